@@ -9,11 +9,23 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/aggregate"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/audit"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/cache"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/clientip"
 	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/discovery"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/fallback"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/geoip"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/graphapi"
 	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/handler"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/middleware/gateway"
 	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/proxy"
 	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/router"
+	"github.com/dhekaag/golang-microservices/shared/pkg/featureflag"
 	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/metrics"
+	"github.com/dhekaag/golang-microservices/shared/pkg/notify"
 	"github.com/joho/godotenv"
 )
 
@@ -22,6 +34,16 @@ func main() {
 		log.Printf("Warning: Error loading .env file: %v", err)
 	}
 	cfg := config.Load()
+	routeTable, err := config.LoadRoutes(cfg.RoutesFile)
+	if err != nil {
+		log.Fatalf("Failed to load route config: %v", err)
+	}
+	cfg.Routes = routeTable.Routes
+	cfg.RateLimit.Rules = routeTable.RateLimits
+	cfg.IPFilter.Rules = routeTable.IPRules
+	cfg.Server.TimeoutRules = routeTable.Timeouts
+	cfg.AccessLog.Rules = routeTable.AccessLog
+
 	bootstrap, err := config.BootStrap(cfg)
 	if err != nil {
 		log.Fatalf("Failed to bootstrap application: %v", err)
@@ -36,34 +58,225 @@ func main() {
 		"order_service", cfg.Services.OrderService,
 	)
 
-	authHandler := handler.NewAuthHandler(&cfg.Services, bootstrap.SessionManager)
-	apiRouter := router.NewRouter(serviceProxy, authHandler, cfg)
+	for name, canary := range cfg.Services.Canary {
+		if len(canary.URLs) > 0 {
+			appLogger.InfoMsg("Canary routing enabled", "service", name, "weight", canary.Weight, "targets", canary.URLs)
+		}
+	}
+
+	for name, shadow := range cfg.Services.Shadow {
+		if shadow.URL != "" {
+			appLogger.InfoMsg("Traffic shadowing enabled", "service", name, "percent", shadow.Percent, "target", shadow.URL)
+		}
+	}
+
+	grpcProxy := proxy.NewGRPCProxy(&cfg.Services)
+	defer grpcProxy.Close()
+
+	responseCache, err := cache.New(cfg.Cache, bootstrap.RedisClient)
+	if err != nil {
+		log.Fatalf("Failed to initialize response cache: %v", err)
+	}
+	if responseCache != nil {
+		appLogger.InfoMsg("Response cache initialized", "backend", cfg.Cache.Backend)
+	}
+
+	// Last-good responses for route fallbacks use the cache backend even when
+	// response caching is off
+	var responseFallback *fallback.Fallback
+	if fallbackStore, err := cache.NewStore(cfg.Cache, bootstrap.RedisClient); err != nil {
+		appLogger.WarnMsg("Fallback responses disabled", "error", err)
+	} else {
+		responseFallback = fallback.New(fallbackStore, cfg.Cache.KeyPrefix+"-fallback", cfg.Cache.MaxBodySize)
+	}
+
+	// Resolve service instances dynamically when a discovery provider is set
+	discoveryCtx, stopDiscovery := context.WithCancel(context.Background())
+	defer stopDiscovery()
+
+	serviceDiscovery, err := discovery.New(cfg.Discovery)
+	if err != nil {
+		log.Fatalf("Failed to initialize service discovery: %v", err)
+	}
+	if serviceDiscovery != nil {
+		for name, registeredName := range cfg.Discovery.ServiceNames {
+			go serviceDiscovery.Watch(discoveryCtx, registeredName, func(addrs []string) {
+				if err := serviceProxy.UpdateTargets(name, addrs); err != nil {
+					appLogger.ErrorMsg("Failed to update service targets", "service", name, "error", err)
+				}
+			})
+		}
+		appLogger.InfoMsg("Service discovery started", "provider", cfg.Discovery.Provider)
+	}
+
+	// Probe upstream instances in the background so routing skips dead ones
+	healthCheckCtx, stopHealthChecks := context.WithCancel(context.Background())
+	defer stopHealthChecks()
+
+	if cfg.Services.HealthCheck.Enabled {
+		go serviceProxy.RunHealthChecks(healthCheckCtx)
+		appLogger.InfoMsg("Upstream health checks started",
+			"interval", cfg.Services.HealthCheck.Interval,
+			"path", cfg.Services.HealthCheck.Path,
+		)
+	}
+
+	auditLog := audit.New(cfg.Audit, bootstrap.RedisClient)
+	if cfg.Audit.Enabled && bootstrap.RedisClient == nil {
+		appLogger.WarnMsg("Audit events are stored in memory; they are lost on restart and not shared between instances")
+	}
+	handler.RegisterSessionHooks(bootstrap.SessionManager, auditLog, metrics.Default())
 
-	appLogger.InfoMsg("API Gateway initialization completed")
+	loginGuard := handler.NewLoginGuard(cfg.Login, bootstrap.RedisClient)
+	if cfg.Login.Enabled && bootstrap.RedisClient == nil {
+		appLogger.WarnMsg("Failed logins are counted in memory; limits apply per instance")
+	}
+
+	authHandler := handler.NewAuthHandler(&cfg.Services, bootstrap.SessionManager, bootstrap.TokenManager, loginGuard, auditLog)
+	if geoResolver := geoip.New(cfg.GeoIP); geoResolver != nil {
+		authHandler.SetGeoResolver(geoResolver)
+	}
+	oauthHandler := handler.NewOAuthHandler(cfg.OAuth, authHandler)
+	magicLinkHandler := handler.NewMagicLinkHandler(cfg.MagicLink, authHandler, notify.New(notify.Config{URL: cfg.Notify.URL, Timeout: cfg.Notify.Timeout}), bootstrap.RedisClient)
+	if cfg.MagicLink.Enabled && cfg.Notify.URL == "" {
+		appLogger.WarnMsg("NOTIFICATION_URL is not set; magic links are logged instead of emailed")
+	}
+
+	guestSessions := handler.NewGuestSessions(cfg, bootstrap.RedisClient)
+	if cfg.Guest.Enabled {
+		authHandler.OnLogin(guestSessions.MergeOnLogin)
+		if bootstrap.RedisClient == nil {
+			appLogger.WarnMsg("Guest sessions are stored in memory; they are lost on restart and not shared between instances")
+		}
+	}
+
+	if cfg.Identity.Secret == "" {
+		appLogger.WarnMsg("IDENTITY_SIGNING_SECRET is not set; services receive an unsigned X-User-ID")
+	}
+
+	ipFilter, err := gateway.NewIPFilter(cfg.IPFilter)
+	if err != nil {
+		log.Fatalf("Failed to initialize IP filter: %v", err)
+	}
+	clientIPs, err := clientip.New(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	webhookVerifier := gateway.NewWebhookVerifier(cfg.Webhooks, bootstrap.RedisClient)
+	for _, route := range cfg.Routes {
+		if route.Webhook != "" && cfg.Webhooks.Providers[route.Webhook].Secret == "" {
+			appLogger.WarnMsg("Webhook secret not configured; requests will be rejected",
+				"provider", route.Webhook,
+				"path_prefix", route.PathPrefix,
+			)
+		}
+	}
+
+	graphqlHandler, err := graphapi.NewHandler(aggregate.New(serviceProxy))
+	if err != nil {
+		log.Fatalf("Failed to initialize GraphQL handler: %v", err)
+	}
+
+	idempotency := gateway.NewIdempotency(cfg.Idempotency, bootstrap.RedisClient)
+	if cfg.Idempotency.Enabled && bootstrap.RedisClient == nil {
+		appLogger.WarnMsg("Idempotency keys are stored in memory; retries must reach the same instance")
+	}
+
+	// Feature flags: FEATURE_FLAG_* variables, overridden by the flags file
+	// and then by Redis, which are reread in the background
+	featureSources := []featureflag.Source{featureflag.EnvSource{}}
+	if cfg.Features.File != "" {
+		featureSources = append(featureSources, featureflag.FileSource{Path: cfg.Features.File})
+	}
+	if cfg.Features.Redis {
+		featureSources = append(featureSources, featureflag.RedisSource{Client: bootstrap.RedisClient, Key: cfg.Features.RedisKey})
+	}
+	featureCtx, stopFeatures := context.WithCancel(context.Background())
+	defer stopFeatures()
+
+	features := featureflag.New(featureCtx, featureflag.Config{
+		Environment:     cfg.Environment,
+		RefreshInterval: cfg.Features.RefreshInterval,
+	}, featureSources...)
+	if len(featureSources) > 1 {
+		go features.Run(featureCtx)
+	}
+
+	drainer := gateway.NewDrainer()
+	apiRouter := router.NewRouter(serviceProxy, grpcProxy, responseCache, responseFallback, authHandler, oauthHandler, magicLinkHandler, guestSessions, ipFilter, clientIPs, webhookVerifier, idempotency, graphqlHandler, drainer, auditLog, features, cfg)
+
+	appLogger.InfoMsg("API Gateway initialization completed", "routes", len(cfg.Routes))
 
 	// Setup HTTP server
 	server := &http.Server{
-		Addr:         ":" + cfg.Server.Port,
-		Handler:      apiRouter.SetupRoutes(),
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
-		IdleTimeout:  120 * time.Second,
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           apiRouter.SetupRoutes(),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       120 * time.Second,
+	}
+
+	// Metrics are served on their own port, kept off the public listener
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("GET /metrics", metrics.Default().Handler())
+	metricsServer := &http.Server{
+		Addr:              ":" + cfg.Server.MetricsPort,
+		Handler:           metricsMux,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+	}
+
+	var redirectServer *http.Server
+	if cfg.Server.TLS.Enabled() {
+		redirectServer, err = configureTLS(server, cfg.Server.TLS, cfg.Server.Port)
+		if err != nil {
+			log.Fatalf("Failed to configure TLS: %v", err)
+		}
 	}
 
 	// Start server in a goroutine
 	go func() {
 		appLogger.InfoMsg("Starting HTTP server",
 			"address", server.Addr,
+			"tls", cfg.Server.TLS.Enabled(),
+			"autocert", cfg.Server.TLS.Autocert,
 			"read_timeout", cfg.Server.ReadTimeout,
+			"read_header_timeout", cfg.Server.ReadHeaderTimeout,
 			"write_timeout", cfg.Server.WriteTimeout,
 		)
 
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if server.TLSConfig != nil {
+			// Certificates come from server.TLSConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			appLogger.ErrorMsg("❌ Failed to start server", "error", err)
 			os.Exit(1)
 		}
 	}()
 
+	go func() {
+		appLogger.InfoMsg("Starting metrics server", "address", metricsServer.Addr)
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			appLogger.ErrorMsg("❌ Failed to start metrics server", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	if redirectServer != nil {
+		go func() {
+			appLogger.InfoMsg("Starting HTTPS redirect server", "address", redirectServer.Addr)
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				appLogger.ErrorMsg("❌ Failed to start HTTPS redirect server", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// Log successful startup with connected services
 	services := []string{
 		cfg.Services.UserService,
@@ -76,12 +289,33 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
+	// Fail readiness and let in-flight requests finish before closing the
+	// listener, so rolling deployments don't drop requests
+	appLogger.InfoMsg("🔄 Draining API Gateway...",
+		"delay", cfg.Server.DrainDelay,
+		"timeout", cfg.Server.DrainTimeout,
+	)
+	drainer.Start()
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+	if err := drainer.Wait(drainCtx, cfg.Server.DrainDelay); err != nil {
+		appLogger.WarnMsg("Drain timeout reached with requests in flight", "in_flight", drainer.InFlight())
+	}
+	cancelDrain()
+
 	appLogger.InfoMsg("🔄 Shutting down API Gateway...")
+	stopDiscovery()
+	stopHealthChecks()
 
 	// Create a deadline for shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	if redirectServer != nil {
+		redirectServer.Shutdown(ctx)
+	}
+	metricsServer.Shutdown(ctx)
+
 	// Attempt graceful shutdown
 	if err := server.Shutdown(ctx); err != nil {
 		appLogger.ErrorMsg("❌ Server forced to shutdown", "error", err)
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/middleware/gateway"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// configureTLS sets up server to serve HTTPS from the configured certificate
+// files or from autocert. It returns the plain HTTP server that redirects to
+// HTTPS (and answers ACME challenges), or nil when no redirect port is set.
+func configureTLS(server *http.Server, cfg config.TLSConfig, httpsPort string) (*http.Server, error) {
+	redirect := gateway.HTTPSRedirect(httpsPort)
+
+	if cfg.Autocert {
+		if len(cfg.AutocertDomains) == 0 {
+			return nil, fmt.Errorf("TLS_AUTOCERT_DOMAINS is required when TLS_AUTOCERT is enabled")
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCache),
+			Email:      cfg.AutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		redirect = manager.HTTPHandler(redirect)
+	} else {
+		if cfg.KeyFile == "" {
+			return nil, fmt.Errorf("TLS_KEY_FILE is required when TLS_CERT_FILE is set")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	server.TLSConfig.MinVersion = tls.VersionTLS12
+
+	if cfg.RedirectPort == "" {
+		return nil, nil
+	}
+	return &http.Server{
+		Addr:         ":" + cfg.RedirectPort,
+		Handler:      redirect,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}, nil
+}
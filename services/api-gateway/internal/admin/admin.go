@@ -0,0 +1,267 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/audit"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/cache"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/handler"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/middleware/gateway"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/proxy"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+)
+
+// PathPrefix is where the admin API is mounted
+const PathPrefix = "/admin/gateway"
+
+// Handler serves the gateway admin API. It must be wrapped in
+// gateway.AdminMiddleware.
+type Handler struct {
+	serviceProxy  *proxy.ServiceProxy
+	responseCache *cache.ResponseCache
+	rateLimiter   *gateway.RouteRateLimiter
+	authHandler   *handler.AuthHandler
+	maintenance   *gateway.Maintenance
+	audit         *audit.Logger
+	config        *config.Config
+	mux           *utils.ServeMux
+}
+
+func NewHandler(
+	serviceProxy *proxy.ServiceProxy,
+	responseCache *cache.ResponseCache,
+	rateLimiter *gateway.RouteRateLimiter,
+	authHandler *handler.AuthHandler,
+	maintenance *gateway.Maintenance,
+	auditLog *audit.Logger,
+	config *config.Config,
+) *Handler {
+	h := &Handler{
+		serviceProxy:  serviceProxy,
+		responseCache: responseCache,
+		rateLimiter:   rateLimiter,
+		authHandler:   authHandler,
+		maintenance:   maintenance,
+		audit:         auditLog,
+		config:        config,
+		mux:           utils.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET "+PathPrefix+"/routes", h.getRoutes)
+	h.mux.HandleFunc("GET "+PathPrefix+"/upstreams", h.getUpstreams)
+	h.mux.HandleFunc("GET "+PathPrefix+"/rate-limits", h.getRateLimits)
+	h.mux.HandleFunc("GET "+PathPrefix+"/sessions", h.getSessions)
+	h.mux.HandleFunc("DELETE "+PathPrefix+"/sessions/remembered", h.revokeRememberedSessions)
+	h.mux.HandleFunc("POST "+PathPrefix+"/cache/flush", h.flushCache)
+	h.mux.HandleFunc("GET "+PathPrefix+"/maintenance", h.getMaintenance)
+	h.mux.HandleFunc("PUT "+PathPrefix+"/maintenance", h.setMaintenance)
+	h.mux.HandleFunc("GET "+PathPrefix+"/audit", h.getAuditEvents)
+
+	logLevels := middleware.LogLevels(logger.Get().Levels())
+	h.mux.Handle("GET "+PathPrefix+"/log-levels", logLevels)
+	h.mux.Handle("PUT "+PathPrefix+"/log-levels", logLevels)
+
+	return h
+}
+
+type MaintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+}
+
+type upstreamStatus struct {
+	Healthy       bool                 `json:"healthy"`
+	Circuit       string               `json:"circuit"`
+	CanaryCircuit string               `json:"canary_circuit,omitempty"`
+	InFlight      int                  `json:"in_flight,omitempty"`
+	MaxInFlight   int                  `json:"max_in_flight,omitempty"`
+	Instances     []proxy.TargetHealth `json:"instances"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) getRoutes(w http.ResponseWriter, r *http.Request) {
+	utils.SendSuccess(w, http.StatusOK, "Routes retrieved successfully", map[string]interface{}{
+		"routes":      h.config.Routes,
+		"rate_limits": h.config.RateLimit.Rules,
+		"ip_rules":    h.config.IPFilter.Rules,
+		"timeouts":    h.config.Server.TimeoutRules,
+	})
+}
+
+func (h *Handler) getUpstreams(w http.ResponseWriter, r *http.Request) {
+	upstreams := make(map[string]upstreamStatus)
+	for _, name := range h.serviceProxy.Services() {
+		status := upstreamStatus{
+			Healthy:   h.serviceProxy.IsServiceHealthy(name),
+			Circuit:   h.serviceProxy.CircuitState(name),
+			Instances: h.serviceProxy.HealthReport(name),
+		}
+		status.InFlight, status.MaxInFlight = h.serviceProxy.Concurrency(name)
+		if canary, ok := h.config.Services.Canary[name]; ok && len(canary.URLs) > 0 {
+			status.CanaryCircuit = h.serviceProxy.CircuitState(name + "-canary")
+		}
+		upstreams[name] = status
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Upstreams retrieved successfully", upstreams)
+}
+
+func (h *Handler) getRateLimits(w http.ResponseWriter, r *http.Request) {
+	utils.SendSuccess(w, http.StatusOK, "Rate limits retrieved successfully", map[string]interface{}{
+		"enabled": h.config.RateLimit.Enabled,
+		"rules":   h.rateLimiter.Stats(),
+	})
+}
+
+func (h *Handler) getSessions(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.authHandler.SessionStats(r.Context())
+	if err != nil {
+		logger.ErrorWithStack(r.Context(), err, "Failed to collect session stats")
+		utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve sessions")
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Session stats retrieved successfully", stats)
+}
+
+// revokeRememberedSessions ends remember-me sessions, of the user in the
+// "user_id" query parameter or of everyone, without touching normal sessions
+func (h *Handler) revokeRememberedSessions(w http.ResponseWriter, r *http.Request) {
+	if h.config.Auth.Mode == config.AuthModeJWT {
+		utils.SendError(w, http.StatusConflict, "Sessions are not stored in JWT mode")
+		return
+	}
+
+	var userID uint
+	if value := r.URL.Query().Get("user_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || id == 0 {
+			utils.SendValidationError(w, appErrors.ValidationErrors{{Field: "user_id", Message: "must be a user ID", Value: value}})
+			return
+		}
+		userID = uint(id)
+	}
+
+	revoked, err := h.authHandler.RevokeRememberedSessions(r.Context(), userID)
+	if err != nil {
+		logger.ErrorWithStack(r.Context(), err, "Failed to revoke remembered sessions", "user_id", userID)
+		utils.SendError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	h.recordAction(r, audit.ActionSessionsRevoked, map[string]any{"remember_me": true, "user_id": userID, "revoked": revoked})
+	utils.SendSuccess(w, http.StatusOK, "Remembered sessions revoked", map[string]int{"revoked": revoked})
+}
+
+// flushCache empties the response cache, or only the entries under the
+// "prefix" query parameter
+func (h *Handler) flushCache(w http.ResponseWriter, r *http.Request) {
+	if h.responseCache == nil {
+		utils.SendError(w, http.StatusConflict, "Response cache is disabled")
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	var err error
+	if prefix != "" {
+		err = h.responseCache.Purge(r.Context(), prefix)
+	} else {
+		err = h.responseCache.Flush(r.Context())
+	}
+	if err != nil {
+		logger.ErrorWithStack(r.Context(), err, "Failed to flush response cache", "prefix", prefix)
+		utils.SendError(w, http.StatusInternalServerError, "Failed to flush response cache")
+		return
+	}
+
+	h.recordAction(r, audit.ActionCacheFlush, map[string]any{"prefix": prefix})
+	utils.SendSuccess(w, http.StatusOK, "Response cache flushed", nil)
+}
+
+func (h *Handler) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	utils.SendSuccess(w, http.StatusOK, "Maintenance mode retrieved successfully", h.maintenance.Status())
+}
+
+func (h *Handler) setMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req MaintenanceRequest
+	if appErr := utils.DecodeJSON(r, &req); appErr != nil {
+		appErrors.WriteErrorResponse(w, appErr)
+		return
+	}
+
+	h.maintenance.Set(req.Enabled, req.Message)
+	h.recordAction(r, audit.ActionMaintenanceMode, map[string]any{"enabled": req.Enabled})
+	utils.SendSuccess(w, http.StatusOK, "Maintenance mode updated", h.maintenance.Status())
+}
+
+// getAuditEvents lists audit events, newest first, filtered by the
+// "action", "actor_id", "target" and "since" (RFC 3339) query parameters
+// and capped by "limit"
+func (h *Handler) getAuditEvents(w http.ResponseWriter, r *http.Request) {
+	if h.audit == nil {
+		utils.SendError(w, http.StatusConflict, "Audit log is disabled")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+	}
+	var validationErrors appErrors.ValidationErrors
+	if value := query.Get("actor_id"); value != "" {
+		actorID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			validationErrors = append(validationErrors, appErrors.ValidationError{Field: "actor_id", Message: "must be a user ID", Value: value})
+		}
+		filter.ActorID = uint(actorID)
+	}
+	if value := query.Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			validationErrors = append(validationErrors, appErrors.ValidationError{Field: "since", Message: "must be an RFC 3339 time", Value: value})
+		}
+		filter.Since = since
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > 1000 {
+			validationErrors = append(validationErrors, appErrors.ValidationError{Field: "limit", Message: "must be between 1 and 1000", Value: value})
+		}
+		filter.Limit = limit
+	}
+	if len(validationErrors) > 0 {
+		utils.SendValidationError(w, validationErrors)
+		return
+	}
+
+	events, err := h.audit.List(r.Context(), filter)
+	if err != nil {
+		logger.ErrorWithStack(r.Context(), err, "Failed to list audit events")
+		utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve audit events")
+		return
+	}
+	if events == nil {
+		events = []audit.Event{}
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Audit events retrieved successfully", events)
+}
+
+// recordAction audits an admin action with the acting user
+func (h *Handler) recordAction(r *http.Request, action string, details map[string]any) {
+	event := audit.Event{Action: action, Details: details}
+	if userSession, ok := gateway.UserSessionFromContext(r.Context()); ok {
+		event.ActorID, event.ActorEmail = userSession.UserID, userSession.Email
+	}
+	h.audit.Record(r, event)
+}
@@ -0,0 +1,189 @@
+package aggregate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/identity"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+)
+
+// Proxy forwards a request to a backend service. *proxy.ServiceProxy
+// satisfies it, so sources get the same breakers, retries and routing as
+// regular proxied routes.
+type Proxy interface {
+	ProxyToService(serviceName string, w http.ResponseWriter, r *http.Request)
+}
+
+// Source is one backend call contributing a field to a composite response
+type Source struct {
+	// Key is the field name in the merged response; "errors" is reserved
+	Key     string
+	Service string
+	// Path builds the upstream path and query for the incoming request.
+	// Returning an empty string skips the source.
+	Path func(r *http.Request) string
+	// Required sources fail the whole response when they fail; others are
+	// reported as null with an entry under "errors"
+	Required bool
+}
+
+// Endpoint is a composite GET endpoint built from several sources
+type Endpoint struct {
+	Sources []Source
+	Timeout time.Duration
+}
+
+type Aggregator struct {
+	proxy Proxy
+}
+
+func New(proxy Proxy) *Aggregator {
+	return &Aggregator{proxy: proxy}
+}
+
+type result struct {
+	data json.RawMessage
+	err  error
+}
+
+// Handler fans out to every source concurrently and merges the results
+func (a *Aggregator) Handler(endpoint Endpoint) http.HandlerFunc {
+	timeout := endpoint.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		results := make([]result, len(endpoint.Sources))
+		var wg sync.WaitGroup
+		for i, source := range endpoint.Sources {
+			path := source.Path(r)
+			if path == "" {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				data, err := a.Fetch(ctx, r, source.Service, path)
+				results[i] = result{data: data, err: err}
+			}()
+		}
+		wg.Wait()
+
+		merged := make(map[string]any, len(endpoint.Sources)+1)
+		failures := make(map[string]string)
+		for i, source := range endpoint.Sources {
+			res := results[i]
+			if res.err != nil {
+				logger.Warn(r.Context(), "Aggregation source failed",
+					"source", source.Key,
+					"service", source.Service,
+					"error", res.err,
+				)
+				if source.Required {
+					utils.SendError(w, http.StatusBadGateway, fmt.Sprintf("Failed to load %s", source.Key))
+					return
+				}
+				failures[source.Key] = res.err.Error()
+			}
+			merged[source.Key] = res.data
+		}
+
+		message := "OK"
+		if len(failures) > 0 {
+			merged["errors"] = failures
+			message = "Partial response"
+		}
+		utils.SendSuccess(w, http.StatusOK, message, merged)
+	}
+}
+
+// Fetch makes a GET request to a service through the proxy and unwraps the
+// standard {"status", "message", "data"} envelope when present. Tracing and
+// user headers are copied from the incoming request.
+func (a *Aggregator) Fetch(ctx context.Context, incoming *http.Request, service, path string) (json.RawMessage, error) {
+	return a.do(ctx, incoming, http.MethodGet, service, path, nil)
+}
+
+// Post is Fetch for lookups that take a JSON body, such as batch endpoints
+func (a *Aggregator) Post(ctx context.Context, incoming *http.Request, service, path string, body any) (json.RawMessage, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	return a.do(ctx, incoming, http.MethodPost, service, path, payload)
+}
+
+func (a *Aggregator) do(ctx context.Context, incoming *http.Request, method, service, path string, payload []byte) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("invalid source path %q: %w", path, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, name := range []string{"X-Request-ID", "X-Correlation-ID", "X-User-ID", identity.Header} {
+		if value := incoming.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+
+	recorder := newBufferedWriter()
+	a.proxy.ProxyToService(service, recorder, req)
+
+	if recorder.statusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("%s service returned status %d", service, recorder.statusCode)
+	}
+
+	body := recorder.body.Bytes()
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		if !json.Valid(body) {
+			return nil, fmt.Errorf("%s service returned invalid JSON", service)
+		}
+	}
+	if envelope.Data != nil {
+		return envelope.Data, nil
+	}
+	return json.RawMessage(body), nil
+}
+
+// bufferedWriter collects a proxied response in memory
+type bufferedWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newBufferedWriter() *bufferedWriter {
+	return &bufferedWriter{header: make(http.Header), statusCode: http.StatusOK}
+}
+
+func (bw *bufferedWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *bufferedWriter) WriteHeader(code int) {
+	bw.statusCode = code
+}
+
+func (bw *bufferedWriter) Write(b []byte) (int, error) {
+	return bw.body.Write(b)
+}
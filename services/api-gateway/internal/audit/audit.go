@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/clientip"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Audited actions
+const (
+	ActionLogin           = "login"
+	ActionLoginLockout    = "login_lockout"
+	ActionLogout          = "logout"
+	ActionSessionsRevoked = "sessions_revoked"
+	ActionSessionRevoked  = "session_revoked"
+	ActionRefreshReuse    = "refresh_token_reuse"
+	ActionNewDeviceLogin  = "new_device_login"
+	ActionUserCreate      = "user_create"
+	ActionUserUpdate      = "user_update"
+	ActionUserDelete      = "user_delete"
+	ActionRoleChange      = "user_role_change"
+	ActionUserDeactivate  = "user_deactivate"
+	ActionUserReactivate  = "user_reactivate"
+	ActionCacheFlush      = "cache_flush"
+	ActionMaintenanceMode = "maintenance_mode"
+)
+
+// Outcomes of an audited action
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event records who did what. ActorID is empty when the actor is not
+// authenticated, e.g. for failed logins, where ActorEmail holds the email
+// that was tried.
+type Event struct {
+	ID         string         `json:"id"`
+	Time       time.Time      `json:"time"`
+	Action     string         `json:"action"`
+	Outcome    string         `json:"outcome"`
+	ActorID    uint           `json:"actor_id,omitempty"`
+	ActorEmail string         `json:"actor_email,omitempty"`
+	Target     string         `json:"target,omitempty"`
+	IP         string         `json:"ip"`
+	RequestID  string         `json:"request_id,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+}
+
+// Filter selects events; zero fields match everything. Events are returned
+// newest first, at most Limit of them.
+type Filter struct {
+	Action  string
+	ActorID uint
+	Target  string
+	Since   time.Time
+	Limit   int
+}
+
+func (f Filter) matches(event *Event) bool {
+	return (f.Action == "" || event.Action == f.Action) &&
+		(f.ActorID == 0 || event.ActorID == f.ActorID) &&
+		(f.Target == "" || event.Target == f.Target) &&
+		(f.Since.IsZero() || !event.Time.Before(f.Since))
+}
+
+// Logger persists audit events and writes them to the application log.
+// A nil Logger only writes to the application log.
+type Logger struct {
+	store Store
+}
+
+// New keeps the last MaxEvents events in Redis when redisClient is set, so
+// every gateway instance sees the same trail, and in memory otherwise.
+// It returns nil when auditing is disabled.
+func New(cfg config.AuditConfig, redisClient redis.UniversalClient) *Logger {
+	if !cfg.Enabled {
+		return nil
+	}
+	if redisClient != nil {
+		return &Logger{store: NewRedisStore(redisClient, cfg.RedisKey, cfg.MaxEvents)}
+	}
+	return &Logger{store: NewMemoryStore(cfg.MaxEvents)}
+}
+
+// Record completes event with the time, client IP and request ID of r and
+// stores it. Storage failures are logged, never returned: an action is not
+// undone because its audit record could not be written.
+func (l *Logger) Record(r *http.Request, event Event) {
+	event.IP = clientIP(r)
+	event.RequestID = logger.GetRequestID(r.Context())
+	if event.RequestID == "" {
+		event.RequestID = r.Header.Get("X-Request-ID")
+	}
+	l.RecordContext(r.Context(), event)
+}
+
+// RecordContext records an event where the request is out of reach, e.g.
+// in a session hook. The caller sets event.IP; the request ID is taken
+// from ctx.
+func (l *Logger) RecordContext(ctx context.Context, event Event) {
+	event.ID = uuid.NewString()
+	event.Time = time.Now().UTC()
+	if event.RequestID == "" {
+		event.RequestID = logger.GetRequestID(ctx)
+	}
+	if event.Outcome == "" {
+		event.Outcome = OutcomeSuccess
+	}
+
+	logger.Info(ctx, "Audit event",
+		"audit", true,
+		"action", event.Action,
+		"outcome", event.Outcome,
+		"actor_id", event.ActorID,
+		"actor_email", event.ActorEmail,
+		"target", event.Target,
+		"ip", event.IP,
+	)
+
+	if l == nil {
+		return
+	}
+	if err := l.store.Append(context.WithoutCancel(ctx), &event); err != nil {
+		logger.ErrorWithStack(ctx, err, "Failed to store audit event", "action", event.Action)
+	}
+}
+
+// List returns the stored events matching filter
+func (l *Logger) List(ctx context.Context, filter Filter) ([]Event, error) {
+	return l.store.List(ctx, filter)
+}
+
+func clientIP(r *http.Request) string {
+	return clientip.FromRequest(r)
+}
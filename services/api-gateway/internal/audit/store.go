@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultListLimit = 100
+	// listPageSize is how many events RedisStore.List reads per round trip
+	listPageSize = 500
+)
+
+// Store persists audit events, keeping the newest ones. Implementations must
+// be safe for concurrent use.
+type Store interface {
+	Append(ctx context.Context, event *Event) error
+	List(ctx context.Context, filter Filter) ([]Event, error)
+}
+
+// MemoryStore keeps the last maxEvents events in process memory
+type MemoryStore struct {
+	events    []Event
+	maxEvents int
+	mutex     sync.RWMutex
+}
+
+func NewMemoryStore(maxEvents int) *MemoryStore {
+	if maxEvents <= 0 {
+		maxEvents = 10000
+	}
+	return &MemoryStore{maxEvents: maxEvents}
+}
+
+func (s *MemoryStore) Append(ctx context.Context, event *Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.events = append(s.events, *event)
+	if len(s.events) > s.maxEvents {
+		s.events = s.events[len(s.events)-s.maxEvents:]
+	}
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, filter Filter) ([]Event, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	limit := listLimit(filter)
+	var events []Event
+	for i := len(s.events) - 1; i >= 0 && len(events) < limit; i-- {
+		if filter.matches(&s.events[i]) {
+			events = append(events, s.events[i])
+		}
+	}
+	return events, nil
+}
+
+// RedisStore keeps the last maxEvents events in a Redis list shared by all
+// gateway instances, newest first
+type RedisStore struct {
+	client    redis.UniversalClient
+	key       string
+	maxEvents int
+}
+
+func NewRedisStore(client redis.UniversalClient, key string, maxEvents int) *RedisStore {
+	if maxEvents <= 0 {
+		maxEvents = 10000
+	}
+	return &RedisStore{client: client, key: key, maxEvents: maxEvents}
+}
+
+func (s *RedisStore) Append(ctx context.Context, event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %w", err)
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.LPush(ctx, s.key, data)
+	pipe.LTrim(ctx, s.key, 0, int64(s.maxEvents-1))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store audit event: %w", err)
+	}
+	return nil
+}
+
+// List reads the events a page at a time, newest first, and stops once
+// enough match, so a selective filter does not load the whole list at once.
+// Events appended meanwhile shift the list, so events already read can show
+// up again on the next page and are skipped.
+func (s *RedisStore) List(ctx context.Context, filter Filter) ([]Event, error) {
+	limit := listLimit(filter)
+	var events []Event
+	seen := make(map[string]bool)
+	for start := int64(0); start < int64(s.maxEvents) && len(events) < limit; start += listPageSize {
+		values, err := s.client.LRange(ctx, s.key, start, start+listPageSize-1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list audit events: %w", err)
+		}
+
+		for _, value := range values {
+			if len(events) >= limit {
+				break
+			}
+			var event Event
+			if err := json.Unmarshal([]byte(value), &event); err != nil {
+				continue
+			}
+			if event.ID != "" {
+				if seen[event.ID] {
+					continue
+				}
+				seen[event.ID] = true
+			}
+			if filter.matches(&event) {
+				events = append(events, event)
+			}
+		}
+		if len(values) < listPageSize {
+			break
+		}
+	}
+	return events, nil
+}
+
+func listLimit(filter Filter) int {
+	if filter.Limit <= 0 {
+		return defaultListLimit
+	}
+	return filter.Limit
+}
@@ -0,0 +1,257 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// Headers that must never be replayed from the cache
+var uncachedHeaders = slices.Concat([]string{"Set-Cookie", "X-Request-Id", "X-Correlation-Id"}, appErrors.RateLimitHeaders)
+
+// ResponseCache caches upstream GET responses. Entries are keyed by path,
+// query and auth state, and honor the upstream Cache-Control header.
+type ResponseCache struct {
+	store       Store
+	keyPrefix   string
+	maxBodySize int64
+}
+
+// New creates the response cache for the configured backend, or returns nil
+// when caching is disabled.
+func New(cfg config.CacheConfig, redisClient redis.UniversalClient) (*ResponseCache, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	store, err := NewStore(cfg, redisClient)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBodySize := cfg.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = 1 << 20
+	}
+
+	return &ResponseCache{
+		store:       store,
+		keyPrefix:   cfg.KeyPrefix + ":",
+		maxBodySize: maxBodySize,
+	}, nil
+}
+
+// NewStore creates the store for the configured cache backend
+func NewStore(cfg config.CacheConfig, redisClient redis.UniversalClient) (Store, error) {
+	switch strings.ToLower(cfg.Backend) {
+	case "", "memory":
+		return NewMemoryStore(cfg.MaxEntries), nil
+	case "redis":
+		if redisClient == nil {
+			return nil, fmt.Errorf("redis cache backend requires a redis client")
+		}
+		return NewRedisStore(redisClient), nil
+	default:
+		return nil, fmt.Errorf("unsupported cache backend: %s", cfg.Backend)
+	}
+}
+
+// Serve answers GET/HEAD requests from the cache when possible. On a miss it
+// calls next and stores the response if it is cacheable. authState separates
+// anonymous from per-session entries.
+func (c *ResponseCache) Serve(w http.ResponseWriter, r *http.Request, authState string, defaultTTL time.Duration, next http.HandlerFunc) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || requestBypassesCache(r) {
+		next(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	envelope, _ := appErrors.ParseEnvelope(r.Header.Get(appErrors.EnvelopeHeader))
+	key := c.key(r.URL.Path, r.URL.RawQuery, authState, string(envelope))
+
+	entry, found, err := c.store.Get(ctx, key)
+	if err != nil {
+		logger.Warn(ctx, "Response cache lookup failed", "error", err)
+	}
+	if found {
+		writeEntry(w, r, entry)
+		return
+	}
+
+	w.Header().Set("X-Cache", "MISS")
+	recorder := &captureWriter{ResponseWriter: w, statusCode: http.StatusOK, limit: c.maxBodySize}
+	next(recorder, r)
+
+	if recorder.statusCode != http.StatusOK || recorder.overflow || r.Method != http.MethodGet {
+		return
+	}
+
+	ttl, cacheable := cacheTTL(w.Header().Get("Cache-Control"), authState, defaultTTL)
+	if !cacheable {
+		return
+	}
+
+	header := w.Header().Clone()
+	for _, name := range uncachedHeaders {
+		header.Del(name)
+	}
+	header.Del("X-Cache")
+
+	entry = &Entry{
+		StatusCode: recorder.statusCode,
+		Header:     header,
+		Body:       recorder.body.Bytes(),
+		StoredAt:   time.Now(),
+	}
+	if err := c.store.Set(context.WithoutCancel(ctx), key, entry, ttl); err != nil {
+		logger.Warn(ctx, "Failed to store response in cache", "error", err)
+	}
+}
+
+// InvalidateOnWrite calls next and, if the write succeeded, purges every
+// entry under the given path prefixes.
+func (c *ResponseCache) InvalidateOnWrite(w http.ResponseWriter, r *http.Request, pathPrefixes []string, next http.HandlerFunc) {
+	recorder := &captureWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	next(recorder, r)
+
+	if recorder.statusCode >= http.StatusBadRequest {
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	for _, prefix := range pathPrefixes {
+		if err := c.Purge(ctx, prefix); err != nil {
+			logger.Warn(ctx, "Failed to purge response cache", "prefix", prefix, "error", err)
+		}
+	}
+}
+
+// Purge removes every cached entry under pathPrefix for all auth states
+func (c *ResponseCache) Purge(ctx context.Context, pathPrefix string) error {
+	return c.store.DeletePrefix(ctx, c.keyPrefix+pathPrefix)
+}
+
+// Flush removes every entry from the cache
+func (c *ResponseCache) Flush(ctx context.Context) error {
+	return c.store.DeletePrefix(ctx, c.keyPrefix)
+}
+
+// key separates entries by auth state and by the envelope the client asked
+// for, which changes the body
+func (c *ResponseCache) key(path, rawQuery, authState, envelope string) string {
+	return c.keyPrefix + path + "?" + rawQuery + "|" + authState + "|" + envelope
+}
+
+// AuthState derives the cache partition for a request: "anon" for anonymous
+// clients, otherwise a hash of the session credential.
+func AuthState(sessionID string) string {
+	if sessionID == "" {
+		return "anon"
+	}
+	sum := sha256.Sum256([]byte(sessionID))
+	return "s:" + hex.EncodeToString(sum[:8])
+}
+
+func requestBypassesCache(r *http.Request) bool {
+	cacheControl := strings.ToLower(r.Header.Get("Cache-Control"))
+	return strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "no-store")
+}
+
+// cacheTTL applies the upstream Cache-Control directives. Without directives
+// the route's default TTL is used.
+func cacheTTL(cacheControl, authState string, defaultTTL time.Duration) (time.Duration, bool) {
+	ttl := defaultTTL
+	var maxAge, sharedMaxAge time.Duration = -1, -1
+
+	for _, directive := range strings.Split(strings.ToLower(cacheControl), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch name {
+		case "no-store", "no-cache":
+			return 0, false
+		case "private":
+			// Only cache private responses in a per-session partition
+			if authState == "anon" {
+				return 0, false
+			}
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		case "s-maxage":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				sharedMaxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	switch {
+	case sharedMaxAge >= 0:
+		ttl = sharedMaxAge
+	case maxAge >= 0:
+		ttl = maxAge
+	}
+
+	return ttl, ttl > 0
+}
+
+func writeEntry(w http.ResponseWriter, r *http.Request, entry *Entry) {
+	header := w.Header()
+	for name, values := range entry.Header {
+		header[name] = values
+	}
+	header.Set("X-Cache", "HIT")
+	header.Set("Age", strconv.Itoa(int(time.Since(entry.StoredAt).Seconds())))
+
+	w.WriteHeader(entry.StatusCode)
+	if r.Method != http.MethodHead {
+		w.Write(entry.Body)
+	}
+}
+
+// captureWriter tees the response to the client while buffering it for the
+// cache, giving up on buffering once limit is exceeded.
+type captureWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+	limit       int64
+	overflow    bool
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if !cw.wroteHeader {
+		cw.statusCode = code
+		cw.wroteHeader = true
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	cw.wroteHeader = true
+	if !cw.overflow {
+		if int64(cw.body.Len()+len(b)) > cw.limit {
+			cw.overflow = true
+			cw.body.Reset()
+		} else {
+			cw.body.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryItem struct {
+	entry     *Entry
+	expiresAt time.Time
+}
+
+// MemoryStore keeps entries in process memory, bounded by maxEntries
+type MemoryStore struct {
+	items      map[string]memoryItem
+	maxEntries int
+	mutex      sync.RWMutex
+}
+
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	return &MemoryStore{
+		items:      make(map[string]memoryItem),
+		maxEntries: maxEntries,
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (*Entry, bool, error) {
+	s.mutex.RLock()
+	item, exists := s.items[key]
+	s.mutex.RUnlock()
+
+	if !exists {
+		return nil, false, nil
+	}
+	if time.Now().After(item.expiresAt) {
+		s.mutex.Lock()
+		delete(s.items, key)
+		s.mutex.Unlock()
+		return nil, false, nil
+	}
+	return item.entry, true, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.items[key]; !exists && len(s.items) >= s.maxEntries {
+		s.evict()
+	}
+
+	s.items[key] = memoryItem{
+		entry:     entry,
+		expiresAt: time.Now().Add(ttl),
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeletePrefix(ctx context.Context, prefix string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key := range s.items {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.items, key)
+		}
+	}
+	return nil
+}
+
+// evict drops expired entries, or the oldest entry when none have expired.
+// Must be called with the mutex held.
+func (s *MemoryStore) evict() {
+	now := time.Now()
+	var (
+		oldestKey string
+		oldest    time.Time
+	)
+
+	for key, item := range s.items {
+		if now.After(item.expiresAt) {
+			delete(s.items, key)
+			continue
+		}
+		if oldestKey == "" || item.entry.StoredAt.Before(oldest) {
+			oldestKey = key
+			oldest = item.entry.StoredAt
+		}
+	}
+
+	if len(s.items) >= s.maxEntries && oldestKey != "" {
+		delete(s.items, oldestKey)
+	}
+}
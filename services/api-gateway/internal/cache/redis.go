@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore shares cached responses between gateway instances
+type RedisStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (*Entry, bool, error) {
+	data, err := s.client.Get(ctx, key).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to get cache entry: %w", err)
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal cache entry: %w", err)
+	}
+	return &entry, true, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %w", err)
+	}
+	if err := s.client.Set(ctx, key, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set cache entry: %w", err)
+	}
+	return nil
+}
+
+// DeletePrefix scans every master of a Redis Cluster, where SCAN only
+// covers the node it is sent to
+func (s *RedisStore) DeletePrefix(ctx context.Context, prefix string) error {
+	if cluster, ok := s.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return s.deletePrefix(ctx, node, prefix)
+		})
+	}
+	return s.deletePrefix(ctx, s.client, prefix)
+}
+
+// deletePrefix deletes the entries under prefix that node holds
+func (s *RedisStore) deletePrefix(ctx context.Context, node redis.UniversalClient, prefix string) error {
+	iter := node.Scan(ctx, 0, escapeGlob(prefix)+"*", 500).Iterator()
+
+	var batch []string
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) >= 500 {
+			if err := s.unlink(ctx, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan cache entries: %w", err)
+	}
+
+	if len(batch) > 0 {
+		return s.unlink(ctx, batch)
+	}
+	return nil
+}
+
+// unlink deletes keys with one UNLINK, or in a cluster, where they span
+// hash slots, with one per key
+func (s *RedisStore) unlink(ctx context.Context, keys []string) error {
+	var err error
+	if _, ok := s.client.(*redis.ClusterClient); ok {
+		pipe := s.client.Pipeline()
+		for _, key := range keys {
+			pipe.Unlink(ctx, key)
+		}
+		_, err = pipe.Exec(ctx)
+	} else {
+		err = s.client.Unlink(ctx, keys...).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to purge cache entries: %w", err)
+	}
+	return nil
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// escapeGlob escapes Redis MATCH pattern metacharacters
+func escapeGlob(s string) string {
+	return globEscaper.Replace(s)
+}
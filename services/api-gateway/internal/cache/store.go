@@ -0,0 +1,23 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Entry is a cached upstream response
+type Entry struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	StoredAt   time.Time   `json:"stored_at"`
+}
+
+// Store persists cache entries. Implementations must be safe for concurrent use.
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, bool, error)
+	Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error
+	// DeletePrefix removes every entry whose key starts with prefix
+	DeletePrefix(ctx context.Context, prefix string) error
+}
@@ -0,0 +1,104 @@
+// Package clientip resolves the address of the client behind a request.
+// Forwarded headers are only believed when the request comes from a trusted
+// proxy, so clients cannot pick the address they are limited, locked out or
+// logged by.
+package clientip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+)
+
+type contextKey struct{}
+
+// Resolver finds client addresses behind the trusted proxies
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// New trusts the proxies in trustedProxies, CIDR ranges or single IPs. With
+// none, forwarded headers are ignored and the peer address is the client.
+func New(trustedProxies []string) (*Resolver, error) {
+	trusted, err := config.ParseIPRanges(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{trusted: trusted}, nil
+}
+
+// Resolve returns the client address of r. When r comes from a trusted
+// proxy, X-Forwarded-For is read from the right, skipping trusted proxies,
+// and the first other address is the client's; entries to its left were
+// sent by the client and are ignored. Without X-Forwarded-For, the proxy's
+// X-Real-IP is used.
+func (res *Resolver) Resolve(r *http.Request) string {
+	client := remoteHost(r.RemoteAddr)
+	if !res.trusts(client) {
+		return client
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			client = hop
+			if !res.trusts(hop) {
+				break
+			}
+		}
+		return client
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	return client
+}
+
+func (res *Resolver) trusts(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(res.trusted, func(prefix netip.Prefix) bool {
+		return prefix.Contains(addr)
+	})
+}
+
+// Middleware resolves the client address of every request once, for
+// FromRequest
+func Middleware(resolver *Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), contextKey{}, resolver.Resolve(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// FromRequest returns the client address resolved by Middleware, or the
+// peer address of r when it did not run
+func FromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value(contextKey{}).(string); ok {
+		return ip
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+// remoteHost strips the port from a peer address
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -2,10 +2,14 @@ package config
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
 	"github.com/dhekaag/golang-microservices/shared/pkg/session"
+	"github.com/dhekaag/golang-microservices/shared/pkg/token"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+	"github.com/dhekaag/golang-microservices/shared/pkg/validation"
 	"github.com/go-playground/validator/v10"
 	"github.com/redis/go-redis/v9"
 )
@@ -14,63 +18,138 @@ type BootstrapConfig struct {
 	App            *Config
 	Log            *logger.Logger
 	Validate       *validator.Validate
-	RedisClient    *redis.Client
+	RedisClient    redis.UniversalClient
 	SessionManager *session.SessionManager
+	TokenManager   *token.Manager
+
+	shutdownTracing func(context.Context) error
 }
 
 func BootStrap(config *Config) (*BootstrapConfig, error) {
-	loggerInstance, err := logger.Init(logger.Config{
-		Level:       "info",
-		Format:      "text",
-		ServiceName: "api-gateway",
-		Environment: "development",
-	})
+	loggerInstance, err := logger.Init(config.Log)
 	if err != nil {
 		return nil, err
 	}
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     config.Session.RedisAddr,
-		Password: config.Session.RedisPassword,
-		DB:       config.Session.RedisDB,
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Enabled:     config.Tracing.Enabled,
+		ServiceName: "api-gateway",
+		Environment: config.Environment,
+		SampleRatio: config.Tracing.SampleRatio,
 	})
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		loggerInstance.ErrorMsg("❌ Failed to connect to Redis", "error", err)
+	if err != nil {
+		loggerInstance.ErrorMsg("❌ Failed to initialize tracing", "error", err)
 		return nil, err
 	}
 
+	bootstrap := &BootstrapConfig{
+		App:             config,
+		Log:             loggerInstance,
+		shutdownTracing: shutdownTracing,
+	}
+
+	if config.Auth.Mode != AuthModeSession && config.Auth.Mode != AuthModeJWT {
+		return nil, fmt.Errorf("unsupported auth mode: %s", config.Auth.Mode)
+	}
+	if config.Session.Store != SessionStoreRedis && config.Session.Store != SessionStoreMemory && config.Session.Store != SessionStoreJWT {
+		return nil, fmt.Errorf("unsupported session store: %s", config.Session.Store)
+	}
+
+	jwtDenylistInRedis := config.Auth.Mode == AuthModeJWT && config.Auth.DenylistBackend == "redis"
+	if config.Auth.Mode == AuthModeJWT && !jwtDenylistInRedis && config.Auth.DenylistBackend != "memory" {
+		return nil, fmt.Errorf("unsupported JWT denylist backend: %s", config.Auth.DenylistBackend)
+	}
+
+	// Redis is only required for sessions kept in Redis, including the
+	// revocations of the jwt store, used refresh tokens of JWT mode kept in
+	// Redis, the redis cache backend and feature flags kept in Redis. One
+	// client, to a server, Sentinel master or cluster, then serves sessions
+	// and every other feature, so none of them quietly falls back to memory.
 	sessionConfig := session.SessionConfig{
-		RedisAddr:     config.Session.RedisAddr,
-		RedisPassword: config.Session.RedisPassword,
-		RedisDB:       config.Session.RedisDB,
-		SessionTTL:    int(config.Session.SessionTTL.Seconds()),
-		SessionPrefix: config.Session.SessionPrefix,
+		RedisAddr:        config.Session.RedisAddr,
+		RedisPassword:    config.Session.RedisPassword,
+		RedisDB:          config.Session.RedisDB,
+		SentinelMaster:   config.Session.SentinelMaster,
+		SentinelAddrs:    config.Session.SentinelAddrs,
+		SentinelPassword: config.Session.SentinelPassword,
+		ClusterAddrs:     config.Session.ClusterAddrs,
+		MaxRetries:       config.Session.MaxRetries,
+		MaxRetryBackoff:  config.Session.MaxRetryBackoff,
+	}
+	sessionsInRedis := config.Auth.Mode == AuthModeSession && config.Session.Store != SessionStoreMemory
+	if sessionsInRedis || jwtDenylistInRedis || (config.Cache.Enabled && config.Cache.Backend == "redis") || config.Features.Redis {
+		redisClient := session.NewRedisClient(sessionConfig)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := redisClient.Ping(ctx).Err(); err != nil {
+			redisClient.Close()
+			loggerInstance.ErrorMsg("❌ Failed to connect to Redis", "error", err)
+			return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+		redisClient.AddHook(tracing.RedisHook())
+		bootstrap.RedisClient = redisClient
 	}
 
-	sessionManager, err := session.NewSessionManager(sessionConfig)
-	if err != nil {
-		loggerInstance.ErrorMsg("❌ Failed to initialize session manager", "error", err)
-		return nil, err
+	if config.Auth.Mode == AuthModeJWT {
+		var denylist token.Denylist
+		if jwtDenylistInRedis {
+			denylist = token.NewRedisDenylist(bootstrap.RedisClient, "jwt-refresh-used")
+		} else {
+			loggerInstance.WarnMsg("Used refresh tokens are remembered in memory; each instance accepts a token once")
+		}
+		tokenManager, err := token.NewManager(token.Config{
+			Secret:          config.Auth.JWTSecret,
+			Issuer:          config.Auth.JWTIssuer,
+			AccessTokenTTL:  config.Auth.AccessTokenTTL,
+			RefreshTokenTTL: config.Auth.RefreshTokenTTL,
+			Denylist:        denylist,
+		})
+		if err != nil {
+			loggerInstance.ErrorMsg("❌ Failed to initialize token manager", "error", err)
+			return nil, err
+		}
+		bootstrap.TokenManager = tokenManager
+	}
+
+	if config.Auth.Mode == AuthModeSession {
+		sessionConfig.SessionTTL = int(config.Session.SessionTTL.Seconds())
+		sessionConfig.SessionPrefix = config.Session.SessionPrefix
+		sessionConfig.RefreshTTL = int(config.Session.RefreshTTL.Seconds())
+		sessionConfig.RememberTTL = int(config.Session.RememberTTL.Seconds())
+		sessionConfig.RememberIdleTTL = int(config.Session.RememberIdleTTL.Seconds())
+		sessionConfig.EncryptionKeys = config.Session.EncryptionKeys
+		sessionConfig.MaxSessions = config.Session.MaxPerUser
+		sessionConfig.LimitPolicy = config.Session.LimitPolicy
+		sessionConfig.LastSeenInterval = int(config.Session.LastSeenInterval.Seconds())
+		sessionConfig.Client = bootstrap.RedisClient
+		switch config.Session.Store {
+		case SessionStoreMemory:
+			sessionConfig.Store = session.NewMemoryStore()
+			loggerInstance.WarnMsg("Sessions are stored in memory; they are lost on restart and not shared between instances")
+		case SessionStoreJWT:
+			sessionConfig.JWTSecret = config.Session.JWTSecret
+		}
+
+		sessionManager, err := session.NewSessionManager(sessionConfig)
+		if err != nil {
+			loggerInstance.ErrorMsg("❌ Failed to initialize session manager", "error", err)
+			return nil, err
+		}
+		bootstrap.SessionManager = sessionManager
 	}
 
 	// Initialize validator
-	validator := validator.New()
+	bootstrap.Validate = validation.New()
 
-	loggerInstance.InfoMsg("Core bootstrap completed successfully")
+	loggerInstance.InfoMsg("Core bootstrap completed successfully", "auth_mode", config.Auth.Mode)
 
-	return &BootstrapConfig{
-		App:            config,
-		Log:            loggerInstance,
-		Validate:       validator,
-		RedisClient:    redisClient,
-		SessionManager: sessionManager,
-	}, nil
+	return bootstrap, nil
 }
 
 // Cleanup method for graceful shutdown
 func (bc *BootstrapConfig) Cleanup() error {
+	// Close the log files last
+	defer bc.Log.Close()
 
 	// Close Redis client
 	if bc.RedisClient != nil {
@@ -90,5 +169,15 @@ func (bc *BootstrapConfig) Cleanup() error {
 		bc.Log.InfoMsg("Session manager closed")
 	}
 
+	// Flush buffered spans
+	if bc.shutdownTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := bc.shutdownTracing(ctx); err != nil {
+			bc.Log.ErrorMsg("❌ Failed to flush traces", "error", err)
+			return err
+		}
+	}
+
 	return nil
 }
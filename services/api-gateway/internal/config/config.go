@@ -2,68 +2,716 @@ package config
 
 import (
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
 )
 
 type Config struct {
-	Server    ServerConfig
-	Services  ServicesConfig
-	RateLimit RateLimitConfig
-	Session   SessionConfig
+	Server      ServerConfig
+	Services    ServicesConfig
+	RateLimit   RateLimitConfig
+	Idempotency IdempotencyConfig
+	IPFilter    IPFilterConfig
+	Session     SessionConfig
+	Auth        AuthConfig
+	OAuth       OAuthConfig
+	MagicLink   MagicLinkConfig
+	Notify      NotificationConfig
+	GeoIP       GeoIPConfig
+	Identity    IdentityConfig
+	Guest       GuestConfig
+	Webhooks    WebhookConfig
+	Discovery   DiscoveryConfig
+	Cache       CacheConfig
+	Docs        DocsConfig
+	APIVersions APIVersionConfig
+	Tracing     TracingConfig
+	// Environment names the deployment, such as development or production,
+	// in logs and traces
+	Environment string
+	// Log configures the logger: level, format, component levels, files and
+	// export
+	Log       logger.Config
+	AccessLog AccessLogConfig
+	Audit     AuditConfig
+	Login     LoginProtectionConfig
+	Features  FeatureFlagConfig
+	// RoutesFile points at a JSON route table; empty uses the built-in routes
+	RoutesFile string
+	Routes     []RouteConfig
 }
 
 type ServerConfig struct {
-	Port           string
+	Port string
+	// MetricsPort serves /metrics apart from Port, so that only the
+	// internal network can scrape it
+	MetricsPort    string
 	RequestTimeout time.Duration
 	ReadTimeout    time.Duration
 	WriteTimeout   time.Duration
+	// ReadHeaderTimeout bounds reading request headers, so slow clients
+	// cannot hold connections open
+	ReadHeaderTimeout time.Duration
+	// MaxBodySize limits regular (JSON) request bodies; MaxUploadSize applies
+	// to multipart bodies and the upload routes
+	MaxBodySize   int64
+	MaxUploadSize int64
+	// DrainDelay is how long /health/ready fails before the gateway waits
+	// for in-flight requests; DrainTimeout bounds the whole drain
+	DrainDelay   time.Duration
+	DrainTimeout time.Duration
+	// TimeoutRules override RequestTimeout and bound upstream calls per
+	// path prefix; they come from the routes config file
+	TimeoutRules []TimeoutRule
+	// TrustedProxies are the proxies, as CIDR ranges or IPs, whose
+	// X-Forwarded-For and X-Real-IP headers name the client; forwarded
+	// headers from anyone else are ignored
+	TrustedProxies []string
+	TLS            TLSConfig
+}
+
+// TLSConfig makes the gateway serve HTTPS on Port, either from CertFile and
+// KeyFile or with certificates obtained through ACME (Let's Encrypt) for
+// AutocertDomains. RedirectPort, when set, serves plain HTTP that redirects
+// to HTTPS and answers ACME HTTP-01 challenges.
+type TLSConfig struct {
+	CertFile        string
+	KeyFile         string
+	Autocert        bool
+	AutocertDomains []string
+	AutocertEmail   string
+	AutocertCache   string
+	RedirectPort    string
+	// HSTSMaxAge is sent in Strict-Transport-Security; 0 omits the header
+	HSTSMaxAge time.Duration
+}
+
+// Enabled reports whether the gateway terminates TLS itself
+func (c TLSConfig) Enabled() bool {
+	return c.Autocert || c.CertFile != ""
 }
 
+// Upstream HTTP protocols. "auto" speaks HTTP/1.1 to plaintext instances
+// and negotiates HTTP/2 over TLS; "h2c" speaks HTTP/2 without TLS.
+const (
+	UpstreamProtocolAuto  = "auto"
+	UpstreamProtocolHTTP1 = "http1"
+	UpstreamProtocolHTTP2 = "http2"
+	UpstreamProtocolH2C   = "h2c"
+)
+
 type ServicesConfig struct {
 	UserService    string
 	ProductService string
 	OrderService   string
+	CircuitBreaker CircuitBreakerConfig
+	Retry          map[string]RetryConfig
+	// Protocol is the HTTP version spoken to each service's instances, one
+	// of the UpstreamProtocol* values
+	Protocol map[string]string
+	// Sticky routes each user to the same instance of a service instead of
+	// round-robin
+	Sticky map[string]bool
+	// MaxConcurrent caps in-flight requests per service; 0 means unlimited
+	MaxConcurrent map[string]int
+	// GRPC holds gRPC addresses (host:port) for services that expose one
+	GRPC map[string]string
+	// Canary holds optional canary instances per service
+	Canary map[string]CanaryConfig
+	// VersionURLs holds per-version upstreams, by service and then API
+	// version, for versions that do not use the default instances
+	VersionURLs map[string]map[string]string
+	// Shadow holds optional shadow upstreams that receive mirrored traffic
+	Shadow      map[string]ShadowConfig
+	HealthCheck HealthCheckConfig
+}
+
+// HealthCheckConfig controls background probing of upstream instances. An
+// instance is taken out of rotation after UnhealthyThreshold consecutive
+// failed probes and put back after HealthyThreshold successful ones.
+type HealthCheckConfig struct {
+	Enabled            bool
+	Interval           time.Duration
+	Timeout            time.Duration
+	Path               string
+	UnhealthyThreshold int
+	HealthyThreshold   int
+}
+
+// ShadowConfig mirrors Percent of a service's requests to URL and discards
+// the responses. Only GET/HEAD requests are mirrored unless MirrorWrites is set.
+type ShadowConfig struct {
+	URL          string
+	Percent      int
+	MirrorWrites bool
+	Timeout      time.Duration
+}
+
+// CanaryConfig sends Weight percent of a service's traffic to URLs. Clients
+// can force a version with Header or Cookie set to "always" or "never".
+type CanaryConfig struct {
+	URLs   []string
+	Weight int
+	Header string
+	Cookie string
+}
+
+// RetryConfig controls retries of idempotent requests to a backend service
+type RetryConfig struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
 }
 
+type CircuitBreakerConfig struct {
+	Enabled             bool
+	FailureThreshold    int
+	SuccessThreshold    int
+	OpenTimeout         time.Duration
+	HalfOpenMaxRequests int
+}
+
+// DiscoveryConfig selects where backend addresses come from. With the
+// "static" provider the *_SERVICE_URL variables are used as-is.
+type DiscoveryConfig struct {
+	Provider     string
+	ConsulAddr   string
+	ConsulToken  string
+	Datacenter   string
+	WatchWait    time.Duration
+	ServiceNames map[string]string
+}
+
+// CacheConfig controls the gateway response cache. Backend is "memory" or
+// "redis"; caching only applies to routes that declare a cache_ttl.
+type CacheConfig struct {
+	Enabled     bool
+	Backend     string
+	KeyPrefix   string
+	MaxEntries  int
+	MaxBodySize int64
+}
+
+// RateLimitConfig holds the fallback limits for requests that match no
+// per-route rule; 0 disables the fallback for that client class.
 type RateLimitConfig struct {
-	RequestsPerMinute int
-	WindowSize        time.Duration
+	Enabled                        bool
+	RequestsPerMinute              int
+	AuthenticatedRequestsPerMinute int
+	WindowSize                     time.Duration
+	Rules                          []RateLimitRule
+}
+
+// IdempotencyConfig controls replaying responses to unsafe requests sent
+// with an Idempotency-Key header. Completed responses are kept for TTL; a key
+// stays claimed by an unfinished request for at most LockTTL.
+type IdempotencyConfig struct {
+	Enabled     bool
+	TTL         time.Duration
+	LockTTL     time.Duration
+	MaxBodySize int64
 }
 
+// WebhookConfig holds signing secrets for inbound webhook providers.
+// Timestamps older than Tolerance are rejected.
+type WebhookConfig struct {
+	Tolerance time.Duration
+	Providers map[string]WebhookProviderConfig
+}
+
+type WebhookProviderConfig struct {
+	Secret string
+	// Scheme is "hmac-sha256" or "stripe"
+	Scheme          string
+	SignatureHeader string
+	TimestampHeader string
+}
+
+// IPFilterConfig holds the global allow/deny lists applied to every request,
+// plus per-route rules from the route table
+type IPFilterConfig struct {
+	Allow []string
+	Deny  []string
+	Rules []IPRule
+}
+
+const (
+	SessionStoreRedis  = "redis"
+	SessionStoreMemory = "memory"
+	SessionStoreJWT    = "jwt"
+)
+
 type SessionConfig struct {
+	// Store is where sessions are kept: "redis", "memory" for a single
+	// instance without Redis, or "jwt" for signed tokens held by the client
+	// with only revocations and refresh tokens in Redis
+	Store string
+	// JWTSecret signs the sessions of the "jwt" store
+	JWTSecret     string
 	RedisAddr     string
 	RedisPassword string
 	RedisDB       int
-	SessionTTL    time.Duration
-	SessionPrefix string
+	// SentinelMaster, when set, reaches Redis through the Sentinels at
+	// SentinelAddrs instead of RedisAddr
+	SentinelMaster   string
+	SentinelAddrs    []string
+	SentinelPassword string
+	// ClusterAddrs reaches a Redis Cluster through these nodes instead of
+	// RedisAddr
+	ClusterAddrs    []string
+	MaxRetries      int
+	MaxRetryBackoff time.Duration
+	SessionTTL      time.Duration
+	SessionPrefix   string
+	// RefreshTTL bounds how long a login can be kept alive through refreshes
+	RefreshTTL time.Duration
+	// RememberTTL and RememberIdleTTL are the absolute and idle lifetimes
+	// of sessions started with "remember me"
+	RememberTTL     time.Duration
+	RememberIdleTTL time.Duration
+	// EncryptionKeys encrypt sessions at rest, see session.SessionConfig
+	EncryptionKeys []string
+	// MaxPerUser caps the active sessions of a user, 0 for no cap.
+	// LimitPolicy is "evict" to end the oldest session at the cap, or
+	// "reject" to refuse the login.
+	MaxPerUser  int
+	LimitPolicy string
+	// LastSeenInterval is how often using a session writes its last-seen
+	// time and renews its TTL
+	LastSeenInterval time.Duration
+}
+
+const (
+	AuthModeSession = "session"
+	AuthModeJWT     = "jwt"
+)
+
+// AuthConfig selects how the gateway authenticates clients. "session" keeps
+// sessions in the session store; "jwt" issues signed tokens validated
+// without one.
+type AuthConfig struct {
+	Mode            string
+	JWTSecret       string
+	JWTIssuer       string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// DenylistBackend keeps redeemed refresh tokens, "memory" or "redis"
+	DenylistBackend string
+}
+
+// OAuthConfig configures social login. A provider is enabled when its
+// client ID is set.
+type OAuthConfig struct {
+	// CallbackBaseURL is the public gateway URL the provider redirects back to
+	CallbackBaseURL string
+	// SuccessRedirect, if set, is where browsers land after a session login
+	SuccessRedirect string
+	Providers       map[string]OAuthProviderConfig
+}
+
+type OAuthProviderConfig struct {
+	ClientID     string
+	ClientSecret string
+}
+
+// MagicLinkConfig configures passwordless login through a single-use link
+// sent by email
+type MagicLinkConfig struct {
+	Enabled bool
+	// TTL is how long a link stays valid
+	TTL time.Duration
+	// Cooldown is the minimum time between links sent to the same email
+	Cooldown time.Duration
+	// BaseURL is the public gateway URL the link points to
+	BaseURL string
+	// SuccessRedirect, if set, is where browsers land after a session login
+	SuccessRedirect string
+}
+
+// NotificationConfig points at the service that delivers emails. Without a
+// URL messages are only logged, which is meant for development.
+type NotificationConfig struct {
+	URL     string
+	Timeout time.Duration
+}
+
+// GeoIPConfig points at a service locating client IP addresses for the
+// session list. URL contains "{ip}"; without a URL sessions have no location.
+type GeoIPConfig struct {
+	URL      string
+	Timeout  time.Duration
+	CacheTTL time.Duration
+}
+
+// IdentityConfig signs the authenticated user into the X-User-Identity
+// header of upstream requests. Services verify it with the same secret;
+// without one, only the unsigned X-User-ID is sent.
+type IdentityConfig struct {
+	Secret string
+	TTL    time.Duration
+}
+
+// GuestConfig enables anonymous sessions on routes with auth "guest", so
+// shoppers can fill a cart before signing in. On login the order service is
+// asked to merge the guest cart into the user's cart at MergePath.
+type GuestConfig struct {
+	Enabled   bool
+	TTL       time.Duration
+	MergePath string
+}
+
+// APIVersionConfig controls routing under /api/<version>/. Requests to
+// unversioned /api/ paths get the version asked for in the Accept header
+// ("<MediaType>.v2+json" or a "version=2" parameter), or Default.
+type APIVersionConfig struct {
+	Default   string
+	Supported []string
+	MediaType string
+}
+
+// DocsConfig controls the combined OpenAPI spec served at /docs
+type DocsConfig struct {
+	// SpecPath is where every backend serves its own OpenAPI document
+	SpecPath string
+	// CacheTTL is how long the merged spec is reused before backends are
+	// asked again
+	CacheTTL time.Duration
+	// ValidateRequests rejects proxied requests that do not match the
+	// merged spec
+	ValidateRequests bool
+}
+
+// TracingConfig controls OpenTelemetry trace export. The collector address
+// comes from OTEL_EXPORTER_OTLP_ENDPOINT.
+type TracingConfig struct {
+	Enabled     bool
+	SampleRatio float64
+}
+
+// AccessLogConfig controls sampling of the access log. Rules come from the
+// routes config file and override SampleRate for busy paths. Server errors
+// and requests slower than SlowThreshold are always logged.
+type AccessLogConfig struct {
+	SampleRate    float64
+	SlowThreshold time.Duration
+	Rules         []AccessLogRule
+}
+
+// AuditConfig controls the audit trail of admin and auth actions. The last
+// MaxEvents events are kept in Redis under RedisKey when Redis is
+// configured, and in memory otherwise.
+type AuditConfig struct {
+	Enabled   bool
+	MaxEvents int
+	RedisKey  string
+}
+
+// FeatureFlagConfig selects the sources of feature flags, on top of the
+// FEATURE_FLAG_* variables: a JSON file and, with Redis, the hash RedisKey.
+// Files and Redis are reread every RefreshInterval.
+type FeatureFlagConfig struct {
+	File            string
+	Redis           bool
+	RedisKey        string
+	RefreshInterval time.Duration
+}
+
+// LoginProtectionConfig limits password guessing. Failed logins are counted
+// per email and per client IP over FailureWindow; reaching MaxEmailFailures
+// or MaxIPFailures locks the email or IP out for LockoutDuration. After
+// DelayAfter failures for an email, each attempt waits DelayStep, doubling
+// per failure up to MaxDelay. A limit of 0 disables that lockout.
+type LoginProtectionConfig struct {
+	Enabled          bool
+	MaxEmailFailures int
+	MaxIPFailures    int
+	FailureWindow    time.Duration
+	LockoutDuration  time.Duration
+	DelayAfter       int
+	DelayStep        time.Duration
+	MaxDelay         time.Duration
 }
 
 func Load() *Config {
+	apiVersions := loadAPIVersionConfig()
+	environment := getEnv("ENVIRONMENT", "development")
 
 	return &Config{
 		Server: ServerConfig{
-			Port:           getEnv("PORT", "8080"),
-			RequestTimeout: getDurationEnv("REQUEST_TIMEOUT", 30*time.Second),
-			ReadTimeout:    getDurationEnv("READ_TIMEOUT", 10*time.Second),
-			WriteTimeout:   getDurationEnv("WRITE_TIMEOUT", 10*time.Second),
+			Port:              getEnv("PORT", "8080"),
+			MetricsPort:       getEnv("METRICS_PORT", "9090"),
+			RequestTimeout:    getDurationEnv("REQUEST_TIMEOUT", 30*time.Second),
+			ReadTimeout:       getDurationEnv("READ_TIMEOUT", 10*time.Second),
+			WriteTimeout:      getDurationEnv("WRITE_TIMEOUT", 10*time.Second),
+			ReadHeaderTimeout: getDurationEnv("READ_HEADER_TIMEOUT", 5*time.Second),
+			MaxBodySize:       int64(getIntEnv("MAX_BODY_SIZE", 1<<20)),
+			MaxUploadSize:     int64(getIntEnv("MAX_UPLOAD_SIZE", 10<<20)),
+			DrainDelay:        getDurationEnv("DRAIN_DELAY", 5*time.Second),
+			DrainTimeout:      getDurationEnv("DRAIN_TIMEOUT", 30*time.Second),
+			TrustedProxies:    getListEnv("TRUSTED_PROXIES"),
+			TLS: TLSConfig{
+				CertFile:        getEnv("TLS_CERT_FILE", ""),
+				KeyFile:         getEnv("TLS_KEY_FILE", ""),
+				Autocert:        getBoolEnv("TLS_AUTOCERT", false),
+				AutocertDomains: getListEnv("TLS_AUTOCERT_DOMAINS"),
+				AutocertEmail:   getEnv("TLS_AUTOCERT_EMAIL", ""),
+				AutocertCache:   getEnv("TLS_AUTOCERT_CACHE_DIR", "certs"),
+				RedirectPort:    getEnv("TLS_REDIRECT_PORT", ""),
+				HSTSMaxAge:      getDurationEnv("TLS_HSTS_MAX_AGE", 365*24*time.Hour),
+			},
 		},
 		Services: ServicesConfig{
 			UserService:    getEnv("USER_SERVICE_URL", "http://localhost:8081"),
 			ProductService: getEnv("PRODUCT_SERVICE_URL", "http://localhost:8082"),
 			OrderService:   getEnv("ORDER_SERVICE_URL", "http://localhost:8083"),
+			CircuitBreaker: CircuitBreakerConfig{
+				Enabled:             getBoolEnv("CIRCUIT_BREAKER_ENABLED", true),
+				FailureThreshold:    getIntEnv("CIRCUIT_BREAKER_FAILURE_THRESHOLD", 5),
+				SuccessThreshold:    getIntEnv("CIRCUIT_BREAKER_SUCCESS_THRESHOLD", 2),
+				OpenTimeout:         getDurationEnv("CIRCUIT_BREAKER_OPEN_TIMEOUT", 30*time.Second),
+				HalfOpenMaxRequests: getIntEnv("CIRCUIT_BREAKER_HALF_OPEN_MAX_REQUESTS", 1),
+			},
+			GRPC: map[string]string{
+				"user":    getEnv("USER_SERVICE_GRPC_ADDR", "localhost:9081"),
+				"product": getEnv("PRODUCT_SERVICE_GRPC_ADDR", "localhost:9082"),
+				"order":   getEnv("ORDER_SERVICE_GRPC_ADDR", "localhost:9083"),
+			},
+			Canary: map[string]CanaryConfig{
+				"user":    loadCanaryConfig("USER_SERVICE"),
+				"product": loadCanaryConfig("PRODUCT_SERVICE"),
+				"order":   loadCanaryConfig("ORDER_SERVICE"),
+			},
+			VersionURLs: map[string]map[string]string{
+				"user":    loadVersionURLs("USER_SERVICE", apiVersions),
+				"product": loadVersionURLs("PRODUCT_SERVICE", apiVersions),
+				"order":   loadVersionURLs("ORDER_SERVICE", apiVersions),
+			},
+			Shadow: map[string]ShadowConfig{
+				"user":    loadShadowConfig("USER_SERVICE"),
+				"product": loadShadowConfig("PRODUCT_SERVICE"),
+				"order":   loadShadowConfig("ORDER_SERVICE"),
+			},
+			Retry: map[string]RetryConfig{
+				"user":    loadRetryConfig("USER_SERVICE"),
+				"product": loadRetryConfig("PRODUCT_SERVICE"),
+				"order":   loadRetryConfig("ORDER_SERVICE"),
+			},
+			Protocol: map[string]string{
+				"user":    loadUpstreamProtocol("USER_SERVICE"),
+				"product": loadUpstreamProtocol("PRODUCT_SERVICE"),
+				"order":   loadUpstreamProtocol("ORDER_SERVICE"),
+			},
+			Sticky: map[string]bool{
+				"user":    getBoolEnv("USER_SERVICE_STICKY_SESSIONS", getBoolEnv("STICKY_SESSIONS", false)),
+				"product": getBoolEnv("PRODUCT_SERVICE_STICKY_SESSIONS", getBoolEnv("STICKY_SESSIONS", false)),
+				"order":   getBoolEnv("ORDER_SERVICE_STICKY_SESSIONS", getBoolEnv("STICKY_SESSIONS", false)),
+			},
+			MaxConcurrent: map[string]int{
+				"user":    getIntEnv("USER_SERVICE_MAX_CONCURRENT_REQUESTS", getIntEnv("MAX_CONCURRENT_REQUESTS", 0)),
+				"product": getIntEnv("PRODUCT_SERVICE_MAX_CONCURRENT_REQUESTS", getIntEnv("MAX_CONCURRENT_REQUESTS", 0)),
+				"order":   getIntEnv("ORDER_SERVICE_MAX_CONCURRENT_REQUESTS", getIntEnv("MAX_CONCURRENT_REQUESTS", 0)),
+			},
+			HealthCheck: HealthCheckConfig{
+				Enabled:            getBoolEnv("HEALTH_CHECK_ENABLED", true),
+				Interval:           getDurationEnv("HEALTH_CHECK_INTERVAL", 10*time.Second),
+				Timeout:            getDurationEnv("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+				Path:               getEnv("HEALTH_CHECK_PATH", "/health"),
+				UnhealthyThreshold: getIntEnv("HEALTH_CHECK_UNHEALTHY_THRESHOLD", 2),
+				HealthyThreshold:   getIntEnv("HEALTH_CHECK_HEALTHY_THRESHOLD", 1),
+			},
 		},
 		RateLimit: RateLimitConfig{
-			RequestsPerMinute: getIntEnv("RATE_LIMIT_RPM", 60),
-			WindowSize:        getDurationEnv("RATE_LIMIT_WINDOW", 1*time.Minute),
+			Enabled:                        getBoolEnv("RATE_LIMIT_ENABLED", true),
+			RequestsPerMinute:              getIntEnv("RATE_LIMIT_RPM", 60),
+			AuthenticatedRequestsPerMinute: getIntEnv("RATE_LIMIT_AUTH_RPM", 600),
+			WindowSize:                     getDurationEnv("RATE_LIMIT_WINDOW", 1*time.Minute),
+		},
+		Idempotency: IdempotencyConfig{
+			Enabled:     getBoolEnv("IDEMPOTENCY_ENABLED", true),
+			TTL:         getDurationEnv("IDEMPOTENCY_TTL", 24*time.Hour),
+			LockTTL:     getDurationEnv("IDEMPOTENCY_LOCK_TTL", time.Minute),
+			MaxBodySize: int64(getIntEnv("IDEMPOTENCY_MAX_BODY_SIZE", 1<<20)),
+		},
+		IPFilter: IPFilterConfig{
+			Allow: getListEnv("IP_ALLOWLIST"),
+			Deny:  getListEnv("IP_DENYLIST"),
 		},
 		Session: SessionConfig{
-			RedisAddr:     getEnv("REDIS_ADDR", "localhost:6379"),
-			RedisPassword: getEnv("REDIS_PASSWORD", ""),
-			RedisDB:       getIntEnv("REDIS_DB", 0),
-			SessionTTL:    getDurationEnv("SESSION_TTL", 24*time.Hour),
-			SessionPrefix: getEnv("SESSION_PREFIX", "session"),
+			Store:            getEnv("SESSION_STORE", SessionStoreRedis),
+			JWTSecret:        getEnv("SESSION_JWT_SECRET", ""),
+			RedisAddr:        getEnv("REDIS_ADDR", "localhost:6379"),
+			RedisPassword:    getEnv("REDIS_PASSWORD", ""),
+			RedisDB:          getIntEnv("REDIS_DB", 0),
+			SentinelMaster:   getEnv("REDIS_SENTINEL_MASTER", ""),
+			SentinelAddrs:    getListEnv("REDIS_SENTINEL_ADDRS"),
+			SentinelPassword: getEnv("REDIS_SENTINEL_PASSWORD", ""),
+			ClusterAddrs:     getListEnv("REDIS_CLUSTER_ADDRS"),
+			MaxRetries:       getIntEnv("REDIS_MAX_RETRIES", 3),
+			MaxRetryBackoff:  getDurationEnv("REDIS_MAX_RETRY_BACKOFF", 512*time.Millisecond),
+			SessionTTL:       getDurationEnv("SESSION_TTL", 24*time.Hour),
+			SessionPrefix:    getEnv("SESSION_PREFIX", "session"),
+			RefreshTTL:       getDurationEnv("SESSION_REFRESH_TTL", 30*24*time.Hour),
+			RememberTTL:      getDurationEnv("SESSION_REMEMBER_TTL", 30*24*time.Hour),
+			RememberIdleTTL:  getDurationEnv("SESSION_REMEMBER_IDLE_TTL", 7*24*time.Hour),
+			EncryptionKeys:   getListEnv("SESSION_ENCRYPTION_KEYS"),
+			MaxPerUser:       getIntEnv("SESSION_MAX_PER_USER", 0),
+			LimitPolicy:      getEnv("SESSION_LIMIT_POLICY", "evict"),
+			LastSeenInterval: getDurationEnv("SESSION_LAST_SEEN_INTERVAL", time.Minute),
+		},
+		Auth: AuthConfig{
+			Mode:            getEnv("AUTH_MODE", AuthModeSession),
+			JWTSecret:       getEnv("JWT_SECRET", ""),
+			JWTIssuer:       getEnv("JWT_ISSUER", "api-gateway"),
+			AccessTokenTTL:  getDurationEnv("JWT_ACCESS_TOKEN_TTL", 15*time.Minute),
+			RefreshTokenTTL: getDurationEnv("JWT_REFRESH_TOKEN_TTL", 7*24*time.Hour),
+			DenylistBackend: getEnv("JWT_DENYLIST_BACKEND", "memory"),
+		},
+		OAuth: OAuthConfig{
+			CallbackBaseURL: getEnv("OAUTH_CALLBACK_BASE_URL", "http://localhost:8080"),
+			SuccessRedirect: getEnv("OAUTH_SUCCESS_REDIRECT", ""),
+			Providers: map[string]OAuthProviderConfig{
+				"google": {
+					ClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
+					ClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
+				},
+				"github": {
+					ClientID:     getEnv("GITHUB_CLIENT_ID", ""),
+					ClientSecret: getEnv("GITHUB_CLIENT_SECRET", ""),
+				},
+			},
+		},
+		MagicLink: MagicLinkConfig{
+			Enabled:         getBoolEnv("MAGIC_LINK_ENABLED", false),
+			TTL:             getDurationEnv("MAGIC_LINK_TTL", 15*time.Minute),
+			Cooldown:        getDurationEnv("MAGIC_LINK_COOLDOWN", time.Minute),
+			BaseURL:         getEnv("MAGIC_LINK_BASE_URL", getEnv("OAUTH_CALLBACK_BASE_URL", "http://localhost:8080")),
+			SuccessRedirect: getEnv("MAGIC_LINK_SUCCESS_REDIRECT", getEnv("OAUTH_SUCCESS_REDIRECT", "")),
+		},
+		Notify: NotificationConfig{
+			URL:     getEnv("NOTIFICATION_URL", ""),
+			Timeout: getDurationEnv("NOTIFICATION_TIMEOUT", 10*time.Second),
+		},
+		GeoIP: GeoIPConfig{
+			URL:      getEnv("GEOIP_URL", ""),
+			Timeout:  getDurationEnv("GEOIP_TIMEOUT", 2*time.Second),
+			CacheTTL: getDurationEnv("GEOIP_CACHE_TTL", 24*time.Hour),
+		},
+		Identity: IdentityConfig{
+			Secret: getEnv("IDENTITY_SIGNING_SECRET", ""),
+			TTL:    getDurationEnv("IDENTITY_TTL", time.Minute),
+		},
+		Guest: GuestConfig{
+			Enabled:   getBoolEnv("GUEST_SESSIONS_ENABLED", false),
+			TTL:       getDurationEnv("GUEST_SESSION_TTL", 7*24*time.Hour),
+			MergePath: getEnv("GUEST_CART_MERGE_PATH", "/cart/merge"),
+		},
+		Webhooks: WebhookConfig{
+			Tolerance: getDurationEnv("WEBHOOK_TOLERANCE", 5*time.Minute),
+			Providers: map[string]WebhookProviderConfig{
+				"payment":      loadWebhookProvider("PAYMENT"),
+				"notification": loadWebhookProvider("NOTIFICATION"),
+			},
+		},
+		RoutesFile: getEnv("ROUTES_CONFIG", ""),
+		Cache: CacheConfig{
+			Enabled:     getBoolEnv("CACHE_ENABLED", true),
+			Backend:     getEnv("CACHE_BACKEND", "memory"),
+			KeyPrefix:   getEnv("CACHE_KEY_PREFIX", "gwcache"),
+			MaxEntries:  getIntEnv("CACHE_MAX_ENTRIES", 10000),
+			MaxBodySize: int64(getIntEnv("CACHE_MAX_BODY_SIZE", 1<<20)),
+		},
+		APIVersions: apiVersions,
+		Docs: DocsConfig{
+			SpecPath:         getEnv("OPENAPI_SPEC_PATH", "/openapi.json"),
+			CacheTTL:         getDurationEnv("DOCS_CACHE_TTL", time.Minute),
+			ValidateRequests: getBoolEnv("OPENAPI_VALIDATE_REQUESTS", true),
+		},
+		AccessLog: AccessLogConfig{
+			SampleRate:    getFloatEnv("ACCESS_LOG_SAMPLE_RATE", 1),
+			SlowThreshold: getDurationEnv("ACCESS_LOG_SLOW_THRESHOLD", time.Second),
+		},
+		Login: LoginProtectionConfig{
+			Enabled:          getBoolEnv("LOGIN_PROTECTION_ENABLED", true),
+			MaxEmailFailures: getIntEnv("LOGIN_MAX_EMAIL_FAILURES", 5),
+			MaxIPFailures:    getIntEnv("LOGIN_MAX_IP_FAILURES", 20),
+			FailureWindow:    getDurationEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+			LockoutDuration:  getDurationEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+			DelayAfter:       getIntEnv("LOGIN_DELAY_AFTER", 2),
+			DelayStep:        getDurationEnv("LOGIN_DELAY_STEP", 500*time.Millisecond),
+			MaxDelay:         getDurationEnv("LOGIN_MAX_DELAY", 5*time.Second),
+		},
+		Audit: AuditConfig{
+			Enabled:   getBoolEnv("AUDIT_ENABLED", true),
+			MaxEvents: getIntEnv("AUDIT_MAX_EVENTS", 10000),
+			RedisKey:  getEnv("AUDIT_REDIS_KEY", "gw-audit"),
 		},
+		Features: FeatureFlagConfig{
+			File:            getEnv("FEATURE_FLAGS_FILE", ""),
+			Redis:           getBoolEnv("FEATURE_FLAGS_REDIS", false),
+			RedisKey:        getEnv("FEATURE_FLAGS_REDIS_KEY", "feature_flags"),
+			RefreshInterval: getDurationEnv("FEATURE_FLAGS_REFRESH_INTERVAL", 30*time.Second),
+		},
+		Tracing: TracingConfig{
+			Enabled:     getBoolEnv("TRACING_ENABLED", false),
+			SampleRatio: getFloatEnv("TRACING_SAMPLE_RATIO", 1),
+		},
+		Environment: environment,
+		Log: logger.Config{
+			Level:       getEnv("LOG_LEVEL", "info"),
+			Format:      getEnv("LOG_FORMAT", logger.DefaultFormat(environment)),
+			ServiceName: "api-gateway",
+			Environment: environment,
+			Components:  getEnv("LOG_COMPONENTS", ""),
+			File: logger.FileConfig{
+				Path:       getEnv("LOG_FILE", ""),
+				ErrorPath:  getEnv("LOG_ERROR_FILE", ""),
+				MaxSizeMB:  getIntEnv("LOG_FILE_MAX_SIZE_MB", 100),
+				MaxAgeDays: getIntEnv("LOG_FILE_MAX_AGE_DAYS", 30),
+				MaxBackups: getIntEnv("LOG_FILE_MAX_BACKUPS", 10),
+				Compress:   getBoolEnv("LOG_FILE_COMPRESS", false),
+			},
+			Export: logger.ExportConfig{
+				Protocol:      getEnv("LOG_EXPORT_PROTOCOL", ""),
+				Endpoint:      getEnv("LOG_EXPORT_ENDPOINT", ""),
+				Headers:       getListEnv("LOG_EXPORT_HEADERS"),
+				Level:         getEnv("LOG_EXPORT_LEVEL", ""),
+				BatchSize:     getIntEnv("LOG_EXPORT_BATCH_SIZE", 100),
+				FlushInterval: getDurationEnv("LOG_EXPORT_FLUSH_INTERVAL", time.Second),
+				QueueSize:     getIntEnv("LOG_EXPORT_QUEUE_SIZE", 1000),
+				MaxRetries:    getIntEnv("LOG_EXPORT_MAX_RETRIES", 3),
+				Timeout:       getDurationEnv("LOG_EXPORT_TIMEOUT", 5*time.Second),
+			},
+		},
+		Discovery: DiscoveryConfig{
+			Provider:    getEnv("DISCOVERY_PROVIDER", "static"),
+			ConsulAddr:  getEnv("CONSUL_ADDR", "http://localhost:8500"),
+			ConsulToken: getEnv("CONSUL_TOKEN", ""),
+			Datacenter:  getEnv("CONSUL_DATACENTER", ""),
+			WatchWait:   getDurationEnv("DISCOVERY_WATCH_WAIT", 5*time.Minute),
+			ServiceNames: map[string]string{
+				"user":    getEnv("USER_SERVICE_NAME", "user-service"),
+				"product": getEnv("PRODUCT_SERVICE_NAME", "product-service"),
+				"order":   getEnv("ORDER_SERVICE_NAME", "order-service"),
+			},
+		},
+	}
+}
+
+// loadRetryConfig reads <PREFIX>_RETRY_* variables, falling back to the
+// global RETRY_* values when a service does not override them.
+func loadRetryConfig(prefix string) RetryConfig {
+	maxAttempts := getIntEnv("RETRY_MAX_ATTEMPTS", 3)
+	initialBackoff := getDurationEnv("RETRY_INITIAL_BACKOFF", 100*time.Millisecond)
+	maxBackoff := getDurationEnv("RETRY_MAX_BACKOFF", 2*time.Second)
+
+	return RetryConfig{
+		MaxAttempts:    getIntEnv(prefix+"_RETRY_MAX_ATTEMPTS", maxAttempts),
+		InitialBackoff: getDurationEnv(prefix+"_RETRY_INITIAL_BACKOFF", initialBackoff),
+		MaxBackoff:     getDurationEnv(prefix+"_RETRY_MAX_BACKOFF", maxBackoff),
 	}
 }
 
@@ -74,6 +722,90 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// loadCanaryConfig reads <PREFIX>_CANARY_URLS and <PREFIX>_CANARY_WEIGHT;
+// the override header and cookie names are shared by all services.
+func loadCanaryConfig(prefix string) CanaryConfig {
+	return CanaryConfig{
+		URLs:   getListEnv(prefix + "_CANARY_URLS"),
+		Weight: getIntEnv(prefix+"_CANARY_WEIGHT", 0),
+		Header: getEnv("CANARY_HEADER", "X-Canary"),
+		Cookie: getEnv("CANARY_COOKIE", "canary"),
+	}
+}
+
+// loadAPIVersionConfig reads API_DEFAULT_VERSION and API_VERSIONS; the
+// default version is always supported
+func loadAPIVersionConfig() APIVersionConfig {
+	defaultVersion := strings.ToLower(getEnv("API_DEFAULT_VERSION", "v1"))
+	supported := []string{defaultVersion}
+	for _, version := range getListEnv("API_VERSIONS") {
+		if version = strings.ToLower(version); !slices.Contains(supported, version) {
+			supported = append(supported, version)
+		}
+	}
+
+	return APIVersionConfig{
+		Default:   defaultVersion,
+		Supported: supported,
+		MediaType: getEnv("API_MEDIA_TYPE", "application/vnd.gateway"),
+	}
+}
+
+// loadVersionURLs reads <PREFIX>_URL_<VERSION> (e.g. PRODUCT_SERVICE_URL_V2)
+// for every non-default API version
+func loadVersionURLs(prefix string, versions APIVersionConfig) map[string]string {
+	urls := make(map[string]string)
+	for _, version := range versions.Supported {
+		if version == versions.Default {
+			continue
+		}
+		if url := getEnv(prefix+"_URL_"+strings.ToUpper(version), ""); url != "" {
+			urls[version] = url
+		}
+	}
+	return urls
+}
+
+// loadUpstreamProtocol reads <PREFIX>_UPSTREAM_PROTOCOL, falling back to
+// the global UPSTREAM_PROTOCOL
+func loadUpstreamProtocol(prefix string) string {
+	protocol := getEnv("UPSTREAM_PROTOCOL", UpstreamProtocolAuto)
+	return strings.ToLower(getEnv(prefix+"_UPSTREAM_PROTOCOL", protocol))
+}
+
+// loadShadowConfig reads <PREFIX>_SHADOW_URL and <PREFIX>_SHADOW_PERCENT,
+// with SHADOW_MIRROR_WRITES and SHADOW_TIMEOUT shared by all services.
+func loadShadowConfig(prefix string) ShadowConfig {
+	return ShadowConfig{
+		URL:          getEnv(prefix+"_SHADOW_URL", ""),
+		Percent:      getIntEnv(prefix+"_SHADOW_PERCENT", 100),
+		MirrorWrites: getBoolEnv("SHADOW_MIRROR_WRITES", false),
+		Timeout:      getDurationEnv("SHADOW_TIMEOUT", 5*time.Second),
+	}
+}
+
+// loadWebhookProvider reads WEBHOOK_<NAME>_* variables
+func loadWebhookProvider(name string) WebhookProviderConfig {
+	prefix := "WEBHOOK_" + name
+	return WebhookProviderConfig{
+		Secret:          getEnv(prefix+"_SECRET", ""),
+		Scheme:          getEnv(prefix+"_SCHEME", "hmac-sha256"),
+		SignatureHeader: getEnv(prefix+"_SIGNATURE_HEADER", "X-Webhook-Signature"),
+		TimestampHeader: getEnv(prefix+"_TIMESTAMP_HEADER", "X-Webhook-Timestamp"),
+	}
+}
+
+// getListEnv splits a comma-separated variable, skipping empty entries
+func getListEnv(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func getIntEnv(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
@@ -83,6 +815,24 @@ func getIntEnv(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+func getBoolEnv(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -0,0 +1,364 @@
+package config
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+)
+
+// Route auth requirements
+const (
+	AuthNone     = "none"
+	AuthRequired = "required"
+	AuthAdmin    = "admin"
+	// AuthGuest serves signed-in users as such and gives everyone else a
+	// guest session
+	AuthGuest = "guest"
+)
+
+//go:embed routes.default.json
+var defaultRoutes []byte
+
+// Route upstream protocols
+const (
+	ProtocolHTTP = "http"
+	ProtocolGRPC = "grpc"
+)
+
+// RouteConfig describes one upstream route handled by the gateway. Routes
+// with protocol "grpc" are transcoded from JSON/REST into a unary gRPC call
+// to GRPCMethod (e.g. "/user.v1.UserService/GetUser").
+type RouteConfig struct {
+	PathPrefix  string   `json:"path_prefix"`
+	Service     string   `json:"service"`
+	Auth        string   `json:"auth"`
+	Methods     []string `json:"methods,omitempty"`
+	StripPrefix string   `json:"strip_prefix,omitempty"`
+	Protocol    string   `json:"protocol,omitempty"`
+	GRPCMethod  string   `json:"grpc_method,omitempty"`
+	// Exact matches PathPrefix itself only, not the paths below it
+	Exact bool `json:"exact,omitempty"`
+	// Permissions must all be granted by the caller's role, on top of the
+	// Auth requirement
+	Permissions []rbac.Permission `json:"permissions,omitempty"`
+	// Group scopes the route to the group named, by ID or slug, in the path
+	// segment after PathPrefix
+	Group *GroupScope `json:"group,omitempty"`
+	// MaxBodySize replaces MAX_BODY_SIZE and MAX_UPLOAD_SIZE for request
+	// bodies on this route; -1 lifts the limit
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+	// CacheTTL enables response caching of GET requests on this route
+	CacheTTL Duration `json:"cache_ttl,omitempty"`
+	// PurgePrefixes lists extra cached paths invalidated by writes to this
+	// route; the route's own prefix is always purged.
+	PurgePrefixes []string `json:"purge_prefixes,omitempty"`
+	// Webhook names the webhook provider whose signature must be verified
+	// before the request is proxied
+	Webhook string `json:"webhook,omitempty"`
+	// Fallback is served to GET requests instead of an error while the
+	// service is unavailable
+	Fallback *FallbackConfig `json:"fallback,omitempty"`
+	// Feature names the feature flag that must be on for the caller; the
+	// route answers 404 otherwise
+	Feature string `json:"feature,omitempty"`
+	// Deprecated marks the route's responses as deprecated, with its sunset
+	// date and successor, and logs who still calls it
+	Deprecated *middleware.Deprecation `json:"deprecated,omitempty"`
+}
+
+// GroupScope admits members of the group a request names whose role in it
+// is MinRole or higher, as well as admins. Memberships are those the caller
+// had when signing in.
+type GroupScope struct {
+	MinRole rbac.GroupRole `json:"min_role,omitempty"`
+}
+
+// FallbackConfig describes the degraded response for a route whose service
+// answers 502, 503 or 504. With LastGood, the last successful response for
+// the same URL is replayed; Body is the static JSON payload served when
+// there is no such response.
+type FallbackConfig struct {
+	LastGood    bool            `json:"last_good,omitempty"`
+	LastGoodTTL Duration        `json:"last_good_ttl,omitempty"`
+	Status      int             `json:"status,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+// Duration is a time.Duration that unmarshals from strings like "30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// RateLimitRule limits requests under a path prefix. Anonymous clients are
+// limited per IP and authenticated clients per user; 0 means unlimited.
+type RateLimitRule struct {
+	PathPrefix    string   `json:"path_prefix"`
+	Methods       []string `json:"methods,omitempty"`
+	Anonymous     int      `json:"anonymous"`
+	Authenticated int      `json:"authenticated"`
+	Window        Duration `json:"window"`
+}
+
+// IPRule restricts requests under a path prefix by client address. Entries
+// are CIDR ranges or single IPs; deny entries win over allow entries, and a
+// non-empty allow list blocks every address it does not contain.
+type IPRule struct {
+	PathPrefix string   `json:"path_prefix"`
+	Allow      []string `json:"allow,omitempty"`
+	Deny       []string `json:"deny,omitempty"`
+}
+
+// TimeoutRule overrides timeouts for requests under a path prefix. Handler
+// replaces REQUEST_TIMEOUT for the whole request; Proxy bounds the upstream
+// call, retries included. Zero keeps the default.
+type TimeoutRule struct {
+	PathPrefix string   `json:"path_prefix"`
+	Methods    []string `json:"methods,omitempty"`
+	Handler    Duration `json:"handler,omitempty"`
+	Proxy      Duration `json:"proxy,omitempty"`
+}
+
+// AccessLogRule sets the share (0-1) of requests under a path prefix that
+// are written to the access log
+type AccessLogRule struct {
+	PathPrefix string  `json:"path_prefix"`
+	SampleRate float64 `json:"sample_rate"`
+}
+
+// RouteTable is the content of the routes config file
+type RouteTable struct {
+	Routes     []RouteConfig   `json:"routes"`
+	RateLimits []RateLimitRule `json:"rate_limits,omitempty"`
+	IPRules    []IPRule        `json:"ip_rules,omitempty"`
+	Timeouts   []TimeoutRule   `json:"timeouts,omitempty"`
+	AccessLog  []AccessLogRule `json:"access_log,omitempty"`
+}
+
+// LoadRoutes reads the route table from path, or returns the built-in
+// default table when path is empty.
+func LoadRoutes(path string) (*RouteTable, error) {
+	data := defaultRoutes
+	if path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read routes config: %w", err)
+		}
+		data = fileData
+	}
+
+	var file RouteTable
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse routes config: %w", err)
+	}
+
+	for i := range file.Routes {
+		if err := normalizeRoute(&file.Routes[i]); err != nil {
+			return nil, fmt.Errorf("invalid route #%d: %w", i+1, err)
+		}
+	}
+
+	for i := range file.RateLimits {
+		if err := normalizeRateLimitRule(&file.RateLimits[i]); err != nil {
+			return nil, fmt.Errorf("invalid rate limit #%d: %w", i+1, err)
+		}
+	}
+
+	for i, rule := range file.IPRules {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return nil, fmt.Errorf("invalid ip rule #%d: path_prefix %q must start with /", i+1, rule.PathPrefix)
+		}
+		if _, err := ParseIPRanges(append(rule.Allow, rule.Deny...)); err != nil {
+			return nil, fmt.Errorf("invalid ip rule #%d: %w", i+1, err)
+		}
+	}
+
+	for i := range file.Timeouts {
+		if err := normalizeTimeoutRule(&file.Timeouts[i]); err != nil {
+			return nil, fmt.Errorf("invalid timeout #%d: %w", i+1, err)
+		}
+	}
+
+	for i, rule := range file.AccessLog {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return nil, fmt.Errorf("invalid access log rule #%d: path_prefix %q must start with /", i+1, rule.PathPrefix)
+		}
+		if rule.SampleRate < 0 || rule.SampleRate > 1 {
+			return nil, fmt.Errorf("invalid access log rule #%d: sample_rate must be between 0 and 1", i+1)
+		}
+	}
+
+	return &file, nil
+}
+
+// ParseIPRanges parses CIDR ranges and single IPs into prefixes
+func ParseIPRanges(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP %q: %w", entry, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func normalizeRateLimitRule(rule *RateLimitRule) error {
+	if !strings.HasPrefix(rule.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix %q must start with /", rule.PathPrefix)
+	}
+	if rule.Anonymous < 0 || rule.Authenticated < 0 {
+		return fmt.Errorf("limits for %s must not be negative", rule.PathPrefix)
+	}
+	if rule.Window <= 0 {
+		rule.Window = Duration(time.Minute)
+	}
+	for i, method := range rule.Methods {
+		rule.Methods[i] = strings.ToUpper(method)
+	}
+	return nil
+}
+
+func normalizeTimeoutRule(rule *TimeoutRule) error {
+	if !strings.HasPrefix(rule.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix %q must start with /", rule.PathPrefix)
+	}
+	if rule.Handler < 0 || rule.Proxy < 0 {
+		return fmt.Errorf("timeouts for %s must not be negative", rule.PathPrefix)
+	}
+	if rule.Handler > 0 && rule.Proxy > rule.Handler {
+		return fmt.Errorf("proxy timeout for %s exceeds its handler timeout", rule.PathPrefix)
+	}
+	for i, method := range rule.Methods {
+		rule.Methods[i] = strings.ToUpper(method)
+	}
+	return nil
+}
+
+func normalizeRoute(route *RouteConfig) error {
+	if !strings.HasPrefix(route.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix %q must start with /", route.PathPrefix)
+	}
+	if route.Service == "" {
+		return fmt.Errorf("service is required for %s", route.PathPrefix)
+	}
+	if route.StripPrefix != "" && !strings.HasPrefix(route.PathPrefix, route.StripPrefix) {
+		return fmt.Errorf("strip_prefix %q is not a prefix of %s", route.StripPrefix, route.PathPrefix)
+	}
+	if route.MaxBodySize < -1 {
+		return fmt.Errorf("max_body_size for %s must be -1 or more", route.PathPrefix)
+	}
+	if deprecated := route.Deprecated; deprecated != nil && !deprecated.Sunset.IsZero() && deprecated.Sunset.Before(deprecated.Since) {
+		return fmt.Errorf("sunset of %s is before its deprecation", route.PathPrefix)
+	}
+
+	if fallback := route.Fallback; fallback != nil {
+		if !fallback.LastGood && len(fallback.Body) == 0 {
+			return fmt.Errorf("fallback for %s needs last_good or a body", route.PathPrefix)
+		}
+		if fallback.Status == 0 {
+			fallback.Status = 200
+		}
+		if fallback.Status < 200 || fallback.Status > 599 {
+			return fmt.Errorf("invalid fallback status %d for %s", fallback.Status, route.PathPrefix)
+		}
+		if fallback.LastGoodTTL <= 0 {
+			fallback.LastGoodTTL = Duration(time.Hour)
+		}
+	}
+
+	route.Auth = strings.ToLower(route.Auth)
+	switch route.Auth {
+	case "":
+		route.Auth = AuthRequired
+	case AuthNone, AuthRequired, AuthAdmin, AuthGuest:
+	default:
+		return fmt.Errorf("unknown auth requirement %q for %s", route.Auth, route.PathPrefix)
+	}
+
+	for i, name := range route.Permissions {
+		permission, err := rbac.ParsePermission(string(name))
+		if err != nil {
+			return fmt.Errorf("%w for %s", err, route.PathPrefix)
+		}
+		route.Permissions[i] = permission
+	}
+	if len(route.Permissions) > 0 && (route.Auth == AuthNone || route.Auth == AuthGuest) {
+		return fmt.Errorf("permissions on %s require authentication, not auth %q", route.PathPrefix, route.Auth)
+	}
+
+	if group := route.Group; group != nil {
+		if route.Auth == AuthNone || route.Auth == AuthGuest {
+			return fmt.Errorf("group scope on %s requires authentication, not auth %q", route.PathPrefix, route.Auth)
+		}
+		group.MinRole = rbac.NormalizeGroupRole(string(group.MinRole))
+		if !group.MinRole.Valid() {
+			return fmt.Errorf("unknown group role %q for %s", group.MinRole, route.PathPrefix)
+		}
+	}
+
+	route.Protocol = strings.ToLower(route.Protocol)
+	switch route.Protocol {
+	case "":
+		route.Protocol = ProtocolHTTP
+	case ProtocolHTTP:
+	case ProtocolGRPC:
+		if !strings.HasPrefix(route.GRPCMethod, "/") || strings.Count(route.GRPCMethod, "/") != 2 {
+			return fmt.Errorf("grpc_method %q for %s must look like /package.Service/Method", route.GRPCMethod, route.PathPrefix)
+		}
+	default:
+		return fmt.Errorf("unknown protocol %q for %s", route.Protocol, route.PathPrefix)
+	}
+
+	for i, method := range route.Methods {
+		route.Methods[i] = strings.ToUpper(method)
+	}
+
+	return nil
+}
+
+// MatchesPathPrefix reports whether path equals prefix or is below it as a
+// path segment, so "/api/v1/users" does not match "/api/v1/usersettings".
+func MatchesPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
@@ -0,0 +1,152 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+)
+
+// ConsulDiscovery watches Consul's health API using blocking queries, so
+// changes are picked up as soon as Consul reports them.
+type ConsulDiscovery struct {
+	baseURL    string
+	token      string
+	datacenter string
+	wait       time.Duration
+	httpClient *http.Client
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+}
+
+func NewConsulDiscovery(cfg config.DiscoveryConfig) *ConsulDiscovery {
+	wait := cfg.WatchWait
+	if wait <= 0 {
+		wait = 5 * time.Minute
+	}
+
+	return &ConsulDiscovery{
+		baseURL:    strings.TrimSuffix(cfg.ConsulAddr, "/"),
+		token:      cfg.ConsulToken,
+		datacenter: cfg.Datacenter,
+		wait:       wait,
+		httpClient: &http.Client{
+			// Leave headroom over the blocking query wait time
+			Timeout: wait + 30*time.Second,
+		},
+	}
+}
+
+func (c *ConsulDiscovery) Watch(ctx context.Context, serviceName string, update UpdateFunc) {
+	var (
+		index   uint64
+		current []string
+		backoff = time.Second
+	)
+
+	for ctx.Err() == nil {
+		addrs, newIndex, err := c.fetch(ctx, serviceName, index)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.WarnMsg("Consul service lookup failed", "service", serviceName, "error", err, "retry_in", backoff.String())
+			if !sleep(ctx, backoff) {
+				return
+			}
+			backoff = min(backoff*2, 30*time.Second)
+			continue
+		}
+		backoff = time.Second
+
+		// Consul may reset the index; start over rather than block forever
+		if newIndex < index {
+			newIndex = 0
+		}
+		index = newIndex
+
+		slices.Sort(addrs)
+		if !slices.Equal(addrs, current) {
+			current = addrs
+			logger.InfoMsg("Service instances updated", "service", serviceName, "instances", strings.Join(addrs, ","))
+			update(addrs)
+		}
+	}
+}
+
+func (c *ConsulDiscovery) fetch(ctx context.Context, serviceName string, index uint64) ([]string, uint64, error) {
+	query := url.Values{}
+	query.Set("passing", "true")
+	query.Set("wait", fmt.Sprintf("%ds", int(c.wait.Seconds())))
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+	}
+	if c.datacenter != "" {
+		query.Set("dc", c.datacenter)
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/health/service/%s?%s", c.baseURL, url.PathEscape(serviceName), query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query consul: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul returned status %d", resp.StatusCode)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode consul response: %w", err)
+	}
+
+	newIndex, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	addrs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		addrs = append(addrs, fmt.Sprintf("http://%s:%d", host, entry.Service.Port))
+	}
+
+	return addrs, newIndex, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
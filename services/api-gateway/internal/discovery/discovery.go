@@ -0,0 +1,33 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+)
+
+// UpdateFunc receives the full, current list of instance addresses for a
+// service every time it changes.
+type UpdateFunc func(addrs []string)
+
+// Discovery resolves backend service instances and notifies on changes
+type Discovery interface {
+	// Watch blocks until ctx is cancelled, calling update whenever the set of
+	// healthy instances for serviceName changes.
+	Watch(ctx context.Context, serviceName string, update UpdateFunc)
+}
+
+// New returns the discovery backend for the configured provider, or nil when
+// the gateway should keep using static service URLs.
+func New(cfg config.DiscoveryConfig) (Discovery, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "", "static":
+		return nil, nil
+	case "consul":
+		return NewConsulDiscovery(cfg), nil
+	default:
+		return nil, fmt.Errorf("unsupported discovery provider: %s", cfg.Provider)
+	}
+}
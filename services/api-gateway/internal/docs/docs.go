@@ -0,0 +1,302 @@
+package docs
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+)
+
+// publicPrefix is where backend paths are exposed by the route table
+const publicPrefix = "/api/v1"
+
+// refreshTimeout bounds a rebuild of the spec, retries included
+const refreshTimeout = 30 * time.Second
+
+//go:embed gateway.json
+var gatewaySpec []byte
+
+//go:embed swagger-ui.html
+var swaggerUI []byte
+
+var httpMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// Fetcher makes GET requests to a service and returns the response body.
+// *aggregate.Aggregator satisfies it.
+type Fetcher interface {
+	Fetch(ctx context.Context, incoming *http.Request, service, path string) (json.RawMessage, error)
+}
+
+// RouteMatcher returns the configured route for a gateway path and method
+type RouteMatcher func(path, method string) *config.RouteConfig
+
+// Handler serves Swagger UI and an OpenAPI spec combining the gateway's own
+// endpoints with the specs published by each backend service.
+type Handler struct {
+	fetcher  Fetcher
+	match    RouteMatcher
+	services []string
+	specPath string
+	cacheTTL time.Duration
+
+	mutex      sync.Mutex
+	spec       []byte
+	cachedAt   time.Time
+	refreshing bool
+}
+
+func NewHandler(fetcher Fetcher, match RouteMatcher, routes []config.RouteConfig, cfg config.DocsConfig) *Handler {
+	var services []string
+	for _, route := range routes {
+		if route.Protocol != config.ProtocolGRPC && !slices.Contains(services, route.Service) {
+			services = append(services, route.Service)
+		}
+	}
+	slices.Sort(services)
+
+	return &Handler{
+		fetcher:  fetcher,
+		match:    match,
+		services: services,
+		specPath: cfg.SpecPath,
+		cacheTTL: cfg.CacheTTL,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/docs", "/docs/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(swaggerUI)
+	case "/docs/openapi.json", "/docs/swagger.json":
+		spec, _ := h.Spec(r)
+		if spec == nil {
+			w.Header().Set("Retry-After", "1")
+			utils.SendError(w, http.StatusServiceUnavailable, "API documentation is being built")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	default:
+		utils.SendError(w, http.StatusNotFound, "Endpoint not found")
+	}
+}
+
+// Spec returns the cached merged spec without waiting on the backends. Once
+// the copy is older than the cache TTL it is rebuilt in the background and
+// served stale meanwhile. Until the first build completes Spec returns nil,
+// so callers must cope with having no spec yet.
+func (h *Handler) Spec(r *http.Request) ([]byte, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if (h.spec == nil || time.Since(h.cachedAt) >= h.cacheTTL) && !h.refreshing {
+		h.refreshing = true
+		go h.refresh()
+	}
+	return h.spec, nil
+}
+
+// refresh rebuilds the spec, keeping the previous one if that fails
+func (h *Handler) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+
+	spec, err := h.build(ctx)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.refreshing = false
+	if err != nil {
+		logger.ErrorWithStack(ctx, err, "Failed to build OpenAPI spec")
+		return
+	}
+	h.spec = spec
+	h.cachedAt = time.Now()
+}
+
+// build fetches every service's spec at once and merges them
+func (h *Handler) build(ctx context.Context) ([]byte, error) {
+	var combined map[string]any
+	if err := json.Unmarshal(gatewaySpec, &combined); err != nil {
+		return nil, fmt.Errorf("invalid gateway spec: %w", err)
+	}
+
+	// Nothing to forward from: the build serves every later request
+	incoming, err := http.NewRequestWithContext(ctx, http.MethodGet, h.specPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec path %q: %w", h.specPath, err)
+	}
+
+	serviceSpecs := make([]map[string]any, len(h.services))
+	var wg sync.WaitGroup
+	for i, service := range h.services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := h.fetcher.Fetch(ctx, incoming, service, h.specPath)
+			if err != nil {
+				logger.Warn(ctx, "OpenAPI spec unavailable", "service", service, "error", err)
+				return
+			}
+			if err := json.Unmarshal(data, &serviceSpecs[i]); err != nil {
+				logger.Warn(ctx, "Invalid OpenAPI spec", "service", service, "error", err)
+				serviceSpecs[i] = nil
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Merged in service order, so the result does not depend on timing
+	var unavailable []string
+	for i, service := range h.services {
+		if serviceSpecs[i] == nil {
+			unavailable = append(unavailable, service)
+			continue
+		}
+		h.merge(combined, service, serviceSpecs[i])
+	}
+
+	if len(unavailable) > 0 {
+		combined["x-unavailable-services"] = unavailable
+	}
+
+	return json.Marshal(combined)
+}
+
+// merge adds a service's components under "<service>." names and its paths
+// under publicPrefix. Only operations the route table actually sends to the
+// service are kept, so internal endpoints stay undocumented, and paths the
+// gateway handles itself win over backend paths.
+func (h *Handler) merge(combined map[string]any, service string, serviceSpec map[string]any) {
+	namespaceRefs(serviceSpec, service)
+
+	components := objectField(combined, "components")
+	for section, entries := range objectField(serviceSpec, "components") {
+		entries, ok := entries.(map[string]any)
+		if !ok {
+			continue
+		}
+		target := objectField(components, section)
+		for name, entry := range entries {
+			target[service+"."+name] = entry
+		}
+	}
+
+	paths := objectField(combined, "paths")
+	for path, item := range objectField(serviceSpec, "paths") {
+		operations, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		publicPath := publicPrefix + path
+		if _, exists := paths[publicPath]; exists {
+			continue
+		}
+
+		exposed := make(map[string]any, len(operations))
+		for method, operation := range operations {
+			if !slices.Contains(httpMethods, method) {
+				// Path-level fields such as shared parameters
+				exposed[method] = operation
+				continue
+			}
+
+			route := h.match(publicPath, strings.ToUpper(method))
+			if route == nil || route.Service != service || route.StripPrefix != publicPrefix ||
+				route.Protocol == config.ProtocolGRPC {
+				continue
+			}
+
+			if operation, ok := operation.(map[string]any); ok {
+				annotateOperation(operation, service, route)
+			}
+			exposed[method] = operation
+		}
+
+		if slices.ContainsFunc(httpMethods, func(method string) bool { return exposed[method] != nil }) {
+			paths[publicPath] = exposed
+		}
+	}
+}
+
+// annotateOperation tags an operation with its service and states the
+// authentication the gateway enforces for it
+func annotateOperation(operation map[string]any, service string, route *config.RouteConfig) {
+	if _, ok := operation["tags"]; !ok {
+		operation["tags"] = []any{service}
+	}
+
+	switch route.Auth {
+	case config.AuthNone:
+		operation["security"] = []any{}
+	case config.AuthAdmin:
+		operation["x-required-role"] = string(rbac.RoleAdmin)
+	case config.AuthGuest:
+		// Signing in is optional; guests are identified by the guest_id cookie
+		operation["security"] = []any{
+			map[string]any{},
+			map[string]any{"sessionCookie": []any{}},
+			map[string]any{"bearerAuth": []any{}},
+		}
+	}
+	if len(route.Permissions) > 0 {
+		operation["x-required-permissions"] = route.Permissions
+	}
+	if route.Group != nil {
+		operation["x-required-group-role"] = route.Group.MinRole
+	}
+}
+
+// namespaceRefs rewrites local component references such as
+// "#/components/schemas/User" to "#/components/schemas/<service>.User"
+func namespaceRefs(node any, service string) {
+	switch value := node.(type) {
+	case map[string]any:
+		for key, child := range value {
+			if ref, ok := child.(string); ok && key == "$ref" {
+				value[key] = namespaceRef(ref, service)
+				continue
+			}
+			namespaceRefs(child, service)
+		}
+	case []any:
+		for _, child := range value {
+			namespaceRefs(child, service)
+		}
+	}
+}
+
+func namespaceRef(ref, service string) string {
+	const prefix = "#/components/"
+	rest, ok := strings.CutPrefix(ref, prefix)
+	if !ok {
+		return ref
+	}
+	section, name, ok := strings.Cut(rest, "/")
+	if !ok {
+		return ref
+	}
+	return prefix + section + "/" + service + "." + name
+}
+
+// objectField returns parent[key] as an object, creating it when missing
+func objectField(parent map[string]any, key string) map[string]any {
+	if object, ok := parent[key].(map[string]any); ok {
+		return object
+	}
+	object := make(map[string]any)
+	parent[key] = object
+	return object
+}
@@ -0,0 +1,203 @@
+package fallback
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/cache"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+)
+
+// Fallback response sources reported in the X-Fallback header
+const (
+	sourceLastGood = "last-good"
+	sourceStatic   = "static"
+)
+
+// Headers that must never be replayed from a last-good response
+var unstoredHeaders = []string{"Set-Cookie", "X-Request-Id", "X-Correlation-Id", "X-Cache", "Age"}
+
+// Fallback replaces 502, 503 and 504 responses on GET routes with a degraded
+// answer: the last successful response for the URL, or a static payload.
+type Fallback struct {
+	store       cache.Store
+	keyPrefix   string
+	maxBodySize int64
+}
+
+// New creates the fallback handler. Last-good responses are kept in store
+// under keyPrefix; bodies over maxBodySize are not kept.
+func New(store cache.Store, keyPrefix string, maxBodySize int64) *Fallback {
+	if maxBodySize <= 0 {
+		maxBodySize = 1 << 20
+	}
+	return &Fallback{
+		store:       store,
+		keyPrefix:   keyPrefix + ":",
+		maxBodySize: maxBodySize,
+	}
+}
+
+// Serve calls next and, if it reports the service unavailable, writes the
+// route's fallback instead. authState partitions last-good responses like
+// the response cache does.
+func (f *Fallback) Serve(w http.ResponseWriter, r *http.Request, cfg *config.FallbackConfig, authState string, next http.HandlerFunc) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		next(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	key := f.keyPrefix + r.URL.Path + "?" + r.URL.RawQuery + "|" + authState
+
+	recorder := &fallbackWriter{
+		ResponseWriter: w,
+		header:         make(http.Header),
+		record:         cfg.LastGood && r.Method == http.MethodGet,
+		limit:          f.maxBodySize,
+	}
+	next(recorder, r)
+
+	if !recorder.unavailable {
+		recorder.WriteHeader(http.StatusOK)
+		if recorder.record && recorder.statusCode == http.StatusOK && !recorder.overflow {
+			f.storeLastGood(ctx, key, recorder, cfg.LastGoodTTL.Duration())
+		}
+		return
+	}
+
+	if cfg.LastGood {
+		entry, found, err := f.store.Get(ctx, key)
+		if err != nil {
+			logger.Warn(ctx, "Fallback lookup failed", "error", err)
+		}
+		if found {
+			logger.Warn(ctx, "Serving last good response", "path", r.URL.Path, "upstream_status", recorder.statusCode)
+			writeFallback(w, r, sourceLastGood, entry.StatusCode, entry.Header, entry.Body, entry.StoredAt)
+			return
+		}
+	}
+
+	if len(cfg.Body) > 0 {
+		logger.Warn(ctx, "Serving static fallback", "path", r.URL.Path, "upstream_status", recorder.statusCode)
+		header := http.Header{"Content-Type": []string{"application/json"}}
+		writeFallback(w, r, sourceStatic, cfg.Status, header, cfg.Body, time.Time{})
+		return
+	}
+
+	// Nothing to fall back to: pass the original error through
+	recorder.flushError()
+}
+
+func (f *Fallback) storeLastGood(ctx context.Context, key string, recorder *fallbackWriter, ttl time.Duration) {
+	header := recorder.header.Clone()
+	for _, name := range unstoredHeaders {
+		header.Del(name)
+	}
+
+	entry := &cache.Entry{
+		StatusCode: recorder.statusCode,
+		Header:     header,
+		Body:       recorder.body.Bytes(),
+		StoredAt:   time.Now(),
+	}
+	if err := f.store.Set(context.WithoutCancel(ctx), key, entry, ttl); err != nil {
+		logger.Warn(ctx, "Failed to store last good response", "error", err)
+	}
+}
+
+// writeFallback writes a degraded response. It is marked with X-Fallback and
+// must not be cached by the response cache or clients.
+func writeFallback(w http.ResponseWriter, r *http.Request, source string, statusCode int, header http.Header, body []byte, storedAt time.Time) {
+	target := w.Header()
+	for name, values := range header {
+		target[name] = values
+	}
+	target.Del("Content-Length")
+	target.Set("Cache-Control", "no-store")
+	target.Set("X-Fallback", source)
+	if !storedAt.IsZero() {
+		target.Set("Age", strconv.Itoa(int(time.Since(storedAt).Seconds())))
+	}
+
+	w.WriteHeader(statusCode)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+// fallbackWriter holds back 502, 503 and 504 responses so a fallback can be
+// written instead, and passes every other response through, buffering it
+// when it may become the last good response.
+type fallbackWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+	unavailable bool
+	held        bytes.Buffer
+
+	record   bool
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (fw *fallbackWriter) Header() http.Header {
+	return fw.header
+}
+
+func (fw *fallbackWriter) WriteHeader(code int) {
+	if fw.wroteHeader {
+		return
+	}
+	fw.statusCode = code
+	fw.wroteHeader = true
+
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		fw.unavailable = true
+		return
+	}
+
+	target := fw.ResponseWriter.Header()
+	for name, values := range fw.header {
+		target[name] = values
+	}
+	fw.ResponseWriter.WriteHeader(code)
+}
+
+func (fw *fallbackWriter) Write(b []byte) (int, error) {
+	fw.WriteHeader(http.StatusOK)
+	if fw.unavailable {
+		return fw.held.Write(b)
+	}
+
+	if fw.record && !fw.overflow {
+		if int64(fw.body.Len()+len(b)) > fw.limit {
+			fw.overflow = true
+			fw.body.Reset()
+		} else {
+			fw.body.Write(b)
+		}
+	}
+	return fw.ResponseWriter.Write(b)
+}
+
+// flushError writes the held back error response unchanged
+func (fw *fallbackWriter) flushError() {
+	target := fw.ResponseWriter.Header()
+	for name, values := range fw.header {
+		target[name] = values
+	}
+	fw.ResponseWriter.WriteHeader(fw.statusCode)
+	fw.ResponseWriter.Write(fw.held.Bytes())
+}
+
+func (fw *fallbackWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
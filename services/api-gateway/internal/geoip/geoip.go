@@ -0,0 +1,127 @@
+// Package geoip finds the approximate location of client IP addresses
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/shared/pkg/session"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+)
+
+// Resolver finds the approximate location of an IP address. The zero
+// Location means the address could not be placed.
+type Resolver interface {
+	Locate(ctx context.Context, ip string) (session.Location, error)
+}
+
+// maxCacheEntries bounds the addresses an HTTPResolver remembers
+const maxCacheEntries = 10000
+
+// HTTPResolver asks a lookup service, answering with JSON that has
+// "country", "region" and "city" fields, such as ipinfo.io. Results are
+// cached, and private and loopback addresses are never looked up.
+type HTTPResolver struct {
+	url      string
+	client   *http.Client
+	cacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedLocation
+}
+
+type cachedLocation struct {
+	location session.Location
+	expires  time.Time
+}
+
+// New returns a resolver for the lookup service configured in cfg, or nil
+// when there is none
+func New(cfg config.GeoIPConfig) *HTTPResolver {
+	if cfg.URL == "" {
+		return nil
+	}
+	return &HTTPResolver{
+		url:      cfg.URL,
+		client:   tracing.NewClient("geoip", cfg.Timeout, nil),
+		cacheTTL: cfg.CacheTTL,
+		cache:    make(map[string]cachedLocation),
+	}
+}
+
+// Locate looks ip up, replacing "{ip}" in the service URL with it
+func (g *HTTPResolver) Locate(ctx context.Context, ip string) (session.Location, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return session.Location{}, nil
+	}
+
+	if location, ok := g.cached(ip); ok {
+		return location, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(g.url, "{ip}", url.PathEscape(ip)), nil)
+	if err != nil {
+		return session.Location{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return session.Location{}, fmt.Errorf("failed to look up %s: %w", ip, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return session.Location{}, fmt.Errorf("geoip lookup returned status %d", resp.StatusCode)
+	}
+
+	var location session.Location
+	if err := json.NewDecoder(resp.Body).Decode(&location); err != nil {
+		return session.Location{}, fmt.Errorf("failed to decode geoip response: %w", err)
+	}
+
+	g.store(ip, location)
+	return location, nil
+}
+
+func (g *HTTPResolver) cached(ip string) (session.Location, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	entry, ok := g.cache[ip]
+	if !ok || time.Now().After(entry.expires) {
+		return session.Location{}, false
+	}
+	return entry.location, true
+}
+
+func (g *HTTPResolver) store(ip string, location session.Location) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if len(g.cache) >= maxCacheEntries {
+		for cachedIP, entry := range g.cache {
+			if now.After(entry.expires) {
+				delete(g.cache, cachedIP)
+			}
+		}
+	}
+	// Still full: drop an arbitrary entry rather than grow
+	if len(g.cache) >= maxCacheEntries {
+		for cachedIP := range g.cache {
+			delete(g.cache, cachedIP)
+			break
+		}
+	}
+	g.cache[ip] = cachedLocation{location: location, expires: now.Add(g.cacheTTL)}
+}
@@ -0,0 +1,73 @@
+package graphapi
+
+import (
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// maxQueryDepth is how deeply fields may nest. The schema has cycles, such
+// as order.user.orders, so without a bound one query could fan out into
+// any number of backend calls.
+const maxQueryDepth = 8
+
+// queryDepth returns how deeply the fields of the operations in query nest,
+// following fragments. Introspection fields are not counted, as they only
+// read the schema. Queries that do not parse have depth 0 and are left to
+// graphql.Do to reject.
+func queryDepth(query string) int {
+	document, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return 0
+	}
+
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok && fragment.Name != nil {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	depth := 0
+	for _, definition := range document.Definitions {
+		if operation, ok := definition.(*ast.OperationDefinition); ok {
+			depth = max(depth, selectionDepth(operation.SelectionSet, fragments, make(map[string]bool)))
+		}
+	}
+	return depth
+}
+
+// selectionDepth measures a selection set; visiting holds the fragments
+// being expanded, so fragments spreading themselves end the recursion
+func selectionDepth(set *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, visiting map[string]bool) int {
+	if set == nil {
+		return 0
+	}
+
+	depth := 0
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.Name != nil && strings.HasPrefix(selection.Name.Value, "__") {
+				continue
+			}
+			depth = max(depth, 1+selectionDepth(selection.SelectionSet, fragments, visiting))
+		case *ast.InlineFragment:
+			depth = max(depth, selectionDepth(selection.SelectionSet, fragments, visiting))
+		case *ast.FragmentSpread:
+			if selection.Name == nil {
+				continue
+			}
+			name := selection.Name.Value
+			fragment, ok := fragments[name]
+			if !ok || visiting[name] {
+				continue
+			}
+			visiting[name] = true
+			depth = max(depth, selectionDepth(fragment.SelectionSet, fragments, visiting))
+			delete(visiting, name)
+		}
+	}
+	return depth
+}
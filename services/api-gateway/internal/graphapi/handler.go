@@ -0,0 +1,246 @@
+package graphapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/middleware/gateway"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/graphql-go/graphql"
+)
+
+// maxBatchConcurrency bounds the backend calls made for one loader batch
+const maxBatchConcurrency = 8
+
+// maxUserBatchSize is the most users user-service returns from one batch
+// lookup
+const maxUserBatchSize = 500
+
+// Fetcher makes GET and POST requests to a service and returns the
+// unwrapped response data. *aggregate.Aggregator satisfies it.
+type Fetcher interface {
+	Fetch(ctx context.Context, incoming *http.Request, service, path string) (json.RawMessage, error)
+	Post(ctx context.Context, incoming *http.Request, service, path string, body any) (json.RawMessage, error)
+}
+
+// Handler serves the GraphQL endpoint. Resolvers call the services through
+// the gateway proxy, so they share its breakers, retries and routing.
+type Handler struct {
+	schema  graphql.Schema
+	fetcher Fetcher
+}
+
+func NewHandler(fetcher Fetcher) (*Handler, error) {
+	schema, err := newSchema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build GraphQL schema: %w", err)
+	}
+	return &Handler{schema: schema, fetcher: fetcher}, nil
+}
+
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var gqlReq graphQLRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		gqlReq.Query = query.Get("query")
+		gqlReq.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &gqlReq.Variables); err != nil {
+				utils.SendError(w, http.StatusBadRequest, "Invalid variables")
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&gqlReq); err != nil {
+			utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	default:
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if gqlReq.Query == "" {
+		utils.SendError(w, http.StatusBadRequest, "Query is required")
+		return
+	}
+	if queryDepth(gqlReq.Query) > maxQueryDepth {
+		utils.SendError(w, http.StatusBadRequest, fmt.Sprintf("Query is nested deeper than %d levels", maxQueryDepth))
+		return
+	}
+
+	state := h.newRequestState(r)
+	result := graphql.Do(graphql.Params{
+		Schema:         h.schema,
+		RequestString:  gqlReq.Query,
+		OperationName:  gqlReq.OperationName,
+		VariableValues: gqlReq.Variables,
+		Context:        context.WithValue(r.Context(), stateKey{}, state),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+type stateKey struct{}
+
+// requestState holds the loaders for one GraphQL request so lookups are
+// cached and batched across the whole query, but never shared between users
+type requestState struct {
+	fetcher      Fetcher
+	incoming     *http.Request
+	userID       uint
+	users        *Loader
+	products     *Loader
+	orders       *Loader
+	ordersByUser *Loader
+}
+
+func (h *Handler) newRequestState(r *http.Request) *requestState {
+	state := &requestState{fetcher: h.fetcher, incoming: r}
+	if userSession, ok := gateway.UserSessionFromContext(r.Context()); ok {
+		state.userID = userSession.UserID
+	}
+
+	ctx := r.Context()
+	state.users = NewLoader(ctx, state.fetchUsers)
+	state.products = NewLoader(ctx, state.fetchEach("product", "/products/%d", decodeObject))
+	state.orders = NewLoader(ctx, state.fetchEach("order", "/orders/%d", decodeObject))
+	state.ordersByUser = NewLoader(ctx, state.fetchEach("order", "/orders?user_id=%d", func(data json.RawMessage) (any, error) {
+		return decodeList(data, "orders")
+	}))
+	return state
+}
+
+func stateFromContext(ctx context.Context) *requestState {
+	return ctx.Value(stateKey{}).(*requestState)
+}
+
+// fetchEach builds a BatchFunc for services without a bulk lookup endpoint.
+// The calls for a batch run concurrently, so a list of N parents costs one
+// round of parallel requests rather than N sequential ones.
+func (s *requestState) fetchEach(service, pathFormat string, decode func(json.RawMessage) (any, error)) BatchFunc {
+	return func(ctx context.Context, ids []int) ([]any, []error) {
+		values := make([]any, len(ids))
+		errs := make([]error, len(ids))
+		semaphore := make(chan struct{}, maxBatchConcurrency)
+
+		var wg sync.WaitGroup
+		for i, id := range ids {
+			wg.Add(1)
+			semaphore <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+
+				data, err := s.fetcher.Fetch(ctx, s.incoming, service, fmt.Sprintf(pathFormat, id))
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				values[i], errs[i] = decode(data)
+			}()
+		}
+		wg.Wait()
+
+		return values, errs
+	}
+}
+
+// fetchUsers loads a batch of users from POST /users/batch, one call per
+// maxUserBatchSize IDs
+func (s *requestState) fetchUsers(ctx context.Context, ids []int) ([]any, []error) {
+	values := make([]any, len(ids))
+	errs := make([]error, len(ids))
+
+	for start := 0; start < len(ids); start += maxUserBatchSize {
+		end := min(start+maxUserBatchSize, len(ids))
+		users, err := s.fetchUserBatch(ctx, ids[start:end])
+		for i := start; i < end; i++ {
+			// Users missing from the batch stay nil and resolve to null
+			values[i], errs[i] = users[ids[i]], err
+		}
+	}
+	return values, errs
+}
+
+func (s *requestState) fetchUserBatch(ctx context.Context, ids []int) (map[int]any, error) {
+	data, err := s.fetcher.Post(ctx, s.incoming, "user", "/users/batch", map[string][]int{"ids": ids})
+	if err != nil {
+		return nil, err
+	}
+
+	var batch struct {
+		Users []map[string]any `json:"users"`
+	}
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, fmt.Errorf("unexpected response: %w", err)
+	}
+	users := make(map[int]any, len(batch.Users))
+	for _, user := range batch.Users {
+		if id, ok := user["id"].(float64); ok {
+			users[int(id)] = user
+		}
+	}
+	return users, nil
+}
+
+func (s *requestState) fetchList(service, path, listKey string) (any, error) {
+	data, err := s.fetcher.Fetch(s.incoming.Context(), s.incoming, service, path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeList(data, listKey)
+}
+
+// decodeObject returns nil, not an empty map, for missing data so GraphQL
+// reports the field as null
+func decodeObject(data json.RawMessage) (any, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var object map[string]any
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("unexpected response: %w", err)
+	}
+	if object == nil {
+		return nil, nil
+	}
+	return object, nil
+}
+
+// decodeList accepts either a bare array or a paginated object holding the
+// array under listKey, e.g. {"users": [...], "page": 1}
+func decodeList(data json.RawMessage, listKey string) (any, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var list []any
+	if err := json.Unmarshal(data, &list); err == nil {
+		return list, nil
+	}
+
+	var page map[string]json.RawMessage
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, fmt.Errorf("unexpected response: %w", err)
+	}
+	if raw, ok := page[listKey]; ok {
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, fmt.Errorf("unexpected response: %w", err)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,73 @@
+package graphapi
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchFunc loads the values for a set of IDs. Both slices are aligned with
+// ids; a nil value with a nil error means the record does not exist.
+type BatchFunc func(ctx context.Context, ids []int) ([]any, []error)
+
+// Loader batches and caches ID lookups made while resolving one GraphQL
+// request. Load only queues the ID and returns a thunk; the executor calls
+// thunks after every sibling field at the same depth has been resolved, so
+// the first call sends a single batch for all queued IDs instead of one
+// backend request per parent object.
+type Loader struct {
+	ctx     context.Context
+	batch   BatchFunc
+	mutex   sync.Mutex
+	results map[int]*loaderResult
+	pending []int
+}
+
+type loaderResult struct {
+	value  any
+	err    error
+	loaded bool
+}
+
+func NewLoader(ctx context.Context, batch BatchFunc) *Loader {
+	return &Loader{
+		ctx:     ctx,
+		batch:   batch,
+		results: make(map[int]*loaderResult),
+	}
+}
+
+// Load returns a thunk resolving to the value for id
+func (l *Loader) Load(id int) func() (interface{}, error) {
+	l.mutex.Lock()
+	result, ok := l.results[id]
+	if !ok {
+		result = &loaderResult{}
+		l.results[id] = result
+		l.pending = append(l.pending, id)
+	}
+	l.mutex.Unlock()
+
+	return func() (interface{}, error) {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		if !result.loaded {
+			l.dispatch()
+		}
+		return result.value, result.err
+	}
+}
+
+// dispatch loads every pending ID. The caller must hold the mutex.
+func (l *Loader) dispatch() {
+	ids := l.pending
+	l.pending = nil
+
+	values, errs := l.batch(l.ctx, ids)
+	for i, id := range ids {
+		result := l.results[id]
+		result.loaded = true
+		result.value = values[i]
+		result.err = errs[i]
+	}
+}
@@ -0,0 +1,200 @@
+package graphapi
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/graphql-go/graphql"
+)
+
+// Objects are the decoded JSON returned by the services, so the default
+// resolver reads each field straight from the map by its snake_case name.
+
+func newSchema() (graphql.Schema, error) {
+	var userType, productType, orderType *graphql.Object
+
+	productType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Product",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"name":        &graphql.Field{Type: graphql.String},
+			"description": &graphql.Field{Type: graphql.String},
+			"price":       &graphql.Field{Type: graphql.Float},
+			"stock":       &graphql.Field{Type: graphql.Int},
+			"category_id": &graphql.Field{Type: graphql.Int},
+			"image":       &graphql.Field{Type: graphql.String},
+			"created_at":  &graphql.Field{Type: graphql.String},
+			"updated_at":  &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	orderItemType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "OrderItem",
+		Fields: graphql.Fields{
+			"product_id": &graphql.Field{Type: graphql.Int},
+			"quantity":   &graphql.Field{Type: graphql.Int},
+			"price":      &graphql.Field{Type: graphql.Float},
+			"product": &graphql.Field{
+				Type:    productType,
+				Resolve: resolveByField("product_id", func(s *requestState) *Loader { return s.products }),
+			},
+		},
+	})
+
+	orderType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Order",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":         &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"user_id":    &graphql.Field{Type: graphql.Int},
+				"status":     &graphql.Field{Type: graphql.String},
+				"total":      &graphql.Field{Type: graphql.Float},
+				"items":      &graphql.Field{Type: graphql.NewList(orderItemType)},
+				"created_at": &graphql.Field{Type: graphql.String},
+				"updated_at": &graphql.Field{Type: graphql.String},
+				"user": &graphql.Field{
+					Type:    userType,
+					Resolve: resolveByField("user_id", func(s *requestState) *Loader { return s.users }),
+				},
+			}
+		}),
+	})
+
+	userType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":             &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"public_id":      &graphql.Field{Type: graphql.String},
+				"name":           &graphql.Field{Type: graphql.String},
+				"email":          &graphql.Field{Type: graphql.String},
+				"email_verified": &graphql.Field{Type: graphql.Boolean},
+				"image":          &graphql.Field{Type: graphql.String},
+				"role":           &graphql.Field{Type: graphql.String},
+				"created_at":     &graphql.Field{Type: graphql.String},
+				"updated_at":     &graphql.Field{Type: graphql.String},
+				"orders": &graphql.Field{
+					Type:    graphql.NewList(orderType),
+					Resolve: resolveByField("id", func(s *requestState) *Loader { return s.ordersByUser }),
+				},
+			}
+		}),
+	})
+
+	pageArgs := graphql.FieldConfigArgument{
+		"page":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 1},
+		"limit": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 20},
+	}
+	idArgs := graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": &graphql.Field{
+				Type: userType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					state := stateFromContext(p.Context)
+					if state.userID == 0 {
+						return nil, errors.New("authentication required")
+					}
+					return state.users.Load(int(state.userID)), nil
+				},
+			},
+			"user": &graphql.Field{
+				Type: userType,
+				Args: idArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return stateFromContext(p.Context).users.Load(p.Args["id"].(int)), nil
+				},
+			},
+			"users": &graphql.Field{
+				Type:    graphql.NewList(userType),
+				Args:    pageArgs,
+				Resolve: resolveList("user", "/users", "users"),
+			},
+			"product": &graphql.Field{
+				Type: productType,
+				Args: idArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return stateFromContext(p.Context).products.Load(p.Args["id"].(int)), nil
+				},
+			},
+			"products": &graphql.Field{
+				Type:    graphql.NewList(productType),
+				Args:    pageArgs,
+				Resolve: resolveList("product", "/products", "products"),
+			},
+			"order": &graphql.Field{
+				Type: orderType,
+				Args: idArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return stateFromContext(p.Context).orders.Load(p.Args["id"].(int)), nil
+				},
+			},
+			"orders": &graphql.Field{
+				Type: graphql.NewList(orderType),
+				Args: pageArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					state := stateFromContext(p.Context)
+					if state.userID == 0 {
+						return nil, errors.New("authentication required")
+					}
+					query := pageQuery(p.Args)
+					query.Set("user_id", strconv.FormatUint(uint64(state.userID), 10))
+					return state.fetchList("order", "/orders?"+query.Encode(), "orders")
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// resolveByField loads a related object through one of the request's loaders,
+// keyed by an integer field of the parent object
+func resolveByField(field string, loader func(*requestState) *Loader) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		id, ok := intField(p.Source, field)
+		if !ok {
+			return nil, nil
+		}
+		return loader(stateFromContext(p.Context)).Load(id), nil
+	}
+}
+
+// resolveList fetches one page of a collection endpoint
+func resolveList(service, path, listKey string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		return stateFromContext(p.Context).fetchList(service, path+"?"+pageQuery(p.Args).Encode(), listKey)
+	}
+}
+
+// pageQuery sends page and limit, which the services read with
+// utils.ParsePagination; limit is clamped to the most the REST lists return
+func pageQuery(args map[string]interface{}) url.Values {
+	page := max(args["page"].(int), 1)
+	limit := min(max(args["limit"].(int), 1), utils.DefaultPageLimits.Max)
+
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("limit", strconv.Itoa(limit))
+	return query
+}
+
+func intField(source any, field string) (int, bool) {
+	object, ok := source.(map[string]any)
+	if !ok {
+		return 0, false
+	}
+	switch value := object[field].(type) {
+	case float64:
+		return int(value), true
+	case int:
+		return value, true
+	}
+	return 0, false
+}
@@ -4,26 +4,70 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
 	"time"
 
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/audit"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/clientip"
 	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/geoip"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
 	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
 	"github.com/dhekaag/golang-microservices/shared/pkg/session"
+	"github.com/dhekaag/golang-microservices/shared/pkg/token"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+	"github.com/dhekaag/golang-microservices/shared/pkg/userrpc"
 	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
 )
 
+var (
+	// errInvalidCredentials answers INVALID_CREDENTIALS, so clients can tell
+	// a wrong password from other failures
+	errInvalidCredentials = appErrors.NewInvalidCredentialsError("Invalid credentials", nil)
+	// errAccountDeactivated is returned for correct credentials of a
+	// deactivated account
+	errAccountDeactivated = errors.New("account is deactivated")
+	// errUserNotFound is returned when user-service has no account with the
+	// ID looked up, as after it was deleted
+	errUserNotFound = errors.New("user not found")
+)
+
+// refreshPath is the only path the refresh token cookie is sent to
+const refreshPath = "/api/v1/auth/refresh"
+
 type AuthHandler struct {
 	userServiceURL string
 	httpClient     *http.Client
 	sessionManager *session.SessionManager
+	// tokenManager is set in JWT mode, where no sessions are stored
+	tokenManager *token.Manager
+	loginGuard   *LoginGuard
+	audit        *audit.Logger
+	loginHooks   []LoginHook
+	// userRPC checks credentials over gRPC when user-service has a gRPC
+	// address; HTTP remains the fallback
+	userRPC *userrpc.Client
+	// geo locates new sessions; nil leaves their location empty
+	geo geoip.Resolver
 }
 
+// LoginHook runs after a user signs in, before the response is written, so
+// it may set cookies on w
+type LoginHook func(w http.ResponseWriter, r *http.Request, userData *UserLoginData)
+
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// RememberMe starts a long-lived session instead of a normal one
+	RememberMe bool `json:"remember_me"`
 }
 
 type LoginResponse struct {
@@ -31,20 +75,41 @@ type LoginResponse struct {
 	Message   string        `json:"message"`
 	Data      UserLoginData `json:"data"`
 	SessionID string        `json:"session_id,omitempty"`
+	// RefreshToken is returned in both modes and shadows the field of the
+	// embedded TokenPair, so issueTokens copies it
+	RefreshToken string `json:"refresh_token,omitempty"`
+	*token.TokenPair
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 type UserLoginData struct {
-	ID    uint   `json:"id"`
-	Email string `json:"email"`
-	Role  string `json:"role"`
-	Name  string `json:"name"`
+	ID     uint              `json:"id"`
+	Email  string            `json:"email"`
+	Role   string            `json:"role"`
+	Name   string            `json:"name"`
+	Groups []rbac.Membership `json:"groups,omitempty"`
+}
+
+// account is a user as user-service knows them now
+type account struct {
+	UserLoginData
+	IsActive bool `json:"is_active"`
+	// PasswordResetAt is when the password was last reset by link
+	PasswordResetAt *time.Time `json:"password_reset_at,omitempty"`
 }
 
 type LogoutRequest struct {
 	SessionID string `json:"session_id"`
 }
 
-func NewAuthHandler(config *config.ServicesConfig, sessionManager *session.SessionManager) *AuthHandler {
+// NewAuthHandler creates the gateway auth handler. When tokenManager is
+// non-nil the handler runs in stateless JWT mode and sessionManager may be nil.
+// Password logins are throttled by loginGuard, which may be nil. Logins,
+// logouts and session revocations are recorded in auditLog.
+func NewAuthHandler(config *config.ServicesConfig, sessionManager *session.SessionManager, tokenManager *token.Manager, loginGuard *LoginGuard, auditLog *audit.Logger) *AuthHandler {
 	// Configure HTTP client with optimized settings
 	transport := &http.Transport{
 		MaxIdleConns:          100,
@@ -57,11 +122,12 @@ func NewAuthHandler(config *config.ServicesConfig, sessionManager *session.Sessi
 
 	return &AuthHandler{
 		userServiceURL: config.UserService,
-		httpClient: &http.Client{
-			Timeout:   15 * time.Second,
-			Transport: transport,
-		},
+		httpClient:     tracing.NewClient("user-service", 15*time.Second, transport),
 		sessionManager: sessionManager,
+		tokenManager:   tokenManager,
+		loginGuard:     loginGuard,
+		audit:          auditLog,
+		userRPC:        newUserRPCClient(config.GRPC["user"]),
 	}
 }
 
@@ -71,9 +137,9 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	r = r.WithContext(ctx)
 
 	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Warn(ctx, "Invalid request body", "error", err)
-		utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+	if appErr := utils.DecodeJSON(r, &req); appErr != nil {
+		logger.Warn(ctx, "Invalid request body", "error", appErr)
+		appErrors.WriteErrorResponse(w, appErr)
 		return
 	}
 
@@ -83,35 +149,192 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userData, err := h.validateCredentials(ctx, req.Email, req.Password)
+	clientIP := getClientIP(r)
+	if lockedFor := h.loginGuard.LockedFor(ctx, req.Email, clientIP); lockedFor > 0 {
+		logger.Warn(ctx, "Login rejected during lockout", "email", req.Email, "ip", clientIP)
+		h.audit.Record(r, audit.Event{
+			Action:     audit.ActionLogin,
+			Outcome:    audit.OutcomeFailure,
+			ActorEmail: req.Email,
+			Details:    map[string]any{"reason": "locked_out"},
+		})
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedFor.Seconds()))))
+		utils.SendError(w, http.StatusTooManyRequests, "Too many failed login attempts, please try again later")
+		return
+	}
+	h.loginGuard.Delay(ctx, req.Email)
+
+	userData, err := h.validateCredentials(r, req.Email, req.Password)
 	if err != nil {
 		logger.Warn(ctx, "Login validation failed", "error", err, "email", req.Email)
+		// Only wrong credentials count towards a lockout, not outages
+		if errors.Is(err, errInvalidCredentials) {
+			h.recordLoginFailure(r, req.Email, clientIP)
+		}
+		if errors.Is(err, errAccountDeactivated) {
+			utils.SendError(w, http.StatusForbidden, "Account is deactivated")
+			return
+		}
+		if errors.Is(err, errInvalidCredentials) {
+			utils.SendAppError(w, err)
+			return
+		}
 		utils.SendError(w, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
+	h.loginGuard.Succeed(ctx, req.Email)
 
-	sessionID, err := utils.GenerateSessionID()
-	if err != nil {
-		logger.Error(ctx, "Failed to generate session ID", "error", err)
-		utils.SendError(w, http.StatusInternalServerError, "Failed to create session")
+	response, ok := h.signIn(w, r, userData, req.RememberMe, "password")
+	if !ok {
 		return
 	}
+	utils.SendSuccess(w, http.StatusOK, "Login successful", response)
+}
+
+// OnLogin registers hook to run on every successful login. Hooks are not
+// safe to add while requests are served.
+func (h *AuthHandler) OnLogin(hook LoginHook) {
+	h.loginHooks = append(h.loginHooks, hook)
+}
+
+// SetGeoResolver locates new sessions with resolver. It is not safe to call
+// while requests are served.
+func (h *AuthHandler) SetGeoResolver(resolver geoip.Resolver) {
+	h.geo = resolver
+}
+
+// signIn starts a session for a user who signed in with method, or issues
+// a token pair in JWT mode, and then records the login. It returns the
+// login response, or false once it has answered with an error.
+func (h *AuthHandler) signIn(w http.ResponseWriter, r *http.Request, userData *UserLoginData, rememberMe bool, method string) (*LoginResponse, bool) {
+	response := &LoginResponse{
+		Success: true,
+		Message: "Login successful",
+		Data:    *userData,
+	}
+
+	if h.tokenManager != nil {
+		tokens, err := h.issuePair(userData)
+		if err != nil {
+			logger.ErrorWithStack(r.Context(), err, "Failed to issue tokens")
+			utils.SendError(w, http.StatusInternalServerError, "Failed to issue tokens")
+			return nil, false
+		}
+		response.RefreshToken = tokens.RefreshToken
+		response.TokenPair = tokens
+	} else {
+		sessionID, refreshToken, err := h.startSession(w, r, userData, rememberMe, method)
+		if err != nil {
+			h.sendStartSessionError(w, r, userData, method, err)
+			return nil, false
+		}
+		response.SessionID = sessionID
+		response.RefreshToken = refreshToken
+	}
+
+	h.recordLogin(w, r, userData, method)
+	return response, true
+}
+
+// recordLogin audits a successful login with method, "password" or the
+// OAuth provider, and runs the login hooks
+func (h *AuthHandler) recordLogin(w http.ResponseWriter, r *http.Request, userData *UserLoginData, method string) {
+	h.audit.Record(r, audit.Event{
+		Action:     audit.ActionLogin,
+		ActorID:    userData.ID,
+		ActorEmail: userData.Email,
+		Details:    map[string]any{"method": method},
+	})
+	for _, hook := range h.loginHooks {
+		hook(w, r, userData)
+	}
+}
+
+// recordLoginFailure counts a failed password login and audits it, along
+// with any lockout it triggers
+func (h *AuthHandler) recordLoginFailure(r *http.Request, email, clientIP string) {
+	h.audit.Record(r, audit.Event{
+		Action:     audit.ActionLogin,
+		Outcome:    audit.OutcomeFailure,
+		ActorEmail: email,
+	})
+
+	for _, key := range h.loginGuard.Fail(r.Context(), email, clientIP) {
+		logger.Warn(r.Context(), "Login locked out", "key", key)
+		h.audit.Record(r, audit.Event{
+			Action:     audit.ActionLoginLockout,
+			ActorEmail: email,
+			Target:     key,
+		})
+	}
+}
 
+// startSession stores a new session for the user with its refresh token
+// and sets both cookies. A remember-me session lasts longer, and so does
+// its cookie. method is how the user signed in, as passed to recordLogin.
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, userData *UserLoginData, rememberMe bool, method string) (sessionID, refreshToken string, err error) {
+	now := time.Now()
+	clientIP := getClientIP(r)
 	userSession := &session.UserSession{
-		UserID:    userData.ID,
-		Email:     userData.Email,
-		Role:      userData.Role,
-		Name:      userData.Name,
-		IPAddress: getClientIP(r),
-		UserAgent: r.UserAgent(),
+		UserID:      userData.ID,
+		Email:       userData.Email,
+		Role:        string(rbac.NormalizeRole(userData.Role)),
+		Name:        userData.Name,
+		CreatedAt:   now,
+		LastSeen:    now,
+		IPAddress:   clientIP,
+		UserAgent:   r.UserAgent(),
+		DeviceName:  parseUserAgent(r.UserAgent()).Name(),
+		Location:    h.locate(r.Context(), clientIP),
+		LoginMethod: method,
+		RememberMe:  rememberMe,
+		Groups:      userData.Groups,
 	}
 
-	if err := h.sessionManager.CreateSession(ctx, sessionID, userSession); err != nil {
-		logger.Error(ctx, "Failed to create session", "error", err)
-		utils.SendError(w, http.StatusInternalServerError, "Failed to create session")
+	sessionID, refreshToken, err = h.sessionManager.StartSession(r.Context(), userSession)
+	if err != nil {
+		return "", "", err
+	}
+
+	setSessionCookies(w, sessionID, refreshToken, h.sessionManager.Lifetime(userSession))
+	return sessionID, refreshToken, nil
+}
+
+// sendStartSessionError answers a login with method whose session could not
+// be started. A login refused at the session limit is audited as failed.
+func (h *AuthHandler) sendStartSessionError(w http.ResponseWriter, r *http.Request, userData *UserLoginData, method string, err error) {
+	if errors.Is(err, session.ErrTooManySessions) {
+		logger.Warn(r.Context(), "Login rejected at the session limit", "user_id", userData.ID)
+		h.audit.Record(r, audit.Event{
+			Action:     audit.ActionLogin,
+			Outcome:    audit.OutcomeFailure,
+			ActorID:    userData.ID,
+			ActorEmail: userData.Email,
+			Details:    map[string]any{"method": method, "reason": "too_many_sessions"},
+		})
+		utils.SendError(w, http.StatusConflict, "Too many active sessions, sign out of another one first")
 		return
 	}
+	logger.ErrorWithStack(r.Context(), err, "Failed to create session")
+	utils.SendError(w, http.StatusInternalServerError, "Failed to create session")
+}
 
+// locate finds the approximate location of ip. A failed lookup only costs
+// the session its location, so it is logged rather than failing the login.
+func (h *AuthHandler) locate(ctx context.Context, ip string) session.Location {
+	if h.geo == nil {
+		return session.Location{}
+	}
+	location, err := h.geo.Locate(ctx, ip)
+	if err != nil {
+		logger.Warn(ctx, "Failed to locate client", "error", err)
+	}
+	return location
+}
+
+// setSessionCookies sets the session cookie, kept for lifetime, and the
+// refresh token cookie, which is only sent to the refresh endpoint
+func setSessionCookies(w http.ResponseWriter, sessionID, refreshToken string, lifetime time.Duration) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_id",
 		Value:    sessionID,
@@ -119,20 +342,143 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		HttpOnly: true,
 		Secure:   false,
 		SameSite: http.SameSiteLaxMode,
-		MaxAge:   int(24 * time.Hour.Seconds()),
+		MaxAge:   int(lifetime.Seconds()),
 	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		Path:     refreshPath,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
 
-	response := LoginResponse{
-		Success:   true,
-		Message:   "Login successful",
-		Data:      *userData,
-		SessionID: sessionID,
+// clearSessionCookies deletes the cookies set by setSessionCookies
+func clearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_id",
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1, // Delete cookie
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    "",
+		Path:     refreshPath,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1,
+	})
+}
+
+// completeLogin signs in a user verified by another flow, such as OAuth or
+// a magic link, named by method. In JWT mode it returns tokens; otherwise it
+// starts a session and redirects to successRedirect when set.
+func (h *AuthHandler) completeLogin(w http.ResponseWriter, r *http.Request, userData *UserLoginData, method, successRedirect string) {
+	response, ok := h.signIn(w, r, userData, false, method)
+	if !ok {
+		return
 	}
 
+	if h.tokenManager == nil && successRedirect != "" {
+		http.Redirect(w, r, successRedirect, http.StatusFound)
+		return
+	}
 	utils.SendSuccess(w, http.StatusOK, "Login successful", response)
 }
 
-func (h *AuthHandler) validateCredentials(ctx context.Context, email, password string) (*UserLoginData, error) {
+// issuePair signs a token pair for the user
+func (h *AuthHandler) issuePair(userData *UserLoginData) (*token.TokenPair, error) {
+	return h.tokenManager.IssuePair(token.Claims{
+		UserID: userData.ID,
+		Email:  userData.Email,
+		Role:   string(rbac.NormalizeRole(userData.Role)),
+		Name:   userData.Name,
+		Groups: userData.Groups,
+	})
+}
+
+func (h *AuthHandler) issueTokens(w http.ResponseWriter, r *http.Request, userData *UserLoginData, message string) {
+	tokens, err := h.issuePair(userData)
+	if err != nil {
+		logger.ErrorWithStack(r.Context(), err, "Failed to issue tokens")
+		utils.SendError(w, http.StatusInternalServerError, "Failed to issue tokens")
+		return
+	}
+
+	response := LoginResponse{
+		Success:      true,
+		Message:      message,
+		Data:         *userData,
+		RefreshToken: tokens.RefreshToken,
+		TokenPair:    tokens,
+	}
+
+	utils.SendSuccess(w, http.StatusOK, message, response)
+}
+
+// lookupUser asks user-service for the account with id, failing with
+// errUserNotFound when there is none
+func (h *AuthHandler) lookupUser(r *http.Request, id uint) (*account, error) {
+	if h.userRPC != nil {
+		if user, ok, err := h.lookupUserRPC(r, id); ok {
+			return user, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/users/%d", h.userServiceURL, id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	forwardClient(req, r)
+	if requestID := logger.GetRequestID(ctx); requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+	if correlationID := logger.GetCorrelationID(ctx); correlationID != "" {
+		req.Header.Set("X-Correlation-ID", correlationID)
+	}
+
+	resp, err := h.httpClient.Do(req)
+	middleware.RecordUpstream(r.Context(), "user", time.Since(start))
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request to user service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errUserNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user service returned status %d", resp.StatusCode)
+	}
+
+	var userResponse struct {
+		Data account `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&userResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse user service response: %w", err)
+	}
+	return &userResponse.Data, nil
+}
+
+func (h *AuthHandler) validateCredentials(r *http.Request, email, password string) (*UserLoginData, error) {
+	if h.userRPC != nil {
+		if userData, ok, err := h.validateCredentialsRPC(r, email, password); ok {
+			return userData, err
+		}
+	}
+
+	ctx := r.Context()
 	start := time.Now()
 
 	// Get request context information
@@ -167,6 +513,7 @@ func (h *AuthHandler) validateCredentials(ctx context.Context, email, password s
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("User-Agent", "API-Gateway/1.0")
 	req.Header.Set("Connection", "keep-alive")
+	forwardClient(req, r)
 
 	if requestID != "" {
 		req.Header.Set("X-Request-ID", requestID)
@@ -177,6 +524,7 @@ func (h *AuthHandler) validateCredentials(ctx context.Context, email, password s
 
 	// Make the request
 	resp, err := h.httpClient.Do(req)
+	middleware.RecordUpstream(ctx, "user", time.Since(start))
 	if err != nil {
 		duration := time.Since(start)
 		logger.Error(ctx, "❌ User service call failed",
@@ -193,7 +541,7 @@ func (h *AuthHandler) validateCredentials(ctx context.Context, email, password s
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		logger.Error(ctx, "Failed to read response body", "error", err)
+		logger.ErrorWithStack(ctx, err, "Failed to read response body")
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
@@ -206,7 +554,10 @@ func (h *AuthHandler) validateCredentials(ctx context.Context, email, password s
 		)
 
 		if resp.StatusCode == http.StatusUnauthorized {
-			return nil, fmt.Errorf("invalid credentials")
+			return nil, errInvalidCredentials
+		}
+		if resp.StatusCode == http.StatusForbidden {
+			return nil, errAccountDeactivated
 		}
 		return nil, fmt.Errorf("user service returned status %d", resp.StatusCode)
 	}
@@ -219,7 +570,7 @@ func (h *AuthHandler) validateCredentials(ctx context.Context, email, password s
 	}
 
 	if err := json.Unmarshal(body, &userResponse); err != nil {
-		logger.Error(ctx, "Failed to parse user service response", "error", err, "body", string(body))
+		logger.ErrorWithStack(ctx, err, "Failed to parse user service response", "body", string(body))
 		return nil, fmt.Errorf("failed to parse user service response: %w", err)
 	}
 
@@ -239,22 +590,26 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// JWTs are stateless; the client discards its tokens
+	if h.tokenManager != nil {
+		utils.SendSuccess(w, http.StatusOK, "Logout successful", nil)
+		return
+	}
+
+	// Audit with the session's user before it is gone
+	logoutEvent := audit.Event{Action: audit.ActionLogout}
+	if userSession, err := h.sessionManager.GetSession(r.Context(), sessionID); err == nil {
+		logoutEvent.ActorID, logoutEvent.ActorEmail = userSession.UserID, userSession.Email
+	}
+
 	// Delete session from Redis
 	if err := h.sessionManager.DeleteSession(r.Context(), sessionID); err != nil {
 		// Log error but don't fail the logout
 		fmt.Printf("Failed to delete session: %v\n", err)
 	}
+	h.audit.Record(r, logoutEvent)
 
-	// Clear session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   -1, // Delete cookie
-	})
+	clearSessionCookies(w)
 
 	utils.SendSuccess(w, http.StatusOK, "Logout successful", nil)
 }
@@ -264,6 +619,21 @@ func (h *AuthHandler) ValidateSession(ctx context.Context, sessionID string) (*s
 		return nil, fmt.Errorf("empty session ID")
 	}
 
+	if h.tokenManager != nil {
+		claims, err := h.tokenManager.Parse(sessionID, token.TypeAccess)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token: %w", err)
+		}
+		return &session.UserSession{
+			UserID:   claims.UserID,
+			Name:     claims.Name,
+			Email:    claims.Email,
+			Role:     claims.Role,
+			Groups:   claims.Groups,
+			LastSeen: time.Now(),
+		}, nil
+	}
+
 	userSession, err := h.sessionManager.GetSession(ctx, sessionID)
 	if err != nil {
 		return nil, fmt.Errorf("invalid session: %w", err)
@@ -278,7 +648,56 @@ func (h *AuthHandler) IsAdmin(ctx context.Context, sessionID string) bool {
 		return false
 	}
 
-	return userSession.Role == "admin"
+	return userSession.IsAdmin()
+}
+
+// SessionStats summarises the active sessions. In JWT mode nothing is
+// stored, so only the mode is reported.
+type SessionStats struct {
+	Mode        string         `json:"mode"`
+	Active      int            `json:"active"`
+	Remembered  int            `json:"remembered"`
+	UniqueUsers int            `json:"unique_users"`
+	ByRole      map[string]int `json:"by_role,omitempty"`
+}
+
+func (h *AuthHandler) SessionStats(ctx context.Context) (*SessionStats, error) {
+	if h.tokenManager != nil {
+		return &SessionStats{Mode: config.AuthModeJWT}, nil
+	}
+
+	sessions, err := h.sessionManager.GetSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &SessionStats{
+		Mode:   config.AuthModeSession,
+		Active: len(sessions),
+		ByRole: make(map[string]int),
+	}
+	users := make(map[uint]bool)
+	for _, userSession := range sessions {
+		users[userSession.UserID] = true
+		stats.ByRole[string(rbac.NormalizeRole(userSession.Role))]++
+		if userSession.RememberMe {
+			stats.Remembered++
+		}
+	}
+	stats.UniqueUsers = len(users)
+	return stats, nil
+}
+
+// errNoSessions is returned for session operations in JWT mode
+var errNoSessions = errors.New("sessions are not stored in JWT mode")
+
+// RevokeRememberedSessions ends the remember-me sessions of a user, or of
+// all users when userID is 0, and returns how many were ended
+func (h *AuthHandler) RevokeRememberedSessions(ctx context.Context, userID uint) (int, error) {
+	if h.tokenManager != nil {
+		return 0, errNoSessions
+	}
+	return h.sessionManager.DeleteRememberedSessions(ctx, userID)
 }
 
 func (h *AuthHandler) GetUserInfo(w http.ResponseWriter, r *http.Request) {
@@ -297,22 +716,144 @@ func (h *AuthHandler) GetUserInfo(w http.ResponseWriter, r *http.Request) {
 	utils.SendSuccess(w, http.StatusOK, "User info retrieved", userSession)
 }
 
+// RefreshSession exchanges a refresh token, sent in the body or as the
+// refresh_token cookie, for a new session and refresh token. The old
+// session ends; reusing a rotated refresh token revokes its whole family.
 func (h *AuthHandler) RefreshSession(w http.ResponseWriter, r *http.Request) {
-	sessionID := h.extractSessionID(r)
-	if sessionID == "" {
-		utils.SendError(w, http.StatusUnauthorized, "No active session")
+	if h.tokenManager != nil {
+		h.refreshTokens(w, r)
 		return
 	}
 
-	if err := h.sessionManager.ExtendSession(r.Context(), sessionID); err != nil {
-		utils.SendError(w, http.StatusUnauthorized, "Failed to refresh session")
+	var req RefreshRequest
+	if r.Body != nil {
+		// An empty body is allowed when the token is sent as a cookie
+		_ = json.NewDecoder(r.Body).Decode(&req)
+	}
+	if req.RefreshToken == "" {
+		if cookie, err := r.Cookie("refresh_token"); err == nil {
+			req.RefreshToken = cookie.Value
+		}
+	}
+	if req.RefreshToken == "" {
+		utils.SendError(w, http.StatusUnauthorized, "Missing refresh token")
 		return
 	}
 
-	utils.SendSuccess(w, http.StatusOK, "Session refreshed", nil)
+	refreshed, err := h.sessionManager.RotateRefreshToken(r.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, session.ErrRefreshTokenReused) {
+			logger.Warn(r.Context(), "Rotated refresh token reused, session revoked")
+			h.audit.Record(r, audit.Event{
+				Action:  audit.ActionRefreshReuse,
+				Outcome: audit.OutcomeFailure,
+			})
+		} else if !errors.Is(err, session.ErrInvalidRefreshToken) {
+			logger.ErrorWithStack(r.Context(), err, "Failed to refresh session")
+		}
+		clearSessionCookies(w)
+		utils.SendError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	setSessionCookies(w, refreshed.SessionID, refreshed.RefreshToken, h.sessionManager.Lifetime(refreshed.Session))
+	utils.SendSuccess(w, http.StatusOK, "Session refreshed", LoginResponse{
+		Success: true,
+		Message: "Session refreshed",
+		Data: UserLoginData{
+			ID:     refreshed.Session.UserID,
+			Email:  refreshed.Session.Email,
+			Role:   refreshed.Session.Role,
+			Name:   refreshed.Session.Name,
+			Groups: refreshed.Session.Groups,
+		},
+		SessionID:    refreshed.SessionID,
+		RefreshToken: refreshed.RefreshToken,
+	})
+}
+
+// refreshTokens exchanges a refresh token, sent in the body or as a bearer
+// token, for a new token pair.
+func (h *AuthHandler) refreshTokens(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if r.Body != nil {
+		// An empty body is allowed when the token is sent in a header
+		_ = json.NewDecoder(r.Body).Decode(&req)
+	}
+	if req.RefreshToken == "" {
+		req.RefreshToken = h.extractSessionID(r)
+	}
+	if req.RefreshToken == "" {
+		utils.SendError(w, http.StatusUnauthorized, "Missing refresh token")
+		return
+	}
+
+	claims, err := h.tokenManager.Parse(req.RefreshToken, token.TypeRefresh)
+	if err != nil {
+		logger.Warn(r.Context(), "Refresh token rejected", "error", err)
+		utils.SendError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	// The token pair is reissued from the account as it is now, so a
+	// deleted or deactivated user, a password reset or a changed role does
+	// not outlive the next refresh, as with sessions
+	user, err := h.lookupUser(r, claims.UserID)
+	if err != nil {
+		if errors.Is(err, errUserNotFound) {
+			logger.Warn(r.Context(), "Refresh token of unknown user rejected", "user_id", claims.UserID)
+			utils.SendError(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+		logger.ErrorWithStack(r.Context(), err, "Failed to look up user for refresh", "user_id", claims.UserID)
+		utils.SendError(w, http.StatusBadGateway, "Failed to refresh tokens")
+		return
+	}
+	if !user.IsActive {
+		logger.Warn(r.Context(), "Refresh token of deactivated user rejected", "user_id", claims.UserID)
+		utils.SendError(w, http.StatusForbidden, "Account is deactivated")
+		return
+	}
+	// iat only has seconds, so tokens of the second of the reset still pass
+	if user.PasswordResetAt != nil && (claims.IssuedAt == nil || claims.IssuedAt.Time.Before(user.PasswordResetAt.Truncate(time.Second))) {
+		logger.Warn(r.Context(), "Refresh token issued before a password reset rejected", "user_id", claims.UserID)
+		utils.SendError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	userData := &user.UserLoginData
+	// Memberships are those of sign-in, as with sessions
+	userData.Groups = claims.Groups
+
+	if err := h.tokenManager.Redeem(r.Context(), claims); err != nil {
+		if errors.Is(err, token.ErrTokenReused) {
+			logger.Warn(r.Context(), "Used refresh token presented again", "user_id", claims.UserID)
+			h.audit.Record(r, audit.Event{
+				Action:  audit.ActionRefreshReuse,
+				Outcome: audit.OutcomeFailure,
+				ActorID: claims.UserID,
+			})
+			utils.SendError(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+		if errors.Is(err, token.ErrInvalidToken) {
+			utils.SendError(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+		logger.ErrorWithStack(r.Context(), err, "Failed to redeem refresh token")
+		utils.SendError(w, http.StatusInternalServerError, "Failed to refresh tokens")
+		return
+	}
+
+	h.issueTokens(w, r, userData, "Token refreshed")
 }
 
 func (h *AuthHandler) LogoutAllSessions(w http.ResponseWriter, r *http.Request) {
+	if h.tokenManager != nil {
+		utils.SendError(w, http.StatusNotImplemented, "Logout of all sessions is not supported in JWT mode")
+		return
+	}
+
 	sessionID := h.extractSessionID(r)
 	if sessionID == "" {
 		utils.SendError(w, http.StatusUnauthorized, "No active session")
@@ -329,21 +870,164 @@ func (h *AuthHandler) LogoutAllSessions(w http.ResponseWriter, r *http.Request)
 		utils.SendError(w, http.StatusInternalServerError, "Failed to logout all sessions")
 		return
 	}
-
-	// Clear current session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   -1,
+	h.audit.Record(r, audit.Event{
+		Action:     audit.ActionSessionsRevoked,
+		ActorID:    userSession.UserID,
+		ActorEmail: userSession.Email,
+		Target:     strconv.FormatUint(uint64(userSession.UserID), 10),
 	})
 
+	// Clear current session cookies
+	clearSessionCookies(w)
+
 	utils.SendSuccess(w, http.StatusOK, "All sessions logged out", nil)
 }
 
+// SessionInfo describes one of the caller's sessions. ID is the session's
+// public ID, never the session ID itself.
+type SessionInfo struct {
+	ID        string     `json:"id"`
+	CreatedAt time.Time  `json:"created_at,omitzero"`
+	LastSeen  time.Time  `json:"last_seen"`
+	IPAddress string     `json:"ip_address"`
+	UserAgent string     `json:"user_agent"`
+	Device    DeviceInfo `json:"device"`
+	// DeviceName describes the device, e.g. "Chrome on macOS"
+	DeviceName string           `json:"device_name"`
+	Location   session.Location `json:"location,omitzero"`
+	// LoginMethod is "password", "magic_link" or the OAuth provider; empty
+	// for sessions started before it was recorded
+	LoginMethod string `json:"login_method,omitempty"`
+	// RememberMe marks long-lived sessions
+	RememberMe bool `json:"remember_me"`
+	Current    bool `json:"current"`
+}
+
+// ListSessions returns the caller's active sessions, most recently used first
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	if h.tokenManager != nil {
+		utils.SendError(w, http.StatusNotImplemented, "Session listing is not supported in JWT mode")
+		return
+	}
+
+	sessionID := h.extractSessionID(r)
+	if sessionID == "" {
+		utils.SendError(w, http.StatusUnauthorized, "No active session")
+		return
+	}
+
+	userSession, err := h.ValidateSession(r.Context(), sessionID)
+	if err != nil {
+		utils.SendError(w, http.StatusUnauthorized, "Invalid session")
+		return
+	}
+
+	userSessions, err := h.sessionManager.GetUserSessions(r.Context(), userSession.UserID)
+	if err != nil {
+		logger.ErrorWithStack(r.Context(), err, "Failed to list sessions")
+		utils.SendError(w, http.StatusInternalServerError, "Failed to list sessions")
+		return
+	}
+
+	sessions := make([]SessionInfo, 0, len(userSessions))
+	for id, userSession := range userSessions {
+		device := parseUserAgent(userSession.UserAgent)
+		deviceName := userSession.DeviceName
+		if deviceName == "" {
+			deviceName = device.Name()
+		}
+		sessions = append(sessions, SessionInfo{
+			ID:          session.PublicID(id),
+			CreatedAt:   userSession.CreatedAt,
+			LastSeen:    userSession.LastSeen,
+			IPAddress:   userSession.IPAddress,
+			UserAgent:   userSession.UserAgent,
+			Device:      device,
+			DeviceName:  deviceName,
+			Location:    userSession.Location,
+			LoginMethod: userSession.LoginMethod,
+			RememberMe:  userSession.RememberMe,
+			Current:     id == sessionID,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+
+	utils.SendSuccess(w, http.StatusOK, "Sessions retrieved", sessions)
+}
+
+// RevokeSession ends one session, identified by its public ID, and its
+// refresh token. Users may revoke their own sessions, admins any session.
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if h.tokenManager != nil {
+		utils.SendError(w, http.StatusNotImplemented, "Session revocation is not supported in JWT mode")
+		return
+	}
+
+	sessionID := h.extractSessionID(r)
+	if sessionID == "" {
+		utils.SendError(w, http.StatusUnauthorized, "No active session")
+		return
+	}
+
+	caller, err := h.ValidateSession(r.Context(), sessionID)
+	if err != nil {
+		utils.SendError(w, http.StatusUnauthorized, "Invalid session")
+		return
+	}
+
+	publicID := r.PathValue("session_id")
+	targetID, target, err := h.findSession(r.Context(), caller, publicID)
+	if err != nil {
+		if errors.Is(err, session.ErrSessionNotFound) {
+			// Sessions of other users are reported as missing, not forbidden
+			utils.SendError(w, http.StatusNotFound, "Session not found")
+			return
+		}
+		logger.ErrorWithStack(r.Context(), err, "Failed to look up session")
+		utils.SendError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	if err := h.sessionManager.DeleteSession(r.Context(), targetID); err != nil {
+		logger.ErrorWithStack(r.Context(), err, "Failed to revoke session")
+		utils.SendError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+	h.audit.Record(r, audit.Event{
+		Action:     audit.ActionSessionRevoked,
+		ActorID:    caller.UserID,
+		ActorEmail: caller.Email,
+		Target:     publicID,
+		Details:    map[string]any{"user_id": target.UserID},
+	})
+
+	if targetID == sessionID {
+		clearSessionCookies(w)
+	}
+	utils.SendSuccess(w, http.StatusOK, "Session revoked", nil)
+}
+
+// findSession resolves a public session ID among the caller's sessions, or
+// among all sessions for admins
+func (h *AuthHandler) findSession(ctx context.Context, caller *session.UserSession, publicID string) (string, *session.UserSession, error) {
+	userSessions, err := h.sessionManager.GetUserSessions(ctx, caller.UserID)
+	if err != nil {
+		return "", nil, err
+	}
+	for id, userSession := range userSessions {
+		if session.PublicID(id) == publicID {
+			return id, userSession, nil
+		}
+	}
+
+	if !caller.IsAdmin() {
+		return "", nil, session.ErrSessionNotFound
+	}
+	return h.sessionManager.FindSession(ctx, publicID)
+}
+
 func (h *AuthHandler) extractSessionID(r *http.Request) string {
 	// Try cookie first
 	cookie, err := r.Cookie("session_id")
@@ -361,19 +1045,21 @@ func (h *AuthHandler) extractSessionID(r *http.Request) string {
 	return r.Header.Get("X-Session-ID")
 }
 
+// getClientIP is the client address resolved behind the trusted proxies, so
+// clients cannot dodge the per-IP login lockout by sending X-Forwarded-For
 func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		return forwarded
-	}
+	return clientip.FromRequest(r)
+}
 
-	// Check X-Real-IP header
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
+// forwardClient tells user-service who is signing in, for its login
+// history: the address and user agent of client, and their country when the
+// proxy in front of the gateway sets X-Client-Country
+func forwardClient(req, client *http.Request) {
+	req.Header.Set("X-Forwarded-For", getClientIP(client))
+	if userAgent := client.UserAgent(); userAgent != "" {
+		req.Header.Set("X-Client-User-Agent", userAgent)
+	}
+	if country := client.Header.Get("X-Client-Country"); country != "" {
+		req.Header.Set("X-Client-Country", country)
 	}
-
-	// Use remote address
-	return r.RemoteAddr
 }
@@ -0,0 +1,91 @@
+package handler
+
+import "strings"
+
+// DeviceInfo is a coarse description of a client taken from its User-Agent
+type DeviceInfo struct {
+	Browser string `json:"browser"`
+	OS      string `json:"os"`
+	// Type is "desktop", "mobile", "tablet", "bot" or "unknown"
+	Type string `json:"type"`
+}
+
+// userAgentMatch maps a User-Agent token to a name. Lists are checked in
+// order, so tokens that other products also send (e.g. "Safari/") go last.
+type userAgentMatch struct {
+	token string
+	name  string
+}
+
+var browserMatches = []userAgentMatch{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"FxiOS/", "Firefox"},
+	{"Firefox/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+	{"PostmanRuntime/", "Postman"},
+	{"okhttp/", "OkHttp"},
+	{"Go-http-client/", "Go HTTP client"},
+}
+
+var osMatches = []userAgentMatch{
+	{"Windows", "Windows"},
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Android", "Android"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// parseUserAgent recognises the common browsers and platforms; anything
+// else is reported as "Unknown"
+func parseUserAgent(userAgent string) DeviceInfo {
+	device := DeviceInfo{
+		Browser: matchUserAgent(userAgent, browserMatches),
+		OS:      matchUserAgent(userAgent, osMatches),
+	}
+
+	lower := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(lower, "bot") || strings.Contains(lower, "spider") || strings.Contains(lower, "crawl"):
+		device.Type = "bot"
+	case device.OS == "iPadOS" || strings.Contains(userAgent, "Tablet") ||
+		(device.OS == "Android" && !strings.Contains(userAgent, "Mobile")):
+		device.Type = "tablet"
+	case device.OS == "iOS" || strings.Contains(userAgent, "Mobi"):
+		device.Type = "mobile"
+	case device.OS == "Windows" || device.OS == "macOS" || device.OS == "Linux" || device.OS == "ChromeOS":
+		device.Type = "desktop"
+	default:
+		device.Type = "unknown"
+	}
+	return device
+}
+
+// Name describes the device for people, e.g. "Chrome on macOS"
+func (d DeviceInfo) Name() string {
+	switch {
+	case d.Browser == "Unknown" && d.OS == "Unknown":
+		return "Unknown device"
+	case d.OS == "Unknown":
+		return d.Browser
+	case d.Browser == "Unknown":
+		return d.OS + " device"
+	}
+	return d.Browser + " on " + d.OS
+}
+
+func matchUserAgent(userAgent string, matches []userAgentMatch) string {
+	for _, match := range matches {
+		if strings.Contains(userAgent, match.token) {
+			return match.name
+		}
+	}
+	return "Unknown"
+}
@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/shared/pkg/identity"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+	"github.com/dhekaag/golang-microservices/shared/pkg/session"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	guestCookie = "guest_id"
+	// GuestHeader carries the guest session to upstream services. It holds
+	// the public ID of the guest token, never the token itself.
+	GuestHeader = "X-Guest-ID"
+	// guestMergeTimeout bounds the cart merge made during login
+	guestMergeTimeout = 5 * time.Second
+)
+
+// GuestSessions gives anonymous visitors of routes with auth "guest" a
+// session of their own, kept in the guest_id cookie, and hands their cart
+// over to their account when they sign in.
+type GuestSessions struct {
+	config   config.GuestConfig
+	store    guestStore
+	orderURL string
+	client   *http.Client
+	signer   *identity.Signer
+}
+
+// guestStore tracks the guest tokens the gateway has issued
+type guestStore interface {
+	// Create stores a new guest token
+	Create(ctx context.Context, tokenHash string, ttl time.Duration) error
+	// Touch extends a guest token, reporting false if it does not exist
+	Touch(ctx context.Context, tokenHash string, ttl time.Duration) (bool, error)
+	// Delete removes a guest token
+	Delete(ctx context.Context, tokenHash string) error
+}
+
+// NewGuestSessions stores guest tokens in Redis when redisClient is set, so
+// they work on every gateway instance, and in memory otherwise. Cart merges
+// are signed with the identity secret like proxied requests.
+func NewGuestSessions(cfg *config.Config, redisClient redis.UniversalClient) *GuestSessions {
+	var store guestStore = &memoryGuestStore{expiresAt: make(map[string]time.Time)}
+	if redisClient != nil {
+		store = &redisGuestStore{client: redisClient}
+	}
+	return &GuestSessions{
+		config:   cfg.Guest,
+		store:    store,
+		orderURL: cfg.Services.OrderService,
+		client:   tracing.NewClient("order-service", guestMergeTimeout, nil),
+		signer:   identity.NewSigner(cfg.Identity.Secret, cfg.Identity.TTL),
+	}
+}
+
+// Enabled reports whether guest sessions are handed out
+func (g *GuestSessions) Enabled() bool {
+	return g != nil && g.config.Enabled
+}
+
+// Resolve continues the request's guest session, or starts one and sets
+// its cookie, and passes it upstream in the X-Guest-ID header
+func (g *GuestSessions) Resolve(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	if guestToken := guestTokenFromRequest(r); guestToken != "" {
+		ok, err := g.store.Touch(ctx, hashGuestToken(guestToken), g.config.TTL)
+		if err != nil {
+			return err
+		}
+		if ok {
+			g.setCookie(w, guestToken)
+			r.Header.Set(GuestHeader, session.PublicID(guestToken))
+			return nil
+		}
+	}
+
+	guestToken, err := utils.GenerateSessionID()
+	if err != nil {
+		return err
+	}
+	if err := g.store.Create(ctx, hashGuestToken(guestToken), g.config.TTL); err != nil {
+		return err
+	}
+	g.setCookie(w, guestToken)
+	r.Header.Set(GuestHeader, session.PublicID(guestToken))
+	logger.Info(ctx, "Guest session started", "guest_id", session.PublicID(guestToken))
+	return nil
+}
+
+// MergeOnLogin is a LoginHook. When the user signing in has a guest
+// session, the order service moves the guest cart into the user's cart and
+// the guest session ends. A failed merge is logged and leaves the guest
+// session in place; it does not fail the login.
+func (g *GuestSessions) MergeOnLogin(w http.ResponseWriter, r *http.Request, userData *UserLoginData) {
+	guestToken := guestTokenFromRequest(r)
+	if !g.Enabled() || guestToken == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), guestMergeTimeout)
+	defer cancel()
+
+	tokenHash := hashGuestToken(guestToken)
+	ok, err := g.store.Touch(ctx, tokenHash, g.config.TTL)
+	if err != nil || !ok {
+		if err != nil {
+			logger.ErrorWithStack(ctx, err, "Failed to look up guest session")
+		}
+		return
+	}
+
+	guestID := session.PublicID(guestToken)
+	if err := g.mergeCart(ctx, guestID, userData); err != nil {
+		logger.Warn(ctx, "Failed to merge guest cart", "guest_id", guestID, "user_id", userData.ID, "error", err)
+		return
+	}
+
+	if err := g.store.Delete(ctx, tokenHash); err != nil {
+		logger.ErrorWithStack(ctx, err, "Failed to end guest session", "guest_id", guestID)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     guestCookie,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+	logger.Info(ctx, "Guest cart merged", "guest_id", guestID, "user_id", userData.ID)
+}
+
+// mergeCart asks the order service to move the guest's cart to the user
+func (g *GuestSessions) mergeCart(ctx context.Context, guestID string, userData *UserLoginData) error {
+	payload, err := json.Marshal(map[string]string{"guest_id": guestID})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := strings.TrimSuffix(g.orderURL, "/") + g.config.MergePath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", strconv.FormatUint(uint64(userData.ID), 10))
+	req.Header.Set(GuestHeader, guestID)
+	req.Header.Set("X-Forwarded-By", "api-gateway")
+	if requestID := logger.GetRequestID(ctx); requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+	if correlationID := logger.GetCorrelationID(ctx); correlationID != "" {
+		req.Header.Set("X-Correlation-ID", correlationID)
+	}
+	if g.signer != nil {
+		signed, err := g.signer.Sign(identity.Identity{
+			UserID: userData.ID,
+			Email:  userData.Email,
+			Role:   string(rbac.NormalizeRole(userData.Role)),
+		})
+		if err != nil {
+			return err
+		}
+		req.Header.Set(identity.Header, signed)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request to order service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("order service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (g *GuestSessions) setCookie(w http.ResponseWriter, guestToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     guestCookie,
+		Value:    guestToken,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(g.config.TTL.Seconds()),
+	})
+}
+
+func guestTokenFromRequest(r *http.Request) string {
+	cookie, err := r.Cookie(guestCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// hashGuestToken keeps raw tokens out of the store
+func hashGuestToken(guestToken string) string {
+	sum := sha256.Sum256([]byte(guestToken))
+	return hex.EncodeToString(sum[:])
+}
+
+const (
+	guestPrefix = "guest:"
+	// maxMemoryGuestEntries triggers a sweep of expired entries
+	maxMemoryGuestEntries = 100000
+)
+
+// redisGuestStore shares guest sessions between gateway instances
+type redisGuestStore struct {
+	client redis.UniversalClient
+}
+
+func (s *redisGuestStore) Create(ctx context.Context, tokenHash string, ttl time.Duration) error {
+	if err := s.client.Set(ctx, guestPrefix+tokenHash, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store guest session: %w", err)
+	}
+	return nil
+}
+
+func (s *redisGuestStore) Touch(ctx context.Context, tokenHash string, ttl time.Duration) (bool, error) {
+	ok, err := s.client.Expire(ctx, guestPrefix+tokenHash, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to extend guest session: %w", err)
+	}
+	return ok, nil
+}
+
+func (s *redisGuestStore) Delete(ctx context.Context, tokenHash string) error {
+	if err := s.client.Del(ctx, guestPrefix+tokenHash).Err(); err != nil {
+		return fmt.Errorf("failed to delete guest session: %w", err)
+	}
+	return nil
+}
+
+// memoryGuestStore keeps guest sessions in process memory
+type memoryGuestStore struct {
+	expiresAt map[string]time.Time
+	mutex     sync.Mutex
+}
+
+func (s *memoryGuestStore) Create(ctx context.Context, tokenHash string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if len(s.expiresAt) >= maxMemoryGuestEntries {
+		for existing, expiresAt := range s.expiresAt {
+			if now.After(expiresAt) {
+				delete(s.expiresAt, existing)
+			}
+		}
+	}
+	s.expiresAt[tokenHash] = now.Add(ttl)
+	return nil
+}
+
+func (s *memoryGuestStore) Touch(ctx context.Context, tokenHash string, ttl time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	expiresAt, ok := s.expiresAt[tokenHash]
+	if !ok || now.After(expiresAt) {
+		delete(s.expiresAt, tokenHash)
+		return false, nil
+	}
+	s.expiresAt[tokenHash] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *memoryGuestStore) Delete(ctx context.Context, tokenHash string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.expiresAt, tokenHash)
+	return nil
+}
@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// LoginGuard slows down and locks out password guessing. Failed logins are
+// counted per email and per client IP within a window; past DelayAfter
+// failures each attempt for the email waits progressively longer, and
+// reaching a limit locks the email or IP out for LockoutDuration.
+// A nil LoginGuard allows every attempt.
+type LoginGuard struct {
+	config config.LoginProtectionConfig
+	store  loginGuardStore
+}
+
+// loginGuardStore keeps failure counters and lockouts, both expiring
+type loginGuardStore interface {
+	Failures(ctx context.Context, key string) (int, error)
+	// AddFailure increments the counter, starting its window on the first failure
+	AddFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	// Lock locks key out for ttl and clears its counter
+	Lock(ctx context.Context, key string, ttl time.Duration) error
+	// LockedFor returns the remaining lockout, or 0
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	Reset(ctx context.Context, key string) error
+}
+
+// NewLoginGuard stores counters in Redis when redisClient is set, so limits
+// hold across gateway instances, and in memory otherwise. It returns nil
+// when login protection is disabled.
+func NewLoginGuard(cfg config.LoginProtectionConfig, redisClient redis.UniversalClient) *LoginGuard {
+	if !cfg.Enabled {
+		return nil
+	}
+	var store loginGuardStore = &memoryLoginGuardStore{entries: make(map[string]memoryLoginGuardEntry)}
+	if redisClient != nil {
+		store = &redisLoginGuardStore{client: redisClient}
+	}
+	return &LoginGuard{config: cfg, store: store}
+}
+
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LockedFor returns how long the email or IP stays locked out, or 0 when
+// the attempt may proceed. Store errors fail open.
+func (g *LoginGuard) LockedFor(ctx context.Context, email, ip string) time.Duration {
+	if g == nil {
+		return 0
+	}
+	var lockedFor time.Duration
+	for _, key := range []string{emailKey(email), ipKey(ip)} {
+		ttl, err := g.store.LockedFor(ctx, key)
+		if err != nil {
+			logger.Warn(ctx, "Login guard unavailable", "error", err)
+			return 0
+		}
+		lockedFor = max(lockedFor, ttl)
+	}
+	return lockedFor
+}
+
+// Delay waits before an attempt for an email that already failed more than
+// DelayAfter times, doubling DelayStep per further failure up to MaxDelay
+func (g *LoginGuard) Delay(ctx context.Context, email string) {
+	if g == nil || g.config.DelayStep <= 0 {
+		return
+	}
+	failures, err := g.store.Failures(ctx, emailKey(email))
+	if err != nil || failures < g.config.DelayAfter {
+		return
+	}
+
+	delay := g.config.DelayStep << min(failures-g.config.DelayAfter, 16)
+	if g.config.MaxDelay > 0 {
+		delay = min(delay, g.config.MaxDelay)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Fail counts a failed attempt and locks out the email or IP once it
+// reaches its limit. It returns the locked out keys ("email:..." or "ip:...").
+func (g *LoginGuard) Fail(ctx context.Context, email, ip string) []string {
+	if g == nil {
+		return nil
+	}
+	limits := map[string]int{
+		emailKey(email): g.config.MaxEmailFailures,
+		ipKey(ip):       g.config.MaxIPFailures,
+	}
+
+	var locked []string
+	for key, limit := range limits {
+		failures, err := g.store.AddFailure(ctx, key, g.config.FailureWindow)
+		if err != nil {
+			logger.Warn(ctx, "Failed to record login failure", "error", err)
+			continue
+		}
+		if limit > 0 && failures >= limit {
+			if err := g.store.Lock(ctx, key, g.config.LockoutDuration); err != nil {
+				logger.Warn(ctx, "Failed to lock out login", "key", key, "error", err)
+				continue
+			}
+			locked = append(locked, key)
+		}
+	}
+	return locked
+}
+
+// Succeed clears the failures counted for the email
+func (g *LoginGuard) Succeed(ctx context.Context, email string) {
+	if g == nil {
+		return
+	}
+	if err := g.store.Reset(ctx, emailKey(email)); err != nil {
+		logger.Warn(ctx, "Failed to reset login failures", "error", err)
+	}
+}
+
+const (
+	loginGuardPrefix = "login-guard:"
+	// maxMemoryLoginGuardEntries triggers a sweep of expired entries
+	maxMemoryLoginGuardEntries = 100000
+)
+
+// redisLoginGuardStore shares counters and lockouts between gateway instances
+type redisLoginGuardStore struct {
+	client redis.UniversalClient
+}
+
+// The counter and lock of a key share a hash tag, keeping them in one hash
+// slot of a Redis Cluster for the transaction in Lock
+func failuresKey(key string) string {
+	return loginGuardPrefix + "failures:{" + key + "}"
+}
+
+func lockKey(key string) string {
+	return loginGuardPrefix + "lock:{" + key + "}"
+}
+
+func (s *redisLoginGuardStore) Failures(ctx context.Context, key string) (int, error) {
+	failures, err := s.client.Get(ctx, failuresKey(key)).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get login failures: %w", err)
+	}
+	return failures, nil
+}
+
+func (s *redisLoginGuardStore) AddFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	counterKey := failuresKey(key)
+	pipe := s.client.TxPipeline()
+	incr := pipe.Incr(ctx, counterKey)
+	pipe.ExpireNX(ctx, counterKey, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to count login failure: %w", err)
+	}
+	return int(incr.Val()), nil
+}
+
+func (s *redisLoginGuardStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, lockKey(key), 1, ttl)
+	pipe.Del(ctx, failuresKey(key))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to lock out login: %w", err)
+	}
+	return nil
+}
+
+func (s *redisLoginGuardStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, lockKey(key)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check login lockout: %w", err)
+	}
+	// Negative values mean the key does not exist or never expires
+	return max(ttl, 0), nil
+}
+
+func (s *redisLoginGuardStore) Reset(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, failuresKey(key)).Err(); err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+	return nil
+}
+
+type memoryLoginGuardEntry struct {
+	failures    int
+	expiresAt   time.Time
+	lockedUntil time.Time
+}
+
+// memoryLoginGuardStore keeps counters in process memory
+type memoryLoginGuardStore struct {
+	entries map[string]memoryLoginGuardEntry
+	mutex   sync.Mutex
+}
+
+// entry returns the live state of key, dropping what has expired
+func (s *memoryLoginGuardStore) entry(key string, now time.Time) memoryLoginGuardEntry {
+	entry := s.entries[key]
+	if now.After(entry.expiresAt) {
+		entry.failures = 0
+	}
+	if entry.failures == 0 && now.After(entry.lockedUntil) {
+		delete(s.entries, key)
+		return memoryLoginGuardEntry{}
+	}
+	return entry
+}
+
+func (s *memoryLoginGuardStore) Failures(ctx context.Context, key string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.entry(key, time.Now()).failures, nil
+}
+
+func (s *memoryLoginGuardStore) AddFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if len(s.entries) >= maxMemoryLoginGuardEntries {
+		for existing := range s.entries {
+			s.entry(existing, now)
+		}
+	}
+	entry := s.entry(key, now)
+	if entry.failures == 0 {
+		entry.expiresAt = now.Add(window)
+	}
+	entry.failures++
+	s.entries[key] = entry
+	return entry.failures, nil
+}
+
+func (s *memoryLoginGuardStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries[key] = memoryLoginGuardEntry{lockedUntil: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryLoginGuardStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return max(time.Until(s.entry(key, time.Now()).lockedUntil), 0), nil
+}
+
+func (s *memoryLoginGuardStore) Reset(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := s.entry(key, time.Now())
+	entry.failures = 0
+	if entry.lockedUntil.IsZero() || time.Now().After(entry.lockedUntil) {
+		delete(s.entries, key)
+	} else {
+		s.entries[key] = entry
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/notify"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const magicLinkVerifyPath = "/api/v1/auth/magic-link/verify"
+
+// magicLinkTimeout bounds the background lookup and delivery of a link
+const magicLinkTimeout = 30 * time.Second
+
+// MagicLinkHandler signs users in through a single-use link sent to their
+// email address. Tokens are stored hashed and expire after the configured
+// TTL.
+type MagicLinkHandler struct {
+	authHandler *AuthHandler
+	notifier    *notify.Notifier
+	store       magicLinkStore
+	config      config.MagicLinkConfig
+}
+
+// magicLinkStore keeps pending link tokens and per-email cooldowns
+type magicLinkStore interface {
+	// Save stores the email a token signs in
+	Save(ctx context.Context, tokenHash, email string, ttl time.Duration) error
+	// Take returns and removes the email of a token, or "" if there is none
+	Take(ctx context.Context, tokenHash string) (string, error)
+	// Claim sets key for ttl, reporting false if it was already set
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// NewMagicLinkHandler stores tokens in Redis when redisClient is set, so a
+// link works on every gateway instance, and in memory otherwise
+func NewMagicLinkHandler(cfg config.MagicLinkConfig, authHandler *AuthHandler, notifier *notify.Notifier, redisClient redis.UniversalClient) *MagicLinkHandler {
+	var store magicLinkStore = &memoryMagicLinkStore{entries: make(map[string]memoryMagicLinkEntry)}
+	if redisClient != nil {
+		store = &redisMagicLinkStore{client: redisClient}
+	}
+	return &MagicLinkHandler{
+		authHandler: authHandler,
+		notifier:    notifier,
+		store:       store,
+		config:      cfg,
+	}
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// Request serves POST /api/v1/auth/magic-link. The answer is the same
+// whether or not the account exists, and the lookup and email happen in the
+// background so response times do not tell either.
+func (h *MagicLinkHandler) Request(w http.ResponseWriter, r *http.Request) {
+	if !h.config.Enabled {
+		utils.SendError(w, http.StatusNotFound, "Magic-link login is disabled")
+		return
+	}
+
+	ctx, _ := logger.GetOrCreateRequestID(r.Context())
+	ctx, _ = logger.GetOrCreateCorrelationID(ctx)
+
+	var req MagicLinkRequest
+	if appErr := utils.DecodeJSON(r, &req); appErr != nil {
+		appErrors.WriteErrorResponse(w, appErr)
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if email == "" || !strings.Contains(email, "@") {
+		utils.SendError(w, http.StatusBadRequest, "A valid email is required")
+		return
+	}
+
+	claimed, err := h.store.Claim(ctx, "cooldown:"+strings.ToLower(email), h.config.Cooldown)
+	if err != nil {
+		logger.ErrorWithStack(ctx, err, "Failed to check magic-link cooldown")
+		utils.SendError(w, http.StatusServiceUnavailable, "Failed to send sign-in link")
+		return
+	}
+	if claimed {
+		go h.sendLink(context.WithoutCancel(ctx), email)
+	} else {
+		logger.Info(ctx, "Magic link suppressed during cooldown", "email", email)
+	}
+
+	utils.SendSuccess(w, http.StatusAccepted, "If an account exists for this email, a sign-in link has been sent", nil)
+}
+
+// sendLink emails a new link if the account exists
+func (h *MagicLinkHandler) sendLink(ctx context.Context, email string) {
+	ctx, cancel := context.WithTimeout(ctx, magicLinkTimeout)
+	defer cancel()
+
+	userData, status, err := h.lookupUser(ctx, nil, email, false)
+	if err != nil {
+		if status == http.StatusNotFound {
+			logger.Info(ctx, "Magic link requested for unknown email", "email", email)
+		} else if status == http.StatusForbidden {
+			logger.Info(ctx, "Magic link requested for deactivated account", "email", email)
+		} else {
+			logger.ErrorWithStack(ctx, err, "Failed to look up magic-link user", "email", email)
+		}
+		return
+	}
+
+	token, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		logger.ErrorWithStack(ctx, err, "Failed to generate magic-link token")
+		return
+	}
+	if err := h.store.Save(ctx, hashMagicLinkToken(token), userData.Email, h.config.TTL); err != nil {
+		logger.ErrorWithStack(ctx, err, "Failed to store magic-link token")
+		return
+	}
+
+	link := strings.TrimSuffix(h.config.BaseURL, "/") + magicLinkVerifyPath + "?token=" + url.QueryEscape(token)
+	err = h.notifier.Send(ctx, notify.Message{
+		To:       userData.Email,
+		Template: "magic_link",
+		Subject:  "Your sign-in link",
+		Data: map[string]any{
+			"name":       userData.Name,
+			"link":       link,
+			"expires_in": h.config.TTL.String(),
+		},
+	})
+	if err != nil {
+		logger.ErrorWithStack(ctx, err, "Failed to send magic link", "user_id", userData.ID)
+		return
+	}
+	logger.Info(ctx, "Magic link sent", "user_id", userData.ID)
+}
+
+// Verify serves GET /api/v1/auth/magic-link/verify?token=..., the link in
+// the email. The token is consumed on first use.
+func (h *MagicLinkHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	if !h.config.Enabled {
+		utils.SendError(w, http.StatusNotFound, "Magic-link login is disabled")
+		return
+	}
+
+	ctx, _ := logger.GetOrCreateRequestID(r.Context())
+	ctx, _ = logger.GetOrCreateCorrelationID(ctx)
+	r = r.WithContext(ctx)
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		utils.SendError(w, http.StatusUnauthorized, "Invalid or expired sign-in link")
+		return
+	}
+
+	email, err := h.store.Take(ctx, hashMagicLinkToken(token))
+	if err != nil {
+		logger.ErrorWithStack(ctx, err, "Failed to read magic-link token")
+		utils.SendError(w, http.StatusServiceUnavailable, "Failed to sign in")
+		return
+	}
+	if email == "" {
+		utils.SendError(w, http.StatusUnauthorized, "Invalid or expired sign-in link")
+		return
+	}
+
+	// Following the link proves the user controls the address
+	userData, status, err := h.lookupUser(ctx, r, email, true)
+	if err != nil {
+		logger.Warn(ctx, "Magic-link login failed", "email", email, "error", err)
+		if status == http.StatusNotFound {
+			utils.SendError(w, http.StatusUnauthorized, "Invalid or expired sign-in link")
+		} else if status == http.StatusForbidden {
+			utils.SendError(w, http.StatusForbidden, "Account is deactivated")
+		} else {
+			utils.SendError(w, http.StatusBadGateway, "Failed to sign in")
+		}
+		return
+	}
+
+	logger.Info(ctx, "Magic-link login successful", "user_id", userData.ID)
+	h.authHandler.completeLogin(w, r, userData, "magic_link", h.config.SuccessRedirect)
+}
+
+// lookupUser asks user-service for the account with email, marking the
+// email verified when verified is set. client is the request signing in, or
+// nil when only looking the account up. The returned status is the
+// user-service response code.
+func (h *MagicLinkHandler) lookupUser(ctx context.Context, client *http.Request, email string, verified bool) (*UserLoginData, int, error) {
+	payload, err := json.Marshal(map[string]any{
+		"email":          email,
+		"email_verified": verified,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.authHandler.userServiceURL+"/auth/passwordless", bytes.NewReader(payload))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if client != nil {
+		forwardClient(req, client)
+	}
+	if requestID := logger.GetRequestID(ctx); requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+	if correlationID := logger.GetCorrelationID(ctx); correlationID != "" {
+		req.Header.Set("X-Correlation-ID", correlationID)
+	}
+
+	resp, err := h.authHandler.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to make request to user service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("user service returned status %d", resp.StatusCode)
+	}
+
+	var userResponse struct {
+		Data UserLoginData `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&userResponse); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to parse user service response: %w", err)
+	}
+	return &userResponse.Data, resp.StatusCode, nil
+}
+
+// hashMagicLinkToken keeps raw tokens out of the store
+func hashMagicLinkToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+const (
+	magicLinkPrefix = "magic-link:"
+	// maxMemoryMagicLinkEntries triggers a sweep of expired entries
+	maxMemoryMagicLinkEntries = 100000
+)
+
+// redisMagicLinkStore shares tokens between gateway instances
+type redisMagicLinkStore struct {
+	client redis.UniversalClient
+}
+
+func (s *redisMagicLinkStore) Save(ctx context.Context, tokenHash, email string, ttl time.Duration) error {
+	if err := s.client.Set(ctx, magicLinkPrefix+"token:"+tokenHash, email, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store magic-link token: %w", err)
+	}
+	return nil
+}
+
+func (s *redisMagicLinkStore) Take(ctx context.Context, tokenHash string) (string, error) {
+	email, err := s.client.GetDel(ctx, magicLinkPrefix+"token:"+tokenHash).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get magic-link token: %w", err)
+	}
+	return email, nil
+}
+
+func (s *redisMagicLinkStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return true, nil
+	}
+	claimed, err := s.client.SetNX(ctx, magicLinkPrefix+key, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim %s: %w", key, err)
+	}
+	return claimed, nil
+}
+
+type memoryMagicLinkEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// memoryMagicLinkStore keeps tokens in process memory
+type memoryMagicLinkStore struct {
+	entries map[string]memoryMagicLinkEntry
+	mutex   sync.Mutex
+}
+
+// set stores value under key, sweeping expired entries when the map is full
+func (s *memoryMagicLinkStore) set(key, value string, ttl time.Duration, now time.Time) {
+	if len(s.entries) >= maxMemoryMagicLinkEntries {
+		for existing, entry := range s.entries {
+			if now.After(entry.expiresAt) {
+				delete(s.entries, existing)
+			}
+		}
+	}
+	s.entries[key] = memoryMagicLinkEntry{value: value, expiresAt: now.Add(ttl)}
+}
+
+func (s *memoryMagicLinkStore) Save(ctx context.Context, tokenHash, email string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.set("token:"+tokenHash, email, ttl, time.Now())
+	return nil
+}
+
+func (s *memoryMagicLinkStore) Take(ctx context.Context, tokenHash string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := "token:" + tokenHash
+	entry, ok := s.entries[key]
+	delete(s.entries, key)
+	if !ok || time.Now().After(entry.expiresAt) {
+		return "", nil
+	}
+	return entry.value, nil
+}
+
+func (s *memoryMagicLinkStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return true, nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expiresAt) {
+		return false, nil
+	}
+	s.set(key, "1", ttl, now)
+	return true, nil
+}
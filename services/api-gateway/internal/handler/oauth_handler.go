@@ -0,0 +1,335 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+const (
+	oauthPathPrefix  = "/api/v1/auth/oauth/"
+	oauthStateCookie = "oauth_state"
+)
+
+// oauthIdentity is the user profile reported by a provider
+type oauthIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Image         *string
+}
+
+type oauthProvider struct {
+	config        *oauth2.Config
+	fetchIdentity func(ctx context.Context, client *http.Client) (*oauthIdentity, error)
+}
+
+// OAuthHandler runs the OAuth authorization code flow (with PKCE) for social
+// login, then provisions the user in user-service and signs them in.
+type OAuthHandler struct {
+	authHandler     *AuthHandler
+	providers       map[string]*oauthProvider
+	successRedirect string
+	secureCookies   bool
+}
+
+func NewOAuthHandler(cfg config.OAuthConfig, authHandler *AuthHandler) *OAuthHandler {
+	baseURL := strings.TrimSuffix(cfg.CallbackBaseURL, "/")
+	providers := make(map[string]*oauthProvider)
+
+	newConfig := func(name string, endpoint oauth2.Endpoint, scopes ...string) *oauth2.Config {
+		providerCfg := cfg.Providers[name]
+		return &oauth2.Config{
+			ClientID:     providerCfg.ClientID,
+			ClientSecret: providerCfg.ClientSecret,
+			Endpoint:     endpoint,
+			RedirectURL:  baseURL + oauthPathPrefix + name + "/callback",
+			Scopes:       scopes,
+		}
+	}
+
+	if cfg.Providers["google"].ClientID != "" {
+		providers["google"] = &oauthProvider{
+			config:        newConfig("google", endpoints.Google, "openid", "email", "profile"),
+			fetchIdentity: fetchGoogleIdentity,
+		}
+	}
+	if cfg.Providers["github"].ClientID != "" {
+		providers["github"] = &oauthProvider{
+			config:        newConfig("github", endpoints.GitHub, "read:user", "user:email"),
+			fetchIdentity: fetchGitHubIdentity,
+		}
+	}
+
+	return &OAuthHandler{
+		authHandler:     authHandler,
+		providers:       providers,
+		successRedirect: cfg.SuccessRedirect,
+		secureCookies:   strings.HasPrefix(baseURL, "https://"),
+	}
+}
+
+// provider looks up the {provider} path wildcard
+func (h *OAuthHandler) provider(w http.ResponseWriter, r *http.Request) (string, *oauthProvider, bool) {
+	name := r.PathValue("provider")
+	provider, ok := h.providers[name]
+	if !ok {
+		utils.SendError(w, http.StatusNotFound, "Unknown OAuth provider")
+		return "", nil, false
+	}
+	return name, provider, true
+}
+
+// Begin serves /api/v1/auth/oauth/{provider}, redirecting to the provider
+func (h *OAuthHandler) Begin(w http.ResponseWriter, r *http.Request) {
+	name, provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		logger.ErrorWithStack(r.Context(), err, "Failed to generate OAuth state")
+		utils.SendError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state + "." + verifier,
+		Path:     oauthPathPrefix + name,
+		HttpOnly: true,
+		Secure:   h.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   600,
+	})
+
+	authURL := provider.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback serves /api/v1/auth/oauth/{provider}/callback, the provider's
+// redirect target
+func (h *OAuthHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	name, provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, _ := logger.GetOrCreateRequestID(r.Context())
+	ctx, _ = logger.GetOrCreateCorrelationID(ctx)
+	r = r.WithContext(ctx)
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		logger.Warn(ctx, "OAuth provider returned error", "provider", name, "error", providerErr)
+		utils.SendError(w, http.StatusUnauthorized, "OAuth login was not completed")
+		return
+	}
+
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Missing OAuth state")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Path:     oauthPathPrefix + name,
+		HttpOnly: true,
+		Secure:   h.secureCookies,
+		MaxAge:   -1,
+	})
+
+	state, verifier, _ := strings.Cut(cookie.Value, ".")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		logger.Warn(ctx, "OAuth state mismatch", "provider", name)
+		utils.SendError(w, http.StatusBadRequest, "Invalid OAuth state")
+		return
+	}
+
+	exchangeCtx := context.WithValue(ctx, oauth2.HTTPClient, h.authHandler.httpClient)
+	oauthToken, err := provider.config.Exchange(exchangeCtx, query.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		logger.Warn(ctx, "OAuth code exchange failed", "provider", name, "error", err)
+		utils.SendError(w, http.StatusUnauthorized, "OAuth login failed")
+		return
+	}
+
+	identity, err := provider.fetchIdentity(ctx, provider.config.Client(exchangeCtx, oauthToken))
+	if err != nil {
+		logger.ErrorWithStack(ctx, err, "Failed to fetch OAuth profile", "provider", name)
+		utils.SendError(w, http.StatusBadGateway, "Failed to fetch OAuth profile")
+		return
+	}
+	if identity.Email == "" {
+		utils.SendError(w, http.StatusUnauthorized, "OAuth account has no email address")
+		return
+	}
+
+	userData, status, err := h.provisionUser(ctx, r, name, identity)
+	if err != nil {
+		logger.Warn(ctx, "OAuth user provisioning failed", "provider", name, "email", identity.Email, "error", err)
+		if status == http.StatusConflict {
+			utils.SendError(w, http.StatusConflict, "An account with this email already exists; sign in with your password")
+		} else if status == http.StatusForbidden {
+			utils.SendError(w, http.StatusForbidden, "Account is deactivated")
+		} else {
+			utils.SendError(w, http.StatusBadGateway, "Failed to sign in")
+		}
+		return
+	}
+
+	logger.Info(ctx, "OAuth login successful", "provider", name, "user_id", userData.ID)
+	h.authHandler.completeLogin(w, r, userData, name, h.successRedirect)
+}
+
+// provisionUser asks user-service to find, link or create the account for
+// the identity signing in with client. The returned status is the
+// user-service response code.
+func (h *OAuthHandler) provisionUser(ctx context.Context, client *http.Request, providerName string, identity *oauthIdentity) (*UserLoginData, int, error) {
+	payload, err := json.Marshal(map[string]any{
+		"provider":         providerName,
+		"provider_user_id": identity.Subject,
+		"email":            identity.Email,
+		"email_verified":   identity.EmailVerified,
+		"name":             identity.Name,
+		"image":            identity.Image,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.authHandler.userServiceURL+"/auth/oauth", bytes.NewReader(payload))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	forwardClient(req, client)
+	if requestID := logger.GetRequestID(ctx); requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+	if correlationID := logger.GetCorrelationID(ctx); correlationID != "" {
+		req.Header.Set("X-Correlation-ID", correlationID)
+	}
+
+	resp, err := h.authHandler.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to make request to user service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("user service returned status %d", resp.StatusCode)
+	}
+
+	var userResponse struct {
+		Data UserLoginData `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&userResponse); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to parse user service response: %w", err)
+	}
+	return &userResponse.Data, resp.StatusCode, nil
+}
+
+func fetchGoogleIdentity(ctx context.Context, client *http.Client) (*oauthIdentity, error) {
+	var profile struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+		Picture       string `json:"picture"`
+	}
+	if err := getJSON(ctx, client, "https://openidconnect.googleapis.com/v1/userinfo", &profile); err != nil {
+		return nil, err
+	}
+
+	identity := &oauthIdentity{
+		Subject:       profile.Sub,
+		Email:         profile.Email,
+		EmailVerified: profile.EmailVerified,
+		Name:          profile.Name,
+	}
+	if profile.Picture != "" {
+		identity.Image = &profile.Picture
+	}
+	return identity, nil
+}
+
+func fetchGitHubIdentity(ctx context.Context, client *http.Client) (*oauthIdentity, error) {
+	var profile struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user", &profile); err != nil {
+		return nil, err
+	}
+
+	// The profile email may be hidden, so use the primary address instead
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	identity := &oauthIdentity{
+		Subject: strconv.FormatInt(profile.ID, 10),
+		Name:    profile.Name,
+	}
+	if identity.Name == "" {
+		identity.Name = profile.Login
+	}
+	if profile.AvatarURL != "" {
+		identity.Image = &profile.AvatarURL
+	}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+			break
+		}
+	}
+	return identity, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, target any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned status %d: %s", url, resp.StatusCode, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/audit"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/metrics"
+	"github.com/dhekaag/golang-microservices/shared/pkg/session"
+)
+
+// RegisterSessionHooks counts session lifecycle events in registry and
+// raises an alert when a user signs in from a new device. Without sessions,
+// in JWT mode, there is nothing to observe.
+func RegisterSessionHooks(sessions *session.SessionManager, auditLog *audit.Logger, registry *metrics.Registry) {
+	if sessions == nil {
+		return
+	}
+
+	events := registry.Counter("session_events_total", "Number of sessions created, deleted and found expired.", "event")
+	created := events.With("created")
+	deleted := events.With("deleted")
+	expired := events.With("expired")
+
+	sessions.OnCreate(func(ctx context.Context, event session.Event) {
+		created.Inc()
+		alertNewDevice(ctx, sessions, auditLog, event)
+	})
+	sessions.OnDelete(func(ctx context.Context, event session.Event) {
+		deleted.Inc()
+	})
+	sessions.OnExpireDetected(func(ctx context.Context, event session.Event) {
+		expired.Inc()
+	})
+}
+
+// alertNewDevice warns when none of the user's other sessions was started
+// on the device of the new one. A user without other sessions has nothing
+// to compare with, so their login raises no alert.
+func alertNewDevice(ctx context.Context, sessions *session.SessionManager, auditLog *audit.Logger, event session.Event) {
+	userSessions, err := sessions.GetUserSessions(ctx, event.UserID)
+	if err != nil {
+		logger.Warn(ctx, "Failed to check for a new device", "error", err, "user_id", event.UserID)
+		return
+	}
+
+	known := false
+	for sessionID, userSession := range userSessions {
+		if sessionID == event.SessionID {
+			continue
+		}
+		if userSession.DeviceName == event.Session.DeviceName {
+			return
+		}
+		known = true
+	}
+	if !known {
+		return
+	}
+
+	logger.Warn(ctx, "Sign-in from a new device",
+		"user_id", event.UserID,
+		"device", event.Session.DeviceName,
+		"ip", event.Session.IPAddress,
+		"country", event.Session.Location.Country,
+	)
+	auditLog.RecordContext(ctx, audit.Event{
+		Action:     audit.ActionNewDeviceLogin,
+		ActorID:    event.UserID,
+		ActorEmail: event.Session.Email,
+		Target:     event.Session.DeviceName,
+		IP:         event.Session.IPAddress,
+		Details: map[string]any{
+			"session_id": session.PublicID(event.SessionID),
+			"location":   event.Session.Location,
+		},
+	})
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+	"github.com/dhekaag/golang-microservices/shared/pkg/userrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// newUserRPCClient returns a client for the internal gRPC API of
+// user-service at addr, or nil without an address. The connection is made
+// on first use.
+func newUserRPCClient(addr string) *userrpc.Client {
+	if addr == "" {
+		return nil
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		logger.Warn(context.Background(), "Invalid user-service gRPC address; signing in over HTTP", "address", addr, "error", err)
+		return nil
+	}
+	return userrpc.NewClient(conn)
+}
+
+// rpcMetadata carries the request and client behind r to user-service
+func rpcMetadata(r *http.Request) metadata.MD {
+	ctx := r.Context()
+	md := metadata.MD{}
+	if requestID := logger.GetRequestID(ctx); requestID != "" {
+		md.Set("x-request-id", requestID)
+	}
+	if correlationID := logger.GetCorrelationID(ctx); correlationID != "" {
+		md.Set("x-correlation-id", correlationID)
+	}
+	md.Set("x-forwarded-by", "api-gateway")
+	md.Set(userrpc.MetadataClientIP, getClientIP(r))
+	if userAgent := r.UserAgent(); userAgent != "" {
+		md.Set(userrpc.MetadataClientUserAgent, userAgent)
+	}
+	if country := r.Header.Get("X-Client-Country"); country != "" {
+		md.Set(userrpc.MetadataClientCountry, country)
+	}
+	tracing.InjectMetadata(ctx, md)
+	return md
+}
+
+// validateCredentialsRPC checks credentials through the gRPC API. It
+// reports false when user-service could not be reached over gRPC, so the
+// caller can fall back to HTTP.
+func (h *AuthHandler) validateCredentialsRPC(r *http.Request, email, password string) (*UserLoginData, bool, error) {
+	ctx := r.Context()
+	start := time.Now()
+
+	callCtx, cancel := context.WithTimeout(metadata.NewOutgoingContext(ctx, rpcMetadata(r)), 10*time.Second)
+	defer cancel()
+
+	response, err := h.userRPC.ValidateCredentials(callCtx, &userrpc.ValidateCredentialsRequest{
+		Email:    email,
+		Password: password,
+	})
+	middleware.RecordUpstream(ctx, "user", time.Since(start))
+	logger.ExternalCall(ctx, "user-service", userrpc.MethodValidateCredentials, time.Since(start), err)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Unauthenticated:
+			return nil, true, errInvalidCredentials
+		case codes.PermissionDenied:
+			return nil, true, errAccountDeactivated
+		case codes.Unavailable, codes.Unimplemented:
+			logger.Warn(ctx, "User service gRPC API unavailable; signing in over HTTP", "error", err)
+			return nil, false, nil
+		}
+		return nil, true, fmt.Errorf("user service gRPC call failed: %w", err)
+	}
+
+	return &UserLoginData{
+		ID:     response.ID,
+		Email:  response.Email,
+		Role:   response.Role,
+		Name:   response.Name,
+		Groups: response.Groups,
+	}, true, nil
+}
+
+// lookupUserRPC looks the user up through the gRPC API, reporting false
+// when user-service could not be reached over gRPC, like
+// validateCredentialsRPC
+func (h *AuthHandler) lookupUserRPC(r *http.Request, id uint) (*account, bool, error) {
+	ctx := r.Context()
+	start := time.Now()
+
+	callCtx, cancel := context.WithTimeout(metadata.NewOutgoingContext(ctx, rpcMetadata(r)), 10*time.Second)
+	defer cancel()
+
+	user, err := h.userRPC.GetUser(callCtx, &userrpc.GetUserRequest{ID: id})
+	middleware.RecordUpstream(ctx, "user", time.Since(start))
+	logger.ExternalCall(ctx, "user-service", userrpc.MethodGetUser, time.Since(start), err)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			return nil, true, errUserNotFound
+		case codes.Unavailable, codes.Unimplemented:
+			logger.Warn(ctx, "User service gRPC API unavailable; looking the user up over HTTP", "error", err)
+			return nil, false, nil
+		}
+		return nil, true, fmt.Errorf("user service gRPC call failed: %w", err)
+	}
+
+	return &account{
+		UserLoginData: UserLoginData{
+			ID:    user.ID,
+			Email: user.Email,
+			Role:  user.Role,
+			Name:  user.Name,
+		},
+		IsActive:        user.IsActive,
+		PasswordResetAt: user.PasswordResetAt,
+	}, true, nil
+}
@@ -3,9 +3,11 @@ package gateway
 import (
 	"context"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/handler"
+	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
 	"github.com/dhekaag/golang-microservices/shared/pkg/session"
 	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
 )
@@ -19,14 +21,22 @@ const (
 	sessionIDKey   contextKey = "session_id"
 )
 
-func SessionAuthMiddleware(next http.Handler, authHandler *handler.AuthHandler) http.Handler {
+// SessionAuthMiddleware requires a valid session for every request except the
+// built-in public paths and any request for which isPublic returns true.
+// Requests for which isOptional returns true get their session attached
+// when they present a valid one and continue anonymously otherwise.
+func SessionAuthMiddleware(next http.Handler, authHandler *handler.AuthHandler, isPublic, isOptional func(*http.Request) bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublic != nil && isPublic(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Skip authentication for certain paths
 		skipPaths := []string{
 			"/health",
 			"/api/v1/auth/login",
 			"/api/v1/auth/register",
-			"/api/v1/users",
 			"/docs",
 			"/api/v1/webhooks",
 		}
@@ -40,9 +50,21 @@ func SessionAuthMiddleware(next http.Handler, authHandler *handler.AuthHandler)
 			}
 		}
 
+		// Registration; the paths below /api/v1/users need a session
+		if r.Method == http.MethodPost && r.URL.Path == "/api/v1/users" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		optional := isOptional != nil && isOptional(r)
+
 		// Extract session ID
 		sessionID := extractSessionIDFromRequest(r)
 		if sessionID == "" {
+			if optional {
+				next.ServeHTTP(w, r)
+				return
+			}
 			utils.SendError(w, http.StatusUnauthorized, "Missing session")
 			return
 		}
@@ -50,6 +72,10 @@ func SessionAuthMiddleware(next http.Handler, authHandler *handler.AuthHandler)
 		// Validate session
 		userSession, err := authHandler.ValidateSession(r.Context(), sessionID)
 		if err != nil {
+			if optional {
+				next.ServeHTTP(w, r)
+				return
+			}
 			utils.SendError(w, http.StatusUnauthorized, "Invalid session")
 			return
 		}
@@ -59,15 +85,22 @@ func SessionAuthMiddleware(next http.Handler, authHandler *handler.AuthHandler)
 		ctx = context.WithValue(ctx, userIDKey, userSession.UserID)
 		ctx = context.WithValue(ctx, userRoleKey, userSession.Role)
 		ctx = context.WithValue(ctx, sessionIDKey, sessionID)
+		middleware.RecordUser(ctx, strconv.FormatUint(uint64(userSession.UserID), 10))
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// UserSessionFromContext returns the session attached by SessionAuthMiddleware
+func UserSessionFromContext(ctx context.Context) (*session.UserSession, bool) {
+	userSession, ok := ctx.Value(userSessionKey).(*session.UserSession)
+	return userSession, ok
+}
+
 func AdminMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userSession, ok := r.Context().Value("user_session").(*session.UserSession)
-		if !ok || userSession.Role != "admin" {
+		userSession, ok := UserSessionFromContext(r.Context())
+		if !ok || !userSession.IsAdmin() {
 			utils.SendError(w, http.StatusForbidden, "Access denied")
 			return
 		}
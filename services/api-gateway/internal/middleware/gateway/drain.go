@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+)
+
+// Drainer tracks in-flight requests so the gateway can finish them before
+// shutting down. Once draining starts, readiness checks fail and new
+// sessions are refused, but requests keep being served until the load
+// balancer stops sending them.
+type Drainer struct {
+	draining atomic.Bool
+	inFlight atomic.Int64
+}
+
+func NewDrainer() *Drainer {
+	return &Drainer{}
+}
+
+// Start switches the gateway into drain mode
+func (d *Drainer) Start() {
+	d.draining.Store(true)
+}
+
+func (d *Drainer) Draining() bool {
+	return d.draining.Load()
+}
+
+// InFlight returns the number of requests currently being served
+func (d *Drainer) InFlight() int64 {
+	return d.inFlight.Load()
+}
+
+// Wait gives load balancers delay to notice the failing readiness check,
+// then waits for in-flight requests to finish. It returns ctx.Err() if ctx
+// ends first.
+func (d *Drainer) Wait(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for d.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// DrainMiddleware counts in-flight requests for Wait
+func DrainMiddleware(next http.Handler, drainer *Drainer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		drainer.inFlight.Add(1)
+		defer drainer.inFlight.Add(-1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RejectWhileDraining answers 503 instead of calling next once draining has
+// started. It guards endpoints that create sessions, so clients log in on
+// an instance that will stay up.
+func RejectWhileDraining(next http.HandlerFunc, drainer *Drainer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if drainer.Draining() {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "1")
+			utils.SendError(w, http.StatusServiceUnavailable, "Gateway is shutting down, please retry")
+			return
+		}
+		next(w, r)
+	}
+}
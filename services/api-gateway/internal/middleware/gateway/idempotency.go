@@ -0,0 +1,320 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	idempotencyHeader       = "Idempotency-Key"
+	idempotencyMaxKeyLength = 255
+)
+
+// Headers that must never be replayed from a stored response
+var unreplayedHeaders = slices.Concat([]string{"Set-Cookie", "X-Request-Id", "X-Correlation-Id", "Date"}, appErrors.RateLimitHeaders)
+
+// Idempotency records the response to a POST, PUT, PATCH or DELETE sent with
+// an Idempotency-Key header and replays it when the client retries with the
+// same key, so the upstream only sees the request once.
+type Idempotency struct {
+	config config.IdempotencyConfig
+	store  idempotencyStore
+}
+
+// idempotencyRecord is a claimed key. It holds the response once the first
+// request has completed.
+type idempotencyRecord struct {
+	Fingerprint string      `json:"fingerprint"`
+	Completed   bool        `json:"completed"`
+	StatusCode  int         `json:"status_code,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// NewIdempotency stores keys in Redis when redisClient is set, so retries
+// may land on any gateway instance, and in memory otherwise.
+func NewIdempotency(cfg config.IdempotencyConfig, redisClient redis.UniversalClient) *Idempotency {
+	var store idempotencyStore = &memoryIdempotencyStore{records: make(map[string]memoryIdempotencyRecord)}
+	if redisClient != nil {
+		store = &redisIdempotencyStore{client: redisClient}
+	}
+	return &Idempotency{config: cfg, store: store}
+}
+
+func (i *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		// Auth responses carry credentials, which must not be stored
+		if key == "" || !isUnsafeMethod(r.Method) || strings.HasPrefix(r.URL.Path, "/api/v1/auth/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > idempotencyMaxKeyLength {
+			utils.SendError(w, http.StatusBadRequest, fmt.Sprintf("%s must be at most %d characters", idempotencyHeader, idempotencyMaxKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				utils.SendError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+				return
+			}
+			utils.SendError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := r.Context()
+		storeKey := idempotencyScope(r) + ":" + key
+		fingerprint := requestFingerprint(r, body)
+
+		claimed, err := i.store.Claim(ctx, storeKey, &idempotencyRecord{Fingerprint: fingerprint}, i.config.LockTTL)
+		if err != nil {
+			// Fail open: the request is still served, just without replay
+			logger.Warn(ctx, "Idempotency store unavailable", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !claimed {
+			i.replay(w, r, storeKey, fingerprint)
+			return
+		}
+
+		recorder := &idempotencyWriter{ResponseWriter: w, statusCode: http.StatusOK, limit: i.config.MaxBodySize}
+		next.ServeHTTP(recorder, r)
+
+		storeCtx := context.WithoutCancel(ctx)
+		// Failures the client should be able to retry release the key
+		if recorder.statusCode >= http.StatusInternalServerError || recorder.statusCode == http.StatusRequestTimeout || recorder.overflow {
+			if err := i.store.Delete(storeCtx, storeKey); err != nil {
+				logger.Warn(ctx, "Failed to release idempotency key", "error", err)
+			}
+			return
+		}
+
+		header := w.Header().Clone()
+		for _, name := range unreplayedHeaders {
+			header.Del(name)
+		}
+		record := &idempotencyRecord{
+			Fingerprint: fingerprint,
+			Completed:   true,
+			StatusCode:  recorder.statusCode,
+			Header:      header,
+			Body:        recorder.body.Bytes(),
+		}
+		if err := i.store.Set(storeCtx, storeKey, record, i.config.TTL); err != nil {
+			logger.Warn(ctx, "Failed to store idempotent response", "error", err)
+		}
+	})
+}
+
+// replay answers a request whose key is already claimed
+func (i *Idempotency) replay(w http.ResponseWriter, r *http.Request, storeKey, fingerprint string) {
+	record, found, err := i.store.Get(r.Context(), storeKey)
+	switch {
+	case err != nil:
+		logger.Warn(r.Context(), "Idempotency store unavailable", "error", err)
+		utils.SendError(w, http.StatusServiceUnavailable, "Failed to look up idempotency key")
+		return
+	case !found || !record.Completed:
+		w.Header().Set("Retry-After", "1")
+		utils.SendError(w, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+		return
+	case record.Fingerprint != fingerprint:
+		utils.SendError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+		return
+	}
+
+	header := w.Header()
+	for name, values := range record.Header {
+		header[name] = values
+	}
+	header.Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
+
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// idempotencyScope keeps keys from different clients apart
+func idempotencyScope(r *http.Request) string {
+	if userID, ok := r.Context().Value(userIDKey).(uint); ok {
+		return fmt.Sprintf("user:%d", userID)
+	}
+	return "ip:" + getClientIP(r)
+}
+
+// requestFingerprint detects a key being reused for a different request
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.RawQuery)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotencyWriter tees the response to the client while buffering it,
+// giving up on buffering once limit is exceeded
+type idempotencyWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+	limit       int64
+	overflow    bool
+}
+
+func (iw *idempotencyWriter) WriteHeader(code int) {
+	if !iw.wroteHeader {
+		iw.statusCode = code
+		iw.wroteHeader = true
+	}
+	iw.ResponseWriter.WriteHeader(code)
+}
+
+func (iw *idempotencyWriter) Write(b []byte) (int, error) {
+	iw.wroteHeader = true
+	if !iw.overflow {
+		if int64(iw.body.Len()+len(b)) > iw.limit {
+			iw.overflow = true
+			iw.body.Reset()
+		} else {
+			iw.body.Write(b)
+		}
+	}
+	return iw.ResponseWriter.Write(b)
+}
+
+func (iw *idempotencyWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+// idempotencyStore persists records. Claim stores record only if key is not
+// taken yet and reports whether it did.
+type idempotencyStore interface {
+	Claim(ctx context.Context, key string, record *idempotencyRecord, ttl time.Duration) (bool, error)
+	Get(ctx context.Context, key string) (*idempotencyRecord, bool, error)
+	Set(ctx context.Context, key string, record *idempotencyRecord, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+type memoryIdempotencyRecord struct {
+	record    *idempotencyRecord
+	expiresAt time.Time
+}
+
+type memoryIdempotencyStore struct {
+	records map[string]memoryIdempotencyRecord
+	mutex   sync.Mutex
+}
+
+func (s *memoryIdempotencyStore) Claim(ctx context.Context, key string, record *idempotencyRecord, ttl time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for storedKey, stored := range s.records {
+		if now.After(stored.expiresAt) {
+			delete(s.records, storedKey)
+		}
+	}
+
+	if _, exists := s.records[key]; exists {
+		return false, nil
+	}
+	s.records[key] = memoryIdempotencyRecord{record: record, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *memoryIdempotencyStore) Get(ctx context.Context, key string) (*idempotencyRecord, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.records[key]
+	if !exists || time.Now().After(stored.expiresAt) {
+		return nil, false, nil
+	}
+	return stored.record, true, nil
+}
+
+func (s *memoryIdempotencyStore) Set(ctx context.Context, key string, record *idempotencyRecord, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.records[key] = memoryIdempotencyRecord{record: record, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Delete(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// redisIdempotencyStore shares keys between gateway instances
+type redisIdempotencyStore struct {
+	client redis.UniversalClient
+}
+
+func (s *redisIdempotencyStore) Claim(ctx context.Context, key string, record *idempotencyRecord, ttl time.Duration) (bool, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	return s.client.SetNX(ctx, "idempotency:"+key, data, ttl).Result()
+}
+
+func (s *redisIdempotencyStore) Get(ctx context.Context, key string) (*idempotencyRecord, bool, error) {
+	data, err := s.client.Get(ctx, "idempotency:"+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var record idempotencyRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, false, err
+	}
+	return &record, true, nil
+}
+
+func (s *redisIdempotencyStore) Set(ctx context.Context, key string, record *idempotencyRecord, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, "idempotency:"+key, data, ttl).Err()
+}
+
+func (s *redisIdempotencyStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, "idempotency:"+key).Err()
+}
@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+)
+
+const defaultMaintenanceMessage = "Service is under maintenance, please try again later"
+
+// maintenanceExemptPaths stay reachable in maintenance mode so operators can
+// still log in, check health and switch the mode off again
+var maintenanceExemptPaths = []string{
+	"/health",
+	"/admin/gateway/",
+	"/api/v1/auth/login",
+}
+
+// Maintenance holds the gateway's maintenance mode. The state is kept in
+// memory, so it applies to the instance it was set on.
+type Maintenance struct {
+	mutex   sync.RWMutex
+	enabled bool
+	message string
+	since   time.Time
+}
+
+// MaintenanceStatus is the current maintenance mode setting
+type MaintenanceStatus struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+func NewMaintenance() *Maintenance {
+	return &Maintenance{}
+}
+
+// Set switches maintenance mode on or off. An empty message uses the
+// default one.
+func (m *Maintenance) Set(enabled bool, message string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	if enabled && !m.enabled {
+		m.since = time.Now()
+	}
+	m.enabled = enabled
+	m.message = message
+}
+
+func (m *Maintenance) Status() MaintenanceStatus {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if !m.enabled {
+		return MaintenanceStatus{}
+	}
+	since := m.since
+	return MaintenanceStatus{Enabled: true, Message: m.message, Since: &since}
+}
+
+// MaintenanceMiddleware answers 503 while maintenance mode is on. Admins and
+// the exempt paths are let through. It must run after SessionAuthMiddleware
+// so admins can be recognised.
+func MaintenanceMiddleware(next http.Handler, maintenance *Maintenance) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := maintenance.Status()
+		if !status.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		for _, path := range maintenanceExemptPaths {
+			if strings.HasPrefix(r.URL.Path, path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if userSession, ok := UserSessionFromContext(r.Context()); ok && userSession.IsAdmin() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", "300")
+		utils.SendError(w, http.StatusServiceUnavailable, status.Message)
+	})
+}
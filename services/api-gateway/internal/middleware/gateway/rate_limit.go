@@ -1,25 +1,28 @@
 package gateway
 
 import (
+	"fmt"
 	"net/http"
-	"sync"
+	"slices"
+	"sort"
+	"sync/atomic"
 	"time"
 
-	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/clientip"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
 )
 
+// RateLimiter allows each client a number of requests per window. Clients
+// are tracked by the shared limiter, which is bounded and drops idle ones.
 type RateLimiter struct {
-	clients map[string]*Client
-	mutex   sync.RWMutex
+	clients *middleware.RateLimiter
 	limit   int
 	window  time.Duration
 }
 
-type Client struct {
-	requests []time.Time
-	mutex    sync.Mutex
-}
-
 type RateLimitConfig struct {
 	RequestsPerMinute int
 	WindowSize        time.Duration
@@ -27,7 +30,7 @@ type RateLimitConfig struct {
 
 func NewRateLimiter(config RateLimitConfig) *RateLimiter {
 	return &RateLimiter{
-		clients: make(map[string]*Client),
+		clients: middleware.NewRateLimiter(0),
 		limit:   config.RequestsPerMinute,
 		window:  config.WindowSize,
 	}
@@ -37,68 +40,179 @@ func RateLimit(next http.Handler, config RateLimitConfig) http.Handler {
 	limiter := NewRateLimiter(config)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		clientIP := getClientIP(r)
-
-		if !limiter.Allow(clientIP) {
-			w.Header().Set("X-RateLimit-Limit", string(rune(config.RequestsPerMinute)))
-			w.Header().Set("X-RateLimit-Remaining", "0")
-			w.Header().Set("Retry-After", "60")
-			utils.SendError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+		allowed, limit := limiter.Take(getClientIP(r))
+		if !allowed {
+			appErrors.WriteRateLimitResponse(w, "Rate limit exceeded", limit)
 			return
 		}
 
+		limit.SetHeaders(w.Header())
 		next.ServeHTTP(w, r)
 	})
 }
 
 func (rl *RateLimiter) Allow(clientID string) bool {
-	rl.mutex.Lock()
-	defer rl.mutex.Unlock()
+	allowed, _ := rl.Take(clientID)
+	return allowed
+}
 
-	client, exists := rl.clients[clientID]
-	if !exists {
-		client = &Client{requests: make([]time.Time, 0)}
-		rl.clients[clientID] = client
-	}
+// Take counts a request of the client if the limit allows it, and returns
+// the limit as it stands afterwards
+func (rl *RateLimiter) Take(clientID string) (bool, appErrors.RateLimit) {
+	return rl.clients.Take(clientID, rl.limit, rl.window)
+}
 
-	client.mutex.Lock()
-	defer client.mutex.Unlock()
+// Usage counts the clients with requests in the current window and how many
+// of them have used up their limit
+func (rl *RateLimiter) Usage() (active, limited int) {
+	return rl.clients.Usage(rl.limit, rl.window)
+}
+
+// getClientIP is the client address resolved behind the trusted proxies
+func getClientIP(r *http.Request) string {
+	return clientip.FromRequest(r)
+}
 
-	now := time.Now()
+// RouteRateLimiter applies per-route limits, with separate budgets for
+// anonymous clients (per IP) and authenticated clients (per user).
+type RouteRateLimiter struct {
+	rules    []*routeLimit
+	fallback *routeLimit
+}
 
-	// Remove old requests outside the window
-	cutoff := now.Add(-rl.window)
-	newRequests := make([]time.Time, 0)
-	for _, req := range client.requests {
-		if req.After(cutoff) {
-			newRequests = append(newRequests, req)
-		}
+type routeLimit struct {
+	rule          config.RateLimitRule
+	anonymous     *RateLimiter
+	authenticated *RateLimiter
+	rejected      atomic.Int64
+}
+
+// RateLimitStats reports the current usage of one rate limit rule
+type RateLimitStats struct {
+	PathPrefix    string         `json:"path_prefix"`
+	Methods       []string       `json:"methods,omitempty"`
+	Window        string         `json:"window"`
+	Anonymous     RateLimitUsage `json:"anonymous"`
+	Authenticated RateLimitUsage `json:"authenticated"`
+	Rejected      int64          `json:"rejected"`
+}
+
+type RateLimitUsage struct {
+	Limit          int `json:"limit"`
+	ActiveClients  int `json:"active_clients"`
+	LimitedClients int `json:"limited_clients"`
+}
+
+func newRouteLimit(rule config.RateLimitRule) *routeLimit {
+	limit := &routeLimit{rule: rule}
+	if rule.Anonymous > 0 {
+		limit.anonymous = NewRateLimiter(RateLimitConfig{
+			RequestsPerMinute: rule.Anonymous,
+			WindowSize:        rule.Window.Duration(),
+		})
 	}
-	client.requests = newRequests
+	if rule.Authenticated > 0 {
+		limit.authenticated = NewRateLimiter(RateLimitConfig{
+			RequestsPerMinute: rule.Authenticated,
+			WindowSize:        rule.Window.Duration(),
+		})
+	}
+	return limit
+}
 
-	// Check if we can accept new request
-	if len(client.requests) >= rl.limit {
-		return false
+func NewRouteRateLimiter(cfg config.RateLimitConfig) *RouteRateLimiter {
+	rules := make([]*routeLimit, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		rules = append(rules, newRouteLimit(rule))
 	}
 
-	// Add current request
-	client.requests = append(client.requests, now)
-	return true
+	// Longest prefix first so the most specific rule wins
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].rule.PathPrefix) > len(rules[j].rule.PathPrefix)
+	})
+
+	return &RouteRateLimiter{
+		rules: rules,
+		fallback: newRouteLimit(config.RateLimitRule{
+			PathPrefix:    "/",
+			Anonymous:     cfg.RequestsPerMinute,
+			Authenticated: cfg.AuthenticatedRequestsPerMinute,
+			Window:        config.Duration(cfg.WindowSize),
+		}),
+	}
 }
 
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		return forwarded
+func (rl *RouteRateLimiter) match(r *http.Request) *routeLimit {
+	for _, limit := range rl.rules {
+		if !config.MatchesPathPrefix(r.URL.Path, limit.rule.PathPrefix) {
+			continue
+		}
+		if len(limit.rule.Methods) == 0 || slices.Contains(limit.rule.Methods, r.Method) {
+			return limit
+		}
 	}
+	return rl.fallback
+}
 
-	// Check X-Real-IP header
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
+// Stats returns the usage of every rule, most specific first, followed by
+// the default limit
+func (rl *RouteRateLimiter) Stats() []RateLimitStats {
+	stats := make([]RateLimitStats, 0, len(rl.rules)+1)
+	for _, limit := range append(slices.Clip(rl.rules), rl.fallback) {
+		entry := RateLimitStats{
+			PathPrefix:    limit.rule.PathPrefix,
+			Methods:       limit.rule.Methods,
+			Window:        limit.rule.Window.Duration().String(),
+			Anonymous:     RateLimitUsage{Limit: limit.rule.Anonymous},
+			Authenticated: RateLimitUsage{Limit: limit.rule.Authenticated},
+			Rejected:      limit.rejected.Load(),
+		}
+		if limit.anonymous != nil {
+			entry.Anonymous.ActiveClients, entry.Anonymous.LimitedClients = limit.anonymous.Usage()
+		}
+		if limit.authenticated != nil {
+			entry.Authenticated.ActiveClients, entry.Authenticated.LimitedClients = limit.authenticated.Usage()
+		}
+		stats = append(stats, entry)
 	}
+	return stats
+}
+
+// RouteRateLimit enforces the configured per-route limits. It must run after
+// SessionAuthMiddleware so authenticated users can be told apart.
+func RouteRateLimit(next http.Handler, cfg config.RateLimitConfig) http.Handler {
+	return NewRouteRateLimiter(cfg).Middleware(next)
+}
+
+// Middleware enforces the limits; see RouteRateLimit
+func (rl *RouteRateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := rl.match(r)
+
+		bucket := limit.anonymous
+		clientKey := "ip:" + getClientIP(r)
+		if userID, ok := r.Context().Value(userIDKey).(uint); ok {
+			bucket = limit.authenticated
+			clientKey = fmt.Sprintf("user:%d", userID)
+		}
+
+		if bucket == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-	// Use remote address
-	return r.RemoteAddr
+		allowed, usage := bucket.Take(clientKey)
+		if !allowed {
+			limit.rejected.Add(1)
+			logger.Warn(r.Context(), "Rate limit exceeded",
+				"client", clientKey,
+				"path_prefix", limit.rule.PathPrefix,
+			)
+			appErrors.WriteRateLimitResponse(w, "Rate limit exceeded", usage)
+			return
+		}
+
+		usage.SetHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
 }
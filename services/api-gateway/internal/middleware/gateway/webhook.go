@@ -0,0 +1,183 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// Webhook signature schemes
+const (
+	// WebhookSchemeHMAC signs "<timestamp>.<body>" with HMAC-SHA256; the
+	// timestamp and hex signature (optionally "sha256=" prefixed) are sent in
+	// separate headers
+	WebhookSchemeHMAC = "hmac-sha256"
+	// WebhookSchemeStripe uses a single "t=<timestamp>,v1=<signature>" header
+	WebhookSchemeStripe = "stripe"
+)
+
+var (
+	ErrWebhookNotConfigured = errors.New("webhook provider is not configured")
+	ErrWebhookSignature     = errors.New("invalid webhook signature")
+	ErrWebhookExpired       = errors.New("webhook timestamp outside tolerance")
+	ErrWebhookReplayed      = errors.New("webhook already received")
+)
+
+// WebhookVerifier checks webhook signatures before they are proxied and
+// remembers accepted signatures to reject replays.
+type WebhookVerifier struct {
+	providers map[string]config.WebhookProviderConfig
+	tolerance time.Duration
+	replays   replayCache
+}
+
+func NewWebhookVerifier(cfg config.WebhookConfig, redisClient redis.UniversalClient) *WebhookVerifier {
+	tolerance := cfg.Tolerance
+	if tolerance <= 0 {
+		tolerance = 5 * time.Minute
+	}
+
+	var replays replayCache
+	if redisClient != nil {
+		replays = &redisReplayCache{client: redisClient}
+	} else {
+		replays = &memoryReplayCache{seen: make(map[string]time.Time)}
+	}
+
+	return &WebhookVerifier{
+		providers: cfg.Providers,
+		tolerance: tolerance,
+		replays:   replays,
+	}
+}
+
+// Verify validates the signature of r for the named provider. The body is
+// read and replaced so it can still be proxied.
+func (v *WebhookVerifier) Verify(provider string, r *http.Request) error {
+	providerCfg, ok := v.providers[provider]
+	if !ok || providerCfg.Secret == "" {
+		return fmt.Errorf("%w: %s", ErrWebhookNotConfigured, provider)
+	}
+
+	var timestamp string
+	var signatures []string
+	switch providerCfg.Scheme {
+	case WebhookSchemeStripe:
+		timestamp, signatures = parseStripeSignature(r.Header.Get(providerCfg.SignatureHeader))
+	default:
+		timestamp = r.Header.Get(providerCfg.TimestampHeader)
+		if signature := r.Header.Get(providerCfg.SignatureHeader); signature != "" {
+			signatures = []string{strings.TrimPrefix(signature, "sha256=")}
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return ErrWebhookSignature
+	}
+
+	unixSeconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrWebhookSignature
+	}
+	if age := time.Since(time.Unix(unixSeconds, 0)); age > v.tolerance || age < -v.tolerance {
+		return ErrWebhookExpired
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read webhook body: %w", err)
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	mac := hmac.New(sha256.New, []byte(providerCfg.Secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	for _, signature := range signatures {
+		decoded, err := hex.DecodeString(signature)
+		if err != nil || !hmac.Equal(decoded, expected) {
+			continue
+		}
+
+		// Keep signatures a little longer than the tolerance window
+		fresh, err := v.replays.Add(r.Context(), provider+":"+signature, 2*v.tolerance)
+		if err != nil {
+			return fmt.Errorf("failed to check webhook replay: %w", err)
+		}
+		if !fresh {
+			return ErrWebhookReplayed
+		}
+		return nil
+	}
+
+	return ErrWebhookSignature
+}
+
+func parseStripeSignature(header string) (string, []string) {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	return timestamp, signatures
+}
+
+// replayCache records accepted webhook signatures. Add reports false when
+// the key was already present.
+type replayCache interface {
+	Add(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+type memoryReplayCache struct {
+	seen  map[string]time.Time
+	mutex sync.Mutex
+}
+
+func (c *memoryReplayCache) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for seenKey, expiresAt := range c.seen {
+		if now.After(expiresAt) {
+			delete(c.seen, seenKey)
+		}
+	}
+
+	if _, exists := c.seen[key]; exists {
+		return false, nil
+	}
+	c.seen[key] = now.Add(ttl)
+	return true, nil
+}
+
+// redisReplayCache shares seen signatures between gateway instances
+type redisReplayCache struct {
+	client redis.UniversalClient
+}
+
+func (c *redisReplayCache) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return c.client.SetNX(ctx, "webhook:replay:"+key, 1, ttl).Result()
+}
@@ -0,0 +1,35 @@
+package proxy
+
+// bulkhead caps the number of requests in flight to one service, so a slow
+// backend ties up at most limit gateway goroutines and connections. Requests
+// over the cap are rejected immediately instead of queueing.
+type bulkhead struct {
+	slots chan struct{}
+}
+
+func newBulkhead(limit int) *bulkhead {
+	return &bulkhead{slots: make(chan struct{}, limit)}
+}
+
+// tryAcquire takes a slot if one is free; every successful call must be
+// paired with release
+func (b *bulkhead) tryAcquire() bool {
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *bulkhead) release() {
+	<-b.slots
+}
+
+func (b *bulkhead) inFlight() int {
+	return len(b.slots)
+}
+
+func (b *bulkhead) limit() int {
+	return cap(b.slots)
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+)
+
+// Upstream variants reported in the X-Upstream-Variant response header
+const (
+	variantStable = "stable"
+	variantCanary = "canary"
+)
+
+// canaryRoute sends a share of a service's traffic to a second set of
+// instances running a new version.
+type canaryRoute struct {
+	upstream *upstream
+	weight   int
+	header   string
+	cookie   string
+}
+
+func newCanaryRoute(name string, cfg config.CanaryConfig) (*canaryRoute, error) {
+	targets, err := parseTargets(cfg.URLs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &canaryRoute{
+		upstream: newUpstream(name+"-canary", targets),
+		weight:   min(max(cfg.Weight, 0), 100),
+		header:   cfg.Header,
+		cookie:   cfg.Cookie,
+	}, nil
+}
+
+// selected reports whether r should go to the canary. An override header or
+// cookie wins; otherwise clients are bucketed by their session credential so
+// they stay on one version, and anonymous requests are split at random.
+func (c *canaryRoute) selected(r *http.Request) bool {
+	if c.header != "" {
+		if useCanary, ok := parseCanaryOverride(r.Header.Get(c.header)); ok {
+			return useCanary
+		}
+	}
+	if c.cookie != "" {
+		if cookie, err := r.Cookie(c.cookie); err == nil {
+			if useCanary, ok := parseCanaryOverride(cookie.Value); ok {
+				return useCanary
+			}
+		}
+	}
+
+	switch {
+	case c.weight <= 0:
+		return false
+	case c.weight >= 100:
+		return true
+	}
+	return canaryBucket(r) < c.weight
+}
+
+func parseCanaryOverride(value string) (useCanary bool, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "always", "true", "1", variantCanary:
+		return true, true
+	case "never", "false", "0", variantStable:
+		return false, true
+	}
+	return false, false
+}
+
+// canaryBucket maps a request to 0-99
+func canaryBucket(r *http.Request) int {
+	key := sessionKey(r)
+	if key == "" {
+		return rand.IntN(100)
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % 100)
+}
+
+// sessionKey returns the client's session credential, or "" for anonymous
+// requests
+func sessionKey(r *http.Request) string {
+	if cookie, err := r.Cookie("session_id"); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return r.Header.Get("Authorization")
+}
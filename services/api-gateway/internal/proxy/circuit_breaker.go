@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+)
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker tracks consecutive failures for a single backend service.
+// Once FailureThreshold is reached the circuit opens and requests are
+// rejected until OpenTimeout elapses, after which a limited number of probe
+// requests are let through (half-open) to decide whether to close again.
+type CircuitBreaker struct {
+	mutex            sync.Mutex
+	name             string
+	config           config.CircuitBreakerConfig
+	state            CircuitState
+	failures         int
+	successes        int
+	halfOpenInFlight int
+	openedAt         time.Time
+}
+
+func NewCircuitBreaker(name string, cfg config.CircuitBreakerConfig) *CircuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.SuccessThreshold <= 0 {
+		cfg.SuccessThreshold = 1
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 30 * time.Second
+	}
+	if cfg.HalfOpenMaxRequests <= 0 {
+		cfg.HalfOpenMaxRequests = 1
+	}
+
+	return &CircuitBreaker{
+		name:   name,
+		config: cfg,
+		state:  CircuitClosed,
+	}
+}
+
+// Allow reports whether a request may be sent to the backend. When the
+// request is rejected, the returned duration is how long the caller should
+// wait before retrying.
+func (cb *CircuitBreaker) Allow() (bool, time.Duration) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		elapsed := time.Since(cb.openedAt)
+		if elapsed < cb.config.OpenTimeout {
+			return false, cb.config.OpenTimeout - elapsed
+		}
+		cb.setState(CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if cb.halfOpenInFlight >= cb.config.HalfOpenMaxRequests {
+			return false, time.Second
+		}
+		cb.halfOpenInFlight++
+		return true, 0
+	default:
+		return true, 0
+	}
+}
+
+func (cb *CircuitBreaker) RecordSuccess() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch cb.state {
+	case CircuitHalfOpen:
+		cb.releaseProbe()
+		cb.successes++
+		if cb.successes >= cb.config.SuccessThreshold {
+			cb.setState(CircuitClosed)
+		}
+	case CircuitClosed:
+		cb.failures = 0
+	}
+}
+
+func (cb *CircuitBreaker) RecordFailure() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch cb.state {
+	case CircuitHalfOpen:
+		cb.releaseProbe()
+		cb.setState(CircuitOpen)
+	case CircuitClosed:
+		cb.failures++
+		if cb.failures >= cb.config.FailureThreshold {
+			cb.setState(CircuitOpen)
+		}
+	}
+}
+
+// Release frees a half-open probe slot without counting the outcome, e.g.
+// when the client went away before the backend answered.
+func (cb *CircuitBreaker) Release() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state == CircuitHalfOpen {
+		cb.releaseProbe()
+	}
+}
+
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state == CircuitOpen && time.Since(cb.openedAt) >= cb.config.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+func (cb *CircuitBreaker) releaseProbe() {
+	if cb.halfOpenInFlight > 0 {
+		cb.halfOpenInFlight--
+	}
+}
+
+// setState must be called with the mutex held.
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	if cb.state == state {
+		return
+	}
+
+	previous := cb.state
+	cb.state = state
+	cb.failures = 0
+	cb.successes = 0
+
+	switch state {
+	case CircuitOpen:
+		cb.openedAt = time.Now()
+		cb.halfOpenInFlight = 0
+		proxyLog().WarnMsg("Circuit breaker opened", "service", cb.name, "previous_state", previous.String(), "open_timeout", cb.config.OpenTimeout.String())
+	case CircuitHalfOpen:
+		cb.halfOpenInFlight = 0
+		proxyLog().InfoMsg("Circuit breaker half-open, probing backend", "service", cb.name)
+	case CircuitClosed:
+		proxyLog().InfoMsg("Circuit breaker closed", "service", cb.name)
+	}
+}
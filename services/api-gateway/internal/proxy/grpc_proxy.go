@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/grpcjson"
+	"github.com/dhekaag/golang-microservices/shared/pkg/identity"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const maxTranscodeBodySize = 4 << 20
+
+// GRPCProxy transcodes JSON REST requests into unary gRPC calls. Payloads are
+// forwarded with the shared JSON codec, so the gateway needs no generated
+// stubs for the services it talks to.
+type GRPCProxy struct {
+	addrs map[string]string
+	conns map[string]*grpc.ClientConn
+	mutex sync.Mutex
+}
+
+func NewGRPCProxy(config *config.ServicesConfig) *GRPCProxy {
+	return &GRPCProxy{
+		addrs: config.GRPC,
+		conns: make(map[string]*grpc.ClientConn),
+	}
+}
+
+func (gp *GRPCProxy) conn(serviceName string) (*grpc.ClientConn, error) {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+
+	if conn, exists := gp.conns[serviceName]; exists {
+		return conn, nil
+	}
+
+	addr, exists := gp.addrs[serviceName]
+	if !exists || addr == "" {
+		return nil, fmt.Errorf("no gRPC address configured for service %s", serviceName)
+	}
+
+	// grpc.NewClient connects lazily, so this does not block on the backend
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpcjson.CallOption()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client for %s: %w", serviceName, err)
+	}
+
+	gp.conns[serviceName] = conn
+	return conn, nil
+}
+
+// Transcode builds the gRPC request message from the JSON body (or from the
+// query string for GET/DELETE), invokes method on the service and writes the
+// reply in the standard response envelope.
+func (gp *GRPCProxy) Transcode(serviceName, method string, w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := upstreamContext(r.Context())
+	defer cancel()
+	start := time.Now()
+
+	conn, err := gp.conn(serviceName)
+	if err != nil {
+		proxyLog().ErrorWithStack(ctx, err, "gRPC proxy unavailable", "service", serviceName)
+		utils.SendError(w, http.StatusBadGateway, fmt.Sprintf("Service %s is currently unavailable", serviceName))
+		return
+	}
+
+	request, err := buildGRPCRequest(r)
+	if err != nil {
+		utils.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	md := metadata.MD{}
+	for header, key := range map[string]string{
+		"X-Request-ID":     "x-request-id",
+		"X-Correlation-ID": "x-correlation-id",
+		"X-User-ID":        "x-user-id",
+		identity.Header:    "x-user-identity",
+	} {
+		if value := r.Header.Get(header); value != "" {
+			md.Set(key, value)
+		}
+	}
+	if requestID := logger.GetRequestID(ctx); requestID != "" {
+		md.Set("x-request-id", requestID)
+	}
+	if correlationID := logger.GetCorrelationID(ctx); correlationID != "" {
+		md.Set("x-correlation-id", correlationID)
+	}
+	md.Set("x-forwarded-by", "api-gateway")
+	tracing.InjectMetadata(ctx, md)
+	outCtx := metadata.NewOutgoingContext(ctx, md)
+
+	var reply grpcjson.RawMessage
+	err = conn.Invoke(outCtx, method, &request, &reply)
+	middleware.RecordUpstream(ctx, serviceName, time.Since(start))
+	proxyLog().ExternalCall(ctx, serviceName+"-service", method, time.Since(start), err)
+	if err != nil {
+		appErrors.WriteErrorResponse(w, grpcStatusToAppError(serviceName, err))
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Request processed successfully", reply)
+}
+
+func buildGRPCRequest(r *http.Request) (grpcjson.RawMessage, error) {
+	if r.Method == http.MethodGet || r.Method == http.MethodDelete || r.Method == http.MethodHead {
+		fields := make(map[string]string)
+		for key, values := range r.URL.Query() {
+			if len(values) > 0 {
+				fields[key] = values[0]
+			}
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode query parameters: %w", err)
+		}
+		return data, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxTranscodeBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body")
+	}
+	if len(body) == 0 {
+		return grpcjson.RawMessage("{}"), nil
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("request body must be valid JSON")
+	}
+	return body, nil
+}
+
+// grpcStatusToAppError maps gRPC status codes onto the gateway error format
+func grpcStatusToAppError(serviceName string, err error) *appErrors.AppError {
+	st, _ := status.FromError(err)
+	message := st.Message()
+
+	switch st.Code() {
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		return appErrors.NewBadRequestError(message, err)
+	case codes.Unauthenticated:
+		return appErrors.NewUnauthorizedError(message, err)
+	case codes.PermissionDenied:
+		return appErrors.NewForbiddenError(message, err)
+	case codes.NotFound:
+		return appErrors.NewNotFoundError(message, err)
+	case codes.AlreadyExists, codes.Aborted:
+		return appErrors.NewConflictError(message, err)
+	case codes.ResourceExhausted:
+		return appErrors.NewTooManyRequestsError(message, err)
+	case codes.Unimplemented:
+		return appErrors.NewNotImplementedError(message, err)
+	case codes.DeadlineExceeded:
+		return appErrors.NewGatewayTimeoutError(fmt.Sprintf("Service %s timed out", serviceName), err)
+	case codes.Unavailable:
+		return appErrors.NewServiceUnavailableError(fmt.Sprintf("Service %s is currently unavailable", serviceName), err)
+	default:
+		return appErrors.NewExternalServiceError(serviceName, "Upstream service error", err)
+	}
+}
+
+func (gp *GRPCProxy) Close() error {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+
+	var firstErr error
+	for name, conn := range gp.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(gp.conns, name)
+	}
+	return firstErr
+}
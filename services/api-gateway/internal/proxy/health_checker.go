@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TargetHealth is the latest health check result for one upstream instance
+type TargetHealth struct {
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	LatencyMS float64   `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+
+	consecutiveFailures  int
+	consecutiveSuccesses int
+}
+
+// RunHealthChecks probes every stable and canary instance on the configured
+// interval until ctx is cancelled. Results are cached on each upstream, so
+// routing and the health endpoint never wait on a probe.
+func (sp *ServiceProxy) RunHealthChecks(ctx context.Context) {
+	cfg := sp.config.HealthCheck
+	client := &http.Client{Timeout: cfg.Timeout}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		sp.probeAll(ctx, client)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (sp *ServiceProxy) probeAll(ctx context.Context, client *http.Client) {
+	upstreams := make([]*upstream, 0, len(sp.upstreams)+len(sp.canaries))
+	for _, up := range sp.upstreams {
+		upstreams = append(upstreams, up)
+	}
+	for _, canary := range sp.canaries {
+		upstreams = append(upstreams, canary.upstream)
+	}
+
+	var wg sync.WaitGroup
+	for _, up := range upstreams {
+		targets := up.list()
+		up.pruneHealth(targets)
+
+		for _, target := range targets {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				latency, err := sp.probe(ctx, client, target)
+				up.recordProbe(target, latency, err, sp.config.HealthCheck.UnhealthyThreshold, sp.config.HealthCheck.HealthyThreshold)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func (sp *ServiceProxy) probe(ctx context.Context, client *http.Client, target *url.URL) (time.Duration, error) {
+	healthURL := strings.TrimSuffix(target.String(), "/") + sp.config.HealthCheck.Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "API-Gateway/1.0 health-check")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		return latency, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return latency, fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	return latency, nil
+}
+
+// recordProbe stores a probe result, flipping the instance's status once
+// the consecutive failure or success threshold is reached
+func (u *upstream) recordProbe(target *url.URL, latency time.Duration, err error, unhealthyThreshold, healthyThreshold int) {
+	previous := u.healthOf(target)
+	if previous == nil {
+		previous = &TargetHealth{Healthy: true}
+	}
+
+	next := &TargetHealth{
+		URL:       target.String(),
+		Healthy:   previous.Healthy,
+		LatencyMS: float64(latency.Microseconds()) / 1000,
+		CheckedAt: time.Now(),
+	}
+
+	if err != nil {
+		next.Error = err.Error()
+		next.consecutiveFailures = previous.consecutiveFailures + 1
+		if previous.Healthy && next.consecutiveFailures >= max(unhealthyThreshold, 1) {
+			next.Healthy = false
+			log.Printf("Upstream %s instance %s marked unhealthy: %v", u.name, target.Host, err)
+		}
+	} else {
+		next.consecutiveSuccesses = previous.consecutiveSuccesses + 1
+		if !previous.Healthy && next.consecutiveSuccesses >= max(healthyThreshold, 1) {
+			next.Healthy = true
+			log.Printf("Upstream %s instance %s is healthy again", u.name, target.Host)
+		}
+	}
+
+	u.health.Store(target.String(), next)
+}
+
+// pruneHealth forgets instances that discovery has removed
+func (u *upstream) pruneHealth(targets []*url.URL) {
+	current := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		current[target.String()] = true
+	}
+	u.health.Range(func(key, _ any) bool {
+		if !current[key.(string)] {
+			u.health.Delete(key)
+		}
+		return true
+	})
+}
+
+// healthReport returns the cached status of every instance, in target order
+func (u *upstream) healthReport() []TargetHealth {
+	targets := u.list()
+	report := make([]TargetHealth, 0, len(targets))
+	for _, target := range targets {
+		if health := u.healthOf(target); health != nil {
+			report = append(report, *health)
+		} else {
+			report = append(report, TargetHealth{URL: target.String(), Healthy: true})
+		}
+	}
+	return report
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+)
+
+// Requests with larger bodies are forwarded once without retry support
+const maxRetryBodySize = 1 << 20
+
+// retryTransport retries idempotent requests that fail with a connection
+// error or a 502/503 from the backend, using exponential backoff with jitter.
+type retryTransport struct {
+	base        http.RoundTripper
+	config      config.RetryConfig
+	serviceName string
+}
+
+func newRetryTransport(base http.RoundTripper, cfg config.RetryConfig, serviceName string) http.RoundTripper {
+	if cfg.MaxAttempts <= 1 {
+		return base
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = cfg.InitialBackoff
+	}
+
+	return &retryTransport{
+		base:        base,
+		config:      cfg,
+		serviceName: serviceName,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isRetryableRequest(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	body, replayable, err := bufferBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if !replayable {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		attemptReq := req.Clone(ctx)
+		if body != nil {
+			attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+			attemptReq.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(body)), nil
+			}
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if attempt >= t.config.MaxAttempts || !shouldRetry(ctx, resp, err) {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		wait := t.backoff(attempt)
+		proxyLog().Warn(ctx, "Retrying proxied request",
+			"service", t.serviceName,
+			"method", req.Method,
+			"path", req.URL.Path,
+			"attempt", attempt,
+			"backoff", wait.String(),
+		)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt: exponential growth from
+// InitialBackoff capped at MaxBackoff, with jitter in the upper half.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	delay := t.config.InitialBackoff << (attempt - 1)
+	if delay <= 0 || delay > t.config.MaxBackoff {
+		delay = t.config.MaxBackoff
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int64N(int64(half)+1))
+}
+
+// isRetryableRequest reports whether the method is safe to resend. Unsafe
+// methods are only retried when the client supplied an Idempotency-Key.
+func isRetryableRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	default:
+		return req.Header.Get("Idempotency-Key") != ""
+	}
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable
+}
+
+// bufferBody reads the request body into memory so it can be replayed. If the
+// body exceeds maxRetryBodySize, the request body is restored and reported as
+// not replayable.
+func bufferBody(req *http.Request) ([]byte, bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if req.ContentLength > maxRetryBodySize {
+		return nil, false, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxRetryBodySize+1))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(body) > maxRetryBodySize {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return nil, false, nil
+	}
+
+	req.Body.Close()
+	return body, true, nil
+}
@@ -1,58 +1,192 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
 	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
 )
 
+// proxyLog returns the logger of the proxy, whose level can be set apart
+// from the gateway's with LOG_COMPONENTS=proxy=debug
+func proxyLog() *logger.Logger {
+	return logger.Named("proxy")
+}
+
 type ServiceProxy struct {
-	services map[string]*httputil.ReverseProxy
-	config   *config.ServicesConfig
+	services  map[string]*httputil.ReverseProxy
+	upstreams map[string]*upstream
+	canaries  map[string]*canaryRoute
+	shadows   map[string]*shadowTarget
+	breakers  map[string]*CircuitBreaker
+	bulkheads map[string]*bulkhead
+	config    *config.ServicesConfig
 }
 
 func NewServiceProxy(config *config.ServicesConfig) *ServiceProxy {
 	services := make(map[string]*httputil.ReverseProxy)
+	upstreams := make(map[string]*upstream)
 
-	// User service proxy
-	if userURL, err := url.Parse(config.UserService); err == nil {
-		services["user"] = createReverseProxy(userURL, "user-service")
-	} else {
-		log.Printf("Failed to parse user service URL: %v", err)
+	staticURLs := map[string]string{
+		"user":    config.UserService,
+		"product": config.ProductService,
+		"order":   config.OrderService,
+	}
+	// Other API versions may run on their own instances, e.g. "product-v2"
+	for name, versionURLs := range config.VersionURLs {
+		for version, rawURL := range versionURLs {
+			staticURLs[VersionedService(name, version)] = rawURL
+		}
 	}
 
-	// Product service proxy
-	if productURL, err := url.Parse(config.ProductService); err == nil {
-		services["product"] = createReverseProxy(productURL, "product-service")
-	} else {
-		log.Printf("Failed to parse product service URL: %v", err)
+	for name, rawURL := range staticURLs {
+		targetURL, err := url.Parse(rawURL)
+		if err != nil {
+			log.Printf("Failed to parse %s service URL: %v", name, err)
+			continue
+		}
+		base := baseService(name)
+		upstreams[name] = newUpstream(name, []*url.URL{targetURL})
+		services[name] = createReverseProxy(name+"-service", config.Retry[base], config.Protocol[base])
 	}
 
-	// Order service proxy
-	if orderURL, err := url.Parse(config.OrderService); err == nil {
-		services["order"] = createReverseProxy(orderURL, "order-service")
-	} else {
-		log.Printf("Failed to parse order service URL: %v", err)
+	canaries := make(map[string]*canaryRoute)
+	for name, canaryConfig := range config.Canary {
+		if _, exists := services[name]; !exists || len(canaryConfig.URLs) == 0 {
+			continue
+		}
+		canary, err := newCanaryRoute(name, canaryConfig)
+		if err != nil {
+			log.Printf("Failed to configure canary for %s service: %v", name, err)
+			continue
+		}
+		canaries[name] = canary
+	}
+
+	shadows := make(map[string]*shadowTarget)
+	for name, shadowConfig := range config.Shadow {
+		if _, exists := services[name]; !exists || shadowConfig.URL == "" {
+			continue
+		}
+		shadow, err := newShadowTarget(name, shadowConfig)
+		if err != nil {
+			log.Printf("Failed to configure shadow for %s service: %v", name, err)
+			continue
+		}
+		shadows[name] = shadow
+	}
+
+	breakers := make(map[string]*CircuitBreaker)
+	if config.CircuitBreaker.Enabled {
+		for name := range services {
+			breakers[name] = NewCircuitBreaker(name+"-service", config.CircuitBreaker)
+		}
+		// Canaries get their own breaker so a bad rollout can't trip stable
+		for name := range canaries {
+			breakers[name+"-canary"] = NewCircuitBreaker(name+"-service-canary", config.CircuitBreaker)
+		}
+	}
+
+	bulkheads := make(map[string]*bulkhead)
+	for name := range services {
+		if limit := config.MaxConcurrent[baseService(name)]; limit > 0 {
+			bulkheads[name] = newBulkhead(limit)
+		}
 	}
 
 	return &ServiceProxy{
-		services: services,
-		config:   config,
+		services:  services,
+		upstreams: upstreams,
+		canaries:  canaries,
+		shadows:   shadows,
+		breakers:  breakers,
+		bulkheads: bulkheads,
+		config:    config,
 	}
 }
 
-func createReverseProxy(target *url.URL, serviceName string) *httputil.ReverseProxy {
-	proxy := httputil.NewSingleHostReverseProxy(target)
+// VersionedService names the upstream serving an API version of a service
+func VersionedService(serviceName, version string) string {
+	return serviceName + "-" + version
+}
+
+// baseService returns the service whose settings a versioned upstream uses
+func baseService(name string) string {
+	base, _, _ := strings.Cut(name, "-")
+	return base
+}
+
+// HasService reports whether serviceName has an upstream
+func (sp *ServiceProxy) HasService(serviceName string) bool {
+	_, exists := sp.services[serviceName]
+	return exists
+}
+
+// UpdateTargets replaces the instance list for a service. It is safe to call
+// while requests are being proxied, e.g. from a service discovery watcher.
+func (sp *ServiceProxy) UpdateTargets(serviceName string, addrs []string) error {
+	up, exists := sp.upstreams[serviceName]
+	if !exists {
+		return fmt.Errorf("service %s not found", serviceName)
+	}
+
+	targets, err := parseTargets(addrs)
+	if err != nil {
+		return err
+	}
+
+	up.setTargets(targets)
+	return nil
+}
+
+// Services returns the names of the proxied services, sorted
+func (sp *ServiceProxy) Services() []string {
+	names := make([]string, 0, len(sp.services))
+	for name := range sp.services {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Targets returns the instances currently known for a service
+func (sp *ServiceProxy) Targets(serviceName string) []string {
+	up, exists := sp.upstreams[serviceName]
+	if !exists {
+		return nil
+	}
+
+	var addrs []string
+	for _, target := range up.list() {
+		addrs = append(addrs, target.String())
+	}
+	return addrs
+}
+
+func createReverseProxy(serviceName string, retryConfig config.RetryConfig, protocol string) *httputil.ReverseProxy {
+	proxy := &httputil.ReverseProxy{}
+	proxy.Transport = newRetryTransport(tracing.Transport(serviceName, newUpstreamTransport(serviceName, protocol)), retryConfig, serviceName)
 
 	// Custom director to modify requests
-	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
+		if target := targetFromContext(req.Context()); target != nil {
+			rewriteTarget(req, target)
+		}
 
 		// 🔑 ENHANCED: Forward context headers
 		if requestID := req.Header.Get("X-Request-ID"); requestID != "" {
@@ -79,9 +213,18 @@ func createReverseProxy(target *url.URL, serviceName string) *httputil.ReversePr
 
 	// Custom error handler
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		_ = r.Context()
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			utils.SendError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+			return
+		}
+
 		log.Printf("❌ Proxy error for %s: %v", serviceName, err)
 
+		if errors.Is(err, context.DeadlineExceeded) {
+			utils.SendError(w, http.StatusGatewayTimeout, fmt.Sprintf("Service %s timed out", serviceName))
+			return
+		}
 		utils.SendError(w, http.StatusBadGateway, fmt.Sprintf("Service %s is currently unavailable", serviceName))
 	}
 
@@ -113,27 +256,111 @@ func (sp *ServiceProxy) ProxyToService(serviceName string, w http.ResponseWriter
 		return
 	}
 
+	if bulkhead := sp.bulkheads[serviceName]; bulkhead != nil {
+		if !bulkhead.tryAcquire() {
+			log.Printf("Concurrency limit reached for %s (%d in flight)", serviceName, bulkhead.limit())
+			appErr := appErrors.NewServiceUnavailableError(fmt.Sprintf("Service %s is at capacity, please retry", serviceName), nil)
+			appErr.Data = map[string]interface{}{
+				"service": serviceName,
+				"limit":   bulkhead.limit(),
+			}
+			w.Header().Set("Retry-After", "1")
+			appErrors.WriteErrorResponse(w, appErr)
+			return
+		}
+		defer bulkhead.release()
+	}
+
+	up, variant, breakerKey := sp.upstreams[serviceName], variantStable, serviceName
+	if canary := sp.canaries[serviceName]; canary != nil {
+		if canary.selected(r) {
+			up, variant, breakerKey = canary.upstream, variantCanary, serviceName+"-canary"
+		}
+		w.Header().Set("X-Upstream-Variant", variant)
+	}
+
+	var target *url.URL
+	if sp.config.Sticky[baseService(serviceName)] {
+		target = up.pickFor(affinityKey(r))
+	} else {
+		target = up.pick()
+	}
+	if target == nil {
+		utils.SendError(w, http.StatusServiceUnavailable, fmt.Sprintf("Service %s has no available instances", serviceName))
+		return
+	}
+	ctx, cancel := upstreamContext(r.Context())
+	defer cancel()
+	r = r.WithContext(withTarget(ctx, target))
+
+	breaker := sp.breakers[breakerKey]
+	if breaker != nil {
+		if allowed, retryAfter := breaker.Allow(); !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			utils.SendError(w, http.StatusServiceUnavailable, fmt.Sprintf("Service %s is temporarily unavailable", serviceName))
+			return
+		}
+	}
+
+	if shadow := sp.shadows[serviceName]; shadow != nil && shadow.sampled(r) {
+		shadow.mirror(r)
+	}
+
 	// Add request tracing
-	log.Printf("Proxying request to %s: %s %s", serviceName, r.Method, r.URL.Path)
+	log.Printf("Proxying request to %s (%s, %s): %s %s", serviceName, target.Host, variant, r.Method, r.URL.Path)
+
+	recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+	start := time.Now()
+	proxy.ServeHTTP(recorder, r)
+	middleware.RecordUpstream(r.Context(), serviceName, time.Since(start))
+
+	if breaker != nil {
+		switch {
+		case errors.Is(r.Context().Err(), context.Canceled):
+			// Client went away; says nothing about backend health
+			breaker.Release()
+		case recorder.statusCode >= http.StatusInternalServerError:
+			breaker.RecordFailure()
+		default:
+			breaker.RecordSuccess()
+		}
+	}
+}
 
-	proxy.ServeHTTP(w, r)
+// CircuitState returns the circuit breaker state for a service (or for its
+// canary, as "<name>-canary"), or "disabled" when circuit breaking is off.
+func (sp *ServiceProxy) CircuitState(serviceName string) string {
+	breaker, exists := sp.breakers[serviceName]
+	if !exists {
+		return "disabled"
+	}
+	return breaker.State().String()
 }
 
+// IsServiceHealthy reports whether any instance of a service passed its
+// last health check. Without background health checks it probes one
+// instance on the spot.
 func (sp *ServiceProxy) IsServiceHealthy(serviceName string) bool {
-	var serviceURL string
-
-	switch serviceName {
-	case "user":
-		serviceURL = sp.config.UserService
-	case "product":
-		serviceURL = sp.config.ProductService
-	case "order":
-		serviceURL = sp.config.OrderService
-	default:
+	up, exists := sp.upstreams[serviceName]
+	if !exists {
 		return false
 	}
 
-	resp, err := http.Get(serviceURL + "/health")
+	if sp.config.HealthCheck.Enabled {
+		for _, target := range up.list() {
+			if up.isHealthy(target) {
+				return true
+			}
+		}
+		return false
+	}
+
+	target := up.pick()
+	if target == nil {
+		return false
+	}
+
+	resp, err := http.Get(strings.TrimSuffix(target.String(), "/") + "/health")
 	if err != nil {
 		return false
 	}
@@ -141,3 +368,47 @@ func (sp *ServiceProxy) IsServiceHealthy(serviceName string) bool {
 
 	return resp.StatusCode == http.StatusOK
 }
+
+// Concurrency returns the requests in flight to a service and its cap, or
+// zeros when the service has no concurrency limit
+func (sp *ServiceProxy) Concurrency(serviceName string) (inFlight, limit int) {
+	if bulkhead := sp.bulkheads[serviceName]; bulkhead != nil {
+		return bulkhead.inFlight(), bulkhead.limit()
+	}
+	return 0, 0
+}
+
+// HealthReport returns the cached health check results for a service's
+// instances, or nil when the service is unknown
+func (sp *ServiceProxy) HealthReport(serviceName string) []TargetHealth {
+	up, exists := sp.upstreams[serviceName]
+	if !exists {
+		return nil
+	}
+	return up.healthReport()
+}
+
+// statusRecorder captures the status code written by the reverse proxy
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if !sr.wroteHeader {
+		sr.statusCode = code
+		sr.wroteHeader = true
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	sr.wroteHeader = true
+	return sr.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+)
+
+const (
+	// maxShadowBodySize caps the request bodies copied to a shadow upstream
+	maxShadowBodySize = 1 << 20
+	// maxInFlightShadows drops mirrored requests when the shadow falls behind
+	maxInFlightShadows = 64
+)
+
+// shadowTarget mirrors a sample of a service's requests to a shadow upstream.
+// Mirrored requests are sent in the background and their responses are
+// discarded, so the shadow can never affect what clients see.
+type shadowTarget struct {
+	serviceName  string
+	target       *url.URL
+	percent      int
+	mirrorWrites bool
+	timeout      time.Duration
+	client       *http.Client
+	inFlight     chan struct{}
+}
+
+func newShadowTarget(serviceName string, cfg config.ShadowConfig) (*shadowTarget, error) {
+	targets, err := parseTargets([]string{cfg.URL})
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	return &shadowTarget{
+		serviceName:  serviceName,
+		target:       targets[0],
+		percent:      min(max(cfg.Percent, 0), 100),
+		mirrorWrites: cfg.MirrorWrites,
+		timeout:      timeout,
+		client:       tracing.NewClient(serviceName, timeout, nil),
+		inFlight:     make(chan struct{}, maxInFlightShadows),
+	}, nil
+}
+
+func (s *shadowTarget) sampled(r *http.Request) bool {
+	if !s.mirrorWrites && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return s.percent >= 100 || rand.IntN(100) < s.percent
+}
+
+// mirror copies r to the shadow upstream in the background. The request body
+// is buffered and restored so the primary request is unaffected; bodies
+// larger than maxShadowBodySize are not mirrored.
+func (s *shadowTarget) mirror(r *http.Request) {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > maxShadowBodySize {
+			return
+		}
+		buffered, err := io.ReadAll(io.LimitReader(r.Body, maxShadowBodySize+1))
+		rest := r.Body
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buffered), rest), rest}
+		if err != nil || len(buffered) > maxShadowBodySize {
+			return
+		}
+		body = buffered
+	}
+
+	select {
+	case s.inFlight <- struct{}{}:
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), s.timeout)
+	shadowReq := r.Clone(ctx)
+	shadowReq.RequestURI = ""
+	shadowReq.Host = ""
+	rewriteTarget(shadowReq, s.target)
+	shadowReq.Body = io.NopCloser(bytes.NewReader(body))
+	shadowReq.ContentLength = int64(len(body))
+	shadowReq.Header.Del("Cookie")
+	shadowReq.Header.Del("Authorization")
+	shadowReq.Header.Set("X-Shadow-Request", "true")
+	shadowReq.Header.Set("X-Forwarded-By", "api-gateway")
+
+	go func() {
+		defer func() { <-s.inFlight }()
+		defer cancel()
+
+		resp, err := s.client.Do(shadowReq)
+		if err != nil {
+			log.Printf("Shadow request to %s failed: %v", s.serviceName, err)
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
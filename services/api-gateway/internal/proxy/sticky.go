@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+)
+
+type affinityContextKey struct{}
+
+// WithAffinityKey sets the key sticky routing hashes on, normally the
+// authenticated user's ID so all of a user's sessions reach one instance.
+func WithAffinityKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, affinityContextKey{}, key)
+}
+
+// affinityKey falls back to the session credential when no key was set
+func affinityKey(r *http.Request) string {
+	if key, ok := r.Context().Value(affinityContextKey{}).(string); ok && key != "" {
+		return "user:" + key
+	}
+	if key := sessionKey(r); key != "" {
+		return "session:" + key
+	}
+	return ""
+}
+
+// pickFor returns the instance key is pinned to. Rendezvous hashing means
+// adding or removing an instance only moves the keys that were on it.
+// Unhealthy instances are skipped, and an empty key falls back to pick.
+func (u *upstream) pickFor(key string) *url.URL {
+	if key == "" {
+		return u.pick()
+	}
+
+	list := u.list()
+	candidates := make([]*url.URL, 0, len(list))
+	for _, target := range list {
+		if u.isHealthy(target) {
+			candidates = append(candidates, target)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = list
+	}
+
+	var best *url.URL
+	var bestScore uint64
+	for _, target := range candidates {
+		if score := rendezvousScore(key, target.String()); best == nil || score > bestScore {
+			best, bestScore = target, score
+		}
+	}
+	return best
+}
+
+func rendezvousScore(key, target string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	hash.Write([]byte{0})
+	hash.Write([]byte(target))
+
+	// FNV alone spreads similar inputs poorly; finish with a 64-bit mixer
+	score := hash.Sum64()
+	score ^= score >> 33
+	score *= 0xff51afd7ed558ccd
+	score ^= score >> 33
+	score *= 0xc4ceb9fe1a85ec53
+	score ^= score >> 33
+	return score
+}
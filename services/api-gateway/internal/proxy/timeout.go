@@ -0,0 +1,23 @@
+package proxy
+
+import (
+	"context"
+	"time"
+)
+
+type timeoutContextKey struct{}
+
+// WithTimeout sets the deadline for the upstream call made for a request.
+// The deadline starts when the call is made, not when it is set, so time
+// spent in gateway middleware does not count against it.
+func WithTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutContextKey{}, timeout)
+}
+
+// upstreamContext applies the timeout set by WithTimeout, if any
+func upstreamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout, ok := ctx.Value(timeoutContextKey{}).(time.Duration); ok && timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return ctx, func() {}
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+)
+
+// newUpstreamTransport returns the transport used to reach a service's
+// instances. HTTP/2 multiplexes concurrent requests over one connection per
+// instance, so busy services need far fewer connections than with HTTP/1.1.
+func newUpstreamTransport(serviceName, protocol string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	protocols := new(http.Protocols)
+
+	switch protocol {
+	case config.UpstreamProtocolAuto, "":
+		return transport
+	case config.UpstreamProtocolHTTP1:
+		protocols.SetHTTP1(true)
+	case config.UpstreamProtocolHTTP2:
+		// HTTP/2 over TLS only; plaintext instances need h2c
+		protocols.SetHTTP2(true)
+	case config.UpstreamProtocolH2C:
+		// Prior-knowledge HTTP/2 for http:// instances, ALPN for https://
+		protocols.SetUnencryptedHTTP2(true)
+		protocols.SetHTTP2(true)
+	default:
+		log.Printf("Unknown upstream protocol %q for %s, using %s", protocol, serviceName, config.UpstreamProtocolAuto)
+		return transport
+	}
+
+	transport.Protocols = protocols
+	return transport
+}
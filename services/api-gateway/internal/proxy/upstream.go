@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type targetContextKey struct{}
+
+// upstream holds the current set of instances for a backend service. The
+// target list is swapped atomically so discovery updates never block
+// in-flight requests.
+type upstream struct {
+	name    string
+	targets atomic.Pointer[[]*url.URL]
+	next    atomic.Uint64
+	// health maps a target URL to its latest *TargetHealth; it is written
+	// only by the health checker
+	health sync.Map
+}
+
+func newUpstream(name string, targets []*url.URL) *upstream {
+	u := &upstream{name: name}
+	u.setTargets(targets)
+	return u
+}
+
+func (u *upstream) setTargets(targets []*url.URL) {
+	list := make([]*url.URL, len(targets))
+	copy(list, targets)
+	u.targets.Store(&list)
+}
+
+func (u *upstream) list() []*url.URL {
+	if list := u.targets.Load(); list != nil {
+		return *list
+	}
+	return nil
+}
+
+// pick returns the next healthy instance in round-robin order, or nil when
+// the service currently has no known instances. When every instance failed
+// its last health check, one is returned anyway and the circuit breaker
+// decides whether the request goes through.
+func (u *upstream) pick() *url.URL {
+	list := u.list()
+	if len(list) == 0 {
+		return nil
+	}
+	n := u.next.Add(1) - 1
+	for i := range uint64(len(list)) {
+		target := list[(n+i)%uint64(len(list))]
+		if u.isHealthy(target) {
+			return target
+		}
+	}
+	return list[n%uint64(len(list))]
+}
+
+// healthOf returns the latest probe result for target, or nil before the
+// first probe
+func (u *upstream) healthOf(target *url.URL) *TargetHealth {
+	if value, ok := u.health.Load(target.String()); ok {
+		return value.(*TargetHealth)
+	}
+	return nil
+}
+
+// isHealthy treats instances that have not been probed yet as healthy
+func (u *upstream) isHealthy(target *url.URL) bool {
+	health := u.healthOf(target)
+	return health == nil || health.Healthy
+}
+
+func parseTargets(addrs []string) ([]*url.URL, error) {
+	targets := make([]*url.URL, 0, len(addrs))
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		target, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream address %q: %w", addr, err)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+func withTarget(ctx context.Context, target *url.URL) context.Context {
+	return context.WithValue(ctx, targetContextKey{}, target)
+}
+
+func targetFromContext(ctx context.Context) *url.URL {
+	target, _ := ctx.Value(targetContextKey{}).(*url.URL)
+	return target
+}
+
+// rewriteTarget points the outgoing request at target, mirroring what
+// httputil.NewSingleHostReverseProxy does for a fixed target.
+func rewriteTarget(req *http.Request, target *url.URL) {
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path = singleJoiningSlash(target.Path, req.URL.Path)
+	req.URL.RawPath = ""
+
+	if target.RawQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = target.RawQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+	}
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,53 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/aggregate"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/middleware/gateway"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+)
+
+// registerCompositeRoutes adds the gateway-composed (BFF) endpoints. Each
+// endpoint fans out to several services and merges their responses.
+func (r *Router) registerCompositeRoutes(mux *utils.ServeMux) {
+	aggregator := aggregate.New(r.serviceProxy)
+
+	mux.HandleFunc("GET /api/v1/me/dashboard", aggregator.Handler(aggregate.Endpoint{
+		Timeout: 5 * time.Second,
+		Sources: []aggregate.Source{
+			{
+				Key:      "user",
+				Service:  "user",
+				Path:     userPath("/users/%d"),
+				Required: true,
+			},
+			{
+				Key:     "recent_orders",
+				Service: "order",
+				Path:    userPath("/orders?user_id=%d&limit=5"),
+			},
+			{
+				Key:     "recommended_products",
+				Service: "product",
+				Path: func(*http.Request) string {
+					return "/products?limit=5"
+				},
+			},
+		},
+	}))
+}
+
+// userPath formats an upstream path with the authenticated user's ID, or
+// skips the source for anonymous requests
+func userPath(format string) func(*http.Request) string {
+	return func(req *http.Request) string {
+		userSession, ok := gateway.UserSessionFromContext(req.Context())
+		if !ok {
+			return ""
+		}
+		return fmt.Sprintf(format, userSession.UserID)
+	}
+}
@@ -0,0 +1,61 @@
+package router
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
+)
+
+// routeTable matches requests against the declarative route config. The
+// longest matching prefix wins; among routes with the same prefix, entries
+// that list methods explicitly take precedence over catch-all entries.
+type routeTable struct {
+	routes []config.RouteConfig
+}
+
+func newRouteTable(routes []config.RouteConfig) *routeTable {
+	sorted := make([]config.RouteConfig, len(routes))
+	copy(sorted, routes)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if len(sorted[i].PathPrefix) != len(sorted[j].PathPrefix) {
+			return len(sorted[i].PathPrefix) > len(sorted[j].PathPrefix)
+		}
+		return len(sorted[i].Methods) > 0 && len(sorted[j].Methods) == 0
+	})
+
+	return &routeTable{routes: sorted}
+}
+
+// match returns the route for path and method. When a route prefix matches
+// but none allows the method, it returns nil and pathMatched=true.
+func (t *routeTable) match(path, method string) (route *config.RouteConfig, pathMatched bool) {
+	for i := range t.routes {
+		candidate := &t.routes[i]
+		if !config.MatchesPathPrefix(path, candidate.PathPrefix) || (candidate.Exact && path != candidate.PathPrefix) {
+			continue
+		}
+		pathMatched = true
+		if len(candidate.Methods) == 0 || slices.Contains(candidate.Methods, method) {
+			return candidate, true
+		}
+	}
+	return nil, pathMatched
+}
+
+// bodyLimits returns the body limits of the routes that set one
+func (t *routeTable) bodyLimits() []middleware.BodyLimitRule {
+	var rules []middleware.BodyLimitRule
+	for _, route := range t.routes {
+		if route.MaxBodySize != 0 {
+			rules = append(rules, middleware.BodyLimitRule{
+				PathPrefix: route.PathPrefix,
+				Methods:    route.Methods,
+				MaxBytes:   route.MaxBodySize,
+			})
+		}
+	}
+	return rules
+}
@@ -1,216 +1,367 @@
 package router
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/admin"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/aggregate"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/audit"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/cache"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/clientip"
 	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/docs"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/fallback"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/graphapi"
 	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/handler"
 	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/middleware/gateway"
 	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/proxy"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/validation"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/featureflag"
+	"github.com/dhekaag/golang-microservices/shared/pkg/identity"
 	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/metrics"
 	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
 	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
 )
 
 type Router struct {
-	serviceProxy *proxy.ServiceProxy
-	authHandler  *handler.AuthHandler
-	config       *config.Config
+	serviceProxy  *proxy.ServiceProxy
+	grpcProxy     *proxy.GRPCProxy
+	responseCache *cache.ResponseCache
+	fallback      *fallback.Fallback
+	authHandler   *handler.AuthHandler
+	oauthHandler  *handler.OAuthHandler
+	magicLink     *handler.MagicLinkHandler
+	guests        *handler.GuestSessions
+	ipFilter      *gateway.IPFilter
+	clientIPs     *clientip.Resolver
+	webhooks      *gateway.WebhookVerifier
+	idempotency   *gateway.Idempotency
+	graphql       *graphapi.Handler
+	drainer       *gateway.Drainer
+	rateLimiter   *gateway.RouteRateLimiter
+	maintenance   *gateway.Maintenance
+	config        *config.Config
+	routes        *routeTable
+	timeouts      *timeoutTable
+	docs          *docs.Handler
+	validator     *validation.Validator
+	audit         *audit.Logger
+	identity      *identity.Signer
+	features      *featureflag.Flags
 }
 
 func NewRouter(
 	serviceProxy *proxy.ServiceProxy,
+	grpcProxy *proxy.GRPCProxy,
+	responseCache *cache.ResponseCache,
+	fallback *fallback.Fallback,
 	authHandler *handler.AuthHandler,
+	oauthHandler *handler.OAuthHandler,
+	magicLink *handler.MagicLinkHandler,
+	guests *handler.GuestSessions,
+	ipFilter *gateway.IPFilter,
+	clientIPs *clientip.Resolver,
+	webhooks *gateway.WebhookVerifier,
+	idempotency *gateway.Idempotency,
+	graphql *graphapi.Handler,
+	drainer *gateway.Drainer,
+	auditLog *audit.Logger,
+	features *featureflag.Flags,
 	config *config.Config,
 ) *Router {
-	return &Router{
-		serviceProxy: serviceProxy,
-		authHandler:  authHandler,
-		config:       config,
+	r := &Router{
+		serviceProxy:  serviceProxy,
+		grpcProxy:     grpcProxy,
+		responseCache: responseCache,
+		fallback:      fallback,
+		authHandler:   authHandler,
+		oauthHandler:  oauthHandler,
+		magicLink:     magicLink,
+		guests:        guests,
+		ipFilter:      ipFilter,
+		clientIPs:     clientIPs,
+		webhooks:      webhooks,
+		idempotency:   idempotency,
+		graphql:       graphql,
+		drainer:       drainer,
+		audit:         auditLog,
+		features:      features,
+		rateLimiter:   gateway.NewRouteRateLimiter(config.RateLimit),
+		maintenance:   gateway.NewMaintenance(),
+		identity:      identity.NewSigner(config.Identity.Secret, config.Identity.TTL),
+		config:        config,
+		routes:        newRouteTable(config.Routes),
+		timeouts:      newTimeoutTable(config.Server.TimeoutRules),
 	}
+
+	// API documentation: Swagger UI over the gateway and backend specs, also
+	// used to validate requests before they are proxied
+	r.docs = docs.NewHandler(aggregate.New(serviceProxy), r.matchRoute, config.Routes, config.Docs)
+	if config.Docs.ValidateRequests {
+		r.validator = validation.New(r.docs.Spec, config.Docs.CacheTTL)
+	}
+	return r
 }
 
 func (r *Router) SetupRoutes() http.Handler {
-	mux := http.NewServeMux()
+	mux := utils.NewServeMux()
 
 	// Health check routes (no authentication required)
-	mux.HandleFunc("/health", r.handleHealthCheck)
-	mux.HandleFunc("/health/ready", r.handleHealthCheck)
-	mux.HandleFunc("/health/live", r.handleHealthCheck)
-
-	// Authentication routes (handled by gateway)
-	mux.HandleFunc("/api/v1/auth/login", r.authHandler.Login)
-	mux.HandleFunc("/api/v1/auth/logout", r.authHandler.Logout)
-	mux.HandleFunc("/api/v1/auth/me", r.authHandler.GetUserInfo)
-	mux.HandleFunc("/api/v1/auth/refresh", r.authHandler.RefreshSession)
-	mux.HandleFunc("/api/v1/auth/logout-all", r.authHandler.LogoutAllSessions)
-
-	// Registration route (proxy to user service)
-	mux.HandleFunc("/api/v1/auth/register", func(w http.ResponseWriter, req *http.Request) {
-		if req.Method == "POST" {
-			req.URL.Path = "/auth/register"
-			r.serviceProxy.ProxyToService("user", w, req)
-		} else {
-			utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
-		}
-	})
-
-	// Password reset routes (proxy to user service)
-	mux.HandleFunc("/api/v1/auth/forgot-password", func(w http.ResponseWriter, req *http.Request) {
-		if req.Method == "POST" {
-			req.URL.Path = "/auth/forgot-password"
-			r.serviceProxy.ProxyToService("user", w, req)
-		} else {
-			utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
-		}
-	})
-
-	mux.HandleFunc("/api/v1/auth/reset-password", func(w http.ResponseWriter, req *http.Request) {
-		if req.Method == "POST" {
-			req.URL.Path = "/auth/reset-password"
-			r.serviceProxy.ProxyToService("user", w, req)
-		} else {
-			utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
-		}
-	})
-
-	// User service routes
-	mux.HandleFunc("/api/v1/users", r.handleUserRoutes)
-	mux.HandleFunc("/api/v1/users/", r.handleUserRoutes)
-
-	// Product service routes
-	mux.HandleFunc("/api/v1/products", r.handleProductRoutes)
-	mux.HandleFunc("/api/v1/products/", r.handleProductRoutes)
-	mux.HandleFunc("/api/v1/categories", r.handleProductRoutes)
-	mux.HandleFunc("/api/v1/categories/", r.handleProductRoutes)
-
-	// Order service routes
-	mux.HandleFunc("/api/v1/orders", r.handleOrderRoutes)
-	mux.HandleFunc("/api/v1/orders/", r.handleOrderRoutes)
-	mux.HandleFunc("/api/v1/cart", r.handleOrderRoutes)
-	mux.HandleFunc("/api/v1/cart/", r.handleOrderRoutes)
-
-	// Admin routes (protected)
-	mux.HandleFunc("/api/v1/admin/", r.handleAdminRoutes)
+	mux.HandleFunc("GET /health", r.handleHealthCheck)
+	mux.HandleFunc("GET /health/ready", r.handleReadinessCheck)
+	mux.HandleFunc("GET /health/live", r.handleHealthCheck)
+
+	// Authentication routes (handled by gateway); no new sessions while draining
+	mux.HandleFunc("POST /api/v1/auth/login", gateway.RejectWhileDraining(r.authHandler.Login, r.drainer))
+	mux.HandleFunc("POST /api/v1/auth/logout", r.authHandler.Logout)
+	mux.HandleFunc("GET /api/v1/auth/me", r.authHandler.GetUserInfo)
+	mux.HandleFunc("POST /api/v1/auth/refresh", r.authHandler.RefreshSession)
+	mux.HandleFunc("POST /api/v1/auth/logout-all", r.authHandler.LogoutAllSessions)
+	mux.HandleFunc("GET /api/v1/auth/sessions", r.authHandler.ListSessions)
+	mux.HandleFunc("DELETE /api/v1/auth/sessions/{session_id}", r.authHandler.RevokeSession)
+	mux.HandleFunc("GET /api/v1/auth/oauth/{provider}", gateway.RejectWhileDraining(r.oauthHandler.Begin, r.drainer))
+	mux.HandleFunc("GET /api/v1/auth/oauth/{provider}/callback", gateway.RejectWhileDraining(r.oauthHandler.Callback, r.drainer))
+	mux.HandleFunc("POST /api/v1/auth/magic-link", gateway.RejectWhileDraining(r.magicLink.Request, r.drainer))
+	mux.HandleFunc("GET /api/v1/auth/magic-link/verify", gateway.RejectWhileDraining(r.magicLink.Verify, r.drainer))
+
+	// Runtime introspection and controls (admin only)
+	adminHandler := admin.NewHandler(r.serviceProxy, r.responseCache, r.rateLimiter, r.authHandler, r.maintenance, r.audit, r.config)
+	mux.Handle(admin.PathPrefix+"/", gateway.AdminMiddleware(adminHandler))
+
+	// Upstream routes declared in the route config
+	mux.HandleFunc("/api/", r.handleConfiguredRoute)
+
+	// Composite endpoints aggregated from several services
+	r.registerCompositeRoutes(mux)
+
+	// GraphQL layer over the REST services
+	mux.Handle("/graphql", r.graphql)
 
 	// File upload routes
 	mux.HandleFunc("/api/v1/upload", r.handleUploadRoutes)
 	mux.HandleFunc("/api/v1/upload/", r.handleUploadRoutes)
 
-	// Webhook routes
-	mux.HandleFunc("/api/v1/webhooks/", r.handleWebhookRoutes)
-
-	// API documentation
-	mux.HandleFunc("/docs", r.handleDocsRoutes)
-	mux.HandleFunc("/docs/", r.handleDocsRoutes)
+	// API documentation: Swagger UI over the gateway and backend specs
+	mux.Handle("GET /docs", r.docs)
+	mux.Handle("GET /docs/", r.docs)
 
 	// Apply global middlewares
-	handler := r.applyMiddlewares(mux)
+	handler := r.applyMiddlewares(mux, metricsRoutes{mux: mux, routes: r.routes})
 
 	return handler
 }
 
-func (r *Router) handleUserRoutes(w http.ResponseWriter, req *http.Request) {
-	// Apply authentication middleware for protected routes
-	protectedRoutes := []string{
-		"/api/v1/users/profile",
-		"/api/v1/users/change-password",
-		"/api/v1/users/upload-avatar",
+func (r *Router) handleConfiguredRoute(w http.ResponseWriter, req *http.Request) {
+	route, pathMatched := r.routes.match(req.URL.Path, req.Method)
+	if route == nil {
+		if pathMatched {
+			utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		} else {
+			utils.SendError(w, http.StatusNotFound, "Endpoint not found")
+		}
+		return
+	}
+
+	if route.Deprecated != nil {
+		ctx := req.Context()
+		if userSession, ok := gateway.UserSessionFromContext(ctx); ok {
+			ctx = logger.WithUserID(ctx, strconv.FormatUint(uint64(userSession.UserID), 10))
+		}
+		middleware.MarkDeprecated(w, req.WithContext(ctx), *route.Deprecated)
 	}
 
-	if r.isProtectedRoute(req.URL.Path, protectedRoutes) ||
-		(req.URL.Path == "/api/v1/users" && req.Method != "POST") {
+	switch route.Auth {
+	case config.AuthGuest:
+		_, signedIn := gateway.UserSessionFromContext(req.Context())
+		if !signedIn && !r.guests.Enabled() {
+			utils.SendError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		if !signedIn {
+			if err := r.guests.Resolve(w, req); err != nil {
+				logger.ErrorWithStack(req.Context(), err, "Failed to start guest session")
+				utils.SendError(w, http.StatusServiceUnavailable, "Failed to start guest session")
+				return
+			}
+		}
+	case config.AuthRequired:
 		if !r.isAuthenticated(req) {
 			utils.SendError(w, http.StatusUnauthorized, "Authentication required")
 			return
 		}
-	}
-
-	// Remove /api/v1 prefix and forward to user service
-	newPath := strings.TrimPrefix(req.URL.Path, "/api/v1")
-	req.URL.Path = newPath
-	r.serviceProxy.ProxyToService("user", w, req)
-}
-
-func (r *Router) handleProductRoutes(w http.ResponseWriter, req *http.Request) {
-	// Apply authentication middleware for write operations
-	if req.Method == "POST" || req.Method == "PUT" || req.Method == "DELETE" {
+	case config.AuthAdmin:
 		if !r.isAuthenticated(req) {
 			utils.SendError(w, http.StatusUnauthorized, "Authentication required")
 			return
 		}
-
-		// Check admin role for product management
 		if !r.isAdmin(req) {
 			utils.SendError(w, http.StatusForbidden, "Admin access required")
 			return
 		}
 	}
 
-	// Remove /api/v1 prefix and forward to product service
-	newPath := strings.TrimPrefix(req.URL.Path, "/api/v1")
-	req.URL.Path = newPath
-	r.serviceProxy.ProxyToService("product", w, req)
-}
-
-func (r *Router) handleOrderRoutes(w http.ResponseWriter, req *http.Request) {
-	// All order routes require authentication
-	if !r.isAuthenticated(req) {
-		utils.SendError(w, http.StatusUnauthorized, "Authentication required")
+	// Routes behind a feature flag stay hidden from callers without it
+	if route.Feature != "" && !r.features.Enabled(req.Context(), route.Feature) {
+		utils.SendError(w, http.StatusNotFound, "Endpoint not found")
 		return
 	}
 
-	// Admin can access all orders
-	adminRoutes := []string{
-		"/api/v1/orders/admin",
-		"/api/v1/orders/analytics",
-		"/api/v1/orders/export",
+	if len(route.Permissions) > 0 {
+		if missing, ok := r.missingPermission(req, route.Permissions); !ok {
+			utils.SendError(w, http.StatusForbidden, "Missing permission: "+string(missing))
+			return
+		}
 	}
 
-	if r.isProtectedRoute(req.URL.Path, adminRoutes) {
-		if !r.isAdmin(req) {
-			utils.SendError(w, http.StatusForbidden, "Admin access required")
+	if route.Group != nil {
+		if status, message := r.checkGroupAccess(req, route); status != 0 {
+			utils.SendError(w, status, message)
 			return
 		}
 	}
 
-	// Remove /api/v1 prefix and forward to order service
-	newPath := strings.TrimPrefix(req.URL.Path, "/api/v1")
-	req.URL.Path = newPath
-	r.serviceProxy.ProxyToService("order", w, req)
-}
+	if route.Webhook != "" {
+		if err := r.webhooks.Verify(route.Webhook, req); err != nil {
+			logger.Warn(req.Context(), "Webhook rejected",
+				"provider", route.Webhook,
+				"path", req.URL.Path,
+				"client_ip", req.RemoteAddr,
+				"error", err,
+			)
+			utils.SendError(w, http.StatusUnauthorized, "Invalid webhook signature")
+			return
+		}
+	}
 
-func (r *Router) handleAdminRoutes(w http.ResponseWriter, req *http.Request) {
-	// Admin routes require authentication and admin role
-	if !r.isAuthenticated(req) {
-		utils.SendError(w, http.StatusUnauthorized, "Authentication required")
+	if r.validator != nil {
+		if err := r.validator.Validate(req); err != nil {
+			var validationErrors appErrors.ValidationErrors
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case errors.As(err, &validationErrors):
+				utils.SendValidationError(w, validationErrors)
+			case errors.As(err, &maxBytesErr):
+				utils.SendError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+			default:
+				utils.SendError(w, http.StatusBadRequest, "Failed to read request body")
+			}
+			return
+		}
+	}
+
+	if route.Protocol == config.ProtocolGRPC {
+		r.grpcProxy.Transcode(route.Service, route.GRPCMethod, w, req)
 		return
 	}
 
-	if !r.isAdmin(req) {
-		utils.SendError(w, http.StatusForbidden, "Admin access required")
+	proxyHandler := func(w http.ResponseWriter, req *http.Request) {
+		if route.StripPrefix != "" {
+			req.URL.Path = strings.TrimPrefix(req.URL.Path, route.StripPrefix)
+			if req.URL.Path == "" {
+				req.URL.Path = "/"
+			}
+		}
+		r.serviceProxy.ProxyToService(r.versionedService(req, route.Service), w, req)
+	}
+
+	if route.Fallback != nil && r.fallback != nil {
+		upstream := proxyHandler
+		proxyHandler = func(w http.ResponseWriter, req *http.Request) {
+			authState := cache.AuthState(r.extractSessionID(req))
+			r.fallback.Serve(w, req, route.Fallback, authState, upstream)
+		}
+	}
+
+	if r.responseCache == nil {
+		proxyHandler(w, req)
 		return
 	}
 
-	// Route to appropriate service based on path
-	path := req.URL.Path
-	switch {
-	case strings.HasPrefix(path, "/api/v1/admin/users"):
-		req.URL.Path = strings.TrimPrefix(path, "/api/v1/admin")
-		r.serviceProxy.ProxyToService("user", w, req)
-	case strings.HasPrefix(path, "/api/v1/admin/products"):
-		req.URL.Path = strings.TrimPrefix(path, "/api/v1/admin")
-		r.serviceProxy.ProxyToService("product", w, req)
-	case strings.HasPrefix(path, "/api/v1/admin/orders"):
-		req.URL.Path = strings.TrimPrefix(path, "/api/v1/admin")
-		r.serviceProxy.ProxyToService("order", w, req)
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if route.CacheTTL > 0 {
+			authState := cache.AuthState(r.extractSessionID(req))
+			r.responseCache.Serve(w, req, authState, route.CacheTTL.Duration(), proxyHandler)
+			return
+		}
+		proxyHandler(w, req)
 	default:
-		utils.SendError(w, http.StatusNotFound, "Admin endpoint not found")
+		purgePrefixes := append([]string{route.PathPrefix}, route.PurgePrefixes...)
+		r.responseCache.InvalidateOnWrite(w, req, purgePrefixes, proxyHandler)
+	}
+}
+
+// matchRoute returns the configured route for path and method, if any
+func (r *Router) matchRoute(path, method string) *config.RouteConfig {
+	route, _ := r.routes.match(path, method)
+	return route
+}
+
+// flagSubject evaluates feature flags for the user of the session, by ID
+// and role, or anonymously
+func flagSubject(req *http.Request) featureflag.Subject {
+	userSession, ok := gateway.UserSessionFromContext(req.Context())
+	if !ok {
+		return featureflag.Subject{}
+	}
+	return featureflag.Subject{
+		ID:         strconv.FormatUint(uint64(userSession.UserID), 10),
+		Attributes: map[string]string{"role": string(rbac.NormalizeRole(userSession.Role))},
 	}
 }
 
+// metricsRoutes labels proxied requests with the path prefix of their
+// configured route rather than the /api/ pattern they all share
+type metricsRoutes struct {
+	mux    *utils.ServeMux
+	routes *routeTable
+}
+
+func (m metricsRoutes) Handler(req *http.Request) (http.Handler, string) {
+	handler, pattern := m.mux.Handler(req)
+	if pattern == "/api/" {
+		if route, _ := m.routes.match(req.URL.Path, req.Method); route != nil {
+			return handler, route.PathPrefix
+		}
+	}
+	return handler, pattern
+}
+
+// isPublicRoute reports whether the route config allows anonymous access
+func (r *Router) isPublicRoute(req *http.Request) bool {
+	// The refresh endpoint authenticates with the refresh token
+	if req.URL.Path == "/api/v1/auth/refresh" {
+		return true
+	}
+
+	// OAuth redirects and callbacks and magic links arrive before the user
+	// has a session
+	if strings.HasPrefix(req.URL.Path, "/api/v1/auth/oauth/") || strings.HasPrefix(req.URL.Path, "/api/v1/auth/magic-link") {
+		return true
+	}
+
+	route, _ := r.routes.match(req.URL.Path, req.Method)
+	return route != nil && route.Auth == config.AuthNone
+}
+
+// isGuestRoute reports whether the route serves guests while guest sessions
+// are enabled; signing in is optional there
+func (r *Router) isGuestRoute(req *http.Request) bool {
+	if !r.guests.Enabled() {
+		return false
+	}
+	route, _ := r.routes.match(req.URL.Path, req.Method)
+	return route != nil && route.Auth == config.AuthGuest
+}
+
 func (r *Router) handleUploadRoutes(w http.ResponseWriter, req *http.Request) {
 	// File upload requires authentication
 	if !r.isAuthenticated(req) {
@@ -223,40 +374,15 @@ func (r *Router) handleUploadRoutes(w http.ResponseWriter, req *http.Request) {
 	switch uploadType {
 	case "avatar", "profile":
 		req.URL.Path = strings.TrimPrefix(req.URL.Path, "/api/v1")
-		r.serviceProxy.ProxyToService("user", w, req)
+		r.serviceProxy.ProxyToService(r.versionedService(req, "user"), w, req)
 	case "product", "category":
 		req.URL.Path = strings.TrimPrefix(req.URL.Path, "/api/v1")
-		r.serviceProxy.ProxyToService("product", w, req)
+		r.serviceProxy.ProxyToService(r.versionedService(req, "product"), w, req)
 	default:
 		utils.SendError(w, http.StatusBadRequest, "Invalid upload type")
 	}
 }
 
-func (r *Router) handleWebhookRoutes(w http.ResponseWriter, req *http.Request) {
-	// Webhook routes don't require authentication but should validate webhook signature
-	path := req.URL.Path
-
-	switch {
-	case strings.HasPrefix(path, "/api/v1/webhooks/payment"):
-		req.URL.Path = strings.TrimPrefix(path, "/api/v1")
-		r.serviceProxy.ProxyToService("order", w, req)
-	case strings.HasPrefix(path, "/api/v1/webhooks/notification"):
-		req.URL.Path = strings.TrimPrefix(path, "/api/v1")
-		r.serviceProxy.ProxyToService("user", w, req)
-	default:
-		utils.SendError(w, http.StatusNotFound, "Webhook endpoint not found")
-	}
-}
-
-func (r *Router) handleDocsRoutes(w http.ResponseWriter, req *http.Request) {
-	// Serve API documentation
-	utils.SendSuccess(w, http.StatusOK, "API Documentation", map[string]string{
-		"swagger": "/docs/swagger.json",
-		"postman": "/docs/postman.json",
-		"version": "v1.0.0",
-	})
-}
-
 func (r *Router) handleHealthCheck(w http.ResponseWriter, req *http.Request) {
 	utils.SendSuccess(w, http.StatusOK, "API Gateway is healthy", map[string]interface{}{
 		"status":    "healthy",
@@ -266,15 +392,41 @@ func (r *Router) handleHealthCheck(w http.ResponseWriter, req *http.Request) {
 			"product": r.serviceProxy.IsServiceHealthy("product"),
 			"order":   r.serviceProxy.IsServiceHealthy("order"),
 		},
+		"circuits": map[string]string{
+			"user":    r.serviceProxy.CircuitState("user"),
+			"product": r.serviceProxy.CircuitState("product"),
+			"order":   r.serviceProxy.CircuitState("order"),
+		},
+		"upstreams": map[string][]proxy.TargetHealth{
+			"user":    r.serviceProxy.HealthReport("user"),
+			"product": r.serviceProxy.HealthReport("product"),
+			"order":   r.serviceProxy.HealthReport("order"),
+		},
 	})
 }
 
-func (r *Router) applyMiddlewares(handler http.Handler) http.Handler {
-	handler = middleware.Timeout(r.config.Server.RequestTimeout)(handler)
+// handleReadinessCheck fails while the gateway drains so load balancers stop
+// sending it new traffic
+func (r *Router) handleReadinessCheck(w http.ResponseWriter, req *http.Request) {
+	if r.drainer.Draining() {
+		utils.SendError(w, http.StatusServiceUnavailable, "API Gateway is draining")
+		return
+	}
+	r.handleHealthCheck(w, req)
+}
+
+func (r *Router) applyMiddlewares(handler http.Handler, routes middleware.RouteMatcher) http.Handler {
+	// Request timeout, overridable per path prefix
+	handler = r.requestTimeout(handler)
 
 	// Security headers middleware
 	handler = middleware.SecurityHeaders()(handler)
 
+	// HSTS only when the gateway terminates TLS itself
+	if tlsConfig := r.config.Server.TLS; tlsConfig.Enabled() && tlsConfig.HSTSMaxAge > 0 {
+		handler = gateway.HSTS(handler, tlsConfig.HSTSMaxAge)
+	}
+
 	// Request ID middleware
 	handler = middleware.Chain(
 		func(next http.Handler) http.Handler {
@@ -303,39 +455,106 @@ func (r *Router) applyMiddlewares(handler http.Handler) http.Handler {
 		},
 	)(handler)
 
-	// Rate limiting middleware
-	// rateLimitConfig := gateway.RateLimitConfig{
-	// 	RequestsPerMinute: r.config.RateLimit.RequestsPerMinute,
-	// 	WindowSize:        r.config.RateLimit.WindowSize,
-	// }
-	// handler = func(next http.Handler) http.Handler {
-	// 	return gateway.RateLimit(next, rateLimitConfig)
-	// }(handler)
+	// Replay responses to retried writes (runs after session auth so keys
+	// are scoped per user)
+	if r.config.Idempotency.Enabled {
+		handler = r.idempotency.Middleware(handler)
+	}
+
+	// Per-route rate limiting (runs after session auth to identify users)
+	if r.config.RateLimit.Enabled {
+		handler = r.rateLimiter.Middleware(handler)
+	}
+
+	// Audit admin changes to user accounts (runs after session auth to
+	// identify the admin)
+	handler = r.auditUserAdmin(handler)
+
+	// Key sticky upstream routing on the user rather than the session
+	handler = withUserAffinity(handler)
+
+	// Tell upstreams who the user is (runs after session auth)
+	handler = r.withUserIdentity(handler)
+
+	// Maintenance mode (runs after session auth so admins get through)
+	handler = gateway.MaintenanceMiddleware(handler, r.maintenance)
+
+	// Feature flags are evaluated for the signed-in user
+	handler = featureflag.Middleware(flagSubject)(handler)
 
 	// Session authentication middleware
 	handler = func(next http.Handler) http.Handler {
-		return gateway.SessionAuthMiddleware(next, r.authHandler)
+		return gateway.SessionAuthMiddleware(next, r.authHandler, r.isPublicRoute, r.isGuestRoute)
 	}(handler)
 
+	// Reject oversized bodies before authenticating or proxying them
+	handler = middleware.BodyLimit(middleware.BodyLimitConfig{
+		MaxBytes:       r.config.Server.MaxBodySize,
+		UploadMaxBytes: r.config.Server.MaxUploadSize,
+		UploadPaths:    []string{"/api/v1/upload", "/api/v1/users/import", "/api/v1/admin/users/import"},
+		Rules:          r.routes.bodyLimits(),
+	})(handler)
+
+	// 304 for unchanged GET responses
+	handler = middleware.ETag()(handler)
+
 	// CORS middleware
 	handler = middleware.CORS()(handler)
 
-	// Logging middleware
-	handler = middleware.Logging()(handler)
+	// IP allow/deny lists, checked before anything else touches the request
+	if r.ipFilter != nil && r.ipFilter.Enabled() {
+		handler = gateway.IPFilterMiddleware(handler, r.ipFilter)
+	}
+
+	// Request counts and durations per route
+	handler = middleware.Metrics(metrics.Default(), routes)(handler)
+
+	// Name the trace span after the route, now the path is versioned
+	handler = middleware.TraceRoute(routes)(handler)
+
+	// Resolve the API version, so everything after sees a versioned path
+	handler = r.resolveVersion(handler)
+
+	// Track in-flight requests so shutdown can wait for them
+	handler = gateway.DrainMiddleware(handler, r.drainer)
+
+	// Find the client behind the trusted proxies, for rate limits and
+	// everything else keyed on the client address
+	handler = clientip.Middleware(r.clientIPs)(handler)
+
+	// Access log, sampled per path
+	handler = middleware.LoggingWithConfig(r.accessLogConfig())(handler)
+
+	// Continue the caller's trace, outside Logging so logs carry the trace ID
+	handler = middleware.Tracing()(handler)
 
 	// Recovery middleware (outermost - applied first)
 	handler = middleware.Recovery()(handler)
 
+	// Translate error messages, including those of recovered panics
+	handler = middleware.Localize(appErrors.DefaultCatalog())(handler)
+
+	// Unwrapped responses for clients sending X-Envelope: raw
+	handler = middleware.Envelope(appErrors.EnvelopeWrapped)(handler)
+
+	// Longer per-route timeouts also need longer connection deadlines
+	handler = r.extendDeadlines(handler)
+
 	return handler
 }
 
-func (r *Router) isProtectedRoute(path string, protectedRoutes []string) bool {
-	for _, route := range protectedRoutes {
-		if strings.HasPrefix(path, route) {
-			return true
-		}
+func (r *Router) accessLogConfig() middleware.LoggingConfig {
+	logConfig := middleware.LoggingConfig{
+		SampleRate:    r.config.AccessLog.SampleRate,
+		SlowThreshold: r.config.AccessLog.SlowThreshold,
+	}
+	for _, rule := range r.config.AccessLog.Rules {
+		logConfig.SampleRules = append(logConfig.SampleRules, middleware.LogSampleRule{
+			PathPrefix: rule.PathPrefix,
+			SampleRate: rule.SampleRate,
+		})
 	}
-	return false
+	return logConfig
 }
 
 func (r *Router) isAuthenticated(req *http.Request) bool {
@@ -359,6 +578,50 @@ func (r *Router) isAdmin(req *http.Request) bool {
 	return r.authHandler.IsAdmin(req.Context(), sessionID)
 }
 
+// missingPermission returns the first of permissions the caller's role does
+// not grant, reporting false in that case
+func (r *Router) missingPermission(req *http.Request, permissions []rbac.Permission) (rbac.Permission, bool) {
+	userSession, err := r.authHandler.ValidateSession(req.Context(), r.extractSessionID(req))
+	if err != nil {
+		return permissions[0], false
+	}
+	for _, permission := range permissions {
+		if !userSession.Can(permission) {
+			return permission, false
+		}
+	}
+	return "", true
+}
+
+// checkGroupAccess admits the caller to a group-scoped route when they are
+// an admin or hold the route's minimum role in the group named by the path
+// segment after the route prefix. Otherwise it returns the status and
+// message to answer with.
+func (r *Router) checkGroupAccess(req *http.Request, route *config.RouteConfig) (int, string) {
+	userSession, err := r.authHandler.ValidateSession(req.Context(), r.extractSessionID(req))
+	if err != nil {
+		return http.StatusUnauthorized, "Authentication required"
+	}
+
+	rest := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, route.PathPrefix), "/")
+	group, _, _ := strings.Cut(rest, "/")
+	if group == "" {
+		return http.StatusNotFound, "Endpoint not found"
+	}
+
+	if userSession.IsAdmin() {
+		return 0, ""
+	}
+	role, ok := userSession.GroupRole(group)
+	if !ok {
+		return http.StatusForbidden, "Not a member of this group"
+	}
+	if !role.AtLeast(route.Group.MinRole) {
+		return http.StatusForbidden, "Group role required: " + string(route.Group.MinRole)
+	}
+	return 0, ""
+}
+
 func (r *Router) extractSessionID(req *http.Request) string {
 	// Try cookie first
 	cookie, err := req.Cookie("session_id")
@@ -376,6 +639,47 @@ func (r *Router) extractSessionID(req *http.Request) string {
 	return req.Header.Get("X-Session-ID")
 }
 
+// withUserIdentity replaces client-supplied user headers with the
+// authenticated user: X-User-ID, and X-User-Identity signed for services to
+// verify when an identity secret is configured. X-Guest-ID is only ever set
+// by the gateway for guest routes.
+func (r *Router) withUserIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.Header.Del("X-User-ID")
+		req.Header.Del(identity.Header)
+		req.Header.Del(handler.GuestHeader)
+
+		if userSession, ok := gateway.UserSessionFromContext(req.Context()); ok {
+			req.Header.Set("X-User-ID", strconv.FormatUint(uint64(userSession.UserID), 10))
+			if r.identity != nil {
+				signed, err := r.identity.Sign(identity.Identity{
+					UserID: userSession.UserID,
+					Email:  userSession.Email,
+					Role:   userSession.Role,
+				})
+				if err != nil {
+					logger.ErrorWithStack(req.Context(), err, "Failed to sign user identity")
+				} else {
+					req.Header.Set(identity.Header, signed)
+				}
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
 func generateRequestID() string {
 	return time.Now().Format("20060102150405.000000")
 }
+
+// withUserAffinity keys sticky upstream routing on the authenticated user, so
+// all of a user's sessions reach the same instance
+func withUserAffinity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if userSession, ok := gateway.UserSessionFromContext(req.Context()); ok {
+			userID := strconv.FormatUint(uint64(userSession.UserID), 10)
+			req = req.WithContext(proxy.WithAffinityKey(req.Context(), userID))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
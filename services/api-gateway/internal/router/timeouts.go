@@ -0,0 +1,86 @@
+package router
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/config"
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/proxy"
+	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
+)
+
+// timeoutTable matches requests against the timeout overrides. The longest
+// matching prefix wins, and rules that list methods win over catch-all rules
+// with the same prefix.
+type timeoutTable struct {
+	rules []config.TimeoutRule
+}
+
+func newTimeoutTable(rules []config.TimeoutRule) *timeoutTable {
+	sorted := make([]config.TimeoutRule, len(rules))
+	copy(sorted, rules)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if len(sorted[i].PathPrefix) != len(sorted[j].PathPrefix) {
+			return len(sorted[i].PathPrefix) > len(sorted[j].PathPrefix)
+		}
+		return len(sorted[i].Methods) > 0 && len(sorted[j].Methods) == 0
+	})
+
+	return &timeoutTable{rules: sorted}
+}
+
+func (t *timeoutTable) match(path, method string) *config.TimeoutRule {
+	for i := range t.rules {
+		rule := &t.rules[i]
+		if config.MatchesPathPrefix(path, rule.PathPrefix) &&
+			(len(rule.Methods) == 0 || slices.Contains(rule.Methods, method)) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// requestTimeout applies the handler timeout of the matching rule, or
+// REQUEST_TIMEOUT, and passes the rule's proxy timeout on to the proxies
+func (r *Router) requestTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		timeout := r.config.Server.RequestTimeout
+		if rule := r.timeouts.match(req.URL.Path, req.Method); rule != nil {
+			if rule.Handler > 0 {
+				timeout = rule.Handler.Duration()
+			}
+			if rule.Proxy > 0 {
+				req = req.WithContext(proxy.WithTimeout(req.Context(), rule.Proxy.Duration()))
+			}
+		}
+		middleware.Timeout(timeout)(next).ServeHTTP(w, req)
+	})
+}
+
+// extendDeadlines lifts the server's read and write deadlines for routes
+// whose handler timeout is longer than READ_TIMEOUT or WRITE_TIMEOUT, so
+// slow uploads and long exports are not cut off by the connection. It must
+// wrap the server's own ResponseWriter.
+func (r *Router) extendDeadlines(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rule := r.timeouts.match(req.URL.Path, req.Method)
+		if rule == nil || rule.Handler <= 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		timeout := rule.Handler.Duration()
+		controller := http.NewResponseController(w)
+		if readTimeout := r.config.Server.ReadTimeout; readTimeout > 0 && timeout > readTimeout {
+			_ = controller.SetReadDeadline(time.Now().Add(timeout))
+		}
+		if writeTimeout := r.config.Server.WriteTimeout; writeTimeout > 0 && timeout > writeTimeout {
+			// Leave room to write the timeout response itself
+			_ = controller.SetWriteDeadline(time.Now().Add(timeout + time.Second))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,110 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dhekaag/golang-microservices/services/api-gateway/internal/proxy"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+)
+
+const apiVersionHeader = "X-API-Version"
+
+var versionSegment = regexp.MustCompile(`^v[0-9]+$`)
+
+type apiVersionContextKey struct{}
+
+// resolveVersion works out which API version a request under /api/ targets.
+// Unversioned paths get the version negotiated from the Accept header, or
+// the default, spliced into the path. A version whose path has no route
+// entries of its own inherits the default version's routes, so a v2 only
+// needs entries for the endpoints that changed.
+func (r *Router) resolveVersion(next http.Handler) http.Handler {
+	versions := r.config.APIVersions
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rest, isAPI := strings.CutPrefix(req.URL.Path, "/api/")
+		if !isAPI {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		version, remainder, hasRemainder := strings.Cut(rest, "/")
+		if versionSegment.MatchString(version) {
+			if !slices.Contains(versions.Supported, version) {
+				utils.SendError(w, http.StatusNotFound, fmt.Sprintf("API version %s is not supported", version))
+				return
+			}
+		} else {
+			version = r.negotiateVersion(req)
+			remainder, hasRemainder = rest, rest != ""
+			w.Header().Add("Vary", "Accept")
+		}
+		if hasRemainder {
+			remainder = "/" + remainder
+		}
+
+		path := "/api/" + version + remainder
+		if version != versions.Default {
+			if _, pathMatched := r.routes.match(path, req.Method); !pathMatched {
+				path = "/api/" + versions.Default + remainder
+			}
+		}
+
+		if path != req.URL.Path {
+			u := *req.URL
+			u.Path = path
+			u.RawPath = ""
+			req = req.Clone(req.Context())
+			req.URL = &u
+		}
+
+		w.Header().Set(apiVersionHeader, version)
+		req.Header.Set(apiVersionHeader, version)
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), apiVersionContextKey{}, version)))
+	})
+}
+
+// negotiateVersion reads the version from an Accept media type such as
+// application/vnd.gateway.v2+json or application/json;version=2, falling
+// back to the default for anything unsupported
+func (r *Router) negotiateVersion(req *http.Request) string {
+	versions := r.config.APIVersions
+	vendorPrefix := versions.MediaType + "."
+
+	for _, accepted := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+
+		var version string
+		if rest, ok := strings.CutPrefix(mediaType, vendorPrefix); ok {
+			version, _, _ = strings.Cut(rest, "+")
+		} else if param := params["version"]; param != "" {
+			version = "v" + strings.TrimPrefix(param, "v")
+		}
+		if slices.Contains(versions.Supported, version) {
+			return version
+		}
+	}
+	return versions.Default
+}
+
+// versionedService returns the upstream serving the request's API version:
+// e.g. "product-v2" when PRODUCT_SERVICE_URL_V2 is set, else serviceName
+func (r *Router) versionedService(req *http.Request, serviceName string) string {
+	version, _ := req.Context().Value(apiVersionContextKey{}).(string)
+	if version == "" || version == r.config.APIVersions.Default {
+		return serviceName
+	}
+	if versioned := proxy.VersionedService(serviceName, version); r.serviceProxy.HasService(versioned) {
+		return versioned
+	}
+	return serviceName
+}
@@ -0,0 +1,259 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validateValue checks a decoded JSON value (numbers as json.Number)
+// against a schema. It supports the keywords the services' specs use;
+// unknown keywords are ignored.
+func (s *spec) validateValue(schema map[string]any, value any, field string, depth int) appErrors.ValidationErrors {
+	schema = s.resolve(schema)
+	if len(schema) == 0 || depth > maxRefDepth {
+		return nil
+	}
+
+	var validationErrors appErrors.ValidationErrors
+	for _, sub := range arrayOf(schema["allOf"]) {
+		validationErrors = append(validationErrors, s.validateValue(objectOf(sub), value, field, depth+1)...)
+	}
+	for _, keyword := range []string{"oneOf", "anyOf"} {
+		alternatives := arrayOf(schema[keyword])
+		if len(alternatives) > 0 && !slices.ContainsFunc(alternatives, func(alternative any) bool {
+			return len(s.validateValue(objectOf(alternative), value, field, depth+1)) == 0
+		}) {
+			validationErrors = append(validationErrors, fieldError(field, "does not match any of the allowed schemas"))
+		}
+	}
+
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); !nullable && schemaType(schema) != "" {
+			validationErrors = append(validationErrors, fieldError(field, "must not be null"))
+		}
+		return validationErrors
+	}
+
+	if expected := schemaType(schema); expected != "" && !hasType(value, expected) {
+		return append(validationErrors, fieldError(field, "must be "+article(expected)))
+	}
+
+	if enum := arrayOf(schema["enum"]); len(enum) > 0 && !slices.ContainsFunc(enum, func(allowed any) bool {
+		return equalJSON(allowed, value)
+	}) {
+		validationErrors = append(validationErrors, fieldError(field, fmt.Sprintf("must be one of %s", formatEnum(enum))))
+	}
+
+	switch value := value.(type) {
+	case string:
+		validationErrors = append(validationErrors, s.validateString(schema, value, field)...)
+	case json.Number:
+		validationErrors = append(validationErrors, validateNumber(schema, value, field)...)
+	case []any:
+		if minItems, ok := number(schema["minItems"]); ok && float64(len(value)) < minItems {
+			validationErrors = append(validationErrors, fieldError(field, fmt.Sprintf("must have at least %v items", minItems)))
+		}
+		if maxItems, ok := number(schema["maxItems"]); ok && float64(len(value)) > maxItems {
+			validationErrors = append(validationErrors, fieldError(field, fmt.Sprintf("must have at most %v items", maxItems)))
+		}
+		if items := objectOf(schema["items"]); items != nil {
+			for i, item := range value {
+				validationErrors = append(validationErrors, s.validateValue(items, item, fmt.Sprintf("%s[%d]", field, i), depth+1)...)
+			}
+		}
+	case map[string]any:
+		validationErrors = append(validationErrors, s.validateObject(schema, value, field, depth)...)
+	}
+	return validationErrors
+}
+
+func (s *spec) validateString(schema map[string]any, value, field string) appErrors.ValidationErrors {
+	var validationErrors appErrors.ValidationErrors
+	length := float64(utf8.RuneCountInString(value))
+	if minLength, ok := number(schema["minLength"]); ok && length < minLength {
+		validationErrors = append(validationErrors, fieldError(field, fmt.Sprintf("must be at least %v characters", minLength)))
+	}
+	if maxLength, ok := number(schema["maxLength"]); ok && length > maxLength {
+		validationErrors = append(validationErrors, fieldError(field, fmt.Sprintf("must be at most %v characters", maxLength)))
+	}
+	if expr, ok := schema["pattern"].(string); ok {
+		if pattern := s.pattern(expr); pattern != nil && !pattern.MatchString(value) {
+			validationErrors = append(validationErrors, fieldError(field, "does not match the required format"))
+		}
+	}
+	if format, ok := schema["format"].(string); ok && !validFormat(format, value) {
+		validationErrors = append(validationErrors, fieldError(field, "must be a valid "+format))
+	}
+	return validationErrors
+}
+
+func validateNumber(schema map[string]any, value json.Number, field string) appErrors.ValidationErrors {
+	parsed, err := value.Float64()
+	if err != nil {
+		return appErrors.ValidationErrors{fieldError(field, "must be a number")}
+	}
+
+	var validationErrors appErrors.ValidationErrors
+	if minimum, ok := number(schema["minimum"]); ok {
+		if exclusive, _ := schema["exclusiveMinimum"].(bool); exclusive && parsed <= minimum {
+			validationErrors = append(validationErrors, fieldError(field, fmt.Sprintf("must be greater than %v", minimum)))
+		} else if parsed < minimum {
+			validationErrors = append(validationErrors, fieldError(field, fmt.Sprintf("must be at least %v", minimum)))
+		}
+	}
+	if maximum, ok := number(schema["maximum"]); ok {
+		if exclusive, _ := schema["exclusiveMaximum"].(bool); exclusive && parsed >= maximum {
+			validationErrors = append(validationErrors, fieldError(field, fmt.Sprintf("must be less than %v", maximum)))
+		} else if parsed > maximum {
+			validationErrors = append(validationErrors, fieldError(field, fmt.Sprintf("must be at most %v", maximum)))
+		}
+	}
+	return validationErrors
+}
+
+func (s *spec) validateObject(schema map[string]any, value map[string]any, field string, depth int) appErrors.ValidationErrors {
+	var validationErrors appErrors.ValidationErrors
+	for _, name := range arrayOf(schema["required"]) {
+		if name, ok := name.(string); ok {
+			if _, present := value[name]; !present {
+				validationErrors = append(validationErrors, fieldError(joinField(field, name), "is required"))
+			}
+		}
+	}
+
+	properties := objectOf(schema["properties"])
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	// Sorted so the errors come back in a stable order
+	slices.Sort(names)
+
+	for _, name := range names {
+		if property, ok := properties[name]; ok {
+			validationErrors = append(validationErrors, s.validateValue(objectOf(property), value[name], joinField(field, name), depth+1)...)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				validationErrors = append(validationErrors, fieldError(joinField(field, name), "is not allowed"))
+			}
+		case map[string]any:
+			validationErrors = append(validationErrors, s.validateValue(additional, value[name], joinField(field, name), depth+1)...)
+		}
+	}
+	return validationErrors
+}
+
+func schemaType(schema map[string]any) string {
+	schemaType, _ := schema["type"].(string)
+	return schemaType
+}
+
+func hasType(value any, expected string) bool {
+	switch expected {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		parsed, ok := new(big.Float).SetString(number.String())
+		return ok && parsed.IsInt()
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	}
+	return true
+}
+
+func validFormat(format, value string) bool {
+	switch format {
+	case "email":
+		address, err := mail.ParseAddress(value)
+		return err == nil && address.Address == value
+	case "uuid":
+		return uuidPattern.MatchString(value)
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, value)
+		return err == nil
+	case "date":
+		_, err := time.Parse(time.DateOnly, value)
+		return err == nil
+	case "uri":
+		parsed, err := url.Parse(value)
+		return err == nil && parsed.IsAbs()
+	}
+	return true
+}
+
+// equalJSON compares enum values, treating numbers by value
+func equalJSON(allowed, value any) bool {
+	if number, ok := value.(json.Number); ok {
+		parsed, err := number.Float64()
+		return err == nil && allowed == parsed
+	}
+	return reflect.DeepEqual(allowed, value)
+}
+
+func formatEnum(enum []any) string {
+	values := make([]string, len(enum))
+	for i, value := range enum {
+		values[i] = fmt.Sprint(value)
+	}
+	return strings.Join(values, ", ")
+}
+
+// number reads a numeric schema keyword; the spec is decoded without
+// UseNumber, so keywords are float64
+func number(node any) (float64, bool) {
+	value, ok := node.(float64)
+	return value, ok
+}
+
+func article(schemaType string) string {
+	switch schemaType {
+	case "integer", "array", "object":
+		return "an " + schemaType
+	}
+	return "a " + schemaType
+}
+
+func fieldError(field, message string) appErrors.ValidationError {
+	if field == "" {
+		field = "body"
+	}
+	return appErrors.ValidationError{Field: field, Message: message}
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
@@ -0,0 +1,217 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// maxRefDepth bounds $ref chains and nested schemas, so a schema that
+// refers to itself cannot recurse forever
+const maxRefDepth = 32
+
+// spec is the part of an OpenAPI document needed to validate requests
+type spec struct {
+	document map[string]any
+	paths    []*pathTemplate
+
+	// patterns caches compiled "pattern" keywords
+	patterns sync.Map
+}
+
+type pathTemplate struct {
+	// segments holds literal segments, and "" for {param} segments
+	segments   []string
+	params     []string
+	operations map[string]*operation
+}
+
+type operation struct {
+	parameters []parameter
+	body       *requestBody
+}
+
+type parameter struct {
+	name     string
+	in       string
+	required bool
+	schema   map[string]any
+}
+
+type requestBody struct {
+	required bool
+	schema   map[string]any
+}
+
+var httpMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+func compile(data []byte) (*spec, error) {
+	var document map[string]any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI spec: %w", err)
+	}
+
+	s := &spec{document: document}
+	for path, item := range objectOf(document["paths"]) {
+		item := s.resolve(objectOf(item))
+		template := newPathTemplate(path)
+		shared := s.parameters(item["parameters"], nil)
+
+		for _, method := range httpMethods {
+			operationItem, ok := item[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			template.operations[strings.ToUpper(method)] = &operation{
+				parameters: s.parameters(operationItem["parameters"], shared),
+				body:       s.requestBody(operationItem["requestBody"]),
+			}
+		}
+		if len(template.operations) > 0 {
+			s.paths = append(s.paths, template)
+		}
+	}
+	return s, nil
+}
+
+func newPathTemplate(path string) *pathTemplate {
+	template := &pathTemplate{operations: make(map[string]*operation)}
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok && strings.HasSuffix(name, "}") {
+			template.segments = append(template.segments, "")
+			template.params = append(template.params, strings.TrimSuffix(name, "}"))
+			continue
+		}
+		template.segments = append(template.segments, segment)
+		template.params = append(template.params, "")
+	}
+	return template
+}
+
+// parameters merges an operation's parameters over the path-level ones
+func (s *spec) parameters(node any, shared []parameter) []parameter {
+	merged := append([]parameter(nil), shared...)
+	for _, entry := range arrayOf(node) {
+		entry := s.resolve(objectOf(entry))
+		name, _ := entry["name"].(string)
+		in, _ := entry["in"].(string)
+		required, _ := entry["required"].(bool)
+		param := parameter{name: name, in: in, required: required || in == "path", schema: objectOf(entry["schema"])}
+
+		replaced := false
+		for i := range merged {
+			if merged[i].name == name && merged[i].in == in {
+				merged[i], replaced = param, true
+			}
+		}
+		if !replaced {
+			merged = append(merged, param)
+		}
+	}
+	return merged
+}
+
+// requestBody returns the JSON body schema, if the operation has one
+func (s *spec) requestBody(node any) *requestBody {
+	body := s.resolve(objectOf(node))
+	for contentType, media := range objectOf(body["content"]) {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+			continue
+		}
+		schema := objectOf(objectOf(media)["schema"])
+		if len(schema) == 0 {
+			return nil
+		}
+		required, _ := body["required"].(bool)
+		return &requestBody{required: required, schema: schema}
+	}
+	return nil
+}
+
+// match returns the operation for path and method along with its path
+// parameters. Literal segments win over parameters, so /users/me is
+// preferred to /users/{id}.
+func (s *spec) match(path, method string) (*operation, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	var best *pathTemplate
+	bestLiterals := -1
+	for _, template := range s.paths {
+		if len(template.segments) != len(segments) || template.operations[method] == nil {
+			continue
+		}
+		literals, matched := 0, true
+		for i, segment := range template.segments {
+			if segment == "" {
+				continue
+			}
+			if segment != segments[i] {
+				matched = false
+				break
+			}
+			literals++
+		}
+		if matched && literals > bestLiterals {
+			best, bestLiterals = template, literals
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	pathParams := make(map[string]string)
+	for i, name := range best.params {
+		if name != "" {
+			pathParams[name] = segments[i]
+		}
+	}
+	return best.operations[method], pathParams
+}
+
+// resolve follows a local $ref such as "#/components/schemas/User"
+func (s *spec) resolve(node map[string]any) map[string]any {
+	for range maxRefDepth {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		pointer, ok := strings.CutPrefix(ref, "#/")
+		if !ok {
+			return nil
+		}
+		var target any = s.document
+		for _, key := range strings.Split(pointer, "/") {
+			key = strings.NewReplacer("~1", "/", "~0", "~").Replace(key)
+			target = objectOf(target)[key]
+		}
+		node = objectOf(target)
+	}
+	return nil
+}
+
+func (s *spec) pattern(expr string) *regexp.Regexp {
+	if cached, ok := s.patterns.Load(expr); ok {
+		return cached.(*regexp.Regexp)
+	}
+	compiled, err := regexp.Compile(expr)
+	if err != nil {
+		// Patterns Go cannot compile are not enforced
+		compiled = nil
+	}
+	s.patterns.Store(expr, compiled)
+	return compiled
+}
+
+func objectOf(node any) map[string]any {
+	object, _ := node.(map[string]any)
+	return object
+}
+
+func arrayOf(node any) []any {
+	array, _ := node.([]any)
+	return array
+}
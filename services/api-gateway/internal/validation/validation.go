@@ -0,0 +1,176 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+)
+
+// SpecSource returns the combined OpenAPI document, or nil while none is
+// available yet. docs.Handler's Spec method satisfies it.
+type SpecSource func(r *http.Request) ([]byte, error)
+
+// Validator checks query, path and JSON body parameters of proxied requests
+// against the combined OpenAPI spec, so malformed input is rejected at the
+// edge. Requests for operations the spec does not document pass unchecked.
+type Validator struct {
+	source   SpecSource
+	cacheTTL time.Duration
+
+	mutex    sync.Mutex
+	spec     *spec
+	loadedAt time.Time
+}
+
+func New(source SpecSource, cacheTTL time.Duration) *Validator {
+	return &Validator{source: source, cacheTTL: cacheTTL}
+}
+
+// Validate returns appErrors.ValidationErrors when r does not match its
+// operation, or another error when the body could not be read. The body is
+// restored for the proxy.
+func (v *Validator) Validate(r *http.Request) error {
+	spec := v.load(r)
+	if spec == nil {
+		return nil
+	}
+	op, pathParams := spec.match(r.URL.Path, r.Method)
+	if op == nil {
+		return nil
+	}
+
+	var validationErrors appErrors.ValidationErrors
+	query := r.URL.Query()
+	for _, param := range op.parameters {
+		switch param.in {
+		case "path":
+			validationErrors = append(validationErrors, spec.validateParam(param, []string{pathParams[param.name]})...)
+		case "query":
+			validationErrors = append(validationErrors, spec.validateParam(param, query[param.name])...)
+		}
+	}
+
+	if op.body != nil && isJSONRequest(r) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		validationErrors = append(validationErrors, spec.validateBody(op.body, body)...)
+	}
+
+	if len(validationErrors) > 0 {
+		return validationErrors
+	}
+	return nil
+}
+
+// load returns the compiled spec, recompiling it once it is older than the
+// cache TTL. If the spec cannot be built the previous one is kept.
+func (v *Validator) load(r *http.Request) *spec {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.spec != nil && time.Since(v.loadedAt) < v.cacheTTL {
+		return v.spec
+	}
+
+	data, err := v.source(r)
+	if err == nil && data == nil {
+		// Not built yet; ask again on the next request
+		return v.spec
+	}
+	v.loadedAt = time.Now()
+	if err == nil {
+		var compiled *spec
+		if compiled, err = compile(data); err == nil {
+			v.spec = compiled
+		}
+	}
+	if err != nil {
+		// Fail open: backends still validate their own input
+		logger.Warn(r.Context(), "OpenAPI spec unavailable for request validation", "error", err)
+	}
+	return v.spec
+}
+
+// isJSONRequest reports whether the body is JSON, as assumed when no
+// Content-Type is sent. Other bodies, such as multipart uploads, are left to
+// the backend.
+func isJSONRequest(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// validateParam checks the raw values of a path or query parameter
+func (s *spec) validateParam(param parameter, values []string) appErrors.ValidationErrors {
+	if len(values) == 0 || values[0] == "" {
+		if param.required {
+			return appErrors.ValidationErrors{{Field: param.name, Message: "is required"}}
+		}
+		return nil
+	}
+
+	schema := s.resolve(param.schema)
+	if schemaType(schema) == "array" {
+		items := make([]any, 0, len(values))
+		for _, value := range values {
+			items = append(items, parseParam(s.resolve(objectOf(schema["items"])), value))
+		}
+		return s.validateValue(schema, items, param.name, 0)
+	}
+
+	validationErrors := s.validateValue(schema, parseParam(schema, values[0]), param.name, 0)
+	for i := range validationErrors {
+		validationErrors[i].Value = values[0]
+	}
+	return validationErrors
+}
+
+// parseParam converts a parameter string to the JSON type its schema
+// expects, leaving it a string when it does not parse so the type check
+// reports it
+func parseParam(schema map[string]any, value string) any {
+	switch schemaType(schema) {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case "boolean":
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	}
+	return value
+}
+
+func (s *spec) validateBody(body *requestBody, data []byte) appErrors.ValidationErrors {
+	if len(bytes.TrimSpace(data)) == 0 {
+		if body.required {
+			return appErrors.ValidationErrors{{Field: "body", Message: "is required"}}
+		}
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return appErrors.ValidationErrors{{Field: "body", Message: fmt.Sprintf("must be valid JSON: %v", err)}}
+	}
+	return s.validateValue(body.schema, value, "", 0)
+}
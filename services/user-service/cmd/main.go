@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -20,6 +21,16 @@ func main() {
 		log.Printf("Warning: Error loading .env file: %v", err)
 	}
 
+	// Manage the schema or seed data and exit, without starting the servers
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "seed":
+			os.Exit(runSeed(os.Args[2:]))
+		}
+	}
+
 	// Load configuration
 	cfg := config.Load()
 
@@ -35,12 +46,17 @@ func main() {
 
 	// Setup HTTP server
 	server := &http.Server{
-		Addr:         ":" + cfg.Server.Port,
-		Handler:      bootstrap.Router.SetupRoutes(),
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           bootstrap.Router.SetupRoutes(),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       120 * time.Second,
 	}
+	// Accept h2c as well, for gateways configured with UPSTREAM_PROTOCOL=h2c
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetUnencryptedHTTP2(true)
 
 	// Start server in a goroutine
 	go func() {
@@ -54,6 +70,25 @@ func main() {
 		}
 	}()
 
+	// Start the internal gRPC API
+	var grpcListener net.Listener
+	if cfg.Server.GRPCPort != "" {
+		grpcListener, err = net.Listen("tcp", ":"+cfg.Server.GRPCPort)
+		if err != nil {
+			log.Fatalf("Failed to listen for gRPC: %v", err)
+		}
+		go func() {
+			appLogger.InfoMsg("Starting gRPC server",
+				"address", grpcListener.Addr().String(),
+			)
+
+			if err := bootstrap.GRPCServer.Serve(grpcListener); err != nil {
+				appLogger.ErrorMsg("Failed to start gRPC server", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// Log successful startup
 	logger.ServiceStarted(cfg.Server.Port)
 
@@ -68,11 +103,27 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	// Let in-flight RPCs finish while HTTP shuts down
+	grpcStopped := make(chan struct{})
+	go func() {
+		if grpcListener != nil {
+			bootstrap.GRPCServer.GracefulStop()
+		}
+		close(grpcStopped)
+	}()
+
 	// Attempt graceful shutdown
 	if err := server.Shutdown(ctx); err != nil {
 		appLogger.ErrorMsg("Server forced to shutdown", "error", err)
 		os.Exit(1)
 	}
 
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		appLogger.ErrorMsg("gRPC server forced to shutdown", "error", ctx.Err())
+		bootstrap.GRPCServer.Stop()
+	}
+
 	logger.ServiceStopped()
 }
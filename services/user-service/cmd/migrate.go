@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/config"
+	"github.com/dhekaag/golang-microservices/shared/pkg/database"
+)
+
+const migrateUsage = `usage: user-service migrate <command>
+
+commands:
+  up          apply all pending migrations
+  down [N]    revert the last N migrations (default 1)
+  version     print the current schema version
+  force V     record version V as applied without running anything,
+              after repairing a failed migration by hand`
+
+// runMigrate handles `user-service migrate ...` and returns the exit code
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	cfg := config.Load()
+	if cfg.Storage != config.StorageMySQL {
+		fmt.Fprintf(os.Stderr, "Nothing to migrate with STORAGE=%s\n", cfg.Storage)
+		return 1
+	}
+	db, err := database.NewDatabaseConnection(*cfg.Database)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	migrator, err := config.NewMigrator(db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load migrations: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed after %d applied: %v\n", applied, err)
+			return 1
+		}
+		fmt.Printf("Applied %d migrations; schema is at version %d\n", applied, migrator.Latest())
+
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, migrateUsage)
+				return 2
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed after %d reverted: %v\n", reverted, err)
+			return 1
+		}
+		fmt.Printf("Reverted %d migrations\n", reverted)
+
+	case args[0] == "version" && len(args) == 1:
+		version, dirty, err := migrator.Version(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read schema version: %v\n", err)
+			return 1
+		}
+		fmt.Printf("version %d of %d", version, migrator.Latest())
+		if dirty {
+			fmt.Print(" (dirty)")
+		}
+		fmt.Println()
+
+	case args[0] == "force" && len(args) == 2:
+		version, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		if err := migrator.Force(ctx, uint(version)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to force schema version: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Schema version set to %d\n", version)
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
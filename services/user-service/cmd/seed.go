@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/config"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/seed"
+	"github.com/dhekaag/golang-microservices/shared/pkg/database"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+)
+
+// runSeed handles `user-service seed [flags]` and returns the exit code.
+// Flags override the SEED_* environment variables.
+func runSeed(args []string) int {
+	cfg := config.Load()
+
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	flags.StringVar(&cfg.Seed.AdminEmail, "admin-email", cfg.Seed.AdminEmail, "email of the admin account")
+	flags.StringVar(&cfg.Seed.AdminPassword, "admin-password", cfg.Seed.AdminPassword, "password of the admin account; generated when empty")
+	flags.IntVar(&cfg.Seed.DemoUsers, "demo-users", cfg.Seed.DemoUsers, "number of demo accounts; 0 seeds only the admin")
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	if cfg.Storage != config.StorageMySQL {
+		fmt.Fprintf(os.Stderr, "STORAGE=%s is seeded when the service starts\n", cfg.Storage)
+		return 1
+	}
+
+	// Seeding is a one-off command, so it logs to stdout only
+	appLogger, err := logger.Init(logger.Config{
+		Level:       cfg.Log.Level,
+		Format:      cfg.Log.Format,
+		ServiceName: cfg.Log.ServiceName,
+		Environment: cfg.Log.Environment,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
+		return 1
+	}
+
+	db, err := database.NewDatabaseConnection(*cfg.Database)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	ctx := context.Background()
+	migrator, err := config.NewMigrator(db)
+	if err == nil {
+		err = migrator.Check(ctx)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Database schema is not ready; run `user-service migrate up` first: %v\n", err)
+		return 1
+	}
+
+	seeder := seed.NewSeeder(repository.NewUserRepository(db), repository.NewGroupRepository(db), appLogger)
+	result, err := seeder.Run(ctx, cfg.Seed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Seeding failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Seeded %d users (%d already existed)", result.UsersCreated, result.UsersExisting)
+	if cfg.Seed.DemoUsers > 0 {
+		fmt.Printf(", %d members of group %q", result.MembersAdded, seed.DemoGroupSlug)
+	}
+	fmt.Println()
+	if result.AdminPassword != "" {
+		fmt.Printf("Admin %s was created with password %s\n", cfg.Seed.AdminEmail, result.AdminPassword)
+	}
+	return 0
+}
@@ -1,42 +1,74 @@
 package config
 
 import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/grpcapi"
 	"github.com/dhekaag/golang-microservices/services/user-service/internal/handler"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/migrations"
 	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository/memory"
 	"github.com/dhekaag/golang-microservices/services/user-service/internal/router"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/seed"
 	"github.com/dhekaag/golang-microservices/services/user-service/internal/service"
 	"github.com/dhekaag/golang-microservices/shared/pkg/database"
+	"github.com/dhekaag/golang-microservices/shared/pkg/events"
+	"github.com/dhekaag/golang-microservices/shared/pkg/identity"
 	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
+	"github.com/dhekaag/golang-microservices/shared/pkg/notify"
+	"github.com/dhekaag/golang-microservices/shared/pkg/session"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+	"github.com/dhekaag/golang-microservices/shared/pkg/userrpc"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/dhekaag/golang-microservices/shared/pkg/validation"
 	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc"
 	"gorm.io/gorm"
 )
 
 type BootstrapConfig struct {
-	DB          *gorm.DB
-	Config      *Config
-	Logger      *logger.Logger
-	Validator   *validator.Validate
-	UserRepo    repository.UserRepository
-	UserService service.UserService
-	UserHandler *handler.UserHandler
-	Router      *router.Router
+	DB           *gorm.DB
+	Config       *Config
+	Logger       *logger.Logger
+	Validator    *validator.Validate
+	UserRepo     repository.UserRepository
+	UserService  service.UserService
+	GroupService service.GroupService
+	Sessions     *session.SessionManager
+	Events       *events.Publisher
+	UserHandler  *handler.UserHandler
+	GroupHandler *handler.GroupHandler
+	Router       *router.Router
+	GRPCServer   *grpc.Server
+
+	shutdownTracing func(context.Context) error
 }
 
-func Bootstrap(config *Config) (*BootstrapConfig, error) {
-	// Initialize logger
-	loggerInstance, err := logger.Init(logger.Config{
-		Level:       "info",
-		Format:      "text",
-		ServiceName: "user-service",
-		Environment: "development",
-	})
+// NewMigrator loads the embedded user-service migrations for db
+func NewMigrator(db *gorm.DB) (*database.Migrator, error) {
+	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, err
 	}
+	return database.NewMigrator(sqlDB, migrations.Files)
+}
 
-	loggerInstance.InfoMsg("Initializing user service...")
+// repositories are the stores of the service, backed by MySQL or memory
+type repositories struct {
+	tx          repository.Transactor
+	users       repository.UserRepository
+	resets      repository.PasswordResetRepository
+	preferences repository.UserPreferencesRepository
+	groups      repository.GroupRepository
+	loginEvents repository.LoginEventRepository
+}
 
-	// Initialize database
+// connectDatabase connects to MySQL and migrates the schema or checks it is
+// up to date
+func connectDatabase(config *Config, loggerInstance *logger.Logger) (*gorm.DB, error) {
 	loggerInstance.InfoMsg("Connecting to database...")
 	db, err := database.NewDatabaseConnection(*config.Database)
 	if err != nil {
@@ -45,41 +77,211 @@ func Bootstrap(config *Config) (*BootstrapConfig, error) {
 	}
 	loggerInstance.InfoMsg("Database connected successfully")
 
-	// Initialize validator
-	validator := validator.New()
-	loggerInstance.InfoMsg("Validator initialized")
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		loggerInstance.ErrorMsg("Failed to load migrations", "error", err)
+		return nil, err
+	}
+	if config.Migrate.OnStart {
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			loggerInstance.ErrorMsg("Failed to migrate database", "error", err)
+			return nil, err
+		}
+		loggerInstance.InfoMsg("Database migrated", "applied", applied, "version", migrator.Latest())
+	}
+	if err := migrator.Check(context.Background()); err != nil {
+		loggerInstance.ErrorMsg("Database schema does not match this build; run `user-service migrate up` or set MIGRATE_ON_START=true", "error", err)
+		return nil, err
+	}
+	return db, nil
+}
+
+func Bootstrap(config *Config) (*BootstrapConfig, error) {
+	// Initialize logger
+	loggerInstance, err := logger.Init(config.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	loggerInstance.InfoMsg("Initializing user service...")
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Enabled:     config.Tracing.Enabled,
+		ServiceName: "user-service",
+		Environment: config.Environment,
+		SampleRatio: config.Tracing.SampleRatio,
+	})
+	if err != nil {
+		loggerInstance.ErrorMsg("Failed to initialize tracing", "error", err)
+		return nil, err
+	}
 
-	// Initialize repository
-	userRepo := repository.NewUserRepository(db)
+	// Initialize storage
+	var db *gorm.DB
+	var repos repositories
+	switch config.Storage {
+	case StorageMySQL:
+		db, err = connectDatabase(config, loggerInstance)
+		if err != nil {
+			return nil, err
+		}
+		repos = repositories{
+			tx:          repository.NewTransactor(db),
+			users:       repository.NewUserRepository(db),
+			resets:      repository.NewPasswordResetRepository(db),
+			preferences: repository.NewUserPreferencesRepository(db),
+			groups:      repository.NewGroupRepository(db),
+			loginEvents: repository.NewLoginEventRepository(db),
+		}
+	case StorageMemory:
+		loggerInstance.WarnMsg("STORAGE is memory; data is lost on restart and not shared between instances")
+		store := memory.NewStore()
+		repos = repositories{
+			tx:          store.Transactor(),
+			users:       store.Users(),
+			resets:      store.PasswordResets(),
+			preferences: store.Preferences(),
+			groups:      store.Groups(),
+			loginEvents: store.LoginEvents(),
+		}
+		// An empty store has no account to sign in with
+		result, err := seed.NewSeeder(repos.users, repos.groups, loggerInstance.Named("seed")).Run(context.Background(), config.Seed)
+		if err != nil {
+			loggerInstance.ErrorMsg("Failed to seed in-memory storage", "error", err)
+			return nil, err
+		}
+		if result.AdminPassword != "" {
+			loggerInstance.WarnMsg("Seeded admin account with a generated password", "email", config.Seed.AdminEmail, "password", result.AdminPassword)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported STORAGE %q", config.Storage)
+	}
+	userRepo := repos.users
 	loggerInstance.InfoMsg("Repository initialized")
 
+	// Initialize validator
+	validator := validation.New()
+	loggerInstance.InfoMsg("Validator initialized")
+
 	// Initialize service
-	userService := service.NewUserService(userRepo, loggerInstance)
+	verification := config.EmailVerification
+	if verification.Secret == "" {
+		// Links then stop working on restart and on other instances
+		verification.Secret, err = utils.GenerateSecureToken(32)
+		if err != nil {
+			return nil, err
+		}
+		loggerInstance.WarnMsg("EMAIL_VERIFICATION_SECRET is not set; verification links are only valid on this instance until it restarts")
+	}
+	if config.Notify.URL == "" {
+		loggerInstance.WarnMsg("NOTIFICATION_URL is not set; emails are logged instead of sent")
+	}
+	var sessions service.SessionRevoker
+	var sessionManager *session.SessionManager
+	if config.Session.Configured() {
+		sessionManager, err = session.NewSessionManager(session.SessionConfig{
+			RedisAddr:        config.Session.RedisAddr,
+			RedisPassword:    config.Session.RedisPassword,
+			RedisDB:          config.Session.RedisDB,
+			SentinelMaster:   config.Session.SentinelMaster,
+			SentinelAddrs:    config.Session.SentinelAddrs,
+			SentinelPassword: config.Session.SentinelPassword,
+			ClusterAddrs:     config.Session.ClusterAddrs,
+			MaxRetries:       config.Session.MaxRetries,
+			MaxRetryBackoff:  config.Session.MaxRetryBackoff,
+			SessionPrefix:    config.Session.Prefix,
+			EncryptionKeys:   config.Session.EncryptionKeys,
+		})
+		if err != nil {
+			loggerInstance.ErrorMsg("Failed to connect to session store", "error", err)
+			return nil, err
+		}
+		sessions = sessionManager
+	} else {
+		loggerInstance.WarnMsg("No session Redis is configured; password resets do not end existing sessions")
+	}
+	eventPublisher, err := events.New(config.Events)
+	if err != nil {
+		loggerInstance.ErrorMsg("Failed to set up event publishing", "error", err)
+		return nil, err
+	}
+	if !eventPublisher.Configured() {
+		loggerInstance.WarnMsg("EVENTS_URL is not set; user events are logged instead of published")
+	}
+	userService := service.NewUserService(
+		repos.tx,
+		repos.users,
+		repos.resets,
+		repos.preferences,
+		repos.groups,
+		repos.loginEvents,
+		loggerInstance.Named("service"),
+		notify.New(config.Notify),
+		eventPublisher,
+		verification,
+		config.PasswordReset,
+		config.LoginHistory,
+		sessions,
+	)
+	groupService := service.NewGroupService(repos.groups, repos.users, loggerInstance.Named("service"))
 	loggerInstance.InfoMsg("Service initialized")
 
 	// Initialize handler
-	userHandler := handler.NewUserHandler(userService, validator, loggerInstance)
+	userHandler := handler.NewUserHandler(userService, validator, loggerInstance.Named("handler"))
+	groupHandler := handler.NewGroupHandler(groupService, validator, loggerInstance.Named("handler"))
 	loggerInstance.InfoMsg("Handler initialized")
 
 	// Initialize router
-	userRouter := router.NewRouter(userHandler)
+	if config.Identity.Secret == "" {
+		loggerInstance.WarnMsg("IDENTITY_SIGNING_SECRET is not set; X-User-ID is not verified")
+	}
+	var legacyQueryRoutes *middleware.Deprecation
+	if config.Server.LegacyQueryRoutes {
+		loggerInstance.WarnMsg("LEGACY_QUERY_ROUTES is enabled; /users?id= routes are deprecated and will be removed")
+		legacyQueryRoutes = &middleware.Deprecation{Sunset: config.Server.LegacyQueryRoutesSunset}
+	}
+	userRouter := router.NewRouter(userHandler, groupHandler, identity.NewSigner(config.Identity.Secret, 0), loggerInstance.Levels(), legacyQueryRoutes, middleware.BodyLimitConfig{
+		MaxBytes:       config.Server.MaxBodySize,
+		UploadMaxBytes: config.Server.MaxUploadSize,
+		UploadPaths:    []string{"/users/import"},
+	})
 	loggerInstance.InfoMsg("Router initialized")
 
+	// Initialize the internal gRPC API, which shares the identity secret
+	// with HTTP
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		middleware.UnaryServerInterceptor(identity.NewSigner(config.Identity.Secret, 0)),
+	))
+	userrpc.RegisterServer(grpcServer, grpcapi.NewUserServer(userService, loggerInstance.Named("grpc")))
+	loggerInstance.InfoMsg("gRPC server initialized")
+
 	loggerInstance.InfoMsg("User service bootstrap completed successfully")
 
 	return &BootstrapConfig{
-		DB:          db,
-		Config:      config,
-		Logger:      loggerInstance,
-		Validator:   validator,
-		UserRepo:    userRepo,
-		UserService: userService,
-		UserHandler: userHandler,
-		Router:      userRouter,
+		DB:           db,
+		Config:       config,
+		Logger:       loggerInstance,
+		Validator:    validator,
+		UserRepo:     userRepo,
+		UserService:  userService,
+		GroupService: groupService,
+		Sessions:     sessionManager,
+		Events:       eventPublisher,
+		UserHandler:  userHandler,
+		GroupHandler: groupHandler,
+		Router:       userRouter,
+		GRPCServer:   grpcServer,
+
+		shutdownTracing: shutdownTracing,
 	}, nil
 }
 
 func (bc *BootstrapConfig) Cleanup() error {
+	// Close the log files last
+	defer bc.Logger.Close()
+
 	bc.Logger.InfoMsg("🧹 Starting cleanup process...")
 
 	if bc.DB != nil {
@@ -94,6 +296,30 @@ func (bc *BootstrapConfig) Cleanup() error {
 		bc.Logger.InfoMsg("Database connection closed")
 	}
 
+	if bc.Sessions != nil {
+		if err := bc.Sessions.Close(); err != nil {
+			bc.Logger.ErrorMsg("Failed to close session store", "error", err)
+			return err
+		}
+	}
+
+	if bc.Events != nil {
+		if err := bc.Events.Close(); err != nil {
+			bc.Logger.ErrorMsg("Failed to close event publisher", "error", err)
+			return err
+		}
+	}
+
+	if bc.shutdownTracing != nil {
+		bc.Logger.InfoMsg("Flushing traces...")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := bc.shutdownTracing(ctx); err != nil {
+			bc.Logger.ErrorMsg("Failed to flush traces", "error", err)
+			return err
+		}
+	}
+
 	bc.Logger.InfoMsg("Cleanup completed successfully")
 	return nil
 }
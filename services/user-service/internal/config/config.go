@@ -3,21 +3,112 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/seed"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/service"
 	"github.com/dhekaag/golang-microservices/shared/pkg/database"
+	"github.com/dhekaag/golang-microservices/shared/pkg/events"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/notify"
 	"github.com/joho/godotenv"
 )
 
+// Storage backends, chosen with STORAGE
+const (
+	StorageMySQL = "mysql"
+	// StorageMemory keeps all data in process memory and seeds it at
+	// startup, for demos and tests without MySQL
+	StorageMemory = "memory"
+)
+
 type Config struct {
 	Server   ServerConfig
+	Storage  string
 	Database *database.DatabaseConfig
+	Migrate  MigrateConfig
+	Tracing  TracingConfig
+	// Environment names the deployment, such as development or production,
+	// in logs and traces
+	Environment string
+	// Log configures the logger: level, format, component levels, files and
+	// export
+	Log      logger.Config
+	Identity IdentityConfig
+	// EmailVerification links are emailed through Notify
+	EmailVerification service.EmailVerificationConfig
+	Notify            notify.Config
+	PasswordReset     service.PasswordResetConfig
+	LoginHistory      service.LoginHistoryConfig
+	Events            events.Config
+	Session           SessionConfig
+	// Seed is used by `user-service seed` only
+	Seed seed.Config
 }
 
 type ServerConfig struct {
 	Port         string
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
+	// ReadHeaderTimeout bounds reading request headers, so slow clients
+	// cannot hold connections open
+	ReadHeaderTimeout time.Duration
+	// MaxBodySize limits request bodies; MaxUploadSize applies to user
+	// imports
+	MaxBodySize   int64
+	MaxUploadSize int64
+	// GRPCPort serves the internal gRPC API; empty disables it
+	GRPCPort string
+	// LegacyQueryRoutes keeps the deprecated /users?id= form of the user
+	// routes working
+	LegacyQueryRoutes bool
+	// LegacyQueryRoutesSunset is announced as the date the legacy routes
+	// stop working; zero announces none
+	LegacyQueryRoutesSunset time.Time
+}
+
+// MigrateConfig controls schema migrations at startup. Without OnStart the
+// service only checks the schema and refuses to start when migrations are
+// pending, leaving them to `user-service migrate up`.
+type MigrateConfig struct {
+	OnStart bool
+}
+
+// TracingConfig controls OpenTelemetry trace export. The collector address
+// comes from OTEL_EXPORTER_OTLP_ENDPOINT.
+type TracingConfig struct {
+	Enabled     bool
+	SampleRatio float64
+}
+
+// IdentityConfig holds the secret shared with the gateway for verifying the
+// X-User-Identity header. Without it, X-User-ID is trusted as sent.
+type IdentityConfig struct {
+	Secret string
+}
+
+// SessionConfig points at the gateway's session store, so that a password
+// reset can end the user's sessions. Without RedisAddr, SentinelMaster or
+// ClusterAddrs sessions are left to expire. Prefix and EncryptionKeys must
+// match the gateway.
+type SessionConfig struct {
+	RedisAddr        string
+	RedisPassword    string
+	RedisDB          int
+	SentinelMaster   string
+	SentinelAddrs    []string
+	SentinelPassword string
+	ClusterAddrs     []string
+	MaxRetries       int
+	MaxRetryBackoff  time.Duration
+	Prefix           string
+	EncryptionKeys   []string
+}
+
+// Configured reports whether a session store is set up
+func (c SessionConfig) Configured() bool {
+	return c.RedisAddr != "" || c.SentinelMaster != "" || len(c.ClusterAddrs) > 0
 }
 
 func Load() *Config {
@@ -25,13 +116,21 @@ func Load() *Config {
 	if err := godotenv.Load(); err != nil {
 		println("Warning: Error loading .env file:", err)
 	}
+	environment := getEnv("ENVIRONMENT", "development")
 
 	return &Config{
 		Server: ServerConfig{
-			Port:         getEnv("PORT", "8081"),
-			ReadTimeout:  getDurationEnv("READ_TIMEOUT", 10*time.Second),
-			WriteTimeout: getDurationEnv("WRITE_TIMEOUT", 10*time.Second),
+			Port:                    getEnv("PORT", "8081"),
+			GRPCPort:                getEnv("GRPC_PORT", "9081"),
+			LegacyQueryRoutes:       getBoolEnv("LEGACY_QUERY_ROUTES", true),
+			LegacyQueryRoutesSunset: getDateEnv("LEGACY_QUERY_ROUTES_SUNSET"),
+			ReadTimeout:             getDurationEnv("READ_TIMEOUT", 10*time.Second),
+			WriteTimeout:            getDurationEnv("WRITE_TIMEOUT", 10*time.Second),
+			ReadHeaderTimeout:       getDurationEnv("READ_HEADER_TIMEOUT", 5*time.Second),
+			MaxBodySize:             int64(getIntEnv("MAX_BODY_SIZE", 1<<20)),
+			MaxUploadSize:           int64(getIntEnv("MAX_UPLOAD_SIZE", 32<<20)),
 		},
+		Storage: getEnv("STORAGE", StorageMySQL),
 		Database: &database.DatabaseConfig{
 			HOST:            getEnv("DB_HOST", "localhost"),
 			Port:            getIntEnv("DB_PORT", 3306),
@@ -43,6 +142,97 @@ func Load() *Config {
 			MaxOpenConns:    getIntEnv("DB_MAX_OPEN_CONNS", 200),
 			ConnMaxLifetime: getDurationEnv("DB_CONN_MAX_LIFETIME", 30*time.Minute),
 			ConnMaxIdleTime: getDurationEnv("DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+
+			ConnectTimeout:    getDurationEnv("DB_CONNECT_TIMEOUT", 60*time.Second),
+			ConnectBackoff:    getDurationEnv("DB_CONNECT_BACKOFF", time.Second),
+			ConnectMaxBackoff: getDurationEnv("DB_CONNECT_MAX_BACKOFF", 10*time.Second),
+
+			LogLevel:           getEnv("DB_LOG_LEVEL", "warn"),
+			SlowQueryThreshold: getDurationEnv("DB_SLOW_QUERY_THRESHOLD", 200*time.Millisecond),
+		},
+		Migrate: MigrateConfig{
+			OnStart: getBoolEnv("MIGRATE_ON_START", false),
+		},
+		Tracing: TracingConfig{
+			Enabled:     getBoolEnv("TRACING_ENABLED", false),
+			SampleRatio: getFloatEnv("TRACING_SAMPLE_RATIO", 1),
+		},
+		Environment: environment,
+		Log: logger.Config{
+			Level:       getEnv("LOG_LEVEL", "info"),
+			Format:      getEnv("LOG_FORMAT", logger.DefaultFormat(environment)),
+			ServiceName: "user-service",
+			Environment: environment,
+			Components:  getEnv("LOG_COMPONENTS", ""),
+			File: logger.FileConfig{
+				Path:       getEnv("LOG_FILE", ""),
+				ErrorPath:  getEnv("LOG_ERROR_FILE", ""),
+				MaxSizeMB:  getIntEnv("LOG_FILE_MAX_SIZE_MB", 100),
+				MaxAgeDays: getIntEnv("LOG_FILE_MAX_AGE_DAYS", 30),
+				MaxBackups: getIntEnv("LOG_FILE_MAX_BACKUPS", 10),
+				Compress:   getBoolEnv("LOG_FILE_COMPRESS", false),
+			},
+			Export: logger.ExportConfig{
+				Protocol:      getEnv("LOG_EXPORT_PROTOCOL", ""),
+				Endpoint:      getEnv("LOG_EXPORT_ENDPOINT", ""),
+				Headers:       getListEnv("LOG_EXPORT_HEADERS"),
+				Level:         getEnv("LOG_EXPORT_LEVEL", ""),
+				BatchSize:     getIntEnv("LOG_EXPORT_BATCH_SIZE", 100),
+				FlushInterval: getDurationEnv("LOG_EXPORT_FLUSH_INTERVAL", time.Second),
+				QueueSize:     getIntEnv("LOG_EXPORT_QUEUE_SIZE", 1000),
+				MaxRetries:    getIntEnv("LOG_EXPORT_MAX_RETRIES", 3),
+				Timeout:       getDurationEnv("LOG_EXPORT_TIMEOUT", 5*time.Second),
+			},
+		},
+		Identity: IdentityConfig{
+			Secret: getEnv("IDENTITY_SIGNING_SECRET", ""),
+		},
+		EmailVerification: service.EmailVerificationConfig{
+			Secret:         getEnv("EMAIL_VERIFICATION_SECRET", ""),
+			TTL:            getDurationEnv("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+			LinkURL:        getEnv("EMAIL_VERIFICATION_URL", "http://localhost:8080/api/v1/auth/verify-email"),
+			ChangeLinkURL:  getEnv("EMAIL_CHANGE_URL", "http://localhost:8080/api/v1/auth/confirm-email-change"),
+			ResendCooldown: getDurationEnv("EMAIL_VERIFICATION_RESEND_COOLDOWN", time.Minute),
+			ResendLimit:    getIntEnv("EMAIL_VERIFICATION_RESEND_LIMIT", 5),
+			ResendWindow:   getDurationEnv("EMAIL_VERIFICATION_RESEND_WINDOW", 24*time.Hour),
+		},
+		Notify: notify.Config{
+			URL:     getEnv("NOTIFICATION_URL", ""),
+			Timeout: getDurationEnv("NOTIFICATION_TIMEOUT", 10*time.Second),
+		},
+		PasswordReset: service.PasswordResetConfig{
+			TTL:     getDurationEnv("PASSWORD_RESET_TTL", time.Hour),
+			LinkURL: getEnv("PASSWORD_RESET_URL", "http://localhost:8080/reset-password"),
+		},
+		LoginHistory: service.LoginHistoryConfig{
+			FailureAlertLimit:  getIntEnv("LOGIN_FAILURE_ALERT_LIMIT", 5),
+			FailureAlertWindow: getDurationEnv("LOGIN_FAILURE_ALERT_WINDOW", 15*time.Minute),
+		},
+		Events: events.Config{
+			URL:     getEnv("EVENTS_URL", ""),
+			Source:  "user-service",
+			Prefix:  getEnv("EVENTS_PREFIX", ""),
+			Timeout: getDurationEnv("EVENTS_TIMEOUT", 5*time.Second),
+		},
+		Session: SessionConfig{
+			RedisAddr:        getEnv("REDIS_ADDR", ""),
+			RedisPassword:    getEnv("REDIS_PASSWORD", ""),
+			RedisDB:          getIntEnv("REDIS_DB", 0),
+			SentinelMaster:   getEnv("REDIS_SENTINEL_MASTER", ""),
+			SentinelAddrs:    getListEnv("REDIS_SENTINEL_ADDRS"),
+			SentinelPassword: getEnv("REDIS_SENTINEL_PASSWORD", ""),
+			ClusterAddrs:     getListEnv("REDIS_CLUSTER_ADDRS"),
+			MaxRetries:       getIntEnv("REDIS_MAX_RETRIES", 3),
+			MaxRetryBackoff:  getDurationEnv("REDIS_MAX_RETRY_BACKOFF", 512*time.Millisecond),
+			Prefix:           getEnv("SESSION_PREFIX", "session"),
+			EncryptionKeys:   getListEnv("SESSION_ENCRYPTION_KEYS"),
+		},
+		Seed: seed.Config{
+			AdminEmail:    getEnv("SEED_ADMIN_EMAIL", "admin@example.com"),
+			AdminName:     getEnv("SEED_ADMIN_NAME", "Administrator"),
+			AdminPassword: getEnv("SEED_ADMIN_PASSWORD", ""),
+			DemoUsers:     getIntEnv("SEED_DEMO_USERS", 10),
+			DemoPassword:  getEnv("SEED_DEMO_PASSWORD", "demo-password"),
 		},
 	}
 }
@@ -55,6 +245,16 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
+func getListEnv(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func getIntEnv(key string, defaultValue int) int {
 	valueStr := os.Getenv(key)
 	if valueStr == "" {
@@ -68,6 +268,41 @@ func getIntEnv(key string, defaultValue int) int {
 	return value
 }
 
+func getBoolEnv(key string, defaultValue bool) bool {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getFloatEnv(key string, defaultValue float64) float64 {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getDateEnv reads a date such as 2026-01-31, as midnight UTC
+func getDateEnv(key string) time.Time {
+	value, err := time.Parse(time.DateOnly, os.Getenv(key))
+	if err != nil {
+		return time.Time{}
+	}
+	return value
+}
+
 func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	valueStr := os.Getenv(key)
 	if valueStr == "" {
@@ -0,0 +1,41 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+)
+
+// Group is an organization users belong to. Its slug names it in URLs
+// alongside the numeric ID, so slugs always contain a letter.
+type Group struct {
+	ID          uint      `gorm:"primaryKey;column:id"`
+	Slug        string    `gorm:"size:50;uniqueIndex;not null;column:slug"`
+	Name        string    `gorm:"size:100;not null;column:name"`
+	Description string    `gorm:"size:500;not null;column:description"`
+	CreatedAt   time.Time `gorm:"autoCreateTime;column:created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime;column:updated_at"`
+}
+
+func (Group) TableName() string {
+	return "tbl_groups"
+}
+
+// GroupMember is a user's membership in a group and their role in it
+type GroupMember struct {
+	GroupID   uint           `gorm:"primaryKey;autoIncrement:false;column:group_id"`
+	UserID    uint           `gorm:"primaryKey;autoIncrement:false;column:user_id;index"`
+	Role      rbac.GroupRole `gorm:"type:enum('OWNER','ADMIN','MEMBER');not null;column:role"`
+	CreatedAt time.Time      `gorm:"autoCreateTime;column:created_at"`
+	UpdatedAt time.Time      `gorm:"autoUpdateTime;column:updated_at"`
+}
+
+func (GroupMember) TableName() string {
+	return "tbl_group_members"
+}
+
+// GroupMembership is a group as seen by one of its members
+type GroupMembership struct {
+	Group `gorm:"embedded"`
+	Role  rbac.GroupRole `gorm:"column:role"`
+}
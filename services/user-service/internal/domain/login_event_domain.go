@@ -0,0 +1,41 @@
+package domain
+
+import "time"
+
+// Login methods recorded in the login history
+const (
+	LoginMethodPassword  = "password"
+	LoginMethodOAuth     = "oauth"
+	LoginMethodMagicLink = "magic_link"
+)
+
+// Login anomalies flagged on events for alerting
+const (
+	// LoginAnomalyNewCountry marks a successful login from a country the
+	// user has not signed in from before
+	LoginAnomalyNewCountry = "new_country"
+	// LoginAnomalyRepeatedFailures marks a failed login that brings the
+	// user's recent failures to the alert limit
+	LoginAnomalyRepeatedFailures = "repeated_failures"
+)
+
+// LoginEvent is a sign-in attempt. UserID is nil for attempts on unknown
+// emails; Country is empty when the gateway could not tell.
+type LoginEvent struct {
+	ID            uint      `gorm:"primaryKey;column:id"`
+	UserID        *uint     `gorm:"column:user_id;index:idx_login_events_user_created,priority:1"`
+	Email         string    `gorm:"size:255;not null;column:email"`
+	Method        string    `gorm:"size:20;not null;column:method"`
+	Provider      string    `gorm:"size:50;not null;column:provider"`
+	Success       bool      `gorm:"not null;column:success"`
+	FailureReason string    `gorm:"size:50;not null;column:failure_reason"`
+	IPAddress     string    `gorm:"size:45;not null;column:ip_address"`
+	UserAgent     string    `gorm:"size:255;not null;column:user_agent"`
+	Country       string    `gorm:"size:2;not null;column:country"`
+	Anomaly       string    `gorm:"size:30;not null;column:anomaly;index"`
+	CreatedAt     time.Time `gorm:"autoCreateTime;column:created_at;index:idx_login_events_user_created,priority:2"`
+}
+
+func (LoginEvent) TableName() string {
+	return "tbl_login_events"
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// PasswordResetToken is an issued password reset link. Only the SHA-256
+// hash of the token is stored; UsedAt is set when it is redeemed.
+type PasswordResetToken struct {
+	ID        uint       `gorm:"primaryKey;column:id"`
+	UserID    uint       `gorm:"not null;column:user_id;index"`
+	TokenHash string     `gorm:"type:char(64);uniqueIndex;not null;column:token_hash"`
+	ExpiresAt time.Time  `gorm:"not null;column:expires_at"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+	CreatedAt time.Time  `gorm:"autoCreateTime;column:created_at"`
+}
+
+func (PasswordResetToken) TableName() string {
+	return "tbl_password_reset_tokens"
+}
@@ -3,28 +3,40 @@ package domain
 import (
 	"time"
 
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type EnumRole string
 
+// Roles are defined by the shared rbac package
 const (
-	USER  EnumRole = "USER"
-	ADMIN EnumRole = "ADMIN"
+	USER  EnumRole = EnumRole(rbac.RoleUser)
+	ADMIN EnumRole = EnumRole(rbac.RoleAdmin)
 )
 
 type User struct {
-	ID            uint      `gorm:"primaryKey;column:id"`
-	PublicID      string    `gorm:"uniqueIndex;not null;column:public_id"`
-	Name          string    `gorm:"not null;column:name"`
-	Email         string    `gorm:"uniqueIndex;not null;column:email"`
-	EmailVerified bool      `gorm:"default:false;column:email_verified"`
-	Image         *string   `gorm:"column:image"`
-	Role          EnumRole  `gorm:"type:enum('USER','ADMIN');default:'USER';column:role;index"`
-	Password      string    `gorm:"not null;column:password"`
-	CreatedAt     time.Time `gorm:"autoCreateTime;column:created_at;index"`
-	UpdatedAt     time.Time `gorm:"autoUpdateTime;column:updated_at"`
+	ID            uint     `gorm:"primaryKey;column:id"`
+	PublicID      string   `gorm:"uniqueIndex;not null;column:public_id"`
+	Name          string   `gorm:"not null;column:name"`
+	Email         string   `gorm:"uniqueIndex;not null;column:email"`
+	EmailVerified bool     `gorm:"default:false;column:email_verified"`
+	Image         *string  `gorm:"column:image"`
+	Role          EnumRole `gorm:"type:enum('USER','ADMIN');default:'USER';column:role;index"`
+	Password      string   `gorm:"not null;column:password"`
+	PendingEmail  *string  `gorm:"column:pending_email"` // Replaces Email once confirmed
+	// Deactivated accounts cannot sign in; the account and its data stay
+	IsActive      bool       `gorm:"not null;default:true;column:is_active;index"`
+	DeactivatedAt *time.Time `gorm:"column:deactivated_at"`
+	// PasswordResetAt is when the password was last reset through a reset
+	// link; tokens issued before then are no longer honored
+	PasswordResetAt *time.Time `gorm:"column:password_reset_at"`
+	CreatedAt       time.Time  `gorm:"autoCreateTime;column:created_at;index"`
+	UpdatedAt       time.Time  `gorm:"autoUpdateTime;column:updated_at"`
+	// Version counts the changes to the row; updates only apply to the
+	// version they were read at
+	Version uint `gorm:"not null;default:1;column:version"`
 }
 
 // BeforeCreate hook to generate PublicID
@@ -32,6 +44,15 @@ func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
 	if u.PublicID == "" {
 		u.PublicID = uuid.New().String()
 	}
+	// Set here rather than left to the column default, so the version is
+	// known without reading the row back
+	if u.Version == 0 {
+		u.Version = 1
+	}
+	// Accounts start active
+	if u.DeactivatedAt == nil {
+		u.IsActive = true
+	}
 	return
 }
 
@@ -41,26 +62,34 @@ func (User) TableName() string {
 
 func (u *User) ToResponse() UserResponse {
 	return UserResponse{
-		ID:            u.ID,
-		PublicID:      u.PublicID,
-		Name:          u.Name,
-		Email:         u.Email,
-		EmailVerified: u.EmailVerified,
-		Image:         u.Image,
-		Role:          u.Role,
-		CreatedAt:     u.CreatedAt,
-		UpdatedAt:     u.UpdatedAt,
+		ID:              u.ID,
+		PublicID:        u.PublicID,
+		Name:            u.Name,
+		Email:           u.Email,
+		EmailVerified:   u.EmailVerified,
+		PendingEmail:    u.PendingEmail,
+		Image:           u.Image,
+		Role:            u.Role,
+		IsActive:        u.IsActive,
+		DeactivatedAt:   u.DeactivatedAt,
+		PasswordResetAt: u.PasswordResetAt,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
 	}
 }
 
 type UserResponse struct {
-	ID            uint      `json:"id"`
-	PublicID      string    `json:"public_id"`
-	Name          string    `json:"name"`
-	Email         string    `json:"email"`
-	EmailVerified bool      `json:"email_verified"`
-	Image         *string   `json:"image"`
-	Role          EnumRole  `json:"role"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID              uint       `json:"id"`
+	PublicID        string     `json:"public_id"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email"`
+	EmailVerified   bool       `json:"email_verified"`
+	PendingEmail    *string    `json:"pending_email,omitempty"`
+	Image           *string    `json:"image"`
+	Role            EnumRole   `json:"role"`
+	IsActive        bool       `json:"is_active"`
+	DeactivatedAt   *time.Time `json:"deactivated_at,omitempty"`
+	PasswordResetAt *time.Time `json:"password_reset_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// UserPreferences holds a user's settings. Settings every client shares are
+// typed columns; Extra is a JSON object for client-specific settings. The
+// columns have no database defaults, since gorm would write those instead of
+// false; DefaultUserPreferences provides them.
+type UserPreferences struct {
+	UserID         uint            `gorm:"primaryKey;autoIncrement:false;column:user_id"`
+	Locale         string          `gorm:"size:35;not null;column:locale"`
+	Timezone       string          `gorm:"size:64;not null;column:timezone"`
+	MarketingOptIn bool            `gorm:"not null;column:marketing_opt_in"`
+	NotifyEmail    bool            `gorm:"not null;column:notify_email"`
+	NotifySMS      bool            `gorm:"not null;column:notify_sms"`
+	NotifyPush     bool            `gorm:"not null;column:notify_push"`
+	Extra          json.RawMessage `gorm:"type:json;column:extra"`
+	CreatedAt      time.Time       `gorm:"autoCreateTime;column:created_at"`
+	UpdatedAt      time.Time       `gorm:"autoUpdateTime;column:updated_at"`
+}
+
+func (UserPreferences) TableName() string {
+	return "tbl_user_preferences"
+}
+
+// DefaultUserPreferences are the settings of a user who never saved any
+func DefaultUserPreferences(userID uint) *UserPreferences {
+	return &UserPreferences{
+		UserID:      userID,
+		Locale:      "en",
+		Timezone:    "UTC",
+		NotifyEmail: true,
+	}
+}
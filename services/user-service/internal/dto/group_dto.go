@@ -0,0 +1,48 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+)
+
+// CreateGroupRequest creates a group owned by the caller. Without a slug,
+// one is made from the name.
+type CreateGroupRequest struct {
+	Name        string `json:"name" validate:"required,min=2,max=100"`
+	Slug        string `json:"slug,omitempty" validate:"omitempty,min=2,max=50"`
+	Description string `json:"description,omitempty" validate:"max=500"`
+}
+
+// UpdateGroupRequest changes the given fields and keeps the rest. The slug
+// cannot change, as sessions refer to groups by it.
+type UpdateGroupRequest struct {
+	Name        *string `json:"name,omitempty" validate:"omitempty,min=2,max=100"`
+	Description *string `json:"description,omitempty" validate:"omitempty,max=500"`
+}
+
+// SetGroupMemberRequest adds a user to a group or changes their role
+type SetGroupMemberRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// GroupResponse describes a group. Role is the caller's role in it, absent
+// for admins who are not members.
+type GroupResponse struct {
+	ID          uint           `json:"id"`
+	Slug        string         `json:"slug"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Role        rbac.GroupRole `json:"role,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+type GroupMemberResponse struct {
+	UserID   uint           `json:"user_id"`
+	PublicID string         `json:"public_id"`
+	Name     string         `json:"name"`
+	Email    string         `json:"email"`
+	Role     rbac.GroupRole `json:"role"`
+	JoinedAt time.Time      `json:"joined_at"`
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
 )
 
 type RegisterRequest struct {
@@ -14,21 +15,55 @@ type RegisterRequest struct {
 }
 
 type LoginRequest struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required"`
+	Email    string      `json:"email" validate:"required,email"`
+	Password string      `json:"password" validate:"required"`
+	Client   LoginClient `json:"-"`
+}
+
+// LoginClient describes who is signing in, as forwarded by the gateway, for
+// the login history. Country is an ISO 3166 code, empty when unknown.
+type LoginClient struct {
+	IPAddress string
+	UserAgent string
+	Country   string
+}
+
+// OAuthLoginRequest is sent by the gateway after a successful OAuth flow
+type OAuthLoginRequest struct {
+	Provider       string      `json:"provider" validate:"required"`
+	ProviderUserID string      `json:"provider_user_id" validate:"required"`
+	Email          string      `json:"email" validate:"required,email"`
+	EmailVerified  bool        `json:"email_verified"`
+	Name           string      `json:"name" validate:"max=100"`
+	Image          *string     `json:"image,omitempty"`
+	Client         LoginClient `json:"-"`
+}
+
+// PasswordlessLoginRequest is sent by the gateway for magic-link logins.
+// EmailVerified is set once the user has followed the emailed link.
+type PasswordlessLoginRequest struct {
+	Email         string      `json:"email" validate:"required,email"`
+	EmailVerified bool        `json:"email_verified"`
+	Client        LoginClient `json:"-"`
 }
 
+// LoginResponse describes the account for the gateway's session, group
+// memberships included
 type LoginResponse struct {
-	ID    uint            `json:"id"`
-	Name  string          `json:"name"`
-	Email string          `json:"email"`
-	Role  domain.EnumRole `json:"role"`
+	ID     uint              `json:"id"`
+	Name   string            `json:"name"`
+	Email  string            `json:"email"`
+	Role   domain.EnumRole   `json:"role"`
+	Groups []rbac.Membership `json:"groups,omitempty"`
 }
 
 type UpdateProfileRequest struct {
 	Name  *string `json:"name,omitempty" validate:"omitempty,min=2,max=100"`
 	Email *string `json:"email,omitempty" validate:"omitempty,email"`
 	Image *string `json:"image,omitempty"`
+	// Version, when set, must be the user's current version, so an edit
+	// based on a stale read is refused instead of overwriting newer changes
+	Version *uint `json:"version,omitempty"`
 }
 
 type ChangePasswordRequest struct {
@@ -36,6 +71,10 @@ type ChangePasswordRequest struct {
 	NewPassword     string `json:"new_password" validate:"required,min=8"`
 }
 
+type ResendVerificationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
 type ForgotPasswordRequest struct {
 	Email string `json:"email" validate:"required,email"`
 }
@@ -45,16 +84,177 @@ type ResetPasswordRequest struct {
 	NewPassword string `json:"new_password" validate:"required,min=8"`
 }
 
+// UserFilter narrows a user listing. Zero values do not filter. Search
+// matches a substring of the name or email; the created range includes
+// CreatedFrom and excludes CreatedBefore.
+type UserFilter struct {
+	Search        string
+	Role          domain.EnumRole
+	EmailVerified *bool
+	Active        *bool
+	CreatedFrom   *time.Time
+	CreatedBefore *time.Time
+}
+
+// Fields a user listing can be sorted by
+const (
+	UserSortCreatedAt = "created_at"
+	UserSortName      = "name"
+	UserSortEmail     = "email"
+)
+
+// UserSort orders a user listing. Users with equal values are ordered by
+// ID in the same direction, so the order is stable.
+type UserSort struct {
+	Field      string
+	Descending bool
+}
+
+// UserCursor is the position of the last user of a page in the listing's
+// sort order
+type UserCursor struct {
+	Value string
+	ID    uint
+}
+
+// UserPage selects a page of a user listing. A Cursor taken from a previous
+// page's next_cursor replaces Offset; it is only valid with the same sort.
+// SkipCount leaves the total out, which saves a full scan of the matching
+// users on large tables.
+type UserPage struct {
+	Limit     int
+	Offset    int
+	Sort      UserSort
+	Cursor    string
+	SkipCount bool
+}
+
+// UnknownTotal is the Total of a listing whose count was skipped
+const UnknownTotal = -1
+
+// UserList is one page of users. NextCursor is empty on the last page.
+type UserList struct {
+	Users      []*UserResponse
+	Total      int64
+	Limit      int
+	Offset     int
+	NextCursor string
+}
+
+// BatchGetUsersRequest names users by ID, public ID or both
+type BatchGetUsersRequest struct {
+	IDs       []uint   `json:"ids"`
+	PublicIDs []string `json:"public_ids"`
+}
+
+// UserBatch holds the users of a batch lookup in the order requested, IDs
+// before public IDs and each user once, and the identifiers that match no
+// user
+type UserBatch struct {
+	Users            []UserResponse `json:"users"`
+	MissingIDs       []uint         `json:"missing_ids,omitempty"`
+	MissingPublicIDs []string       `json:"missing_public_ids,omitempty"`
+}
+
+// Ways an import treats rows whose email already has an account
+const (
+	ImportDuplicateSkip   = "skip"
+	ImportDuplicateUpdate = "update"
+	ImportDuplicateFail   = "fail"
+)
+
+// ImportUserRow is one user of a bulk import. Line is the row's position
+// in the file, counting a CSV header. Without a password the account can
+// only sign in after a password reset or through OAuth.
+type ImportUserRow struct {
+	Line          int    `json:"-"`
+	Name          string `json:"name" validate:"required,min=2,max=100"`
+	Email         string `json:"email" validate:"required,email"`
+	Password      string `json:"password,omitempty" validate:"omitempty,min=8"`
+	Role          string `json:"role,omitempty" validate:"omitempty,oneof=USER ADMIN"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+}
+
+// ImportRowResult reports a row that was not created. Created rows are only
+// counted, so results stay small for large files.
+type ImportRowResult struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ImportResult struct {
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Skipped int               `json:"skipped"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// ImportJob tracks an asynchronous import
+type ImportJob struct {
+	ID         string        `json:"id"`
+	Status     string        `json:"status"`
+	Total      int           `json:"total"`
+	Processed  int           `json:"processed"`
+	Result     *ImportResult `json:"result,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+}
+
 type UserResponse struct {
 	ID            uint            `json:"id"`
 	PublicID      string          `json:"public_id"`
 	Name          string          `json:"name"`
 	Email         string          `json:"email"`
 	EmailVerified bool            `json:"email_verified"`
+	PendingEmail  *string         `json:"pending_email,omitempty"`
 	Image         *string         `json:"image"`
 	Role          domain.EnumRole `json:"role"`
-	CreatedAt     time.Time       `json:"created_at"`
-	UpdatedAt     time.Time       `json:"updated_at"`
+	IsActive      bool            `json:"is_active"`
+	DeactivatedAt *time.Time      `json:"deactivated_at,omitempty"`
+	// PasswordResetAt is absent until the password is reset by link
+	PasswordResetAt *time.Time `json:"password_reset_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	Version         uint       `json:"version"`
+	// Preferences are only included on request
+	Preferences *UserPreferencesResponse `json:"preferences,omitempty"`
+}
+
+type NotificationChannels struct {
+	Email bool `json:"email"`
+	SMS   bool `json:"sms"`
+	Push  bool `json:"push"`
+}
+
+type UserPreferencesResponse struct {
+	Locale               string               `json:"locale"`
+	Timezone             string               `json:"timezone"`
+	MarketingOptIn       bool                 `json:"marketing_opt_in"`
+	NotificationChannels NotificationChannels `json:"notification_channels"`
+	Extra                map[string]any       `json:"extra"`
+	// UpdatedAt is absent while the user has the defaults
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// UpdatePreferencesRequest changes the given settings and keeps the rest.
+// Extra replaces the stored object as a whole; {} clears it.
+type UpdatePreferencesRequest struct {
+	Locale               *string                            `json:"locale,omitempty" validate:"omitempty,bcp47_language_tag"`
+	Timezone             *string                            `json:"timezone,omitempty" validate:"omitempty,timezone"`
+	MarketingOptIn       *bool                              `json:"marketing_opt_in,omitempty"`
+	NotificationChannels *UpdateNotificationChannelsRequest `json:"notification_channels,omitempty"`
+	Extra                map[string]any                     `json:"extra,omitempty"`
+}
+
+type UpdateNotificationChannelsRequest struct {
+	Email *bool `json:"email,omitempty"`
+	SMS   *bool `json:"sms,omitempty"`
+	Push  *bool `json:"push,omitempty"`
 }
 
 type PaginatedUsersResponse struct {
@@ -64,3 +264,26 @@ type PaginatedUsersResponse struct {
 	Total      int64          `json:"total"`
 	TotalPages int            `json:"total_pages"`
 }
+
+// LoginEventResponse is an entry of a user's login history. Anomaly names
+// what made the attempt suspicious, if anything.
+type LoginEventResponse struct {
+	ID            uint      `json:"id"`
+	Method        string    `json:"method"`
+	Provider      string    `json:"provider,omitempty"`
+	Success       bool      `json:"success"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	IPAddress     string    `json:"ip_address"`
+	UserAgent     string    `json:"user_agent"`
+	Country       string    `json:"country,omitempty"`
+	Anomaly       string    `json:"anomaly,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// LoginHistory is one page of a user's login history, newest first
+type LoginHistory struct {
+	Events []LoginEventResponse
+	Total  int64
+	Limit  int
+	Offset int
+}
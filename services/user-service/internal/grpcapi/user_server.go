@@ -0,0 +1,161 @@
+// Package grpcapi serves the internal gRPC API of user-service, described
+// in shared/pkg/userrpc, for the gateway and sibling services
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/service"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/userrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type UserServer struct {
+	userService service.UserService
+	logger      *logger.Logger
+}
+
+func NewUserServer(userService service.UserService, logger *logger.Logger) *UserServer {
+	return &UserServer{
+		userService: userService,
+		logger:      logger,
+	}
+}
+
+func (s *UserServer) GetUser(ctx context.Context, req *userrpc.GetUserRequest) (*userrpc.User, error) {
+	var user *dto.UserResponse
+	var err error
+	switch {
+	case req.ID != 0 && req.PublicID == "" && req.Email == "":
+		user, err = s.userService.GetUserByID(ctx, req.ID)
+	case req.ID == 0 && req.PublicID != "" && req.Email == "":
+		user, err = s.userService.GetUserByPublicID(ctx, req.PublicID)
+	case req.ID == 0 && req.PublicID == "" && req.Email != "":
+		user, err = s.userService.GetUserByEmail(ctx, req.Email)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "exactly one of id, public_id and email is required")
+	}
+	if err != nil {
+		return nil, lookupError(err)
+	}
+
+	response := toUser(user)
+	return &response, nil
+}
+
+// ValidateCredentials signs in with email and password like POST
+// /auth/login, recording the attempt in the login history
+func (s *UserServer) ValidateCredentials(ctx context.Context, req *userrpc.ValidateCredentialsRequest) (*userrpc.ValidateCredentialsResponse, error) {
+	if req.Email == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "email and password are required")
+	}
+
+	loginResponse, err := s.userService.Login(ctx, &dto.LoginRequest{
+		Email:    req.Email,
+		Password: req.Password,
+		Client:   loginClient(ctx),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrAccountDeactivated) {
+			return nil, status.Error(codes.PermissionDenied, "account is deactivated")
+		}
+		if appErrors.IsInvalidCredentials(err) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "failed to validate credentials")
+	}
+
+	return &userrpc.ValidateCredentialsResponse{
+		ID:     loginResponse.ID,
+		Name:   loginResponse.Name,
+		Email:  loginResponse.Email,
+		Role:   string(loginResponse.Role),
+		Groups: loginResponse.Groups,
+	}, nil
+}
+
+// BatchGetUsers returns the users in the order of the request and lists the
+// identifiers that do not exist
+func (s *UserServer) BatchGetUsers(ctx context.Context, req *userrpc.BatchGetUsersRequest) (*userrpc.BatchGetUsersResponse, error) {
+	batch, err := s.userService.BatchGetUsers(ctx, req.IDs, req.PublicIDs)
+	if err != nil {
+		if errors.Is(err, service.ErrUserBatchTooLarge) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "failed to get users")
+	}
+
+	response := &userrpc.BatchGetUsersResponse{
+		Users:            make([]userrpc.User, len(batch.Users)),
+		MissingIDs:       batch.MissingIDs,
+		MissingPublicIDs: batch.MissingPublicIDs,
+	}
+	for i := range batch.Users {
+		response.Users[i] = toUser(&batch.Users[i])
+	}
+	return response, nil
+}
+
+// lookupError maps a failed user lookup to a status
+func lookupError(err error) error {
+	if appErrors.IsNotFound(err) {
+		return status.Error(codes.NotFound, "user not found")
+	}
+	return status.Error(codes.Internal, "failed to get user")
+}
+
+// loginClient reads the client the caller signs in for from the metadata
+// the gateway sends, falling back to the caller's address like
+// handler.loginClient does for HTTP
+func loginClient(ctx context.Context) dto.LoginClient {
+	md, _ := metadata.FromIncomingContext(ctx)
+	client := dto.LoginClient{
+		UserAgent: firstMetadata(md, userrpc.MetadataClientUserAgent),
+		Country:   firstMetadata(md, userrpc.MetadataClientCountry),
+	}
+	if forwarded := firstMetadata(md, userrpc.MetadataClientIP); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		client.IPAddress = strings.TrimSpace(first)
+	} else if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		client.IPAddress = p.Addr.String()
+		if host, _, err := net.SplitHostPort(client.IPAddress); err == nil {
+			client.IPAddress = host
+		}
+	}
+	if client.UserAgent == "" {
+		client.UserAgent = firstMetadata(md, "user-agent")
+	}
+	return client
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func toUser(user *dto.UserResponse) userrpc.User {
+	return userrpc.User{
+		ID:              user.ID,
+		PublicID:        user.PublicID,
+		Name:            user.Name,
+		Email:           user.Email,
+		EmailVerified:   user.EmailVerified,
+		Image:           user.Image,
+		Role:            string(user.Role),
+		IsActive:        user.IsActive,
+		PasswordResetAt: user.PasswordResetAt,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+	}
+}
@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/service"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/identity"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/go-playground/validator/v10"
+)
+
+type GroupHandler struct {
+	groupService service.GroupService
+	validator    *validator.Validate
+	logger       *logger.Logger
+}
+
+func NewGroupHandler(groupService service.GroupService, validator *validator.Validate, logger *logger.Logger) *GroupHandler {
+	return &GroupHandler{
+		groupService: groupService,
+		validator:    validator,
+		logger:       logger,
+	}
+}
+
+func (h *GroupHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	req, appErr := utils.BindJSON[dto.CreateGroupRequest](r, h.validator)
+	if appErr != nil {
+		appErrors.WriteErrorResponse(w, appErr)
+		return
+	}
+
+	group, err := h.groupService.CreateGroup(r.Context(), actor, &req)
+	if err != nil {
+		h.sendGroupError(w, r, "Failed to create group", err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusCreated, "Group created successfully", group)
+}
+
+// ListGroups returns the caller's groups with their role in each
+func (h *GroupHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	groups, err := h.groupService.ListGroups(r.Context(), actor)
+	if err != nil {
+		h.sendGroupError(w, r, "Failed to retrieve groups", err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Groups retrieved successfully", groups)
+}
+
+// ListUserGroups returns the groups of the user in the path
+func (h *GroupHandler) ListUserGroups(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	groups, err := h.groupService.ListUserGroups(r.Context(), actor, userID)
+	if err != nil {
+		h.sendGroupError(w, r, "Failed to retrieve groups", err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Groups retrieved successfully", groups)
+}
+
+func (h *GroupHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	group, err := h.groupService.GetGroup(r.Context(), actor, r.PathValue("group"))
+	if err != nil {
+		h.sendGroupError(w, r, "Failed to retrieve group", err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Group retrieved successfully", group)
+}
+
+func (h *GroupHandler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	req, appErr := utils.BindJSON[dto.UpdateGroupRequest](r, h.validator)
+	if appErr != nil {
+		appErrors.WriteErrorResponse(w, appErr)
+		return
+	}
+
+	group, err := h.groupService.UpdateGroup(r.Context(), actor, r.PathValue("group"), &req)
+	if err != nil {
+		h.sendGroupError(w, r, "Failed to update group", err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Group updated successfully", group)
+}
+
+func (h *GroupHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.groupService.DeleteGroup(r.Context(), actor, r.PathValue("group")); err != nil {
+		h.sendGroupError(w, r, "Failed to delete group", err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Group deleted successfully", nil)
+}
+
+func (h *GroupHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := h.groupService.ListMembers(r.Context(), actor, r.PathValue("group"))
+	if err != nil {
+		h.sendGroupError(w, r, "Failed to retrieve group members", err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Group members retrieved successfully", members)
+}
+
+// SetMember adds the user in the path to the group or changes their role
+func (h *GroupHandler) SetMember(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := memberIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	req, appErr := utils.BindJSON[dto.SetGroupMemberRequest](r, h.validator)
+	if appErr != nil {
+		appErrors.WriteErrorResponse(w, appErr)
+		return
+	}
+
+	member, err := h.groupService.SetMember(r.Context(), actor, r.PathValue("group"), userID, &req)
+	if err != nil {
+		h.sendGroupError(w, r, "Failed to save group member", err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Group member saved successfully", member)
+}
+
+func (h *GroupHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := memberIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.groupService.RemoveMember(r.Context(), actor, r.PathValue("group"), userID); err != nil {
+		h.sendGroupError(w, r, "Failed to remove group member", err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Group member removed successfully", nil)
+}
+
+// sendGroupError answers with the status matching err; unexpected errors
+// are logged with message
+func (h *GroupHandler) sendGroupError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	switch {
+	case errors.Is(err, service.ErrGroupNotFound):
+		utils.SendError(w, http.StatusNotFound, "Group not found")
+	case errors.Is(err, service.ErrGroupMemberNotFound):
+		utils.SendError(w, http.StatusNotFound, "Group member not found")
+	case errors.Is(err, service.ErrGroupForbidden):
+		utils.SendError(w, http.StatusForbidden, "Insufficient group role")
+	case errors.Is(err, service.ErrLastGroupOwner):
+		utils.SendError(w, http.StatusConflict, err.Error())
+	case sendUserConflict(w, err):
+		// A slug taken, also by a concurrent request
+	case errors.Is(err, service.ErrInvalidGroupSlug), errors.Is(err, service.ErrInvalidGroupRole):
+		utils.SendError(w, http.StatusBadRequest, err.Error())
+	case appErrors.IsNotFound(err):
+		utils.SendError(w, http.StatusNotFound, "User not found")
+	default:
+		h.logger.ErrorWithStack(r.Context(), err, message)
+		utils.SendError(w, http.StatusInternalServerError, message)
+	}
+}
+
+// actorFromRequest identifies the caller by X-User-ID, which the gateway
+// sets for signed-in users. Admins are only recognized by the signed
+// identity, so without IDENTITY_SIGNING_SECRET no caller acts as an admin.
+func actorFromRequest(w http.ResponseWriter, r *http.Request) (service.Actor, bool) {
+	userID, err := strconv.ParseUint(r.Header.Get("X-User-ID"), 10, 32)
+	if err != nil || userID == 0 {
+		utils.SendError(w, http.StatusUnauthorized, "Authentication required")
+		return service.Actor{}, false
+	}
+
+	actor := service.Actor{UserID: uint(userID)}
+	if id, ok := identity.FromContext(r.Context()); ok {
+		actor.Admin = rbac.IsAdmin(id.Role)
+	}
+	return actor, true
+}
+
+// authorizeUser lets the caller act on the user userID when it is that user
+// or its verified identity grants permission, answering 403 otherwise
+func authorizeUser(w http.ResponseWriter, r *http.Request, userID uint, permission rbac.Permission, message string) bool {
+	actor, ok := actorFromRequest(w, r)
+	if !ok {
+		return false
+	}
+	if actor.UserID == userID {
+		return true
+	}
+	if id, ok := identity.FromContext(r.Context()); ok && rbac.HasPermission(id.Role, permission) {
+		return true
+	}
+	utils.SendError(w, http.StatusForbidden, message)
+	return false
+}
+
+func memberIDParam(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	userID, err := strconv.ParseUint(r.PathValue("user_id"), 10, 32)
+	if err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Invalid user ID")
+		return 0, false
+	}
+	return uint(userID), true
+}
@@ -0,0 +1,356 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/service"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+)
+
+const (
+	// maxImportBytes caps the size of an import file
+	maxImportBytes = 32 << 20
+	// exportFlushEvery is the number of exported users sent per flush
+	exportFlushEvery = 500
+)
+
+// Formats of exports and imports
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+	formatJSON   = "json"
+)
+
+var exportColumns = []string{"id", "public_id", "name", "email", "email_verified", "role", "is_active", "created_at", "updated_at"}
+
+// ExportUsers streams the users matching the GET /users filters as CSV or
+// NDJSON (format=csv|ndjson). Rows are sent as they are read, so an export
+// failing midway ends with a truncated body; the failure is logged.
+func (h *UserHandler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Invalid filter: "+err.Error())
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatCSV
+	}
+	if format != formatCSV && format != formatNDJSON {
+		utils.SendError(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	// Large exports outlive the server's write timeout
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.Warn(r.Context(), "Failed to lift write deadline for export", "error", err)
+	}
+
+	filename := fmt.Sprintf("users-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	var write func(*dto.UserResponse) error
+	flush := func() error { return nil }
+	if format == formatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		csvWriter := csv.NewWriter(w)
+		if err := csvWriter.Write(exportColumns); err != nil {
+			return
+		}
+		write = func(user *dto.UserResponse) error {
+			return csvWriter.Write([]string{
+				strconv.FormatUint(uint64(user.ID), 10),
+				user.PublicID,
+				user.Name,
+				user.Email,
+				strconv.FormatBool(user.EmailVerified),
+				string(user.Role),
+				strconv.FormatBool(user.IsActive),
+				user.CreatedAt.UTC().Format(time.RFC3339),
+				user.UpdatedAt.UTC().Format(time.RFC3339),
+			})
+		}
+		flush = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		write = func(user *dto.UserResponse) error {
+			return encoder.Encode(user)
+		}
+	}
+
+	exported := 0
+	err = h.userService.ExportUsers(r.Context(), filter, func(user *dto.UserResponse) error {
+		if err := write(user); err != nil {
+			return err
+		}
+		exported++
+		if exported%exportFlushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			controller.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		h.logger.ErrorWithStack(r.Context(), err, "User export aborted", "exported", exported)
+		return
+	}
+	controller.Flush()
+
+	h.logger.Info(r.Context(), "Users exported", "format", format, "exported", exported)
+}
+
+// ImportUsers creates users from a CSV, NDJSON or JSON array file, sent
+// as the body or as the "file" field of a multipart form. on_duplicate
+// (skip, update or fail) decides what happens to emails that already have
+// an account. With mode=async the import runs in the background and its
+// job is returned for polling GET /users/import/jobs/{id}.
+func (h *UserHandler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	onDuplicate := r.URL.Query().Get("on_duplicate")
+	switch onDuplicate {
+	case "":
+		onDuplicate = dto.ImportDuplicateSkip
+	case dto.ImportDuplicateSkip, dto.ImportDuplicateUpdate, dto.ImportDuplicateFail:
+	default:
+		utils.SendError(w, http.StatusBadRequest, "on_duplicate must be skip, update or fail")
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "sync" && mode != "async" {
+		utils.SendError(w, http.StatusBadRequest, "mode must be sync or async")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	source, format, err := importSource(r)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			utils.SendError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Import file exceeds %d bytes", maxImportBytes))
+			return
+		}
+		utils.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer source.Close()
+
+	rows, err := parseImportRows(source, format)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			utils.SendError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Import file exceeds %d bytes", maxImportBytes))
+			return
+		}
+		utils.SendError(w, http.StatusBadRequest, "Invalid import file: "+err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		utils.SendError(w, http.StatusBadRequest, "Import file has no users")
+		return
+	}
+
+	if mode == "async" {
+		job := h.userService.StartImport(r.Context(), rows, onDuplicate)
+		utils.SendSuccess(w, http.StatusAccepted, "Import started", job)
+		return
+	}
+
+	result, err := h.userService.ImportUsers(r.Context(), rows, onDuplicate)
+	if err != nil {
+		h.logger.ErrorWithStack(r.Context(), err, "Failed to import users")
+		utils.SendError(w, http.StatusInternalServerError, "Failed to import users")
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Import completed", result)
+}
+
+// GetImportJob reports the progress of an asynchronous import, and its
+// result once it has finished
+func (h *UserHandler) GetImportJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.userService.GetImportJob(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, service.ErrImportJobNotFound) {
+			utils.SendError(w, http.StatusNotFound, "Import job not found")
+		} else {
+			utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve import job")
+		}
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Import job retrieved successfully", job)
+}
+
+// importSource returns the uploaded file and its format. The format comes
+// from the format query parameter, else the file name or content type.
+func importSource(r *http.Request) (io.ReadCloser, string, error) {
+	format := r.URL.Query().Get("format")
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	source := r.Body
+	if mediaType == "multipart/form-data" {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, "", err
+			}
+			return nil, "", errors.New(`multipart imports need a "file" field`)
+		}
+		source = file
+		if format == "" {
+			switch strings.ToLower(filepath.Ext(header.Filename)) {
+			case ".csv":
+				format = formatCSV
+			case ".ndjson", ".jsonl":
+				format = formatNDJSON
+			case ".json":
+				format = formatJSON
+			}
+			mediaType, _, _ = mime.ParseMediaType(header.Header.Get("Content-Type"))
+		}
+	}
+
+	if format == "" {
+		switch mediaType {
+		case "text/csv":
+			format = formatCSV
+		case "application/x-ndjson", "application/jsonl":
+			format = formatNDJSON
+		case "application/json":
+			format = formatJSON
+		}
+	}
+	switch format {
+	case formatCSV, formatNDJSON, formatJSON:
+		return source, format, nil
+	case "":
+		source.Close()
+		return nil, "", errors.New("unknown import format; set format to csv, ndjson or json")
+	default:
+		source.Close()
+		return nil, "", errors.New("format must be csv, ndjson or json")
+	}
+}
+
+// parseImportRows reads the users of an import file. Malformed files are
+// rejected as a whole; invalid users are left to the import to report.
+func parseImportRows(source io.Reader, format string) ([]dto.ImportUserRow, error) {
+	switch format {
+	case formatCSV:
+		return parseImportCSV(source)
+	case formatNDJSON:
+		return parseImportNDJSON(source)
+	default:
+		var rows []dto.ImportUserRow
+		if err := json.NewDecoder(source).Decode(&rows); err != nil {
+			return nil, fmt.Errorf("expected a JSON array of users: %w", err)
+		}
+		for i := range rows {
+			rows[i].Line = i + 1
+		}
+		return rows, nil
+	}
+}
+
+// parseImportCSV reads a CSV file whose header names the columns: name and
+// email are required, password, role and email_verified are optional
+func parseImportCSV(source io.Reader) ([]dto.ImportUserRow, error) {
+	reader := csv.NewReader(source)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "email"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %s column", required)
+		}
+	}
+
+	var rows []dto.ImportUserRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		row := dto.ImportUserRow{
+			Line:     line,
+			Name:     field("name"),
+			Email:    field("email"),
+			Password: field("password"),
+			Role:     field("role"),
+		}
+		if value := strings.TrimSpace(field("email_verified")); value != "" {
+			row.EmailVerified, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: email_verified must be true or false", line)
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// parseImportNDJSON reads one JSON user per line, ignoring blank lines
+func parseImportNDJSON(source io.Reader) ([]dto.ImportUserRow, error) {
+	scanner := bufio.NewScanner(source)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	var rows []dto.ImportUserRow
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		row := dto.ImportUserRow{}
+		if err := json.Unmarshal([]byte(text), &row); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		row.Line = line
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
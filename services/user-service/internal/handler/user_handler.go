@@ -1,15 +1,23 @@
 package handler
 
 import (
+	"cmp"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
 	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
 	"github.com/dhekaag/golang-microservices/services/user-service/internal/service"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
 	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
 	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/dhekaag/golang-microservices/shared/pkg/validation"
 	"github.com/go-playground/validator/v10"
 )
 
@@ -29,30 +37,27 @@ func NewUserHandler(userService service.UserService, validator *validator.Valida
 }
 
 func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
-		return
-	}
-
 	var req dto.RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.logger.Warn(r.Context(), "Invalid request body for registration", "error", err)
-		utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+	if appErr := utils.DecodeJSON(r, &req); appErr != nil {
+		h.logger.Warn(r.Context(), "Invalid request body for registration", "error", appErr)
+		appErrors.WriteErrorResponse(w, appErr)
 		return
 	}
+	// Accept "admin" as well as "ADMIN"
+	req.Role = string(rbac.NormalizeRole(req.Role))
 
 	if err := h.validator.Struct(&req); err != nil {
 		h.logger.Warn(r.Context(), "Validation failed for registration", "error", err)
-		utils.SendError(w, http.StatusBadRequest, "Validation failed: "+err.Error())
+		utils.SendValidationError(w, validation.Errors(err))
 		return
 	}
 
 	user, err := h.userService.Register(r.Context(), &req)
 	if err != nil {
 		h.logger.Error(r.Context(), "Registration failed", "error", err, "email", req.Email)
-		if strings.Contains(err.Error(), "already exists") {
-			utils.SendError(w, http.StatusConflict, err.Error())
-		} else {
+		if errors.Is(err, service.ErrAccountDeactivated) {
+			utils.SendError(w, http.StatusForbidden, "Account is deactivated")
+		} else if !sendUserConflict(w, err) {
 			utils.SendError(w, http.StatusInternalServerError, "Registration failed")
 		}
 		return
@@ -63,29 +68,27 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
-		return
-	}
 	ctx := r.Context()
 
-	var req dto.LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.logger.Warn(ctx, "Invalid request body for login", "error", err)
-		utils.SendError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := h.validator.Struct(&req); err != nil {
-		h.logger.Warn(ctx, "Validation failed for login", "error", err)
-		utils.SendError(w, http.StatusBadRequest, "Validation failed: "+err.Error())
+	req, appErr := utils.BindJSON[dto.LoginRequest](r, h.validator)
+	if appErr != nil {
+		h.logger.Warn(ctx, "Invalid login request", "error", appErr)
+		appErrors.WriteErrorResponse(w, appErr)
 		return
 	}
 
+	req.Client = loginClient(r)
 	loginResponse, err := h.userService.Login(ctx, &req)
 	if err != nil {
 		h.logger.Warn(ctx, "Login failed", "error", err, "email", req.Email)
-		utils.SendError(w, http.StatusUnauthorized, err.Error())
+		switch {
+		case errors.Is(err, service.ErrAccountDeactivated):
+			utils.SendError(w, http.StatusForbidden, "Account is deactivated")
+		case appErrors.IsInvalidCredentials(err):
+			utils.SendAppError(w, err)
+		default:
+			utils.SendError(w, http.StatusInternalServerError, "Login failed")
+		}
 		return
 	}
 
@@ -95,10 +98,11 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 		"success": true,
 		"message": "Login successful",
 		"data": map[string]interface{}{
-			"id":    loginResponse.ID,
-			"name":  loginResponse.Name,
-			"email": loginResponse.Email,
-			"role":  string(loginResponse.Role),
+			"id":     loginResponse.ID,
+			"name":   loginResponse.Name,
+			"email":  loginResponse.Email,
+			"role":   string(loginResponse.Role),
+			"groups": loginResponse.Groups,
 		},
 	}
 
@@ -107,63 +111,135 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// OAuthLogin finds or provisions the user for an identity verified by the
+// gateway's OAuth flow
+func (h *UserHandler) OAuthLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, appErr := utils.BindJSON[dto.OAuthLoginRequest](r, h.validator)
+	if appErr != nil {
+		h.logger.Warn(ctx, "Invalid OAuth login request", "error", appErr)
+		appErrors.WriteErrorResponse(w, appErr)
+		return
+	}
+
+	req.Client = loginClient(r)
+	loginResponse, err := h.userService.OAuthLogin(ctx, &req)
+	if err != nil {
+		if errors.Is(err, service.ErrAccountDeactivated) {
+			utils.SendError(w, http.StatusForbidden, "Account is deactivated")
+		} else if !sendUserConflict(w, err) {
+			utils.SendError(w, http.StatusInternalServerError, "OAuth login failed")
+		}
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Login successful", loginResponse)
+}
+
+// PasswordlessLogin returns the account for the gateway's magic-link flow
+func (h *UserHandler) PasswordlessLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, appErr := utils.BindJSON[dto.PasswordlessLoginRequest](r, h.validator)
+	if appErr != nil {
+		h.logger.Warn(ctx, "Invalid passwordless login request", "error", appErr)
+		appErrors.WriteErrorResponse(w, appErr)
+		return
+	}
+
+	req.Client = loginClient(r)
+	loginResponse, err := h.userService.PasswordlessLogin(ctx, &req)
+	if err != nil {
+		if errors.Is(err, service.ErrAccountDeactivated) {
+			utils.SendError(w, http.StatusForbidden, "Account is deactivated")
+		} else if appErrors.IsNotFound(err) {
+			utils.SendError(w, http.StatusNotFound, "User not found")
+		} else {
+			utils.SendError(w, http.StatusInternalServerError, "Passwordless login failed")
+		}
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Login successful", loginResponse)
+}
+
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("id")
-	publicID := r.URL.Query().Get("public_id")
+	// {id} is either the numeric ID or the public ID
+	idParam := r.PathValue("id")
 
 	var user *dto.UserResponse
 	var err error
 
-	if userID != "" {
-		id, parseErr := strconv.ParseUint(userID, 10, 32)
-		if parseErr != nil {
-			utils.SendError(w, http.StatusBadRequest, "Invalid user ID")
-			return
-		}
+	if id, parseErr := strconv.ParseUint(idParam, 10, 32); parseErr == nil {
 		user, err = h.userService.GetUserByID(r.Context(), uint(id))
-	} else if publicID != "" {
-		user, err = h.userService.GetUserByPublicID(r.Context(), publicID)
 	} else {
-		utils.SendError(w, http.StatusBadRequest, "User ID or Public ID required")
-		return
+		user, err = h.userService.GetUserByPublicID(r.Context(), idParam)
 	}
 
 	if err != nil {
-		utils.SendError(w, http.StatusNotFound, err.Error())
+		utils.SendAppError(w, err)
 		return
 	}
 
+	if r.URL.Query().Get("include") == "preferences" {
+		preferences, err := h.userService.GetPreferences(r.Context(), user.ID)
+		if err != nil {
+			utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve preferences")
+			return
+		}
+		user.Preferences = preferences
+	}
+
 	utils.SendSuccess(w, http.StatusOK, "User retrieved successfully", user)
 }
 
-func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
-	userIDStr := r.URL.Query().Get("id")
-	if userIDStr == "" {
-		utils.SendError(w, http.StatusBadRequest, "User ID required")
+// BatchGetUsers returns the users named by ids and public_ids in one call,
+// listing the identifiers that match no user
+func (h *UserHandler) BatchGetUsers(w http.ResponseWriter, r *http.Request) {
+	var req dto.BatchGetUsersRequest
+	if appErr := utils.DecodeJSON(r, &req); appErr != nil {
+		appErrors.WriteErrorResponse(w, appErr)
+		return
+	}
+	if len(req.IDs) == 0 && len(req.PublicIDs) == 0 {
+		utils.SendError(w, http.StatusBadRequest, "ids or public_ids required")
 		return
 	}
 
-	userID, err := strconv.ParseUint(userIDStr, 10, 32)
+	batch, err := h.userService.BatchGetUsers(r.Context(), req.IDs, req.PublicIDs)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid user ID")
+		if errors.Is(err, service.ErrUserBatchTooLarge) {
+			utils.SendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve users")
 		return
 	}
 
-	var req dto.UpdateProfileRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+	utils.SendSuccess(w, http.StatusOK, "Users retrieved successfully", batch)
+}
+
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
 		return
 	}
 
-	if err := h.validator.Struct(req); err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Validation failed: "+err.Error())
+	req, appErr := utils.BindJSON[dto.UpdateProfileRequest](r, h.validator)
+	if appErr != nil {
+		appErrors.WriteErrorResponse(w, appErr)
 		return
 	}
 
-	user, err := h.userService.UpdateUser(r.Context(), uint(userID), &req)
+	user, err := h.userService.UpdateUser(r.Context(), userID, &req)
 	if err != nil {
 		h.logger.Error(r.Context(), "Failed to update user", "error", err)
-		utils.SendError(w, http.StatusBadRequest, err.Error())
+		if errors.Is(err, service.ErrVerificationRateLimited) {
+			utils.SendError(w, http.StatusTooManyRequests, "Too many confirmation emails requested, try again later")
+			return
+		}
+		utils.SendAppError(w, err)
 		return
 	}
 
@@ -171,114 +247,454 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	userIDStr := r.URL.Query().Get("id")
-	if userIDStr == "" {
-		utils.SendError(w, http.StatusBadRequest, "User ID required")
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.userService.DeleteUser(r.Context(), userID); err != nil {
+		h.logger.Error(r.Context(), "Failed to delete user", "error", err)
+		utils.SendAppError(w, err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "User deleted successfully", nil)
+}
+
+// DeactivateUser blocks the user from signing in and ends their sessions
+func (h *UserHandler) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
 		return
 	}
 
-	userID, err := strconv.ParseUint(userIDStr, 10, 32)
+	user, err := h.userService.DeactivateUser(r.Context(), userID)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid user ID")
+		h.logger.Error(r.Context(), "Failed to deactivate user", "error", err)
+		utils.SendAppError(w, err)
 		return
 	}
 
-	if err := h.userService.DeleteUser(r.Context(), uint(userID)); err != nil {
-		h.logger.Error(r.Context(), "Failed to delete user", "error", err)
-		utils.SendError(w, http.StatusBadRequest, err.Error())
+	utils.SendSuccess(w, http.StatusOK, "User deactivated successfully", user)
+}
+
+// ReactivateUser lets a deactivated user sign in again
+func (h *UserHandler) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
 		return
 	}
 
-	utils.SendSuccess(w, http.StatusOK, "User deleted successfully", nil)
+	user, err := h.userService.ReactivateUser(r.Context(), userID)
+	if err != nil {
+		h.logger.Error(r.Context(), "Failed to reactivate user", "error", err)
+		utils.SendAppError(w, err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "User reactivated successfully", user)
 }
 
 func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
-
-	limit := 10
-	offset := 0
+	pagination, validationErrors := utils.ParsePagination(r)
+	sort, sortErrors := parseUserSort(r)
+	if validationErrors = append(validationErrors, sortErrors...); len(validationErrors) > 0 {
+		utils.SendValidationError(w, validationErrors)
+		return
+	}
 
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil {
-			limit = l
-		}
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Invalid filter: "+err.Error())
+		return
 	}
 
-	if offsetStr != "" {
-		if o, err := strconv.Atoi(offsetStr); err == nil {
-			offset = o
+	// count=false skips counting the matching users
+	counted := true
+	if countParam := r.URL.Query().Get("count"); countParam != "" {
+		counted, err = strconv.ParseBool(countParam)
+		if err != nil {
+			utils.SendError(w, http.StatusBadRequest, "Invalid count: must be true or false")
+			return
 		}
 	}
 
-	users, total, err := h.userService.ListUsers(r.Context(), limit, offset)
+	list, err := h.userService.ListUsers(r.Context(), filter, dto.UserPage{
+		Limit:     pagination.Limit,
+		Offset:    pagination.Offset,
+		Sort:      sort,
+		Cursor:    pagination.Cursor,
+		SkipCount: !counted,
+	})
 	if err != nil {
-		h.logger.Error(r.Context(), "Failed to list users", "error", err)
+		if errors.Is(err, service.ErrInvalidCursor) {
+			utils.SendError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		h.logger.ErrorWithStack(r.Context(), err, "Failed to list users")
 		utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve users")
 		return
 	}
 
 	response := map[string]interface{}{
-		"users": users,
+		"users": list.Users,
 		"pagination": map[string]interface{}{
-			"total":  total,
-			"limit":  limit,
-			"offset": offset,
+			"total":  list.Total,
+			"limit":  list.Limit,
+			"offset": list.Offset,
 		},
 	}
+	meta := &appErrors.Meta{
+		Limit:      list.Limit,
+		Total:      int(list.Total),
+		TotalPage:  (int(list.Total) + list.Limit - 1) / list.Limit,
+		NextCursor: list.NextCursor,
+	}
+	if list.Total == dto.UnknownTotal {
+		meta.TotalPage = dto.UnknownTotal
+	}
+	// Pages are only numbered when paging by offset
+	if pagination.Cursor == "" {
+		meta.Page = list.Offset/list.Limit + 1
+	}
+
+	utils.SendSuccessWithMeta(w, http.StatusOK, "Users retrieved successfully", response, meta)
+}
+
+// parseUserSort reads the sort (created_at, name or email) and order (asc
+// or desc) of GET /users; the default is the newest users first
+func parseUserSort(r *http.Request) (dto.UserSort, appErrors.ValidationErrors) {
+	sort, validationErrors := utils.ParseSort(r, dto.UserSortCreatedAt, dto.UserSortName, dto.UserSortEmail)
+	field := cmp.Or(sort.Field, dto.UserSortCreatedAt)
+	// Names and emails read naturally from A to Z
+	return dto.UserSort{Field: field, Descending: sort.Descending(field == dto.UserSortCreatedAt)}, validationErrors
+}
+
+// parseUserFilter reads the filters of GET /users: search, role,
+// email_verified, active, created_from and created_to. Dates are RFC 3339 times or
+// YYYY-MM-DD days; a created_to day includes the whole day.
+func parseUserFilter(r *http.Request) (dto.UserFilter, error) {
+	query := r.URL.Query()
+	filter := dto.UserFilter{
+		Search: strings.TrimSpace(query.Get("search")),
+	}
+
+	if value := query.Get("role"); value != "" {
+		role := rbac.NormalizeRole(value)
+		if !role.Valid() {
+			return filter, errors.New("role must be USER or ADMIN")
+		}
+		filter.Role = domain.EnumRole(role)
+	}
+
+	if value := query.Get("email_verified"); value != "" {
+		verified, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, errors.New("email_verified must be true or false")
+		}
+		filter.EmailVerified = &verified
+	}
+
+	if value := query.Get("active"); value != "" {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, errors.New("active must be true or false")
+		}
+		filter.Active = &active
+	}
+
+	if value := query.Get("created_from"); value != "" {
+		from, _, err := parseDateParam(value)
+		if err != nil {
+			return filter, errors.New("created_from must be an RFC 3339 time or a YYYY-MM-DD date")
+		}
+		filter.CreatedFrom = &from
+	}
 
-	utils.SendSuccess(w, http.StatusOK, "Users retrieved successfully", response)
+	if value := query.Get("created_to"); value != "" {
+		to, isDay, err := parseDateParam(value)
+		if err != nil {
+			return filter, errors.New("created_to must be an RFC 3339 time or a YYYY-MM-DD date")
+		}
+		if isDay {
+			to = to.AddDate(0, 0, 1)
+		} else {
+			to = to.Add(time.Nanosecond)
+		}
+		filter.CreatedBefore = &to
+	}
+
+	if filter.CreatedFrom != nil && filter.CreatedBefore != nil && !filter.CreatedFrom.Before(*filter.CreatedBefore) {
+		return filter, errors.New("created_from must not be after created_to")
+	}
+	return filter, nil
+}
+
+// parseDateParam accepts an RFC 3339 time or a YYYY-MM-DD day, reporting
+// which one it was
+func parseDateParam(value string) (time.Time, bool, error) {
+	if day, err := time.Parse(time.DateOnly, value); err == nil {
+		return day, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
 }
 
 func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
-	userIDStr := r.URL.Query().Get("id")
-	if userIDStr == "" {
-		utils.SendError(w, http.StatusBadRequest, "User ID required")
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	req, appErr := utils.BindJSON[dto.ChangePasswordRequest](r, h.validator)
+	if appErr != nil {
+		appErrors.WriteErrorResponse(w, appErr)
 		return
 	}
 
-	userID, err := strconv.ParseUint(userIDStr, 10, 32)
+	if err := h.userService.ChangePassword(r.Context(), userID, &req); err != nil {
+		utils.SendAppError(w, err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Password changed successfully", nil)
+}
+
+// VerifyEmail serves GET /auth/verify-email?token=..., the link in the
+// verification email
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		utils.SendError(w, http.StatusBadRequest, "Verification token is required")
+		return
+	}
+
+	user, err := h.userService.VerifyEmail(r.Context(), token)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid user ID")
+		if errors.Is(err, service.ErrInvalidVerificationToken) {
+			utils.SendError(w, http.StatusBadRequest, "Invalid or expired verification link")
+		} else if !sendUserConflict(w, err) {
+			utils.SendError(w, http.StatusInternalServerError, "Email verification failed")
+		}
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Email verified successfully", user)
+}
+
+// ConfirmEmailChange serves GET /auth/confirm-email-change?token=..., the
+// link sent to the new address of an email change
+func (h *UserHandler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		utils.SendError(w, http.StatusBadRequest, "Confirmation token is required")
 		return
 	}
 
-	var req dto.ChangePasswordRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid request body")
+	user, err := h.userService.ConfirmEmailChange(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidEmailChangeToken) {
+			utils.SendError(w, http.StatusBadRequest, "Invalid or expired confirmation link")
+		} else if !sendUserConflict(w, err) {
+			utils.SendError(w, http.StatusInternalServerError, "Email change failed")
+		}
 		return
 	}
 
-	if err := h.validator.Struct(req); err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Validation failed: "+err.Error())
+	utils.SendSuccess(w, http.StatusOK, "Email changed successfully", user)
+}
+
+// ResendVerification emails a new verification link. The response does not
+// reveal whether the account exists.
+func (h *UserHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	req, appErr := utils.BindJSON[dto.ResendVerificationRequest](r, h.validator)
+	if appErr != nil {
+		appErrors.WriteErrorResponse(w, appErr)
 		return
 	}
 
-	if err := h.userService.ChangePassword(r.Context(), uint(userID), &req); err != nil {
-		utils.SendError(w, http.StatusBadRequest, err.Error())
+	if err := h.userService.ResendVerification(r.Context(), req.Email); err != nil {
+		if errors.Is(err, service.ErrVerificationRateLimited) {
+			utils.SendError(w, http.StatusTooManyRequests, "Too many verification emails requested, try again later")
+		} else {
+			utils.SendError(w, http.StatusInternalServerError, "Failed to send verification email")
+		}
 		return
 	}
 
-	utils.SendSuccess(w, http.StatusOK, "Password changed successfully", nil)
+	utils.SendSuccess(w, http.StatusAccepted, "If the account exists and is not verified yet, a verification email has been sent", nil)
 }
 
-func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
-	userIDStr := r.URL.Query().Get("id")
-	if userIDStr == "" {
-		utils.SendError(w, http.StatusBadRequest, "User ID required")
+// ForgotPassword emails a password reset link. The response does not
+// reveal whether the account exists.
+func (h *UserHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	req, appErr := utils.BindJSON[dto.ForgotPasswordRequest](r, h.validator)
+	if appErr != nil {
+		appErrors.WriteErrorResponse(w, appErr)
+		return
+	}
+
+	if err := h.userService.ForgotPassword(r.Context(), req.Email); err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to send password reset email")
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusAccepted, "If the account exists, a password reset email has been sent", nil)
+}
+
+// ResetPassword sets a new password with the token from a reset link
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	req, appErr := utils.BindJSON[dto.ResetPasswordRequest](r, h.validator)
+	if appErr != nil {
+		appErrors.WriteErrorResponse(w, appErr)
 		return
 	}
 
-	userID, err := strconv.ParseUint(userIDStr, 10, 32)
+	if err := h.userService.ResetPassword(r.Context(), &req); err != nil {
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			utils.SendError(w, http.StatusBadRequest, "Invalid or expired password reset link")
+		} else {
+			utils.SendError(w, http.StatusInternalServerError, "Failed to reset password")
+		}
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Password reset successfully", nil)
+}
+
+// GetPreferences returns the user's settings, the defaults if none were
+// saved. Users read their own; callers with users:write anyone's.
+func (h *UserHandler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	if !authorizeUser(w, r, userID, rbac.UsersWrite, "Not allowed to access these preferences") {
+		return
+	}
+
+	preferences, err := h.userService.GetPreferences(r.Context(), userID)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid user ID")
+		utils.SendAppError(w, err)
+		return
+	}
+
+	utils.SendSuccess(w, http.StatusOK, "Preferences retrieved successfully", preferences)
+}
+
+// UpdatePreferences changes the settings present in the body. Users
+// change their own; callers with users:write anyone's.
+func (h *UserHandler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	if !authorizeUser(w, r, userID, rbac.UsersWrite, "Not allowed to access these preferences") {
+		return
+	}
+
+	req, appErr := utils.BindJSON[dto.UpdatePreferencesRequest](r, h.validator)
+	if appErr != nil {
+		appErrors.WriteErrorResponse(w, appErr)
 		return
 	}
 
-	if err := h.userService.VerifyEmail(r.Context(), uint(userID)); err != nil {
-		utils.SendError(w, http.StatusBadRequest, err.Error())
+	preferences, err := h.userService.UpdatePreferences(r.Context(), userID, &req)
+	if err != nil {
+		if errors.Is(err, service.ErrPreferencesTooLarge) {
+			utils.SendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.ErrorWithStack(r.Context(), err, "Failed to update preferences")
+		utils.SendAppError(w, err)
 		return
 	}
 
-	utils.SendSuccess(w, http.StatusOK, "Email verified successfully", nil)
+	utils.SendSuccess(w, http.StatusOK, "Preferences updated successfully", preferences)
+}
+
+// loginHistoryPageLimits page through sign-in attempts, which are short
+var loginHistoryPageLimits = utils.PageLimits{Default: 20, Max: 100}
+
+// GetLoginHistory lists the user's sign-in attempts, newest first. Users
+// see their own history; callers with users:read see anyone's.
+func (h *UserHandler) GetLoginHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	if !authorizeUser(w, r, userID, rbac.UsersRead, "Not allowed to view this login history") {
+		return
+	}
+
+	pagination, validationErrors := loginHistoryPageLimits.Parse(r)
+	if len(validationErrors) > 0 {
+		utils.SendValidationError(w, validationErrors)
+		return
+	}
+
+	history, err := h.userService.GetLoginHistory(r.Context(), userID, pagination.Limit, pagination.Offset)
+	if err != nil {
+		if appErrors.IsNotFound(err) {
+			utils.SendError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		h.logger.ErrorWithStack(r.Context(), err, "Failed to get login history", "user_id", userID)
+		utils.SendError(w, http.StatusInternalServerError, "Failed to retrieve login history")
+		return
+	}
+
+	meta := &appErrors.Meta{
+		Page:      history.Offset/history.Limit + 1,
+		Limit:     history.Limit,
+		Total:     int(history.Total),
+		TotalPage: (int(history.Total) + history.Limit - 1) / history.Limit,
+	}
+	utils.SendSuccessWithMeta(w, http.StatusOK, "Login history retrieved successfully", history.Events, meta)
+}
+
+// loginClient reads who is signing in from the headers the gateway
+// forwards. The address is the last X-Forwarded-For hop, the one the gateway
+// appended; the hops before it were sent by the client. Without the header,
+// the direct caller is recorded.
+func loginClient(r *http.Request) dto.LoginClient {
+	client := dto.LoginClient{
+		UserAgent: r.Header.Get("X-Client-User-Agent"),
+		Country:   r.Header.Get("X-Client-Country"),
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(forwarded[len(forwarded)-1], ",")
+		client.IPAddress = strings.TrimSpace(hops[len(hops)-1])
+	} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client.IPAddress = host
+	} else {
+		client.IPAddress = r.RemoteAddr
+	}
+	if client.UserAgent == "" {
+		client.UserAgent = r.UserAgent()
+	}
+	return client
+}
+
+// sendUserConflict answers 409 when err is a conflict, reporting whether it
+// did: CONFLICT for a concurrent change to the user, DUPLICATE_ENTRY for a
+// taken email or slug, or DATABASE_CONSTRAINT_ERROR when the database
+// rejected the write, such as a signup racing another with the same email
+func sendUserConflict(w http.ResponseWriter, err error) bool {
+	if !appErrors.IsConflict(err) {
+		return false
+	}
+	utils.SendAppError(w, err)
+	return true
+}
+
+// userIDParam parses the numeric {id} path wildcard, answering 400 when it
+// is not a valid ID
+func userIDParam(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	userID, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Invalid user ID")
+		return 0, false
+	}
+	return uint(userID), true
 }
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/service"
+	"github.com/dhekaag/golang-microservices/shared/pkg/identity"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+	"github.com/go-playground/validator/v10"
+)
+
+// preferencesService serves empty preferences; the other methods are not
+// called by these tests
+type preferencesService struct {
+	service.UserService
+}
+
+func (preferencesService) GetPreferences(ctx context.Context, userID uint) (*dto.UserPreferencesResponse, error) {
+	return &dto.UserPreferencesResponse{}, nil
+}
+
+func (preferencesService) UpdatePreferences(ctx context.Context, userID uint, req *dto.UpdatePreferencesRequest) (*dto.UserPreferencesResponse, error) {
+	return &dto.UserPreferencesResponse{}, nil
+}
+
+func TestPreferencesOwnership(t *testing.T) {
+	h := NewUserHandler(preferencesService{}, validator.New(), nil)
+
+	tests := []struct {
+		name   string
+		caller string
+		role   rbac.Role
+		status int
+	}{
+		{name: "owner", caller: "1", status: http.StatusOK},
+		{name: "other user", caller: "2", status: http.StatusForbidden},
+		{name: "other user with user role", caller: "2", role: rbac.RoleUser, status: http.StatusForbidden},
+		{name: "admin", caller: "2", role: rbac.RoleAdmin, status: http.StatusOK},
+		{name: "anonymous", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		for _, method := range []string{http.MethodGet, http.MethodPut} {
+			t.Run(tt.name+" "+method, func(t *testing.T) {
+				req := httptest.NewRequest(method, "/users/1/preferences", strings.NewReader(`{}`))
+				req.SetPathValue("id", "1")
+				if tt.caller != "" {
+					req.Header.Set("X-User-ID", tt.caller)
+				}
+				if tt.role != "" {
+					req = req.WithContext(identity.WithIdentity(req.Context(), &identity.Identity{Role: string(tt.role)}))
+				}
+
+				rec := httptest.NewRecorder()
+				if method == http.MethodGet {
+					h.GetPreferences(rec, req)
+				} else {
+					h.UpdatePreferences(rec, req)
+				}
+				if rec.Code != tt.status {
+					t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS `tbl_login_events`;
+DROP TABLE IF EXISTS `tbl_group_members`;
+DROP TABLE IF EXISTS `tbl_groups`;
+DROP TABLE IF EXISTS `tbl_user_preferences`;
+DROP TABLE IF EXISTS `tbl_password_reset_tokens`;
+DROP TABLE IF EXISTS `tbl_users`;
@@ -0,0 +1,91 @@
+-- Baseline of the schema the service used to create at startup. Tables are
+-- created only if missing, so databases set up by earlier releases are
+-- adopted as they are.
+
+CREATE TABLE IF NOT EXISTS `tbl_users` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `public_id` varchar(36) NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `email` varchar(191) NOT NULL,
+  `email_verified` boolean DEFAULT false,
+  `image` text,
+  `role` enum('USER','ADMIN') DEFAULT 'USER',
+  `password` varchar(255) NOT NULL,
+  `pending_email` varchar(191) NULL,
+  `is_active` boolean NOT NULL DEFAULT true,
+  `deactivated_at` datetime(3) NULL,
+  `created_at` datetime(3) NULL,
+  `updated_at` datetime(3) NULL,
+  `version` bigint unsigned NOT NULL DEFAULT 1,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `idx_tbl_users_public_id` (`public_id`),
+  UNIQUE INDEX `idx_tbl_users_email` (`email`),
+  INDEX `idx_tbl_users_role` (`role`),
+  INDEX `idx_tbl_users_is_active` (`is_active`),
+  INDEX `idx_tbl_users_created_at` (`created_at`)
+);
+
+CREATE TABLE IF NOT EXISTS `tbl_password_reset_tokens` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` bigint unsigned NOT NULL,
+  `token_hash` char(64) NOT NULL,
+  `expires_at` datetime(3) NOT NULL,
+  `used_at` datetime(3) NULL,
+  `created_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_tbl_password_reset_tokens_user_id` (`user_id`),
+  UNIQUE INDEX `idx_tbl_password_reset_tokens_token_hash` (`token_hash`)
+);
+
+CREATE TABLE IF NOT EXISTS `tbl_user_preferences` (
+  `user_id` bigint unsigned NOT NULL,
+  `locale` varchar(35) NOT NULL,
+  `timezone` varchar(64) NOT NULL,
+  `marketing_opt_in` boolean NOT NULL,
+  `notify_email` boolean NOT NULL,
+  `notify_sms` boolean NOT NULL,
+  `notify_push` boolean NOT NULL,
+  `extra` json,
+  `created_at` datetime(3) NULL,
+  `updated_at` datetime(3) NULL,
+  PRIMARY KEY (`user_id`)
+);
+
+CREATE TABLE IF NOT EXISTS `tbl_groups` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `slug` varchar(50) NOT NULL,
+  `name` varchar(100) NOT NULL,
+  `description` varchar(500) NOT NULL,
+  `created_at` datetime(3) NULL,
+  `updated_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `idx_tbl_groups_slug` (`slug`)
+);
+
+CREATE TABLE IF NOT EXISTS `tbl_group_members` (
+  `group_id` bigint unsigned NOT NULL,
+  `user_id` bigint unsigned NOT NULL,
+  `role` enum('OWNER','ADMIN','MEMBER') NOT NULL,
+  `created_at` datetime(3) NULL,
+  `updated_at` datetime(3) NULL,
+  PRIMARY KEY (`group_id`, `user_id`),
+  INDEX `idx_tbl_group_members_user_id` (`user_id`)
+);
+
+CREATE TABLE IF NOT EXISTS `tbl_login_events` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` bigint unsigned NULL,
+  `email` varchar(255) NOT NULL,
+  `method` varchar(20) NOT NULL,
+  `provider` varchar(50) NOT NULL,
+  `success` boolean NOT NULL,
+  `failure_reason` varchar(50) NOT NULL,
+  `ip_address` varchar(45) NOT NULL,
+  `user_agent` varchar(255) NOT NULL,
+  `country` varchar(2) NOT NULL,
+  `anomaly` varchar(30) NOT NULL,
+  `created_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_login_events_user_created` (`user_id`, `created_at`),
+  INDEX `idx_tbl_login_events_anomaly` (`anomaly`)
+);
@@ -0,0 +1 @@
+ALTER TABLE `tbl_users` DROP COLUMN `password_reset_at`;
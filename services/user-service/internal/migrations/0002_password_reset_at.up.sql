@@ -0,0 +1,3 @@
+-- When the password was last reset by link, so tokens issued before then
+-- can be refused
+ALTER TABLE `tbl_users` ADD COLUMN `password_reset_at` datetime(3) NULL AFTER `deactivated_at`;
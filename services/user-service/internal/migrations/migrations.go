@@ -0,0 +1,10 @@
+// Package migrations holds the versioned SQL migrations of the user-service
+// schema, named in golang-migrate's NNNN_name.up.sql / .down.sql layout
+package migrations
+
+import "embed"
+
+// Files are applied in version order by database.Migrator
+//
+//go:embed *.sql
+var Files embed.FS
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/shared/pkg/database"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrGroupNotFound is returned for unknown group IDs and slugs
+	ErrGroupNotFound = fmt.Errorf("group %w", appErrors.ErrNotFound)
+	// ErrGroupMemberNotFound is returned when the user is not in the group
+	ErrGroupMemberNotFound = fmt.Errorf("group member %w", appErrors.ErrNotFound)
+)
+
+type GroupRepository interface {
+	// Create stores the group with ownerID as its first owner
+	Create(ctx context.Context, group *domain.Group, ownerID uint) error
+	GetByID(ctx context.Context, id uint) (*domain.Group, error)
+	GetBySlug(ctx context.Context, slug string) (*domain.Group, error)
+	Update(ctx context.Context, group *domain.Group) error
+	// Delete removes the group and its memberships
+	Delete(ctx context.Context, id uint) error
+	// ListByUser returns the groups the user belongs to, by name
+	ListByUser(ctx context.Context, userID uint) ([]domain.GroupMembership, error)
+	GetMember(ctx context.Context, groupID, userID uint) (*domain.GroupMember, error)
+	// ListMembers returns the group's members, oldest first
+	ListMembers(ctx context.Context, groupID uint) ([]domain.GroupMember, error)
+	// SaveMember adds the member or changes their role
+	SaveMember(ctx context.Context, member *domain.GroupMember) error
+	DeleteMember(ctx context.Context, groupID, userID uint) error
+	CountOwners(ctx context.Context, groupID uint) (int64, error)
+	// DeleteMemberships removes the user from every group
+	DeleteMemberships(ctx context.Context, userID uint) error
+}
+
+type groupRepository struct {
+	db *gorm.DB
+}
+
+func NewGroupRepository(db *gorm.DB) GroupRepository {
+	return &groupRepository{db: db}
+}
+
+func (r *groupRepository) Create(ctx context.Context, group *domain.Group, ownerID uint) error {
+	err := conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(group).Error; err != nil {
+			return err
+		}
+		return tx.Create(&domain.GroupMember{
+			GroupID: group.ID,
+			UserID:  ownerID,
+			Role:    rbac.GroupOwner,
+		}).Error
+	})
+	return database.TranslateError(err)
+}
+
+func (r *groupRepository) GetByID(ctx context.Context, id uint) (*domain.Group, error) {
+	var group domain.Group
+	if err := conn(ctx, r.db).First(&group, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrGroupNotFound
+		}
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (r *groupRepository) GetBySlug(ctx context.Context, slug string) (*domain.Group, error) {
+	var group domain.Group
+	if err := conn(ctx, r.db).Where("slug = ?", slug).First(&group).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrGroupNotFound
+		}
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (r *groupRepository) Update(ctx context.Context, group *domain.Group) error {
+	if err := conn(ctx, r.db).Save(group).Error; err != nil {
+		return database.TranslateError(err)
+	}
+	return nil
+}
+
+func (r *groupRepository) Delete(ctx context.Context, id uint) error {
+	return conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", id).Delete(&domain.GroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&domain.Group{}, id).Error
+	})
+}
+
+func (r *groupRepository) ListByUser(ctx context.Context, userID uint) ([]domain.GroupMembership, error) {
+	var memberships []domain.GroupMembership
+	err := conn(ctx, r.db).
+		Model(&domain.Group{}).
+		Select("tbl_groups.*, tbl_group_members.role").
+		Joins("JOIN tbl_group_members ON tbl_group_members.group_id = tbl_groups.id").
+		Where("tbl_group_members.user_id = ?", userID).
+		Order("tbl_groups.name, tbl_groups.id").
+		Scan(&memberships).Error
+	if err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+func (r *groupRepository) GetMember(ctx context.Context, groupID, userID uint) (*domain.GroupMember, error) {
+	var member domain.GroupMember
+	err := conn(ctx, r.db).Where("group_id = ? AND user_id = ?", groupID, userID).First(&member).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrGroupMemberNotFound
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (r *groupRepository) ListMembers(ctx context.Context, groupID uint) ([]domain.GroupMember, error) {
+	var members []domain.GroupMember
+	err := conn(ctx, r.db).Where("group_id = ?", groupID).Order("created_at, user_id").Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (r *groupRepository) SaveMember(ctx context.Context, member *domain.GroupMember) error {
+	if err := conn(ctx, r.db).Save(member).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *groupRepository) DeleteMember(ctx context.Context, groupID, userID uint) error {
+	result := conn(ctx, r.db).Where("group_id = ? AND user_id = ?", groupID, userID).Delete(&domain.GroupMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrGroupMemberNotFound
+	}
+	return nil
+}
+
+func (r *groupRepository) CountOwners(ctx context.Context, groupID uint) (int64, error) {
+	var count int64
+	err := conn(ctx, r.db).
+		Model(&domain.GroupMember{}).
+		Where("group_id = ? AND role = ?", groupID, rbac.GroupOwner).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *groupRepository) DeleteMemberships(ctx context.Context, userID uint) error {
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).Delete(&domain.GroupMember{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"gorm.io/gorm"
+)
+
+type LoginEventRepository interface {
+	Create(ctx context.Context, event *domain.LoginEvent) error
+	// ListByUser returns a page of the user's events, newest first, and the
+	// total number of events
+	ListByUser(ctx context.Context, userID uint, limit, offset int) ([]domain.LoginEvent, int64, error)
+	// CountFailuresSince counts the user's failed attempts after since
+	CountFailuresSince(ctx context.Context, userID uint, since time.Time) (int64, error)
+	// SuccessCountries lists the known countries the user has signed in
+	// from
+	SuccessCountries(ctx context.Context, userID uint) ([]string, error)
+}
+
+type loginEventRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginEventRepository(db *gorm.DB) LoginEventRepository {
+	return &loginEventRepository{db: db}
+}
+
+func (r *loginEventRepository) Create(ctx context.Context, event *domain.LoginEvent) error {
+	if err := conn(ctx, r.db).Create(event).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *loginEventRepository) ListByUser(ctx context.Context, userID uint, limit, offset int) ([]domain.LoginEvent, int64, error) {
+	var events []domain.LoginEvent
+	var total int64
+
+	query := conn(ctx, r.db).Model(&domain.LoginEvent{}).Where("user_id = ?", userID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&events).Error; err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+func (r *loginEventRepository) CountFailuresSince(ctx context.Context, userID uint, since time.Time) (int64, error) {
+	var count int64
+	err := conn(ctx, r.db).
+		Model(&domain.LoginEvent{}).
+		Where("user_id = ? AND success = ? AND created_at > ?", userID, false, since).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *loginEventRepository) SuccessCountries(ctx context.Context, userID uint) ([]string, error) {
+	var countries []string
+	err := conn(ctx, r.db).
+		Model(&domain.LoginEvent{}).
+		Distinct("country").
+		Where("user_id = ? AND success = ? AND country <> ''", userID, true).
+		Pluck("country", &countries).Error
+	if err != nil {
+		return nil, err
+	}
+	return countries, nil
+}
@@ -0,0 +1,190 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+)
+
+type groupRepository struct {
+	store *Store
+}
+
+func (r *groupRepository) Create(ctx context.Context, group *domain.Group, ownerID uint) error {
+	defer r.store.lock(ctx)()
+
+	if err := r.checkSlug(group); err != nil {
+		return err
+	}
+	now := time.Now()
+	group.CreatedAt, group.UpdatedAt = now, now
+	r.store.lastGroupID++
+	group.ID = r.store.lastGroupID
+	r.store.groups[group.ID] = *group
+
+	r.store.members[groupMemberKey{group.ID, ownerID}] = domain.GroupMember{
+		GroupID:   group.ID,
+		UserID:    ownerID,
+		Role:      rbac.GroupOwner,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return nil
+}
+
+func (r *groupRepository) GetByID(ctx context.Context, id uint) (*domain.Group, error) {
+	defer r.store.rlock(ctx)()
+
+	group, ok := r.store.groups[id]
+	if !ok {
+		return nil, repository.ErrGroupNotFound
+	}
+	return &group, nil
+}
+
+func (r *groupRepository) GetBySlug(ctx context.Context, slug string) (*domain.Group, error) {
+	defer r.store.rlock(ctx)()
+
+	for _, group := range r.store.groups {
+		if strings.EqualFold(group.Slug, slug) {
+			return &group, nil
+		}
+	}
+	return nil, repository.ErrGroupNotFound
+}
+
+func (r *groupRepository) Update(ctx context.Context, group *domain.Group) error {
+	defer r.store.lock(ctx)()
+
+	stored, ok := r.store.groups[group.ID]
+	if !ok {
+		return repository.ErrGroupNotFound
+	}
+	if err := r.checkSlug(group); err != nil {
+		return err
+	}
+	group.CreatedAt = stored.CreatedAt
+	group.UpdatedAt = time.Now()
+	r.store.groups[group.ID] = *group
+	return nil
+}
+
+// checkSlug enforces the unique index on slugs
+func (r *groupRepository) checkSlug(group *domain.Group) error {
+	for id, existing := range r.store.groups {
+		if id != group.ID && strings.EqualFold(existing.Slug, group.Slug) {
+			return duplicateEntry("slug", group.Slug)
+		}
+	}
+	return nil
+}
+
+func (r *groupRepository) Delete(ctx context.Context, id uint) error {
+	defer r.store.lock(ctx)()
+
+	for key := range r.store.members {
+		if key.groupID == id {
+			delete(r.store.members, key)
+		}
+	}
+	delete(r.store.groups, id)
+	return nil
+}
+
+func (r *groupRepository) ListByUser(ctx context.Context, userID uint) ([]domain.GroupMembership, error) {
+	defer r.store.rlock(ctx)()
+
+	var memberships []domain.GroupMembership
+	for key, member := range r.store.members {
+		if key.userID != userID {
+			continue
+		}
+		if group, ok := r.store.groups[key.groupID]; ok {
+			memberships = append(memberships, domain.GroupMembership{Group: group, Role: member.Role})
+		}
+	}
+	slices.SortFunc(memberships, func(a, b domain.GroupMembership) int {
+		return cmp.Or(cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)), cmp.Compare(a.ID, b.ID))
+	})
+	return memberships, nil
+}
+
+func (r *groupRepository) GetMember(ctx context.Context, groupID, userID uint) (*domain.GroupMember, error) {
+	defer r.store.rlock(ctx)()
+
+	member, ok := r.store.members[groupMemberKey{groupID, userID}]
+	if !ok {
+		return nil, repository.ErrGroupMemberNotFound
+	}
+	return &member, nil
+}
+
+func (r *groupRepository) ListMembers(ctx context.Context, groupID uint) ([]domain.GroupMember, error) {
+	defer r.store.rlock(ctx)()
+
+	var members []domain.GroupMember
+	for key, member := range r.store.members {
+		if key.groupID == groupID {
+			members = append(members, member)
+		}
+	}
+	slices.SortFunc(members, func(a, b domain.GroupMember) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.UserID, b.UserID))
+	})
+	return members, nil
+}
+
+func (r *groupRepository) SaveMember(ctx context.Context, member *domain.GroupMember) error {
+	defer r.store.lock(ctx)()
+
+	key := groupMemberKey{member.GroupID, member.UserID}
+	now := time.Now()
+	if stored, ok := r.store.members[key]; ok {
+		member.CreatedAt = stored.CreatedAt
+	} else if member.CreatedAt.IsZero() {
+		member.CreatedAt = now
+	}
+	member.UpdatedAt = now
+	r.store.members[key] = *member
+	return nil
+}
+
+func (r *groupRepository) DeleteMember(ctx context.Context, groupID, userID uint) error {
+	defer r.store.lock(ctx)()
+
+	key := groupMemberKey{groupID, userID}
+	if _, ok := r.store.members[key]; !ok {
+		return repository.ErrGroupMemberNotFound
+	}
+	delete(r.store.members, key)
+	return nil
+}
+
+func (r *groupRepository) CountOwners(ctx context.Context, groupID uint) (int64, error) {
+	defer r.store.rlock(ctx)()
+
+	var count int64
+	for key, member := range r.store.members {
+		if key.groupID == groupID && member.Role == rbac.GroupOwner {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *groupRepository) DeleteMemberships(ctx context.Context, userID uint) error {
+	defer r.store.lock(ctx)()
+
+	for key := range r.store.members {
+		if key.userID == userID {
+			delete(r.store.members, key)
+		}
+	}
+	return nil
+}
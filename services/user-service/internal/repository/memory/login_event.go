@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+)
+
+type loginEventRepository struct {
+	store *Store
+}
+
+func (r *loginEventRepository) Create(ctx context.Context, event *domain.LoginEvent) error {
+	defer r.store.lock(ctx)()
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	r.store.lastLoginEventID++
+	event.ID = r.store.lastLoginEventID
+	r.store.loginEvents = append(r.store.loginEvents, *event)
+	return nil
+}
+
+func (r *loginEventRepository) ListByUser(ctx context.Context, userID uint, limit, offset int) ([]domain.LoginEvent, int64, error) {
+	events := r.byUser(ctx, userID)
+	// Events are stored oldest first
+	slices.Reverse(events)
+
+	total := int64(len(events))
+	events = events[min(offset, len(events)):]
+	return events[:min(limit, len(events))], total, nil
+}
+
+func (r *loginEventRepository) CountFailuresSince(ctx context.Context, userID uint, since time.Time) (int64, error) {
+	var count int64
+	for _, event := range r.byUser(ctx, userID) {
+		if !event.Success && event.CreatedAt.After(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *loginEventRepository) SuccessCountries(ctx context.Context, userID uint) ([]string, error) {
+	var countries []string
+	for _, event := range r.byUser(ctx, userID) {
+		if event.Success && event.Country != "" && !slices.Contains(countries, event.Country) {
+			countries = append(countries, event.Country)
+		}
+	}
+	return countries, nil
+}
+
+func (r *loginEventRepository) byUser(ctx context.Context, userID uint) []domain.LoginEvent {
+	defer r.store.rlock(ctx)()
+
+	var events []domain.LoginEvent
+	for _, event := range r.store.loginEvents {
+		if event.UserID != nil && *event.UserID == userID {
+			events = append(events, event)
+		}
+	}
+	return events
+}
@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository"
+)
+
+type passwordResetRepository struct {
+	store *Store
+}
+
+func (r *passwordResetRepository) Create(ctx context.Context, token *domain.PasswordResetToken) error {
+	defer r.store.lock(ctx)()
+
+	for id, existing := range r.store.resets {
+		if existing.UserID == token.UserID {
+			delete(r.store.resets, id)
+		}
+	}
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	r.store.lastResetID++
+	token.ID = r.store.lastResetID
+	r.store.resets[token.ID] = *token
+	return nil
+}
+
+func (r *passwordResetRepository) Redeem(ctx context.Context, tokenHash string, passwordHash string) (*domain.PasswordResetToken, error) {
+	defer r.store.lock(ctx)()
+
+	now := time.Now()
+	for id, token := range r.store.resets {
+		if token.TokenHash != tokenHash || token.UsedAt != nil || !token.ExpiresAt.After(now) {
+			continue
+		}
+		user, ok := r.store.users[token.UserID]
+		if !ok {
+			break
+		}
+
+		token.UsedAt = &now
+		r.store.resets[id] = token
+		user.Password = passwordHash
+		user.PasswordResetAt = &now
+		user.Version++
+		user.UpdatedAt = now
+		r.store.users[user.ID] = user
+		return &token, nil
+	}
+	return nil, repository.ErrResetTokenNotFound
+}
+
+func (r *passwordResetRepository) DeleteByUser(ctx context.Context, userID uint) error {
+	defer r.store.lock(ctx)()
+
+	for id, token := range r.store.resets {
+		if token.UserID == userID {
+			delete(r.store.resets, id)
+		}
+	}
+	return nil
+}
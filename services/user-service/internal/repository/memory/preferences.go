@@ -0,0 +1,44 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository"
+)
+
+type userPreferencesRepository struct {
+	store *Store
+}
+
+func (r *userPreferencesRepository) Get(ctx context.Context, userID uint) (*domain.UserPreferences, error) {
+	defer r.store.rlock(ctx)()
+
+	preferences, ok := r.store.preferences[userID]
+	if !ok {
+		return nil, repository.ErrPreferencesNotFound
+	}
+	return &preferences, nil
+}
+
+func (r *userPreferencesRepository) Save(ctx context.Context, preferences *domain.UserPreferences) error {
+	defer r.store.lock(ctx)()
+
+	now := time.Now()
+	if stored, ok := r.store.preferences[preferences.UserID]; ok {
+		preferences.CreatedAt = stored.CreatedAt
+	} else if preferences.CreatedAt.IsZero() {
+		preferences.CreatedAt = now
+	}
+	preferences.UpdatedAt = now
+	r.store.preferences[preferences.UserID] = *preferences
+	return nil
+}
+
+func (r *userPreferencesRepository) Delete(ctx context.Context, userID uint) error {
+	defer r.store.lock(ctx)()
+
+	delete(r.store.preferences, userID)
+	return nil
+}
@@ -0,0 +1,154 @@
+// Package memory implements the user-service repositories in process
+// memory, for running the service without MySQL in local demos and tests.
+// Data is lost when the process exits and is not shared between instances.
+package memory
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+)
+
+// Store holds every table. The repositories it returns share one lock, so
+// operations spanning tables, such as redeeming a password reset, are atomic
+// like the transactions of the MySQL repositories. Store is also their
+// Transactor: a transaction holds the lock until it ends, so it is
+// serializable, and a rollback restores the tables as they were.
+type Store struct {
+	mu sync.RWMutex
+
+	users       map[uint]domain.User
+	resets      map[uint]domain.PasswordResetToken
+	preferences map[uint]domain.UserPreferences
+	groups      map[uint]domain.Group
+	members     map[groupMemberKey]domain.GroupMember
+	loginEvents []domain.LoginEvent
+
+	// Last IDs handed out, like AUTO_INCREMENT
+	lastUserID       uint
+	lastResetID      uint
+	lastGroupID      uint
+	lastLoginEventID uint
+}
+
+// txKey marks a context inside a transaction of the Store it holds
+type txKey struct{}
+
+type groupMemberKey struct {
+	groupID uint
+	userID  uint
+}
+
+func NewStore() *Store {
+	return &Store{
+		users:       make(map[uint]domain.User),
+		resets:      make(map[uint]domain.PasswordResetToken),
+		preferences: make(map[uint]domain.UserPreferences),
+		groups:      make(map[uint]domain.Group),
+		members:     make(map[groupMemberKey]domain.GroupMember),
+	}
+}
+
+func (s *Store) Users() repository.UserRepository {
+	return &userRepository{store: s}
+}
+
+func (s *Store) PasswordResets() repository.PasswordResetRepository {
+	return &passwordResetRepository{store: s}
+}
+
+func (s *Store) Preferences() repository.UserPreferencesRepository {
+	return &userPreferencesRepository{store: s}
+}
+
+func (s *Store) Groups() repository.GroupRepository {
+	return &groupRepository{store: s}
+}
+
+func (s *Store) LoginEvents() repository.LoginEventRepository {
+	return &loginEventRepository{store: s}
+}
+
+func (s *Store) Transactor() repository.Transactor {
+	return s
+}
+
+func (s *Store) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.inTx(ctx) {
+		return fn(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.clone()
+	if err := fn(context.WithValue(ctx, txKey{}, s)); err != nil {
+		s.restore(snapshot)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) inTx(ctx context.Context) bool {
+	store, _ := ctx.Value(txKey{}).(*Store)
+	return store == s
+}
+
+// lock takes the store for writing and returns the unlock function. Inside
+// a transaction the lock is already held.
+func (s *Store) lock(ctx context.Context) func() {
+	if s.inTx(ctx) {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// rlock takes the store for reading and returns the unlock function
+func (s *Store) rlock(ctx context.Context) func() {
+	if s.inTx(ctx) {
+		return func() {}
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock
+}
+
+// clone copies the tables, for restoring them on rollback
+func (s *Store) clone() *Store {
+	return &Store{
+		users:            maps.Clone(s.users),
+		resets:           maps.Clone(s.resets),
+		preferences:      maps.Clone(s.preferences),
+		groups:           maps.Clone(s.groups),
+		members:          maps.Clone(s.members),
+		loginEvents:      slices.Clone(s.loginEvents),
+		lastUserID:       s.lastUserID,
+		lastResetID:      s.lastResetID,
+		lastGroupID:      s.lastGroupID,
+		lastLoginEventID: s.lastLoginEventID,
+	}
+}
+
+func (s *Store) restore(snapshot *Store) {
+	s.users = snapshot.users
+	s.resets = snapshot.resets
+	s.preferences = snapshot.preferences
+	s.groups = snapshot.groups
+	s.members = snapshot.members
+	s.loginEvents = snapshot.loginEvents
+	s.lastUserID = snapshot.lastUserID
+	s.lastResetID = snapshot.lastResetID
+	s.lastGroupID = snapshot.lastGroupID
+	s.lastLoginEventID = snapshot.lastLoginEventID
+}
+
+// duplicateEntry is the error of a unique index violation, as the MySQL
+// repositories report it
+func duplicateEntry(field, value string) error {
+	return appErrors.NewDuplicateEntryError(field+" already exists", field, value)
+}
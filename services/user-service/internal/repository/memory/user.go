@@ -0,0 +1,261 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository"
+)
+
+type userRepository struct {
+	store *Store
+}
+
+func (r *userRepository) Create(ctx context.Context, user *domain.User) error {
+	defer r.store.lock(ctx)()
+
+	if err := user.BeforeCreate(nil); err != nil {
+		return err
+	}
+	// The unique indexes of tbl_users
+	for _, existing := range r.store.users {
+		if strings.EqualFold(existing.Email, user.Email) {
+			return duplicateEntry("email", user.Email)
+		}
+		if existing.PublicID == user.PublicID {
+			return duplicateEntry("public_id", user.PublicID)
+		}
+	}
+
+	if user.Role == "" {
+		user.Role = domain.USER
+	}
+	now := time.Now()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	user.UpdatedAt = now
+	r.store.lastUserID++
+	user.ID = r.store.lastUserID
+	r.store.users[user.ID] = *user
+	return nil
+}
+
+func (r *userRepository) GetByID(ctx context.Context, id uint) (*domain.User, error) {
+	defer r.store.rlock(ctx)()
+
+	user, ok := r.store.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *userRepository) GetByPublicID(ctx context.Context, publicID string) (*domain.User, error) {
+	return r.find(ctx, func(user *domain.User) bool { return user.PublicID == publicID })
+}
+
+func (r *userRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return r.find(ctx, func(user *domain.User) bool { return strings.EqualFold(user.Email, email) })
+}
+
+func (r *userRepository) find(ctx context.Context, match func(*domain.User) bool) (*domain.User, error) {
+	defer r.store.rlock(ctx)()
+
+	for _, user := range r.store.users {
+		if match(&user) {
+			return &user, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (r *userRepository) GetByIDs(ctx context.Context, ids []uint) ([]*domain.User, error) {
+	defer r.store.rlock(ctx)()
+
+	var users []*domain.User
+	for _, id := range ids {
+		if user, ok := r.store.users[id]; ok {
+			users = append(users, &user)
+		}
+	}
+	return users, nil
+}
+
+func (r *userRepository) GetByPublicIDs(ctx context.Context, publicIDs []string) ([]*domain.User, error) {
+	wanted := make(map[string]bool, len(publicIDs))
+	for _, publicID := range publicIDs {
+		wanted[publicID] = true
+	}
+	return r.filter(ctx, func(user *domain.User) bool { return wanted[user.PublicID] }), nil
+}
+
+func (r *userRepository) Update(ctx context.Context, user *domain.User) error {
+	defer r.store.lock(ctx)()
+
+	stored, ok := r.store.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return repository.ErrUserConflict
+	}
+	for id, existing := range r.store.users {
+		if id != user.ID && strings.EqualFold(existing.Email, user.Email) {
+			return duplicateEntry("email", user.Email)
+		}
+	}
+
+	user.Version++
+	user.CreatedAt = stored.CreatedAt
+	user.UpdatedAt = time.Now()
+	r.store.users[user.ID] = *user
+	return nil
+}
+
+func (r *userRepository) Delete(ctx context.Context, id uint) error {
+	defer r.store.lock(ctx)()
+
+	delete(r.store.users, id)
+	return nil
+}
+
+func (r *userRepository) List(ctx context.Context, filter dto.UserFilter, sort dto.UserSort, after *dto.UserCursor, limit, offset int) ([]*domain.User, error) {
+	key, ok := userSortKeys[sort.Field]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort field %q", sort.Field)
+	}
+	less := func(a, b *domain.User) bool {
+		if c := key(a).compare(key(b)); c != 0 {
+			return (c < 0) != sort.Descending
+		}
+		return (a.ID < b.ID) != sort.Descending
+	}
+
+	users := r.filter(ctx, func(user *domain.User) bool { return matchesUserFilter(user, filter) })
+	sortUsers(users, less)
+
+	if after != nil {
+		// Continue behind the cursor's position, as keyset pagination does
+		value, err := cursorValue(sort.Field, after.Value)
+		if err != nil {
+			return nil, err
+		}
+		behind := func(user *domain.User) bool {
+			c := key(user).compare(value)
+			if c == 0 {
+				c = cmp.Compare(user.ID, after.ID)
+			}
+			return (c > 0 && !sort.Descending) || (c < 0 && sort.Descending)
+		}
+		start := len(users)
+		for i, user := range users {
+			if behind(user) {
+				start = i
+				break
+			}
+		}
+		users = users[start:]
+	} else {
+		users = users[min(offset, len(users)):]
+	}
+	return users[:min(limit, len(users))], nil
+}
+
+func (r *userRepository) Count(ctx context.Context, filter dto.UserFilter) (int64, error) {
+	users := r.filter(ctx, func(user *domain.User) bool { return matchesUserFilter(user, filter) })
+	return int64(len(users)), nil
+}
+
+func (r *userRepository) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	_, err := r.GetByEmail(ctx, email)
+	return err == nil, nil
+}
+
+func (r *userRepository) Each(ctx context.Context, filter dto.UserFilter, batchSize int, fn func([]*domain.User) error) error {
+	users := r.filter(ctx, func(user *domain.User) bool { return matchesUserFilter(user, filter) })
+	sortUsers(users, func(a, b *domain.User) bool { return a.ID < b.ID })
+
+	for start := 0; start < len(users); start += batchSize {
+		if err := fn(users[start:min(start+batchSize, len(users))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filter returns copies of the users match accepts
+func (r *userRepository) filter(ctx context.Context, match func(*domain.User) bool) []*domain.User {
+	defer r.store.rlock(ctx)()
+
+	var users []*domain.User
+	for _, user := range r.store.users {
+		if match(&user) {
+			users = append(users, &user)
+		}
+	}
+	return users
+}
+
+func matchesUserFilter(user *domain.User, filter dto.UserFilter) bool {
+	if filter.Search != "" {
+		search := strings.ToLower(filter.Search)
+		if !strings.Contains(strings.ToLower(user.Name), search) && !strings.Contains(strings.ToLower(user.Email), search) {
+			return false
+		}
+	}
+	if filter.Role != "" && user.Role != filter.Role {
+		return false
+	}
+	if filter.EmailVerified != nil && user.EmailVerified != *filter.EmailVerified {
+		return false
+	}
+	if filter.Active != nil && user.IsActive != *filter.Active {
+		return false
+	}
+	if filter.CreatedFrom != nil && user.CreatedAt.Before(*filter.CreatedFrom) {
+		return false
+	}
+	if filter.CreatedBefore != nil && !user.CreatedAt.Before(*filter.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// sortKey is the value a listing is ordered by. Strings compare without
+// case, like MySQL's default collation.
+type sortKey struct {
+	text string
+	time time.Time
+}
+
+func (k sortKey) compare(other sortKey) int {
+	if c := k.time.Compare(other.time); c != 0 {
+		return c
+	}
+	return strings.Compare(strings.ToLower(k.text), strings.ToLower(other.text))
+}
+
+var userSortKeys = map[string]func(*domain.User) sortKey{
+	dto.UserSortCreatedAt: func(user *domain.User) sortKey { return sortKey{time: user.CreatedAt} },
+	dto.UserSortName:      func(user *domain.User) sortKey { return sortKey{text: user.Name} },
+	dto.UserSortEmail:     func(user *domain.User) sortKey { return sortKey{text: user.Email} },
+}
+
+func cursorValue(field, value string) (sortKey, error) {
+	if field != dto.UserSortCreatedAt {
+		return sortKey{text: value}, nil
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return sortKey{}, fmt.Errorf("invalid cursor time: %w", err)
+	}
+	return sortKey{time: createdAt}, nil
+}
+
+func sortUsers(users []*domain.User, less func(a, b *domain.User) bool) {
+	sort.Slice(users, func(i, j int) bool { return less(users[i], users[j]) })
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"gorm.io/gorm"
+)
+
+// ErrResetTokenNotFound is returned for unknown, expired and used tokens
+var ErrResetTokenNotFound = errors.New("password reset token not found")
+
+type PasswordResetRepository interface {
+	// Create stores a new token and deletes the user's earlier ones, so
+	// only the latest link works
+	Create(ctx context.Context, token *domain.PasswordResetToken) error
+	// Redeem marks the token used and sets the user's password in one
+	// transaction. A token can only be redeemed once.
+	Redeem(ctx context.Context, tokenHash string, passwordHash string) (*domain.PasswordResetToken, error)
+	// DeleteByUser invalidates the user's outstanding tokens
+	DeleteByUser(ctx context.Context, userID uint) error
+}
+
+type passwordResetRepository struct {
+	db *gorm.DB
+}
+
+func NewPasswordResetRepository(db *gorm.DB) PasswordResetRepository {
+	return &passwordResetRepository{db: db}
+}
+
+func (r *passwordResetRepository) Create(ctx context.Context, token *domain.PasswordResetToken) error {
+	return conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", token.UserID).Delete(&domain.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *passwordResetRepository) Redeem(ctx context.Context, tokenHash string, passwordHash string) (*domain.PasswordResetToken, error) {
+	var token domain.PasswordResetToken
+	err := conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// The conditional update claims the token, so concurrent requests
+		// with the same token cannot both succeed
+		result := tx.Model(&domain.PasswordResetToken{}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrResetTokenNotFound
+		}
+
+		if err := tx.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+			return err
+		}
+
+		result = tx.Model(&domain.User{}).Where("id = ?", token.UserID).Updates(map[string]any{
+			"password":          passwordHash,
+			"password_reset_at": now,
+			"version":           gorm.Expr("version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrResetTokenNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *passwordResetRepository) DeleteByUser(ctx context.Context, userID uint) error {
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).Delete(&domain.PasswordResetToken{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
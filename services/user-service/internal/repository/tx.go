@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Transactor groups repository calls into one transaction. The database is
+// opened with SkipDefaultTransaction, so without it every call commits on
+// its own and a failure part way leaves the earlier writes in place.
+type Transactor interface {
+	// WithTx runs fn in a transaction, committed when fn returns nil and
+	// rolled back otherwise. Repository calls take part in it when made
+	// with the context fn receives; a WithTx inside fn joins the outer
+	// transaction. That context must not be used once fn returns, so work
+	// started in the background takes the caller's context instead.
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type txKey struct{}
+
+type gormTransactor struct {
+	db *gorm.DB
+}
+
+func NewTransactor(db *gorm.DB) Transactor {
+	return &gormTransactor{db: db}
+}
+
+func (t *gormTransactor) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+	return t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// conn returns the transaction ctx carries, or db outside of one, bound to
+// ctx
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
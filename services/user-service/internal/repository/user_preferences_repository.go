@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"gorm.io/gorm"
+)
+
+// ErrPreferencesNotFound is returned for users who never saved preferences
+var ErrPreferencesNotFound = errors.New("preferences not found")
+
+type UserPreferencesRepository interface {
+	Get(ctx context.Context, userID uint) (*domain.UserPreferences, error)
+	// Save creates or replaces the user's preferences
+	Save(ctx context.Context, preferences *domain.UserPreferences) error
+	Delete(ctx context.Context, userID uint) error
+}
+
+type userPreferencesRepository struct {
+	db *gorm.DB
+}
+
+func NewUserPreferencesRepository(db *gorm.DB) UserPreferencesRepository {
+	return &userPreferencesRepository{db: db}
+}
+
+func (r *userPreferencesRepository) Get(ctx context.Context, userID uint) (*domain.UserPreferences, error) {
+	var preferences domain.UserPreferences
+	err := conn(ctx, r.db).Where("user_id = ?", userID).First(&preferences).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPreferencesNotFound
+		}
+		return nil, err
+	}
+	return &preferences, nil
+}
+
+func (r *userPreferencesRepository) Save(ctx context.Context, preferences *domain.UserPreferences) error {
+	if err := conn(ctx, r.db).Save(preferences).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *userPreferencesRepository) Delete(ctx context.Context, userID uint) error {
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).Delete(&domain.UserPreferences{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
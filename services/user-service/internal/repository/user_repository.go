@@ -3,20 +3,48 @@ package repository
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+	"github.com/dhekaag/golang-microservices/shared/pkg/database"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
 	"gorm.io/gorm"
 )
 
+// ErrUserNotFound is returned for unknown user IDs, public IDs and emails
+var ErrUserNotFound = fmt.Errorf("user %w", appErrors.ErrNotFound)
+
+// ErrUserConflict is returned by Update when the user changed, or was
+// deleted, after it was read
+var ErrUserConflict = appErrors.NewConflictError("user was changed by another request; reload it and try again", nil)
+
 type UserRepository interface {
 	Create(ctx context.Context, user *domain.User) error
 	GetByID(ctx context.Context, id uint) (*domain.User, error)
 	GetByPublicID(ctx context.Context, publicID string) (*domain.User, error)
 	GetByEmail(ctx context.Context, email string) (*domain.User, error)
+	// GetByIDs returns the users that exist among ids, in no particular order
+	GetByIDs(ctx context.Context, ids []uint) ([]*domain.User, error)
+	// GetByPublicIDs returns the users that exist among publicIDs, in no
+	// particular order
+	GetByPublicIDs(ctx context.Context, publicIDs []string) ([]*domain.User, error)
+	// Update saves user if the row is still at user.Version, and increments
+	// the version. Otherwise it returns ErrUserConflict and saves nothing.
 	Update(ctx context.Context, user *domain.User) error
 	Delete(ctx context.Context, id uint) error
-	List(ctx context.Context, limit, offset int) ([]*domain.User, int64, error)
+	// List returns a page of the users matching filter. With after set, the
+	// page starts behind that position and offset is ignored.
+	List(ctx context.Context, filter dto.UserFilter, sort dto.UserSort, after *dto.UserCursor, limit, offset int) ([]*domain.User, error)
+	// Count returns the number of users matching filter, regardless of
+	// paging
+	Count(ctx context.Context, filter dto.UserFilter) (int64, error)
 	ExistsByEmail(ctx context.Context, email string) (bool, error)
+	// Each passes the users matching filter to fn in batches, in ID order,
+	// stopping at the first error
+	Each(ctx context.Context, filter dto.UserFilter, batchSize int, fn func([]*domain.User) error) error
 }
 
 type userRepository struct {
@@ -28,18 +56,20 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 }
 
 func (r *userRepository) Create(ctx context.Context, user *domain.User) error {
-	if err := r.db.WithContext(ctx).Create(user).Error; err != nil {
-		return err
+	if err := conn(ctx, r.db).Create(user).Error; err != nil {
+		// A concurrent signup with the same email gets past the existence
+		// check and fails on the unique index
+		return database.TranslateError(err)
 	}
 	return nil
 }
 
 func (r *userRepository) GetByID(ctx context.Context, id uint) (*domain.User, error) {
 	var user domain.User
-	err := r.db.WithContext(ctx).First(&user, id).Error
+	err := conn(ctx, r.db).First(&user, id).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -48,10 +78,10 @@ func (r *userRepository) GetByID(ctx context.Context, id uint) (*domain.User, er
 
 func (r *userRepository) GetByPublicID(ctx context.Context, publicID string) (*domain.User, error) {
 	var user domain.User
-	err := r.db.WithContext(ctx).Where("public_id = ?", publicID).First(&user).Error
+	err := conn(ctx, r.db).Where("public_id = ?", publicID).First(&user).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -60,47 +90,165 @@ func (r *userRepository) GetByPublicID(ctx context.Context, publicID string) (*d
 
 func (r *userRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
 	var user domain.User
-	err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error
+	err := conn(ctx, r.db).Where("email = ?", email).First(&user).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
 	return &user, nil
 }
 
+func (r *userRepository) GetByIDs(ctx context.Context, ids []uint) ([]*domain.User, error) {
+	var users []*domain.User
+	if len(ids) == 0 {
+		return users, nil
+	}
+	if err := conn(ctx, r.db).Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *userRepository) GetByPublicIDs(ctx context.Context, publicIDs []string) ([]*domain.User, error) {
+	var users []*domain.User
+	if len(publicIDs) == 0 {
+		return users, nil
+	}
+	if err := conn(ctx, r.db).Where("public_id IN ?", publicIDs).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 func (r *userRepository) Update(ctx context.Context, user *domain.User) error {
-	if err := r.db.WithContext(ctx).Save(user).Error; err != nil {
-		return err
+	version := user.Version
+	user.Version++
+	result := conn(ctx, r.db).
+		Select("*").
+		Omit("CreatedAt").
+		Where("version = ?", version).
+		Updates(user)
+	if result.Error != nil {
+		user.Version = version
+		return database.TranslateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		user.Version = version
+		return ErrUserConflict
 	}
 	return nil
 }
 
 func (r *userRepository) Delete(ctx context.Context, id uint) error {
-	if err := r.db.WithContext(ctx).Delete(&domain.User{}, id).Error; err != nil {
+	if err := conn(ctx, r.db).Delete(&domain.User{}, id).Error; err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *userRepository) List(ctx context.Context, limit, offset int) ([]*domain.User, int64, error) {
+func (r *userRepository) List(ctx context.Context, filter dto.UserFilter, sort dto.UserSort, after *dto.UserCursor, limit, offset int) ([]*domain.User, error) {
 	var users []*domain.User
-	var total int64
 
-	// Get paginated records
-	err := r.db.WithContext(ctx).
+	column, ok := userSortColumns[sort.Field]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort field %q", sort.Field)
+	}
+
+	direction, compare := "ASC", ">"
+	if sort.Descending {
+		direction, compare = "DESC", "<"
+	}
+
+	page := conn(ctx, r.db).Scopes(userFilterScope(filter))
+	if after != nil {
+		// Keyset pagination: continue after the last row of the previous
+		// page instead of skipping rows, which stays fast on deep pages
+		var value any = after.Value
+		if column == "created_at" {
+			createdAt, err := time.Parse(time.RFC3339Nano, after.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cursor time: %w", err)
+			}
+			value = createdAt
+		}
+		page = page.Where(
+			fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, compare, column, compare),
+			value, value, after.ID,
+		)
+	} else {
+		page = page.Offset(offset)
+	}
+
+	err := page.
 		Limit(limit).
-		Offset(offset).
-		Order("created_at DESC").
-		Find(&users).
-		Count(&total).Error
+		Order(column + " " + direction).
+		Order("id " + direction).
+		Find(&users).Error
+
+	return users, err
+}
+
+// Count runs apart from List, without its paging, so the total covers every
+// matching user rather than one page
+func (r *userRepository) Count(ctx context.Context, filter dto.UserFilter) (int64, error) {
+	var total int64
+	err := conn(ctx, r.db).Model(&domain.User{}).Scopes(userFilterScope(filter)).Count(&total).Error
+	return total, err
+}
+
+// userSortColumns maps sort fields to their columns
+var userSortColumns = map[string]string{
+	dto.UserSortCreatedAt: "created_at",
+	dto.UserSortName:      "name",
+	dto.UserSortEmail:     "email",
+}
+
+// userFilterScope restricts a query to the users matching filter. List,
+// Count and Each share it, so totals always match the listing.
+func userFilterScope(filter dto.UserFilter) func(*gorm.DB) *gorm.DB {
+	return func(query *gorm.DB) *gorm.DB {
+		return applyUserFilter(query, filter)
+	}
+}
 
-	return users, total, err
+func applyUserFilter(query *gorm.DB, filter dto.UserFilter) *gorm.DB {
+	if filter.Search != "" {
+		pattern := "%" + likeEscaper.Replace(filter.Search) + "%"
+		query = query.Where("(name LIKE ? OR email LIKE ?)", pattern, pattern)
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if filter.EmailVerified != nil {
+		query = query.Where("email_verified = ?", *filter.EmailVerified)
+	}
+	if filter.Active != nil {
+		query = query.Where("is_active = ?", *filter.Active)
+	}
+	if filter.CreatedFrom != nil {
+		query = query.Where("created_at >= ?", *filter.CreatedFrom)
+	}
+	if filter.CreatedBefore != nil {
+		query = query.Where("created_at < ?", *filter.CreatedBefore)
+	}
+	return query
 }
 
+// likeEscaper makes LIKE wildcards in search terms match literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
 func (r *userRepository) ExistsByEmail(ctx context.Context, email string) (bool, error) {
 	var count int64
-	err := r.db.WithContext(ctx).Model(&domain.User{}).Where("email = ?", email).Count(&count).Error
+	err := conn(ctx, r.db).Model(&domain.User{}).Where("email = ?", email).Count(&count).Error
 	return count > 0, err
 }
+
+func (r *userRepository) Each(ctx context.Context, filter dto.UserFilter, batchSize int, fn func([]*domain.User) error) error {
+	var users []*domain.User
+	return conn(ctx, r.db).Scopes(userFilterScope(filter)).
+		FindInBatches(&users, batchSize, func(tx *gorm.DB, batch int) error {
+			return fn(users)
+		}).Error
+}
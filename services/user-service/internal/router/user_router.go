@@ -1,51 +1,207 @@
 package router
 
 import (
+	_ "embed"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/dhekaag/golang-microservices/services/user-service/internal/handler"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/identity"
 	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/metrics"
 	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
 )
 
+// openAPISpec documents the service's endpoints; the gateway merges it into
+// the combined spec served at /docs
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
 type Router struct {
-	userHandler *handler.UserHandler
+	userHandler       *handler.UserHandler
+	groupHandler      *handler.GroupHandler
+	identity          *identity.Signer
+	logLevels         *logger.Levels
+	legacyQueryRoutes *middleware.Deprecation
+	bodyLimit         middleware.BodyLimitConfig
 }
 
-func NewRouter(userHandler *handler.UserHandler) *Router {
+// NewRouter verifies the gateway's signed user identity when identity is
+// set; a nil signer trusts X-User-ID. With legacyQueryRoutes, users can
+// still be addressed as /users?id= and /users?public_id=, which is
+// deprecated in favour of /users/{id} as legacyQueryRoutes describes. Admins can change logLevels at
+// runtime. Request bodies are capped by bodyLimit.
+func NewRouter(userHandler *handler.UserHandler, groupHandler *handler.GroupHandler, identity *identity.Signer, logLevels *logger.Levels, legacyQueryRoutes *middleware.Deprecation, bodyLimit middleware.BodyLimitConfig) *Router {
 	return &Router{
-		userHandler: userHandler,
+		userHandler:       userHandler,
+		groupHandler:      groupHandler,
+		identity:          identity,
+		logLevels:         logLevels,
+		legacyQueryRoutes: legacyQueryRoutes,
+		bodyLimit:         bodyLimit,
 	}
 }
 
 func (r *Router) SetupRoutes() http.Handler {
-	mux := http.NewServeMux()
+	mux := utils.NewServeMux()
+
+	middleware.RegisterMetrics(mux, metrics.Default())
 
 	// Health check
-	mux.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"healthy","service":"user-service"}`))
 	})
 
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPISpec)
+	})
+
 	// Auth routes (no authentication required)
-	mux.HandleFunc("/auth/register", r.userHandler.Register)
-	mux.HandleFunc("/auth/login", r.userHandler.Login)
+	mux.HandleFunc("POST /auth/register", r.userHandler.Register)
+	mux.HandleFunc("POST /auth/login", r.userHandler.Login)
+	mux.HandleFunc("POST /auth/oauth", r.userHandler.OAuthLogin)
+	mux.HandleFunc("POST /auth/passwordless", r.userHandler.PasswordlessLogin)
+	mux.HandleFunc("GET /auth/verify-email", r.userHandler.VerifyEmail)
+	mux.HandleFunc("GET /auth/confirm-email-change", r.userHandler.ConfirmEmailChange)
+	mux.HandleFunc("POST /auth/resend-verification", r.userHandler.ResendVerification)
+	mux.HandleFunc("POST /auth/forgot-password", r.userHandler.ForgotPassword)
+	mux.HandleFunc("POST /auth/reset-password", r.userHandler.ResetPassword)
 
 	// User management routes (authentication required)
-	mux.HandleFunc("/users", r.handleUserRoutes)
-	mux.HandleFunc("/users/", r.handleUserRoutes)
+	mux.HandleFunc("GET /users", r.legacyUserQuery(r.userHandler.GetUser, r.userHandler.ListUsers))
+	mux.HandleFunc("GET /users/{id}", r.userHandler.GetUser)
+	mux.HandleFunc("POST /users/batch", r.requirePermission(rbac.UsersRead, r.userHandler.BatchGetUsers))
+	mux.HandleFunc("PUT /users/{id}", r.userHandler.UpdateUser)
+	mux.HandleFunc("DELETE /users/{id}", r.userHandler.DeleteUser)
+	mux.HandleFunc("PUT /users/{id}/change-password", r.userHandler.ChangePassword)
+	mux.HandleFunc("GET /users/{id}/preferences", r.userHandler.GetPreferences)
+	mux.HandleFunc("PUT /users/{id}/preferences", r.userHandler.UpdatePreferences)
+	mux.HandleFunc("GET /users/{id}/login-history", r.userHandler.GetLoginHistory)
+	if r.legacyQueryRoutes != nil {
+		mux.HandleFunc("PUT /users", r.legacyUserQuery(r.userHandler.UpdateUser, nil))
+		mux.HandleFunc("DELETE /users", r.legacyUserQuery(r.userHandler.DeleteUser, nil))
+	}
+
+	// Bulk user administration
+	mux.HandleFunc("GET /users/export", r.requirePermission(rbac.UsersRead, r.userHandler.ExportUsers))
+	mux.HandleFunc("POST /users/import", r.requirePermission(rbac.UsersWrite, r.userHandler.ImportUsers))
+	mux.HandleFunc("GET /users/import/jobs/{id}", r.requirePermission(rbac.UsersWrite, r.userHandler.GetImportJob))
+	mux.HandleFunc("POST /users/{id}/deactivate", r.requirePermission(rbac.UsersWrite, r.userHandler.DeactivateUser))
+	mux.HandleFunc("POST /users/{id}/reactivate", r.requirePermission(rbac.UsersWrite, r.userHandler.ReactivateUser))
+
+	// Log levels, for admins only; admins are recognized by the signed
+	// identity, so this is unavailable without IDENTITY_SIGNING_SECRET
+	logLevels := middleware.RequireAdmin(middleware.LogLevels(r.logLevels))
+	mux.Handle("GET /users/admin/log-levels", logLevels)
+	mux.Handle("PUT /users/admin/log-levels", logLevels)
+
+	// Groups and their members; roles within a group are checked by the
+	// service
+	mux.HandleFunc("GET /groups", r.groupHandler.ListGroups)
+	mux.HandleFunc("POST /groups", r.groupHandler.CreateGroup)
+	mux.HandleFunc("GET /groups/{group}", r.groupHandler.GetGroup)
+	mux.HandleFunc("PUT /groups/{group}", r.groupHandler.UpdateGroup)
+	mux.HandleFunc("DELETE /groups/{group}", r.groupHandler.DeleteGroup)
+	mux.HandleFunc("GET /groups/{group}/members", r.groupHandler.ListMembers)
+	mux.HandleFunc("PUT /groups/{group}/members/{user_id}", r.groupHandler.SetMember)
+	mux.HandleFunc("DELETE /groups/{group}/members/{user_id}", r.groupHandler.RemoveMember)
+	mux.HandleFunc("GET /users/{id}/groups", r.groupHandler.ListUserGroups)
 
 	// Apply middlewares
 	handler := middleware.Chain(
+		middleware.Localize(appErrors.DefaultCatalog()),
+		middleware.Envelope(appErrors.EnvelopeWrapped),
 		middleware.Recovery(),
+		middleware.Tracing(),
+		legacyImportJobPath,
+		middleware.TraceRoute(mux),
+		middleware.Metrics(metrics.Default(), mux),
+		middleware.VerifyIdentity(r.identity),
 		r.contextMiddleware,
 		middleware.Logging(),
+		middleware.ETag(),
 		middleware.CORS(),
+		middleware.BodyLimit(r.bodyLimit),
 	)(mux)
 
 	return handler
 }
 
+// requirePermission checks the role of the verified identity. Like
+// RequireAdmin, it refuses every request without one, so these routes are
+// unavailable without IDENTITY_SIGNING_SECRET: the gateway's catch-all users
+// route checks no permission, and X-User-ID carries no role.
+func (r *Router) requirePermission(permission rbac.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id, ok := identity.FromContext(req.Context())
+		if !ok {
+			utils.SendError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		if !rbac.HasPermission(id.Role, permission) {
+			utils.SendError(w, http.StatusForbidden, "Missing permission: "+string(permission))
+			return
+		}
+		next(w, req)
+	}
+}
+
+// legacyUserQuery serves the query-parameter form of a user route: a
+// request naming its user with ?id= or ?public_id= goes to next as if the
+// user were in the path, and is marked deprecated. Other requests go to
+// fallback, or get 400 without one.
+func (r *Router) legacyUserQuery(next, fallback http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID := req.URL.Query().Get("id")
+		if userID == "" {
+			userID = req.URL.Query().Get("public_id")
+		}
+		if r.legacyQueryRoutes == nil || userID == "" {
+			if fallback == nil {
+				utils.SendError(w, http.StatusBadRequest, "User ID required")
+				return
+			}
+			fallback(w, req)
+			return
+		}
+
+		deprecation := *r.legacyQueryRoutes
+		deprecation.Successor = "/users/" + url.PathEscape(userID)
+		middleware.MarkDeprecated(w, req, deprecation)
+		req.SetPathValue("id", userID)
+		next(w, req)
+	}
+}
+
+// legacyImportJobPath serves the first path of the import job status,
+// /users/import/{id}, as /users/import/jobs/{id}, marking it deprecated.
+// The old pattern cannot be registered next to /users/{id}/preferences, as
+// both match /users/import/preferences, so the path is rewritten before it
+// reaches the mux.
+func legacyImportJobPath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		jobID, ok := strings.CutPrefix(req.URL.Path, "/users/import/")
+		if req.Method != http.MethodGet || !ok || jobID == "" || jobID == "jobs" || strings.Contains(jobID, "/") {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		successor := "/users/import/jobs/" + jobID
+		middleware.MarkDeprecated(w, req, middleware.Deprecation{Successor: successor})
+		rewritten := req.Clone(req.Context())
+		rewritten.URL.Path = successor
+		rewritten.URL.RawPath = ""
+		next.ServeHTTP(w, rewritten)
+	})
+}
+
 func (r *Router) contextMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
@@ -81,20 +237,3 @@ func (r *Router) contextMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, req)
 	})
 }
-
-func (r *Router) handleUserRoutes(w http.ResponseWriter, req *http.Request) {
-	switch req.Method {
-	case http.MethodGet:
-		if req.URL.Query().Get("id") != "" || req.URL.Query().Get("public_id") != "" {
-			r.userHandler.GetUser(w, req)
-		} else {
-			r.userHandler.ListUsers(w, req)
-		}
-	case http.MethodPut:
-		r.userHandler.UpdateUser(w, req)
-	case http.MethodDelete:
-		r.userHandler.DeleteUser(w, req)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-}
@@ -0,0 +1,45 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/handler"
+	"github.com/dhekaag/golang-microservices/shared/pkg/identity"
+	"github.com/dhekaag/golang-microservices/shared/pkg/middleware"
+)
+
+// TestSetupRoutes builds the mux, which panics on conflicting patterns,
+// and checks that the import job routes resolve. Requests carry no
+// identity, so routes requiring a permission answer 401 before reaching
+// their handler.
+func TestSetupRoutes(t *testing.T) {
+	r := NewRouter(&handler.UserHandler{}, &handler.GroupHandler{}, identity.NewSigner("router-test-secret", 0), nil, nil, middleware.BodyLimitConfig{})
+	routes := r.SetupRoutes()
+
+	tests := []struct {
+		name       string
+		path       string
+		status     int
+		deprecated bool
+	}{
+		{name: "import job", path: "/users/import/jobs/abc123", status: http.StatusUnauthorized},
+		{name: "legacy import job", path: "/users/import/abc123", status: http.StatusUnauthorized, deprecated: true},
+		{name: "export", path: "/users/export", status: http.StatusUnauthorized},
+		{name: "health", path: "/health", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.status {
+				t.Errorf("GET %s: status %d, want %d", tt.path, rec.Code, tt.status)
+			}
+			if deprecated := rec.Header().Get("Deprecation") != ""; deprecated != tt.deprecated {
+				t.Errorf("GET %s: deprecated %v, want %v", tt.path, deprecated, tt.deprecated)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+// Package seed creates the initial admin account and demo data for
+// development. Every run creates only what is missing, so it is safe to run
+// on each start of a development environment.
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DemoGroupSlug names the group the demo users are placed in
+const DemoGroupSlug = "demo"
+
+// publicIDNamespace derives public IDs from emails, so seeded users have
+// the same public ID in every environment
+var publicIDNamespace = uuid.MustParse("6f0d3c8e-5a61-4c43-9a3e-8f6b0c2d7e15")
+
+// Config describes the accounts to seed. Without AdminPassword the admin
+// gets a random password, returned once in the Result.
+type Config struct {
+	AdminEmail    string
+	AdminName     string
+	AdminPassword string
+	// DemoUsers is the number of demo accounts, demo01@example.com and
+	// onwards; 0 seeds only the admin
+	DemoUsers    int
+	DemoPassword string
+}
+
+// Result reports what a run created. AdminPassword is set only when the
+// admin was created with a generated password.
+type Result struct {
+	UsersCreated  int
+	UsersExisting int
+	GroupCreated  bool
+	MembersAdded  int
+	AdminPassword string
+}
+
+type Seeder struct {
+	users  repository.UserRepository
+	groups repository.GroupRepository
+	logger *logger.Logger
+}
+
+func NewSeeder(users repository.UserRepository, groups repository.GroupRepository, logger *logger.Logger) *Seeder {
+	return &Seeder{users: users, groups: groups, logger: logger}
+}
+
+// Run creates the admin account, then the demo users and the demo group
+// they belong to. Existing accounts are left as they are, including their
+// passwords and roles.
+func (s *Seeder) Run(ctx context.Context, cfg Config) (*Result, error) {
+	if cfg.AdminEmail == "" {
+		return nil, errors.New("admin email is required")
+	}
+	if cfg.DemoUsers > 0 && len(cfg.DemoPassword) < 8 {
+		return nil, errors.New("demo password must be at least 8 characters")
+	}
+
+	result := &Result{}
+	adminPassword := cfg.AdminPassword
+	if adminPassword == "" {
+		generated, err := utils.GenerateSecureToken(12)
+		if err != nil {
+			return nil, err
+		}
+		adminPassword = generated
+	} else if len(adminPassword) < 8 {
+		return nil, errors.New("admin password must be at least 8 characters")
+	}
+
+	admin, created, err := s.ensureUser(ctx, cfg.AdminEmail, cfg.AdminName, adminPassword, domain.ADMIN)
+	if err != nil {
+		return nil, err
+	}
+	result.count(created)
+	if created && cfg.AdminPassword == "" {
+		result.AdminPassword = adminPassword
+	}
+
+	if cfg.DemoUsers <= 0 {
+		return result, nil
+	}
+
+	demoUsers := make([]*domain.User, 0, cfg.DemoUsers)
+	for i := 1; i <= cfg.DemoUsers; i++ {
+		email := fmt.Sprintf("demo%02d@example.com", i)
+		user, created, err := s.ensureUser(ctx, email, fmt.Sprintf("Demo User %02d", i), cfg.DemoPassword, domain.USER)
+		if err != nil {
+			return nil, err
+		}
+		result.count(created)
+		demoUsers = append(demoUsers, user)
+	}
+
+	if err := s.ensureDemoGroup(ctx, admin, demoUsers, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ensureUser creates a verified, active account unless one with the email
+// exists
+func (s *Seeder) ensureUser(ctx context.Context, email, name, password string, role domain.EnumRole) (*domain.User, bool, error) {
+	exists, err := s.users.ExistsByEmail(ctx, email)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to look up %s: %w", email, err)
+	}
+	if exists {
+		user, err := s.users.GetByEmail(ctx, email)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to look up %s: %w", email, err)
+		}
+		return user, false, nil
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, false, err
+	}
+	if name == "" {
+		name = "Administrator"
+	}
+	user := &domain.User{
+		PublicID:      uuid.NewSHA1(publicIDNamespace, []byte(email)).String(),
+		Name:          name,
+		Email:         email,
+		EmailVerified: true,
+		Role:          role,
+		Password:      string(hashedPassword),
+	}
+	if err := s.users.Create(ctx, user); err != nil {
+		return nil, false, fmt.Errorf("failed to create %s: %w", email, err)
+	}
+
+	s.logger.Info(ctx, "Seeded user", "user_id", user.ID, "email", email, "role", role)
+	return user, true, nil
+}
+
+// ensureDemoGroup creates the demo group owned by the admin and adds the
+// demo users, the first as a group admin and the rest as members, so every
+// group role has an account to sign in with. Existing memberships keep
+// their role.
+func (s *Seeder) ensureDemoGroup(ctx context.Context, admin *domain.User, members []*domain.User, result *Result) error {
+	group, err := s.groups.GetBySlug(ctx, DemoGroupSlug)
+	if errors.Is(err, repository.ErrGroupNotFound) {
+		group = &domain.Group{
+			Slug:        DemoGroupSlug,
+			Name:        "Demo",
+			Description: "Demo accounts created by the seed command",
+		}
+		if err := s.groups.Create(ctx, group, admin.ID); err != nil {
+			return fmt.Errorf("failed to create the demo group: %w", err)
+		}
+		result.GroupCreated = true
+		s.logger.Info(ctx, "Seeded group", "group_id", group.ID, "slug", group.Slug)
+	} else if err != nil {
+		return fmt.Errorf("failed to look up the demo group: %w", err)
+	}
+
+	for i, user := range members {
+		_, err := s.groups.GetMember(ctx, group.ID, user.ID)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, repository.ErrGroupMemberNotFound) {
+			return fmt.Errorf("failed to look up demo group member: %w", err)
+		}
+
+		role := rbac.GroupMember
+		if i == 0 {
+			role = rbac.GroupAdmin
+		}
+		if err := s.groups.SaveMember(ctx, &domain.GroupMember{GroupID: group.ID, UserID: user.ID, Role: role}); err != nil {
+			return fmt.Errorf("failed to add demo group member: %w", err)
+		}
+		result.MembersAdded++
+	}
+	return nil
+}
+
+func (r *Result) count(created bool) {
+	if created {
+		r.UsersCreated++
+	} else {
+		r.UsersExisting++
+	}
+}
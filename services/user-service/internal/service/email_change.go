@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/events"
+	"github.com/dhekaag/golang-microservices/shared/pkg/notify"
+)
+
+var (
+	// ErrEmailTaken is returned when another account already has the address
+	ErrEmailTaken = appErrors.NewDuplicateEntryError("email already taken", "email", "")
+	// ErrInvalidEmailChangeToken is returned for forged, expired or outdated
+	// email change tokens
+	ErrInvalidEmailChangeToken = errors.New("invalid or expired email change token")
+)
+
+// requestEmailChange stores email as the user's pending address. Asking for
+// the current address cancels a pending change instead. It reports whether
+// a confirmation needs to be sent; the caller saves the user.
+func (s *userService) requestEmailChange(ctx context.Context, user *domain.User, email string) (bool, error) {
+	existingUser, _ := s.repo.GetByEmail(ctx, email)
+	if existingUser != nil && existingUser.ID != user.ID {
+		return false, ErrEmailTaken
+	}
+
+	if strings.EqualFold(user.Email, email) {
+		user.PendingEmail = nil
+		return false, nil
+	}
+
+	if !s.verifier.allowResend(email) {
+		s.logger.Warn(ctx, "Email change confirmation rate limited", "user_id", user.ID)
+		return false, ErrVerificationRateLimited
+	}
+	user.PendingEmail = &email
+	return true, nil
+}
+
+// ConfirmEmailChange applies a pending email change with the token from the
+// confirmation link. The new address counts as verified, and the previous
+// one is told about the change. Password reset links sent to the previous
+// address stop working.
+func (s *userService) ConfirmEmailChange(ctx context.Context, token string) (*dto.UserResponse, error) {
+	claims, err := s.verifier.parse(purposeChangeEmail, token)
+	if err != nil {
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	user, err := s.repo.GetByID(ctx, claims.UserID)
+	if err != nil {
+		s.logger.Warn(ctx, "Email change confirmation for unknown user", "user_id", claims.UserID)
+		return nil, ErrInvalidEmailChangeToken
+	}
+	if user.PendingEmail == nil || !strings.EqualFold(*user.PendingEmail, claims.Email) {
+		s.logger.Warn(ctx, "Email change token for a cancelled or replaced change", "user_id", user.ID)
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	// The address may have been registered since the change was requested
+	existingUser, _ := s.repo.GetByEmail(ctx, *user.PendingEmail)
+	if existingUser != nil && existingUser.ID != user.ID {
+		return nil, ErrEmailTaken
+	}
+
+	previousEmail := user.Email
+	user.Email = *user.PendingEmail
+	user.EmailVerified = true
+	user.PendingEmail = nil
+	err = s.tx.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, user); err != nil {
+			return err
+		}
+		return s.resets.DeleteByUser(ctx, user.ID)
+	})
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to change email", "user_id", user.ID)
+		return nil, err
+	}
+	s.logger.Info(ctx, "Email changed successfully", "user_id", user.ID)
+	s.publishUserAsync(ctx, events.UserUpdated, user)
+
+	s.notifyEmailChangedAsync(ctx, user, previousEmail)
+	response := s.toUserResponse(user)
+	return &response, nil
+}
+
+// sendEmailChangeAsync emails the confirmation link to the user's pending
+// address in the background
+func (s *userService) sendEmailChangeAsync(ctx context.Context, user *domain.User) {
+	userID, name, email := user.ID, user.Name, *user.PendingEmail
+	go func() {
+		ctx := context.WithoutCancel(ctx)
+		token, err := s.verifier.token(purposeChangeEmail, userID, email)
+		if err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to create email change token", "user_id", userID)
+			return
+		}
+		err = s.notifier.Send(ctx, notify.Message{
+			To:       email,
+			Template: "confirm_email_change",
+			Subject:  "Confirm your new email address",
+			Data: map[string]any{
+				"name":       name,
+				"link":       tokenLink(s.verifier.config.ChangeLinkURL, token),
+				"expires_in": s.verifier.config.TTL.String(),
+			},
+		})
+		if err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to send email change confirmation", "user_id", userID)
+			return
+		}
+		s.logger.Info(ctx, "Email change confirmation sent", "user_id", userID)
+	}()
+}
+
+// notifyEmailChangedAsync tells the previous address that the account has
+// moved, so an unexpected change does not go unnoticed
+func (s *userService) notifyEmailChangedAsync(ctx context.Context, user *domain.User, previousEmail string) {
+	userID, name, email := user.ID, user.Name, user.Email
+	go func() {
+		ctx := context.WithoutCancel(ctx)
+		err := s.notifier.Send(ctx, notify.Message{
+			To:       previousEmail,
+			Template: "email_changed",
+			Subject:  "Your email address was changed",
+			Data: map[string]any{
+				"name":      name,
+				"new_email": email,
+			},
+		})
+		if err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to send email change notice", "user_id", userID)
+			return
+		}
+		s.logger.Info(ctx, "Email change notice sent", "user_id", userID)
+	}()
+}
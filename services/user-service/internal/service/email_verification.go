@@ -0,0 +1,163 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidVerificationToken is returned for forged, expired or
+	// outdated verification tokens
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	// ErrVerificationRateLimited is returned when verification emails for an
+	// address are requested too often
+	ErrVerificationRateLimited = errors.New("too many verification emails requested")
+)
+
+const (
+	// maxResendEntries triggers a sweep of expired resend windows
+	maxResendEntries = 100000
+
+	// Token purposes; a token only works for the purpose it was signed for
+	purposeVerifyEmail = "email-verification"
+	purposeChangeEmail = "email-change"
+)
+
+// EmailVerificationConfig controls the links sent to confirm email
+// addresses. LinkURL is the public verify endpoint the token is appended to,
+// ChangeLinkURL the endpoint confirming an email change. Resends to one
+// address are at least ResendCooldown apart and at most ResendLimit per
+// ResendWindow.
+type EmailVerificationConfig struct {
+	Secret         string
+	TTL            time.Duration
+	LinkURL        string
+	ChangeLinkURL  string
+	ResendCooldown time.Duration
+	ResendLimit    int
+	ResendWindow   time.Duration
+}
+
+// emailVerifier signs verification and email change tokens and throttles
+// resends. Tokens are not stored: they carry the user ID and email address,
+// signed with HMAC-SHA256 for one purpose, so a token stops working once the
+// address changes.
+type emailVerifier struct {
+	config EmailVerificationConfig
+	secret []byte
+
+	mutex   sync.Mutex
+	resends map[string]*resendWindow
+}
+
+type resendWindow struct {
+	count    int
+	lastSent time.Time
+	resetAt  time.Time
+}
+
+type verificationClaims struct {
+	UserID    uint   `json:"uid"`
+	Email     string `json:"email"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func newEmailVerifier(config EmailVerificationConfig) *emailVerifier {
+	return &emailVerifier{
+		config:  config,
+		secret:  []byte(config.Secret),
+		resends: make(map[string]*resendWindow),
+	}
+}
+
+// token returns a token for purpose and the given address of the user
+func (v *emailVerifier) token(purpose string, userID uint, email string) (string, error) {
+	payload, err := json.Marshal(verificationClaims{
+		UserID:    userID,
+		Email:     strings.ToLower(email),
+		ExpiresAt: time.Now().Add(v.config.TTL).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal verification token: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + v.signature(purpose, encoded), nil
+}
+
+// parse checks the purpose, signature and expiry of token
+func (v *emailVerifier) parse(purpose, token string) (*verificationClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(v.signature(purpose, encoded))) {
+		return nil, ErrInvalidVerificationToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+	var claims verificationClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, ErrInvalidVerificationToken
+	}
+	return &claims, nil
+}
+
+func (v *emailVerifier) signature(purpose, encoded string) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(purpose + ":" + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// link returns the URL the user follows to verify their address
+func (v *emailVerifier) link(token string) string {
+	return tokenLink(v.config.LinkURL, token)
+}
+
+// tokenLink appends token to the query of linkURL
+func tokenLink(linkURL, token string) string {
+	separator := "?"
+	if strings.Contains(linkURL, "?") {
+		separator = "&"
+	}
+	return linkURL + separator + "token=" + token
+}
+
+// allowResend records a resend to email, reporting false while the address
+// is in its cooldown or has used up its window. Counts are kept per
+// instance.
+func (v *emailVerifier) allowResend(email string) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	now := time.Now()
+	key := strings.ToLower(email)
+	if len(v.resends) >= maxResendEntries {
+		for existing, window := range v.resends {
+			if now.After(window.resetAt) {
+				delete(v.resends, existing)
+			}
+		}
+	}
+
+	window, ok := v.resends[key]
+	if !ok || now.After(window.resetAt) {
+		v.resends[key] = &resendWindow{count: 1, lastSent: now, resetAt: now.Add(v.config.ResendWindow)}
+		return true
+	}
+	if now.Sub(window.lastSent) < v.config.ResendCooldown ||
+		(v.config.ResendLimit > 0 && window.count >= v.config.ResendLimit) {
+		return false
+	}
+	window.count++
+	window.lastSent = now
+	return true
+}
@@ -0,0 +1,378 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+)
+
+var (
+	// ErrGroupNotFound is returned for unknown groups and for groups the
+	// caller is not in
+	ErrGroupNotFound = repository.ErrGroupNotFound
+	// ErrGroupMemberNotFound is returned when the user is not in the group
+	ErrGroupMemberNotFound = repository.ErrGroupMemberNotFound
+	// ErrGroupForbidden is returned when the caller's role in the group does
+	// not allow the change
+	ErrGroupForbidden = errors.New("insufficient group role")
+	// ErrGroupSlugTaken is returned when another group has the slug
+	ErrGroupSlugTaken = appErrors.NewDuplicateEntryError("group slug already taken", "slug", "")
+	// ErrInvalidGroupSlug is returned for slugs that are not lowercase words
+	// joined by hyphens with at least one letter
+	ErrInvalidGroupSlug = errors.New("slug must be lowercase letters, digits and hyphens, with at least one letter")
+	// ErrInvalidGroupRole is returned for roles other than OWNER, ADMIN and
+	// MEMBER
+	ErrInvalidGroupRole = errors.New("role must be OWNER, ADMIN or MEMBER")
+	// ErrLastGroupOwner is returned when a change would leave a group
+	// without an owner
+	ErrLastGroupOwner = errors.New("a group needs at least one owner")
+)
+
+var (
+	groupSlugPattern   = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	nonSlugCharPattern = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// Actor is the user making a request. Admins may manage every group as
+// its owners can.
+type Actor struct {
+	UserID uint
+	Admin  bool
+}
+
+// GroupService manages groups and their members. Groups are named by their
+// numeric ID or their slug. Callers outside a group cannot tell it from a
+// missing one.
+type GroupService interface {
+	CreateGroup(ctx context.Context, actor Actor, req *dto.CreateGroupRequest) (*dto.GroupResponse, error)
+	// ListGroups returns the caller's groups
+	ListGroups(ctx context.Context, actor Actor) ([]dto.GroupResponse, error)
+	// ListUserGroups returns a user's groups, to the user and to admins
+	ListUserGroups(ctx context.Context, actor Actor, userID uint) ([]dto.GroupResponse, error)
+	GetGroup(ctx context.Context, actor Actor, group string) (*dto.GroupResponse, error)
+	UpdateGroup(ctx context.Context, actor Actor, group string, req *dto.UpdateGroupRequest) (*dto.GroupResponse, error)
+	DeleteGroup(ctx context.Context, actor Actor, group string) error
+	ListMembers(ctx context.Context, actor Actor, group string) ([]dto.GroupMemberResponse, error)
+	// SetMember adds the user to the group or changes their role
+	SetMember(ctx context.Context, actor Actor, group string, userID uint, req *dto.SetGroupMemberRequest) (*dto.GroupMemberResponse, error)
+	// RemoveMember takes the user out of the group; members may remove
+	// themselves
+	RemoveMember(ctx context.Context, actor Actor, group string, userID uint) error
+}
+
+type groupService struct {
+	repo   repository.GroupRepository
+	users  repository.UserRepository
+	logger *logger.Logger
+}
+
+func NewGroupService(repo repository.GroupRepository, users repository.UserRepository, logger *logger.Logger) GroupService {
+	return &groupService{
+		repo:   repo,
+		users:  users,
+		logger: logger,
+	}
+}
+
+func (s *groupService) CreateGroup(ctx context.Context, actor Actor, req *dto.CreateGroupRequest) (*dto.GroupResponse, error) {
+	slug := req.Slug
+	if slug == "" {
+		slug = strings.Trim(nonSlugCharPattern.ReplaceAllString(strings.ToLower(req.Name), "-"), "-")
+		if len(slug) > 50 {
+			slug = strings.TrimRight(slug[:50], "-")
+		}
+	}
+	if !validGroupSlug(slug) {
+		return nil, ErrInvalidGroupSlug
+	}
+	if _, err := s.repo.GetBySlug(ctx, slug); err == nil {
+		return nil, ErrGroupSlugTaken
+	} else if !errors.Is(err, repository.ErrGroupNotFound) {
+		return nil, err
+	}
+
+	group := &domain.Group{
+		Slug:        slug,
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	if err := s.repo.Create(ctx, group, actor.UserID); err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to create group", "slug", slug)
+		return nil, err
+	}
+
+	s.logger.Info(ctx, "Group created", "group_id", group.ID, "owner_id", actor.UserID)
+	response := toGroupResponse(group, rbac.GroupOwner)
+	return &response, nil
+}
+
+func (s *groupService) ListGroups(ctx context.Context, actor Actor) ([]dto.GroupResponse, error) {
+	return s.listGroups(ctx, actor.UserID)
+}
+
+func (s *groupService) ListUserGroups(ctx context.Context, actor Actor, userID uint) ([]dto.GroupResponse, error) {
+	if !actor.Admin && actor.UserID != userID {
+		return nil, ErrGroupForbidden
+	}
+	return s.listGroups(ctx, userID)
+}
+
+func (s *groupService) listGroups(ctx context.Context, userID uint) ([]dto.GroupResponse, error) {
+	memberships, err := s.repo.ListByUser(ctx, userID)
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to list groups", "user_id", userID)
+		return nil, err
+	}
+
+	groups := make([]dto.GroupResponse, 0, len(memberships))
+	for i := range memberships {
+		groups = append(groups, toGroupResponse(&memberships[i].Group, memberships[i].Role))
+	}
+	return groups, nil
+}
+
+func (s *groupService) GetGroup(ctx context.Context, actor Actor, group string) (*dto.GroupResponse, error) {
+	found, role, err := s.authorize(ctx, actor, group, rbac.GroupMember)
+	if err != nil {
+		return nil, err
+	}
+	response := toGroupResponse(found, role)
+	return &response, nil
+}
+
+func (s *groupService) UpdateGroup(ctx context.Context, actor Actor, group string, req *dto.UpdateGroupRequest) (*dto.GroupResponse, error) {
+	found, role, err := s.authorize(ctx, actor, group, rbac.GroupAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		found.Name = *req.Name
+	}
+	if req.Description != nil {
+		found.Description = *req.Description
+	}
+	if err := s.repo.Update(ctx, found); err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to update group", "group_id", found.ID)
+		return nil, err
+	}
+
+	s.logger.Info(ctx, "Group updated", "group_id", found.ID)
+	response := toGroupResponse(found, role)
+	return &response, nil
+}
+
+func (s *groupService) DeleteGroup(ctx context.Context, actor Actor, group string) error {
+	found, _, err := s.authorize(ctx, actor, group, rbac.GroupOwner)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(ctx, found.ID); err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to delete group", "group_id", found.ID)
+		return err
+	}
+	s.logger.Info(ctx, "Group deleted", "group_id", found.ID)
+	return nil
+}
+
+func (s *groupService) ListMembers(ctx context.Context, actor Actor, group string) ([]dto.GroupMemberResponse, error) {
+	found, _, err := s.authorize(ctx, actor, group, rbac.GroupMember)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := s.repo.ListMembers(ctx, found.ID)
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to list group members", "group_id", found.ID)
+		return nil, err
+	}
+	userIDs := make([]uint, len(members))
+	for i, member := range members {
+		userIDs[i] = member.UserID
+	}
+	users, err := s.users.GetByIDs(ctx, userIDs)
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to load group members", "group_id", found.ID)
+		return nil, err
+	}
+	usersByID := make(map[uint]*domain.User, len(users))
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+
+	responses := make([]dto.GroupMemberResponse, 0, len(members))
+	for i := range members {
+		// Members whose account is gone are left out
+		if user, ok := usersByID[members[i].UserID]; ok {
+			responses = append(responses, toGroupMemberResponse(&members[i], user))
+		}
+	}
+	return responses, nil
+}
+
+func (s *groupService) SetMember(ctx context.Context, actor Actor, group string, userID uint, req *dto.SetGroupMemberRequest) (*dto.GroupMemberResponse, error) {
+	newRole := rbac.NormalizeGroupRole(req.Role)
+	if !newRole.Valid() {
+		return nil, ErrInvalidGroupRole
+	}
+
+	found, actorRole, err := s.authorize(ctx, actor, group, rbac.GroupAdmin)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := s.repo.GetMember(ctx, found.ID, userID)
+	switch {
+	case errors.Is(err, repository.ErrGroupMemberNotFound):
+		member = &domain.GroupMember{GroupID: found.ID, UserID: userID}
+	case err != nil:
+		return nil, err
+	}
+
+	// Only owners hand out or take away ownership
+	if (newRole == rbac.GroupOwner || member.Role == rbac.GroupOwner) && !canManageOwners(actor, actorRole) {
+		return nil, ErrGroupForbidden
+	}
+	if member.Role == rbac.GroupOwner && newRole != rbac.GroupOwner {
+		if err := s.keepAnOwner(ctx, found.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	member.Role = newRole
+	if err := s.repo.SaveMember(ctx, member); err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to save group member", "group_id", found.ID, "user_id", userID)
+		return nil, err
+	}
+
+	s.logger.Info(ctx, "Group member saved", "group_id", found.ID, "user_id", userID, "role", newRole)
+	response := toGroupMemberResponse(member, user)
+	return &response, nil
+}
+
+func (s *groupService) RemoveMember(ctx context.Context, actor Actor, group string, userID uint) error {
+	minimum := rbac.GroupAdmin
+	if userID == actor.UserID {
+		minimum = rbac.GroupMember
+	}
+	found, actorRole, err := s.authorize(ctx, actor, group, minimum)
+	if err != nil {
+		return err
+	}
+
+	member, err := s.repo.GetMember(ctx, found.ID, userID)
+	if err != nil {
+		return err
+	}
+	if member.Role == rbac.GroupOwner {
+		if userID != actor.UserID && !canManageOwners(actor, actorRole) {
+			return ErrGroupForbidden
+		}
+		if err := s.keepAnOwner(ctx, found.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := s.repo.DeleteMember(ctx, found.ID, userID); err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to remove group member", "group_id", found.ID, "user_id", userID)
+		return err
+	}
+	s.logger.Info(ctx, "Group member removed", "group_id", found.ID, "user_id", userID)
+	return nil
+}
+
+// authorize finds group and checks that the actor holds at least minimum
+// in it, returning the actor's role, if any. Admins pass every check.
+// Groups the actor is not in are reported as not found.
+func (s *groupService) authorize(ctx context.Context, actor Actor, group string, minimum rbac.GroupRole) (*domain.Group, rbac.GroupRole, error) {
+	found, err := s.findGroup(ctx, group)
+	if err != nil {
+		return nil, "", err
+	}
+
+	member, err := s.repo.GetMember(ctx, found.ID, actor.UserID)
+	switch {
+	case err == nil:
+	case !errors.Is(err, repository.ErrGroupMemberNotFound):
+		return nil, "", err
+	case actor.Admin:
+		return found, "", nil
+	default:
+		return nil, "", repository.ErrGroupNotFound
+	}
+	if !actor.Admin && !member.Role.AtLeast(minimum) {
+		return nil, "", ErrGroupForbidden
+	}
+	return found, member.Role, nil
+}
+
+// canManageOwners reports whether the actor may grant or revoke ownership
+func canManageOwners(actor Actor, role rbac.GroupRole) bool {
+	return actor.Admin || role.AtLeast(rbac.GroupOwner)
+}
+
+// findGroup looks group up by ID when it is numeric and by slug otherwise
+func (s *groupService) findGroup(ctx context.Context, group string) (*domain.Group, error) {
+	if id, err := strconv.ParseUint(group, 10, 32); err == nil {
+		return s.repo.GetByID(ctx, uint(id))
+	}
+	return s.repo.GetBySlug(ctx, group)
+}
+
+// keepAnOwner fails when the group has a single owner, who is about to be
+// removed or demoted
+func (s *groupService) keepAnOwner(ctx context.Context, groupID uint) error {
+	owners, err := s.repo.CountOwners(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastGroupOwner
+	}
+	return nil
+}
+
+// validGroupSlug reports whether slug can name a group. A letter is
+// required, so slugs are never mistaken for IDs.
+func validGroupSlug(slug string) bool {
+	return len(slug) >= 2 && len(slug) <= 50 &&
+		groupSlugPattern.MatchString(slug) &&
+		strings.ContainsFunc(slug, func(r rune) bool { return r >= 'a' && r <= 'z' })
+}
+
+func toGroupResponse(group *domain.Group, role rbac.GroupRole) dto.GroupResponse {
+	return dto.GroupResponse{
+		ID:          group.ID,
+		Slug:        group.Slug,
+		Name:        group.Name,
+		Description: group.Description,
+		Role:        role,
+		CreatedAt:   group.CreatedAt,
+		UpdatedAt:   group.UpdatedAt,
+	}
+}
+
+func toGroupMemberResponse(member *domain.GroupMember, user *domain.User) dto.GroupMemberResponse {
+	return dto.GroupMemberResponse{
+		UserID:   user.ID,
+		PublicID: user.PublicID,
+		Name:     user.Name,
+		Email:    user.Email,
+		Role:     member.Role,
+		JoinedAt: member.CreatedAt,
+	}
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+)
+
+// Reasons recorded for failed logins
+const (
+	loginFailureUnknownEmail    = "unknown_email"
+	loginFailureInvalidPassword = "invalid_password"
+	loginFailureDeactivated     = "account_deactivated"
+	// loginFailureUnverifiedEmail is an OAuth login whose provider has not
+	// verified the email of an existing account
+	loginFailureUnverifiedEmail = "unverified_email"
+)
+
+// Sizes of the ip_address and user_agent columns
+const (
+	maxLoginIPLength        = 45
+	maxLoginUserAgentLength = 255
+)
+
+// LoginHistoryConfig controls which failed logins are flagged: reaching
+// FailureAlertLimit failures within FailureAlertWindow marks the attempt as
+// repeated failures. A limit of 0 turns the check off.
+type LoginHistoryConfig struct {
+	FailureAlertLimit  int
+	FailureAlertWindow time.Duration
+}
+
+// loginAttempt is a sign-in to record. User is nil for unknown emails, and
+// Failure is empty for successful logins.
+type loginAttempt struct {
+	User     *domain.User
+	Email    string
+	Method   string
+	Provider string
+	Client   dto.LoginClient
+	Failure  string
+}
+
+// recordLoginAsync stores attempt in the login history in the background,
+// flagging anomalies on the way. Failures to record are only logged, so
+// they never block a login.
+func (s *userService) recordLoginAsync(ctx context.Context, attempt loginAttempt) {
+	event := &domain.LoginEvent{
+		Email:         attempt.Email,
+		Method:        attempt.Method,
+		Provider:      attempt.Provider,
+		Success:       attempt.Failure == "",
+		FailureReason: attempt.Failure,
+		IPAddress:     truncate(attempt.Client.IPAddress, maxLoginIPLength),
+		UserAgent:     truncate(attempt.Client.UserAgent, maxLoginUserAgentLength),
+		Country:       normalizeCountry(attempt.Client.Country),
+	}
+	if attempt.User != nil {
+		userID := attempt.User.ID
+		event.UserID = &userID
+	}
+
+	go func() {
+		ctx := context.WithoutCancel(ctx)
+		if event.UserID != nil {
+			event.Anomaly = s.detectLoginAnomaly(ctx, event)
+		}
+		if err := s.loginEvents.Create(ctx, event); err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to record login event", "email", event.Email)
+			return
+		}
+		if event.Anomaly != "" {
+			// Alerting picks these up from the log and the anomaly column
+			s.logger.Warn(ctx, "Suspicious login",
+				"user_id", *event.UserID,
+				"anomaly", event.Anomaly,
+				"ip", event.IPAddress,
+				"country", event.Country,
+				"event_id", event.ID,
+			)
+		}
+	}()
+}
+
+// detectLoginAnomaly compares event, not yet stored, with the user's
+// earlier logins. Lookup errors are logged and leave the event unflagged.
+func (s *userService) detectLoginAnomaly(ctx context.Context, event *domain.LoginEvent) string {
+	userID := *event.UserID
+
+	if event.Success {
+		if event.Country == "" {
+			return ""
+		}
+		countries, err := s.loginEvents.SuccessCountries(ctx, userID)
+		if err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to read login countries", "user_id", userID)
+			return ""
+		}
+		// The first known country is not news
+		if len(countries) > 0 && !slices.Contains(countries, event.Country) {
+			return domain.LoginAnomalyNewCountry
+		}
+		return ""
+	}
+
+	limit := s.loginHistory.FailureAlertLimit
+	if limit <= 0 {
+		return ""
+	}
+	failures, err := s.loginEvents.CountFailuresSince(ctx, userID, time.Now().Add(-s.loginHistory.FailureAlertWindow))
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to count login failures", "user_id", userID)
+		return ""
+	}
+	// Flag the attempt that reaches the limit rather than every one after
+	if failures+1 == int64(limit) {
+		return domain.LoginAnomalyRepeatedFailures
+	}
+	return ""
+}
+
+func (s *userService) GetLoginHistory(ctx context.Context, userID uint, limit, offset int) (*dto.LoginHistory, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	if _, err := s.repo.GetByID(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	events, total, err := s.loginEvents.ListByUser(ctx, userID, limit, offset)
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to list login history", "user_id", userID)
+		return nil, err
+	}
+
+	history := &dto.LoginHistory{
+		Events: make([]dto.LoginEventResponse, 0, len(events)),
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	for _, event := range events {
+		history.Events = append(history.Events, dto.LoginEventResponse{
+			ID:            event.ID,
+			Method:        event.Method,
+			Provider:      event.Provider,
+			Success:       event.Success,
+			FailureReason: event.FailureReason,
+			IPAddress:     event.IPAddress,
+			UserAgent:     event.UserAgent,
+			Country:       event.Country,
+			Anomaly:       event.Anomaly,
+			CreatedAt:     event.CreatedAt,
+		})
+	}
+	return history, nil
+}
+
+// normalizeCountry keeps two-letter country codes, upper-cased, and drops
+// anything else, such as the "XX" or "T1" placeholders some proxies send
+func normalizeCountry(country string) string {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if len(country) != 2 || country[0] < 'A' || country[0] > 'Z' || country[1] < 'A' || country[1] > 'Z' || country == "XX" {
+		return ""
+	}
+	return country
+}
+
+func truncate(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	// Cut at a rune boundary
+	for length > 0 && !utf8.RuneStart(value[length]) {
+		length--
+	}
+	return value[:length]
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository"
+	"github.com/dhekaag/golang-microservices/shared/pkg/events"
+	"github.com/dhekaag/golang-microservices/shared/pkg/notify"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidResetToken is returned for unknown, expired and already used
+// password reset tokens
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// PasswordResetConfig controls password reset links. LinkURL is the page
+// that asks for the new password; the token is appended to it.
+type PasswordResetConfig struct {
+	TTL     time.Duration
+	LinkURL string
+}
+
+// SessionRevoker ends every gateway session of a user. It is satisfied by
+// *session.SessionManager.
+type SessionRevoker interface {
+	DeleteSessions(ctx context.Context, userID uint) error
+}
+
+// ForgotPassword emails a password reset link when an account has the
+// address. The work happens in the background and nothing is reported, so
+// callers cannot tell whether the account exists.
+func (s *userService) ForgotPassword(ctx context.Context, email string) error {
+	go func() {
+		ctx := context.WithoutCancel(ctx)
+		user, err := s.repo.GetByEmail(ctx, email)
+		if err != nil {
+			s.logger.Info(ctx, "Password reset requested for unknown email", "email", email)
+			return
+		}
+		if !user.IsActive {
+			s.logger.Info(ctx, "Password reset requested for deactivated account", "user_id", user.ID)
+			return
+		}
+		if err := s.sendPasswordReset(ctx, user); err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to send password reset email", "user_id", user.ID)
+			return
+		}
+		s.logger.Info(ctx, "Password reset email sent", "user_id", user.ID)
+	}()
+	return nil
+}
+
+func (s *userService) sendPasswordReset(ctx context.Context, user *domain.User) error {
+	token, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return err
+	}
+	if err := s.resets.Create(ctx, &domain.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashResetToken(token),
+		ExpiresAt: time.Now().Add(s.passwordReset.TTL),
+	}); err != nil {
+		return err
+	}
+
+	return s.notifier.Send(ctx, notify.Message{
+		To:       user.Email,
+		Template: "reset_password",
+		Subject:  "Reset your password",
+		Data: map[string]any{
+			"name":       user.Name,
+			"link":       tokenLink(s.passwordReset.LinkURL, token),
+			"expires_in": s.passwordReset.TTL.String(),
+		},
+	})
+}
+
+// ResetPassword sets a new password with a token from a reset link. The
+// token is used up, and every session of the user is ended, so whoever knew
+// the old password is signed out.
+func (s *userService) ResetPassword(ctx context.Context, req *dto.ResetPasswordRequest) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to hash new password")
+		return err
+	}
+
+	token, err := s.resets.Redeem(ctx, hashResetToken(req.Token), string(hashedPassword))
+	if err != nil {
+		if errors.Is(err, repository.ErrResetTokenNotFound) {
+			s.logger.Warn(ctx, "Password reset with invalid token")
+			return ErrInvalidResetToken
+		}
+		s.logger.ErrorWithStack(ctx, err, "Failed to reset password")
+		return err
+	}
+	s.logger.Info(ctx, "Password reset successfully", "user_id", token.UserID)
+	s.publishAsync(ctx, events.UserPasswordChanged, token.UserID, events.PasswordChanged{ID: token.UserID, Reason: passwordChangedByReset})
+
+	// The password has changed either way; a failure leaves sessions to
+	// expire on their own
+	s.endSessions(ctx, token.UserID, "password reset")
+	return nil
+}
+
+// endSessions ends every gateway session of the user when a session store
+// is configured. Failures are logged, not returned, as the change that
+// triggered them has already been made.
+func (s *userService) endSessions(ctx context.Context, userID uint, reason string) {
+	if s.sessions == nil {
+		return
+	}
+	if err := s.sessions.DeleteSessions(ctx, userID); err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to end sessions", "user_id", userID, "reason", reason)
+		return
+	}
+	s.logger.Info(ctx, "Sessions ended", "user_id", userID, "reason", reason)
+}
+
+// hashResetToken keeps raw tokens out of the database
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+)
+
+// MaxUserBatchSize is the most IDs and public IDs, together, one batch
+// lookup accepts
+const MaxUserBatchSize = 500
+
+var ErrUserBatchTooLarge = fmt.Errorf("at most %d ids and public ids are allowed", MaxUserBatchSize)
+
+// BatchGetUsers looks users up by ID and public ID in two queries, so
+// callers resolving many owners avoid a request per user. Repeated
+// identifiers are ignored.
+func (s *userService) BatchGetUsers(ctx context.Context, ids []uint, publicIDs []string) (*dto.UserBatch, error) {
+	if len(ids)+len(publicIDs) > MaxUserBatchSize {
+		return nil, ErrUserBatchTooLarge
+	}
+
+	byID, err := s.repo.GetByIDs(ctx, ids)
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to get users by ID", "count", len(ids))
+		return nil, err
+	}
+	byPublicID, err := s.repo.GetByPublicIDs(ctx, publicIDs)
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to get users by public ID", "count", len(publicIDs))
+		return nil, err
+	}
+
+	foundIDs := make(map[uint]*domain.User, len(byID))
+	for _, user := range byID {
+		foundIDs[user.ID] = user
+	}
+	foundPublicIDs := make(map[string]*domain.User, len(byPublicID))
+	for _, user := range byPublicID {
+		foundPublicIDs[user.PublicID] = user
+	}
+
+	batch := &dto.UserBatch{Users: make([]dto.UserResponse, 0, len(byID)+len(byPublicID))}
+	added := make(map[uint]bool, len(byID)+len(byPublicID))
+	add := func(user *domain.User) {
+		if !added[user.ID] {
+			added[user.ID] = true
+			batch.Users = append(batch.Users, s.toUserResponse(user))
+		}
+	}
+	missingIDs := make(map[uint]bool)
+	for _, id := range ids {
+		if user, ok := foundIDs[id]; ok {
+			add(user)
+		} else if !missingIDs[id] {
+			missingIDs[id] = true
+			batch.MissingIDs = append(batch.MissingIDs, id)
+		}
+	}
+	missingPublicIDs := make(map[string]bool)
+	for _, publicID := range publicIDs {
+		if user, ok := foundPublicIDs[publicID]; ok {
+			add(user)
+		} else if !missingPublicIDs[publicID] {
+			missingPublicIDs[publicID] = true
+			batch.MissingPublicIDs = append(batch.MissingPublicIDs, publicID)
+		}
+	}
+	return batch, nil
+}
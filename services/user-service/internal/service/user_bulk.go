@@ -0,0 +1,324 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+	"github.com/dhekaag/golang-microservices/shared/pkg/events"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/dhekaag/golang-microservices/shared/pkg/validation"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrImportJobNotFound is returned for unknown and expired import jobs
+var ErrImportJobNotFound = errors.New("import job not found")
+
+// Import job statuses
+const (
+	ImportJobRunning   = "running"
+	ImportJobCompleted = "completed"
+	ImportJobFailed    = "failed"
+)
+
+// Import row statuses
+const (
+	importRowCreated = "created"
+	importRowUpdated = "updated"
+	importRowSkipped = "skipped"
+	importRowFailed  = "failed"
+)
+
+const (
+	// exportBatchSize is the number of users read per query during export
+	exportBatchSize = 500
+	// importJobRetention is how long finished import jobs can be looked up
+	importJobRetention = 24 * time.Hour
+)
+
+// ExportUsers passes every user matching filter to fn, reading them in
+// batches so the whole table is never held in memory
+func (s *userService) ExportUsers(ctx context.Context, filter dto.UserFilter, fn func(*dto.UserResponse) error) error {
+	return s.repo.Each(ctx, filter, exportBatchSize, func(users []*domain.User) error {
+		for _, user := range users {
+			response := s.toUserResponse(user)
+			if err := fn(&response); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ImportUsers creates a user per row. Rows that fail validation or whose
+// email already has an account are reported per row; onDuplicate decides
+// whether those are skipped, updated or failed. An error is only returned
+// when the import could not run at all.
+func (s *userService) ImportUsers(ctx context.Context, rows []dto.ImportUserRow, onDuplicate string) (*dto.ImportResult, error) {
+	return s.importRows(ctx, rows, onDuplicate, nil)
+}
+
+// StartImport runs ImportUsers in the background and returns a job whose
+// progress GetImportJob reports. Jobs are kept in memory by the instance
+// that runs them.
+func (s *userService) StartImport(ctx context.Context, rows []dto.ImportUserRow, onDuplicate string) *dto.ImportJob {
+	job := s.imports.create(len(rows))
+	s.logger.Info(ctx, "Import job started", "job_id", job.ID, "rows", len(rows))
+
+	go func() {
+		ctx := context.WithoutCancel(ctx)
+		result, err := s.importRows(ctx, rows, onDuplicate, func(processed int) {
+			s.imports.progress(job.ID, processed)
+		})
+		s.imports.finish(job.ID, result, err)
+		if err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Import job failed", "job_id", job.ID)
+			return
+		}
+		s.logger.Info(ctx, "Import job completed", "job_id", job.ID,
+			"created", result.Created,
+			"updated", result.Updated,
+			"skipped", result.Skipped,
+			"failed", result.Failed,
+		)
+	}()
+	return job
+}
+
+func (s *userService) GetImportJob(ctx context.Context, id string) (*dto.ImportJob, error) {
+	job, ok := s.imports.get(id)
+	if !ok {
+		return nil, ErrImportJobNotFound
+	}
+	return job, nil
+}
+
+func (s *userService) importRows(ctx context.Context, rows []dto.ImportUserRow, onDuplicate string, progress func(processed int)) (*dto.ImportResult, error) {
+	switch onDuplicate {
+	case dto.ImportDuplicateSkip, dto.ImportDuplicateUpdate, dto.ImportDuplicateFail:
+	default:
+		return nil, fmt.Errorf("unknown duplicate strategy %q", onDuplicate)
+	}
+
+	result := &dto.ImportResult{Total: len(rows), Rows: []dto.ImportRowResult{}}
+	for i := range rows {
+		row := &rows[i]
+		status, err := s.importRow(ctx, row, onDuplicate)
+		switch {
+		case err != nil:
+			result.Failed++
+			result.Rows = append(result.Rows, dto.ImportRowResult{Line: row.Line, Email: row.Email, Status: importRowFailed, Error: err.Error()})
+		case status == importRowUpdated:
+			result.Updated++
+		case status == importRowSkipped:
+			result.Skipped++
+			result.Rows = append(result.Rows, dto.ImportRowResult{Line: row.Line, Email: row.Email, Status: importRowSkipped, Error: "email already exists"})
+		case status == importRowCreated:
+			result.Created++
+		}
+		if progress != nil {
+			progress(i + 1)
+		}
+	}
+
+	s.logger.Info(ctx, "Users imported",
+		"total", result.Total,
+		"created", result.Created,
+		"updated", result.Updated,
+		"skipped", result.Skipped,
+		"failed", result.Failed,
+	)
+	return result, nil
+}
+
+// importRow creates or updates the row's user, returning how it was handled
+// or why it failed
+func (s *userService) importRow(ctx context.Context, row *dto.ImportUserRow, onDuplicate string) (string, error) {
+	row.Name = strings.TrimSpace(row.Name)
+	row.Email = strings.TrimSpace(row.Email)
+	row.Role = strings.ToUpper(strings.TrimSpace(row.Role))
+	if err := s.validate.Struct(row); err != nil {
+		return "", describeValidationError(err)
+	}
+
+	exists, err := s.repo.ExistsByEmail(ctx, row.Email)
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to check user existence")
+		return "", errors.New("failed to check email")
+	}
+	if exists {
+		switch onDuplicate {
+		case dto.ImportDuplicateUpdate:
+			return importRowUpdated, s.updateImportedUser(ctx, row)
+		case dto.ImportDuplicateFail:
+			return "", errors.New("email already exists")
+		default:
+			return importRowSkipped, nil
+		}
+	}
+
+	hashedPassword, err := importPasswordHash(row.Password)
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to hash password")
+		return "", errors.New("failed to hash password")
+	}
+	role := domain.USER
+	if row.Role != "" {
+		role = domain.EnumRole(row.Role)
+	}
+	user := &domain.User{
+		Name:          row.Name,
+		Email:         row.Email,
+		EmailVerified: row.EmailVerified,
+		Password:      hashedPassword,
+		Role:          role,
+	}
+	if err := s.repo.Create(ctx, user); err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to create imported user", "email", row.Email)
+		return "", errors.New("failed to create user")
+	}
+	s.publishUserAsync(ctx, events.UserCreated, user)
+	return importRowCreated, nil
+}
+
+// updateImportedUser overwrites the name of an existing user, and the
+// role, password and verification when the row sets them
+func (s *userService) updateImportedUser(ctx context.Context, row *dto.ImportUserRow) error {
+	user, err := s.repo.GetByEmail(ctx, row.Email)
+	if err != nil {
+		return errors.New("failed to load existing user")
+	}
+
+	user.Name = row.Name
+	if row.Role != "" {
+		user.Role = domain.EnumRole(row.Role)
+	}
+	if row.EmailVerified {
+		user.EmailVerified = true
+	}
+	if row.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(row.Password), bcrypt.DefaultCost)
+		if err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to hash password")
+			return errors.New("failed to hash password")
+		}
+		user.Password = string(hashedPassword)
+	}
+
+	if err := s.repo.Update(ctx, user); err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to update imported user", "user_id", user.ID)
+		if errors.Is(err, ErrUserConflict) {
+			return err
+		}
+		return errors.New("failed to update user")
+	}
+	s.publishUserAsync(ctx, events.UserUpdated, user)
+	if row.Password != "" {
+		s.publishAsync(ctx, events.UserPasswordChanged, user.ID, events.PasswordChanged{ID: user.ID, Reason: passwordChangedByImport})
+	}
+	return nil
+}
+
+// importPasswordHash hashes the row's password. Rows without one get a
+// random password nobody knows; it is hashed at the minimum cost, since it
+// cannot be guessed anyway and large imports would otherwise take hours.
+func importPasswordHash(password string) (string, error) {
+	cost := bcrypt.DefaultCost
+	if password == "" {
+		randomPassword, err := utils.GenerateSecureToken(32)
+		if err != nil {
+			return "", err
+		}
+		password, cost = randomPassword, bcrypt.MinCost
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// describeValidationError lists the invalid fields of a row
+func describeValidationError(err error) error {
+	fieldErrors := validation.Errors(err)
+	messages := make([]string, len(fieldErrors))
+	for i, fieldError := range fieldErrors {
+		messages[i] = fieldError.Message
+	}
+	return errors.New("invalid row: " + strings.Join(messages, ", "))
+}
+
+// importJobs keeps the state of asynchronous imports
+type importJobs struct {
+	mutex sync.Mutex
+	jobs  map[string]*dto.ImportJob
+}
+
+func newImportJobs() *importJobs {
+	return &importJobs{jobs: make(map[string]*dto.ImportJob)}
+}
+
+func (j *importJobs) create(total int) *dto.ImportJob {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	now := time.Now()
+	for id, job := range j.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > importJobRetention {
+			delete(j.jobs, id)
+		}
+	}
+
+	job := &dto.ImportJob{
+		ID:        uuid.New().String(),
+		Status:    ImportJobRunning,
+		Total:     total,
+		CreatedAt: now,
+	}
+	j.jobs[job.ID] = job
+	copied := *job
+	return &copied
+}
+
+func (j *importJobs) progress(id string, processed int) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if job, ok := j.jobs[id]; ok {
+		job.Processed = processed
+	}
+}
+
+func (j *importJobs) finish(id string, result *dto.ImportResult, err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Result = result
+	job.Status = ImportJobCompleted
+	if err != nil {
+		job.Status = ImportJobFailed
+		job.Error = err.Error()
+	}
+}
+
+// get returns a copy of the job, safe to read while the import runs
+func (j *importJobs) get(id string) (*dto.ImportJob, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *job
+	return &copied, true
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+)
+
+// ErrInvalidCursor is returned for malformed cursors and for cursors used
+// with a different sort than the page they came from
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// userCursor is the JSON behind the opaque next_cursor. It records the
+// sort, since a position is meaningless in any other order.
+type userCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d,omitempty"`
+	Value      string `json:"v"`
+	ID         uint   `json:"id"`
+}
+
+func encodeUserCursor(sort dto.UserSort, user *domain.User) string {
+	cursor := userCursor{Sort: sort.Field, Descending: sort.Descending, ID: user.ID}
+	switch sort.Field {
+	case dto.UserSortName:
+		cursor.Value = user.Name
+	case dto.UserSortEmail:
+		cursor.Value = user.Email
+	default:
+		cursor.Value = user.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	// Marshalling a struct of strings and numbers cannot fail
+	payload, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func decodeUserCursor(value string, sort dto.UserSort) (*dto.UserCursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor userCursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Sort != sort.Field || cursor.Descending != sort.Descending {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Sort == dto.UserSortCreatedAt {
+		if _, err := time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return &dto.UserCursor{Value: cursor.Value, ID: cursor.ID}, nil
+}
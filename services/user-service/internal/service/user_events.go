@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/shared/pkg/events"
+)
+
+// Reasons given in user.password_changed events
+const (
+	passwordChangedByUser   = "change"
+	passwordChangedByReset  = "reset"
+	passwordChangedByImport = "import"
+)
+
+// publishAsync publishes a user event in the background, once the change
+// is saved. Failures are logged and not retried, so consumers may miss
+// events while the broker is down.
+func (s *userService) publishAsync(ctx context.Context, eventType string, userID uint, data any) {
+	go func() {
+		ctx := context.WithoutCancel(ctx)
+		if err := s.events.Publish(ctx, eventType, data); err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to publish user event", "type", eventType, "user_id", userID)
+		}
+	}()
+}
+
+// publishUserAsync publishes eventType, user.created or user.updated, with
+// the user as it is now
+func (s *userService) publishUserAsync(ctx context.Context, eventType string, user *domain.User) {
+	s.publishAsync(ctx, eventType, user.ID, userEvent(user))
+}
+
+func userEvent(user *domain.User) events.User {
+	return events.User{
+		ID:            user.ID,
+		PublicID:      user.PublicID,
+		Name:          user.Name,
+		Email:         user.Email,
+		Role:          string(user.Role),
+		EmailVerified: user.EmailVerified,
+		IsActive:      user.IsActive,
+	}
+}
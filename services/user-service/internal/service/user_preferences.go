@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
+	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository"
+)
+
+// maxPreferencesExtraBytes caps the JSON size of the extra settings
+const maxPreferencesExtraBytes = 16 << 10
+
+// ErrPreferencesTooLarge is returned when the extra settings exceed
+// maxPreferencesExtraBytes
+var ErrPreferencesTooLarge = fmt.Errorf("extra preferences exceed %d bytes", maxPreferencesExtraBytes)
+
+// GetPreferences returns the user's preferences, or the defaults when they
+// never saved any
+func (s *userService) GetPreferences(ctx context.Context, userID uint) (*dto.UserPreferencesResponse, error) {
+	if _, err := s.repo.GetByID(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	preferences, err := s.loadPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return toPreferencesResponse(preferences), nil
+}
+
+// UpdatePreferences applies the settings present in req and stores the
+// result
+func (s *userService) UpdatePreferences(ctx context.Context, userID uint, req *dto.UpdatePreferencesRequest) (*dto.UserPreferencesResponse, error) {
+	if _, err := s.repo.GetByID(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	preferences, err := s.loadPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Locale != nil {
+		preferences.Locale = *req.Locale
+	}
+	if req.Timezone != nil {
+		preferences.Timezone = *req.Timezone
+	}
+	if req.MarketingOptIn != nil {
+		preferences.MarketingOptIn = *req.MarketingOptIn
+	}
+	if channels := req.NotificationChannels; channels != nil {
+		if channels.Email != nil {
+			preferences.NotifyEmail = *channels.Email
+		}
+		if channels.SMS != nil {
+			preferences.NotifySMS = *channels.SMS
+		}
+		if channels.Push != nil {
+			preferences.NotifyPush = *channels.Push
+		}
+	}
+	if req.Extra != nil {
+		extra, err := json.Marshal(req.Extra)
+		if err != nil {
+			return nil, err
+		}
+		if len(extra) > maxPreferencesExtraBytes {
+			return nil, ErrPreferencesTooLarge
+		}
+		preferences.Extra = extra
+	}
+
+	if err := s.preferences.Save(ctx, preferences); err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to save preferences", "user_id", userID)
+		return nil, err
+	}
+
+	s.logger.Info(ctx, "Preferences updated", "user_id", userID)
+	return toPreferencesResponse(preferences), nil
+}
+
+func (s *userService) loadPreferences(ctx context.Context, userID uint) (*domain.UserPreferences, error) {
+	preferences, err := s.preferences.Get(ctx, userID)
+	if errors.Is(err, repository.ErrPreferencesNotFound) {
+		return domain.DefaultUserPreferences(userID), nil
+	}
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to get preferences", "user_id", userID)
+		return nil, err
+	}
+	return preferences, nil
+}
+
+// toPreferencesResponse converts preferences; defaults that were never
+// saved have no update time
+func toPreferencesResponse(preferences *domain.UserPreferences) *dto.UserPreferencesResponse {
+	response := &dto.UserPreferencesResponse{
+		Locale:         preferences.Locale,
+		Timezone:       preferences.Timezone,
+		MarketingOptIn: preferences.MarketingOptIn,
+		NotificationChannels: dto.NotificationChannels{
+			Email: preferences.NotifyEmail,
+			SMS:   preferences.NotifySMS,
+			Push:  preferences.NotifyPush,
+		},
+		Extra: map[string]any{},
+	}
+	if len(preferences.Extra) > 0 {
+		// Extra is only ever written from a marshalled object
+		_ = json.Unmarshal(preferences.Extra, &response.Extra)
+	}
+	if !preferences.UpdatedAt.IsZero() {
+		updatedAt := preferences.UpdatedAt
+		response.UpdatedAt = &updatedAt
+	}
+	return response
+}
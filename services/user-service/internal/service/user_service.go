@@ -3,37 +3,128 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/dhekaag/golang-microservices/services/user-service/internal/domain"
 	"github.com/dhekaag/golang-microservices/services/user-service/internal/dto"
 	"github.com/dhekaag/golang-microservices/services/user-service/internal/repository"
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/events"
 	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/notify"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/dhekaag/golang-microservices/shared/pkg/validation"
+	"github.com/go-playground/validator/v10"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrAccountDeactivated is returned when a deactivated account tries to
+// sign in
+var ErrAccountDeactivated = errors.New("account is deactivated")
+
+// ErrEmailExists is returned when registering an email that has an account
+var ErrEmailExists = appErrors.NewDuplicateEntryError("user with this email already exists", "email", "")
+
+// ErrIncorrectPassword is returned when changing the password with a wrong
+// current password
+var ErrIncorrectPassword = appErrors.NewValidationError("Validation failed", appErrors.ValidationErrors{{
+	Field:   "current_password",
+	Message: "current_password is incorrect",
+}})
+
+// ErrUnverifiedEmailExists is returned when an OAuth provider asserts an
+// email it did not verify and an account already has it
+var ErrUnverifiedEmailExists = fmt.Errorf("an account with this email %w", appErrors.ErrConflict)
+
+// ErrUserConflict is returned when the user changed between being read and
+// saved, or did not have the version the caller expected
+var ErrUserConflict = repository.ErrUserConflict
+
 type UserService interface {
 	Register(ctx context.Context, req *dto.RegisterRequest) (*dto.UserResponse, error)
 	Login(ctx context.Context, req *dto.LoginRequest) (*dto.LoginResponse, error)
+	OAuthLogin(ctx context.Context, req *dto.OAuthLoginRequest) (*dto.LoginResponse, error)
+	PasswordlessLogin(ctx context.Context, req *dto.PasswordlessLoginRequest) (*dto.LoginResponse, error)
+	GetLoginHistory(ctx context.Context, userID uint, limit, offset int) (*dto.LoginHistory, error)
 	CreateUser(ctx context.Context, req *dto.RegisterRequest) (*dto.UserResponse, error)
 	GetUserByID(ctx context.Context, id uint) (*dto.UserResponse, error)
 	GetUserByPublicID(ctx context.Context, publicID string) (*dto.UserResponse, error)
 	GetUserByEmail(ctx context.Context, email string) (*dto.UserResponse, error)
+	BatchGetUsers(ctx context.Context, ids []uint, publicIDs []string) (*dto.UserBatch, error)
 	UpdateUser(ctx context.Context, id uint, req *dto.UpdateProfileRequest) (*dto.UserResponse, error)
 	DeleteUser(ctx context.Context, id uint) error
-	ListUsers(ctx context.Context, limit, offset int) ([]*dto.UserResponse, int64, error)
+	DeactivateUser(ctx context.Context, id uint) (*dto.UserResponse, error)
+	ReactivateUser(ctx context.Context, id uint) (*dto.UserResponse, error)
+	ListUsers(ctx context.Context, filter dto.UserFilter, page dto.UserPage) (*dto.UserList, error)
 	ChangePassword(ctx context.Context, userID uint, req *dto.ChangePasswordRequest) error
-	VerifyEmail(ctx context.Context, userID uint) error
+	VerifyEmail(ctx context.Context, token string) (*dto.UserResponse, error)
+	ConfirmEmailChange(ctx context.Context, token string) (*dto.UserResponse, error)
+	ResendVerification(ctx context.Context, email string) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, req *dto.ResetPasswordRequest) error
+	ExportUsers(ctx context.Context, filter dto.UserFilter, fn func(*dto.UserResponse) error) error
+	ImportUsers(ctx context.Context, rows []dto.ImportUserRow, onDuplicate string) (*dto.ImportResult, error)
+	StartImport(ctx context.Context, rows []dto.ImportUserRow, onDuplicate string) *dto.ImportJob
+	GetImportJob(ctx context.Context, id string) (*dto.ImportJob, error)
+	GetPreferences(ctx context.Context, userID uint) (*dto.UserPreferencesResponse, error)
+	UpdatePreferences(ctx context.Context, userID uint, req *dto.UpdatePreferencesRequest) (*dto.UserPreferencesResponse, error)
 }
 
 type userService struct {
-	repo   repository.UserRepository
-	logger *logger.Logger
+	tx            repository.Transactor
+	repo          repository.UserRepository
+	resets        repository.PasswordResetRepository
+	preferences   repository.UserPreferencesRepository
+	groups        repository.GroupRepository
+	loginEvents   repository.LoginEventRepository
+	logger        *logger.Logger
+	notifier      *notify.Notifier
+	events        *events.Publisher
+	verifier      *emailVerifier
+	passwordReset PasswordResetConfig
+	loginHistory  LoginHistoryConfig
+	sessions      SessionRevoker
+	validate      *validator.Validate
+	imports       *importJobs
 }
 
-func NewUserService(repo repository.UserRepository, logger *logger.Logger) UserService {
+// NewUserService sends verification, email change and password reset
+// emails through notifier, and publishes user lifecycle events to events. sessions may be nil, in which case a password reset does not
+// end the user's sessions.
+func NewUserService(
+	tx repository.Transactor,
+	repo repository.UserRepository,
+	resets repository.PasswordResetRepository,
+	preferences repository.UserPreferencesRepository,
+	groups repository.GroupRepository,
+	loginEvents repository.LoginEventRepository,
+	logger *logger.Logger,
+	notifier *notify.Notifier,
+	events *events.Publisher,
+	verification EmailVerificationConfig,
+	passwordReset PasswordResetConfig,
+	loginHistory LoginHistoryConfig,
+	sessions SessionRevoker,
+) UserService {
 	return &userService{
-		repo:   repo,
-		logger: logger,
+		tx:            tx,
+		repo:          repo,
+		resets:        resets,
+		preferences:   preferences,
+		groups:        groups,
+		loginEvents:   loginEvents,
+		logger:        logger,
+		notifier:      notifier,
+		events:        events,
+		verifier:      newEmailVerifier(verification),
+		passwordReset: passwordReset,
+		loginHistory:  loginHistory,
+		sessions:      sessions,
+		validate:      validation.New(),
+		imports:       newImportJobs(),
 	}
 }
 
@@ -43,17 +134,17 @@ func (s *userService) Register(ctx context.Context, req *dto.RegisterRequest) (*
 	// Check if user already exists
 	exists, err := s.repo.ExistsByEmail(ctx, req.Email)
 	if err != nil {
-		s.logger.Error(ctx, "Failed to check user existence", "error", err)
+		s.logger.ErrorWithStack(ctx, err, "Failed to check user existence")
 		return nil, err
 	}
 	if exists {
-		return nil, errors.New("user with this email already exists")
+		return nil, ErrEmailExists
 	}
 
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		s.logger.Error(ctx, "Failed to hash password", "error", err)
+		s.logger.ErrorWithStack(ctx, err, "Failed to hash password")
 		return nil, err
 	}
 
@@ -72,11 +163,14 @@ func (s *userService) Register(ctx context.Context, req *dto.RegisterRequest) (*
 	}
 
 	if err := s.repo.Create(ctx, user); err != nil {
-		s.logger.Error(ctx, "Failed to create user", "error", err)
+		s.logger.ErrorWithStack(ctx, err, "Failed to create user")
 		return nil, err
 	}
 
 	s.logger.Info(ctx, "User registered successfully", "user_id", user.ID, "email", user.Email)
+	s.publishUserAsync(ctx, events.UserCreated, user)
+	s.verifier.allowResend(user.Email)
+	s.sendVerificationAsync(ctx, user)
 
 	// Convert to DTO response
 	response := s.toUserResponse(user)
@@ -85,28 +179,169 @@ func (s *userService) Register(ctx context.Context, req *dto.RegisterRequest) (*
 
 func (s *userService) Login(ctx context.Context, req *dto.LoginRequest) (*dto.LoginResponse, error) {
 	s.logger.Info(ctx, "User login attempt", "email", req.Email)
+	attempt := loginAttempt{Email: req.Email, Method: domain.LoginMethodPassword, Client: req.Client}
 
 	// Get user by email
 	user, err := s.repo.GetByEmail(ctx, req.Email)
-	if err != nil {
+	if appErrors.IsNotFound(err) {
 		s.logger.Warn(ctx, "Login failed - user not found", "email", req.Email)
-		return nil, errors.New("invalid credentials")
+		attempt.Failure = loginFailureUnknownEmail
+		s.recordLoginAsync(ctx, attempt)
+		return nil, appErrors.ErrInvalidCredentials
+	}
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to get user by email", "email", req.Email)
+		return nil, err
 	}
+	attempt.User = user
 
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
 		s.logger.Warn(ctx, "Login failed - invalid password", "email", req.Email)
-		return nil, errors.New("invalid credentials")
+		attempt.Failure = loginFailureInvalidPassword
+		s.recordLoginAsync(ctx, attempt)
+		return nil, appErrors.ErrInvalidCredentials
+	}
+
+	// Checked after the password, so the status is not revealed to others
+	if !user.IsActive {
+		s.logger.Warn(ctx, "Login failed - account deactivated", "user_id", user.ID)
+		attempt.Failure = loginFailureDeactivated
+		s.recordLoginAsync(ctx, attempt)
+		return nil, ErrAccountDeactivated
 	}
 
 	s.logger.Info(ctx, "User logged in successfully", "user_id", user.ID, "email", user.Email)
+	s.recordLoginAsync(ctx, attempt)
 
-	return &dto.LoginResponse{
-		ID:    user.ID,
-		Name:  user.Name,
-		Email: user.Email,
-		Role:  user.Role,
-	}, nil
+	return s.loginResponse(ctx, user), nil
+}
+
+// OAuthLogin signs in a user authenticated by an OAuth provider. Existing
+// accounts are linked by email when the provider has verified it; unknown
+// emails get a new account without a usable password.
+func (s *userService) OAuthLogin(ctx context.Context, req *dto.OAuthLoginRequest) (*dto.LoginResponse, error) {
+	s.logger.Info(ctx, "OAuth login attempt", "provider", req.Provider, "email", req.Email)
+	attempt := loginAttempt{Email: req.Email, Method: domain.LoginMethodOAuth, Provider: req.Provider, Client: req.Client}
+
+	user, err := s.repo.GetByEmail(ctx, req.Email)
+	if err == nil {
+		attempt.User = user
+		if !req.EmailVerified {
+			s.logger.Warn(ctx, "OAuth login rejected - unverified email matches existing account", "provider", req.Provider, "email", req.Email)
+			attempt.Failure = loginFailureUnverifiedEmail
+			s.recordLoginAsync(ctx, attempt)
+			return nil, ErrUnverifiedEmailExists
+		}
+		if !user.IsActive {
+			s.logger.Warn(ctx, "OAuth login rejected - account deactivated", "user_id", user.ID)
+			attempt.Failure = loginFailureDeactivated
+			s.recordLoginAsync(ctx, attempt)
+			return nil, ErrAccountDeactivated
+		}
+
+		if !user.EmailVerified {
+			user.EmailVerified = true
+			if err := s.repo.Update(ctx, user); err != nil {
+				s.logger.ErrorWithStack(ctx, err, "Failed to mark email verified", "user_id", user.ID)
+				return nil, err
+			}
+			s.publishUserAsync(ctx, events.UserUpdated, user)
+		}
+
+		s.logger.Info(ctx, "OAuth account linked", "user_id", user.ID, "provider", req.Provider)
+	} else if appErrors.IsNotFound(err) {
+		user, err = s.provisionOAuthUser(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		attempt.User = user
+	} else {
+		s.logger.ErrorWithStack(ctx, err, "Failed to get user by email", "email", req.Email)
+		return nil, err
+	}
+
+	s.recordLoginAsync(ctx, attempt)
+	return s.loginResponse(ctx, user), nil
+}
+
+// PasswordlessLogin looks up the account for a magic-link login. Unlike
+// OAuth, unknown emails are not provisioned. Following the link proves
+// ownership of the email, so it is marked verified.
+func (s *userService) PasswordlessLogin(ctx context.Context, req *dto.PasswordlessLoginRequest) (*dto.LoginResponse, error) {
+	// Only a followed link is a login; before that the gateway just looks
+	// the account up to send one
+	attempt := loginAttempt{Email: req.Email, Method: domain.LoginMethodMagicLink, Client: req.Client}
+
+	user, err := s.repo.GetByEmail(ctx, req.Email)
+	if err != nil {
+		s.logger.Warn(ctx, "Passwordless login failed - user not found", "email", req.Email)
+		if req.EmailVerified {
+			attempt.Failure = loginFailureUnknownEmail
+			s.recordLoginAsync(ctx, attempt)
+		}
+		return nil, err
+	}
+	attempt.User = user
+	if !user.IsActive {
+		s.logger.Warn(ctx, "Passwordless login rejected - account deactivated", "user_id", user.ID)
+		if req.EmailVerified {
+			attempt.Failure = loginFailureDeactivated
+			s.recordLoginAsync(ctx, attempt)
+		}
+		return nil, ErrAccountDeactivated
+	}
+
+	if req.EmailVerified && !user.EmailVerified {
+		user.EmailVerified = true
+		if err := s.repo.Update(ctx, user); err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to mark email verified", "user_id", user.ID)
+			return nil, err
+		}
+		s.publishUserAsync(ctx, events.UserUpdated, user)
+	}
+
+	if req.EmailVerified {
+		s.recordLoginAsync(ctx, attempt)
+	}
+	return s.loginResponse(ctx, user), nil
+}
+
+func (s *userService) provisionOAuthUser(ctx context.Context, req *dto.OAuthLoginRequest) (*domain.User, error) {
+	// The random password can never be entered, so the account can only
+	// sign in through the provider until a password is set
+	randomPassword, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to hash password")
+		return nil, err
+	}
+
+	name := req.Name
+	if len(name) < 2 {
+		name, _, _ = strings.Cut(req.Email, "@")
+	}
+
+	user := &domain.User{
+		Name:          name,
+		Email:         req.Email,
+		EmailVerified: req.EmailVerified,
+		Image:         req.Image,
+		Password:      string(hashedPassword),
+		Role:          domain.USER,
+	}
+
+	if err := s.repo.Create(ctx, user); err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to create OAuth user")
+		return nil, err
+	}
+
+	s.logger.Info(ctx, "OAuth user provisioned", "user_id", user.ID, "provider", req.Provider)
+	s.publishUserAsync(ctx, events.UserCreated, user)
+	return user, nil
 }
 
 func (s *userService) CreateUser(ctx context.Context, req *dto.RegisterRequest) (*dto.UserResponse, error) {
@@ -116,7 +351,7 @@ func (s *userService) CreateUser(ctx context.Context, req *dto.RegisterRequest)
 func (s *userService) GetUserByID(ctx context.Context, id uint) (*dto.UserResponse, error) {
 	user, err := s.repo.GetByID(ctx, id)
 	if err != nil {
-		s.logger.Error(ctx, "Failed to get user by ID", "user_id", id, "error", err)
+		s.logger.ErrorWithStack(ctx, err, "Failed to get user by ID", "user_id", id)
 		return nil, err
 	}
 
@@ -127,7 +362,7 @@ func (s *userService) GetUserByID(ctx context.Context, id uint) (*dto.UserRespon
 func (s *userService) GetUserByPublicID(ctx context.Context, publicID string) (*dto.UserResponse, error) {
 	user, err := s.repo.GetByPublicID(ctx, publicID)
 	if err != nil {
-		s.logger.Error(ctx, "Failed to get user by public ID", "public_id", publicID, "error", err)
+		s.logger.ErrorWithStack(ctx, err, "Failed to get user by public ID", "public_id", publicID)
 		return nil, err
 	}
 
@@ -138,7 +373,7 @@ func (s *userService) GetUserByPublicID(ctx context.Context, publicID string) (*
 func (s *userService) GetUserByEmail(ctx context.Context, email string) (*dto.UserResponse, error) {
 	user, err := s.repo.GetByEmail(ctx, email)
 	if err != nil {
-		s.logger.Error(ctx, "Failed to get user by email", "email", email, "error", err)
+		s.logger.ErrorWithStack(ctx, err, "Failed to get user by email", "email", email)
 		return nil, err
 	}
 
@@ -154,29 +389,36 @@ func (s *userService) UpdateUser(ctx context.Context, id uint, req *dto.UpdatePr
 		return nil, err
 	}
 
+	if req.Version != nil && *req.Version != user.Version {
+		return nil, ErrUserConflict
+	}
+
 	// Update fields
+	emailChanged := false
 	if req.Name != nil {
 		user.Name = *req.Name
 	}
 	if req.Email != nil {
-		// Check if email is already taken by another user
-		existingUser, _ := s.repo.GetByEmail(ctx, *req.Email)
-		if existingUser != nil && existingUser.ID != user.ID {
-			return nil, errors.New("email already taken")
+		// A new email only takes effect once it is confirmed
+		emailChanged, err = s.requestEmailChange(ctx, user, *req.Email)
+		if err != nil {
+			return nil, err
 		}
-		user.Email = *req.Email
-		user.EmailVerified = false // Reset verification if email changed
 	}
 	if req.Image != nil {
 		user.Image = req.Image
 	}
 
 	if err := s.repo.Update(ctx, user); err != nil {
-		s.logger.Error(ctx, "Failed to update user", "user_id", id, "error", err)
+		s.logger.ErrorWithStack(ctx, err, "Failed to update user", "user_id", id)
 		return nil, err
 	}
 
 	s.logger.Info(ctx, "User updated successfully", "user_id", user.ID)
+	s.publishUserAsync(ctx, events.UserUpdated, user)
+	if emailChanged {
+		s.sendEmailChangeAsync(ctx, user)
+	}
 	response := s.toUserResponse(user)
 	return &response, nil
 }
@@ -185,21 +427,90 @@ func (s *userService) DeleteUser(ctx context.Context, id uint) error {
 	s.logger.Info(ctx, "Deleting user", "user_id", id)
 
 	// Check if user exists
-	_, err := s.repo.GetByID(ctx, id)
+	user, err := s.repo.GetByID(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	if err := s.repo.Delete(ctx, id); err != nil {
-		s.logger.Error(ctx, "Failed to delete user", "user_id", id, "error", err)
+	// The user goes together with everything that refers to it
+	err = s.tx.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.Delete(ctx, id); err != nil {
+			return err
+		}
+		if err := s.preferences.Delete(ctx, id); err != nil {
+			return err
+		}
+		if err := s.resets.DeleteByUser(ctx, id); err != nil {
+			return err
+		}
+		return s.groups.DeleteMemberships(ctx, id)
+	})
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to delete user", "user_id", id)
 		return err
 	}
 
 	s.logger.Info(ctx, "User deleted successfully", "user_id", id)
+	s.publishAsync(ctx, events.UserDeleted, id, events.DeletedUser{
+		ID:       user.ID,
+		PublicID: user.PublicID,
+		Email:    user.Email,
+	})
 	return nil
 }
 
-func (s *userService) ListUsers(ctx context.Context, limit, offset int) ([]*dto.UserResponse, int64, error) {
+// DeactivateUser blocks the account from signing in and ends its sessions.
+// Deactivating an inactive account changes nothing.
+func (s *userService) DeactivateUser(ctx context.Context, id uint) (*dto.UserResponse, error) {
+	user, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.IsActive {
+		now := time.Now()
+		user.IsActive = false
+		user.DeactivatedAt = &now
+		if err := s.repo.Update(ctx, user); err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to deactivate user", "user_id", id)
+			return nil, err
+		}
+		s.logger.Info(ctx, "User deactivated", "user_id", id)
+		s.publishUserAsync(ctx, events.UserUpdated, user)
+	}
+
+	// Also run for inactive accounts, in case ending the sessions failed
+	// the first time
+	s.endSessions(ctx, id, "deactivation")
+
+	response := s.toUserResponse(user)
+	return &response, nil
+}
+
+// ReactivateUser lets a deactivated account sign in again
+func (s *userService) ReactivateUser(ctx context.Context, id uint) (*dto.UserResponse, error) {
+	user, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.IsActive {
+		user.IsActive = true
+		user.DeactivatedAt = nil
+		if err := s.repo.Update(ctx, user); err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to reactivate user", "user_id", id)
+			return nil, err
+		}
+		s.logger.Info(ctx, "User reactivated", "user_id", id)
+		s.publishUserAsync(ctx, events.UserUpdated, user)
+	}
+
+	response := s.toUserResponse(user)
+	return &response, nil
+}
+
+func (s *userService) ListUsers(ctx context.Context, filter dto.UserFilter, page dto.UserPage) (*dto.UserList, error) {
+	limit := page.Limit
 	if limit <= 0 {
 		limit = 10
 	}
@@ -207,19 +518,50 @@ func (s *userService) ListUsers(ctx context.Context, limit, offset int) ([]*dto.
 		limit = 100
 	}
 
-	users, total, err := s.repo.List(ctx, limit, offset)
+	var after *dto.UserCursor
+	if page.Cursor != "" {
+		cursor, err := decodeUserCursor(page.Cursor, page.Sort)
+		if err != nil {
+			return nil, err
+		}
+		after = cursor
+	}
+
+	// One extra row tells whether there is a next page
+	users, err := s.repo.List(ctx, filter, page.Sort, after, limit+1, page.Offset)
 	if err != nil {
-		s.logger.Error(ctx, "Failed to list users", "error", err)
-		return nil, 0, err
+		s.logger.ErrorWithStack(ctx, err, "Failed to list users")
+		return nil, err
+	}
+
+	total := int64(dto.UnknownTotal)
+	if !page.SkipCount {
+		total, err = s.repo.Count(ctx, filter)
+		if err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to count users")
+			return nil, err
+		}
 	}
 
-	var responses []*dto.UserResponse
+	list := &dto.UserList{
+		Users:  []*dto.UserResponse{},
+		Total:  total,
+		Limit:  limit,
+		Offset: page.Offset,
+	}
+	if after != nil {
+		list.Offset = 0
+	}
+	if len(users) > limit {
+		users = users[:limit]
+		list.NextCursor = encodeUserCursor(page.Sort, users[limit-1])
+	}
 	for _, user := range users {
 		response := s.toUserResponse(user)
-		responses = append(responses, &response)
+		list.Users = append(list.Users, &response)
 	}
 
-	return responses, total, nil
+	return list, nil
 }
 
 func (s *userService) ChangePassword(ctx context.Context, userID uint, req *dto.ChangePasswordRequest) error {
@@ -232,55 +574,153 @@ func (s *userService) ChangePassword(ctx context.Context, userID uint, req *dto.
 
 	// Verify current password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
-		return errors.New("current password is incorrect")
+		return ErrIncorrectPassword
 	}
 
 	// Hash new password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
 	if err != nil {
-		s.logger.Error(ctx, "Failed to hash new password", "error", err)
+		s.logger.ErrorWithStack(ctx, err, "Failed to hash new password")
 		return err
 	}
 
 	user.Password = string(hashedPassword)
 	if err := s.repo.Update(ctx, user); err != nil {
-		s.logger.Error(ctx, "Failed to update password", "user_id", userID, "error", err)
+		s.logger.ErrorWithStack(ctx, err, "Failed to update password", "user_id", userID)
 		return err
 	}
 
 	s.logger.Info(ctx, "Password changed successfully", "user_id", userID)
+	s.publishAsync(ctx, events.UserPasswordChanged, userID, events.PasswordChanged{ID: userID, Reason: passwordChangedByUser})
 	return nil
 }
 
-func (s *userService) VerifyEmail(ctx context.Context, userID uint) error {
-	s.logger.Info(ctx, "Verifying email", "user_id", userID)
+// VerifyEmail marks the address in token verified. Tokens for an address
+// the user no longer has are rejected.
+func (s *userService) VerifyEmail(ctx context.Context, token string) (*dto.UserResponse, error) {
+	claims, err := s.verifier.parse(purposeVerifyEmail, token)
+	if err != nil {
+		return nil, err
+	}
 
-	user, err := s.repo.GetByID(ctx, userID)
+	user, err := s.repo.GetByID(ctx, claims.UserID)
 	if err != nil {
-		return err
+		s.logger.Warn(ctx, "Email verification for unknown user", "user_id", claims.UserID)
+		return nil, ErrInvalidVerificationToken
+	}
+	if !strings.EqualFold(user.Email, claims.Email) {
+		s.logger.Warn(ctx, "Email verification token for a previous address", "user_id", user.ID)
+		return nil, ErrInvalidVerificationToken
 	}
 
-	user.EmailVerified = true
-	if err := s.repo.Update(ctx, user); err != nil {
-		s.logger.Error(ctx, "Failed to verify email", "user_id", userID, "error", err)
-		return err
+	if !user.EmailVerified {
+		user.EmailVerified = true
+		if err := s.repo.Update(ctx, user); err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to verify email", "user_id", user.ID)
+			return nil, err
+		}
+		s.logger.Info(ctx, "Email verified successfully", "user_id", user.ID)
+		s.publishUserAsync(ctx, events.UserUpdated, user)
+	}
+
+	response := s.toUserResponse(user)
+	return &response, nil
+}
+
+// ResendVerification emails a new verification link to an unverified
+// account. Unknown and already verified addresses are ignored, so callers
+// cannot tell them apart; only the rate limit is reported.
+func (s *userService) ResendVerification(ctx context.Context, email string) error {
+	if !s.verifier.allowResend(email) {
+		s.logger.Warn(ctx, "Verification email rate limited", "email", email)
+		return ErrVerificationRateLimited
+	}
+
+	user, err := s.repo.GetByEmail(ctx, email)
+	if err != nil {
+		s.logger.Info(ctx, "Verification requested for unknown email", "email", email)
+		return nil
+	}
+	if user.EmailVerified {
+		s.logger.Info(ctx, "Verification requested for verified email", "user_id", user.ID)
+		return nil
 	}
 
-	s.logger.Info(ctx, "Email verified successfully", "user_id", userID)
+	s.sendVerificationAsync(ctx, user)
 	return nil
 }
 
+// sendVerificationAsync emails a verification link in the background, so
+// neither the caller's latency nor a slow notification service reveal
+// anything
+func (s *userService) sendVerificationAsync(ctx context.Context, user *domain.User) {
+	userID, name, email := user.ID, user.Name, user.Email
+	go func() {
+		ctx := context.WithoutCancel(ctx)
+		token, err := s.verifier.token(purposeVerifyEmail, userID, email)
+		if err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to create verification token", "user_id", userID)
+			return
+		}
+		err = s.notifier.Send(ctx, notify.Message{
+			To:       email,
+			Template: "verify_email",
+			Subject:  "Verify your email address",
+			Data: map[string]any{
+				"name":       name,
+				"link":       s.verifier.link(token),
+				"expires_in": s.verifier.config.TTL.String(),
+			},
+		})
+		if err != nil {
+			s.logger.ErrorWithStack(ctx, err, "Failed to send verification email", "user_id", userID)
+			return
+		}
+		s.logger.Info(ctx, "Verification email sent", "user_id", userID)
+	}()
+}
+
+// loginResponse describes user for a gateway session. Memberships that
+// cannot be read are left out, which only narrows what the session may do.
+func (s *userService) loginResponse(ctx context.Context, user *domain.User) *dto.LoginResponse {
+	response := &dto.LoginResponse{
+		ID:    user.ID,
+		Name:  user.Name,
+		Email: user.Email,
+		Role:  user.Role,
+	}
+
+	memberships, err := s.groups.ListByUser(ctx, user.ID)
+	if err != nil {
+		s.logger.ErrorWithStack(ctx, err, "Failed to load group memberships", "user_id", user.ID)
+		return response
+	}
+	for _, membership := range memberships {
+		response.Groups = append(response.Groups, rbac.Membership{
+			GroupID: membership.ID,
+			Slug:    membership.Slug,
+			Role:    membership.Role,
+		})
+	}
+	return response
+}
+
 // Helper method to convert domain.User to dto.UserResponse
 func (s *userService) toUserResponse(user *domain.User) dto.UserResponse {
 	return dto.UserResponse{
-		ID:            user.ID,
-		PublicID:      user.PublicID,
-		Name:          user.Name,
-		Email:         user.Email,
-		EmailVerified: user.EmailVerified,
-		Image:         user.Image,
-		Role:          user.Role,
-		CreatedAt:     user.CreatedAt,
-		UpdatedAt:     user.UpdatedAt,
+		ID:              user.ID,
+		PublicID:        user.PublicID,
+		Name:            user.Name,
+		Email:           user.Email,
+		EmailVerified:   user.EmailVerified,
+		PendingEmail:    user.PendingEmail,
+		Image:           user.Image,
+		Role:            user.Role,
+		IsActive:        user.IsActive,
+		DeactivatedAt:   user.DeactivatedAt,
+		PasswordResetAt: user.PasswordResetAt,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+		Version:         user.Version,
 	}
 }
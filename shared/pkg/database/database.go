@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/metrics"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
 )
 
 type DatabaseConfig struct {
@@ -20,6 +23,19 @@ type DatabaseConfig struct {
 	MaxOpenConns    int           `json:"max_open_conns"`
 	ConnMaxLifetime time.Duration `json:"conn_max_lifetime"`
 	ConnMaxIdleTime time.Duration `json:"conn_max_idle_time"`
+	// ConnectTimeout is how long to keep trying while the database is not
+	// reachable yet, such as while its container starts; 0 tries once.
+	// Attempts back off exponentially from ConnectBackoff, 1s if unset, to
+	// ConnectMaxBackoff, 10s if unset.
+	ConnectTimeout    time.Duration `json:"connect_timeout"`
+	ConnectBackoff    time.Duration `json:"connect_backoff"`
+	ConnectMaxBackoff time.Duration `json:"connect_max_backoff"`
+	// LogLevel is how much SQL is logged: "silent", "error" for failed
+	// queries, "warn" (the default) for slow queries too, or "info" for
+	// every query. Queries over SlowQueryThreshold, 200ms if unset, are
+	// slow; a negative threshold turns slow query logging off.
+	LogLevel           string        `json:"log_level"`
+	SlowQueryThreshold time.Duration `json:"slow_query_threshold"`
 }
 
 func NewDatabaseConnection(config DatabaseConfig) (*gorm.DB, error) {
@@ -32,15 +48,29 @@ func NewDatabaseConnection(config DatabaseConfig) (*gorm.DB, error) {
 		config.DBNAME,
 	)
 
+	sqlLog, err := newSQLLogger(config.LogLevel, config.SlowQueryThreshold)
+	if err != nil {
+		return nil, err
+	}
+
 	gormConfig := &gorm.Config{
 		PrepareStmt:                              true,
 		DisableForeignKeyConstraintWhenMigrating: true,
 		SkipDefaultTransaction:                   true,
+		// connect logs failed attempts itself
+		Logger: gormlogger.Discard,
 	}
 
-	db, err := gorm.Open(mysql.Open(dsn), gormConfig)
+	db, err := connect(config, dsn, gormConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+		return nil, err
+	}
+	db.Logger = sqlLog
+
+	// Trace queries as children of the request span and record their
+	// metrics with the rest of the service's
+	if err := db.Use(newTelemetryPlugin(metrics.Default())); err != nil {
+		return nil, fmt.Errorf("failed to register telemetry plugin: %w", err)
 	}
 
 	sqlDB, err := db.DB()
@@ -73,11 +103,58 @@ func NewDatabaseConnection(config DatabaseConfig) (*gorm.DB, error) {
 	sqlDB.SetConnMaxLifetime(connMaxLifetime)
 	sqlDB.SetConnMaxIdleTime(connMaxIdleTime)
 
-	// Test the connection with timeout
+	return db, nil
+}
+
+// connect opens the database, retrying for up to config.ConnectTimeout
+func connect(config DatabaseConfig, dsn string, gormConfig *gorm.Config) (*gorm.DB, error) {
+	backoff := config.ConnectBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	maxBackoff := config.ConnectMaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 10 * time.Second
+	}
+	deadline := time.Now().Add(config.ConnectTimeout)
+
+	for attempt := 1; ; attempt++ {
+		db, err := open(dsn, gormConfig)
+		if err == nil {
+			if attempt > 1 {
+				logger.InfoMsg("Database is reachable", "attempts", attempt)
+			}
+			return db, nil
+		}
+
+		wait := min(backoff, time.Until(deadline))
+		if wait <= 0 {
+			if attempt > 1 {
+				return nil, fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+			}
+			return nil, err
+		}
+		logger.WarnMsg("Database is not reachable yet", "error", err, "attempt", attempt, "retry_in", wait.String())
+		time.Sleep(wait)
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// open connects to the database once, making sure it answers
+func open(dsn string, gormConfig *gorm.Config) (*gorm.DB, error) {
+	db, err := gorm.Open(mysql.Open(dsn), gormConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get underlying sql.DB: %w", err)
+	}
 	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
-
 	return db, nil
 }
 
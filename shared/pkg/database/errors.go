@@ -0,0 +1,78 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL error numbers for constraint violations
+const (
+	mysqlDuplicateEntry     = 1062
+	mysqlColumnCannotBeNull = 1048
+	mysqlRowIsReferenced    = 1451
+	mysqlNoReferencedRow    = 1452
+	mysqlCheckViolated      = 3819
+)
+
+// duplicateEntryMessage matches "Duplicate entry 'a@b.c' for key
+// 'tbl_users.idx_tbl_users_email'"; MySQL 5.7 leaves out the table
+var duplicateEntryMessage = regexp.MustCompile(`^Duplicate entry '(.*)' for key '(?:(\w+)\.)?(\w+)'$`)
+
+// TranslateError turns MySQL constraint violations into AppErrors answered
+// with 409: a duplicate key into a DUPLICATE_ENTRY naming the field, and
+// other violations into a DATABASE_CONSTRAINT_ERROR. Repositories wrap
+// their writes with it, so a race past an existence check still gets a
+// proper API error. Other errors are returned unchanged.
+func TranslateError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return err
+	}
+
+	switch mysqlErr.Number {
+	case mysqlDuplicateEntry:
+		value, field := "", "value"
+		if match := duplicateEntryMessage.FindStringSubmatch(mysqlErr.Message); match != nil {
+			value, field = match[1], indexField(match[2], match[3])
+		}
+		appErr := appErrors.NewDuplicateEntryError(fmt.Sprintf("%s already exists", field), field, value)
+		appErr.Cause = err
+		return appErr
+	case mysqlColumnCannotBeNull, mysqlRowIsReferenced, mysqlNoReferencedRow, mysqlCheckViolated:
+		return appErrors.NewDatabaseConstraintError("The change violates a data constraint", constraintName(mysqlErr.Message), err)
+	}
+	return err
+}
+
+// indexField names the field of a unique index, taking idx_tbl_users_email
+// to email as GORM names indexes after the table and column
+func indexField(table, index string) string {
+	if table != "" {
+		if field, ok := strings.CutPrefix(index, "idx_"+table+"_"); ok {
+			return field
+		}
+	}
+	return strings.TrimPrefix(index, "idx_")
+}
+
+// constraintName is the constraint, or for NOT NULL the column, a MySQL
+// error message is about
+func constraintName(message string) string {
+	for _, pattern := range constraintPatterns {
+		if match := pattern.FindStringSubmatch(message); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+var constraintPatterns = []*regexp.Regexp{
+	regexp.MustCompile("CONSTRAINT `(\\w+)`"),
+	regexp.MustCompile(`^Check constraint '(\w+)'`),
+	regexp.MustCompile(`^Column '(\w+)' cannot be null`),
+}
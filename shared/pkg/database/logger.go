@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// defaultSlowQueryThreshold is the duration past which queries are logged
+// as slow
+const defaultSlowQueryThreshold = 200 * time.Millisecond
+
+// logLevels maps DatabaseConfig.LogLevel to GORM's levels, from quietest
+var logLevels = map[string]gormlogger.LogLevel{
+	"silent": gormlogger.Silent,
+	"error":  gormlogger.Error,
+	"warn":   gormlogger.Warn,
+	"info":   gormlogger.Info,
+}
+
+// sqlLogger logs GORM's messages and queries with the shared logger, as the
+// "database" component, along with the request ID of the query's context.
+// Failed queries are logged at "error", slow ones at "warn", and all of them
+// at "info". Queries are logged without their parameters, which may hold
+// personal data.
+type sqlLogger struct {
+	log           *logger.Logger
+	level         gormlogger.LogLevel
+	slowThreshold time.Duration
+}
+
+// newSQLLogger logs at level, "warn" if empty, flagging queries slower
+// than slowThreshold, defaultSlowQueryThreshold if 0 and never if negative
+func newSQLLogger(level string, slowThreshold time.Duration) (*sqlLogger, error) {
+	if level == "" {
+		level = "warn"
+	}
+	gormLevel, ok := logLevels[level]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database log level: %s", level)
+	}
+	if slowThreshold == 0 {
+		slowThreshold = defaultSlowQueryThreshold
+	}
+	return &sqlLogger{
+		log:           logger.Named("database"),
+		level:         gormLevel,
+		slowThreshold: slowThreshold,
+	}, nil
+}
+
+func (l *sqlLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	changed := *l
+	changed.level = level
+	return &changed
+}
+
+func (l *sqlLogger) Info(ctx context.Context, msg string, data ...any) {
+	if l.level >= gormlogger.Info {
+		l.log.Info(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+func (l *sqlLogger) Warn(ctx context.Context, msg string, data ...any) {
+	if l.level >= gormlogger.Warn {
+		l.log.Warn(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+func (l *sqlLogger) Error(ctx context.Context, msg string, data ...any) {
+	if l.level >= gormlogger.Error {
+		l.log.Error(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+func (l *sqlLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= gormlogger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	switch {
+	// Finding no record is an answer rather than a failure
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= gormlogger.Error:
+		sql, rows := fc()
+		l.log.Error(ctx, "Database query failed", "error", err, "sql", sql, "rows", rows, "duration", elapsed.String())
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= gormlogger.Warn:
+		sql, rows := fc()
+		l.log.Warn(ctx, "Slow database query", "sql", sql, "rows", rows, "duration", elapsed.String(), "threshold", l.slowThreshold.String())
+	case l.level >= gormlogger.Info:
+		sql, rows := fc()
+		l.log.Info(ctx, "Database query", "sql", sql, "rows", rows, "duration", elapsed.String())
+	}
+}
+
+// ParamsFilter leaves the parameters out of logged queries
+func (l *sqlLogger) ParamsFilter(ctx context.Context, sql string, params ...any) (string, []any) {
+	return sql, nil
+}
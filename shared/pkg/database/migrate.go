@@ -0,0 +1,335 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+)
+
+// migrationsTable records the schema version in one row, in the layout
+// golang-migrate uses, so its CLI can take over an existing database
+const migrationsTable = "schema_migrations"
+
+// migrationLockTimeout bounds the wait for another instance's migrations
+const migrationLockTimeout = 60 * time.Second
+
+var (
+	// ErrSchemaOutOfDate is returned by Check when migrations are pending
+	ErrSchemaOutOfDate = errors.New("database schema is out of date")
+	// ErrSchemaDirty is returned when a migration failed part way. The
+	// schema has to be repaired by hand and the version set with Force.
+	ErrSchemaDirty = errors.New("database schema is dirty after a failed migration")
+	// ErrSchemaTooNew is returned by Check when the database has migrations
+	// this build does not know, such as after a rollback of the service
+	ErrSchemaTooNew = errors.New("database schema is newer than this build")
+)
+
+// migrationFile matches golang-migrate file names: 0001_create_users.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one numbered schema change
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies the SQL migrations of a service to a MySQL database.
+// Every migration needs an up file; a missing down file makes it
+// irreversible.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator reads the migrations at the root of files, typically an
+// embed.FS, and checks that their versions are unique
+func NewMigrator(db *sql.DB, files fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", entry.Name())
+		}
+		content, err := fs.ReadFile(files, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[uint(version)]
+		if !ok {
+			migration = &Migration{Version: uint(version), Name: match[2]}
+			byVersion[uint(version)] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", migration.Name, match[2], version)
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrator := &Migrator{db: db}
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrator.migrations = append(migrator.migrations, *migration)
+	}
+	sort.Slice(migrator.migrations, func(i, j int) bool {
+		return migrator.migrations[i].Version < migrator.migrations[j].Version
+	})
+	return migrator, nil
+}
+
+// Latest returns the version of the newest migration, 0 without any
+func (m *Migrator) Latest() uint {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version the database is at, 0 before any migration,
+// and whether the last migration failed part way
+func (m *Migrator) Version(ctx context.Context) (uint, bool, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+	return m.version(ctx, conn)
+}
+
+// Check returns nil when the database is at the latest version, so a
+// service can refuse to start against a schema it does not match
+func (m *Migrator) Check(ctx context.Context) error {
+	version, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case dirty:
+		return fmt.Errorf("%w at version %d", ErrSchemaDirty, version)
+	case version < m.Latest():
+		return fmt.Errorf("%w: at version %d, expected %d", ErrSchemaOutOfDate, version, m.Latest())
+	case version > m.Latest():
+		return fmt.Errorf("%w: at version %d, expected %d", ErrSchemaTooNew, version, m.Latest())
+	}
+	return nil
+}
+
+// Up applies every pending migration in order and returns how many it
+// applied. Instances migrating at the same time wait for each other.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		version, dirty, err := m.version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w at version %d", ErrSchemaDirty, version)
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version <= version {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration, migration.Up, migration.Version); err != nil {
+				return err
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the newest steps migrations and returns how many it
+// reverted
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		version, dirty, err := m.version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w at version %d", ErrSchemaDirty, version)
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := m.migrations[i]
+			if migration.Version > version {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s cannot be reverted", migration.Version, migration.Name)
+			}
+			var previous uint
+			if i > 0 {
+				previous = m.migrations[i-1].Version
+			}
+			if err := m.apply(ctx, conn, migration, migration.Down, previous); err != nil {
+				return err
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Force records version as applied and clean without running anything,
+// for use after repairing a dirty schema by hand
+func (m *Migrator) Force(ctx context.Context, version uint) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		return m.setVersion(ctx, conn, version, false)
+	})
+}
+
+// apply runs the statements of one direction of migration and records
+// target as the new version. MySQL commits DDL implicitly, so the version
+// is marked dirty first and stays dirty if a statement fails.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, script string, target uint) error {
+	start := time.Now()
+	if err := m.setVersion(ctx, conn, migration.Version, true); err != nil {
+		return err
+	}
+	for _, statement := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+	if err := m.setVersion(ctx, conn, target, false); err != nil {
+		return err
+	}
+
+	logger.Info(ctx, "Migration applied",
+		"version", migration.Version,
+		"name", migration.Name,
+		"to_version", target,
+		"duration", time.Since(start),
+	)
+	return nil
+}
+
+// locked runs fn on one connection holding a named MySQL lock
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+
+	// The lock is scoped to the database, so services sharing a server do
+	// not wait for each other
+	var lockName string
+	var acquired sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT CONCAT('schema_migrations:', DATABASE())").Scan(&lockName)
+	if err == nil {
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, int(migrationLockTimeout.Seconds())).Scan(&acquired)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	if acquired.Int64 != 1 {
+		return errors.New("timed out waiting for another instance's migrations")
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT RELEASE_LOCK(?)", lockName)
+
+	return fn(conn)
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS `"+migrationsTable+"` (`version` bigint NOT NULL PRIMARY KEY, `dirty` boolean NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", migrationsTable, err)
+	}
+	return nil
+}
+
+func (m *Migrator) version(ctx context.Context, conn *sql.Conn) (uint, bool, error) {
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return 0, false, err
+	}
+
+	var version uint
+	var dirty bool
+	err := conn.QueryRowContext(ctx, "SELECT `version`, `dirty` FROM `"+migrationsTable+"` LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, dirty, nil
+}
+
+func (m *Migrator) setVersion(ctx context.Context, conn *sql.Conn, version uint, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM `"+migrationsTable+"`"); err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+	// Version 0 is recorded as no row, like golang-migrate does
+	if version > 0 || dirty {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO `"+migrationsTable+"` (`version`, `dirty`) VALUES (?, ?)", version, dirty); err != nil {
+			return fmt.Errorf("failed to record schema version: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// splitStatements splits a script into statements at semicolons ending a
+// line, dropping comment lines. Statements must not end a line inside a
+// string with a semicolon.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/metrics"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	telemetrySpanKey  = "telemetry:span"
+	telemetryStartKey = "telemetry:start"
+)
+
+// queryBuckets are the upper bounds, in seconds, of query durations, which
+// are mostly shorter than requests
+var queryBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// telemetryPlugin records a span for every GORM operation, as a child of
+// the span in the statement's context (use db.WithContext(ctx)), and its
+// duration, rows and errors per operation and table
+type telemetryPlugin struct {
+	duration *metrics.HistogramVec
+	rows     *metrics.CounterVec
+	errors   *metrics.CounterVec
+}
+
+func newTelemetryPlugin(registry *metrics.Registry) *telemetryPlugin {
+	return &telemetryPlugin{
+		duration: registry.Histogram("db_query_duration_seconds", "Time taken by database operations.", queryBuckets, "operation", "table"),
+		rows:     registry.Counter("db_rows_affected_total", "Number of rows returned or affected by database operations.", "operation", "table"),
+		errors:   registry.Counter("db_query_errors_total", "Number of database operations that failed.", "operation", "table"),
+	}
+}
+
+func (*telemetryPlugin) Name() string {
+	return "telemetry"
+}
+
+func (p *telemetryPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("telemetry:before_create", beforeOperation("create")),
+		callbacks.Create().After("gorm:create").Register("telemetry:after_create", p.afterOperation("create")),
+		callbacks.Query().Before("gorm:query").Register("telemetry:before_query", beforeOperation("query")),
+		callbacks.Query().After("gorm:query").Register("telemetry:after_query", p.afterOperation("query")),
+		callbacks.Update().Before("gorm:update").Register("telemetry:before_update", beforeOperation("update")),
+		callbacks.Update().After("gorm:update").Register("telemetry:after_update", p.afterOperation("update")),
+		callbacks.Delete().Before("gorm:delete").Register("telemetry:before_delete", beforeOperation("delete")),
+		callbacks.Delete().After("gorm:delete").Register("telemetry:after_delete", p.afterOperation("delete")),
+		callbacks.Row().Before("gorm:row").Register("telemetry:before_row", beforeOperation("row")),
+		callbacks.Row().After("gorm:row").Register("telemetry:after_row", p.afterOperation("row")),
+		callbacks.Raw().Before("gorm:raw").Register("telemetry:before_raw", beforeOperation("raw")),
+		callbacks.Raw().After("gorm:raw").Register("telemetry:after_raw", p.afterOperation("raw")),
+	)
+}
+
+func beforeOperation(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		startSpan(tx, operation)
+		tx.InstanceSet(telemetryStartKey, time.Now())
+	}
+}
+
+func (p *telemetryPlugin) afterOperation(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		endSpan(tx)
+		p.observe(tx, operation)
+	}
+}
+
+func startSpan(tx *gorm.DB, operation string) {
+	ctx, span := tracing.Tracer().Start(tx.Statement.Context, "db "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mysql"),
+			attribute.String("db.operation.name", operation),
+		),
+	)
+	tx.Statement.Context = ctx
+	tx.InstanceSet(telemetrySpanKey, span)
+}
+
+func endSpan(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(telemetrySpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("db.collection.name", tx.Statement.Table),
+		attribute.String("db.query.text", tx.Statement.SQL.String()),
+		attribute.Int64("db.response.returned_rows", tx.RowsAffected),
+	)
+	if failed(tx) {
+		tracing.RecordError(span, tx.Error)
+	}
+}
+
+// observe records the duration, rows and outcome of the operation. Raw
+// SQL has no table and is labeled with an empty one.
+func (p *telemetryPlugin) observe(tx *gorm.DB, operation string) {
+	value, ok := tx.InstanceGet(telemetryStartKey)
+	if !ok {
+		return
+	}
+	table := tx.Statement.Table
+
+	p.duration.With(operation, table).Observe(time.Since(value.(time.Time)).Seconds())
+	if tx.RowsAffected > 0 {
+		p.rows.With(operation, table).Add(float64(tx.RowsAffected))
+	}
+	if failed(tx) {
+		p.errors.With(operation, table).Inc()
+	}
+}
+
+// failed reports whether the operation failed; finding no record is an
+// answer rather than a failure
+func failed(tx *gorm.DB) bool {
+	return tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound)
+}
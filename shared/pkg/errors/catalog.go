@@ -0,0 +1,231 @@
+package errors
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Catalog holds translations of the error messages per locale, one template
+// per error code. Templates name parameters in braces, as in
+// "{field} sudah digunakan", and take them from the Data of the AppError;
+// parameters missing from Data stay as written. The codes themselves are
+// never translated, so clients can keep branching on them.
+//
+// Errors in the default locale keep the message they were created with,
+// which is more specific than a template per code can be.
+type Catalog struct {
+	mu            sync.RWMutex
+	defaultLocale string
+	templates     map[string]map[string]string
+}
+
+// NewCatalog returns an empty catalog whose messages are written in
+// defaultLocale
+func NewCatalog(defaultLocale string) *Catalog {
+	return &Catalog{
+		defaultLocale: normalizeLocale(defaultLocale),
+		templates:     make(map[string]map[string]string),
+	}
+}
+
+// Add sets the templates of a locale, keyed by error code, keeping the
+// templates of codes not listed
+func (c *Catalog) Add(locale string, templates map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	locale = normalizeLocale(locale)
+	if c.templates[locale] == nil {
+		c.templates[locale] = make(map[string]string, len(templates))
+	}
+	for code, template := range templates {
+		c.templates[locale][code] = template
+	}
+}
+
+// DefaultLocale returns the locale the messages are written in
+func (c *Catalog) DefaultLocale() string {
+	return c.defaultLocale
+}
+
+// Locales returns the default locale and the locales with templates
+func (c *Catalog) Locales() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	locales := []string{c.defaultLocale}
+	for locale := range c.templates {
+		if locale != c.defaultLocale {
+			locales = append(locales, locale)
+		}
+	}
+	sort.Strings(locales[1:])
+	return locales
+}
+
+// Negotiate picks the locale for an Accept-Language header, such as
+// "id-ID,id;q=0.9,en;q=0.8": the most preferred language the catalog has,
+// matched exactly or by its primary language, else the default locale
+func (c *Catalog) Negotiate(acceptLanguage string) string {
+	type preference struct {
+		tag     string
+		quality float64
+	}
+
+	var preferences []preference
+	for _, entry := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		if tag == "" {
+			continue
+		}
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality > 0 {
+			preferences = append(preferences, preference{tag: normalizeLocale(tag), quality: quality})
+		}
+	}
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].quality > preferences[j].quality
+	})
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, preference := range preferences {
+		if preference.tag == "*" {
+			break
+		}
+		primary, _, _ := strings.Cut(preference.tag, "-")
+		for _, candidate := range []string{preference.tag, primary} {
+			if candidate == c.defaultLocale {
+				return candidate
+			}
+			if _, ok := c.templates[candidate]; ok {
+				return candidate
+			}
+		}
+	}
+	return c.defaultLocale
+}
+
+// Message fills the template of code in locale with params, reporting
+// whether the locale has one
+func (c *Catalog) Message(locale, code string, params map[string]interface{}) (string, bool) {
+	c.mu.RLock()
+	template, ok := c.templates[normalizeLocale(locale)][code]
+	c.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+
+	if len(params) == 0 || !strings.Contains(template, "{") {
+		return template, true
+	}
+	replacements := make([]string, 0, 2*len(params))
+	for name, value := range params {
+		replacements = append(replacements, "{"+name+"}", fmt.Sprint(value))
+	}
+	return strings.NewReplacer(replacements...).Replace(template), true
+}
+
+// Localize returns err with its message in locale, or err itself when the
+// locale is the default one or has no template for the code
+func (c *Catalog) Localize(err *AppError, locale string) *AppError {
+	if normalizeLocale(locale) == c.defaultLocale {
+		return err
+	}
+	message, ok := c.Message(locale, err.Code, err.Data)
+	if !ok {
+		return err
+	}
+	localized := *err
+	localized.Message = message
+	return &localized
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// LocalizeWriter makes WriteErrorResponse translate the errors written to w
+// into locale. It is installed by middleware.Localize.
+func LocalizeWriter(w http.ResponseWriter, catalog *Catalog, locale string) http.ResponseWriter {
+	return &localizedWriter{ResponseWriter: w, catalog: catalog, locale: locale}
+}
+
+type localizedWriter struct {
+	http.ResponseWriter
+	catalog *Catalog
+	locale  string
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (lw *localizedWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// localize translates err for the locale of w, if a writer in its chain
+// carries one
+func localize(w http.ResponseWriter, err *AppError) (*AppError, string) {
+	if lw, ok := findWriter[*localizedWriter](w); ok {
+		return lw.catalog.Localize(err, lw.locale), lw.locale
+	}
+	return err, ""
+}
+
+var (
+	defaultCatalog     *Catalog
+	defaultCatalogOnce sync.Once
+)
+
+// DefaultCatalog returns the catalog of the services: messages written in
+// English with Indonesian translations. Services may Add more locales at
+// startup.
+func DefaultCatalog() *Catalog {
+	defaultCatalogOnce.Do(func() {
+		defaultCatalog = NewCatalog("en")
+		defaultCatalog.Add("id", map[string]string{
+			CodeBadRequest:          "Permintaan tidak valid",
+			CodeUnauthorized:        "Autentikasi diperlukan",
+			CodeForbidden:           "Anda tidak memiliki akses",
+			CodeNotFound:            "Data tidak ditemukan",
+			CodeMethodNotAllowed:    "Metode tidak diizinkan",
+			CodeConflict:            "Data bentrok dengan data yang sudah ada",
+			CodeUnprocessableEntity: "Permintaan tidak dapat diproses",
+			CodeTooManyRequests:     "Terlalu banyak permintaan, coba lagi nanti",
+			CodeRequestTimeout:      "Waktu permintaan habis",
+			CodePayloadTooLarge:     "Ukuran permintaan terlalu besar",
+			CodeUnsupportedMedia:    "Jenis konten tidak didukung",
+
+			CodeInternalServer:     "Terjadi kesalahan pada server",
+			CodeNotImplemented:     "Fitur belum tersedia",
+			CodeBadGateway:         "Layanan hulu tidak merespons dengan benar",
+			CodeServiceUnavailable: "Layanan sedang tidak tersedia",
+			CodeGatewayTimeout:     "Layanan hulu tidak merespons tepat waktu",
+
+			CodeValidationFailed:   "Validasi gagal",
+			CodeDuplicateEntry:     "{field} sudah digunakan",
+			CodeInsufficientFunds:  "Saldo tidak cukup: dibutuhkan {required}, tersedia {available}",
+			CodeExpiredToken:       "Token sudah kedaluwarsa",
+			CodeInvalidCredentials: "Email atau kata sandi salah",
+
+			CodeDatabaseConnection: "Terjadi kesalahan pada server",
+			CodeDatabaseQuery:      "Terjadi kesalahan pada server",
+			CodeDatabaseConstraint: "Data melanggar batasan {constraint}",
+
+			CodeExternalService: "Layanan {service} sedang bermasalah",
+			CodePaymentFailed:   "Pembayaran gagal",
+			CodeEmailFailed:     "Email gagal dikirim",
+		})
+	})
+	return defaultCatalog
+}
@@ -0,0 +1,138 @@
+package errors
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Envelope is the shape of the responses written by this package
+type Envelope string
+
+const (
+	// EnvelopeWrapped wraps payloads in {status, message, data, meta}, the
+	// default
+	EnvelopeWrapped Envelope = "wrapped"
+	// EnvelopeRaw writes payloads as they are: the data of a success,
+	// {code, message, data} for an error, and the meta of a page as
+	// headers. Successes without data answer 204.
+	EnvelopeRaw Envelope = "raw"
+)
+
+// EnvelopeHeader lets a client pick the Envelope of a response
+const EnvelopeHeader = "X-Envelope"
+
+// Headers carrying the Meta of a raw response
+const (
+	HeaderPage       = "X-Page"
+	HeaderLimit      = "X-Limit"
+	HeaderTotalCount = "X-Total-Count"
+	HeaderTotalPages = "X-Total-Pages"
+	HeaderNextCursor = "X-Next-Cursor"
+)
+
+// MetaHeaders lists the headers carrying the Meta of a raw response
+var MetaHeaders = []string{HeaderPage, HeaderLimit, HeaderTotalCount, HeaderTotalPages, HeaderNextCursor}
+
+// ParseEnvelope reads an Envelope, accepting "none" for EnvelopeRaw
+func ParseEnvelope(value string) (Envelope, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case string(EnvelopeWrapped):
+		return EnvelopeWrapped, true
+	case string(EnvelopeRaw), "none":
+		return EnvelopeRaw, true
+	default:
+		return "", false
+	}
+}
+
+// EnvelopeWriter makes the responses written to w take envelope. It is
+// installed by middleware.Envelope.
+func EnvelopeWriter(w http.ResponseWriter, envelope Envelope) http.ResponseWriter {
+	return &envelopeWriter{ResponseWriter: w, envelope: envelope}
+}
+
+type envelopeWriter struct {
+	http.ResponseWriter
+	envelope Envelope
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (ew *envelopeWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// findWriter finds the writer of type T in the Unwrap chain of w
+func findWriter[T http.ResponseWriter](w http.ResponseWriter) (T, bool) {
+	for w != nil {
+		if found, ok := w.(T); ok {
+			return found, true
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = unwrapper.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// envelopeOf returns the Envelope set for w, EnvelopeWrapped by default
+func envelopeOf(w http.ResponseWriter) Envelope {
+	if ew, ok := findWriter[*envelopeWriter](w); ok {
+		return ew.envelope
+	}
+	return EnvelopeWrapped
+}
+
+// writeResponse writes every response of this package in the envelope set
+// for w
+func writeResponse(w http.ResponseWriter, statusCode int, response APIResponse) {
+	if envelopeOf(w) == EnvelopeWrapped {
+		writeJSON(w, statusCode, response)
+		return
+	}
+
+	if response.Status == StatusError {
+		writeJSON(w, statusCode, &AppError{Code: response.Error, Message: response.Message, Data: errorData(response.Data)})
+		return
+	}
+	if meta, ok := response.Meta.(*Meta); ok && meta != nil {
+		setMetaHeaders(w.Header(), meta)
+	}
+	if response.Data == nil {
+		if statusCode == http.StatusOK {
+			statusCode = http.StatusNoContent
+		}
+		w.WriteHeader(statusCode)
+		return
+	}
+	writeJSON(w, statusCode, response.Data)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
+
+func errorData(data interface{}) map[string]interface{} {
+	if data, ok := data.(map[string]interface{}); ok {
+		return data
+	}
+	return nil
+}
+
+func setMetaHeaders(header http.Header, meta *Meta) {
+	if meta.Page > 0 {
+		header.Set(HeaderPage, strconv.Itoa(meta.Page))
+	}
+	header.Set(HeaderLimit, strconv.Itoa(meta.Limit))
+	header.Set(HeaderTotalCount, strconv.Itoa(meta.Total))
+	header.Set(HeaderTotalPages, strconv.Itoa(meta.TotalPage))
+	if meta.NextCursor != "" {
+		header.Set(HeaderNextCursor, meta.NextCursor)
+	}
+}
@@ -1,9 +1,12 @@
 package errors
 
 import (
-	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // AppError represents application error
@@ -19,6 +22,37 @@ func (e *AppError) Error() string {
 	return fmt.Sprintf("[%s] %s", e.Code, e.Message)
 }
 
+// Unwrap returns the cause, so errors.Is and errors.As see through it
+func (e *AppError) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches the sentinel errors of the AppError's code, so that
+// errors.Is(NewNotFoundError(...), ErrNotFound) holds
+func (e *AppError) Is(target error) bool {
+	sentinel, ok := codeSentinels[e.Code]
+	return ok && target == sentinel
+}
+
+// Sentinel errors for the failures callers commonly branch on. Their
+// messages are meant to be wrapped with the subject, as in
+// fmt.Errorf("user %w", ErrNotFound), and are tested with errors.Is or the
+// Is helpers below rather than by message.
+var (
+	ErrNotFound           = stderrors.New("not found")
+	ErrConflict           = stderrors.New("already exists")
+	ErrInvalidCredentials = stderrors.New("invalid credentials")
+)
+
+// codeSentinels maps the AppError codes to the sentinel errors they match
+var codeSentinels = map[string]error{
+	CodeNotFound:           ErrNotFound,
+	CodeConflict:           ErrConflict,
+	CodeDuplicateEntry:     ErrConflict,
+	CodeDatabaseConstraint: ErrConflict,
+	CodeInvalidCredentials: ErrInvalidCredentials,
+}
+
 // APIResponse represents standard API response format
 type APIResponse struct {
 	Status  string      `json:"status"`
@@ -28,12 +62,14 @@ type APIResponse struct {
 	Meta    interface{} `json:"meta,omitempty"`
 }
 
-// Meta represents pagination metadata
+// Meta represents pagination metadata. NextCursor is set by endpoints
+// with cursor pagination while there are more results.
 type Meta struct {
-	Page      int `json:"page"`
-	Limit     int `json:"limit"`
-	Total     int `json:"total"`
-	TotalPage int `json:"total_page"`
+	Page       int    `json:"page"`
+	Limit      int    `json:"limit"`
+	Total      int    `json:"total"`
+	TotalPage  int    `json:"total_page"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // ValidationError represents field validation error
@@ -65,6 +101,8 @@ const (
 	CodeUnprocessableEntity = "UNPROCESSABLE_ENTITY"
 	CodeTooManyRequests     = "TOO_MANY_REQUESTS"
 	CodeRequestTimeout      = "REQUEST_TIMEOUT"
+	CodePayloadTooLarge     = "PAYLOAD_TOO_LARGE"
+	CodeUnsupportedMedia    = "UNSUPPORTED_MEDIA_TYPE"
 
 	// Server errors (5xx)
 	CodeInternalServer     = "INTERNAL_SERVER_ERROR"
@@ -173,6 +211,68 @@ func NewTooManyRequestsError(message string, cause error) *AppError {
 	}
 }
 
+// RateLimit describes the limit of a client, for 429 responses and for the
+// headers of the responses it still gets
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	// Reset is when the client gets another request
+	Reset time.Time
+}
+
+// ResetAfter returns the whole seconds until Reset, at least 1 when it is
+// still ahead
+func (l RateLimit) ResetAfter() int {
+	seconds := int(math.Ceil(time.Until(l.Reset).Seconds()))
+	return max(seconds, 0)
+}
+
+// RateLimitHeaders lists the headers set by WriteRateLimitResponse. They
+// describe the limit at the time of one response, so caches and replays
+// must not repeat them.
+var RateLimitHeaders = []string{
+	"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+	"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset",
+	"Retry-After",
+}
+
+// SetHeaders sets the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers, in seconds, with their X-RateLimit-* forerunners
+// for older clients
+func (l RateLimit) SetHeaders(header http.Header) {
+	limit, remaining, reset := strconv.Itoa(l.Limit), strconv.Itoa(l.Remaining), strconv.Itoa(l.ResetAfter())
+	header.Set("RateLimit-Limit", limit)
+	header.Set("RateLimit-Remaining", remaining)
+	header.Set("RateLimit-Reset", reset)
+	header.Set("X-RateLimit-Limit", limit)
+	header.Set("X-RateLimit-Remaining", remaining)
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(l.Reset.Unix(), 10))
+}
+
+// NewRateLimitError reports an exhausted rate limit, with the limit in Data
+// so clients need not parse headers
+func NewRateLimitError(message string, limit RateLimit) *AppError {
+	return &AppError{
+		Code:       CodeTooManyRequests,
+		Message:    message,
+		StatusCode: http.StatusTooManyRequests,
+		Data: map[string]interface{}{
+			"limit":       limit.Limit,
+			"remaining":   limit.Remaining,
+			"reset":       limit.Reset.UTC().Format(time.RFC3339),
+			"retry_after": limit.ResetAfter(),
+		},
+	}
+}
+
+// WriteRateLimitResponse answers 429 with the headers and Data of limit and
+// a Retry-After for when it resets
+func WriteRateLimitResponse(w http.ResponseWriter, message string, limit RateLimit) {
+	limit.SetHeaders(w.Header())
+	w.Header().Set("Retry-After", strconv.Itoa(limit.ResetAfter()))
+	WriteErrorResponse(w, NewRateLimitError(message, limit))
+}
+
 func NewRequestTimeoutError(message string, cause error) *AppError {
 	return &AppError{
 		Code:       CodeRequestTimeout,
@@ -182,6 +282,24 @@ func NewRequestTimeoutError(message string, cause error) *AppError {
 	}
 }
 
+func NewPayloadTooLargeError(message string, cause error) *AppError {
+	return &AppError{
+		Code:       CodePayloadTooLarge,
+		Message:    message,
+		StatusCode: http.StatusRequestEntityTooLarge,
+		Cause:      cause,
+	}
+}
+
+func NewUnsupportedMediaTypeError(message string, cause error) *AppError {
+	return &AppError{
+		Code:       CodeUnsupportedMedia,
+		Message:    message,
+		StatusCode: http.StatusUnsupportedMediaType,
+		Cause:      cause,
+	}
+}
+
 // 5xx Server Errors
 func NewInternalServerError(message string, cause error) *AppError {
 	return &AppError{
@@ -243,15 +361,18 @@ func NewValidationError(message string, validationErrors ValidationErrors) *AppE
 	}
 }
 
+// NewDuplicateEntryError reports that field is taken; an empty value is
+// left out of Data
 func NewDuplicateEntryError(message string, field string, value string) *AppError {
+	data := map[string]interface{}{"field": field}
+	if value != "" {
+		data["value"] = value
+	}
 	return &AppError{
 		Code:       CodeDuplicateEntry,
 		Message:    message,
 		StatusCode: http.StatusConflict,
-		Data: map[string]interface{}{
-			"field": field,
-			"value": value,
-		},
+		Data:       data,
 	}
 }
 
@@ -308,7 +429,7 @@ func NewDatabaseConstraintError(message string, constraint string, cause error)
 	return &AppError{
 		Code:       CodeDatabaseConstraint,
 		Message:    message,
-		StatusCode: http.StatusBadRequest,
+		StatusCode: http.StatusConflict,
 		Data: map[string]interface{}{
 			"constraint": constraint,
 		},
@@ -348,7 +469,15 @@ func NewEmailFailedError(message string, cause error) *AppError {
 }
 
 // Response helper functions
+
+// WriteErrorResponse writes err as JSON. Behind middleware.Localize the
+// message is translated into the client's language; the code is not.
 func WriteErrorResponse(w http.ResponseWriter, err *AppError) {
+	err, locale := localize(w, err)
+	if locale != "" {
+		w.Header().Set("Content-Language", locale)
+	}
+
 	response := APIResponse{
 		Status:  StatusError,
 		Message: err.Message,
@@ -360,9 +489,7 @@ func WriteErrorResponse(w http.ResponseWriter, err *AppError) {
 		response.Data = err.Data
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(err.StatusCode)
-	json.NewEncoder(w).Encode(response)
+	writeResponse(w, err.StatusCode, response)
 }
 
 func WriteValidationErrorResponse(w http.ResponseWriter, validationErrors ValidationErrors) {
@@ -377,9 +504,7 @@ func WriteSuccessResponse(w http.ResponseWriter, statusCode int, message string,
 		Data:    data,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(response)
+	writeResponse(w, statusCode, response)
 }
 
 func WriteSuccessResponseWithMeta(w http.ResponseWriter, statusCode int, message string, data interface{}, meta *Meta) {
@@ -390,9 +515,7 @@ func WriteSuccessResponseWithMeta(w http.ResponseWriter, statusCode int, message
 		Meta:    meta,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(response)
+	writeResponse(w, statusCode, response)
 }
 
 func WritePaginatedResponse(w http.ResponseWriter, message string, data interface{}, page, limit, total int) {
@@ -410,15 +533,36 @@ func WritePaginatedResponse(w http.ResponseWriter, message string, data interfac
 
 // Error checking helpers
 func IsAppError(err error) bool {
-	_, ok := err.(*AppError)
+	_, ok := GetAppError(err)
 	return ok
 }
 
+// GetAppError finds the AppError in err's chain, so it also recognizes one
+// wrapped with fmt.Errorf("...: %w", err)
 func GetAppError(err error) (*AppError, bool) {
-	appErr, ok := err.(*AppError)
+	var appErr *AppError
+	ok := stderrors.As(err, &appErr)
 	return appErr, ok
 }
 
+// IsNotFound reports whether err is or wraps ErrNotFound or a NOT_FOUND
+// AppError
+func IsNotFound(err error) bool {
+	return stderrors.Is(err, ErrNotFound)
+}
+
+// IsConflict reports whether err is or wraps ErrConflict or an AppError
+// for a conflict, duplicate entry or violated constraint
+func IsConflict(err error) bool {
+	return stderrors.Is(err, ErrConflict)
+}
+
+// IsInvalidCredentials reports whether err is or wraps
+// ErrInvalidCredentials or an INVALID_CREDENTIALS AppError
+func IsInvalidCredentials(err error) bool {
+	return stderrors.Is(err, ErrInvalidCredentials)
+}
+
 func IsClientError(err error) bool {
 	if appErr, ok := GetAppError(err); ok {
 		return appErr.StatusCode >= 400 && appErr.StatusCode < 500
@@ -433,12 +577,31 @@ func IsServerError(err error) bool {
 	return false
 }
 
-// Wrap standard errors
+// FromError returns the AppError in err's chain, or else one matching the
+// sentinel err wraps: NOT_FOUND, CONFLICT or INVALID_CREDENTIALS carrying
+// the message of err, and VALIDATION_FAILED for ValidationErrors. Other
+// errors become an INTERNAL_SERVER_ERROR that hides their message.
 func FromError(err error) *AppError {
+	if err == nil {
+		return nil
+	}
 	if appErr, ok := GetAppError(err); ok {
 		return appErr
 	}
-	return NewInternalServerError("Internal server error", err)
+
+	var validationErrors ValidationErrors
+	switch {
+	case stderrors.As(err, &validationErrors):
+		return NewValidationError("Validation failed", validationErrors)
+	case IsInvalidCredentials(err):
+		return NewInvalidCredentialsError(err.Error(), err)
+	case IsNotFound(err):
+		return NewNotFoundError(err.Error(), err)
+	case IsConflict(err):
+		return NewConflictError(err.Error(), err)
+	default:
+		return NewInternalServerError("Internal server error", err)
+	}
 }
 
 func WrapError(err error, code, message string, statusCode int) *AppError {
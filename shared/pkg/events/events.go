@@ -0,0 +1,172 @@
+// Package events publishes domain events to a message broker, so other
+// services can react to changes without calling the service that made them
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/google/uuid"
+)
+
+// User lifecycle event types, published by user-service
+const (
+	UserCreated         = "user.created"
+	UserUpdated         = "user.updated"
+	UserDeleted         = "user.deleted"
+	UserPasswordChanged = "user.password_changed"
+)
+
+// Event is the envelope every event is published in. Data depends on Type.
+type Event struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	Source        string          `json:"source"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	RequestID     string          `json:"request_id,omitempty"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// User is the data of user.created and user.updated events, the account
+// as it is after the change
+type User struct {
+	ID            uint   `json:"id"`
+	PublicID      string `json:"public_id"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+	IsActive      bool   `json:"is_active"`
+}
+
+// DeletedUser is the data of user.deleted events
+type DeletedUser struct {
+	ID       uint   `json:"id"`
+	PublicID string `json:"public_id"`
+	Email    string `json:"email"`
+}
+
+// PasswordChanged is the data of user.password_changed events. Reason is
+// "change" when the user changed it, "reset" after a reset link and
+// "import" when a bulk import set it.
+type PasswordChanged struct {
+	ID     uint   `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// Config selects the broker by the scheme of URL: nats://[user:pass@]host:4222
+// for NATS, redis://[:pass@]host:6379[/db] for Redis Streams. Without a URL
+// events are only logged, which is meant for development.
+type Config struct {
+	URL string
+	// Source names the publishing service in every event
+	Source string
+	// Prefix is prepended to event types to form the NATS subject or Redis
+	// stream, e.g. "events." publishes user.created on events.user.created
+	Prefix  string
+	Timeout time.Duration
+}
+
+// broker delivers the payload of an event on subject
+type broker interface {
+	publish(ctx context.Context, subject string, payload []byte) error
+	close() error
+}
+
+// Publisher publishes events to the configured broker
+type Publisher struct {
+	broker  broker
+	source  string
+	prefix  string
+	timeout time.Duration
+}
+
+// New creates a publisher for cfg. Brokers are connected to on first use,
+// so an unreachable broker fails publishing rather than startup.
+func New(cfg Config) (*Publisher, error) {
+	publisher := &Publisher{
+		source:  cfg.Source,
+		prefix:  cfg.Prefix,
+		timeout: cfg.Timeout,
+	}
+	if publisher.timeout <= 0 {
+		publisher.timeout = 5 * time.Second
+	}
+	if cfg.URL == "" {
+		return publisher, nil
+	}
+
+	brokerURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid events URL: %w", err)
+	}
+	switch brokerURL.Scheme {
+	case "nats":
+		publisher.broker = newNATSBroker(brokerURL, publisher.timeout)
+	case "redis", "rediss":
+		publisher.broker, err = newRedisBroker(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported events broker %q", brokerURL.Scheme)
+	}
+	return publisher, nil
+}
+
+// Configured reports whether events are delivered rather than logged
+func (p *Publisher) Configured() bool {
+	return p.broker != nil
+}
+
+// Publish wraps data in an event of eventType and delivers it. Without a
+// broker the event is only logged.
+func (p *Publisher) Publish(ctx context.Context, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event data: %w", err)
+	}
+	event := Event{
+		ID:            uuid.New().String(),
+		Type:          eventType,
+		Source:        p.source,
+		OccurredAt:    time.Now().UTC(),
+		RequestID:     logger.GetRequestID(ctx),
+		CorrelationID: logger.GetCorrelationID(ctx),
+		Data:          payload,
+	}
+
+	if p.broker == nil {
+		logger.Info(ctx, "Event not published; EVENTS_URL is not set",
+			"event_id", event.ID,
+			"type", event.Type,
+			"data", string(event.Data),
+		)
+		return nil
+	}
+
+	message, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	if err := p.broker.publish(ctx, p.prefix+eventType, message); err != nil {
+		return fmt.Errorf("failed to publish %s event: %w", eventType, err)
+	}
+	return nil
+}
+
+// Close disconnects from the broker
+func (p *Publisher) Close() error {
+	if p.broker == nil {
+		return nil
+	}
+	return p.broker.close()
+}
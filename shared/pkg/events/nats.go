@@ -0,0 +1,171 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsBroker speaks the NATS client protocol over one connection. Every
+// PUB is followed by a PING, so the PONG confirms the server accepted the
+// message. TLS is not supported.
+type natsBroker struct {
+	addr    string
+	connect []byte
+	timeout time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newNATSBroker(brokerURL *url.URL, timeout time.Duration) *natsBroker {
+	addr := brokerURL.Host
+	if brokerURL.Port() == "" {
+		addr = net.JoinHostPort(brokerURL.Hostname(), "4222")
+	}
+
+	options := map[string]any{
+		"verbose":  false,
+		"pedantic": false,
+		"lang":     "go",
+		"version":  "1.0.0",
+	}
+	if brokerURL.User != nil {
+		if password, ok := brokerURL.User.Password(); ok {
+			options["user"] = brokerURL.User.Username()
+			options["pass"] = password
+		} else {
+			options["auth_token"] = brokerURL.User.Username()
+		}
+	}
+	connect, _ := json.Marshal(options)
+
+	return &natsBroker{addr: addr, connect: connect, timeout: timeout}
+}
+
+func (b *natsBroker) publish(ctx context.Context, subject string, payload []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	reused := b.conn != nil
+	err := b.send(ctx, subject, payload)
+	if err != nil && reused && ctx.Err() == nil {
+		// The server drops connections that stayed idle too long; retry
+		// once on a new one
+		b.disconnect()
+		err = b.send(ctx, subject, payload)
+	}
+	if err != nil {
+		b.disconnect()
+	}
+	return err
+}
+
+func (b *natsBroker) send(ctx context.Context, subject string, payload []byte) error {
+	if b.conn == nil {
+		if err := b.dial(ctx); err != nil {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(b.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := b.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(b.conn, "PUB %s %d\r\n%s\r\nPING\r\n", subject, len(payload), payload); err != nil {
+		return fmt.Errorf("failed to write to NATS: %w", err)
+	}
+	return b.awaitPong()
+}
+
+// dial connects and sends CONNECT. Errors in CONNECT, such as bad
+// credentials, surface while waiting for the PONG of the first publish.
+func (b *natsBroker) dial(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: b.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", b.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(b.timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to read NATS greeting: %w", err)
+	}
+	infoJSON, ok := strings.CutPrefix(strings.TrimSpace(line), "INFO ")
+	if !ok {
+		conn.Close()
+		return errors.New("unexpected NATS greeting")
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if err := json.Unmarshal([]byte(infoJSON), &info); err != nil {
+		conn.Close()
+		return fmt.Errorf("invalid NATS greeting: %w", err)
+	}
+	if info.TLSRequired {
+		conn.Close()
+		return errors.New("NATS server requires TLS, which is not supported")
+	}
+
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", b.connect); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to write to NATS: %w", err)
+	}
+	b.conn, b.reader = conn, reader
+	return nil
+}
+
+// awaitPong reads until the server's PONG, answering its PINGs on the way
+func (b *natsBroker) awaitPong() error {
+	for {
+		line, err := b.reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read from NATS: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := b.conn.Write([]byte("PONG\r\n")); err != nil {
+				return fmt.Errorf("failed to write to NATS: %w", err)
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS error: %s", strings.Trim(strings.TrimPrefix(line, "-ERR"), " '"))
+		}
+		// +OK and INFO updates need no answer
+	}
+}
+
+func (b *natsBroker) disconnect() {
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn, b.reader = nil, nil
+	}
+}
+
+func (b *natsBroker) close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.disconnect()
+	return nil
+}
@@ -0,0 +1,42 @@
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisStreamMaxLen bounds each stream; older events are trimmed
+// approximately once consumers have had time to read them
+const redisStreamMaxLen = 100000
+
+// redisBroker appends events to a Redis stream per subject, under the
+// field "event"
+type redisBroker struct {
+	client *redis.Client
+}
+
+func newRedisBroker(rawURL string) (*redisBroker, error) {
+	options, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid events URL: %w", err)
+	}
+	client := redis.NewClient(options)
+	client.AddHook(tracing.RedisHook())
+	return &redisBroker{client: client}, nil
+}
+
+func (b *redisBroker) publish(ctx context.Context, subject string, payload []byte) error {
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: subject,
+		MaxLen: redisStreamMaxLen,
+		Approx: true,
+		Values: map[string]any{"event": payload},
+	}).Err()
+}
+
+func (b *redisBroker) close() error {
+	return b.client.Close()
+}
@@ -0,0 +1,203 @@
+// Package featureflag toggles features at runtime. Flags come from sources
+// such as environment variables, a JSON file or Redis, are refreshed in the
+// background, and are evaluated per request against the environment and the
+// user making it, so features can be rolled out without a redeploy.
+package featureflag
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+)
+
+// Flag decides where a feature is on. A flag is off unless Enabled, and
+// then on for the subjects that pass every condition set.
+type Flag struct {
+	Enabled bool `json:"enabled"`
+	// Environments limits the flag to these environments
+	Environments []string `json:"environments,omitempty"`
+	// Users are always let through once the environment matches, ahead of
+	// the other conditions
+	Users []string `json:"users,omitempty"`
+	// Attributes must all match the subject's: the subject's value of each
+	// attribute must be one of those listed, e.g. {"role": ["ADMIN"]}
+	Attributes map[string][]string `json:"attributes,omitempty"`
+	// Percentage rolls the flag out to this share (0-100) of subjects,
+	// picked by a stable hash of their ID; unset means every subject
+	Percentage *int `json:"percentage,omitempty"`
+}
+
+// Subject is who a flag is evaluated for. Anonymous subjects have no ID and
+// only see percentage rollouts once they reach 100.
+type Subject struct {
+	ID         string
+	Attributes map[string]string
+}
+
+// Source loads flags by name. Later sources given to New override earlier
+// ones flag by flag.
+type Source interface {
+	Load(ctx context.Context) (map[string]Flag, error)
+}
+
+// Config controls evaluation and refreshing
+type Config struct {
+	// Environment is matched against Flag.Environments
+	Environment string
+	// RefreshInterval is how often Run reloads the sources
+	RefreshInterval time.Duration
+}
+
+// Flags evaluates the flags loaded from its sources
+type Flags struct {
+	config  Config
+	sources []Source
+	// loaded holds the last flags loaded from each source
+	loaded []map[string]Flag
+	mutex  sync.RWMutex
+	flags  map[string]Flag
+}
+
+// New creates Flags over sources, loading them once. A source failing to
+// load is logged and skipped, so a broken source leaves its flags off.
+func New(ctx context.Context, config Config, sources ...Source) *Flags {
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = 30 * time.Second
+	}
+	f := &Flags{
+		config:  config,
+		sources: sources,
+		loaded:  make([]map[string]Flag, len(sources)),
+		flags:   make(map[string]Flag),
+	}
+	f.Refresh(ctx)
+	return f
+}
+
+// Refresh reloads every source. A source that fails keeps the flags it
+// loaded last. Refresh is not meant to be called concurrently.
+func (f *Flags) Refresh(ctx context.Context) error {
+	var failed error
+	flags := make(map[string]Flag)
+	for i, source := range f.sources {
+		loaded, err := source.Load(ctx)
+		if err != nil {
+			logger.Warn(ctx, "Failed to load feature flags", "source", fmt.Sprintf("%T", source), "error", err)
+			failed = err
+		} else {
+			f.loaded[i] = loaded
+		}
+		for name, flag := range f.loaded[i] {
+			flags[name] = flag
+		}
+	}
+
+	f.mutex.Lock()
+	f.flags = flags
+	f.mutex.Unlock()
+	return failed
+}
+
+// Run refreshes the flags every RefreshInterval until ctx is done
+func (f *Flags) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.Refresh(ctx)
+		}
+	}
+}
+
+// Enabled reports whether the flag name is on for the subject of ctx.
+// Unknown flags are off.
+func (f *Flags) Enabled(ctx context.Context, name string) bool {
+	if f == nil {
+		return false
+	}
+	subject, _ := SubjectFromContext(ctx)
+	return f.EnabledFor(name, subject)
+}
+
+// EnabledFor reports whether the flag name is on for subject
+func (f *Flags) EnabledFor(name string, subject Subject) bool {
+	if f == nil {
+		return false
+	}
+	f.mutex.RLock()
+	flag, exists := f.flags[name]
+	f.mutex.RUnlock()
+
+	return exists && flag.evaluate(name, f.config.Environment, subject)
+}
+
+// Evaluate returns every flag by name with whether it is on for the
+// subject of ctx
+func (f *Flags) Evaluate(ctx context.Context) map[string]bool {
+	if f == nil {
+		return map[string]bool{}
+	}
+	subject, _ := SubjectFromContext(ctx)
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	evaluated := make(map[string]bool, len(f.flags))
+	for name, flag := range f.flags {
+		evaluated[name] = flag.evaluate(name, f.config.Environment, subject)
+	}
+	return evaluated
+}
+
+func (flag Flag) evaluate(name, environment string, subject Subject) bool {
+	if !flag.Enabled {
+		return false
+	}
+	if len(flag.Environments) > 0 && !slices.Contains(flag.Environments, environment) {
+		return false
+	}
+	if subject.ID != "" && slices.Contains(flag.Users, subject.ID) {
+		return true
+	}
+	for attribute, values := range flag.Attributes {
+		if !slices.Contains(values, subject.Attributes[attribute]) {
+			return false
+		}
+	}
+	if flag.Percentage != nil {
+		return bucket(name, subject.ID) < *flag.Percentage
+	}
+	return true
+}
+
+// bucket places id in one of 100 buckets, differently for each flag so the
+// same users are not always first. Anonymous subjects land in the last one.
+func bucket(name, id string) int {
+	if id == "" {
+		return 99
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(name + ":" + id))
+	return int(hash.Sum32() % 100)
+}
+
+type subjectKey struct{}
+
+// WithSubject returns ctx carrying the subject flags are evaluated for
+func WithSubject(ctx context.Context, subject Subject) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// SubjectFromContext returns the subject set with WithSubject
+func SubjectFromContext(ctx context.Context) (Subject, bool) {
+	subject, ok := ctx.Value(subjectKey{}).(Subject)
+	return subject, ok
+}
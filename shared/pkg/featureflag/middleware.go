@@ -0,0 +1,30 @@
+package featureflag
+
+import (
+	"net/http"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/errors"
+)
+
+// Middleware sets the subject of each request, as found by subject, for
+// the flags evaluated while serving it
+func Middleware(subject func(r *http.Request) Subject) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := WithSubject(r.Context(), subject(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Require serves next only while the flag name is on for the request's
+// subject, answering 404 otherwise as if the route did not exist
+func (f *Flags) Require(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.Enabled(r.Context(), name) {
+			errors.WriteErrorResponse(w, errors.NewNotFoundError("Endpoint not found", nil))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,132 @@
+package featureflag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// EnvPrefix starts the environment variables read by EnvSource
+const EnvPrefix = "FEATURE_FLAG_"
+
+// EnvSource reads flags from FEATURE_FLAG_<NAME> environment variables,
+// named by the lowercased rest of the variable, e.g. FEATURE_FLAG_NEW_CHECKOUT
+// for new_checkout. A value is either a boolean, a percentage such as "25%",
+// or a Flag as JSON.
+type EnvSource struct{}
+
+func (EnvSource) Load(ctx context.Context) (map[string]Flag, error) {
+	flags := make(map[string]Flag)
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		name, found := strings.CutPrefix(key, EnvPrefix)
+		if !found || name == "" {
+			continue
+		}
+		flag, err := ParseFlag(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		flags[strings.ToLower(name)] = flag
+	}
+	return flags, nil
+}
+
+// ParseFlag reads a flag written as a boolean, a percentage such as "25%",
+// or a Flag as JSON
+func ParseFlag(value string) (Flag, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "{") {
+		var flag Flag
+		if err := json.Unmarshal([]byte(value), &flag); err != nil {
+			return Flag{}, fmt.Errorf("invalid flag: %w", err)
+		}
+		return flag, flag.validate()
+	}
+	if percent, found := strings.CutSuffix(value, "%"); found {
+		percentage, err := strconv.Atoi(percent)
+		if err != nil {
+			return Flag{}, fmt.Errorf("invalid percentage %q", value)
+		}
+		flag := Flag{Enabled: true, Percentage: &percentage}
+		return flag, flag.validate()
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return Flag{}, fmt.Errorf("invalid flag %q: want a boolean, a percentage or JSON", value)
+	}
+	return Flag{Enabled: enabled}, nil
+}
+
+func (flag Flag) validate() error {
+	if flag.Percentage != nil && (*flag.Percentage < 0 || *flag.Percentage > 100) {
+		return fmt.Errorf("percentage %d is outside 0-100", *flag.Percentage)
+	}
+	return nil
+}
+
+// FileSource reads flags from a JSON file, {"flags": {"name": {...}}}. The
+// file is read on every refresh, so edits apply without a restart.
+type FileSource struct {
+	Path string
+}
+
+func (s FileSource) Load(ctx context.Context) (map[string]Flag, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feature flags: %w", err)
+	}
+
+	var file struct {
+		Flags map[string]Flag `json:"flags"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.Path, err)
+	}
+	for name, flag := range file.Flags {
+		if err := flag.validate(); err != nil {
+			return nil, fmt.Errorf("flag %s: %w", name, err)
+		}
+	}
+	return file.Flags, nil
+}
+
+// DefaultRedisKey is the Redis hash RedisSource reads when no key is set
+const DefaultRedisKey = "feature_flags"
+
+// RedisSource reads flags from a Redis hash, one field per flag holding a
+// value in the format of ParseFlag, so flags can be flipped for every
+// instance at once:
+//
+//	HSET feature_flags new_checkout '{"enabled":true,"percentage":10}'
+type RedisSource struct {
+	Client redis.UniversalClient
+	Key    string
+}
+
+func (s RedisSource) Load(ctx context.Context) (map[string]Flag, error) {
+	key := s.Key
+	if key == "" {
+		key = DefaultRedisKey
+	}
+
+	values, err := s.Client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feature flags from Redis: %w", err)
+	}
+
+	flags := make(map[string]Flag, len(values))
+	for name, value := range values {
+		flag, err := ParseFlag(value)
+		if err != nil {
+			return nil, fmt.Errorf("flag %s: %w", name, err)
+		}
+		flags[name] = flag
+	}
+	return flags, nil
+}
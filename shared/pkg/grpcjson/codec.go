@@ -0,0 +1,65 @@
+// Package grpcjson provides a JSON codec for gRPC so services can expose
+// gRPC endpoints with plain Go structs instead of generated protobuf types,
+// and the gateway can forward JSON payloads without knowing their schema.
+package grpcjson
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// Name is the gRPC content-subtype registered by this package
+const Name = "json"
+
+// Codec marshals messages as JSON. Raw payloads (*RawMessage, *[]byte) are
+// passed through untouched.
+type Codec struct{}
+
+// RawMessage is an opaque JSON payload forwarded as-is
+type RawMessage = json.RawMessage
+
+func init() {
+	encoding.RegisterCodec(Codec{})
+}
+
+func (Codec) Name() string {
+	return Name
+}
+
+func (Codec) Marshal(v any) ([]byte, error) {
+	switch msg := v.(type) {
+	case *RawMessage:
+		if msg == nil || len(*msg) == 0 {
+			return []byte("{}"), nil
+		}
+		return *msg, nil
+	case *[]byte:
+		return *msg, nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+func (Codec) Unmarshal(data []byte, v any) error {
+	switch msg := v.(type) {
+	case *RawMessage:
+		*msg = append((*msg)[:0], data...)
+		return nil
+	case *[]byte:
+		*msg = append((*msg)[:0], data...)
+		return nil
+	default:
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("grpcjson: failed to unmarshal %T: %w", v, err)
+		}
+		return nil
+	}
+}
+
+// CallOption selects the JSON codec for a client call
+func CallOption() grpc.CallOption {
+	return grpc.CallContentSubtype(Name)
+}
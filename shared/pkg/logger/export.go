@@ -0,0 +1,435 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Export protocols
+const (
+	// ExportOTLP sends OTLP/HTTP JSON to <endpoint>/v1/logs
+	ExportOTLP = "otlp"
+	// ExportLoki sends to the Loki push API at <endpoint>/loki/api/v1/push
+	ExportLoki = "loki"
+)
+
+// ExportConfig ships records to a log backend in addition to the local
+// output. Records are queued and sent in batches by one goroutine; when the
+// backend falls behind and the queue is full, new records are dropped
+// rather than slowing down the service, and the drops are reported on
+// stderr.
+type ExportConfig struct {
+	// Protocol is ExportOTLP or ExportLoki; empty disables export
+	Protocol string `json:"protocol"`
+	// Endpoint is the base URL of the backend, such as http://loki:3100. For
+	// OTLP it defaults to OTEL_EXPORTER_OTLP_ENDPOINT, like tracing.
+	Endpoint string `json:"endpoint"`
+	// Headers are "Name=value" pairs sent with every request, such as
+	// credentials or X-Scope-OrgID
+	Headers []string `json:"headers"`
+	// Level is the lowest level exported; empty exports what is logged
+	Level         string        `json:"level"`
+	BatchSize     int           `json:"batch_size"`
+	FlushInterval time.Duration `json:"flush_interval"`
+	QueueSize     int           `json:"queue_size"`
+	// MaxRetries bounds the retries of a batch after network errors, 429
+	// and 5xx responses, with exponential backoff
+	MaxRetries int           `json:"max_retries"`
+	Timeout    time.Duration `json:"timeout"`
+}
+
+// exportCloseTimeout bounds flushing queued records on Close
+const exportCloseTimeout = 5 * time.Second
+
+// exportRecord is a record detached from slog, with attributes flattened to
+// dotted keys
+type exportRecord struct {
+	time    time.Time
+	level   slog.Level
+	message string
+	attrs   []slog.Attr
+}
+
+// exporter batches records and posts them to the backend
+type exporter struct {
+	config   ExportConfig
+	url      string
+	headers  http.Header
+	encode   func(batch []exportRecord) ([]byte, error)
+	client   *http.Client
+	queue    chan exportRecord
+	dropped  atomic.Int64
+	done     chan struct{}
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func newExporter(config ExportConfig, serviceName, environment string) (*exporter, error) {
+	endpoint := config.Endpoint
+	if endpoint == "" && strings.ToLower(config.Protocol) == ExportOTLP {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" {
+		return nil, fmt.Errorf("log export to %s needs an endpoint", config.Protocol)
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+
+	e := &exporter{
+		config:  config,
+		headers: http.Header{"Content-Type": {"application/json"}},
+		client:  &http.Client{Timeout: config.Timeout},
+		done:    make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+	switch strings.ToLower(config.Protocol) {
+	case ExportOTLP:
+		e.url = endpoint + "/v1/logs"
+		e.encode = otlpEncoder(serviceName, environment)
+	case ExportLoki:
+		e.url = endpoint + "/loki/api/v1/push"
+		e.encode = lokiEncoder(serviceName, environment)
+	default:
+		return nil, fmt.Errorf("unknown log export protocol %q", config.Protocol)
+	}
+	for _, header := range config.Headers {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok {
+			return nil, fmt.Errorf("log export header %q is not Name=value", header)
+		}
+		e.headers.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	if e.config.BatchSize <= 0 {
+		e.config.BatchSize = 100
+	}
+	if e.config.FlushInterval <= 0 {
+		e.config.FlushInterval = time.Second
+	}
+	if e.config.QueueSize < e.config.BatchSize {
+		e.config.QueueSize = max(1000, e.config.BatchSize)
+	}
+	if e.config.Timeout <= 0 {
+		e.client.Timeout = 5 * time.Second
+	}
+	e.queue = make(chan exportRecord, e.config.QueueSize)
+
+	go e.run()
+	return e, nil
+}
+
+// enqueue queues a record without blocking, dropping it when the queue is
+// full
+func (e *exporter) enqueue(record exportRecord) {
+	select {
+	case e.queue <- record:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]exportRecord, 0, e.config.BatchSize)
+	flush := func(ctx context.Context) {
+		if dropped := e.dropped.Swap(0); dropped > 0 {
+			fmt.Fprintf(os.Stderr, "log export: dropped %d records, the queue was full\n", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(ctx, batch); err != nil {
+			fmt.Fprintf(os.Stderr, "log export: failed to send %d records: %v\n", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case record := <-e.queue:
+			batch = append(batch, record)
+			if len(batch) >= e.config.BatchSize {
+				flush(context.Background())
+			}
+		case <-ticker.C:
+			flush(context.Background())
+		case <-e.stop:
+			// Send what is queued, without retrying for long
+			ctx, cancel := context.WithTimeout(context.Background(), exportCloseTimeout)
+			defer cancel()
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+				if len(batch) >= e.config.BatchSize {
+					flush(ctx)
+				}
+			}
+			flush(ctx)
+			return
+		}
+	}
+}
+
+// send posts one batch, retrying failures that may pass on a later attempt
+func (e *exporter) send(ctx context.Context, batch []exportRecord) error {
+	body, err := e.encode(batch)
+	if err != nil {
+		return err
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		retry, err := e.post(ctx, body)
+		if err == nil || !retry || attempt >= e.config.MaxRetries {
+			return err
+		}
+
+		// Full jitter keeps instances from retrying in step
+		wait := time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// post sends a body once and reports whether a failure is worth retrying
+func (e *exporter) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header = e.headers.Clone()
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, err
+}
+
+// close stops the exporter after sending the queued records
+func (e *exporter) close() error {
+	e.stopOnce.Do(func() { close(e.stop) })
+	select {
+	case <-e.done:
+		return nil
+	case <-time.After(2 * exportCloseTimeout):
+		return errors.New("log export: timed out flushing records")
+	}
+}
+
+// exportHandler turns records into exportRecords for an exporter
+type exportHandler struct {
+	exporter *exporter
+	level    slog.Leveler
+	attrs    []slog.Attr
+	group    string
+}
+
+func (h *exportHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *exportHandler) Handle(ctx context.Context, r slog.Record) error {
+	record := exportRecord{
+		time:    r.Time,
+		level:   r.Level,
+		message: ansiColor.ReplaceAllString(r.Message, ""),
+		attrs:   append([]slog.Attr(nil), h.attrs...),
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		record.attrs = appendFlat(record.attrs, h.group, a)
+		return true
+	})
+	h.exporter.enqueue(record)
+	return nil
+}
+
+func (h *exportHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := *h
+	derived.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		derived.attrs = appendFlat(derived.attrs, h.group, a)
+	}
+	return &derived
+}
+
+func (h *exportHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	derived := *h
+	derived.group = joinKey(h.group, name)
+	return &derived
+}
+
+// otlpEncoder encodes batches as an OTLP ExportLogsServiceRequest in the
+// JSON mapping of OTLP/HTTP
+func otlpEncoder(serviceName, environment string) func([]exportRecord) ([]byte, error) {
+	resource := []otlpKeyValue{
+		{Key: "service.name", Value: otlpString(serviceName)},
+		{Key: "deployment.environment", Value: otlpString(environment)},
+	}
+	return func(batch []exportRecord) ([]byte, error) {
+		records := make([]otlpLogRecord, 0, len(batch))
+		for _, r := range batch {
+			record := otlpLogRecord{
+				TimeUnixNano:   strconv.FormatInt(r.time.UnixNano(), 10),
+				SeverityNumber: otlpSeverity(r.level),
+				SeverityText:   r.level.String(),
+				Body:           otlpString(r.message),
+			}
+			for _, a := range r.attrs {
+				// Trace context has fields of its own, which backends use to
+				// link logs to traces
+				switch a.Key {
+				case "trace_id":
+					record.TraceID = a.Value.String()
+				case "span_id":
+					record.SpanID = a.Value.String()
+				default:
+					record.Attributes = append(record.Attributes, otlpKeyValue{Key: a.Key, Value: otlpValue(a.Value)})
+				}
+			}
+			records = append(records, record)
+		}
+
+		return json.Marshal(map[string]any{
+			"resourceLogs": []map[string]any{{
+				"resource": map[string]any{"attributes": resource},
+				"scopeLogs": []map[string]any{{
+					"scope":      map[string]string{"name": "github.com/dhekaag/golang-microservices/shared/pkg/logger"},
+					"logRecords": records,
+				}},
+			}},
+		})
+	}
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano   string         `json:"timeUnixNano"`
+	SeverityNumber int            `json:"severityNumber"`
+	SeverityText   string         `json:"severityText"`
+	Body           otlpAnyValue   `json:"body"`
+	Attributes     []otlpKeyValue `json:"attributes,omitempty"`
+	TraceID        string         `json:"traceId,omitempty"`
+	SpanID         string         `json:"spanId,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 is a string in OTLP JSON
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func otlpString(s string) otlpAnyValue {
+	return otlpAnyValue{StringValue: &s}
+}
+
+func otlpValue(v slog.Value) otlpAnyValue {
+	switch v.Kind() {
+	case slog.KindBool:
+		b := v.Bool()
+		return otlpAnyValue{BoolValue: &b}
+	case slog.KindInt64:
+		i := strconv.FormatInt(v.Int64(), 10)
+		return otlpAnyValue{IntValue: &i}
+	case slog.KindUint64:
+		i := strconv.FormatUint(v.Uint64(), 10)
+		return otlpAnyValue{IntValue: &i}
+	case slog.KindFloat64:
+		f := v.Float64()
+		return otlpAnyValue{DoubleValue: &f}
+	default:
+		return otlpString(v.String())
+	}
+}
+
+// otlpSeverity maps slog levels to the OTLP severity numbers
+func otlpSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 17
+	case level >= slog.LevelWarn:
+		return 13
+	case level >= slog.LevelInfo:
+		return 9
+	default:
+		return 5
+	}
+}
+
+// lokiEncoder encodes batches for the Loki push API, one stream per level.
+// Lines are JSON objects, so they can be queried with `| json`; labels are
+// kept to the few low-cardinality ones Loki indexes well.
+func lokiEncoder(serviceName, environment string) func([]exportRecord) ([]byte, error) {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	return func(batch []exportRecord) ([]byte, error) {
+		var streams []*stream
+		byLevel := make(map[slog.Level]*stream)
+		for _, r := range batch {
+			s, ok := byLevel[r.level]
+			if !ok {
+				s = &stream{Stream: map[string]string{
+					"service":     serviceName,
+					"environment": environment,
+					"level":       strings.ToLower(r.level.String()),
+				}}
+				byLevel[r.level] = s
+				streams = append(streams, s)
+			}
+
+			fields := make(map[string]any, len(r.attrs)+1)
+			for _, a := range r.attrs {
+				switch a.Value.Kind() {
+				case slog.KindBool, slog.KindInt64, slog.KindUint64, slog.KindFloat64:
+					fields[a.Key] = a.Value.Any()
+				default:
+					fields[a.Key] = a.Value.String()
+				}
+			}
+			fields["msg"] = r.message
+			line, err := json.Marshal(fields)
+			if err != nil {
+				return nil, err
+			}
+			s.Values = append(s.Values, [2]string{strconv.FormatInt(r.time.UnixNano(), 10), string(line)})
+		}
+		return json.Marshal(map[string]any{"streams": streams})
+	}
+}
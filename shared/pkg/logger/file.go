@@ -0,0 +1,320 @@
+package logger
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMaxSizeMB is the size log files are rotated at when not configured
+const defaultMaxSizeMB = 100
+
+// backupTimeFormat stamps rotated files: app-2006-01-02T15-04-05.000.log
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// FileConfig writes logs to files in addition to stdout, for hosts without
+// a log collector. Files are rotated by size, and rotated files are removed
+// by age and count, like lumberjack does.
+type FileConfig struct {
+	// Path receives every record at the configured level; empty disables
+	// file output
+	Path string `json:"path"`
+	// ErrorPath, if set, also receives the error records, so failures can be
+	// found without searching the full log
+	ErrorPath string `json:"error_path"`
+	// MaxSizeMB is the size a file is rotated at, 100 when 0
+	MaxSizeMB int `json:"max_size_mb"`
+	// MaxAgeDays removes rotated files older than it; 0 keeps them
+	MaxAgeDays int `json:"max_age_days"`
+	// MaxBackups is how many rotated files are kept per log; 0 keeps all
+	MaxBackups int `json:"max_backups"`
+	// Compress gzips rotated files
+	Compress bool `json:"compress"`
+}
+
+// ansiColor matches the color codes the pretty output puts in messages
+var ansiColor = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// RotatingFile is an io.WriteCloser appending to a file that it renames
+// aside once it would grow past its maximum size. It is safe for concurrent
+// use.
+type RotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	config  FileConfig
+	maxSize int64
+	file    *os.File
+	size    int64
+
+	// Rotated files are compressed and removed in the background, one pass
+	// at a time
+	millMu sync.Mutex
+	millWG sync.WaitGroup
+}
+
+// OpenRotatingFile opens path for appending, creating it and its directory
+// if needed. The rotation settings of config apply; its paths are ignored.
+func OpenRotatingFile(path string, config FileConfig) (*RotatingFile, error) {
+	maxSizeMB := config.MaxSizeMB
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultMaxSizeMB
+	}
+	f := &RotatingFile{
+		path:    path,
+		config:  config,
+		maxSize: int64(maxSizeMB) * 1024 * 1024,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	// Apply the retention to files left by earlier runs
+	f.startMill()
+	return f, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the file and waits for rotated files to be processed
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+
+	f.millWG.Wait()
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file aside with a timestamp and starts a new
+// one. The caller holds mu.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	f.file = nil
+
+	prefix, ext := f.backupParts()
+	backup := prefix + time.Now().UTC().Format(backupTimeFormat) + ext
+	if err := os.Rename(f.path, backup); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.startMill()
+	return nil
+}
+
+// backupParts returns what rotated files of the log start and end with:
+// logs/app.log is rotated to logs/app-<time>.log
+func (f *RotatingFile) backupParts() (string, string) {
+	ext := filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-", ext
+}
+
+func (f *RotatingFile) startMill() {
+	if !f.config.Compress && f.config.MaxBackups <= 0 && f.config.MaxAgeDays <= 0 {
+		return
+	}
+	f.millWG.Add(1)
+	go func() {
+		defer f.millWG.Done()
+		f.millMu.Lock()
+		defer f.millMu.Unlock()
+		if err := f.mill(); err != nil {
+			fmt.Fprintf(os.Stderr, "log rotation of %s: %v\n", f.path, err)
+		}
+	}()
+}
+
+// mill removes the rotated files beyond MaxBackups or older than MaxAgeDays
+// and compresses the rest
+func (f *RotatingFile) mill() error {
+	type backup struct {
+		path string
+		time time.Time
+	}
+
+	prefix, ext := f.backupParts()
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return err
+	}
+	var backups []backup
+	for _, path := range matches {
+		stamp := strings.TrimPrefix(path, prefix)
+		stamp = strings.TrimSuffix(strings.TrimSuffix(stamp, ".gz"), ext)
+		rotatedAt, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: path, time: rotatedAt})
+	}
+	// Newest first
+	sort.Slice(backups, func(i, j int) bool { return backups[i].time.After(backups[j].time) })
+
+	var errs []error
+	cutoff := time.Now().AddDate(0, 0, -f.config.MaxAgeDays)
+	for i, b := range backups {
+		expired := f.config.MaxAgeDays > 0 && b.time.Before(cutoff)
+		if expired || (f.config.MaxBackups > 0 && i >= f.config.MaxBackups) {
+			errs = append(errs, os.Remove(b.path))
+			continue
+		}
+		if f.config.Compress && !strings.HasSuffix(b.path, ".gz") {
+			errs = append(errs, compressFile(b.path))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// compressFile replaces path with path.gz
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// fileHandler writes records to a file in the configured format. The pretty
+// format is for terminals, so files get plain key=value lines instead, and
+// the colors some messages carry are removed.
+func fileHandler(w io.Writer, format string, level slog.Leveler) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level}
+	if strings.ToLower(format) == "json" {
+		return plainHandler{slog.NewJSONHandler(w, opts)}
+	}
+	return plainHandler{slog.NewTextHandler(w, opts)}
+}
+
+// plainHandler strips color codes from messages
+type plainHandler struct {
+	slog.Handler
+}
+
+func (h plainHandler) Handle(ctx context.Context, r slog.Record) error {
+	if strings.Contains(r.Message, "\x1b[") {
+		plain := slog.NewRecord(r.Time, r.Level, ansiColor.ReplaceAllString(r.Message, ""), r.PC)
+		r.Attrs(func(a slog.Attr) bool {
+			plain.AddAttrs(a)
+			return true
+		})
+		r = plain
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return plainHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h plainHandler) WithGroup(name string) slog.Handler {
+	return plainHandler{h.Handler.WithGroup(name)}
+}
+
+// fanoutHandler passes records to every handler that accepts their level
+type fanoutHandler []slog.Handler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, handler := range h {
+		if handler.Enabled(ctx, r.Level) {
+			errs = append(errs, handler.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return handlers
+}
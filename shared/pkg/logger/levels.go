@@ -0,0 +1,143 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
+)
+
+// Levels holds the level of a service and the levels of its named
+// components, such as proxy or repository. A component without a level of
+// its own follows the service level. Levels can be changed while the
+// service runs and apply to loggers created before the change.
+type Levels struct {
+	mu         sync.RWMutex
+	level      slog.Level
+	components map[string]slog.Level
+}
+
+// NewLevels parses component levels written as "proxy=debug,repository=warn"
+func NewLevels(level slog.Level, components string) (*Levels, error) {
+	levels := &Levels{level: level, components: make(map[string]slog.Level)}
+	for _, entry := range strings.Split(components, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("component level %q is not name=level", entry)
+		}
+		componentLevel, err := ParseLevel(value)
+		if err != nil {
+			return nil, err
+		}
+		levels.components[name] = componentLevel
+	}
+	return levels, nil
+}
+
+// Level returns the service level
+func (l *Levels) Level() slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.level
+}
+
+// SetLevel changes the service level
+func (l *Levels) SetLevel(level slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+// Component returns the level of a component, the service level unless it
+// has its own
+func (l *Levels) Component(name string) slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if level, ok := l.components[name]; ok {
+		return level
+	}
+	return l.level
+}
+
+// SetComponent gives a component its own level
+func (l *Levels) SetComponent(name string, level slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.components[name] = level
+}
+
+// ResetComponent makes a component follow the service level again
+func (l *Levels) ResetComponent(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.components, name)
+}
+
+// Components returns the components with a level of their own
+func (l *Levels) Components() map[string]slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return maps.Clone(l.components)
+}
+
+// componentLevel is the slog.Leveler of one component, read on every record
+type componentLevel struct {
+	levels *Levels
+	name   string
+}
+
+func (c componentLevel) Level() slog.Level {
+	if c.name == "" {
+		return c.levels.Level()
+	}
+	return c.levels.Component(c.name)
+}
+
+// componentHandler filters records by the current level of a component and
+// tags them with its name
+type componentHandler struct {
+	handler slog.Handler
+	level   componentLevel
+}
+
+func (h componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.handler.Enabled(ctx, level)
+}
+
+func (h componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	if h.level.name != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String("component", h.level.name))
+	}
+	return h.handler.Handle(ctx, r)
+}
+
+func (h componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return componentHandler{handler: h.handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h componentHandler) WithGroup(name string) slog.Handler {
+	return componentHandler{handler: h.handler.WithGroup(name), level: h.level}
+}
+
+// ParseLevel accepts debug, info, warn (or warning) and error in any case
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", level)
+	}
+}
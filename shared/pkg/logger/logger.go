@@ -2,19 +2,26 @@ package logger
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Logger struct {
 	*slog.Logger
-	config Config
+	config   Config
+	levels   *Levels
+	outputs  slog.Handler
+	files    []*RotatingFile
+	exporter *exporter
 }
 
 type Config struct {
@@ -22,6 +29,23 @@ type Config struct {
 	Format      string `json:"format"`
 	ServiceName string `json:"service_name"`
 	Environment string `json:"environment"`
+	// Components sets levels of named components, such as
+	// "proxy=debug,repository=warn"; see Named
+	Components string       `json:"components"`
+	File       FileConfig   `json:"file"`
+	Export     ExportConfig `json:"export"`
+}
+
+// DefaultFormat is the log format of an environment: JSON in production,
+// where logs are collected and parsed, and the colored text format anywhere
+// else
+func DefaultFormat(environment string) string {
+	switch strings.ToLower(environment) {
+	case "production", "prod":
+		return "json"
+	default:
+		return "text"
+	}
 }
 
 // Context keys
@@ -48,11 +72,16 @@ const (
 	ColorBold   = "\033[1m"
 )
 
-// Custom handler that writes directly to stdout with proper formatting
+// Custom handler that writes directly to stdout with proper formatting.
+// Attributes of groups are written with dotted keys, group.key=value.
 type PrettyHandler struct {
 	writer  io.Writer
 	level   slog.Level
 	service string
+	// attrs were added with WithAttrs, already qualified by their groups;
+	// group qualifies the keys of later attributes
+	attrs []slog.Attr
+	group string
 }
 
 func NewPrettyHandler(w io.Writer, opts *slog.HandlerOptions, serviceName string) *PrettyHandler {
@@ -73,19 +102,25 @@ func (h *PrettyHandler) Enabled(ctx context.Context, level slog.Level) bool {
 }
 
 func (h *PrettyHandler) Handle(ctx context.Context, r slog.Record) error {
-	timestamp := time.Now().Format("15:04:05")
 	level := formatLevel(r.Level)
 	service := fmt.Sprintf("%s%s%s", ColorCyan, h.service, ColorReset)
 
 	// Build the log line
 	var parts []string
-	parts = append(parts, fmt.Sprintf("%s%s%s", ColorGray, timestamp, ColorReset))
+	if !r.Time.IsZero() {
+		parts = append(parts, fmt.Sprintf("%s%s%s", ColorGray, r.Time.Format("15:04:05"), ColorReset))
+	}
 	parts = append(parts, level)
 	parts = append(parts, service)
 	parts = append(parts, r.Message)
 
-	// Add attributes
+	// Add attributes, those of the handler first
+	attrs := slices.Clip(h.attrs)
 	r.Attrs(func(a slog.Attr) bool {
+		attrs = appendFlat(attrs, h.group, a)
+		return true
+	})
+	for _, a := range attrs {
 		if a.Key == "request_id" {
 			parts = append(parts, fmt.Sprintf("%s[%s]%s", ColorBlue, a.Value.String(), ColorReset))
 		} else if a.Key == "user_id" {
@@ -93,8 +128,7 @@ func (h *PrettyHandler) Handle(ctx context.Context, r slog.Record) error {
 		} else {
 			parts = append(parts, fmt.Sprintf("%s=%s", a.Key, a.Value.String()))
 		}
-		return true
-	})
+	}
 
 	line := strings.Join(parts, " ") + "\n"
 	_, err := h.writer.Write([]byte(line))
@@ -102,39 +136,175 @@ func (h *PrettyHandler) Handle(ctx context.Context, r slog.Record) error {
 }
 
 func (h *PrettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	return h
+	if len(attrs) == 0 {
+		return h
+	}
+	derived := *h
+	derived.attrs = slices.Clip(h.attrs)
+	for _, a := range attrs {
+		derived.attrs = appendFlat(derived.attrs, h.group, a)
+	}
+	return &derived
 }
 
 func (h *PrettyHandler) WithGroup(name string) slog.Handler {
-	return h
+	if name == "" {
+		return h
+	}
+	derived := *h
+	derived.group = joinKey(h.group, name)
+	return &derived
+}
+
+// appendFlat appends a with its key prefixed by group, expanding groups
+// into dotted keys
+func appendFlat(attrs []slog.Attr, group string, a slog.Attr) []slog.Attr {
+	value := a.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		prefix := group
+		if a.Key != "" {
+			prefix = joinKey(group, a.Key)
+		}
+		for _, member := range value.Group() {
+			attrs = appendFlat(attrs, prefix, member)
+		}
+		return attrs
+	}
+	if a.Key == "" {
+		return attrs
+	}
+	return append(attrs, slog.Attr{Key: joinKey(group, a.Key), Value: value})
+}
+
+func joinKey(group, key string) string {
+	if group == "" {
+		return key
+	}
+	return group + "." + key
 }
 
 // Initialize logger
 func Init(config Config) (*Logger, error) {
-	level := parseLevel(config.Level)
+	serviceLevel := slog.LevelInfo
+	if config.Level != "" {
+		parsed, err := ParseLevel(config.Level)
+		if err != nil {
+			return nil, err
+		}
+		serviceLevel = parsed
+	}
+	switch strings.ToLower(config.Format) {
+	case "", "text", "json":
+	default:
+		return nil, fmt.Errorf("unknown log format %q", config.Format)
+	}
+	levels, err := NewLevels(serviceLevel, config.Components)
+	if err != nil {
+		return nil, err
+	}
 	serviceName := fmt.Sprintf("%s[%s]", config.ServiceName, config.Environment)
 
+	// Levels are checked by the Levels of the logger, which can change at
+	// runtime, so the outputs take every record passed to them
+	level := slog.LevelDebug
 	var handler slog.Handler
 
 	switch strings.ToLower(config.Format) {
 	case "json":
+		// The pretty output names the service in every line; JSON records
+		// carry it as attributes for the log collector
 		opts := &slog.HandlerOptions{Level: level}
-		handler = slog.NewJSONHandler(os.Stdout, opts)
+		handler = slog.NewJSONHandler(os.Stdout, opts).WithAttrs([]slog.Attr{
+			slog.String("service", config.ServiceName),
+			slog.String("environment", config.Environment),
+		})
 	default:
 		// Use our custom pretty handler
 		opts := &slog.HandlerOptions{Level: level}
 		handler = NewPrettyHandler(os.Stdout, opts, serviceName)
 	}
 
-	logger := &Logger{
-		Logger: slog.New(handler),
-		config: config,
+	logger := &Logger{config: config, levels: levels}
+
+	// Optional file output next to stdout
+	if config.File.Path != "" || config.File.ErrorPath != "" {
+		handlers := fanoutHandler{handler}
+		outputs := []struct {
+			path  string
+			level slog.Level
+		}{
+			{config.File.Path, level},
+			{config.File.ErrorPath, slog.LevelError},
+		}
+		for _, output := range outputs {
+			if output.path == "" {
+				continue
+			}
+			file, err := OpenRotatingFile(output.path, config.File)
+			if err != nil {
+				logger.Close()
+				return nil, err
+			}
+			logger.files = append(logger.files, file)
+			handlers = append(handlers, fileHandler(file, config.Format, output.level))
+		}
+		handler = handlers
+	}
+
+	// Optional shipping to a log backend
+	if config.Export.Protocol != "" {
+		exporter, err := newExporter(config.Export, config.ServiceName, config.Environment)
+		if err != nil {
+			logger.Close()
+			return nil, err
+		}
+		logger.exporter = exporter
+
+		exportLevel := level
+		if config.Export.Level != "" {
+			exportLevel = parseLevel(config.Export.Level)
+		}
+		handler = fanoutHandler{handler, &exportHandler{exporter: exporter, level: exportLevel}}
 	}
 
+	logger.outputs = contextHandler{handler}
+	logger.Logger = slog.New(componentHandler{handler: logger.outputs, level: componentLevel{levels: levels}})
+
 	globalLogger = logger
 	return logger, nil
 }
 
+// Named returns a logger for a component of the service, whose records are
+// tagged with component=name and filtered by the component's level. The
+// logger shares the outputs of l; Close it through l.
+func (l *Logger) Named(name string) *Logger {
+	return &Logger{
+		Logger:  slog.New(componentHandler{handler: l.outputs, level: componentLevel{levels: l.levels, name: name}}),
+		config:  l.config,
+		levels:  l.levels,
+		outputs: l.outputs,
+	}
+}
+
+// Levels returns the levels of the service and its components, for changing
+// them at runtime
+func (l *Logger) Levels() *Levels {
+	return l.levels
+}
+
+// Close sends the records queued for export and closes the log files, so
+// it belongs at the very end of shutdown
+func (l *Logger) Close() error {
+	var errs []error
+	if l.exporter != nil {
+		errs = append(errs, l.exporter.close())
+	}
+	for _, file := range l.files {
+		errs = append(errs, file.Close())
+	}
+	return errors.Join(errs...)
+}
+
 func formatLevel(level slog.Level) string {
 	switch level {
 	case slog.LevelDebug:
@@ -199,7 +369,7 @@ func (l *Logger) DebugMsg(msg string, args ...any) {
 }
 
 // Specialized logging methods with enhanced formatting
-func (l *Logger) HTTPRequest(ctx context.Context, method, path string, statusCode int, duration time.Duration) {
+func (l *Logger) HTTPRequest(ctx context.Context, method, path string, statusCode int, duration time.Duration, args ...any) {
 	level := slog.LevelInfo
 	statusColor := ColorGreen
 
@@ -223,7 +393,7 @@ func (l *Logger) HTTPRequest(ctx context.Context, method, path string, statusCod
 		duration.String(),
 	)
 
-	l.logWithContext(ctx, level, msg)
+	l.logWithContext(ctx, level, msg, args...)
 }
 
 func (l *Logger) Database(ctx context.Context, operation string, duration time.Duration, err error) {
@@ -231,7 +401,7 @@ func (l *Logger) Database(ctx context.Context, operation string, duration time.D
 		l.logWithContext(ctx, slog.LevelError,
 			fmt.Sprintf("🔴 DB %s%s%s failed", ColorRed, operation, ColorReset),
 			"duration", duration.String(),
-			"error", err.Error(),
+			errorGroup(err, callerStack()),
 		)
 	} else {
 		l.logWithContext(ctx, slog.LevelInfo,
@@ -247,7 +417,7 @@ func (l *Logger) ExternalCall(ctx context.Context, service, endpoint string, dur
 			fmt.Sprintf("🔴 External call to %s%s%s failed", ColorRed, service, ColorReset),
 			"endpoint", endpoint,
 			"duration", duration.String(),
-			"error", err.Error(),
+			errorGroup(err, callerStack()),
 		)
 	} else {
 		l.logWithContext(ctx, slog.LevelInfo,
@@ -270,33 +440,73 @@ func (l *Logger) ServiceStopped() {
 	l.InfoMsg(fmt.Sprintf("🛑 Service %sstopped gracefully%s", ColorYellow, ColorReset))
 }
 
-// Internal helper method
+// Internal helper method. The IDs the context carries are added by the
+// contextHandler every logger writes through.
 func (l *Logger) logWithContext(ctx context.Context, level slog.Level, msg string, args ...any) {
-	// Extract context values
-	contextArgs := l.extractContextArgs(ctx)
+	l.Logger.Log(ctx, level, msg, args...)
+}
+
+// contextHandler adds the request, user and correlation IDs and the trace
+// and span IDs of the record's context in front of its attributes. Trace
+// IDs are there whenever the context has a valid span context: a span of
+// this service, or the caller's when tracing is disabled here.
+type contextHandler struct {
+	handler slog.Handler
+}
+
+func (h contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := contextAttrs(ctx)
+	if len(attrs) == 0 {
+		return h.handler.Handle(ctx, r)
+	}
+
+	enriched := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	enriched.AddAttrs(attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		enriched.AddAttrs(a)
+		return true
+	})
+	return h.handler.Handle(ctx, enriched)
+}
 
-	// Combine context args with provided args
-	allArgs := append(contextArgs, args...)
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.handler.WithAttrs(attrs)}
+}
 
-	l.Logger.Log(ctx, level, msg, allArgs...)
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.handler.WithGroup(name)}
 }
 
-func (l *Logger) extractContextArgs(ctx context.Context) []any {
-	var args []any
+func contextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	var attrs []slog.Attr
 
 	if requestID := getFromContext(ctx, RequestIDKey); requestID != "" {
-		args = append(args, "request_id", requestID)
+		attrs = append(attrs, slog.String("request_id", requestID))
 	}
 
 	if userID := getFromContext(ctx, UserIDKey); userID != "" {
-		args = append(args, "user_id", userID)
+		attrs = append(attrs, slog.String("user_id", userID))
 	}
 
 	if correlationID := getFromContext(ctx, CorrelationIDKey); correlationID != "" {
-		args = append(args, "correlation_id", correlationID)
+		attrs = append(attrs, slog.String("correlation_id", correlationID))
 	}
 
-	return args
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		attrs = append(attrs,
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()),
+		)
+	}
+
+	return attrs
 }
 
 // Context helper functions
@@ -342,18 +552,11 @@ func GetOrCreateCorrelationID(ctx context.Context) (context.Context, string) {
 
 // Utility functions
 func parseLevel(level string) slog.Level {
-	switch strings.ToLower(level) {
-	case "debug":
-		return slog.LevelDebug
-	case "info":
-		return slog.LevelInfo
-	case "warn", "warning":
-		return slog.LevelWarn
-	case "error":
-		return slog.LevelError
-	default:
+	parsed, err := ParseLevel(level)
+	if err != nil {
 		return slog.LevelInfo
 	}
+	return parsed
 }
 
 func getFromContext(ctx context.Context, key ContextKey) string {
@@ -402,8 +605,12 @@ func DebugMsg(msg string, args ...any) {
 	Get().DebugMsg(msg, args...)
 }
 
-func HTTPRequest(ctx context.Context, method, path string, statusCode int, duration time.Duration) {
-	Get().HTTPRequest(ctx, method, path, statusCode, duration)
+func Named(name string) *Logger {
+	return Get().Named(name)
+}
+
+func HTTPRequest(ctx context.Context, method, path string, statusCode int, duration time.Duration, args ...any) {
+	Get().HTTPRequest(ctx, method, path, statusCode, duration, args...)
 }
 
 func Database(ctx context.Context, operation string, duration time.Duration, err error) {
@@ -0,0 +1,174 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/slogtest"
+	"time"
+)
+
+var ansiCodes = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// parsePrettyLine reads a line of PrettyHandler back into the map slogtest
+// expects, nesting dotted keys into groups
+func parsePrettyLine(t *testing.T, line string) map[string]any {
+	t.Helper()
+	fields := strings.Fields(ansiCodes.ReplaceAllString(line, ""))
+
+	record := make(map[string]any)
+	if len(fields) > 0 {
+		if _, err := time.Parse("15:04:05", fields[0]); err == nil {
+			record[slog.TimeKey] = fields[0]
+			fields = fields[1:]
+		}
+	}
+	if len(fields) < 3 {
+		t.Fatalf("line %q lacks level, service or message", line)
+	}
+	record[slog.LevelKey] = strings.Trim(fields[0], "[]")
+	record[slog.MessageKey] = fields[2]
+
+	for _, field := range fields[3:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			t.Fatalf("line %q: attribute %q is not key=value", line, field)
+		}
+		path := strings.Split(key, ".")
+		group := record
+		for _, name := range path[:len(path)-1] {
+			nested, ok := group[name].(map[string]any)
+			if !ok {
+				nested = make(map[string]any)
+				group[name] = nested
+			}
+			group = nested
+		}
+		group[path[len(path)-1]] = value
+	}
+	return record
+}
+
+func TestPrettyHandlerConformance(t *testing.T) {
+	var buf bytes.Buffer
+	newHandler := func(t *testing.T) slog.Handler {
+		buf.Reset()
+		return NewPrettyHandler(&buf, nil, "svc")
+	}
+	result := func(t *testing.T) map[string]any {
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("want one line, got %q", buf.String())
+		}
+		return parsePrettyLine(t, lines[0])
+	}
+	slogtest.Run(t, newHandler, result)
+}
+
+func TestPrettyHandlerWithAttrsAndGroups(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(l *slog.Logger)
+		want string
+	}{
+		{
+			name: "attrs before group",
+			log: func(l *slog.Logger) {
+				l.With("a", 1).WithGroup("g").Info("msg", "b", 2)
+			},
+			want: "msg a=1 g.b=2",
+		},
+		{
+			name: "attrs within group",
+			log: func(l *slog.Logger) {
+				l.WithGroup("g").With("a", 1).WithGroup("h").Info("msg", "b", 2)
+			},
+			want: "msg g.a=1 g.h.b=2",
+		},
+		{
+			name: "group attr",
+			log: func(l *slog.Logger) {
+				l.Info("msg", slog.Group("g", "a", 1, slog.Group("h", "b", 2)))
+			},
+			want: "msg g.a=1 g.h.b=2",
+		},
+		{
+			name: "empty group",
+			log: func(l *slog.Logger) {
+				l.WithGroup("g").Info("msg")
+			},
+			want: "msg",
+		},
+		{
+			name: "derived handlers do not share attrs",
+			log: func(l *slog.Logger) {
+				base := l.With("a", 1)
+				base.With("b", 2)
+				base.With("c", 3).Info("msg")
+			},
+			want: "msg a=1 c=3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(slog.New(NewPrettyHandler(&buf, nil, "svc")))
+
+			line := ansiCodes.ReplaceAllString(strings.TrimSuffix(buf.String(), "\n"), "")
+			_, got, _ := strings.Cut(line, "svc ")
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrettyHandlerLevels(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   slog.Leveler
+		enabled []slog.Level
+		skipped []slog.Level
+	}{
+		{
+			name:    "default",
+			enabled: []slog.Level{slog.LevelInfo, slog.LevelWarn, slog.LevelError},
+			skipped: []slog.Level{slog.LevelDebug},
+		},
+		{
+			name:    "debug",
+			level:   slog.LevelDebug,
+			enabled: []slog.Level{slog.LevelDebug, slog.LevelInfo},
+		},
+		{
+			name:    "error",
+			level:   slog.LevelError,
+			enabled: []slog.Level{slog.LevelError},
+			skipped: []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewPrettyHandler(&bytes.Buffer{}, &slog.HandlerOptions{Level: tt.level}, "svc")
+			for _, level := range tt.enabled {
+				if !handler.Enabled(context.Background(), level) {
+					t.Errorf("%s disabled", level)
+				}
+			}
+			for _, level := range tt.skipped {
+				if handler.Enabled(context.Background(), level) {
+					t.Errorf("%s enabled", level)
+				}
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	slog.New(NewPrettyHandler(&buf, nil, "svc")).Warn("msg")
+	if got := ansiCodes.ReplaceAllString(buf.String(), ""); !strings.Contains(got, "[WARN]") {
+		t.Errorf("line %q lacks its level", got)
+	}
+}
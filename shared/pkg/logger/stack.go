@@ -0,0 +1,131 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+)
+
+// maxStackFrames bounds the stack logged by ErrorWithStack
+const maxStackFrames = 16
+
+// loggerPackage prefixes the functions of this package, which are left out
+// of stacks
+const loggerPackage = "github.com/dhekaag/golang-microservices/shared/pkg/logger."
+
+// ErrorWithStack logs err at error level with its details as an "error"
+// group instead of a flat string: the message, the code and status of the
+// AppError in its chain if any, the chain of wrapped errors, and the stack
+// of the caller without runtime and standard library frames. It is meant
+// for failures nobody expected, where the stack helps; expected failures
+// are better logged with Error or Warn.
+func (l *Logger) ErrorWithStack(ctx context.Context, err error, msg string, args ...any) {
+	if !l.Enabled(ctx, slog.LevelError) {
+		return
+	}
+	l.logWithContext(ctx, slog.LevelError, msg, append(args, errorGroup(err, callerStack()))...)
+}
+
+// ErrorWithStack logs with the global logger, see Logger.ErrorWithStack
+func ErrorWithStack(ctx context.Context, err error, msg string, args ...any) {
+	if !Get().Enabled(ctx, slog.LevelError) {
+		return
+	}
+	Get().logWithContext(ctx, slog.LevelError, msg, append(args, errorGroup(err, callerStack()))...)
+}
+
+// errorGroup describes err for ErrorWithStack
+func errorGroup(err error, stack []string) slog.Attr {
+	if err == nil {
+		return slog.Group("error", append([]any{slog.String("message", "<nil>")}, stackAttr(stack)...)...)
+	}
+
+	attrs := []any{slog.String("message", err.Error())}
+	if appErr, ok := appErrors.GetAppError(err); ok {
+		attrs = append(attrs, slog.String("code", appErr.Code))
+		if appErr.StatusCode != 0 {
+			attrs = append(attrs, slog.Int("status", appErr.StatusCode))
+		}
+	}
+	if chain := errorChain(err); len(chain) > 1 {
+		attrs = append(attrs, slog.Any("chain", chain))
+	}
+	return slog.Group("error", append(attrs, stackAttr(stack)...)...)
+}
+
+// stackAttr leaves out empty stacks, as when only the standard library
+// called in
+func stackAttr(stack []string) []any {
+	if len(stack) == 0 {
+		return nil
+	}
+	return []any{slog.Any("stack", stack)}
+}
+
+// errorChain lists err and the errors it wraps as "<type>: <message>",
+// outermost first. Errors joining several stop the chain, listing those.
+func errorChain(err error) []string {
+	var chain []string
+	for err != nil && len(chain) < maxStackFrames {
+		chain = append(chain, fmt.Sprintf("%T: %s", err, err.Error()))
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, inner := range joined.Unwrap() {
+				chain = append(chain, fmt.Sprintf("%T: %s", inner, inner.Error()))
+			}
+			break
+		}
+		err = errors.Unwrap(err)
+	}
+	return chain
+}
+
+// callerStack returns the frames calling into this package as
+// "function (file:line)", leaving out runtime and standard library frames
+// such as those of net/http serving the request
+func callerStack() []string {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []string
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, loggerPackage) && !isStandardLibrary(frame.Function) {
+			stack = append(stack, fmt.Sprintf("%s (%s:%d)", frame.Function, shortPath(frame.File), frame.Line))
+			if len(stack) == maxStackFrames {
+				break
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// isStandardLibrary reports whether function belongs to the standard
+// library, whose import paths have no dot in their first element
+func isStandardLibrary(function string) bool {
+	first, _, found := strings.Cut(function, "/")
+	if !found {
+		// A single-element path like runtime or main, ending at the first dot
+		first, _, _ = strings.Cut(function, ".")
+		return first != "main"
+	}
+	return !strings.Contains(first, ".")
+}
+
+// shortPath keeps the last two elements of a file path, handler/user.go
+func shortPath(path string) string {
+	if i := strings.LastIndex(path, "/"); i > 0 {
+		if j := strings.LastIndex(path[:i], "/"); j >= 0 {
+			return path[j+1:]
+		}
+	}
+	return path
+}
@@ -0,0 +1,432 @@
+// Package metrics keeps counters, gauges and histograms and serves them in
+// the Prometheus text exposition format, for scraping at /metrics. It
+// covers what the services record; it is not a full Prometheus client.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the histograms of
+// request durations
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector is a metric family in a registry
+type collector interface {
+	describe() (name, help, kind string, labels []string)
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics of a service
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+var (
+	defaultRegistry     *Registry
+	defaultRegistryOnce sync.Once
+)
+
+// Default returns the registry of the process, which also reports the
+// number of goroutines and the heap in use
+func Default() *Registry {
+	defaultRegistryOnce.Do(func() {
+		defaultRegistry = NewRegistry()
+		defaultRegistry.GaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+			return float64(runtime.NumGoroutine())
+		})
+		defaultRegistry.GaugeFunc("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", func() float64 {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			return float64(stats.HeapInuse)
+		})
+	})
+	return defaultRegistry
+}
+
+// register returns the collector already registered as name, or stores
+// the one made by create. Registering a name again with another kind or
+// other labels panics, as it is a programming error.
+func register[T collector](r *Registry, name, kind string, labels []string, create func() T) T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.collectors[name]; ok {
+		_, _, existingKind, existingLabels := existing.describe()
+		typed, ok := existing.(T)
+		if !ok || existingKind != kind || !slices.Equal(existingLabels, labels) {
+			panic(fmt.Sprintf("metrics: %s is already registered as a %s with labels %v", name, existingKind, existingLabels))
+		}
+		return typed
+	}
+	created := create()
+	r.collectors[name] = created
+	return created
+}
+
+// Counter returns the counter family name, registering it on first use
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return register(r, name, "counter", labels, func() *CounterVec {
+		return &CounterVec{family: newFamily[counterValue](name, help, "counter", labels)}
+	})
+}
+
+// Gauge returns the gauge family name, registering it on first use
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return register(r, name, "gauge", labels, func() *GaugeVec {
+		return &GaugeVec{family: newFamily[gaugeValue](name, help, "gauge", labels)}
+	})
+}
+
+// GaugeFunc registers a gauge without labels whose value is read from
+// value at every scrape
+func (r *Registry) GaugeFunc(name, help string, value func() float64) {
+	register(r, name, "gauge", nil, func() *gaugeFunc {
+		return &gaugeFunc{name: name, help: help, value: value}
+	})
+}
+
+// Histogram returns the histogram family name with the given bucket upper
+// bounds, DefaultBuckets if none, registering it on first use
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Sorted(slices.Values(buckets))
+	return register(r, name, "histogram", labels, func() *HistogramVec {
+		return &HistogramVec{family: newFamily[histogramValue](name, help, "histogram", labels), buckets: buckets}
+	})
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buffered := bufio.NewWriter(w)
+		r.write(buffered)
+		buffered.Flush()
+	})
+}
+
+func (r *Registry) write(w *bufio.Writer) {
+	r.mu.Lock()
+	collectors := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		nameI, _, _, _ := collectors[i].describe()
+		nameJ, _, _, _ := collectors[j].describe()
+		return nameI < nameJ
+	})
+	for _, c := range collectors {
+		name, help, kind, _ := c.describe()
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+		c.write(w)
+	}
+}
+
+// family keeps one value per combination of label values
+type family[V any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.RWMutex
+	values map[string]*V
+	order  map[string][]string
+}
+
+func newFamily[V any](name, help, kind string, labels []string) family[V] {
+	return family[V]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: slices.Clone(labels),
+		values: make(map[string]*V),
+		order:  make(map[string][]string),
+	}
+}
+
+func (f *family[V]) describe() (string, string, string, []string) {
+	return f.name, f.help, f.kind, f.labels
+}
+
+// with returns the value for labelValues, which must match the labels of
+// the family in number
+func (f *family[V]) with(labelValues []string, create func() *V) *V {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.RLock()
+	value, ok := f.values[key]
+	f.mu.RUnlock()
+	if ok {
+		return value
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if value, ok := f.values[key]; ok {
+		return value
+	}
+	value = create()
+	f.values[key] = value
+	f.order[key] = slices.Clone(labelValues)
+	return value
+}
+
+// each calls fn for every value in the order of their label values
+func (f *family[V]) each(fn func(labelValues []string, value *V)) {
+	f.mu.RLock()
+	keys := make([]string, 0, len(f.values))
+	for key := range f.values {
+		keys = append(keys, key)
+	}
+	f.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		f.mu.RLock()
+		value, labelValues := f.values[key], f.order[key]
+		f.mu.RUnlock()
+		fn(labelValues, value)
+	}
+}
+
+// CounterVec is a family of counters told apart by their label values
+type CounterVec struct {
+	family[counterValue]
+}
+
+type counterValue struct {
+	bits atomic.Uint64
+}
+
+// Counter only goes up
+type Counter struct {
+	value *counterValue
+}
+
+// With returns the counter for the label values, in the order the labels
+// were registered
+func (c *CounterVec) With(labelValues ...string) Counter {
+	return Counter{value: c.with(labelValues, func() *counterValue { return &counterValue{} })}
+}
+
+// Inc adds 1
+func (c Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds delta, which must not be negative
+func (c Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	addFloat(&c.value.bits, delta)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.each(func(labelValues []string, value *counterValue) {
+		writeSample(w, c.name, c.labels, labelValues, "", "", math.Float64frombits(value.bits.Load()))
+	})
+}
+
+// GaugeVec is a family of gauges told apart by their label values
+type GaugeVec struct {
+	family[gaugeValue]
+}
+
+type gaugeValue struct {
+	bits atomic.Uint64
+}
+
+// Gauge goes up and down
+type Gauge struct {
+	value *gaugeValue
+}
+
+// With returns the gauge for the label values, in the order the labels
+// were registered
+func (g *GaugeVec) With(labelValues ...string) Gauge {
+	return Gauge{value: g.with(labelValues, func() *gaugeValue { return &gaugeValue{} })}
+}
+
+// Set sets the gauge to value
+func (g Gauge) Set(value float64) {
+	g.value.bits.Store(math.Float64bits(value))
+}
+
+// Inc adds 1
+func (g Gauge) Inc() {
+	addFloat(&g.value.bits, 1)
+}
+
+// Dec subtracts 1
+func (g Gauge) Dec() {
+	addFloat(&g.value.bits, -1)
+}
+
+// Add adds delta
+func (g Gauge) Add(delta float64) {
+	addFloat(&g.value.bits, delta)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.each(func(labelValues []string, value *gaugeValue) {
+		writeSample(w, g.name, g.labels, labelValues, "", "", math.Float64frombits(value.bits.Load()))
+	})
+}
+
+type gaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+func (g *gaugeFunc) describe() (string, string, string, []string) {
+	return g.name, g.help, "gauge", nil
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	writeSample(w, g.name, nil, nil, "", "", g.value())
+}
+
+// HistogramVec is a family of histograms told apart by their label values
+type HistogramVec struct {
+	family[histogramValue]
+	buckets []float64
+}
+
+type histogramValue struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	value   *histogramValue
+	buckets []float64
+}
+
+// With returns the histogram for the label values, in the order the labels
+// were registered
+func (h *HistogramVec) With(labelValues ...string) Histogram {
+	value := h.with(labelValues, func() *histogramValue {
+		return &histogramValue{counts: make([]uint64, len(h.buckets))}
+	})
+	return Histogram{value: value, buckets: h.buckets}
+}
+
+// Observe records value, such as a duration in seconds
+func (h Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.buckets, value)
+
+	h.value.mu.Lock()
+	defer h.value.mu.Unlock()
+	if i < len(h.value.counts) {
+		h.value.counts[i]++
+	}
+	h.value.count++
+	h.value.sum += value
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.each(func(labelValues []string, value *histogramValue) {
+		value.mu.Lock()
+		counts := slices.Clone(value.counts)
+		count, sum := value.count, value.sum
+		value.mu.Unlock()
+
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, labelValues, "le", formatFloat(upperBound), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, labelValues, "le", "+Inf", float64(count))
+		writeSample(w, h.name+"_sum", h.labels, labelValues, "", "", sum)
+		writeSample(w, h.name+"_count", h.labels, labelValues, "", "", float64(count))
+	})
+}
+
+func addFloat(bits *atomic.Uint64, delta float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// writeSample writes one line, with an extra label such as le when
+// extraName is set
+func writeSample(w *bufio.Writer, name string, labels, labelValues []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, escapeLabelValue(labelValues[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoggingConfig controls the access log written by Logging. Requests are
+// logged at SampleRate (0-1), or at the rate of the longest SampleRules
+// prefix matching the path. Server errors and requests slower than
+// SlowThreshold are always logged.
+type LoggingConfig struct {
+	SampleRate    float64
+	SampleRules   []LogSampleRule
+	SlowThreshold time.Duration
+}
+
+// LogSampleRule sets the access-log sample rate for paths under PathPrefix
+type LogSampleRule struct {
+	PathPrefix string
+	SampleRate float64
+}
+
+func (c LoggingConfig) sampleRate(path string) float64 {
+	rate, matched := c.SampleRate, ""
+	for _, rule := range c.SampleRules {
+		if strings.HasPrefix(path, rule.PathPrefix) && len(rule.PathPrefix) > len(matched) {
+			rate, matched = rule.SampleRate, rule.PathPrefix
+		}
+	}
+	return rate
+}
+
+func (c LoggingConfig) sampled(path string, statusCode int, duration time.Duration) bool {
+	if statusCode >= http.StatusInternalServerError {
+		return true
+	}
+	if c.SlowThreshold > 0 && duration >= c.SlowThreshold {
+		return true
+	}
+	rate := c.sampleRate(path)
+	return rate >= 1 || (rate > 0 && rand.Float64() < rate)
+}
+
+type accessRecordKey struct{}
+
+// accessRecord collects what inner handlers learn about a request, such as
+// the authenticated user and the upstream calls, for its access-log line
+type accessRecord struct {
+	mutex            sync.Mutex
+	userID           string
+	upstreams        []string
+	upstreamDuration time.Duration
+}
+
+// RecordUser sets the user shown in the request's access log. Use it where
+// the user is only known inside the Logging middleware, e.g. after auth.
+func RecordUser(ctx context.Context, userID string) {
+	if record, ok := ctx.Value(accessRecordKey{}).(*accessRecord); ok {
+		record.mutex.Lock()
+		record.userID = userID
+		record.mutex.Unlock()
+	}
+}
+
+// RecordUpstream adds a call to service to the request's access log. When
+// several calls are made, the longest one is reported as upstream latency.
+func RecordUpstream(ctx context.Context, service string, duration time.Duration) {
+	if record, ok := ctx.Value(accessRecordKey{}).(*accessRecord); ok {
+		record.mutex.Lock()
+		if !slices.Contains(record.upstreams, service) {
+			record.upstreams = append(record.upstreams, service)
+		}
+		record.upstreamDuration = max(record.upstreamDuration, duration)
+		record.mutex.Unlock()
+	}
+}
+
+// milliseconds keeps sub-millisecond precision for fast requests
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// countingBody counts the request body bytes read by the handlers
+type countingBody struct {
+	io.ReadCloser
+	count int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+)
+
+// Deprecation describes a route on its way out. Responses carry it in the
+// Deprecation (RFC 9745), Sunset (RFC 8594) and Link headers.
+type Deprecation struct {
+	// Since is when the route was deprecated; zero only marks it deprecated
+	Since time.Time `json:"since,omitzero"`
+	// Sunset is when the route stops working
+	Sunset time.Time `json:"sunset,omitzero"`
+	// Successor is the route to use instead
+	Successor string `json:"successor,omitempty"`
+	// Docs explains the deprecation and how to migrate
+	Docs string `json:"docs,omitempty"`
+}
+
+// Deprecated marks every response of the handler with deprecation, and
+// logs who still calls it
+func Deprecated(deprecation Deprecation) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MarkDeprecated(w, r, deprecation)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MarkDeprecated sets the deprecation headers on the response to r and logs
+// the call with the client's address, user agent and user, so the callers
+// left can be found before the route is removed
+func MarkDeprecated(w http.ResponseWriter, r *http.Request, deprecation Deprecation) {
+	header := w.Header()
+	if deprecation.Since.IsZero() {
+		header.Set("Deprecation", "true")
+	} else {
+		header.Set("Deprecation", "@"+strconv.FormatInt(deprecation.Since.Unix(), 10))
+	}
+	if !deprecation.Sunset.IsZero() {
+		header.Set("Sunset", deprecation.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if deprecation.Successor != "" {
+		header.Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", deprecation.Successor))
+	}
+	if deprecation.Docs != "" {
+		header.Add("Link", fmt.Sprintf("<%s>; rel=\"deprecation\"", deprecation.Docs))
+	}
+
+	args := []any{
+		"method", r.Method,
+		"path", r.URL.Path,
+		"client_ip", getClientIP(r),
+		"user_agent", r.UserAgent(),
+	}
+	if userID := r.Header.Get("X-User-ID"); userID != "" && logger.GetUserID(r.Context()) == "" {
+		args = append(args, "user", userID)
+	}
+	if !deprecation.Sunset.IsZero() {
+		args = append(args, "sunset", deprecation.Sunset.Format(time.RFC3339))
+	}
+	logger.Warn(r.Context(), "Deprecated route used", args...)
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/errors"
+)
+
+// Envelope shapes the responses written with the errors and utils helpers:
+// wrapped in {status, message, data} or, with errors.EnvelopeRaw, as the
+// payload alone. Clients pick either with the X-Envelope header ("raw" or
+// "wrapped"); envelope is the shape when they do not. Install it for the
+// whole service, and again around routes that default to raw.
+func Envelope(envelope errors.Envelope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(w.Header().Values("Vary"), errors.EnvelopeHeader) {
+				w.Header().Add("Vary", errors.EnvelopeHeader)
+			}
+			chosen := envelope
+			if requested, ok := errors.ParseEnvelope(r.Header.Get(errors.EnvelopeHeader)); ok {
+				chosen = requested
+			}
+			next.ServeHTTP(errors.EnvelopeWriter(w, chosen), r)
+		})
+	}
+}
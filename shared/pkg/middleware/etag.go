@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// maxETagBody is the largest response ETag buffers; larger responses are
+// sent as they are written, without an ETag
+const maxETagBody = 1 << 20
+
+// ETag gives successful JSON responses to GET requests a weak ETag computed
+// from their body, and answers 304 Not Modified when it matches the
+// request's If-None-Match. An ETag set by the handler, such as one from an
+// upstream service, is kept and compared instead. Responses marked no-store,
+// over 1 MiB, or flushed while written are passed through unchanged.
+func ETag() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ew := &etagWriter{ResponseWriter: w, request: r}
+			next.ServeHTTP(ew, r)
+			ew.finish()
+		})
+	}
+}
+
+// etagWriter holds back a response until its ETag is known
+type etagWriter struct {
+	http.ResponseWriter
+	request *http.Request
+	status  int
+	body    bytes.Buffer
+	// passthrough is set once the response is being sent as written
+	passthrough bool
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (ew *etagWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+func (ew *etagWriter) WriteHeader(code int) {
+	if ew.status != 0 {
+		return
+	}
+	ew.status = code
+	if !ew.taggable() {
+		ew.passthrough = true
+		ew.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (ew *etagWriter) Write(b []byte) (int, error) {
+	if ew.status == 0 {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.passthrough {
+		return ew.ResponseWriter.Write(b)
+	}
+	if ew.body.Len()+len(b) > maxETagBody {
+		ew.release()
+		return ew.ResponseWriter.Write(b)
+	}
+	return ew.body.Write(b)
+}
+
+// Flush sends what is held back and passes the rest of the response
+// through, so streamed responses are not delayed
+func (ew *etagWriter) Flush() {
+	if ew.status == 0 {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if !ew.passthrough {
+		ew.release()
+	}
+	http.NewResponseController(ew.ResponseWriter).Flush()
+}
+
+// taggable reports whether the response, by its status and headers, gets an
+// ETag
+func (ew *etagWriter) taggable() bool {
+	header := ew.Header()
+	return ew.status == http.StatusOK &&
+		strings.HasPrefix(header.Get("Content-Type"), "application/json") &&
+		!strings.Contains(header.Get("Cache-Control"), "no-store")
+}
+
+// release writes the held back status and body and switches to passthrough
+func (ew *etagWriter) release() {
+	ew.passthrough = true
+	ew.ResponseWriter.WriteHeader(ew.status)
+	if ew.body.Len() > 0 {
+		ew.ResponseWriter.Write(ew.body.Bytes())
+		ew.body.Reset()
+	}
+}
+
+// finish writes the held back response, or 304 when the client has it
+func (ew *etagWriter) finish() {
+	if ew.passthrough || ew.status == 0 {
+		return
+	}
+
+	header := ew.Header()
+	etag := header.Get("ETag")
+	if etag == "" {
+		sum := sha256.Sum256(ew.body.Bytes())
+		etag = `W/"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+		header.Set("ETag", etag)
+	}
+
+	if etagMatches(ew.request.Header.Get("If-None-Match"), etag) {
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		ew.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+	ew.release()
+}
+
+// etagMatches reports whether the If-None-Match header value lists etag,
+// using the weak comparison RFC 9110 requires for If-None-Match
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
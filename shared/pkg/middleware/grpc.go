@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/identity"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor does for gRPC servers what Recovery, Tracing,
+// VerifyIdentity and Logging do for HTTP: it continues the caller's trace,
+// carries the request and correlation IDs, checks the signed identity in
+// x-user-identity, turns panics into Internal errors and logs every call.
+// With a nil signer identities are not checked.
+func UnaryServerInterceptor(signer *identity.Signer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		ctx = tracing.ExtractMetadata(ctx, md)
+		ctx, span := tracing.Tracer().Start(ctx, info.FullMethod,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("rpc.system", "grpc"),
+				attribute.String("rpc.method", info.FullMethod),
+			),
+		)
+		defer span.End()
+
+		if requestID := firstMetadata(md, "x-request-id"); requestID != "" {
+			ctx = logger.WithRequestID(ctx, requestID)
+		} else {
+			ctx, _ = logger.GetOrCreateRequestID(ctx)
+		}
+		if correlationID := firstMetadata(md, "x-correlation-id"); correlationID != "" {
+			ctx = logger.WithCorrelationID(ctx, correlationID)
+		} else {
+			ctx, _ = logger.GetOrCreateCorrelationID(ctx)
+		}
+
+		if value := firstMetadata(md, "x-user-identity"); signer != nil && value != "" {
+			id, verifyErr := signer.Verify(value)
+			if verifyErr != nil {
+				logger.Warn(ctx, "Rejected user identity", "method", info.FullMethod, "error", verifyErr)
+				return nil, status.Error(codes.Unauthenticated, "invalid user identity")
+			}
+			ctx = identity.WithIdentity(ctx, id)
+			ctx = logger.WithUserID(ctx, strconv.FormatUint(uint64(id.UserID), 10))
+		}
+
+		start := time.Now()
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				stack := make([]byte, 4096)
+				length := runtime.Stack(stack, false)
+				logger.Error(ctx, "Panic recovered",
+					"error", fmt.Sprintf("%v", recovered),
+					"stack", string(stack[:length]),
+					"method", info.FullMethod,
+				)
+				resp, err = nil, status.Error(codes.Internal, "internal server error")
+			}
+
+			code := status.Code(err)
+			span.SetAttributes(attribute.String("rpc.grpc.status_code", code.String()))
+			args := []any{
+				"method", info.FullMethod,
+				"code", code.String(),
+				"duration_ms", milliseconds(time.Since(start)),
+			}
+			switch code {
+			case codes.OK, codes.NotFound, codes.InvalidArgument, codes.Unauthenticated,
+				codes.PermissionDenied, codes.AlreadyExists, codes.FailedPrecondition:
+				logger.Info(ctx, "gRPC request", args...)
+			default:
+				tracing.RecordError(span, err)
+				logger.Error(ctx, "gRPC request failed", append(args, "error", err)...)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/identity"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+)
+
+// VerifyIdentity checks the signed identity the gateway attaches to
+// authenticated requests. A valid identity is attached to the request
+// context and replaces X-User-ID; a forged or expired one is answered with
+// 401. Requests without one continue anonymously, with any X-User-ID
+// removed. With a nil signer nothing is checked and X-User-ID is trusted.
+func VerifyIdentity(signer *identity.Signer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if signer == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := r.Header.Get(identity.Header)
+			if value == "" {
+				r.Header.Del("X-User-ID")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id, err := signer.Verify(value)
+			if err != nil {
+				logger.Warn(r.Context(), "Rejected user identity",
+					"path", r.URL.Path,
+					"client_ip", getClientIP(r),
+					"error", err,
+				)
+				errors.WriteErrorResponse(w, errors.NewUnauthorizedError("Invalid user identity", err))
+				return
+			}
+
+			userID := strconv.FormatUint(uint64(id.UserID), 10)
+			r.Header.Set("X-User-ID", userID)
+			ctx := identity.WithIdentity(r.Context(), id)
+			ctx = logger.WithUserID(ctx, userID)
+			RecordUser(ctx, userID)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/errors"
+)
+
+// Localize translates the messages of error responses into the language
+// the client asks for with Accept-Language, using catalog. Only responses
+// written with errors.WriteErrorResponse, including utils.SendError, are
+// translated; their codes stay as they are. Install it outside Recovery so
+// that panics are answered in the client's language too.
+func Localize(catalog *errors.Catalog) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Language")
+			if locale := catalog.Negotiate(r.Header.Get("Accept-Language")); locale != catalog.DefaultLocale() {
+				w = errors.LocalizeWriter(w, catalog, locale)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
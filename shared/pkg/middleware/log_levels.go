@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/identity"
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+)
+
+// LogLevelsRequest changes log levels. A component set to "" follows the
+// service level again; components not listed keep their level.
+type LogLevelsRequest struct {
+	Level      string            `json:"level,omitempty"`
+	Components map[string]string `json:"components,omitempty"`
+}
+
+// LogLevelsResponse lists the service level and the components with a
+// level of their own
+type LogLevelsResponse struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+}
+
+// LogLevels serves the log levels of a service: GET reads them and PUT
+// changes them without a restart. It does no authentication of its own and
+// must be mounted behind an admin check such as RequireAdmin.
+func LogLevels(levels *logger.Levels) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			if !changeLogLevels(w, r, levels) {
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		response := LogLevelsResponse{
+			Level:      levelName(levels.Level()),
+			Components: make(map[string]string),
+		}
+		for name, level := range levels.Components() {
+			response.Components[name] = levelName(level)
+		}
+		utils.SendSuccess(w, http.StatusOK, "Log levels retrieved successfully", response)
+	})
+}
+
+// changeLogLevels applies a LogLevelsRequest, all of it or, when any level
+// is invalid, none of it
+func changeLogLevels(w http.ResponseWriter, r *http.Request, levels *logger.Levels) bool {
+	var req LogLevelsRequest
+	if appErr := utils.DecodeJSON(r, &req); appErr != nil {
+		errors.WriteErrorResponse(w, appErr)
+		return false
+	}
+
+	var level *slog.Level
+	if req.Level != "" {
+		parsed, err := logger.ParseLevel(req.Level)
+		if err != nil {
+			utils.SendError(w, http.StatusBadRequest, err.Error())
+			return false
+		}
+		level = &parsed
+	}
+	components := make(map[string]*slog.Level, len(req.Components))
+	for name, value := range req.Components {
+		if strings.TrimSpace(name) == "" {
+			utils.SendError(w, http.StatusBadRequest, "Component name required")
+			return false
+		}
+		if value == "" {
+			components[name] = nil
+			continue
+		}
+		parsed, err := logger.ParseLevel(value)
+		if err != nil {
+			utils.SendError(w, http.StatusBadRequest, err.Error())
+			return false
+		}
+		components[name] = &parsed
+	}
+
+	if level != nil {
+		levels.SetLevel(*level)
+	}
+	for name, componentLevel := range components {
+		if componentLevel == nil {
+			levels.ResetComponent(name)
+		} else {
+			levels.SetComponent(name, *componentLevel)
+		}
+	}
+
+	logger.Warn(r.Context(), "Log levels changed",
+		"level", req.Level,
+		"components", req.Components,
+	)
+	return true
+}
+
+// RequireAdmin lets through requests whose verified identity has the admin
+// role; it runs after VerifyIdentity. Without a verified identity there is
+// no role to trust, so every request is refused.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := identity.FromContext(r.Context())
+		if !ok {
+			utils.SendError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		if !rbac.IsAdmin(id.Role) {
+			utils.SendError(w, http.StatusForbidden, "Admin access required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func levelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
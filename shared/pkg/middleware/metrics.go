@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/metrics"
+)
+
+// RouteMatcher finds the pattern a request is routed to, as
+// http.ServeMux.Handler does
+type RouteMatcher interface {
+	Handler(r *http.Request) (http.Handler, string)
+}
+
+// unmatchedRoute labels requests no route matched, so that scanners do
+// not add a series per path
+const unmatchedRoute = "unmatched"
+
+// Metrics records every request in registry: the http_requests_total
+// counter and the http_request_duration_seconds histogram, both labeled by
+// method and route pattern, and the http_requests_in_flight gauge. Routes
+// are the patterns of routes, such as "GET /users/{id}", so the number of
+// series stays bounded.
+func Metrics(registry *metrics.Registry, routes RouteMatcher) func(http.Handler) http.Handler {
+	requests := registry.Counter("http_requests_total", "Number of HTTP requests served.", "method", "route", "status")
+	duration := registry.Histogram("http_request_duration_seconds", "Time taken to serve HTTP requests.", nil, "method", "route")
+	inFlight := registry.Gauge("http_requests_in_flight", "Number of HTTP requests being served.").With()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := unmatchedRoute
+			if _, pattern := routes.Handler(r); pattern != "" {
+				route = pattern
+			}
+
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			start := time.Now()
+			wrapped := newResponseWriter(w)
+			next.ServeHTTP(wrapped, r)
+
+			duration.With(r.Method, route).Observe(time.Since(start).Seconds())
+			requests.With(r.Method, route, strconv.Itoa(wrapped.statusCode)).Inc()
+		})
+	}
+}
+
+// RegisterMetrics serves registry at GET /metrics on mux
+func RegisterMetrics(mux interface{ Handle(string, http.Handler) }, registry *metrics.Registry) {
+	mux.Handle("GET /metrics", registry.Handler())
+}
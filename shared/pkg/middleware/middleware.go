@@ -3,13 +3,20 @@ package middleware
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dhekaag/golang-microservices/shared/pkg/errors"
 	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Response writer wrapper
@@ -34,8 +41,21 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return size, err
 }
 
-// Logging middleware
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streamed responses
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Logging middleware, logging every request
 func Logging() func(http.Handler) http.Handler {
+	return LoggingWithConfig(LoggingConfig{SampleRate: 1})
+}
+
+// LoggingWithConfig writes one access-log line per sampled request, with
+// bytes in and out, total and upstream latency, the upstream services and
+// the user
+func LoggingWithConfig(config LoggingConfig) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
@@ -43,7 +63,14 @@ func Logging() func(http.Handler) http.Handler {
 			// Create request context with IDs
 			ctx, requestID := logger.GetOrCreateRequestID(r.Context())
 			ctx, correlationID := logger.GetOrCreateCorrelationID(ctx)
-			r = r.WithContext(ctx)
+			record := &accessRecord{}
+			r = r.WithContext(context.WithValue(ctx, accessRecordKey{}, record))
+
+			var body *countingBody
+			if r.Body != nil && r.Body != http.NoBody {
+				body = &countingBody{ReadCloser: r.Body}
+				r.Body = body
+			}
 
 			// Wrap response writer
 			wrapped := newResponseWriter(w)
@@ -52,19 +79,90 @@ func Logging() func(http.Handler) http.Handler {
 			wrapped.Header().Set("X-Request-ID", requestID)
 			wrapped.Header().Set("X-Correlation-ID", correlationID)
 
-			// Log request start
-			logger.Info(ctx, "Request started",
+			// Process request
+			next.ServeHTTP(wrapped, r)
+
+			duration := time.Since(start)
+			if !config.sampled(r.URL.Path, wrapped.statusCode, duration) {
+				return
+			}
+
+			var bytesIn int64
+			if body != nil {
+				bytesIn = body.count
+			}
+			args := []any{
 				"method", r.Method,
 				"path", r.URL.Path,
+				"status", wrapped.statusCode,
+				"duration_ms", milliseconds(duration),
+				"bytes_in", bytesIn,
+				"bytes_out", wrapped.size,
 				"remote_addr", getClientIP(r),
+			}
+
+			record.mutex.Lock()
+			if record.userID != "" && logger.GetUserID(ctx) == "" {
+				ctx = logger.WithUserID(ctx, record.userID)
+			}
+			if len(record.upstreams) > 0 {
+				args = append(args,
+					"upstream", strings.Join(record.upstreams, ","),
+					"upstream_ms", milliseconds(record.upstreamDuration),
+				)
+			}
+			record.mutex.Unlock()
+
+			logger.HTTPRequest(ctx, r.Method, r.URL.Path, wrapped.statusCode, duration, args...)
+		})
+	}
+}
+
+// Tracing starts a server span for each request, continuing the trace from
+// an incoming traceparent header. Place it outside Logging so log lines
+// carry the trace ID.
+func Tracing() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := tracing.Extract(r.Context(), r.Header)
+			ctx, span := tracing.Tracer().Start(ctx, "HTTP "+r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("url.path", r.URL.Path),
+					attribute.String("client.address", getClientIP(r)),
+				),
 			)
+			defer span.End()
 
-			// Process request
-			next.ServeHTTP(wrapped, r)
+			wrapped := newResponseWriter(w)
+			next.ServeHTTP(wrapped, r.WithContext(ctx))
 
-			// Log request completion
-			duration := time.Since(start)
-			logger.HTTPRequest(ctx, r.Method, r.URL.Path, wrapped.statusCode, duration)
+			span.SetAttributes(attribute.Int("http.response.status_code", wrapped.statusCode))
+			if wrapped.statusCode >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(wrapped.statusCode))
+			}
+		})
+	}
+}
+
+// TraceRoute names the span started by Tracing after the route of the
+// request, as in "GET /users/{id}", and records the route as http.route.
+// Place it where requests have the path routes matches against.
+func TraceRoute(routes RouteMatcher) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, pattern := routes.Handler(r); pattern != "" {
+				// Mux patterns may start with their method
+				route := pattern
+				if _, path, found := strings.Cut(pattern, " "); found {
+					route = path
+				}
+				span := trace.SpanFromContext(r.Context())
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(attribute.String("http.route", route))
+			}
+			next.ServeHTTP(w, r)
 		})
 	}
 }
@@ -102,7 +200,9 @@ func CORS() func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-None-Match, "+errors.EnvelopeHeader)
+			// Raw responses carry their pagination in headers
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(append([]string{"ETag"}, errors.MetaHeaders...), ", "))
 
 			if r.Method == "OPTIONS" {
 				w.WriteHeader(http.StatusOK)
@@ -126,6 +226,11 @@ func SecurityHeaders() func(http.Handler) http.Handler {
 	}
 }
 
+// Timeout runs the handler with a context cancelled after timeout. When
+// it expires before the handler has responded, the client gets 408 and
+// later writes by the handler fail with http.ErrHandlerTimeout; a response
+// already started is cut short instead. Panics in the handler reach the
+// caller's goroutine, so Recovery still sees them.
 func Timeout(timeout time.Duration) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -133,17 +238,34 @@ func Timeout(timeout time.Duration) func(http.Handler) http.Handler {
 			defer cancel()
 
 			r = r.WithContext(ctx)
+			tw := &timeoutWriter{ResponseWriter: w, header: w.Header().Clone()}
 
-			done := make(chan bool, 1)
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
 			go func() {
-				next.ServeHTTP(w, r)
-				done <- true
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next.ServeHTTP(tw, r)
+				close(done)
 			}()
 
 			select {
+			case p := <-panicked:
+				panic(p)
 			case <-done:
-				return
+				tw.finish()
 			case <-ctx.Done():
+				tw.mutex.Lock()
+				defer tw.mutex.Unlock()
+				tw.timedOut = true
+
+				if tw.wroteHeader {
+					logger.Warn(r.Context(), "Request timeout after the response started", "timeout", timeout.String())
+					return
+				}
 				logger.Warn(r.Context(), "Request timeout", "timeout", timeout.String())
 				appErr := errors.NewRequestTimeoutError("Request timeout", ctx.Err())
 				errors.WriteErrorResponse(w, appErr)
@@ -152,55 +274,335 @@ func Timeout(timeout time.Duration) func(http.Handler) http.Handler {
 	}
 }
 
-// Rate limiting middleware (simplified)
+// timeoutWriter guards the ResponseWriter shared by Timeout and the handler
+// goroutine. Handlers set headers on a copy of the response headers, which
+// replaces them when the status is written or the handler returns, so they
+// cannot race with the timeout response.
+type timeoutWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	mutex       sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	tw.writeHeader(code)
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	tw.copyHeader()
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+// finish passes on the headers of a handler that returned without writing,
+// for the implicit 200
+func (tw *timeoutWriter) finish() {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	if !tw.timedOut && !tw.wroteHeader {
+		tw.copyHeader()
+	}
+}
+
+func (tw *timeoutWriter) copyHeader() {
+	header := tw.ResponseWriter.Header()
+	clear(header)
+	for key, values := range tw.header {
+		header[key] = values
+	}
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeader(http.StatusOK)
+	return tw.ResponseWriter.Write(b)
+}
+
+// Flush keeps streamed responses flowing until the timeout
+func (tw *timeoutWriter) Flush() {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeader(http.StatusOK)
+	http.NewResponseController(tw.ResponseWriter).Flush()
+}
+
+// BodyLimitConfig caps request body sizes. The matching rule sets the limit
+// of a request; otherwise multipart requests and requests under UploadPaths
+// use UploadMaxBytes, and everything else uses MaxBytes.
+type BodyLimitConfig struct {
+	MaxBytes       int64
+	UploadMaxBytes int64
+	UploadPaths    []string
+	Rules          []BodyLimitRule
+}
+
+// BodyLimitRule sets the body limit of requests under PathPrefix, for the
+// listed methods or all of them. The longest matching prefix wins, and rules
+// listing methods win over catch-all rules with the same prefix. A MaxBytes
+// of 0 or less lifts the limit.
+type BodyLimitRule struct {
+	PathPrefix string
+	Methods    []string
+	MaxBytes   int64
+}
+
+// limit returns the body limit of r
+func (c BodyLimitConfig) limit(r *http.Request) int64 {
+	var rule *BodyLimitRule
+	for i := range c.Rules {
+		candidate := &c.Rules[i]
+		if !matchesPathPrefix(r.URL.Path, candidate.PathPrefix) ||
+			(len(candidate.Methods) > 0 && !slices.Contains(candidate.Methods, r.Method)) {
+			continue
+		}
+		if rule == nil || len(candidate.PathPrefix) > len(rule.PathPrefix) ||
+			(len(candidate.PathPrefix) == len(rule.PathPrefix) && len(candidate.Methods) > 0 && len(rule.Methods) == 0) {
+			rule = candidate
+		}
+	}
+	if rule != nil {
+		return rule.MaxBytes
+	}
+	if isUploadRequest(r, c.UploadPaths) {
+		return c.UploadMaxBytes
+	}
+	return c.MaxBytes
+}
+
+// BodyLimit rejects requests whose declared Content-Length exceeds the limit
+// with 413. Bodies of unknown length are wrapped in http.MaxBytesReader so
+// reads fail once the limit is crossed.
+func BodyLimit(config BodyLimitConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			limit := config.limit(r)
+			if limit <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.ContentLength > limit {
+				logger.Warn(r.Context(), "Request body too large",
+					"content_length", r.ContentLength,
+					"limit", limit,
+					"path", r.URL.Path,
+				)
+				appErr := errors.NewPayloadTooLargeError(fmt.Sprintf("Request body exceeds %d bytes", limit), nil)
+				errors.WriteErrorResponse(w, appErr)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isUploadRequest(r *http.Request, uploadPaths []string) bool {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		return true
+	}
+	for _, path := range uploadPaths {
+		if matchesPathPrefix(r.URL.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPathPrefix reports whether path is prefix or lies under it
+func matchesPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// defaultMaxClients bounds the clients a RateLimiter tracks when no bound
+// is given
+const defaultMaxClients = 10000
+
+// RateLimiter counts requests per client in a sliding window. Clients idle
+// for a whole window are dropped, and at most maxClients are tracked: a new
+// client beyond that evicts the one seen least recently. Idle clients are
+// found with the window of the current call, so a limiter should be used
+// with one window.
 type RateLimiter struct {
-	requests map[string][]time.Time
+	mu         sync.Mutex
+	requests   map[string][]time.Time
+	maxClients int
+	lastSweep  time.Time
 }
 
-func NewRateLimiter() *RateLimiter {
+// NewRateLimiter creates a limiter tracking up to maxClients clients, or
+// 10000 when maxClients is 0 or less
+func NewRateLimiter(maxClients int) *RateLimiter {
+	if maxClients <= 0 {
+		maxClients = defaultMaxClients
+	}
 	return &RateLimiter{
-		requests: make(map[string][]time.Time),
+		requests:   make(map[string][]time.Time),
+		maxClients: maxClients,
+		lastSweep:  time.Now(),
 	}
 }
 
 func (rl *RateLimiter) Allow(clientIP string, maxRequests int, window time.Duration) bool {
+	allowed, _ := rl.Take(clientIP, maxRequests, window)
+	return allowed
+}
+
+// Take counts a request of the client if the limit allows it, and returns
+// the limit as it stands afterwards
+func (rl *RateLimiter) Take(clientIP string, maxRequests int, window time.Duration) (bool, errors.RateLimit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
 	now := time.Now()
+	if now.Sub(rl.lastSweep) >= window {
+		rl.sweep(now, window)
+	}
+
+	// Drop requests that left the window
+	requests, exists := rl.requests[clientIP]
+	requests = slices.DeleteFunc(requests, func(req time.Time) bool {
+		return now.Sub(req) >= window
+	})
+
+	// Check if under limit, adding the current request
+	allowed := len(requests) < maxRequests
+	if allowed {
+		if !exists && len(rl.requests) >= rl.maxClients {
+			rl.evict(now, window)
+		}
+		requests = append(requests, now)
+	}
+	if len(requests) > 0 {
+		rl.requests[clientIP] = requests
+	} else {
+		delete(rl.requests, clientIP)
+	}
+
+	limit := errors.RateLimit{
+		Limit:     maxRequests,
+		Remaining: max(maxRequests-len(requests), 0),
+		Reset:     now.Add(window),
+	}
+	if len(requests) > 0 {
+		limit.Reset = requests[0].Add(window)
+	}
+	return allowed, limit
+}
 
-	// Clean old requests
-	if requests, exists := rl.requests[clientIP]; exists {
-		var validRequests []time.Time
+// Clients returns the number of clients being tracked
+func (rl *RateLimiter) Clients() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return len(rl.requests)
+}
+
+// Usage counts the clients with requests in the window and how many of
+// them have used up maxRequests
+func (rl *RateLimiter) Usage(maxRequests int, window time.Duration) (active, limited int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	for _, requests := range rl.requests {
+		inWindow := 0
 		for _, req := range requests {
 			if now.Sub(req) < window {
-				validRequests = append(validRequests, req)
+				inWindow++
 			}
 		}
-		rl.requests[clientIP] = validRequests
+		if inWindow > 0 {
+			active++
+		}
+		if inWindow >= maxRequests {
+			limited++
+		}
 	}
+	return active, limited
+}
 
-	// Check if under limit
-	if len(rl.requests[clientIP]) >= maxRequests {
-		return false
+// sweep drops the clients without requests in the window. Must be called
+// with the mutex held.
+func (rl *RateLimiter) sweep(now time.Time, window time.Duration) {
+	for clientIP, requests := range rl.requests {
+		if len(requests) == 0 || now.Sub(requests[len(requests)-1]) >= window {
+			delete(rl.requests, clientIP)
+		}
 	}
+	rl.lastSweep = now
+}
 
-	// Add current request
-	rl.requests[clientIP] = append(rl.requests[clientIP], now)
-	return true
+// evict makes room for a new client: it sweeps idle clients, and drops the
+// client whose last request is the oldest when none were idle. Must be
+// called with the mutex held.
+func (rl *RateLimiter) evict(now time.Time, window time.Duration) {
+	rl.sweep(now, window)
+	if len(rl.requests) < rl.maxClients {
+		return
+	}
+
+	var (
+		oldestClient string
+		oldest       time.Time
+	)
+	for clientIP, requests := range rl.requests {
+		last := requests[len(requests)-1]
+		if oldestClient == "" || last.Before(oldest) {
+			oldestClient = clientIP
+			oldest = last
+		}
+	}
+	delete(rl.requests, oldestClient)
 }
 
+// RateLimit allows each client IP maxRequests requests per window. Clients
+// are keyed by their TCP peer address: forwarded headers are set by the
+// client itself unless a trusted proxy rewrites them, so keying on them
+// would let every request pick a fresh limit.
 func RateLimit(maxRequests int, window time.Duration) func(http.Handler) http.Handler {
-	limiter := NewRateLimiter()
+	limiter := NewRateLimiter(0)
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			clientIP := getClientIP(r)
+			clientIP := peerIP(r)
 
-			if !limiter.Allow(clientIP, maxRequests, window) {
+			allowed, limit := limiter.Take(clientIP, maxRequests, window)
+			if !allowed {
 				logger.Warn(r.Context(), "Rate limit exceeded", "client_ip", clientIP)
-				appErr := errors.NewTooManyRequestsError("Rate limit exceeded", nil)
-				errors.WriteErrorResponse(w, appErr)
+				errors.WriteRateLimitResponse(w, "Rate limit exceeded", limit)
 				return
 			}
 
+			limit.SetHeaders(w.Header())
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -236,6 +638,15 @@ func getClientIP(r *http.Request) string {
 	return r.RemoteAddr
 }
 
+// peerIP is the host of the request's TCP peer
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 // Middleware chain helper
 func Chain(middlewares ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
 	return func(final http.Handler) http.Handler {
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterConcurrentAllow(t *testing.T) {
+	const (
+		limit      = 100
+		goroutines = 50
+		calls      = 10
+	)
+	limiter := NewRateLimiter(0)
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range calls {
+				if limiter.Allow("shared", limit, time.Minute) {
+					allowed.Add(1)
+				}
+				// Clients of their own, each within the limit
+				if !limiter.Allow(fmt.Sprintf("client-%d", i), limit, time.Minute) {
+					t.Errorf("client-%d limited below %d requests", i, limit)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != limit {
+		t.Errorf("allowed %d requests of the shared client, want %d", got, limit)
+	}
+	if got := limiter.Clients(); got != goroutines+1 {
+		t.Errorf("tracking %d clients, want %d", got, goroutines+1)
+	}
+}
+
+func TestRateLimiterEvictsAtMaxClients(t *testing.T) {
+	limiter := NewRateLimiter(3)
+	for _, client := range []string{"a", "b", "c"} {
+		if !limiter.Allow(client, 1, time.Minute) {
+			t.Fatalf("first request of %s limited", client)
+		}
+	}
+
+	// A fourth client evicts the one seen least recently
+	if !limiter.Allow("d", 1, time.Minute) {
+		t.Fatal("first request of d limited")
+	}
+	if got := limiter.Clients(); got != 3 {
+		t.Errorf("tracking %d clients, want 3", got)
+	}
+	if limiter.Allow("b", 1, time.Minute) {
+		t.Error("b was evicted instead of a")
+	}
+	if !limiter.Allow("a", 1, time.Minute) {
+		t.Error("a is still limited after its eviction")
+	}
+}
+
+func TestRateLimiterSweepsIdleClients(t *testing.T) {
+	const window = 20 * time.Millisecond
+	limiter := NewRateLimiter(0)
+	limiter.Allow("idle", 1, window)
+
+	time.Sleep(2 * window)
+	limiter.Allow("active", 1, window)
+	if got := limiter.Clients(); got != 1 {
+		t.Errorf("tracking %d clients, want the idle one dropped", got)
+	}
+}
+
+func TestRateLimitIgnoresForwardedHeaders(t *testing.T) {
+	handler := RateLimit(2, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := range 3 {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:" + fmt.Sprint(40000+i)
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		req.Header.Set("X-Real-IP", fmt.Sprintf("203.0.113.%d", i))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		want := http.StatusOK
+		if i == 2 {
+			want = http.StatusTooManyRequests
+		}
+		if rec.Code != want {
+			t.Errorf("request %d: status %d, want %d", i+1, rec.Code, want)
+		}
+	}
+
+	// Another peer has a limit of its own
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.2:40000"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("other peer: status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestTimeoutKeepsHeadersOfHandlersNotWriting(t *testing.T) {
+	handler := Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handled", "yes")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("X-Handled"); got != "yes" {
+		t.Errorf("X-Handled %q, want the header set by the handler", got)
+	}
+}
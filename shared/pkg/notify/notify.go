@@ -0,0 +1,90 @@
+// Package notify hands emails to the notification service, which renders
+// and delivers them
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/logger"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+)
+
+// Message is an email for the notification service to render and deliver.
+// Template names the email; Data fills it in.
+type Message struct {
+	To       string         `json:"to"`
+	Template string         `json:"template"`
+	Subject  string         `json:"subject"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+// Config points at the notification service. Without a URL messages are
+// only logged, which is meant for development.
+type Config struct {
+	URL     string
+	Timeout time.Duration
+}
+
+// Notifier hands emails to the notification service
+type Notifier struct {
+	url    string
+	client *http.Client
+}
+
+func New(cfg Config) *Notifier {
+	return &Notifier{
+		url:    cfg.URL,
+		client: tracing.NewClient("notifier", cfg.Timeout, nil),
+	}
+}
+
+// Configured reports whether messages are delivered rather than logged
+func (n *Notifier) Configured() bool {
+	return n.url != ""
+}
+
+// Send posts msg to the notification service. Without a configured URL the
+// message, including its data, is only logged.
+func (n *Notifier) Send(ctx context.Context, msg Message) error {
+	if n.url == "" {
+		logger.Info(ctx, "Notification not delivered; NOTIFICATION_URL is not set",
+			"to", msg.To,
+			"template", msg.Template,
+			"data", msg.Data,
+		)
+		return nil
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if requestID := logger.GetRequestID(ctx); requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+	if correlationID := logger.GetCorrelationID(ctx); correlationID != "" {
+		req.Header.Set("X-Correlation-ID", correlationID)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("notification service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// Package rbac defines the roles users can hold, across the platform and
+// within groups, and the permissions each role grants. Services check permissions rather than comparing role names,
+// so a new role only has to be added here.
+package rbac
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Role is a normalized role name as stored by the user service
+type Role string
+
+const (
+	RoleUser  Role = "USER"
+	RoleAdmin Role = "ADMIN"
+)
+
+// Permission allows an action on a resource, written "resource:action"
+type Permission string
+
+const (
+	UsersRead     Permission = "users:read"
+	UsersWrite    Permission = "users:write"
+	ProductsRead  Permission = "products:read"
+	ProductsWrite Permission = "products:write"
+	OrdersRead    Permission = "orders:read"
+	OrdersWrite   Permission = "orders:write"
+	OrdersRefund  Permission = "orders:refund"
+	GatewayAdmin  Permission = "gateway:admin"
+)
+
+// allPermissions lists every known permission
+var allPermissions = []Permission{
+	UsersRead,
+	UsersWrite,
+	ProductsRead,
+	ProductsWrite,
+	OrdersRead,
+	OrdersWrite,
+	OrdersRefund,
+	GatewayAdmin,
+}
+
+// rolePermissions maps each role to what it may do. Permissions on a
+// user's own profile, cart and orders are enforced by the services and not
+// listed here; these grant access across all users.
+var rolePermissions = map[Role][]Permission{
+	RoleUser:  {ProductsRead},
+	RoleAdmin: allPermissions,
+}
+
+// NormalizeRole maps role values such as "admin" or " Admin " to the
+// canonical Role. The empty string is RoleUser.
+func NormalizeRole(role string) Role {
+	role = strings.ToUpper(strings.TrimSpace(role))
+	if role == "" {
+		return RoleUser
+	}
+	return Role(role)
+}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can reports whether r grants permission
+func (r Role) Can(permission Permission) bool {
+	return slices.Contains(rolePermissions[r], permission)
+}
+
+// Permissions returns the permissions r grants
+func (r Role) Permissions() []Permission {
+	return slices.Clone(rolePermissions[r])
+}
+
+// IsAdmin reports whether role, in any spelling, is the admin role
+func IsAdmin(role string) bool {
+	return NormalizeRole(role) == RoleAdmin
+}
+
+// HasPermission reports whether role, in any spelling, grants permission
+func HasPermission(role string, permission Permission) bool {
+	return NormalizeRole(role).Can(permission)
+}
+
+// ParsePermission validates a permission name from configuration
+func ParsePermission(name string) (Permission, error) {
+	permission := Permission(strings.ToLower(strings.TrimSpace(name)))
+	if !slices.Contains(allPermissions, permission) {
+		return "", fmt.Errorf("unknown permission %q", name)
+	}
+	return permission, nil
+}
+
+// GroupRole is a normalized role a user holds within a group
+type GroupRole string
+
+const (
+	GroupOwner  GroupRole = "OWNER"
+	GroupAdmin  GroupRole = "ADMIN"
+	GroupMember GroupRole = "MEMBER"
+)
+
+// groupRoleRanks orders group roles; each role can do what lower ones can
+var groupRoleRanks = map[GroupRole]int{
+	GroupMember: 1,
+	GroupAdmin:  2,
+	GroupOwner:  3,
+}
+
+// NormalizeGroupRole maps group role values such as "owner" to the
+// canonical GroupRole. The empty string is GroupMember.
+func NormalizeGroupRole(role string) GroupRole {
+	role = strings.ToUpper(strings.TrimSpace(role))
+	if role == "" {
+		return GroupMember
+	}
+	return GroupRole(role)
+}
+
+// Valid reports whether r is a known group role
+func (r GroupRole) Valid() bool {
+	_, ok := groupRoleRanks[r]
+	return ok
+}
+
+// AtLeast reports whether r is minimum or a higher role
+func (r GroupRole) AtLeast(minimum GroupRole) bool {
+	rank, ok := groupRoleRanks[r]
+	return ok && rank >= groupRoleRanks[minimum]
+}
+
+// Membership is a group a user belongs to and their role in it
+type Membership struct {
+	GroupID uint      `json:"id"`
+	Slug    string    `json:"slug"`
+	Role    GroupRole `json:"role"`
+}
+
+// FindMembership returns the membership in group, given by ID or slug
+func FindMembership(memberships []Membership, group string) (Membership, bool) {
+	for _, membership := range memberships {
+		if membership.Slug == group || strconv.FormatUint(uint64(membership.GroupID), 10) == group {
+			return membership, true
+		}
+	}
+	return Membership{}, false
+}
@@ -0,0 +1,119 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encryptedPrefix marks values written by a sessionCipher:
+// "enc:<key id>:<base64 nonce and ciphertext>"
+const encryptedPrefix = "enc:"
+
+var errUnknownSessionKey = errors.New("unknown session encryption key")
+
+// sessionCipher encrypts stored sessions and refresh token families with
+// AES-GCM. Values are sealed with the first key and opened with whichever
+// key they name, so keys can be rotated without ending sessions.
+type sessionCipher struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+}
+
+// newSessionCipher parses "<id>:<base64 key>" entries, returning nil when
+// there are none. Keys must be 16, 24 or 32 bytes long.
+func newSessionCipher(entries []string) (*sessionCipher, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	sc := &sessionCipher{keys: make(map[string]cipher.AEAD, len(entries))}
+	for i, entry := range entries {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("session encryption key %d must look like <id>:<base64 key>", i+1)
+		}
+		if _, exists := sc.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate session encryption key id %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("session encryption key %q is not valid base64: %w", id, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("session encryption key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("session encryption key %q: %w", id, err)
+		}
+		sc.keys[id] = aead
+		if i == 0 {
+			sc.currentID = id
+		}
+	}
+	return sc, nil
+}
+
+func (sc *sessionCipher) seal(plaintext []byte) (string, error) {
+	aead := sc.keys[sc.currentID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return encryptedPrefix + sc.currentID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (sc *sessionCipher) open(data string) ([]byte, error) {
+	id, encoded, _ := strings.Cut(strings.TrimPrefix(data, encryptedPrefix), ":")
+	aead, ok := sc.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", errUnknownSessionKey, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted session")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt session: %w", err)
+	}
+	return plaintext, nil
+}
+
+// encode marshals value for storage, encrypting it when encryption is on
+func (sm *SessionManager) encode(value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	if sm.cipher == nil {
+		return string(data), nil
+	}
+	return sm.cipher.seal(data)
+}
+
+// decode reverses encode. Plaintext values written before encryption was
+// turned on are still read; they are encrypted the next time they are saved.
+func (sm *SessionManager) decode(data string, value any) error {
+	raw := []byte(data)
+	if strings.HasPrefix(data, encryptedPrefix) {
+		if sm.cipher == nil {
+			return errors.New("session is encrypted but no encryption keys are configured")
+		}
+		plaintext, err := sm.cipher.open(data)
+		if err != nil {
+			return err
+		}
+		raw = plaintext
+	}
+	return json.Unmarshal(raw, value)
+}
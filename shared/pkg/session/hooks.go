@@ -0,0 +1,60 @@
+package session
+
+import "context"
+
+// Event describes a session that was created, deleted or found expired
+type Event struct {
+	SessionID string
+	UserID    uint
+	// Session is nil for sessions found expired in the user index, whose
+	// data is already gone
+	Session *UserSession
+}
+
+// Hook observes session lifecycle events. Hooks run synchronously in the
+// request that caused the event, so slow work belongs in a goroutine.
+type Hook func(ctx context.Context, event Event)
+
+type hooks struct {
+	create []Hook
+	delete []Hook
+	expire []Hook
+}
+
+// OnCreate registers hook to run after a session is created with
+// StartSession or CreateSession. Refreshing a session replaces its ID without running
+// OnCreate. Hooks are not safe to add while sessions are in use.
+func (sm *SessionManager) OnCreate(hook Hook) {
+	sm.hooks.create = append(sm.hooks.create, hook)
+}
+
+// OnDelete registers hook to run after a session is ended: on logout or
+// revocation, when all of a user's sessions are deleted, or when reuse of
+// its refresh token revokes it. Hooks are not safe to add while sessions
+// are in use.
+func (sm *SessionManager) OnDelete(hook Hook) {
+	sm.hooks.delete = append(sm.hooks.delete, hook)
+}
+
+// OnExpireDetected registers hook to run when the manager comes across an
+// expired session: a remember-me session past its lifetime, or an entry of
+// the user index whose session the store already expired. Sessions that
+// expire unnoticed produce no event. Hooks are not safe to add while
+// sessions are in use.
+func (sm *SessionManager) OnExpireDetected(hook Hook) {
+	sm.hooks.expire = append(sm.hooks.expire, hook)
+}
+
+func runHooks(ctx context.Context, hooks []Hook, event Event) {
+	for _, hook := range hooks {
+		hook(ctx, event)
+	}
+}
+
+// reportExpired runs the expire hooks for index entries of the user whose
+// sessions had expired
+func (sm *SessionManager) reportExpired(ctx context.Context, userID uint, sessionIDs []string) {
+	for _, sessionID := range sessionIDs {
+		runHooks(ctx, sm.hooks.expire, Event{SessionID: sessionID, UserID: userID})
+	}
+}
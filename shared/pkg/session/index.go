@@ -0,0 +1,84 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrSessionNotFound is returned for unknown or expired sessions
+var ErrSessionNotFound = errors.New("session not found")
+
+// PublicID derives an identifier for a session that can be shown to users
+// without handing out the session ID, which is a credential
+func PublicID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// errStopWalk ends walkSessions early without failing it
+var errStopWalk = errors.New("stop walk")
+
+// walkSessions calls fn with every live session of every user, stopping
+// at the first error fn returns other than errStopWalk
+func (sm *SessionManager) walkSessions(ctx context.Context, fn func(sessionID string, userSession *UserSession) error) error {
+	err := sm.store.Walk(ctx, func(userID uint, sessions map[string]string, expired []string) error {
+		sm.reportExpired(ctx, userID, expired)
+		for sessionID, data := range sessions {
+			var userSession UserSession
+			if err := sm.decode(data, &userSession); err != nil {
+				return fmt.Errorf("failed to unmarshal user session: %w", err)
+			}
+			if err := fn(sessionID, &userSession); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errStopWalk) {
+		return nil
+	}
+	return err
+}
+
+// FindSession looks up a session of any user by its public ID, returning
+// the session ID and the session, or an error when there is none
+func (sm *SessionManager) FindSession(ctx context.Context, publicID string) (string, *UserSession, error) {
+	var foundID string
+	var found *UserSession
+	err := sm.walkSessions(ctx, func(sessionID string, userSession *UserSession) error {
+		if PublicID(sessionID) != publicID {
+			return nil
+		}
+		foundID, found = sessionID, userSession
+		return errStopWalk
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if found == nil {
+		return "", nil, ErrSessionNotFound
+	}
+	return foundID, found, nil
+}
+
+// GetUserSessions returns the user's active sessions by session ID
+func (sm *SessionManager) GetUserSessions(ctx context.Context, userID uint) (map[string]*UserSession, error) {
+	values, expired, err := sm.store.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	sm.reportExpired(ctx, userID, expired)
+
+	sessions := make(map[string]*UserSession, len(values))
+	for sessionID, data := range values {
+		var userSession UserSession
+		if err := sm.decode(data, &userSession); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal user session: %w", err)
+		}
+		sessions[sessionID] = &userSession
+	}
+	return sessions, nil
+}
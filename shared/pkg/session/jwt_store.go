@@ -0,0 +1,219 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// sessionAudience sets session tokens apart from other JWTs signed with
+// the same secret
+const sessionAudience = "session"
+
+// JWTStore keeps sessions in HS256-signed JWTs held by the client, which
+// present them as session IDs. Sessions are as private as the manager
+// makes them: encrypt them with SessionConfig.EncryptionKeys, or their
+// contents can be read by the client. Refresh token families stay in
+// Redis as with RedisStore, next to a denylist of revoked sessions:
+//
+//	<prefix>-revoked:<token id>       a revoked session, until it expires
+//	<prefix>-revoked-user:<id>        when all sessions of a user were
+//	                                  revoked, in Unix milliseconds
+//
+// Reading a session costs one MGET against the denylist; nothing is
+// written while it is used. In exchange, sessions cannot be listed, so
+// ListByUser and Walk find none, and a session cannot be changed once
+// started: its last-seen time stays the time of login or refresh.
+type JWTStore struct {
+	families *RedisStore
+	secret   []byte
+	// maxTTL is the longest a token lives, and so how long revocations of
+	// all sessions of a user are kept
+	maxTTL time.Duration
+}
+
+// sealedSession are the claims of a session token
+type sealedSession struct {
+	UserID uint   `json:"uid"`
+	Data   string `json:"dat"`
+	// IssuedMillis orders the token against revocations of all sessions of
+	// the user more finely than the seconds of the iat claim
+	IssuedMillis int64 `json:"iat_ms"`
+	jwt.RegisteredClaims
+}
+
+// NewJWTStore signs sessions with secret, at least 32 bytes long, keeping
+// families and revocations under prefix in client. Tokens live at most
+// maxTTL. Closing the store closes the client.
+func NewJWTStore(client redis.UniversalClient, prefix, secret string, maxTTL time.Duration) (*JWTStore, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("session JWT secret must be at least 32 bytes")
+	}
+	return &JWTStore{
+		families: NewRedisStore(client, prefix),
+		secret:   []byte(secret),
+		maxTTL:   maxTTL,
+	}, nil
+}
+
+func (s *JWTStore) revokedKey(tokenID string) string {
+	return fmt.Sprintf("%s-revoked:%s", s.families.prefix, tokenID)
+}
+
+func (s *JWTStore) revokedUserKey(userID uint) string {
+	return fmt.Sprintf("%s-revoked-user:%d", s.families.prefix, userID)
+}
+
+func (s *JWTStore) Seal(ctx context.Context, userID uint, data string, ttl time.Duration) (string, error) {
+	tokenID, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := sealedSession{
+		UserID:       userID,
+		Data:         data,
+		IssuedMillis: now.UnixMilli(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Audience:  jwt.ClaimStrings{sessionAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(min(ttl, s.maxTTL))),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, &claims).SignedString(s.secret)
+}
+
+// parse verifies the signature and expiry of a session token
+func (s *JWTStore) parse(sessionID string) (*sealedSession, error) {
+	var claims sealedSession
+	_, err := jwt.ParseWithClaims(sessionID, &claims, func(*jwt.Token) (any, error) {
+		return s.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(sessionAudience),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// Create has nothing to write: the session is sealed into its ID
+func (s *JWTStore) Create(ctx context.Context, sessionID string, userID uint, data string, ttl time.Duration) error {
+	return nil
+}
+
+// Get opens the session token, rejecting it once revoked
+func (s *JWTStore) Get(ctx context.Context, sessionID string) (string, error) {
+	claims, err := s.parse(sessionID)
+	if err != nil {
+		return "", ErrSessionNotFound
+	}
+
+	revoked, err := s.families.mget(ctx, s.revokedKey(claims.ID), s.revokedUserKey(claims.UserID))
+	if err != nil {
+		return "", err
+	}
+	if revoked[0] != nil {
+		return "", ErrSessionNotFound
+	}
+	if revokedAt, ok := revoked[1].(string); ok {
+		if millis, err := strconv.ParseInt(revokedAt, 10, 64); err == nil && claims.IssuedMillis <= millis {
+			return "", ErrSessionNotFound
+		}
+	}
+	return claims.Data, nil
+}
+
+// Update cannot change a session held by the client, so it is a no-op
+func (s *JWTStore) Update(ctx context.Context, sessionID string, userID uint, data string, ttl time.Duration) error {
+	return nil
+}
+
+// Delete revokes the session until its token expires
+func (s *JWTStore) Delete(ctx context.Context, sessionID string, userID uint) error {
+	pipe := s.families.txPipeline()
+	s.queueRevoke(ctx, pipe, sessionID)
+	if pipe.Len() == 0 {
+		return nil
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// queueRevoke queues adding the session to the denylist. Invalid and
+// expired tokens are rejected anyway and are left out.
+func (s *JWTStore) queueRevoke(ctx context.Context, pipe redis.Pipeliner, sessionID string) {
+	claims, err := s.parse(sessionID)
+	if err != nil {
+		return
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl > 0 {
+		pipe.Set(ctx, s.revokedKey(claims.ID), 1, ttl)
+	}
+}
+
+// ListByUser finds no sessions: they are not kept on the server
+func (s *JWTStore) ListByUser(ctx context.Context, userID uint) (map[string]string, []string, error) {
+	return map[string]string{}, nil, nil
+}
+
+// Walk finds no sessions: they are not kept on the server
+func (s *JWTStore) Walk(ctx context.Context, fn func(userID uint, sessions map[string]string, expired []string) error) error {
+	return nil
+}
+
+// DeleteByUser revokes every session issued to the user so far, which it
+// cannot list, and deletes the user's refresh token families
+func (s *JWTStore) DeleteByUser(ctx context.Context, userID uint) error {
+	if err := s.families.client.Set(ctx, s.revokedUserKey(userID), time.Now().UnixMilli(), s.maxTTL).Err(); err != nil {
+		return err
+	}
+	return s.families.DeleteByUser(ctx, userID)
+}
+
+func (s *JWTStore) CreateFamily(ctx context.Context, familyID string, userID uint, tokenHash, data string, ttl time.Duration) error {
+	return s.families.CreateFamily(ctx, familyID, userID, tokenHash, data, ttl)
+}
+
+func (s *JWTStore) GetFamily(ctx context.Context, familyID string) (string, time.Duration, error) {
+	return s.families.GetFamily(ctx, familyID)
+}
+
+func (s *JWTStore) TakeToken(ctx context.Context, tokenHash string) (string, error) {
+	return s.families.TakeToken(ctx, tokenHash)
+}
+
+func (s *JWTStore) UsedToken(ctx context.Context, tokenHash string) (string, error) {
+	return s.families.UsedToken(ctx, tokenHash)
+}
+
+// RotateFamily revokes the old session; the new one is already sealed
+func (s *JWTStore) RotateFamily(ctx context.Context, rotation Rotation) error {
+	pipe := s.families.txPipeline()
+	s.queueRevoke(ctx, pipe, rotation.OldSessionID)
+	s.families.queueFamilyRotation(ctx, pipe, rotation)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *JWTStore) DeleteFamily(ctx context.Context, familyID string, userID uint, tokenHash, sessionID string) error {
+	pipe := s.families.txPipeline()
+	s.families.queueDeleteFamily(ctx, pipe, familyID, userID, tokenHash)
+	s.queueRevoke(ctx, pipe, sessionID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *JWTStore) Close() error {
+	return s.families.Close()
+}
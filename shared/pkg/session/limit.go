@@ -0,0 +1,59 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"slices"
+)
+
+// Policies for a user signing in with SessionConfig.MaxSessions sessions
+// already active
+const (
+	// LimitEvict ends the user's oldest sessions to make room
+	LimitEvict = "evict"
+	// LimitReject refuses the new session with ErrTooManySessions
+	LimitReject = "reject"
+)
+
+// ErrTooManySessions is returned when a user at the session limit signs in
+// and the policy is LimitReject
+var ErrTooManySessions = errors.New("too many active sessions")
+
+// makeRoom applies the session limit before a new session of the user is
+// created, going by the user's index. Logins racing each other may both
+// pass, leaving the user a session over the limit until the next login.
+func (sm *SessionManager) makeRoom(ctx context.Context, userID uint) error {
+	if sm.maxSessions <= 0 {
+		return nil
+	}
+
+	userSessions, err := sm.GetUserSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	excess := len(userSessions) - sm.maxSessions + 1
+	if excess <= 0 {
+		return nil
+	}
+	if sm.limitPolicy == LimitReject {
+		return ErrTooManySessions
+	}
+
+	sessionIDs := make([]string, 0, len(userSessions))
+	for sessionID := range userSessions {
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	// Oldest first; sessions from before CreatedAt was recorded come first
+	slices.SortFunc(sessionIDs, func(a, b string) int {
+		if c := userSessions[a].CreatedAt.Compare(userSessions[b].CreatedAt); c != 0 {
+			return c
+		}
+		return userSessions[a].LastSeen.Compare(userSessions[b].LastSeen)
+	})
+	for _, sessionID := range sessionIDs[:excess] {
+		if err := sm.DeleteSession(ctx, sessionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is how often writes drop expired entries from a
+// MemoryStore, so entries nobody reads again do not pile up
+const memorySweepInterval = time.Minute
+
+// MemoryStore keeps sessions in the process, for running a single instance
+// without Redis. Sessions are lost on restart and not shared between
+// instances.
+type MemoryStore struct {
+	mu           sync.Mutex
+	sessions     map[string]memorySession
+	userSessions map[uint]map[string]struct{}
+	families     map[string]memoryEntry
+	userFamilies map[uint]map[string]struct{}
+	// tokens and used map refresh token hashes to their family
+	tokens    map[string]memoryEntry
+	used      map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	data    string
+	expires time.Time
+}
+
+type memorySession struct {
+	memoryEntry
+	userID uint
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !now.Before(e.expires)
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions:     make(map[string]memorySession),
+		userSessions: make(map[uint]map[string]struct{}),
+		families:     make(map[string]memoryEntry),
+		userFamilies: make(map[uint]map[string]struct{}),
+		tokens:       make(map[string]memoryEntry),
+		used:         make(map[string]memoryEntry),
+		lastSweep:    time.Now(),
+	}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, sessionID string, userID uint, data string, ttl time.Duration) error {
+	return s.Update(ctx, sessionID, userID, data, ttl)
+}
+
+func (s *MemoryStore) Get(ctx context.Context, sessionID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok || session.expired(time.Now()) {
+		return "", ErrSessionNotFound
+	}
+	return session.data, nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, sessionID string, userID uint, data string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.maybeSweep(now)
+	s.setSession(now, sessionID, userID, data, ttl)
+	return nil
+}
+
+func (s *MemoryStore) setSession(now time.Time, sessionID string, userID uint, data string, ttl time.Duration) {
+	if previous, ok := s.sessions[sessionID]; ok && previous.userID != userID {
+		removeMember(s.userSessions, previous.userID, sessionID)
+	}
+	s.sessions[sessionID] = memorySession{
+		memoryEntry: memoryEntry{data: data, expires: now.Add(ttl)},
+		userID:      userID,
+	}
+	addMember(s.userSessions, userID, sessionID)
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, sessionID string, userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteSession(sessionID, userID)
+	return nil
+}
+
+func (s *MemoryStore) deleteSession(sessionID string, userID uint) {
+	delete(s.sessions, sessionID)
+	removeMember(s.userSessions, userID, sessionID)
+}
+
+func (s *MemoryStore) ListByUser(ctx context.Context, userID uint) (map[string]string, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	sessions := make(map[string]string, len(s.userSessions[userID]))
+	var expired []string
+	for sessionID := range s.userSessions[userID] {
+		session, ok := s.sessions[sessionID]
+		if !ok || session.expired(now) {
+			s.deleteSession(sessionID, userID)
+			expired = append(expired, sessionID)
+			continue
+		}
+		sessions[sessionID] = session.data
+	}
+	return sessions, expired, nil
+}
+
+// Walk lists the users first, so fn may use the store
+func (s *MemoryStore) Walk(ctx context.Context, fn func(userID uint, sessions map[string]string, expired []string) error) error {
+	s.mu.Lock()
+	userIDs := make([]uint, 0, len(s.userSessions))
+	for userID := range s.userSessions {
+		userIDs = append(userIDs, userID)
+	}
+	s.mu.Unlock()
+
+	for _, userID := range userIDs {
+		sessions, expired, err := s.ListByUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := fn(userID, sessions, expired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteByUser(ctx context.Context, userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sessionID := range s.userSessions[userID] {
+		delete(s.sessions, sessionID)
+	}
+	for familyID := range s.userFamilies[userID] {
+		delete(s.families, familyID)
+	}
+	delete(s.userSessions, userID)
+	delete(s.userFamilies, userID)
+	return nil
+}
+
+func (s *MemoryStore) CreateFamily(ctx context.Context, familyID string, userID uint, tokenHash, data string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.maybeSweep(now)
+	expires := now.Add(ttl)
+	s.families[familyID] = memoryEntry{data: data, expires: expires}
+	s.tokens[tokenHash] = memoryEntry{data: familyID, expires: expires}
+	addMember(s.userFamilies, userID, familyID)
+	return nil
+}
+
+func (s *MemoryStore) GetFamily(ctx context.Context, familyID string) (string, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	family, ok := s.families[familyID]
+	if !ok || family.expired(now) {
+		return "", 0, ErrInvalidRefreshToken
+	}
+	return family.data, family.expires.Sub(now), nil
+}
+
+func (s *MemoryStore) TakeToken(ctx context.Context, tokenHash string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[tokenHash]
+	delete(s.tokens, tokenHash)
+	if !ok || token.expired(time.Now()) {
+		return "", ErrInvalidRefreshToken
+	}
+	return token.data, nil
+}
+
+func (s *MemoryStore) UsedToken(ctx context.Context, tokenHash string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.used[tokenHash]
+	if !ok || token.expired(time.Now()) {
+		return "", ErrInvalidRefreshToken
+	}
+	return token.data, nil
+}
+
+func (s *MemoryStore) RotateFamily(ctx context.Context, rotation Rotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.maybeSweep(now)
+	s.deleteSession(rotation.OldSessionID, rotation.UserID)
+	s.setSession(now, rotation.SessionID, rotation.UserID, rotation.SessionData, rotation.SessionTTL)
+
+	expires := now.Add(rotation.FamilyTTL)
+	if family, ok := s.families[rotation.FamilyID]; ok {
+		expires = family.expires
+	}
+	s.families[rotation.FamilyID] = memoryEntry{data: rotation.FamilyData, expires: expires}
+	s.tokens[rotation.TokenHash] = memoryEntry{data: rotation.FamilyID, expires: expires}
+	s.used[rotation.UsedTokenHash] = memoryEntry{data: rotation.FamilyID, expires: expires}
+	return nil
+}
+
+func (s *MemoryStore) DeleteFamily(ctx context.Context, familyID string, userID uint, tokenHash, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.families, familyID)
+	delete(s.tokens, tokenHash)
+	s.deleteSession(sessionID, userID)
+	removeMember(s.userFamilies, userID, familyID)
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// maybeSweep drops expired entries at most once per memorySweepInterval.
+// The caller holds the lock.
+func (s *MemoryStore) maybeSweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for sessionID, session := range s.sessions {
+		if session.expired(now) {
+			s.deleteSession(sessionID, session.userID)
+		}
+	}
+	for hash, token := range s.tokens {
+		if token.expired(now) {
+			delete(s.tokens, hash)
+		}
+	}
+	for hash, token := range s.used {
+		if token.expired(now) {
+			delete(s.used, hash)
+		}
+	}
+	for familyID, family := range s.families {
+		if family.expired(now) {
+			delete(s.families, familyID)
+		}
+	}
+	// Families are indexed under their user only, so drop the index entries
+	// whose family is gone
+	for userID, familyIDs := range s.userFamilies {
+		for familyID := range familyIDs {
+			if _, ok := s.families[familyID]; !ok {
+				removeMember(s.userFamilies, userID, familyID)
+			}
+		}
+	}
+}
+
+func addMember[K comparable](sets map[K]map[string]struct{}, key K, member string) {
+	set, ok := sets[key]
+	if !ok {
+		set = make(map[string]struct{})
+		sets[key] = set
+	}
+	set[member] = struct{}{}
+}
+
+// removeMember drops member from the set at key, and the set once empty
+func removeMember[K comparable](sets map[K]map[string]struct{}, key K, member string) {
+	set, ok := sets[key]
+	if !ok {
+		return
+	}
+	delete(set, member)
+	if len(set) == 0 {
+		delete(sets, key)
+	}
+}
@@ -0,0 +1,401 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps sessions in Redis, where every gateway instance sees
+// them. Sessions are indexed by user so a user's sessions can be listed
+// and revoked without scanning the keyspace:
+//
+//	<prefix>:<session id>           the session
+//	<prefix>-users                  set of user IDs with sessions
+//	<prefix>-user:<id>:sessions     set of the user's session IDs
+//	<prefix>-user:<id>:refresh      set of the user's refresh token families
+//	<prefix>-refresh:family:<id>    a refresh token family
+//	<prefix>-refresh:token:<hash>   the family of a current refresh token
+//	<prefix>-refresh:used:<hash>    the family of a rotated refresh token
+//
+// Entries of expired sessions are pruned when the index is read.
+//
+// In a Redis Cluster the keys spread over all shards. Transactions cannot
+// span hash slots there, so writes touching several keys are only
+// pipelined: a write cut short can leave index entries of missing
+// sessions, which are pruned like those of expired ones.
+type RedisStore struct {
+	client  redis.UniversalClient
+	prefix  string
+	cluster bool
+}
+
+// NewRedisStore keeps sessions under prefix in client. Closing the store
+// closes the client.
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	_, cluster := client.(*redis.ClusterClient)
+	return &RedisStore{client: client, prefix: prefix, cluster: cluster}
+}
+
+// txPipeline queues a write of several keys: a transaction, or in a
+// cluster a plain pipeline
+func (s *RedisStore) txPipeline() redis.Pipeliner {
+	if s.cluster {
+		return s.client.Pipeline()
+	}
+	return s.client.TxPipeline()
+}
+
+// mget reads keys with MGET, or in a cluster, where MGET cannot span hash
+// slots, with a pipeline of GETs. Missing keys read as nil.
+func (s *RedisStore) mget(ctx context.Context, keys ...string) ([]any, error) {
+	if !s.cluster {
+		return s.client.MGet(ctx, keys...).Result()
+	}
+
+	pipe := s.client.Pipeline()
+	gets := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		gets[i] = pipe.Get(ctx, key)
+	}
+	// Errors are checked per command, where missing keys are told apart
+	pipe.Exec(ctx)
+
+	values := make([]any, len(keys))
+	for i, get := range gets {
+		switch err := get.Err(); err {
+		case nil:
+			values[i] = get.Val()
+		case redis.Nil:
+		default:
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (s *RedisStore) sessionKey(sessionID string) string {
+	return fmt.Sprintf("%s:%s", s.prefix, sessionID)
+}
+
+func (s *RedisStore) usersKey() string {
+	return fmt.Sprintf("%s-users", s.prefix)
+}
+
+func (s *RedisStore) userSessionsKey(userID uint) string {
+	return fmt.Sprintf("%s-user:%d:sessions", s.prefix, userID)
+}
+
+func (s *RedisStore) userRefreshKey(userID uint) string {
+	return fmt.Sprintf("%s-user:%d:refresh", s.prefix, userID)
+}
+
+// Refresh keys live outside "<prefix>:*" so they are never listed as sessions
+func (s *RedisStore) refreshKey(kind, id string) string {
+	return fmt.Sprintf("%s-refresh:%s:%s", s.prefix, kind, id)
+}
+
+func (s *RedisStore) Create(ctx context.Context, sessionID string, userID uint, data string, ttl time.Duration) error {
+	return s.Update(ctx, sessionID, userID, data, ttl)
+}
+
+func (s *RedisStore) Get(ctx context.Context, sessionID string) (string, error) {
+	data, err := s.client.Get(ctx, s.sessionKey(sessionID)).Result()
+	if err == redis.Nil {
+		return "", ErrSessionNotFound
+	}
+	return data, err
+}
+
+func (s *RedisStore) Update(ctx context.Context, sessionID string, userID uint, data string, ttl time.Duration) error {
+	pipe := s.txPipeline()
+	s.queueSession(ctx, pipe, sessionID, userID, data, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// queueSession queues writing a session and indexing it under its user.
+// The user's set lives as long as the longest-lived of their sessions.
+func (s *RedisStore) queueSession(ctx context.Context, pipe redis.Pipeliner, sessionID string, userID uint, data string, ttl time.Duration) {
+	pipe.Set(ctx, s.sessionKey(sessionID), data, ttl)
+
+	userKey := s.userSessionsKey(userID)
+	pipe.SAdd(ctx, userKey, sessionID)
+	pipe.ExpireNX(ctx, userKey, ttl)
+	pipe.ExpireGT(ctx, userKey, ttl)
+	pipe.SAdd(ctx, s.usersKey(), userID)
+}
+
+func (s *RedisStore) Delete(ctx context.Context, sessionID string, userID uint) error {
+	pipe := s.txPipeline()
+	pipe.Del(ctx, s.sessionKey(sessionID))
+	pipe.SRem(ctx, s.userSessionsKey(userID), sessionID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisStore) ListByUser(ctx context.Context, userID uint) (map[string]string, []string, error) {
+	listings, err := s.listSessions(ctx, []uint{userID})
+	if err != nil {
+		return nil, nil, err
+	}
+	return listings[0].sessions, listings[0].expired, nil
+}
+
+// walkBatchSize is how many users Walk reads from the index at a time
+const walkBatchSize = 100
+
+// Walk scans the users set with SSCAN, so a large index never blocks
+// Redis, and fetches each batch of users' sessions in two round trips
+func (s *RedisStore) Walk(ctx context.Context, fn func(userID uint, sessions map[string]string, expired []string) error) error {
+	// SSCAN may return a member more than once
+	seen := make(map[uint]struct{})
+	batch := make([]uint, 0, walkBatchSize)
+	flush := func() error {
+		listings, err := s.listSessions(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for i, userID := range batch {
+			if err := fn(userID, listings[i].sessions, listings[i].expired); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	iter := s.client.SScan(ctx, s.usersKey(), 0, "", walkBatchSize).Iterator()
+	for iter.Next(ctx) {
+		userID, err := strconv.ParseUint(iter.Val(), 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, ok := seen[uint(userID)]; ok {
+			continue
+		}
+		seen[uint(userID)] = struct{}{}
+
+		batch = append(batch, uint(userID))
+		if len(batch) == walkBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan session users: %w", err)
+	}
+	if len(batch) > 0 {
+		return flush()
+	}
+	return nil
+}
+
+// userListing is what listSessions found for one user
+type userListing struct {
+	sessions map[string]string
+	expired  []string
+}
+
+// listSessions returns the live sessions of each user, reading the users'
+// index sets in one pipeline and their sessions with one MGET. Index
+// entries of expired sessions, and users left without sessions, are pruned.
+func (s *RedisStore) listSessions(ctx context.Context, userIDs []uint) ([]userListing, error) {
+	pipe := s.client.Pipeline()
+	members := make([]*redis.StringSliceCmd, len(userIDs))
+	for i, userID := range userIDs {
+		members[i] = pipe.SMembers(ctx, s.userSessionsKey(userID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get user sessions: %w", err)
+	}
+
+	var keys []string
+	for _, cmd := range members {
+		for _, sessionID := range cmd.Val() {
+			keys = append(keys, s.sessionKey(sessionID))
+		}
+	}
+	var values []any
+	if len(keys) > 0 {
+		var err error
+		if values, err = s.mget(ctx, keys...); err != nil {
+			return nil, fmt.Errorf("failed to get sessions: %w", err)
+		}
+	}
+
+	listings := make([]userListing, len(userIDs))
+	prune := s.client.Pipeline()
+	next := 0
+	for i, userID := range userIDs {
+		sessionIDs := members[i].Val()
+		listing := &listings[i]
+		listing.sessions = make(map[string]string, len(sessionIDs))
+		if len(sessionIDs) == 0 {
+			// Drop the user from the index once all sessions are gone
+			prune.SRem(ctx, s.usersKey(), userID)
+			continue
+		}
+
+		var expired []any
+		for _, sessionID := range sessionIDs {
+			data, ok := values[next].(string)
+			next++
+			if !ok {
+				expired = append(expired, sessionID)
+				listing.expired = append(listing.expired, sessionID)
+				continue
+			}
+			listing.sessions[sessionID] = data
+		}
+		if len(expired) > 0 {
+			prune.SRem(ctx, s.userSessionsKey(userID), expired...)
+		}
+	}
+
+	if prune.Len() > 0 {
+		if _, err := prune.Exec(ctx); err != nil {
+			return nil, fmt.Errorf("failed to prune session index: %w", err)
+		}
+	}
+	return listings, nil
+}
+
+// deleteByUserScript deletes the sessions and refresh token families listed
+// in a user's index sets, then the sets, and drops the user from the users
+// set. Current tokens of the families are left to expire; without their
+// family they are rejected.
+//
+// KEYS: user sessions set, user refresh set, users set
+// ARGV: session key prefix, family key prefix, user ID
+var deleteByUserScript = redis.NewScript(`
+for _, id in ipairs(redis.call('SMEMBERS', KEYS[1])) do
+	redis.call('DEL', ARGV[1] .. id)
+end
+for _, id in ipairs(redis.call('SMEMBERS', KEYS[2])) do
+	redis.call('DEL', ARGV[2] .. id)
+end
+redis.call('DEL', KEYS[1], KEYS[2])
+redis.call('SREM', KEYS[3], ARGV[3])
+return 1
+`)
+
+func (s *RedisStore) DeleteByUser(ctx context.Context, userID uint) error {
+	if s.cluster {
+		return s.deleteByUserKeys(ctx, userID)
+	}
+	keys := []string{s.userSessionsKey(userID), s.userRefreshKey(userID), s.usersKey()}
+	return deleteByUserScript.Run(ctx, s.client, keys, s.sessionKey(""), s.refreshKey("family", ""), userID).Err()
+}
+
+// deleteByUserKeys is deleteByUserScript for a cluster, where a script
+// cannot reach keys in other hash slots. Sessions started while it runs
+// may survive.
+func (s *RedisStore) deleteByUserKeys(ctx context.Context, userID uint) error {
+	sessionsKey, familiesKey := s.userSessionsKey(userID), s.userRefreshKey(userID)
+
+	read := s.client.Pipeline()
+	sessionIDs := read.SMembers(ctx, sessionsKey)
+	familyIDs := read.SMembers(ctx, familiesKey)
+	if _, err := read.Exec(ctx); err != nil {
+		return err
+	}
+
+	pipe := s.client.Pipeline()
+	for _, sessionID := range sessionIDs.Val() {
+		pipe.Del(ctx, s.sessionKey(sessionID))
+	}
+	for _, familyID := range familyIDs.Val() {
+		pipe.Del(ctx, s.refreshKey("family", familyID))
+	}
+	pipe.Del(ctx, sessionsKey)
+	pipe.Del(ctx, familiesKey)
+	pipe.SRem(ctx, s.usersKey(), userID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisStore) CreateFamily(ctx context.Context, familyID string, userID uint, tokenHash, data string, ttl time.Duration) error {
+	userKey := s.userRefreshKey(userID)
+
+	pipe := s.txPipeline()
+	pipe.Set(ctx, s.refreshKey("family", familyID), data, ttl)
+	pipe.Set(ctx, s.refreshKey("token", tokenHash), familyID, ttl)
+	pipe.SAdd(ctx, userKey, familyID)
+	pipe.Expire(ctx, userKey, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisStore) GetFamily(ctx context.Context, familyID string) (string, time.Duration, error) {
+	familyKey := s.refreshKey("family", familyID)
+
+	pipe := s.client.Pipeline()
+	get := pipe.Get(ctx, familyKey)
+	ttl := pipe.PTTL(ctx, familyKey)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return "", 0, err
+	}
+	if get.Err() == redis.Nil {
+		return "", 0, ErrInvalidRefreshToken
+	}
+	return get.Val(), ttl.Val(), nil
+}
+
+func (s *RedisStore) TakeToken(ctx context.Context, tokenHash string) (string, error) {
+	familyID, err := s.client.GetDel(ctx, s.refreshKey("token", tokenHash)).Result()
+	if err == redis.Nil {
+		return "", ErrInvalidRefreshToken
+	}
+	return familyID, err
+}
+
+func (s *RedisStore) UsedToken(ctx context.Context, tokenHash string) (string, error) {
+	familyID, err := s.client.Get(ctx, s.refreshKey("used", tokenHash)).Result()
+	if err == redis.Nil {
+		return "", ErrInvalidRefreshToken
+	}
+	return familyID, err
+}
+
+func (s *RedisStore) RotateFamily(ctx context.Context, rotation Rotation) error {
+	pipe := s.txPipeline()
+	pipe.Del(ctx, s.sessionKey(rotation.OldSessionID))
+	pipe.SRem(ctx, s.userSessionsKey(rotation.UserID), rotation.OldSessionID)
+	s.queueSession(ctx, pipe, rotation.SessionID, rotation.UserID, rotation.SessionData, rotation.SessionTTL)
+	s.queueFamilyRotation(ctx, pipe, rotation)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// queueFamilyRotation queues moving the family to its new token
+func (s *RedisStore) queueFamilyRotation(ctx context.Context, pipe redis.Pipeliner, rotation Rotation) {
+	pipe.SetArgs(ctx, s.refreshKey("family", rotation.FamilyID), rotation.FamilyData, redis.SetArgs{KeepTTL: true})
+	pipe.Set(ctx, s.refreshKey("token", rotation.TokenHash), rotation.FamilyID, rotation.FamilyTTL)
+	// Remember the rotated token until the family expires to detect reuse
+	pipe.Set(ctx, s.refreshKey("used", rotation.UsedTokenHash), rotation.FamilyID, rotation.FamilyTTL)
+}
+
+func (s *RedisStore) DeleteFamily(ctx context.Context, familyID string, userID uint, tokenHash, sessionID string) error {
+	pipe := s.txPipeline()
+	s.queueDeleteFamily(ctx, pipe, familyID, userID, tokenHash)
+	pipe.Del(ctx, s.sessionKey(sessionID))
+	pipe.SRem(ctx, s.userSessionsKey(userID), sessionID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// queueDeleteFamily queues deleting the family with its current token
+func (s *RedisStore) queueDeleteFamily(ctx context.Context, pipe redis.Pipeliner, familyID string, userID uint, tokenHash string) {
+	pipe.Del(ctx, s.refreshKey("family", familyID))
+	pipe.Del(ctx, s.refreshKey("token", tokenHash))
+	pipe.SRem(ctx, s.userRefreshKey(userID), familyID)
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
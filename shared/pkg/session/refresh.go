@@ -0,0 +1,227 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown or expired refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token
+	// is presented again. Either the client or an attacker holds a stolen
+	// copy, so the whole token family and its session have been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+const defaultRefreshTTL = 30 * 24 * time.Hour
+
+// refreshFamily links the refresh tokens descending from one login to the
+// session they currently back. Only the latest token of a family is valid.
+type refreshFamily struct {
+	SessionID string      `json:"session_id"`
+	TokenHash string      `json:"token_hash"`
+	Session   UserSession `json:"session"`
+}
+
+// RefreshedSession is the result of rotating a refresh token
+type RefreshedSession struct {
+	SessionID    string
+	RefreshToken string
+	Session      *UserSession
+}
+
+// hashRefreshToken keeps raw tokens out of the store
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueRefreshToken starts a refresh token family for a newly created
+// session. The family expires RefreshTTL after login however often it is
+// rotated, so users have to sign in again at least that often. Stores that
+// name sessions themselves require StartSession instead.
+func (sm *SessionManager) IssueRefreshToken(ctx context.Context, sessionID string, userSession *UserSession) (string, error) {
+	if _, ok := sm.store.(TokenStore); ok {
+		return "", errTokenStore
+	}
+	familyID, err := utils.GenerateSessionID()
+	if err != nil {
+		return "", err
+	}
+	refreshToken, err := utils.GenerateSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	userSession.RefreshFamily = familyID
+	family := refreshFamily{
+		SessionID: sessionID,
+		TokenHash: hashRefreshToken(refreshToken),
+		Session:   *userSession,
+	}
+	familyData, err := sm.encode(family)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal refresh token family: %w", err)
+	}
+	sessionData, err := sm.encode(userSession)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal user session: %w", err)
+	}
+
+	if err := sm.store.Update(ctx, sessionID, userSession.UserID, sessionData, sm.sessionTTL(userSession)); err != nil {
+		return "", fmt.Errorf("failed to issue refresh token: %w", err)
+	}
+	if err := sm.store.CreateFamily(ctx, familyID, userSession.UserID, family.TokenHash, familyData, sm.refreshTTL); err != nil {
+		return "", fmt.Errorf("failed to issue refresh token: %w", err)
+	}
+	return refreshToken, nil
+}
+
+// RotateRefreshToken exchanges refreshToken for a new session and a new
+// refresh token of the same family; the old session ends. Presenting a
+// token that was already rotated revokes the family and returns
+// ErrRefreshTokenReused.
+func (sm *SessionManager) RotateRefreshToken(ctx context.Context, refreshToken string) (*RefreshedSession, error) {
+	tokenHash := hashRefreshToken(refreshToken)
+	familyID, err := sm.store.TakeToken(ctx, tokenHash)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return nil, sm.checkRefreshReuse(ctx, tokenHash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	family, remaining, err := sm.getRefreshFamily(ctx, familyID)
+	if err != nil {
+		return nil, err
+	}
+	if remaining <= 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	newToken, err := utils.GenerateSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	sessionTTL := sm.sessionTTL(&family.Session)
+	if sessionTTL <= 0 {
+		// A remember-me login past its lifetime cannot be refreshed
+		if _, err := sm.revokeRefreshFamily(ctx, familyID); err != nil {
+			return nil, err
+		}
+		runHooks(ctx, sm.hooks.expire, Event{SessionID: family.SessionID, UserID: family.Session.UserID, Session: &family.Session})
+		return nil, ErrInvalidRefreshToken
+	}
+
+	family.Session.LastSeen = time.Now()
+	sessionData, err := sm.encode(family.Session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal user session: %w", err)
+	}
+	sessionID, err := sm.newSessionID(ctx, family.Session.UserID, sessionData, sessionTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	oldSessionID := family.SessionID
+	family.SessionID = sessionID
+	family.TokenHash = hashRefreshToken(newToken)
+	familyData, err := sm.encode(family)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal refresh token family: %w", err)
+	}
+
+	err = sm.store.RotateFamily(ctx, Rotation{
+		FamilyID:      familyID,
+		UserID:        family.Session.UserID,
+		FamilyData:    familyData,
+		FamilyTTL:     remaining,
+		UsedTokenHash: tokenHash,
+		TokenHash:     family.TokenHash,
+		OldSessionID:  oldSessionID,
+		SessionID:     sessionID,
+		SessionData:   sessionData,
+		SessionTTL:    sessionTTL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	return &RefreshedSession{
+		SessionID:    sessionID,
+		RefreshToken: newToken,
+		Session:      &family.Session,
+	}, nil
+}
+
+// checkRefreshReuse tells an unknown refresh token from a rotated one,
+// revoking the family of the latter
+func (sm *SessionManager) checkRefreshReuse(ctx context.Context, tokenHash string) error {
+	familyID, err := sm.store.UsedToken(ctx, tokenHash)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	if err := sm.RevokeRefreshFamily(ctx, familyID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// getRefreshFamily returns the family with the time it has left
+func (sm *SessionManager) getRefreshFamily(ctx context.Context, familyID string) (*refreshFamily, time.Duration, error) {
+	data, remaining, err := sm.store.GetFamily(ctx, familyID)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return nil, 0, err
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get refresh token family: %w", err)
+	}
+
+	var family refreshFamily
+	if err := sm.decode(data, &family); err != nil {
+		return nil, 0, fmt.Errorf("failed to unmarshal refresh token family: %w", err)
+	}
+	return &family, remaining, nil
+}
+
+// RevokeRefreshFamily invalidates the current refresh token of the family
+// and ends the session it backs
+func (sm *SessionManager) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	family, err := sm.revokeRefreshFamily(ctx, familyID)
+	if err != nil {
+		return err
+	}
+	if family != nil {
+		runHooks(ctx, sm.hooks.delete, Event{SessionID: family.SessionID, UserID: family.Session.UserID, Session: &family.Session})
+	}
+	return nil
+}
+
+// revokeRefreshFamily revokes the family without running hooks. It returns
+// the revoked family, nil if there was none.
+func (sm *SessionManager) revokeRefreshFamily(ctx context.Context, familyID string) (*refreshFamily, error) {
+	family, _, err := sm.getRefreshFamily(ctx, familyID)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sm.store.DeleteFamily(ctx, familyID, family.Session.UserID, family.TokenHash, family.SessionID); err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+	return family, nil
+}
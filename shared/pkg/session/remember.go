@@ -0,0 +1,70 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	defaultRememberTTL     = 30 * 24 * time.Hour
+	defaultRememberIdleTTL = 7 * 24 * time.Hour
+)
+
+// ErrSessionExpired is returned when a remember-me session has outlived
+// its absolute lifetime; the session is deleted
+var ErrSessionExpired = errors.New("session expired")
+
+// sessionTTL is how long a session written now stays valid without use:
+// the session TTL, or for remember-me sessions the idle TTL capped by the
+// remaining absolute lifetime. It is not positive once that has passed.
+func (sm *SessionManager) sessionTTL(userSession *UserSession) time.Duration {
+	if !userSession.RememberMe {
+		return sm.ttl
+	}
+	if userSession.CreatedAt.IsZero() {
+		return sm.rememberIdleTTL
+	}
+	return min(sm.rememberIdleTTL, time.Until(userSession.CreatedAt.Add(sm.rememberTTL)))
+}
+
+// Lifetime is the longest a session can last, for cookie expiry
+func (sm *SessionManager) Lifetime(userSession *UserSession) time.Duration {
+	if userSession.RememberMe {
+		return sm.rememberTTL
+	}
+	return sm.ttl
+}
+
+// DeleteRememberedSessions ends the remember-me sessions of the user, or of
+// every user when userID is 0, leaving normal sessions alone. It returns
+// the number of sessions ended.
+func (sm *SessionManager) DeleteRememberedSessions(ctx context.Context, userID uint) (int, error) {
+	deleted := 0
+	deleteRemembered := func(sessionID string, userSession *UserSession) error {
+		if !userSession.RememberMe {
+			return nil
+		}
+		if err := sm.DeleteSession(ctx, sessionID); err != nil {
+			return err
+		}
+		deleted++
+		return nil
+	}
+
+	if userID == 0 {
+		err := sm.walkSessions(ctx, deleteRemembered)
+		return deleted, err
+	}
+
+	userSessions, err := sm.GetUserSessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	for sessionID, userSession := range userSessions {
+		if err := deleteRemembered(sessionID, userSession); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
@@ -2,17 +2,40 @@ package session
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+	"github.com/dhekaag/golang-microservices/shared/pkg/tracing"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
 	"github.com/redis/go-redis/v9"
 )
 
+// defaultLastSeenInterval spares most session reads a write, while
+// last-seen times stay accurate to the minute
+const defaultLastSeenInterval = time.Minute
+
 type SessionManager struct {
-	redisClient *redis.Client
-	prefix      string
-	ttl         time.Duration
+	store      Store
+	ttl        time.Duration
+	refreshTTL time.Duration
+	// Remember-me sessions expire after rememberIdleTTL without use and
+	// rememberTTL after login at the latest
+	rememberTTL     time.Duration
+	rememberIdleTTL time.Duration
+	// maxSessions caps the active sessions of a user, enforced on login
+	// according to limitPolicy; 0 is no cap
+	maxSessions int
+	limitPolicy string
+	// lastSeenInterval is how old the last-seen time of a session gets
+	// before a read writes it anew
+	lastSeenInterval time.Duration
+	// cipher encrypts stored values; nil stores them as plain JSON
+	cipher *sessionCipher
+	hooks  hooks
+	// sharedClient leaves closing the store's Redis client to its owner
+	sharedClient bool
 }
 
 type UserSession struct {
@@ -20,76 +43,328 @@ type UserSession struct {
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
 	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
 	LastSeen  time.Time `json:"last_seen"`
 	IPAddress string    `json:"ip_address"`
 	UserAgent string    `json:"user_agent"`
+	// DeviceName describes the client, e.g. "Chrome on macOS"
+	DeviceName string `json:"device_name,omitempty"`
+	// Location is where the session was started, as told by the IP address
+	Location Location `json:"location,omitzero"`
+	// LoginMethod is how the user signed in, e.g. "password" or an OAuth
+	// provider
+	LoginMethod string `json:"login_method,omitempty"`
+	// RefreshFamily identifies the refresh tokens issued with the session
+	RefreshFamily string `json:"refresh_family,omitempty"`
+	// RememberMe marks a long-lived session, see SessionConfig.RememberTTL
+	RememberMe bool `json:"remember_me,omitempty"`
+	// Groups are the user's group memberships as of sign-in
+	Groups []rbac.Membership `json:"groups,omitempty"`
+}
+
+// Location is the approximate place of a client, from its IP address
+type Location struct {
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	City    string `json:"city,omitempty"`
+}
+
+// IsAdmin reports whether the session belongs to an administrator
+func (s *UserSession) IsAdmin() bool {
+	return rbac.IsAdmin(s.Role)
+}
+
+// Can reports whether the session's role grants permission
+func (s *UserSession) Can(permission rbac.Permission) bool {
+	return rbac.HasPermission(s.Role, permission)
+}
+
+// GroupRole returns the user's role in group, given by ID or slug
+func (s *UserSession) GroupRole(group string) (rbac.GroupRole, bool) {
+	membership, ok := rbac.FindMembership(s.Groups, group)
+	return membership.Role, ok
 }
 
 type SessionConfig struct {
-	RedisAddr     string `json:"redis_addr"`
-	RedisPassword string `json:"redis_password"`
-	RedisDB       int    `json:"redis_db"`
+	// Store keeps the sessions. When nil they are kept in Redis under
+	// SessionPrefix, through Client or else a client connected to RedisAddr.
+	Store Store `json:"-"`
+	// Client is a connected client shared with other users of Redis; the
+	// manager leaves closing it to its owner
+	Client        redis.UniversalClient `json:"-"`
+	RedisAddr     string                `json:"redis_addr"`
+	RedisPassword string                `json:"redis_password"`
+	RedisDB       int                   `json:"redis_db"`
+	// SentinelMaster connects through the Sentinels at SentinelAddrs instead
+	// of RedisAddr, following the master named SentinelMaster across
+	// failovers
+	SentinelMaster   string   `json:"sentinel_master"`
+	SentinelAddrs    []string `json:"sentinel_addrs"`
+	SentinelPassword string   `json:"sentinel_password"`
+	// ClusterAddrs connects to a Redis Cluster through these nodes instead
+	// of RedisAddr, see RedisStore
+	ClusterAddrs []string `json:"cluster_addrs"`
+	// MaxRetries is how often a command failing on a network error, or
+	// while a failover is in progress, is retried: 3 times if unset, never
+	// if -1. Retries back off exponentially up to MaxRetryBackoff, 512ms if
+	// unset.
+	MaxRetries      int           `json:"max_retries"`
+	MaxRetryBackoff time.Duration `json:"max_retry_backoff"`
+	// JWTSecret, when Store is nil, keeps sessions in JWTs signed with it
+	// and held by the client instead of in Redis, see JWTStore
+	JWTSecret string `json:"-"`
+
 	SessionTTL    int    `json:"session_ttl"`
 	SessionPrefix string `json:"session_prefix"`
+	// RefreshTTL is the lifetime of refresh tokens in seconds, 30 days if unset
+	RefreshTTL int `json:"refresh_ttl"`
+	// RememberTTL is the absolute lifetime of remember-me sessions in
+	// seconds, 30 days if unset; RememberIdleTTL ends them earlier when
+	// unused, 7 days if unset
+	RememberTTL     int `json:"remember_ttl"`
+	RememberIdleTTL int `json:"remember_idle_ttl"`
+	// EncryptionKeys turns on AES-GCM encryption of stored sessions. Each
+	// entry is "<id>:<base64 key>" with a 16, 24 or 32 byte key. The first
+	// key encrypts and all of them decrypt, so a key is rotated by putting a
+	// new one first and dropping the old one once sessions written with it
+	// have expired.
+	EncryptionKeys []string `json:"encryption_keys"`
+	// MaxSessions caps the active sessions of each user; 0 is no cap.
+	// Signing in at the cap ends the user's oldest session, or with
+	// LimitPolicy LimitReject fails with ErrTooManySessions. Stores that
+	// cannot list sessions, such as JWTStore, are not capped.
+	MaxSessions int    `json:"max_sessions"`
+	LimitPolicy string `json:"limit_policy"`
+	// LastSeenInterval is how often, in seconds, reading a session writes
+	// its last-seen time, renewing its TTL: 60 if unset, every read if
+	// negative
+	LastSeenInterval int `json:"last_seen_interval"`
 }
 
 func NewSessionManager(config SessionConfig) (*SessionManager, error) {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     config.RedisAddr,
-		Password: config.RedisPassword,
-		DB:       config.RedisDB,
-	})
-	// Test the connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	_, err := rdb.Ping(ctx).Result()
+	sessionCipher, err := newSessionCipher(config.EncryptionKeys)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		return nil, err
+	}
+
+	limitPolicy := config.LimitPolicy
+	if limitPolicy == "" {
+		limitPolicy = LimitEvict
+	}
+	if limitPolicy != LimitEvict && limitPolicy != LimitReject {
+		return nil, fmt.Errorf("unsupported session limit policy: %s", limitPolicy)
+	}
+
+	lastSeenInterval := time.Duration(config.LastSeenInterval) * time.Second
+	if config.LastSeenInterval == 0 {
+		lastSeenInterval = defaultLastSeenInterval
+	}
+
+	ttl := time.Duration(config.SessionTTL) * time.Second
+	if ttl > 0 {
+		// Sessions used throughout must not expire between writes
+		lastSeenInterval = min(lastSeenInterval, ttl/2)
+	}
+	refreshTTL := time.Duration(config.RefreshTTL) * time.Second
+	if refreshTTL <= 0 {
+		refreshTTL = defaultRefreshTTL
+	}
+
+	rememberTTL := time.Duration(config.RememberTTL) * time.Second
+	if rememberTTL <= 0 {
+		rememberTTL = defaultRememberTTL
+	}
+	rememberIdleTTL := time.Duration(config.RememberIdleTTL) * time.Second
+	if rememberIdleTTL <= 0 {
+		rememberIdleTTL = defaultRememberIdleTTL
+	}
+
+	store := config.Store
+	sharedClient := store == nil && config.Client != nil
+	if store == nil {
+		rdb := config.Client
+		if rdb == nil {
+			rdb = NewRedisClient(config)
+			rdb.AddHook(tracing.RedisHook())
+			// Test the connection
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := rdb.Ping(ctx).Result(); err != nil {
+				rdb.Close()
+				return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+			}
+		}
+		store = NewRedisStore(rdb, config.SessionPrefix)
+		if config.JWTSecret != "" {
+			jwtStore, err := NewJWTStore(rdb, config.SessionPrefix, config.JWTSecret, max(ttl, rememberTTL))
+			if err != nil {
+				if !sharedClient {
+					rdb.Close()
+				}
+				return nil, err
+			}
+			store = jwtStore
+		}
 	}
 
 	return &SessionManager{
-		redisClient: rdb,
-		prefix:      config.SessionPrefix,
-		ttl:         time.Duration(config.SessionTTL) * time.Second,
+		store:            store,
+		ttl:              ttl,
+		refreshTTL:       refreshTTL,
+		rememberTTL:      rememberTTL,
+		rememberIdleTTL:  rememberIdleTTL,
+		maxSessions:      config.MaxSessions,
+		limitPolicy:      limitPolicy,
+		lastSeenInterval: lastSeenInterval,
+		cipher:           sessionCipher,
+		sharedClient:     sharedClient,
 	}, nil
 }
 
-func (sm *SessionManager) getSessionKey(sessionID string) string {
-	return fmt.Sprintf("%s:%s", sm.prefix, sessionID)
+// NewRedisClient connects to the Redis deployment in config: a master
+// managed by Sentinel, a cluster, or a single server
+func NewRedisClient(config SessionConfig) redis.UniversalClient {
+	switch {
+	case config.SentinelMaster != "":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       config.SentinelMaster,
+			SentinelAddrs:    config.SentinelAddrs,
+			SentinelPassword: config.SentinelPassword,
+			Password:         config.RedisPassword,
+			DB:               config.RedisDB,
+			MaxRetries:       config.MaxRetries,
+			MaxRetryBackoff:  config.MaxRetryBackoff,
+		})
+	case len(config.ClusterAddrs) > 0:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:           config.ClusterAddrs,
+			Password:        config.RedisPassword,
+			MaxRetries:      config.MaxRetries,
+			MaxRetryBackoff: config.MaxRetryBackoff,
+		})
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:            config.RedisAddr,
+			Password:        config.RedisPassword,
+			DB:              config.RedisDB,
+			MaxRetries:      config.MaxRetries,
+			MaxRetryBackoff: config.MaxRetryBackoff,
+		})
+	}
 }
 
+// CreateSession stores a new session under sessionID, within the session
+// limit of the user. Stores that name sessions themselves require
+// StartSession instead.
 func (sm *SessionManager) CreateSession(ctx context.Context, sessionID string, userSession *UserSession) error {
-	sessionKey := sm.getSessionKey(sessionID)
-	data, err := json.Marshal(userSession)
+	if _, ok := sm.store.(TokenStore); ok {
+		return errTokenStore
+	}
+	if err := sm.makeRoom(ctx, userSession.UserID); err != nil {
+		return err
+	}
+	if err := sm.saveSession(ctx, sessionID, userSession, sm.store.Create, "failed to create session"); err != nil {
+		return err
+	}
+	runHooks(ctx, sm.hooks.create, Event{SessionID: sessionID, UserID: userSession.UserID, Session: userSession})
+	return nil
+}
+
+// errTokenStore is returned for writes a TokenStore cannot take
+var errTokenStore = errors.New("session store names sessions itself; start sessions with StartSession")
+
+// StartSession creates a session for a user who just signed in, together
+// with its refresh token, and returns the session ID for the client. The
+// session limit of the user applies, see SessionConfig.MaxSessions.
+func (sm *SessionManager) StartSession(ctx context.Context, userSession *UserSession) (sessionID, refreshToken string, err error) {
+	if err := sm.makeRoom(ctx, userSession.UserID); err != nil {
+		return "", "", err
+	}
+	familyID, err := utils.GenerateSessionID()
 	if err != nil {
-		return fmt.Errorf("failed to marshal user session: %w", err)
+		return "", "", err
 	}
-	err = sm.redisClient.Set(ctx, sessionKey, data, sm.ttl).Err()
+	refreshToken, err = utils.GenerateSessionID()
 	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+		return "", "", err
 	}
-	return nil
+
+	ttl := sm.sessionTTL(userSession)
+	if ttl <= 0 {
+		return "", "", ErrSessionExpired
+	}
+	userSession.RefreshFamily = familyID
+	data, err := sm.encode(userSession)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal user session: %w", err)
+	}
+	sessionID, err = sm.newSessionID(ctx, userSession.UserID, data, ttl)
+	if err != nil {
+		return "", "", err
+	}
+
+	family := refreshFamily{
+		SessionID: sessionID,
+		TokenHash: hashRefreshToken(refreshToken),
+		Session:   *userSession,
+	}
+	familyData, err := sm.encode(family)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal refresh token family: %w", err)
+	}
+
+	if err := sm.store.Create(ctx, sessionID, userSession.UserID, data, ttl); err != nil {
+		return "", "", fmt.Errorf("failed to create session: %w", err)
+	}
+	if err := sm.store.CreateFamily(ctx, familyID, userSession.UserID, family.TokenHash, familyData, sm.refreshTTL); err != nil {
+		return "", "", fmt.Errorf("failed to issue refresh token: %w", err)
+	}
+	runHooks(ctx, sm.hooks.create, Event{SessionID: sessionID, UserID: userSession.UserID, Session: userSession})
+	return sessionID, refreshToken, nil
 }
 
+// newSessionID names a session about to be written with data: a TokenStore
+// seals the data into the ID, other stores get a random one
+func (sm *SessionManager) newSessionID(ctx context.Context, userID uint, data string, ttl time.Duration) (string, error) {
+	tokens, ok := sm.store.(TokenStore)
+	if !ok {
+		return utils.GenerateSessionID()
+	}
+	sessionID, err := tokens.Seal(ctx, userID, data, ttl)
+	if err != nil {
+		return "", fmt.Errorf("failed to seal session: %w", err)
+	}
+	return sessionID, nil
+}
+
+// GetSession returns the session. Its last-seen time, and with it its TTL,
+// is written once it is LastSeenInterval old, so most reads write nothing.
 func (sm *SessionManager) GetSession(ctx context.Context, sessionID string) (*UserSession, error) {
-	sessionKey := sm.getSessionKey(sessionID)
-	data, error := sm.redisClient.Get(ctx, sessionKey).Result()
+	return sm.getSession(ctx, sessionID, false)
+}
 
-	if error != nil {
-		if error == redis.Nil {
-			return nil, fmt.Errorf("session not found")
+// getSession reads the session, writing its last-seen time when due or
+// when touch is set
+func (sm *SessionManager) getSession(ctx context.Context, sessionID string, touch bool) (*UserSession, error) {
+	data, err := sm.store.Get(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return nil, err
 		}
-		return nil, fmt.Errorf("failed to get session: %w", error)
+		return nil, fmt.Errorf("failed to get session: %w", err)
 	}
 
 	var userSession UserSession
 
-	if err := json.Unmarshal([]byte(data), &userSession); err != nil {
+	if err := sm.decode(data, &userSession); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal user session: %w", err)
 	}
 
-	// update last seen time
-	userSession.LastSeen = time.Now()
+	now := time.Now()
+	if !touch && now.Sub(userSession.LastSeen) < sm.lastSeenInterval {
+		return &userSession, nil
+	}
+	userSession.LastSeen = now
 	if err := sm.UpdateSession(ctx, sessionID, &userSession); err != nil {
 		return nil, fmt.Errorf("failed to update last seen time: %w", err)
 	}
@@ -97,99 +372,119 @@ func (sm *SessionManager) GetSession(ctx context.Context, sessionID string) (*Us
 }
 
 func (sm *SessionManager) UpdateSession(ctx context.Context, sessionID string, userSession *UserSession) error {
-	sessionKey := sm.getSessionKey(sessionID)
-	data, err := json.Marshal(userSession)
+	return sm.saveSession(ctx, sessionID, userSession, sm.store.Update, "failed to update session")
+}
+
+// saveSession encodes the session and writes it with write
+func (sm *SessionManager) saveSession(ctx context.Context, sessionID string, userSession *UserSession, write func(ctx context.Context, sessionID string, userID uint, data string, ttl time.Duration) error, failure string) error {
+	data, err := sm.encode(userSession)
 	if err != nil {
 		return fmt.Errorf("failed to marshal user session: %w", err)
 	}
-	err = sm.redisClient.Set(ctx, sessionKey, data, sm.ttl).Err()
-	if err != nil {
-		return fmt.Errorf("failed to update session: %w", err)
+
+	ttl := sm.sessionTTL(userSession)
+	if ttl <= 0 {
+		if _, err := sm.deleteSession(ctx, sessionID); err != nil {
+			return err
+		}
+		runHooks(ctx, sm.hooks.expire, Event{SessionID: sessionID, UserID: userSession.UserID, Session: userSession})
+		return ErrSessionExpired
+	}
+
+	if err := write(ctx, sessionID, userSession.UserID, data, ttl); err != nil {
+		return fmt.Errorf("%s: %w", failure, err)
 	}
 	return nil
 }
 
+// DeleteSession ends the session and revokes its refresh token
 func (sm *SessionManager) DeleteSession(ctx context.Context, sessionID string) error {
-	sessionKey := sm.getSessionKey(sessionID)
-	err := sm.redisClient.Del(ctx, sessionKey).Err()
+	userSession, err := sm.deleteSession(ctx, sessionID)
 	if err != nil {
-		return fmt.Errorf("failed to delete session: %w", err)
+		return err
+	}
+	if userSession != nil {
+		runHooks(ctx, sm.hooks.delete, Event{SessionID: sessionID, UserID: userSession.UserID, Session: userSession})
 	}
 	return nil
 }
 
-func (sm *SessionManager) ExtendSession(ctx context.Context, sessionID string) error {
-	sessionKey := sm.getSessionKey(sessionID)
-	err := sm.redisClient.Expire(ctx, sessionKey, sm.ttl).Err()
+// deleteSession ends the session and revokes its refresh token without
+// running hooks. It returns the deleted session, nil if there was none.
+func (sm *SessionManager) deleteSession(ctx context.Context, sessionID string) (*UserSession, error) {
+	data, err := sm.store.Get(ctx, sessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil, nil
+	}
 	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	var userSession UserSession
+	if err := sm.decode(data, &userSession); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user session: %w", err)
+	}
+	if userSession.RefreshFamily != "" {
+		if _, err := sm.revokeRefreshFamily(ctx, userSession.RefreshFamily); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := sm.store.Delete(ctx, sessionID, userSession.UserID); err != nil {
+		return nil, fmt.Errorf("failed to delete session: %w", err)
+	}
+	return &userSession, nil
+}
+
+func (sm *SessionManager) ExtendSession(ctx context.Context, sessionID string) error {
+	// Rewriting the session renews its TTL, and that of its user index
+	if _, err := sm.getSession(ctx, sessionID, true); err != nil {
 		return fmt.Errorf("failed to extend session: %w", err)
 	}
 	return nil
 }
 
+// GetSessions returns the active sessions of all users, walking the user
+// index in batches instead of scanning the keyspace
 func (sm *SessionManager) GetSessions(ctx context.Context) ([]*UserSession, error) {
 	var sessions []*UserSession
-
-	// Get all keys matching the session prefix
-	keys, err := sm.redisClient.Keys(ctx, fmt.Sprintf("%s:*", sm.prefix)).Result()
+	err := sm.walkSessions(ctx, func(sessionID string, userSession *UserSession) error {
+		sessions = append(sessions, userSession)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get session keys: %w", err)
-	}
-
-	// Retrieve each session
-	for _, key := range keys {
-		data, err := sm.redisClient.Get(ctx, key).Result()
-		if err != nil {
-			if err == redis.Nil {
-				continue
-			}
-			return nil, fmt.Errorf("failed to get session: %w", err)
-		}
-
-		var userSession UserSession
-		if err := json.Unmarshal([]byte(data), &userSession); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal user session: %w", err)
-		}
-		sessions = append(sessions, &userSession)
+		return nil, err
 	}
-
 	return sessions, nil
 }
 
+// DeleteSessions ends every session of the user and revokes all of the
+// user's refresh tokens, including those whose session already expired.
+// It goes by the user's index in one step, so a session created meanwhile
+// is either ended or stays listed.
 func (sm *SessionManager) DeleteSessions(ctx context.Context, userID uint) error {
-	// Get all keys matching the session prefix
-	keys, err := sm.redisClient.Keys(ctx, fmt.Sprintf("%s:*", sm.prefix)).Result()
-	if err != nil {
-		return fmt.Errorf("failed to get session keys: %w", err)
-	}
-
-	for _, key := range keys {
-		data, err := sm.redisClient.Get(ctx, key).Result()
-		if err != nil {
-			if err == redis.Nil {
-				continue
-			}
-			return fmt.Errorf("failed to get session: %w", err)
-		}
-
-		var userSession UserSession
-		if err := json.Unmarshal([]byte(data), &userSession); err != nil {
-			return fmt.Errorf("failed to unmarshal user session: %w", err)
-		}
-
-		if userSession.UserID == userID {
-			if err := sm.redisClient.Del(ctx, key).Err(); err != nil {
-				return fmt.Errorf("failed to delete session: %w", err)
-			}
+	// The store deletes by the index without reading the sessions, so read
+	// them first for the hooks
+	var deleted map[string]*UserSession
+	if len(sm.hooks.delete) > 0 {
+		var err error
+		if deleted, err = sm.GetUserSessions(ctx, userID); err != nil {
+			return err
 		}
 	}
 
+	if err := sm.store.DeleteByUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete user sessions: %w", err)
+	}
+	for sessionID, userSession := range deleted {
+		runHooks(ctx, sm.hooks.delete, Event{SessionID: sessionID, UserID: userID, Session: userSession})
+	}
 	return nil
 }
 
 func (sm *SessionManager) Close() error {
-	if sm.redisClient != nil {
-		return sm.redisClient.Close()
+	if sm.sharedClient {
+		return nil
 	}
-	return nil
+	return sm.store.Close()
 }
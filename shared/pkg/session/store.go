@@ -0,0 +1,78 @@
+package session
+
+import (
+	"context"
+	"time"
+)
+
+// Store keeps sessions, indexed by user, and refresh token families. Values
+// are opaque to the store: SessionManager encodes, and may encrypt, them.
+// Missing or expired sessions are reported as ErrSessionNotFound, and
+// missing refresh tokens and families as ErrInvalidRefreshToken.
+type Store interface {
+	// Create stores a new session of the user for ttl
+	Create(ctx context.Context, sessionID string, userID uint, data string, ttl time.Duration) error
+	// Get returns the stored session
+	Get(ctx context.Context, sessionID string) (string, error)
+	// Update replaces the session and restarts its ttl
+	Update(ctx context.Context, sessionID string, userID uint, data string, ttl time.Duration) error
+	// Delete removes the session of the user; unknown sessions are ignored
+	Delete(ctx context.Context, sessionID string, userID uint) error
+	// ListByUser returns the user's live sessions by session ID, and the
+	// IDs of expired sessions it dropped from the user's index
+	ListByUser(ctx context.Context, userID uint) (sessions map[string]string, expired []string, err error)
+	// Walk calls fn with the live sessions of each user, by session ID, and
+	// the expired ones dropped from the index, stopping at the first error,
+	// which it returns
+	Walk(ctx context.Context, fn func(userID uint, sessions map[string]string, expired []string) error) error
+	// DeleteByUser removes all sessions and refresh token families indexed
+	// under the user in one step, so a session created meanwhile is either
+	// removed or stays indexed
+	DeleteByUser(ctx context.Context, userID uint) error
+
+	// CreateFamily stores a refresh token family with the hash of its
+	// current token for ttl, indexed under the user
+	CreateFamily(ctx context.Context, familyID string, userID uint, tokenHash, data string, ttl time.Duration) error
+	// GetFamily returns a family with the time it has left
+	GetFamily(ctx context.Context, familyID string) (string, time.Duration, error)
+	// TakeToken returns the family of a current token and forgets the
+	// token, so only one caller can rotate it
+	TakeToken(ctx context.Context, tokenHash string) (string, error)
+	// UsedToken returns the family of a token that was rotated
+	UsedToken(ctx context.Context, tokenHash string) (string, error)
+	// RotateFamily moves a family to a new token and session in one step
+	RotateFamily(ctx context.Context, rotation Rotation) error
+	// DeleteFamily removes a family with its current token and session
+	DeleteFamily(ctx context.Context, familyID string, userID uint, tokenHash, sessionID string) error
+
+	Close() error
+}
+
+// TokenStore is a Store that keeps sessions with the client instead of on
+// the server, such as JWTStore. The client presents the token carrying its
+// session as the session ID, so the store names sessions rather than the
+// caller: Seal returns the ID of a session about to be created, and Create
+// and Update have nothing left to write. Sessions are started with
+// SessionManager.StartSession, and changes to a started session are lost.
+type TokenStore interface {
+	Store
+	// Seal returns a token carrying the session for ttl
+	Seal(ctx context.Context, userID uint, data string, ttl time.Duration) (string, error)
+}
+
+// Rotation describes the rotation of a refresh token family: the old
+// session and token give way to new ones, and the old token is remembered
+// as used for as long as the family lives
+type Rotation struct {
+	FamilyID   string
+	UserID     uint
+	FamilyData string
+	// FamilyTTL is the time the family has left, which rotating keeps
+	FamilyTTL     time.Duration
+	UsedTokenHash string
+	TokenHash     string
+	OldSessionID  string
+	SessionID     string
+	SessionData   string
+	SessionTTL    time.Duration
+}
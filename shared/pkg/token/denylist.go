@@ -0,0 +1,74 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxMemoryDenylistEntries triggers a sweep of expired entries
+const maxMemoryDenylistEntries = 100000
+
+// Denylist remembers used refresh tokens by their ID until they expire
+type Denylist interface {
+	// Add lists the token for ttl, reporting false when it already was
+	Add(ctx context.Context, tokenID string, ttl time.Duration) (bool, error)
+}
+
+// RedisDenylist shares used tokens between gateway instances, under
+// <prefix>:<token id>
+type RedisDenylist struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func NewRedisDenylist(client redis.UniversalClient, prefix string) *RedisDenylist {
+	return &RedisDenylist{client: client, prefix: prefix}
+}
+
+func (d *RedisDenylist) Add(ctx context.Context, tokenID string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return true, nil
+	}
+	added, err := d.client.SetNX(ctx, d.prefix+":"+tokenID, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to list used token: %w", err)
+	}
+	return added, nil
+}
+
+// MemoryDenylist keeps used tokens in process memory, so a token used on
+// one instance can still be used once on each of the others
+type MemoryDenylist struct {
+	expiries map[string]time.Time
+	mutex    sync.Mutex
+}
+
+func NewMemoryDenylist() *MemoryDenylist {
+	return &MemoryDenylist{expiries: make(map[string]time.Time)}
+}
+
+func (d *MemoryDenylist) Add(ctx context.Context, tokenID string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return true, nil
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	if expiresAt, ok := d.expiries[tokenID]; ok && now.Before(expiresAt) {
+		return false, nil
+	}
+	if len(d.expiries) >= maxMemoryDenylistEntries {
+		for existing, expiresAt := range d.expiries {
+			if now.After(expiresAt) {
+				delete(d.expiries, existing)
+			}
+		}
+	}
+	d.expiries[tokenID] = now.Add(ttl)
+	return true, nil
+}
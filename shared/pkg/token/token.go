@@ -0,0 +1,175 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+	"github.com/dhekaag/golang-microservices/shared/pkg/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	TypeAccess  = "access"
+	TypeRefresh = "refresh"
+)
+
+var (
+	ErrInvalidToken   = errors.New("invalid token")
+	ErrWrongTokenType = errors.New("wrong token type")
+	// ErrTokenReused is returned when a refresh token is redeemed again
+	ErrTokenReused = errors.New("refresh token already used")
+)
+
+type Config struct {
+	Secret          string
+	Issuer          string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// Denylist remembers redeemed refresh tokens; a MemoryDenylist when nil
+	Denylist Denylist
+}
+
+// Claims identifies the user a token was issued to
+type Claims struct {
+	UserID    uint   `json:"uid"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	TokenType string `json:"typ"`
+	// Groups are the user's group memberships as of sign-in
+	Groups []rbac.Membership `json:"groups,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Manager issues and validates HS256-signed JWTs
+type Manager struct {
+	secret          []byte
+	issuer          string
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	denylist        Denylist
+}
+
+// TokenPair is returned on login and refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func NewManager(config Config) (*Manager, error) {
+	if len(config.Secret) < 32 {
+		return nil, fmt.Errorf("JWT secret must be at least 32 bytes")
+	}
+	if config.AccessTokenTTL <= 0 {
+		config.AccessTokenTTL = 15 * time.Minute
+	}
+	if config.RefreshTokenTTL <= 0 {
+		config.RefreshTokenTTL = 7 * 24 * time.Hour
+	}
+	if config.Denylist == nil {
+		config.Denylist = NewMemoryDenylist()
+	}
+
+	return &Manager{
+		secret:          []byte(config.Secret),
+		issuer:          config.Issuer,
+		accessTokenTTL:  config.AccessTokenTTL,
+		refreshTokenTTL: config.RefreshTokenTTL,
+		denylist:        config.Denylist,
+	}, nil
+}
+
+// IssuePair signs a new access and refresh token for the given identity
+func (m *Manager) IssuePair(claims Claims) (*TokenPair, error) {
+	accessToken, err := m.issue(claims, TypeAccess, m.accessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := m.issue(claims, TypeRefresh, m.refreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(m.accessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (m *Manager) issue(claims Claims, tokenType string, ttl time.Duration) (string, error) {
+	tokenID, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.TokenType = tokenType
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        tokenID,
+		Issuer:    m.issuer,
+		Subject:   strconv.FormatUint(uint64(claims.UserID), 10),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims).SignedString(m.secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign %s token: %w", tokenType, err)
+	}
+	return signed, nil
+}
+
+// Parse verifies the signature, expiry and issuer of a token and checks that
+// it is of the expected type.
+func (m *Manager) Parse(tokenString, tokenType string) (*Claims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if m.issuer != "" {
+		options = append(options, jwt.WithIssuer(m.issuer))
+	}
+
+	var claims Claims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(*jwt.Token) (any, error) {
+		return m.secret, nil
+	}, options...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if claims.TokenType != tokenType {
+		return nil, ErrWrongTokenType
+	}
+	return &claims, nil
+}
+
+// Redeem marks the refresh token of claims used, so a new pair is issued
+// for it only once. It fails with ErrTokenReused when the token was
+// redeemed before, and rejects tokens issued without an ID.
+func (m *Manager) Redeem(ctx context.Context, claims *Claims) error {
+	if claims.TokenType != TypeRefresh {
+		return ErrWrongTokenType
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return ErrInvalidToken
+	}
+
+	added, err := m.denylist.Add(ctx, claims.ID, time.Until(claims.ExpiresAt.Time))
+	if err != nil {
+		return err
+	}
+	if !added {
+		return ErrTokenReused
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package userrpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Server is implemented by user-service to serve the API
+type Server interface {
+	GetUser(ctx context.Context, req *GetUserRequest) (*User, error)
+	ValidateCredentials(ctx context.Context, req *ValidateCredentialsRequest) (*ValidateCredentialsResponse, error)
+	BatchGetUsers(ctx context.Context, req *BatchGetUsersRequest) (*BatchGetUsersResponse, error)
+}
+
+// RegisterServer registers srv as ServiceName on registrar
+func RegisterServer(registrar grpc.ServiceRegistrar, srv Server) {
+	registrar.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "GetUser", Handler: unaryHandler(MethodGetUser, Server.GetUser)},
+		{MethodName: "ValidateCredentials", Handler: unaryHandler(MethodValidateCredentials, Server.ValidateCredentials)},
+		{MethodName: "BatchGetUsers", Handler: unaryHandler(MethodBatchGetUsers, Server.BatchGetUsers)},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// unaryHandler adapts a Server method to grpc, decoding the request and
+// running it through the server's interceptors
+func unaryHandler[Req, Resp any](fullMethod string, call func(Server, context.Context, *Req) (*Resp, error)) grpc.MethodHandler {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		req := new(Req)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(Server), ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(Server), ctx, req.(*Req))
+		}
+		return interceptor(ctx, req, info, handler)
+	}
+}
@@ -0,0 +1,129 @@
+// Package userrpc is the internal gRPC API of user-service: its messages,
+// service registration and a typed client. Messages travel as JSON with the grpcjson codec, so
+// neither side needs generated code.
+package userrpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/grpcjson"
+	"github.com/dhekaag/golang-microservices/shared/pkg/rbac"
+	"google.golang.org/grpc"
+)
+
+// ServiceName is the gRPC service user-service registers
+const ServiceName = "user.v1.UserService"
+
+// Full method names, as used by the gateway's gRPC routes
+const (
+	MethodGetUser             = "/" + ServiceName + "/GetUser"
+	MethodValidateCredentials = "/" + ServiceName + "/ValidateCredentials"
+	MethodBatchGetUsers       = "/" + ServiceName + "/BatchGetUsers"
+)
+
+// MaxBatchSize is the most IDs and public IDs, together, BatchGetUsers
+// accepts in one call
+const MaxBatchSize = 500
+
+// Metadata keys describing the client behind a ValidateCredentials call,
+// recorded in the login history. They mirror the HTTP headers the gateway
+// sends to /auth/login.
+const (
+	MetadataClientIP        = "x-forwarded-for"
+	MetadataClientUserAgent = "x-client-user-agent"
+	MetadataClientCountry   = "x-client-country"
+)
+
+// User is an account as other services see it
+type User struct {
+	ID            uint    `json:"id"`
+	PublicID      string  `json:"public_id"`
+	Name          string  `json:"name"`
+	Email         string  `json:"email"`
+	EmailVerified bool    `json:"email_verified"`
+	Image         *string `json:"image,omitempty"`
+	Role          string  `json:"role"`
+	IsActive      bool    `json:"is_active"`
+	// PasswordResetAt is when the password was last reset by link
+	PasswordResetAt *time.Time `json:"password_reset_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// GetUserRequest looks a user up by exactly one of ID, PublicID and Email
+type GetUserRequest struct {
+	ID       uint   `json:"id,omitempty"`
+	PublicID string `json:"public_id,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+type ValidateCredentialsRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// ValidateCredentialsResponse describes the signed-in account for the
+// caller's session
+type ValidateCredentialsResponse struct {
+	ID     uint              `json:"id"`
+	Name   string            `json:"name"`
+	Email  string            `json:"email"`
+	Role   string            `json:"role"`
+	Groups []rbac.Membership `json:"groups,omitempty"`
+}
+
+// BatchGetUsersRequest names users by ID, public ID or both
+type BatchGetUsersRequest struct {
+	IDs       []uint   `json:"ids,omitempty"`
+	PublicIDs []string `json:"public_ids,omitempty"`
+}
+
+// BatchGetUsersResponse holds the users found, in the order requested with
+// IDs before public IDs and each user once, and the identifiers that do not
+// exist
+type BatchGetUsersResponse struct {
+	Users            []User   `json:"users"`
+	MissingIDs       []uint   `json:"missing_ids,omitempty"`
+	MissingPublicIDs []string `json:"missing_public_ids,omitempty"`
+}
+
+// Client calls user-service over conn. Failed calls return gRPC status
+// errors: NotFound for unknown users, Unauthenticated for wrong
+// credentials, PermissionDenied for deactivated accounts and
+// InvalidArgument for malformed requests.
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{conn: conn}
+}
+
+func (c *Client) GetUser(ctx context.Context, req *GetUserRequest, opts ...grpc.CallOption) (*User, error) {
+	var user User
+	if err := c.invoke(ctx, MethodGetUser, req, &user, opts); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *Client) ValidateCredentials(ctx context.Context, req *ValidateCredentialsRequest, opts ...grpc.CallOption) (*ValidateCredentialsResponse, error) {
+	var response ValidateCredentialsResponse
+	if err := c.invoke(ctx, MethodValidateCredentials, req, &response, opts); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (c *Client) BatchGetUsers(ctx context.Context, req *BatchGetUsersRequest, opts ...grpc.CallOption) (*BatchGetUsersResponse, error) {
+	var response BatchGetUsersResponse
+	if err := c.invoke(ctx, MethodBatchGetUsers, req, &response, opts); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req, reply any, opts []grpc.CallOption) error {
+	return c.conn.Invoke(ctx, method, req, reply, append([]grpc.CallOption{grpcjson.CallOption()}, opts...)...)
+}
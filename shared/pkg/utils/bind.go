@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/dhekaag/golang-microservices/shared/pkg/validation"
+	"github.com/go-playground/validator/v10"
+)
+
+// BindOptions sets how a JSON request body is read. MaxBytes of 0 or less
+// leaves the size to BodyLimit middleware.
+type BindOptions struct {
+	MaxBytes              int64
+	DisallowUnknownFields bool
+}
+
+// DefaultBindOptions are the options of BindJSON and DecodeJSON: bodies of
+// up to 1 MiB, with unknown fields ignored as before
+var DefaultBindOptions = BindOptions{MaxBytes: 1 << 20}
+
+// BindJSON decodes the JSON body of r into a T and validates it with
+// validate, see BindJSONWith
+func BindJSON[T any](r *http.Request, validate *validator.Validate) (T, *errors.AppError) {
+	return BindJSONWith[T](r, validate, DefaultBindOptions)
+}
+
+// BindJSONWith decodes the JSON body of r into a T and validates it with
+// validate. The error is ready for errors.WriteErrorResponse: 415 for a
+// Content-Type other than JSON, 413 for a body over MaxBytes, 400 for a
+// body that is not JSON, and a validation error naming the fields for
+// values of the wrong type, unknown fields and failed checks.
+func BindJSONWith[T any](r *http.Request, validate *validator.Validate, options BindOptions) (T, *errors.AppError) {
+	var value T
+	if appErr := decodeJSON(r, &value, options); appErr != nil {
+		return value, appErr
+	}
+	if err := validate.Struct(&value); err != nil {
+		return value, errors.NewValidationError("Validation failed", validation.Errors(err))
+	}
+	return value, nil
+}
+
+// DecodeJSON decodes the JSON body of r into value without validating it,
+// for handlers that adjust the request first. Errors are those of
+// BindJSONWith.
+func DecodeJSON(r *http.Request, value any) *errors.AppError {
+	return decodeJSON(r, value, DefaultBindOptions)
+}
+
+func decodeJSON(r *http.Request, value any, options BindOptions) *errors.AppError {
+	// Clients sending no Content-Type are trusted to send JSON
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+			return errors.NewUnsupportedMediaTypeError("Content-Type must be application/json", err)
+		}
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return errors.NewBadRequestError("Request body is required", nil)
+	}
+
+	body := r.Body
+	if options.MaxBytes > 0 {
+		body = http.MaxBytesReader(nil, r.Body, options.MaxBytes)
+	}
+	decoder := json.NewDecoder(body)
+	if options.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(value); err != nil {
+		return decodeError(err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.NewBadRequestError("Request body must be a single JSON value", err)
+	}
+	return nil
+}
+
+// decodeError describes why a body could not be decoded, naming the field
+// where the JSON is valid but does not fit the request
+func decodeError(err error) *errors.AppError {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case stderrors.Is(err, io.EOF):
+		return errors.NewBadRequestError("Request body is required", err)
+	case stderrors.Is(err, io.ErrUnexpectedEOF):
+		return errors.NewBadRequestError("Request body is not valid JSON: it ends too soon", err)
+	case stderrors.As(err, &syntaxError):
+		return errors.NewBadRequestError(fmt.Sprintf("Request body is not valid JSON at byte %d", syntaxError.Offset), err)
+	case stderrors.As(err, &maxBytesError):
+		return errors.NewPayloadTooLargeError(fmt.Sprintf("Request body exceeds %d bytes", maxBytesError.Limit), err)
+	case stderrors.As(err, &typeError) && typeError.Field != "":
+		return errors.NewValidationError("Validation failed", errors.ValidationErrors{{
+			Field:   typeError.Field,
+			Message: fmt.Sprintf("%s must be %s", typeError.Field, jsonType(typeError.Type)),
+		}})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for unknown fields
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return errors.NewValidationError("Validation failed", errors.ValidationErrors{{
+			Field:   field,
+			Message: field + " is not a known field",
+		}})
+	default:
+		return errors.NewBadRequestError("Request body does not match the request", err)
+	}
+}
+
+// jsonType names a Go type the way a client writing JSON knows it
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
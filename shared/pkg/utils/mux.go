@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"net/http"
+)
+
+// ServeMux is an http.ServeMux whose 404 and 405 responses use the standard
+// JSON error body. Register routes with method and wildcard patterns, e.g.
+// "GET /users/{id}", and read wildcards with r.PathValue.
+type ServeMux struct {
+	*http.ServeMux
+}
+
+func NewServeMux() *ServeMux {
+	return &ServeMux{ServeMux: http.NewServeMux()}
+}
+
+func (m *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := m.Handler(r); pattern != "" {
+		m.ServeMux.ServeHTTP(w, r)
+		return
+	}
+
+	// No route matched: let the mux pick 404 or 405 (and set Allow)
+	recorder := &statusRecorder{header: make(http.Header)}
+	m.ServeMux.ServeHTTP(recorder, r)
+
+	if allow := recorder.header.Get("Allow"); allow != "" {
+		w.Header().Set("Allow", allow)
+	}
+	if recorder.statusCode == http.StatusMethodNotAllowed {
+		SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	SendError(w, http.StatusNotFound, "Endpoint not found")
+}
+
+// statusRecorder keeps the status and headers of a response, dropping the body
+type statusRecorder struct {
+	header     http.Header
+	statusCode int
+}
+
+func (s *statusRecorder) Header() http.Header {
+	return s.header
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.statusCode == 0 {
+		s.statusCode = code
+	}
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dhekaag/golang-microservices/shared/pkg/errors"
+)
+
+// Sort orders
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// PageLimits bounds the page size of a listing: Default when the request
+// names no limit, and Max, to which larger limits are lowered
+type PageLimits struct {
+	Default int
+	Max     int
+}
+
+// DefaultPageLimits are the page limits of ParsePagination
+var DefaultPageLimits = PageLimits{Default: 10, Max: 100}
+
+// Pagination is the page a listing request asks for. Offset is derived
+// from page when the request pages by number; Page is 0 when it pages by
+// cursor.
+type Pagination struct {
+	Page   int
+	Limit  int
+	Offset int
+	Cursor string
+}
+
+// ParsePagination reads the limit, page, offset and cursor query
+// parameters within DefaultPageLimits
+func ParsePagination(r *http.Request) (Pagination, errors.ValidationErrors) {
+	return DefaultPageLimits.Parse(r)
+}
+
+// Parse reads the limit, page, offset and cursor query parameters. A
+// request pages either by page, by offset or by a cursor from a previous
+// page; combining them is an error, as are values that are not whole
+// numbers in range.
+func (l PageLimits) Parse(r *http.Request) (Pagination, errors.ValidationErrors) {
+	query := r.URL.Query()
+	pagination := Pagination{Limit: l.Default, Cursor: query.Get("cursor")}
+	var validationErrors errors.ValidationErrors
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			validationErrors = append(validationErrors, queryError("limit", "must be a whole number of at least 1", value))
+		} else {
+			pagination.Limit = min(limit, l.Max)
+		}
+	}
+
+	page, offset := query.Get("page"), query.Get("offset")
+	switch {
+	case pagination.Cursor != "" && (page != "" || offset != ""):
+		validationErrors = append(validationErrors, queryError("cursor", "cannot be combined with page or offset", ""))
+	case page != "" && offset != "":
+		validationErrors = append(validationErrors, queryError("page", "cannot be combined with offset", page))
+	case page != "":
+		number, err := strconv.Atoi(page)
+		if err != nil || number < 1 {
+			validationErrors = append(validationErrors, queryError("page", "must be a whole number of at least 1", page))
+		} else {
+			pagination.Offset = (number - 1) * pagination.Limit
+		}
+	case offset != "":
+		number, err := strconv.Atoi(offset)
+		if err != nil || number < 0 {
+			validationErrors = append(validationErrors, queryError("offset", "must be a whole number of at least 0", offset))
+		} else {
+			pagination.Offset = number
+		}
+	}
+
+	if pagination.Cursor == "" {
+		pagination.Page = pagination.Offset/pagination.Limit + 1
+	}
+	return pagination, validationErrors
+}
+
+// Sort is the order a listing request asks for. Field and Order are empty
+// when the request leaves them to the listing's defaults.
+type Sort struct {
+	Field string
+	Order string
+}
+
+// Descending reports whether to sort in descending order, which is
+// byDefault when the request names no order
+func (s Sort) Descending(byDefault bool) bool {
+	switch s.Order {
+	case SortAsc:
+		return false
+	case SortDesc:
+		return true
+	default:
+		return byDefault
+	}
+}
+
+// ParseSort reads the sort query parameter, which must be one of allowed,
+// and the order parameter, asc or desc in any case
+func ParseSort(r *http.Request, allowed ...string) (Sort, errors.ValidationErrors) {
+	query := r.URL.Query()
+	var sort Sort
+	var validationErrors errors.ValidationErrors
+
+	if field := query.Get("sort"); field != "" {
+		if slices.Contains(allowed, field) {
+			sort.Field = field
+		} else {
+			validationErrors = append(validationErrors, queryError("sort", "must be one of "+strings.Join(allowed, ", "), field))
+		}
+	}
+
+	switch order := strings.ToLower(query.Get("order")); order {
+	case "":
+	case SortAsc, SortDesc:
+		sort.Order = order
+	default:
+		validationErrors = append(validationErrors, queryError("order", "must be asc or desc", query.Get("order")))
+	}
+	return sort, validationErrors
+}
+
+func queryError(field, message, value string) errors.ValidationError {
+	return errors.ValidationError{Field: field, Message: field + " " + message, Value: value}
+}
@@ -12,6 +12,11 @@ func SendSuccess(w http.ResponseWriter, statusCode int, message string, data int
 	errors.WriteSuccessResponse(w, statusCode, message, data)
 }
 
+// SendSuccessWithMeta sends a success response with pagination metadata
+func SendSuccessWithMeta(w http.ResponseWriter, statusCode int, message string, data interface{}, meta *errors.Meta) {
+	errors.WriteSuccessResponseWithMeta(w, statusCode, message, data, meta)
+}
+
 // SendError sends an error response
 func SendError(w http.ResponseWriter, statusCode int, message string) {
 	var appErr *errors.AppError
@@ -35,6 +40,10 @@ func SendError(w http.ResponseWriter, statusCode int, message string) {
 		appErr = errors.NewTooManyRequestsError(message, nil)
 	case http.StatusRequestTimeout:
 		appErr = errors.NewRequestTimeoutError(message, nil)
+	case http.StatusRequestEntityTooLarge:
+		appErr = errors.NewPayloadTooLargeError(message, nil)
+	case http.StatusUnsupportedMediaType:
+		appErr = errors.NewUnsupportedMediaTypeError(message, nil)
 	case http.StatusInternalServerError:
 		appErr = errors.NewInternalServerError(message, nil)
 	case http.StatusNotImplemented:
@@ -52,6 +61,13 @@ func SendError(w http.ResponseWriter, statusCode int, message string) {
 	errors.WriteErrorResponse(w, appErr)
 }
 
+// SendAppError sends err with the code, status and Data of its AppError,
+// see errors.FromError. Unlike SendError it keeps business codes such as
+// DUPLICATE_ENTRY and INVALID_CREDENTIALS, so clients can branch on them.
+func SendAppError(w http.ResponseWriter, err error) {
+	errors.WriteErrorResponse(w, errors.FromError(err))
+}
+
 // SendPaginated sends a paginated response
 func SendPaginated(w http.ResponseWriter, message string, data interface{}, page, limit, total int) {
 	errors.WritePaginatedResponse(w, message, data, page, limit, total)
@@ -0,0 +1,118 @@
+// Package validation reports the failures of go-playground/validator as
+// field errors that clients can show next to their inputs
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	appErrors "github.com/dhekaag/golang-microservices/shared/pkg/errors"
+	"github.com/go-playground/validator/v10"
+)
+
+// New returns a validator that names fields by their JSON names, so the
+// errors refer to the fields of the request body rather than of the Go
+// struct
+func New() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(jsonName)
+	return validate
+}
+
+// Errors converts an error of Validate.Struct into one field error per
+// failed field, with a message such as "email must be a valid email
+// address". Values are left out, since they may be passwords. Errors that
+// are not validation failures become a single error without a field.
+func Errors(err error) appErrors.ValidationErrors {
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return appErrors.ValidationErrors{{Message: err.Error()}}
+	}
+
+	result := make(appErrors.ValidationErrors, 0, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		field := fieldPath(fieldError)
+		result = append(result, appErrors.ValidationError{
+			Field:   field,
+			Message: field + " " + message(fieldError),
+		})
+	}
+	return result
+}
+
+// jsonName is the name of a field in its JSON encoding
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	default:
+		return name
+	}
+}
+
+// fieldPath names the field from the top of the validated struct, such as
+// address.city, without the name of the struct itself
+func fieldPath(fieldError validator.FieldError) string {
+	_, path, found := strings.Cut(fieldError.Namespace(), ".")
+	if !found {
+		return fieldError.Field()
+	}
+	return path
+}
+
+// message describes the failed check of a field; checks without a message
+// of their own are named by their tag
+func message(fieldError validator.FieldError) string {
+	param := fieldError.Param()
+	switch fieldError.Tag() {
+	case "required", "required_if", "required_unless", "required_with", "required_without":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "url", "http_url":
+		return "must be a valid URL"
+	case "uuid", "uuid4":
+		return "must be a valid UUID"
+	case "timezone":
+		return "must be a valid time zone, such as Europe/Berlin"
+	case "bcp47_language_tag":
+		return "must be a valid language tag, such as en-US"
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(param), ", ")
+	case "min", "gte":
+		return "must be at least " + bound(fieldError.Kind(), param)
+	case "max", "lte":
+		return "must be at most " + bound(fieldError.Kind(), param)
+	case "len":
+		return "must be exactly " + bound(fieldError.Kind(), param)
+	case "gt":
+		return "must be more than " + bound(fieldError.Kind(), param)
+	case "lt":
+		return "must be less than " + bound(fieldError.Kind(), param)
+	case "eqfield":
+		return "must match " + param
+	default:
+		if param != "" {
+			return fmt.Sprintf("failed the %s=%s check", fieldError.Tag(), param)
+		}
+		return fmt.Sprintf("failed the %s check", fieldError.Tag())
+	}
+}
+
+// bound words the parameter of a size check, which counts characters for
+// strings and items for collections
+func bound(kind reflect.Kind, param string) string {
+	switch kind {
+	case reflect.String:
+		return param + " characters long"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return param + " items"
+	default:
+		return param
+	}
+}